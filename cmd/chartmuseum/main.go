@@ -17,17 +17,30 @@ limitations under the License.
 package main
 
 import (
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/chartmuseum/storage"
 
 	"helm.sh/chartmuseum/pkg/cache"
 	"helm.sh/chartmuseum/pkg/chartmuseum"
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
 	"helm.sh/chartmuseum/pkg/config"
+	cm_storage "helm.sh/chartmuseum/pkg/storage"
+	"helm.sh/chartmuseum/pkg/vault"
 
 	"github.com/urfave/cli"
 )
@@ -35,8 +48,14 @@ import (
 var (
 	crash = log.Fatal
 
+	digitaloceanRegionPattern = regexp.MustCompile(`^[a-z]{3}[0-9]$`)
+
 	newServer = chartmuseum.NewServer
 
+	// vaultClient is the client of the Vault holding storage credentials or the TLS
+	// certificate, logged in on first use
+	vaultClient *vault.Client
+
 	// Version is the semantic version (added at compile time)
 	Version string
 
@@ -71,7 +90,36 @@ func cliHandler(c *cli.Context) {
 
 	conf.ShowDeprecationWarnings(c, logger)
 
-	backend := backendFromConfig(conf)
+	backend := layoutBackendFromConfig(conf, backendFromConfig(conf))
+	if routes := conf.GetStringMapString("storage.route"); len(routes) > 0 {
+		backend = routedBackendFromConfig(conf, routes, backend)
+	}
+	if conf.GetInt("storage.retry.attempts") > 1 || conf.GetDuration("storage.retry.timeout") > 0 {
+		backend = cm_storage.NewRetryBackend(backend, cm_storage.RetryOptions{
+			Attempts:   conf.GetInt("storage.retry.attempts"),
+			Backoff:    conf.GetDuration("storage.retry.backoff"),
+			MaxBackoff: conf.GetDuration("storage.retry.maxbackoff"),
+			Timeout:    conf.GetDuration("storage.retry.timeout"),
+			Logger:     logger,
+		})
+	}
+	if conf.GetInt("storage.circuitbreaker.threshold") > 0 {
+		backend = cm_storage.NewCircuitBreakerBackend(backend, cm_storage.CircuitBreakerOptions{
+			Threshold: conf.GetInt("storage.circuitbreaker.threshold"),
+			Cooldown:  conf.GetDuration("storage.circuitbreaker.cooldown"),
+			Logger:    logger,
+		})
+	}
+	if conf.GetString("storage.replica.url") != "" {
+		backend = replicatedBackendFromConfig(conf, backend, logger)
+	}
+	if conf.GetString("storage.tiered.cachedir") != "" {
+		backend = tieredBackendFromConfig(conf, backend)
+	}
+	// encrypting last, the replica and the cache on local disk only hold ciphertext
+	if conf.GetString("storage.encryption.keyfile") != "" || conf.GetString("storage.encryption.kmskeyid") != "" {
+		backend = encryptedBackendFromConfig(conf, backend)
+	}
 	store := storeFromConfig(conf)
 
 	options := chartmuseum.ServerOptions{
@@ -84,8 +132,23 @@ func cliHandler(c *cli.Context) {
 		TlsCert:                conf.GetString("tls.cert"),
 		TlsKey:                 conf.GetString("tls.key"),
 		TlsCACert:              conf.GetString("tls.cacert"),
+		TlsClientNames:         splitCommaSeparated(conf.GetString("tls.clientnames")),
+		TlsCRLFile:             conf.GetString("tls.crl"),
+		TlsOCSP:                conf.GetBool("tls.ocsp"),
+		IPAllow:                splitCommaSeparated(conf.GetString("ipfilter.allow")),
+		IPDeny:                 splitCommaSeparated(conf.GetString("ipfilter.deny")),
+		IPWriteAllow:           splitCommaSeparated(conf.GetString("ipfilter.writeallow")),
+		IPWriteDeny:            splitCommaSeparated(conf.GetString("ipfilter.writedeny")),
+		IPTrustedProxies:       splitCommaSeparated(conf.GetString("ipfilter.trustedproxies")),
+		RateLimitRead:          conf.GetFloat64("ratelimit.read"),
+		RateLimitReadBurst:     conf.GetInt("ratelimit.readburst"),
+		RateLimitWrite:         conf.GetFloat64("ratelimit.write"),
+		RateLimitWriteBurst:    conf.GetInt("ratelimit.writeburst"),
+		RateLimitBy:            conf.GetString("ratelimit.by"),
+		AuditLog:               splitCommaSeparated(conf.GetString("auditlog")),
 		Username:               conf.GetString("basicauth.user"),
 		Password:               conf.GetString("basicauth.pass"),
+		HtpasswdFile:           conf.GetString("basicauth.htpasswd"),
 		ChartPostFormFieldName: conf.GetString("chartpostformfieldname"),
 		ProvPostFormFieldName:  conf.GetString("provpostformfieldname"),
 		ContextPath:            conf.GetString("contextpath"),
@@ -108,8 +171,36 @@ func cliHandler(c *cli.Context) {
 		AuthService:            conf.GetString("authservice"),
 		AuthCertPath:           conf.GetString("authcertpath"),
 		AuthActionsSearchPath:  conf.GetString("authactionssearchpath"),
+		AuthJWTSecret:          conf.GetString("authjwtsecret"),
+		AuthJWKSURL:            conf.GetString("authjwksurl"),
+		AuthIssuer:             conf.GetString("authissuer"),
+		AuthAudience:           conf.GetString("authaudience"),
+		AuthOIDCIssuerURL:      conf.GetString("authoidcissuerurl"),
+		AuthOIDCClientID:       conf.GetString("authoidcclientid"),
+		AuthOIDCGroupsClaim:    conf.GetString("authoidcgroupsclaim"),
+		AuthOIDCGroups:         conf.GetStringMapString("authoidcgroup"),
+		AuthLDAP:               ldapOptionsFromConfig(conf),
+		AuthRoles:              conf.GetStringMapString("authrole"),
+		AuthTenantsFile:        conf.GetString("authtenantsfile"),
+		AuthTokenEndpoint:      conf.GetBool("authtokenendpoint"),
+		AuthTokenKeyPath:       conf.GetString("authtokenkey"),
+		AuthTokenTTL:           conf.GetDuration("authtokenttl"),
+		AuthPullTokenMaxTTL:    conf.GetDuration("authpulltokenmaxttl"),
+		AuthSession:            conf.GetBool("authsession"),
+		AuthSessionSecret:      conf.GetString("authsessionsecret"),
+		AuthSessionTTL:         conf.GetDuration("authsessionttl"),
+		AuthHMACKeys:           conf.GetStringMapString("authhmackey"),
+		AuthHMACSkew:           conf.GetDuration("authhmacskew"),
+		AuthWebhookURL:         conf.GetString("authwebhookurl"),
+		AuthWebhookTimeout:     conf.GetDuration("authwebhooktimeout"),
+		AuthCacheTTL:           conf.GetDuration("authcachettl"),
+		AuthCacheNegativeTTL:   conf.GetDuration("authcachenegativettl"),
 		DepthDynamic:           conf.GetBool("depthdynamic"),
 		CORSAllowOrigin:        conf.GetString("cors.alloworigin"),
+		CORSAllowMethods:       splitCommaSeparated(conf.GetString("cors.allowmethods")),
+		CORSAllowHeaders:       splitCommaSeparated(conf.GetString("cors.allowheaders")),
+		CORSAllowCredentials:   conf.GetBool("cors.allowcredentials"),
+		CORSMaxAge:             conf.GetDuration("cors.maxage"),
 		WriteTimeout:           conf.GetInt("writetimeout"),
 		ReadTimeout:            conf.GetInt("readtimeout"),
 		EnforceSemver2:         conf.GetBool("enforce-semver2"),
@@ -118,8 +209,39 @@ func cliHandler(c *cli.Context) {
 		PerChartLimit:          conf.GetInt("per-chart-limit"),
 		WebTemplatePath:        conf.GetString("web-template-path"),
 		ArtifactHubRepoID:      conf.GetStringMapString("artifact-hub-repo-id"),
+		ArtifactHubRepoFile:    conf.GetStringMapString("artifact-hub-repo-file"),
 		AlwaysRegenerateIndex:  conf.GetBool("always-regenerate-chart-index"),
 		JSONIndex:              conf.GetBool("json-index"),
+		IndexContentType:       conf.GetString("index-content-type"),
+		JSONIndexContentType:   conf.GetString("json-index-content-type"),
+		ProvenanceKeyring:      conf.GetString("provenance-keyring"),
+		ReconcileInterval:      conf.GetDuration("index-reconcile-interval"),
+		MultiChartUpload:       conf.GetBool("multi-chart-upload"),
+		ReservedPrefixes:       splitCommaSeparated(conf.GetString("reserved-prefixes")),
+		RollbackRetries:        conf.GetInt("upload-rollback-retries"),
+		MaxIndexSize:           conf.GetInt("max-index-size"),
+		TruncateIndex:          conf.GetBool("truncate-index"),
+		RequireProvenance:      conf.GetBool("require-provenance"),
+		MultipartMaxMemory:     int64(conf.GetInt("multipart-max-memory")),
+		HealthDetails:          conf.GetBool("health-details"),
+		CaseInsensitiveNames:   conf.GetBool("case-insensitive-chart-names"),
+		RedirectDownloads:      conf.GetDuration("redirect-downloads"),
+		StorageListPageSize:    conf.GetInt("storage-list-page-size"),
+		DownloadStats:          conf.GetString("download-stats"),
+		DownloadStatsInterval:  conf.GetDuration("download-stats-interval"),
+		LegacyUploadResponse:   conf.GetBool("legacy-upload-response"),
+		MinChartAPIVersion:     conf.GetString("min-chart-api-version"),
+		IndexDebounce:          conf.GetDuration("index-debounce"),
+		IndexMaxStaleness:      conf.GetDuration("index-debounce-max-staleness"),
+		IndexDebounceRepos:     conf.GetStringMapString("index-debounce-repo"),
+		UpstreamURLs:           conf.GetStringMapString("upstream-repo-url"),
+		UpstreamMaxSize:        conf.GetInt("upstream-max-size"),
+		UpstreamAllowedHosts:   splitCommaSeparated(conf.GetString("upstream-allowed-hosts")),
+		UploadURLAllowedHosts:  splitCommaSeparated(conf.GetString("upload-url-allowed-hosts")),
+		StorageBackendName:     storageBackendName(conf),
+	}
+	if vaultPath := conf.GetString("tls.vaultpath"); vaultPath != "" {
+		options.TlsCertFunc = vaultCertificateFromConfig(conf, vaultPath)
 	}
 
 	server, err := newServer(options)
@@ -130,7 +252,74 @@ func cliHandler(c *cli.Context) {
 	server.Listen(conf.GetInt("port"))
 }
 
+// vaultLeaseFromConfig returns the lease of the Vault secret read at path, or written at path
+// with data if set
+func vaultLeaseFromConfig(conf *config.Config, path string, data map[string]interface{}) *vault.Lease {
+	if vaultClient == nil {
+		client, err := vault.NewClient(vault.Options{
+			Address:             conf.GetString("vault.address"),
+			Namespace:           conf.GetString("vault.namespace"),
+			CACertFile:          conf.GetString("vault.cacert"),
+			Auth:                conf.GetString("vault.auth"),
+			AuthMount:           conf.GetString("vault.authmount"),
+			Token:               conf.GetString("vault.token"),
+			RoleID:              conf.GetString("vault.roleid"),
+			SecretID:            conf.GetString("vault.secretid"),
+			Role:                conf.GetString("vault.role"),
+			KubernetesTokenFile: conf.GetString("vault.kubernetestokenfile"),
+		})
+		if err != nil {
+			crash("Invalid Vault configuration: ", err)
+		}
+		vaultClient = client
+	}
+	lease := vaultClient.Lease(path, data)
+	if interval := conf.GetDuration("vault.refreshinterval"); interval > 0 {
+		lease.RefreshInterval = interval
+	}
+	return lease
+}
+
+// vaultCertificateFromConfig serves the TLS certificate of the Vault secret at path, issued
+// for the common name by the PKI secrets engine when set
+func vaultCertificateFromConfig(conf *config.Config, path string) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	var data map[string]interface{}
+	if commonName := conf.GetString("tls.vaultcommonname"); commonName != "" {
+		data = map[string]interface{}{"common_name": commonName}
+	}
+	source, err := vault.NewCertificateSource(vaultLeaseFromConfig(conf, path, data))
+	if err != nil {
+		crash("Invalid Vault TLS certificate: ", err)
+	}
+	return source.GetCertificate
+}
+
+// ldapOptionsFromConfig reads the settings of LDAP auth, under ldap in a config file
+func ldapOptionsFromConfig(conf *config.Config) cm_router.LDAPOptions {
+	return cm_router.LDAPOptions{
+		URL:            conf.GetString("ldap.url"),
+		StartTLS:       conf.GetBool("ldap.starttls"),
+		CACertFile:     conf.GetString("ldap.cacert"),
+		BindDN:         conf.GetString("ldap.binddn"),
+		BindPassword:   conf.GetString("ldap.bindpassword"),
+		UserBaseDN:     conf.GetString("ldap.userbasedn"),
+		UserFilter:     conf.GetString("ldap.userfilter"),
+		GroupBaseDN:    conf.GetString("ldap.groupbasedn"),
+		GroupFilter:    conf.GetString("ldap.groupfilter"),
+		GroupAttribute: conf.GetString("ldap.groupattribute"),
+		Groups:         conf.GetStringMapString("ldap.group"),
+		CacheTTL:       conf.GetDuration("ldap.cachettl"),
+	}
+}
+
 func backendFromConfig(conf *config.Config) storage.Backend {
+	if storageURL := conf.GetString("storage.url"); storageURL != "" {
+		backend, err := cm_storage.Open(storageURL)
+		if err != nil {
+			crash("Unsupported storage URL: ", err)
+		}
+		return backend
+	}
 	crashIfConfigMissingVars(conf, []string{"storage.backend"})
 
 	var backend storage.Backend
@@ -139,10 +328,30 @@ func backendFromConfig(conf *config.Config) storage.Backend {
 	switch storageFlag {
 	case "local":
 		backend = localBackendFromConfig(conf)
+	case "memory":
+		backend = cm_storage.NewMemoryBackend()
 	case "amazon":
 		backend = amazonBackendFromConfig(conf)
 	case "google":
 		backend = googleBackendFromConfig(conf)
+	case "digitalocean":
+		backend = digitaloceanBackendFromConfig(conf)
+	case "minio":
+		backend = minioBackendFromConfig(conf)
+	case "b2":
+		backend = b2BackendFromConfig(conf)
+	case "sftp":
+		backend = sftpBackendFromConfig(conf)
+	case "webdav":
+		backend = webdavBackendFromConfig(conf)
+	case "sql":
+		backend = sqlBackendFromConfig(conf)
+	case "ipfs":
+		backend = ipfsBackendFromConfig(conf)
+	case "rados":
+		backend = radosBackendFromConfig(conf)
+	case "hdfs":
+		backend = hdfsBackendFromConfig(conf)
 	case "oracle":
 		backend = oracleBackendFromConfig(conf)
 	case "microsoft":
@@ -164,10 +373,114 @@ func backendFromConfig(conf *config.Config) storage.Backend {
 	return backend
 }
 
+// storageBackendName is the type of the storage backend, the scheme of the storage URL if any
+func storageBackendName(conf *config.Config) string {
+	if storageURL := conf.GetString("storage.url"); storageURL != "" {
+		if u, err := url.Parse(storageURL); err == nil && u.Scheme != "" {
+			return u.Scheme
+		}
+		return ""
+	}
+	return strings.ToLower(conf.GetString("storage.backend"))
+}
+
+// layoutBackendFromConfig places the files of backend at the keys of the storage layout, backend
+// itself with the default layout
+func layoutBackendFromConfig(conf *config.Config, backend storage.Backend) storage.Backend {
+	if conf.GetString("storage.layout") == "" {
+		return backend
+	}
+	layout, err := cm_storage.ParseKeyLayout(conf.GetString("storage.layout"))
+	if err != nil {
+		crash("Invalid storage layout: ", err)
+	}
+	layoutBackend, err := cm_storage.NewLayoutBackend(backend, layout)
+	if err != nil {
+		crash("Invalid storage layout: ", err)
+	}
+	return layoutBackend
+}
+
+func routedBackendFromConfig(conf *config.Config, routes map[string]string, backend storage.Backend) storage.Backend {
+	prefixes := make([]string, 0, len(routes))
+	for prefix := range routes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes) // deterministic startup errors
+	backends := map[string]storage.Backend{}
+	for _, prefix := range prefixes {
+		rawURL := routes[prefix]
+		if strings.Trim(prefix, "/") == "" {
+			crash("Invalid storage route: missing prefix for ", rawURL)
+		}
+		routeBackend, err := cm_storage.Open(rawURL)
+		if err != nil {
+			crash("Unsupported storage route URL for ", prefix, ": ", err)
+		}
+		backends[prefix] = layoutBackendFromConfig(conf, routeBackend)
+	}
+	return cm_storage.NewRoutedBackend(backend, backends)
+}
+
+func replicatedBackendFromConfig(conf *config.Config, backend storage.Backend, logger *cm_logger.Logger) storage.Backend {
+	secondary, err := cm_storage.Open(conf.GetString("storage.replica.url"))
+	if err != nil {
+		crash("Unsupported storage replica URL: ", err)
+	}
+	return cm_storage.NewReplicatedBackend(backend, secondary, cm_storage.ReplicationOptions{
+		QueueSize:         conf.GetInt("storage.replica.queuesize"),
+		ReconcileInterval: conf.GetDuration("storage.replica.reconcileinterval"),
+		Logger:            logger,
+	})
+}
+
+func tieredBackendFromConfig(conf *config.Config, backend storage.Backend) storage.Backend {
+	tiered, err := cm_storage.NewTieredBackend(
+		backend,
+		conf.GetString("storage.tiered.cachedir"),
+		int64(conf.GetInt("storage.tiered.cachemaxsize")),
+	)
+	if err != nil {
+		crash("Invalid tiered storage: ", err)
+	}
+	return tiered
+}
+
+func encryptedBackendFromConfig(conf *config.Config, backend storage.Backend) storage.Backend {
+	keyFile := conf.GetString("storage.encryption.keyfile")
+	kmsKeyID := conf.GetString("storage.encryption.kmskeyid")
+	if keyFile != "" && kmsKeyID != "" {
+		crash("Invalid storage encryption: --storage-encryption-key-file and --storage-encryption-kms-key-id are exclusive")
+	}
+	var keys cm_storage.KeyWrapper
+	if kmsKeyID != "" {
+		sess := session.Must(session.NewSessionWithOptions(session.Options{
+			Config:            aws.Config{Region: aws.String(conf.GetString("storage.encryption.kmsregion"))},
+			SharedConfigState: session.SharedConfigEnable,
+		}))
+		keys = &cm_storage.KMSKeyWrapper{Client: kms.New(sess), KeyID: kmsKeyID}
+	} else {
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			crash("Invalid storage encryption: ", err)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil {
+			crash("Invalid storage encryption: key file is not base64 encoded: ", err)
+		}
+		keys, err = cm_storage.NewLocalKeyWrapper(key)
+		if err != nil {
+			crash("Invalid storage encryption: ", err)
+		}
+	}
+	return cm_storage.NewEncryptedBackend(backend, keys)
+}
+
 func localBackendFromConfig(conf *config.Config) storage.Backend {
 	crashIfConfigMissingVars(conf, []string{"storage.local.rootdir"})
-	return storage.NewLocalFilesystemBackend(
+	return cm_storage.NewLocalFilesystemBackend(
 		conf.GetString("storage.local.rootdir"),
+		conf.GetBool("storage.local.fsync"),
 	)
 }
 
@@ -178,20 +491,200 @@ func amazonBackendFromConfig(conf *config.Config) storage.Backend {
 	}
 	crashIfConfigMissingVars(conf, []string{"storage.amazon.bucket", "storage.amazon.region"})
 	forcePathStyle := conf.GetBool("storage.amazon.forcepathstyle")
-	return storage.NewAmazonS3BackendWithOptions(
+	s3Backend := storage.NewAmazonS3BackendWithOptions(
 		conf.GetString("storage.amazon.bucket"),
 		conf.GetString("storage.amazon.prefix"),
 		conf.GetString("storage.amazon.region"),
 		conf.GetString("storage.amazon.endpoint"),
+		"",
+		&storage.AmazonS3Options{
+			S3ForcePathStyle: &forcePathStyle,
+		},
+	)
+	if vaultPath := conf.GetString("storage.amazon.vaultpath"); vaultPath != "" {
+		s3Backend.Client.Config.Credentials = cm_storage.NewVaultAmazonCredentials(vaultLeaseFromConfig(conf, vaultPath, nil))
+	}
+	backend, err := cm_storage.NewAmazonS3SSEBackend(
+		s3Backend,
 		conf.GetString("storage.amazon.sse"),
+		conf.GetString("storage.amazon.ssekmskeyid"),
+	)
+	if err != nil {
+		crash("Invalid Amazon S3 storage: ", err)
+	}
+	return backend
+}
+
+// digitaloceanBackendFromConfig presets the S3 backend for Spaces, which is only
+// reachable through the endpoint of its region
+func digitaloceanBackendFromConfig(conf *config.Config) storage.Backend {
+	crashIfConfigMissingVars(conf, []string{"storage.digitalocean.bucket", "storage.digitalocean.region"})
+	region := strings.ToLower(conf.GetString("storage.digitalocean.region"))
+	if !digitaloceanRegionPattern.MatchString(region) {
+		crash("Invalid DigitalOcean Spaces region: ", region)
+	}
+	forcePathStyle := true
+	return storage.NewAmazonS3BackendWithOptions(
+		conf.GetString("storage.digitalocean.bucket"),
+		conf.GetString("storage.digitalocean.prefix"),
+		// Spaces expects the region of the signature to be us-east-1
+		"us-east-1",
+		fmt.Sprintf("https://%s.digitaloceanspaces.com", region),
+		"",
 		&storage.AmazonS3Options{
 			S3ForcePathStyle: &forcePathStyle,
 		},
 	)
 }
 
+// minioBackendFromConfig presets the S3 backend for MinIO, addressing buckets by path and
+// trusting the certificate authority of the server
+func minioBackendFromConfig(conf *config.Config) storage.Backend {
+	crashIfConfigMissingVars(conf, []string{"storage.minio.endpoint", "storage.minio.bucket"})
+	backend, err := cm_storage.NewMinIOBackend(cm_storage.MinIOOptions{
+		Endpoint:           conf.GetString("storage.minio.endpoint"),
+		Bucket:             conf.GetString("storage.minio.bucket"),
+		Prefix:             conf.GetString("storage.minio.prefix"),
+		Region:             conf.GetString("storage.minio.region"),
+		AccessKeyID:        conf.GetString("storage.minio.accesskeyid"),
+		SecretAccessKey:    conf.GetString("storage.minio.secretaccesskey"),
+		CACertFile:         conf.GetString("storage.minio.cacert"),
+		InsecureSkipVerify: conf.GetBool("storage.minio.insecureskipverify"),
+	})
+	if err != nil {
+		crash("Invalid MinIO storage: ", err)
+	}
+	return backend
+}
+
+func b2BackendFromConfig(conf *config.Config) storage.Backend {
+	crashIfConfigMissingVars(conf, []string{"storage.b2.bucketid", "storage.b2.keyid", "storage.b2.applicationkey"})
+	return cm_storage.NewB2Backend(
+		conf.GetString("storage.b2.keyid"),
+		conf.GetString("storage.b2.applicationkey"),
+		conf.GetString("storage.b2.bucketid"),
+		conf.GetString("storage.b2.prefix"),
+	)
+}
+
+func sftpBackendFromConfig(conf *config.Config) storage.Backend {
+	crashIfConfigMissingVars(conf, []string{"storage.sftp.host", "storage.sftp.user"})
+	options := cm_storage.SFTPOptions{
+		User:                  conf.GetString("storage.sftp.user"),
+		Password:              conf.GetString("storage.sftp.password"),
+		KnownHostsFile:        conf.GetString("storage.sftp.knownhostsfile"),
+		InsecureIgnoreHostKey: conf.GetBool("storage.sftp.insecureignorehostkey"),
+	}
+	if keyFile := conf.GetString("storage.sftp.privatekeyfile"); keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			crash("Invalid SFTP private key file: ", err)
+		}
+		options.PrivateKey = key
+	}
+	backend, err := cm_storage.NewSFTPBackend(
+		conf.GetString("storage.sftp.host"),
+		conf.GetString("storage.sftp.basepath"),
+		options,
+	)
+	if err != nil {
+		crash("Invalid SFTP storage: ", err)
+	}
+	return backend
+}
+
+func webdavBackendFromConfig(conf *config.Config) storage.Backend {
+	crashIfConfigMissingVars(conf, []string{"storage.webdav.url"})
+	backend, err := cm_storage.NewWebDAVBackend(
+		conf.GetString("storage.webdav.url"),
+		cm_storage.WebDAVOptions{
+			Username: conf.GetString("storage.webdav.username"),
+			Password: conf.GetString("storage.webdav.password"),
+		},
+	)
+	if err != nil {
+		crash("Invalid WebDAV storage: ", err)
+	}
+	return backend
+}
+
+func sqlBackendFromConfig(conf *config.Config) storage.Backend {
+	crashIfConfigMissingVars(conf, []string{"storage.sql.dialect", "storage.sql.dsn"})
+	dialect := strings.ToLower(conf.GetString("storage.sql.dialect"))
+	db, err := sql.Open(dialect, conf.GetString("storage.sql.dsn"))
+	if err != nil {
+		crash("Invalid SQL storage: ", err)
+	}
+	backend, err := cm_storage.NewSQLBackend(db, dialect, conf.GetString("storage.sql.table"))
+	if err != nil {
+		crash("Invalid SQL storage: ", err)
+	}
+	return backend
+}
+
+func ipfsBackendFromConfig(conf *config.Config) storage.Backend {
+	backend, err := cm_storage.NewIPFSBackend(
+		conf.GetString("storage.ipfs.apiurl"),
+		conf.GetString("storage.ipfs.root"),
+		cm_storage.IPFSOptions{Gateway: conf.GetString("storage.ipfs.gateway")},
+	)
+	if err != nil {
+		crash("Invalid IPFS storage: ", err)
+	}
+	return backend
+}
+
+func radosBackendFromConfig(conf *config.Config) storage.Backend {
+	crashIfConfigMissingVars(conf, []string{"storage.rados.pool"})
+	backend, err := cm_storage.NewRADOSBackend(cm_storage.RADOSOptions{
+		Pool:       conf.GetString("storage.rados.pool"),
+		Namespace:  conf.GetString("storage.rados.namespace"),
+		Cluster:    conf.GetString("storage.rados.cluster"),
+		User:       conf.GetString("storage.rados.user"),
+		ConfigFile: conf.GetString("storage.rados.configfile"),
+	})
+	if err != nil {
+		crash("Invalid RADOS storage: ", err)
+	}
+	return backend
+}
+
+func hdfsBackendFromConfig(conf *config.Config) storage.Backend {
+	crashIfConfigMissingVars(conf, []string{"storage.hdfs.namenode"})
+	backend, err := cm_storage.NewHDFSBackend(conf.GetString("storage.hdfs.namenode"), cm_storage.HDFSOptions{
+		User:                     conf.GetString("storage.hdfs.user"),
+		KerberosPrincipal:        conf.GetString("storage.hdfs.kerberosprincipal"),
+		KerberosKeytab:           conf.GetString("storage.hdfs.kerberoskeytab"),
+		KerberosConfig:           conf.GetString("storage.hdfs.kerberosconfig"),
+		KerberosServicePrincipal: conf.GetString("storage.hdfs.kerberosserviceprincipal"),
+		UseDatanodeHostname:      conf.GetBool("storage.hdfs.datanodehostname"),
+	})
+	if err != nil {
+		crash("Invalid HDFS storage: ", err)
+	}
+	return backend
+}
+
 func googleBackendFromConfig(conf *config.Config) storage.Backend {
 	crashIfConfigMissingVars(conf, []string{"storage.google.bucket"})
+	if vaultPath := conf.GetString("storage.google.vaultpath"); vaultPath != "" {
+		backend, err := cm_storage.NewVaultGoogleCSBackend(
+			conf.GetString("storage.google.bucket"),
+			conf.GetString("storage.google.prefix"),
+			vaultLeaseFromConfig(conf, vaultPath, nil),
+		)
+		if err != nil {
+			crash("Invalid Google Cloud Storage: ", err)
+		}
+		return backend
+	}
+	// the backend uses application default credentials, which honor this env var
+	if credentialsFile := conf.GetString("storage.google.credentialsfile"); credentialsFile != "" {
+		if _, err := os.Stat(credentialsFile); err != nil {
+			crash("Invalid Google credentials file: ", err)
+		}
+		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credentialsFile)
+	}
 	return storage.NewGoogleCSBackend(
 		conf.GetString("storage.google.bucket"),
 		conf.GetString("storage.google.prefix"),
@@ -200,6 +693,17 @@ func googleBackendFromConfig(conf *config.Config) storage.Backend {
 
 func oracleBackendFromConfig(conf *config.Config) storage.Backend {
 	crashIfConfigMissingVars(conf, []string{"storage.oracle.bucket", "storage.oracle.compartmentid"})
+	// the backend reads its authentication settings from the environment only
+	switch conf.GetString("storage.oracle.auth") {
+	case "instance-principal":
+		os.Setenv("ORACLE_AUTH_METHOD", "InstancePrincipal")
+	case "config-file", "":
+		if configFile := conf.GetString("storage.oracle.configfile"); configFile != "" {
+			os.Setenv("OCI_CONFIG_FILE", configFile)
+		}
+	default:
+		crash("Unsupported Oracle auth method: ", conf.GetString("storage.oracle.auth"))
+	}
 	return storage.NewOracleCSBackend(
 		conf.GetString("storage.oracle.bucket"),
 		conf.GetString("storage.oracle.prefix"),
@@ -210,6 +714,24 @@ func oracleBackendFromConfig(conf *config.Config) storage.Backend {
 
 func microsoftBackendFromConfig(conf *config.Config) storage.Backend {
 	crashIfConfigMissingVars(conf, []string{"storage.microsoft.container"})
+	if vaultPath := conf.GetString("storage.microsoft.vaultpath"); vaultPath != "" {
+		backend, err := cm_storage.NewMicrosoftVaultBackend(
+			conf.GetString("storage.microsoft.container"),
+			conf.GetString("storage.microsoft.prefix"),
+			vaultLeaseFromConfig(conf, vaultPath, nil),
+		)
+		if err != nil {
+			crash("Invalid Microsoft Azure Blob Storage: ", err)
+		}
+		return backend
+	}
+	// the backend reads its credentials from the environment only
+	if account := conf.GetString("storage.microsoft.account"); account != "" {
+		os.Setenv("AZURE_STORAGE_ACCOUNT", account)
+	}
+	if accessKey := conf.GetString("storage.microsoft.accesskey"); accessKey != "" {
+		os.Setenv("AZURE_STORAGE_ACCESS_KEY", accessKey)
+	}
 	return storage.NewMicrosoftBlobBackend(
 		conf.GetString("storage.microsoft.container"),
 		conf.GetString("storage.microsoft.prefix"),
@@ -218,6 +740,9 @@ func microsoftBackendFromConfig(conf *config.Config) storage.Backend {
 
 func alibabaBackendFromConfig(conf *config.Config) storage.Backend {
 	crashIfConfigMissingVars(conf, []string{"storage.alibaba.bucket"})
+	if securityToken := conf.GetString("storage.alibaba.securitytoken"); securityToken != "" {
+		return alibabaSTSBackendFromConfig(conf, securityToken)
+	}
 	return storage.NewAlibabaCloudOSSBackend(
 		conf.GetString("storage.alibaba.bucket"),
 		conf.GetString("storage.alibaba.prefix"),
@@ -226,6 +751,34 @@ func alibabaBackendFromConfig(conf *config.Config) storage.Backend {
 	)
 }
 
+// alibabaSTSBackendFromConfig builds the OSS client itself, as the storage package
+// does not support temporary credentials
+func alibabaSTSBackendFromConfig(conf *config.Config, securityToken string) storage.Backend {
+	accessKeyID := os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_ID")
+	accessKeySecret := os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+	if accessKeyID == "" || accessKeySecret == "" {
+		crash("ALIBABA_CLOUD_ACCESS_KEY_ID and ALIBABA_CLOUD_ACCESS_KEY_SECRET must be set with a security token")
+	}
+	endpoint := conf.GetString("storage.alibaba.endpoint")
+	if endpoint == "" {
+		endpoint = "oss-cn-hangzhou.aliyuncs.com"
+	}
+	client, err := oss.New(endpoint, accessKeyID, accessKeySecret, oss.SecurityToken(securityToken))
+	if err != nil {
+		crash("Failed to create OSS client: ", err)
+	}
+	bucket, err := client.Bucket(conf.GetString("storage.alibaba.bucket"))
+	if err != nil {
+		crash("Failed to get OSS bucket: ", err)
+	}
+	return &storage.AlibabaCloudOSSBackend{
+		Bucket: bucket,
+		Client: client,
+		Prefix: strings.Trim(conf.GetString("storage.alibaba.prefix"), "/"),
+		SSE:    conf.GetString("storage.alibaba.sse"),
+	}
+}
+
 func openstackBackendFromConfig(conf *config.Config) storage.Backend {
 	var backend storage.Backend
 	switch conf.GetString("storage.openstack.auth") {
@@ -236,8 +789,11 @@ func openstackBackendFromConfig(conf *config.Config) storage.Backend {
 			conf.GetString("storage.openstack.prefix"),
 			conf.GetString("storage.openstack.cacert"),
 		)
-	case "auto":
+	case "auto", "v2", "v3":
 		crashIfConfigMissingVars(conf, []string{"storage.openstack.container", "storage.openstack.region"})
+		if version := conf.GetString("storage.openstack.auth"); version != "auto" {
+			pinKeystoneVersion(version)
+		}
 		backend = storage.NewOpenstackOSBackend(
 			conf.GetString("storage.openstack.container"),
 			conf.GetString("storage.openstack.prefix"),
@@ -250,8 +806,37 @@ func openstackBackendFromConfig(conf *config.Config) storage.Backend {
 	return backend
 }
 
+// pinKeystoneVersion points OS_AUTH_URL at the Keystone version requested, v2 or v3, so that the
+// backend authenticates with it rather than negotiating the version with the identity service
+func pinKeystoneVersion(version string) {
+	authURL := strings.TrimSuffix(os.Getenv("OS_AUTH_URL"), "/")
+	if authURL == "" {
+		return // reported missing by the backend
+	}
+	suffixes := map[string]string{"v2": "/v2.0", "v3": "/v3"}
+	for v, suffix := range suffixes {
+		if strings.HasSuffix(authURL, suffix) {
+			if v != version {
+				crash(fmt.Sprintf("OS_AUTH_URL %s is a Keystone %s endpoint, but --storage-openstack-auth is %s", authURL, v, version))
+			}
+			return
+		}
+	}
+	os.Setenv("OS_AUTH_URL", authURL+suffixes[version]+"/")
+}
+
 func baiduBackendFromConfig(conf *config.Config) storage.Backend {
 	crashIfConfigMissingVars(conf, []string{"storage.baidu.bucket"})
+	// the backend reads its credentials from the environment only, and panics without them
+	if accessKeyID := conf.GetString("storage.baidu.accesskeyid"); accessKeyID != "" {
+		os.Setenv("BAIDU_CLOUD_ACCESS_KEY_ID", accessKeyID)
+	}
+	if accessKeySecret := conf.GetString("storage.baidu.accesskeysecret"); accessKeySecret != "" {
+		os.Setenv("BAIDU_CLOUD_ACCESS_KEY_SECRET", accessKeySecret)
+	}
+	if os.Getenv("BAIDU_CLOUD_ACCESS_KEY_ID") == "" || os.Getenv("BAIDU_CLOUD_ACCESS_KEY_SECRET") == "" {
+		crash("Missing Baidu Cloud BOS credentials: set --storage-baidu-access-key-id and --storage-baidu-access-key-secret")
+	}
 	return storage.NewBaiDuBOSBackend(
 		conf.GetString("storage.baidu.bucket"),
 		conf.GetString("storage.baidu.prefix"),
@@ -264,21 +849,39 @@ func etcdBackendFromConfig(conf *config.Config) storage.Backend {
 		"storage.etcd.certfile",
 		"storage.etcd.keyfile",
 		"storage.etcd.prefix"})
-	return storage.NewEtcdCSBackend(
-		conf.GetString("storage.etcd.endpoint"),
-		conf.GetString("storage.etcd.cafile"),
-		conf.GetString("storage.etcd.certfile"),
-		conf.GetString("storage.etcd.keyfile"),
-		conf.GetString("storage.etcd.prefix"),
+	// objects are stored as values, which etcd rejects past its request size limit
+	return cm_storage.NewSizeLimitedBackend(
+		storage.NewEtcdCSBackend(
+			conf.GetString("storage.etcd.endpoint"),
+			conf.GetString("storage.etcd.cafile"),
+			conf.GetString("storage.etcd.certfile"),
+			conf.GetString("storage.etcd.keyfile"),
+			conf.GetString("storage.etcd.prefix"),
+		),
+		conf.GetInt("storage.etcd.maxobjectsize"),
 	)
 }
 
 func tencentBackendFromConfig(conf *config.Config) storage.Backend {
 	crashIfConfigMissingVars(conf, []string{"storage.tencent.bucket"})
+	// the backend reads its credentials from the environment only, and panics without them
+	if secretID := conf.GetString("storage.tencent.secretid"); secretID != "" {
+		os.Setenv("TENCENT_CLOUD_COS_SECRET_ID", secretID)
+	}
+	if secretKey := conf.GetString("storage.tencent.secretkey"); secretKey != "" {
+		os.Setenv("TENCENT_CLOUD_COS_SECRET_KEY", secretKey)
+	}
+	if os.Getenv("TENCENT_CLOUD_COS_SECRET_ID") == "" || os.Getenv("TENCENT_CLOUD_COS_SECRET_KEY") == "" {
+		crash("Missing Tencent Cloud COS credentials: set --storage-tencent-secret-id and --storage-tencent-secret-key")
+	}
+	endpoint := conf.GetString("storage.tencent.endpoint")
+	if region := conf.GetString("storage.tencent.region"); endpoint == "" && region != "" {
+		endpoint = fmt.Sprintf("cos.%s.myqcloud.com", region)
+	}
 	return storage.NewTencentCloudCOSBackend(
 		conf.GetString("storage.tencent.bucket"),
 		conf.GetString("storage.tencent.prefix"),
-		conf.GetString("storage.tencent.endpoint"),
+		endpoint,
 	)
 }
 
@@ -309,6 +912,16 @@ func redisCacheFromConfig(conf *config.Config) cache.Store {
 	))
 }
 
+func splitCommaSeparated(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func crashIfConfigMissingVars(conf *config.Config, vars []string) {
 	var missing []string
 	for _, v := range vars {
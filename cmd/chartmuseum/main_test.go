@@ -17,9 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"helm.sh/chartmuseum/pkg/chartmuseum"
@@ -69,6 +71,16 @@ func (suite *MainTestSuite) TestMain() {
 	suite.Panics(main, "local storage")
 	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with local backend")
 
+	os.Args = []string{"chartmuseum", "--storage-url", "garage://x"}
+	suite.Panics(main, "bad storage URL")
+	suite.Contains(suite.LastCrashMessage, "no storage backend registered for scheme garage", "crashes with unregistered scheme")
+
+	storageDir, err := filepath.Abs("../../.chartstorage")
+	suite.Nil(err, "no error resolving storage dir")
+	os.Args = []string{"chartmuseum", "--storage-url", "file://" + storageDir}
+	suite.Panics(main, "storage URL")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with storage URL")
+
 	os.Args = []string{"chartmuseum", "--storage", "amazon", "--storage-amazon-bucket", "x", "--storage-amazon-region", "x"}
 	suite.Panics(main, "amazon storage")
 	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with amazon backend")
@@ -85,6 +97,14 @@ func (suite *MainTestSuite) TestMain() {
 	suite.Panics(main, "microsoft storage")
 	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with microsoft backend")
 
+	defer os.Unsetenv("AZURE_STORAGE_ACCOUNT")
+	defer os.Unsetenv("AZURE_STORAGE_ACCESS_KEY")
+	os.Args = []string{"chartmuseum", "--storage", "microsoft", "--storage-microsoft-container", "x",
+		"--storage-microsoft-account", "x", "--storage-microsoft-access-key", "eA=="}
+	suite.Panics(main, "microsoft storage with credentials")
+	suite.Equal("x", os.Getenv("AZURE_STORAGE_ACCOUNT"), "account name exported")
+	suite.Equal("eA==", os.Getenv("AZURE_STORAGE_ACCESS_KEY"), "access key exported")
+
 	os.Args = []string{"chartmuseum", "--storage", "alibaba", "--storage-alibaba-bucket", "x", "--storage-alibaba-endpoint", "oss-cn-beijing.aliyuncs.com"}
 	suite.Panics(main, "alibaba storage")
 	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with alibaba backend")
@@ -97,9 +117,191 @@ func (suite *MainTestSuite) TestMain() {
 	suite.Panics(main, "oracle storage")
 	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with oracle backend")
 
+	os.Unsetenv("BAIDU_CLOUD_ACCESS_KEY_ID")
+	os.Unsetenv("BAIDU_CLOUD_ACCESS_KEY_SECRET")
 	os.Args = []string{"chartmuseum", "--storage", "baidu", "--storage-baidu-bucket", "x", "--storage-baidu-endpoint", "bj.bcebos.com"}
+	suite.Panics(main, "baidu storage without credentials")
+	suite.Contains(suite.LastCrashMessage, "Missing Baidu Cloud BOS credentials", "crashes without credentials")
+
+	defer os.Unsetenv("BAIDU_CLOUD_ACCESS_KEY_ID")
+	defer os.Unsetenv("BAIDU_CLOUD_ACCESS_KEY_SECRET")
+	os.Args = []string{"chartmuseum", "--storage", "baidu", "--storage-baidu-bucket", "x", "--storage-baidu-endpoint", "bj.bcebos.com",
+		"--storage-baidu-access-key-id", "x", "--storage-baidu-access-key-secret", "x"}
 	suite.Panics(main, "baidu storage")
 	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with baidu backend")
+	suite.Equal("x", os.Getenv("BAIDU_CLOUD_ACCESS_KEY_ID"), "access key id exported")
+
+	os.Args = []string{"chartmuseum", "--storage", "google", "--storage-google-bucket", "x", "--storage-google-credentials-file", "missing.json"}
+	suite.Panics(main, "google storage with missing credentials file")
+	suite.Contains(suite.LastCrashMessage, "Invalid Google credentials file", "crashes with missing credentials file")
+
+	os.Args = []string{"chartmuseum", "--storage", "alibaba", "--storage-alibaba-bucket", "x", "--storage-alibaba-security-token", "x"}
+	suite.Panics(main, "alibaba storage with security token and no access key")
+	suite.Contains(suite.LastCrashMessage, "must be set with a security token", "crashes without access key")
+
+	os.Args = []string{"chartmuseum", "--storage", "openstack", "--storage-openstack-auth", "v3", "--storage-openstack-container", "x"}
+	suite.Panics(main, "openstack storage v3 without region")
+	suite.Equal("Missing required flags(s): --storage-openstack-region", suite.LastCrashMessage, "region required with v3")
+
+	os.Setenv("OS_AUTH_URL", "https://keystone.example.com:5000/v2.0/")
+	os.Args = []string{"chartmuseum", "--storage", "openstack", "--storage-openstack-auth", "v3", "--storage-openstack-container", "x", "--storage-openstack-region", "x"}
+	suite.Panics(main, "openstack storage v3 with a v2 auth url")
+	suite.Equal("OS_AUTH_URL https://keystone.example.com:5000/v2.0 is a Keystone v2 endpoint, but --storage-openstack-auth is v3", suite.LastCrashMessage, "crashes with the other Keystone version")
+
+	os.Setenv("OS_AUTH_URL", "https://keystone.example.com:5000")
+	suite.Panics(main, "openstack storage v3 without credentials")
+	suite.Equal("https://keystone.example.com:5000/v3/", os.Getenv("OS_AUTH_URL"), "auth url pinned to v3")
+	os.Unsetenv("OS_AUTH_URL")
+
+	os.Args = []string{"chartmuseum", "--storage", "openstack", "--storage-openstack-auth", "v4", "--storage-openstack-container", "x"}
+	suite.Panics(main, "openstack storage with bad auth")
+	suite.Equal("Unsupported OpenStack auth protocol: v4", suite.LastCrashMessage, "crashes with bad auth protocol")
+
+	os.Args = []string{"chartmuseum", "--storage", "digitalocean", "--storage-digitalocean-bucket", "x", "--storage-digitalocean-region", "fra1"}
+	suite.Panics(main, "digitalocean storage")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with digitalocean backend")
+
+	os.Args = []string{"chartmuseum", "--storage", "digitalocean", "--storage-digitalocean-bucket", "x", "--storage-digitalocean-region", "fra1.digitaloceanspaces.com"}
+	suite.Panics(main, "digitalocean storage with bad region")
+	suite.Equal("Invalid DigitalOcean Spaces region: fra1.digitaloceanspaces.com", suite.LastCrashMessage, "crashes with bad region")
+
+	os.Args = []string{"chartmuseum", "--storage", "minio", "--storage-minio-endpoint", "https://minio:9000", "--storage-minio-bucket", "x", "--storage-minio-insecure-skip-verify"}
+	suite.Panics(main, "minio storage")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with minio backend")
+
+	os.Args = []string{"chartmuseum", "--storage", "minio", "--storage-minio-endpoint", "minio:9000", "--storage-minio-bucket", "x"}
+	suite.Panics(main, "minio storage with bad endpoint")
+	suite.Equal(`Invalid MinIO storage: endpoint must be an http:// or https:// URL, got "minio:9000"`, suite.LastCrashMessage, "crashes with bad endpoint")
+
+	os.Args = []string{"chartmuseum", "--storage", "b2", "--storage-b2-bucket-id", "x", "--storage-b2-key-id", "x", "--storage-b2-application-key", "x"}
+	suite.Panics(main, "b2 storage")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with b2 backend")
+
+	os.Args = []string{"chartmuseum", "--storage", "oracle", "--storage-oracle-bucket", "x", "--storage-oracle-compartmentid", "x", "--storage-oracle-auth", "x"}
+	suite.Panics(main, "oracle storage with bad auth")
+	suite.Equal("Unsupported Oracle auth method: x", suite.LastCrashMessage, "crashes with bad auth method")
+
+	os.Args = []string{"chartmuseum", "--storage", "sftp", "--storage-sftp-host", "x", "--storage-sftp-user", "x", "--storage-sftp-password", "x"}
+	suite.Panics(main, "sftp storage without known hosts")
+	suite.Equal("Invalid SFTP storage: missing sftp known hosts file", suite.LastCrashMessage, "crashes without known hosts")
+
+	os.Args = []string{"chartmuseum", "--storage", "sftp", "--storage-sftp-host", "x", "--storage-sftp-user", "x", "--storage-sftp-password", "x", "--storage-sftp-insecure-ignore-host-key"}
+	suite.Panics(main, "sftp storage")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with sftp backend")
+
+	os.Args = []string{"chartmuseum", "--storage", "webdav", "--storage-webdav-url", "ftp://x"}
+	suite.Panics(main, "webdav storage with bad url")
+	suite.Equal("Invalid WebDAV storage: invalid webdav URL: ftp://x", suite.LastCrashMessage, "crashes with bad url")
+
+	os.Args = []string{"chartmuseum", "--storage", "webdav", "--storage-webdav-url", "https://x/charts"}
+	suite.Panics(main, "webdav storage")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with webdav backend")
+
+	os.Args = []string{"chartmuseum", "--storage", "sql", "--storage-sql-dialect", "oracle", "--storage-sql-dsn", "x"}
+	suite.Panics(main, "sql storage with unsupported dialect")
+	suite.Contains(suite.LastCrashMessage, "Invalid SQL storage", "crashes with unsupported dialect")
+
+	os.Args = []string{"chartmuseum", "--storage", "ipfs", "--storage-ipfs-gateway", "ipfs.io"}
+	suite.Panics(main, "ipfs storage with bad gateway")
+	suite.Equal("Invalid IPFS storage: invalid ipfs gateway URL: ipfs.io", suite.LastCrashMessage, "crashes with bad gateway")
+
+	os.Args = []string{"chartmuseum", "--storage", "ipfs"}
+	suite.Panics(main, "ipfs storage")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with ipfs backend")
+
+	os.Args = []string{"chartmuseum", "--storage", "rados", "--storage-rados-namespace", "charts"}
+	suite.Panics(main, "rados storage without pool")
+	suite.Equal("Missing required flags(s): --storage-rados-pool", suite.LastCrashMessage, "crashes without pool")
+
+	os.Args = []string{"chartmuseum", "--storage", "local", "--storage-local-rootdir", "../../.chartstorage",
+		"--storage-tiered-cache-dir", "../../.chartstorage-cache", "--storage-tiered-cache-max-size", "0"}
+	suite.Panics(main, "tiered storage with bad size")
+	suite.Equal("Invalid tiered storage: tiered storage cache size must be positive", suite.LastCrashMessage, "crashes with bad size")
+
+	os.Args = []string{"chartmuseum", "--storage", "local", "--storage-local-rootdir", "../../.chartstorage", "--storage-replica-url", "garage://x"}
+	suite.Panics(main, "bad storage replica URL")
+	suite.Contains(suite.LastCrashMessage, "Unsupported storage replica URL", "crashes with bad replica URL")
+
+	os.Args = []string{"chartmuseum", "--storage", "local", "--storage-local-rootdir", "../../.chartstorage", "--depth", "1", "--storage-route", "team-a=memory://"}
+	suite.Panics(main, "local storage with a route")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with storage route")
+
+	os.Args = []string{"chartmuseum", "--storage", "local", "--storage-local-rootdir", "../../.chartstorage", "--storage-route", "team-a=garage://x"}
+	suite.Panics(main, "bad storage route URL")
+	suite.Contains(suite.LastCrashMessage, "Unsupported storage route URL for team-a", "crashes with bad route URL")
+
+	os.Args = []string{"chartmuseum", "--storage", "local", "--storage-local-rootdir", "../../.chartstorage", "--storage-route", "memory://"}
+	suite.Panics(main, "storage route without prefix")
+	suite.Equal("Invalid storage route: missing prefix for memory://", suite.LastCrashMessage, "crashes without route prefix")
+
+	os.Args = []string{"chartmuseum", "--storage", "local", "--storage-local-rootdir", "../../.chartstorage", "--storage-layout", "charts/{repo}/{first}/{file}"}
+	suite.Panics(main, "local storage with a sharded layout")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with storage layout")
+
+	os.Args = []string{"chartmuseum", "--storage", "local", "--storage-local-rootdir", "../../.chartstorage", "--storage-layout", "{name}/{file}"}
+	suite.Panics(main, "bad storage layout")
+	suite.Equal("Invalid storage layout: key layout {name}/{file} must contain {repo} once", suite.LastCrashMessage, "crashes with bad layout")
+
+	os.Args = []string{"chartmuseum", "--storage", "amazon", "--storage-amazon-bucket", "x", "--storage-amazon-region", "x", "--storage-amazon-sse-kms-key-id", "alias/x"}
+	suite.Panics(main, "amazon storage with kms key")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with kms key")
+
+	os.Args = []string{"chartmuseum", "--storage", "amazon", "--storage-amazon-bucket", "x", "--storage-amazon-region", "x", "--storage-amazon-sse", "AES256", "--storage-amazon-sse-kms-key-id", "alias/x"}
+	suite.Panics(main, "amazon storage with kms key and AES256")
+	suite.Equal("Invalid Amazon S3 storage: a KMS key id requires aws:kms server-side encryption", suite.LastCrashMessage, "crashes with kms key and AES256")
+
+	os.Unsetenv("TENCENT_CLOUD_COS_SECRET_ID")
+	os.Unsetenv("TENCENT_CLOUD_COS_SECRET_KEY")
+	os.Args = []string{"chartmuseum", "--storage", "tencent", "--storage-tencent-bucket", "x"}
+	suite.Panics(main, "tencent storage without credentials")
+	suite.Contains(suite.LastCrashMessage, "Missing Tencent Cloud COS credentials", "crashes without credentials")
+
+	defer os.Unsetenv("TENCENT_CLOUD_COS_SECRET_ID")
+	defer os.Unsetenv("TENCENT_CLOUD_COS_SECRET_KEY")
+	os.Args = []string{"chartmuseum", "--storage", "tencent", "--storage-tencent-bucket", "x", "--storage-tencent-region", "ap-beijing",
+		"--storage-tencent-secret-id", "x", "--storage-tencent-secret-key", "x"}
+	suite.Panics(main, "tencent storage with credentials")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with tencent backend")
+	suite.Equal("x", os.Getenv("TENCENT_CLOUD_COS_SECRET_ID"), "secret id exported")
+
+	os.Args = []string{"chartmuseum", "--storage", "hdfs"}
+	suite.Panics(main, "hdfs storage without namenode")
+	suite.Contains(suite.LastCrashMessage, "Missing required flags(s): --storage-hdfs-namenode-uri", "crashes without namenode")
+
+	os.Args = []string{"chartmuseum", "--storage", "hdfs", "--storage-hdfs-namenode-uri", "http://namenode:8020/charts"}
+	suite.Panics(main, "hdfs storage with bad namenode URI")
+	suite.Equal("Invalid HDFS storage: invalid hdfs namenode URI: http://namenode:8020/charts", suite.LastCrashMessage, "crashes with bad namenode URI")
+
+	os.Args = []string{"chartmuseum", "--storage", "memory"}
+	suite.Panics(main, "memory storage")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with memory backend")
+
+	keyFile := filepath.Join(suite.T().TempDir(), "encryption.key")
+	suite.Nil(os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(make([]byte, 32))+"\n"), 0600))
+	os.Args = []string{"chartmuseum", "--storage", "memory", "--storage-encryption-key-file", keyFile}
+	suite.Panics(main, "encrypted storage")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with encryption key")
+
+	suite.Nil(os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(make([]byte, 16))), 0600))
+	os.Args = []string{"chartmuseum", "--storage", "memory", "--storage-encryption-key-file", keyFile}
+	suite.Panics(main, "encrypted storage with short key")
+	suite.Equal("Invalid storage encryption: encryption key must be 32 bytes long, got 16", suite.LastCrashMessage, "crashes with short key")
+
+	os.Args = []string{"chartmuseum", "--storage", "memory", "--storage-encryption-key-file", keyFile, "--storage-encryption-kms-key-id", "alias/x"}
+	suite.Panics(main, "encrypted storage with key file and kms key")
+	suite.Contains(suite.LastCrashMessage, "are exclusive", "crashes with key file and kms key")
+
+	os.Args = []string{"chartmuseum", "--storage", "memory", "--storage-encryption-kms-key-id", "alias/x", "--storage-encryption-kms-region", "us-east-1"}
+	suite.Panics(main, "encrypted storage with kms key")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with kms key")
+
+	os.Args = []string{"chartmuseum", "--storage", "memory", "--storage-retry-attempts", "3", "--storage-retry-timeout", "10s"}
+	suite.Panics(main, "storage with retries")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with retries")
+
+	os.Args = []string{"chartmuseum", "--storage", "memory", "--storage-circuit-breaker-threshold", "5", "--storage-circuit-breaker-cooldown", "1m"}
+	suite.Panics(main, "storage with a circuit breaker")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error with circuit breaker")
 
 	// Redis cache
 	os.Args = []string{"chartmuseum", "--storage", "local", "--storage-local-rootdir", "../../.chartstorage", "--cache", "redis", "--cache-redis-addr", suite.RedisMock.Addr()}
@@ -0,0 +1,66 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix starts every API key, telling them apart from passwords and bearer tokens
+const APIKeyPrefix = "cmk_"
+
+type (
+	// APIKey is a credential created by a user of a repo, granting some actions on the repo only
+	APIKey struct {
+		ID      string     `json:"id"`
+		Name    string     `json:"name"`
+		User    string     `json:"user,omitempty"`
+		Actions []string   `json:"actions"`
+		Created time.Time  `json:"created"`
+		Expires *time.Time `json:"expires,omitempty"`
+	}
+
+	// APIKeyVerifier returns the API key of repo matching secret, nil if there is none
+	APIKeyVerifier func(repo string, secret string) (*APIKey, error)
+)
+
+// Grants tells whether the key grants action, and has not expired
+func (key *APIKey) Grants(action string) bool {
+	if key.Expires != nil && time.Now().After(*key.Expires) {
+		return false
+	}
+	return containsString(key.Actions, action)
+}
+
+// SetAPIKeyVerifier accepts the API keys checked by verifier, as bearer tokens or basic auth
+// passwords, ahead of the other authentication methods
+func (router *Router) SetAPIKeyVerifier(verifier APIKeyVerifier) {
+	router.apiKeys = verifier
+}
+
+// apiKeySecret returns the API key sent by the client of a request, empty if there is none
+func apiKeySecret(r *http.Request) string {
+	if _, password, ok := r.BasicAuth(); ok && strings.HasPrefix(password, APIKeyPrefix) {
+		return password
+	}
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); strings.HasPrefix(token, APIKeyPrefix) {
+		return token
+	}
+	return ""
+}
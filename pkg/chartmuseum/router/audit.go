@@ -0,0 +1,212 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// auditChartsKey is the context key of the charts changed by a request
+	auditChartsKey = "auditcharts"
+	// auditChangesKey is the context key of the other changes made by a request
+	auditChangesKey = "auditchanges"
+
+	auditWebhookTimeout = 5 * time.Second
+)
+
+type (
+	// AuditRecord describes a request changing repos, for compliance. Result is success, denied if
+	// the client was not allowed, or failure
+	AuditRecord struct {
+		Time      time.Time `json:"time"`
+		User      string    `json:"user"`
+		APIKey    string    `json:"apikey,omitempty"`
+		Repo      string    `json:"repo"`
+		Chart     string    `json:"chart,omitempty"`
+		Version   string    `json:"version,omitempty"`
+		Action    string    `json:"action"`
+		Method    string    `json:"method"`
+		Path      string    `json:"path"`
+		Status    int       `json:"status"`
+		Result    string    `json:"result"`
+		ClientIP  string    `json:"client_ip"`
+		RequestID string    `json:"request_id,omitempty"`
+		// Changes describes what the request changed besides chart versions, such as repo settings
+		Changes interface{} `json:"changes,omitempty"`
+	}
+
+	// auditLog writes an audit record of every request changing repos to its sinks, as JSON
+	auditLog struct {
+		sinks []auditSink
+	}
+
+	// auditSink is a destination of audit records, each written as a JSON object
+	auditSink interface {
+		write(record []byte) error
+	}
+
+	// fileAuditSink appends records to a file, or writes them to stdout, one per line
+	fileAuditSink struct {
+		lock sync.Mutex
+		file io.Writer
+	}
+
+	// webhookAuditSink POSTs each record to a url
+	webhookAuditSink struct {
+		url    string
+		client *http.Client
+	}
+
+	auditChart struct {
+		name    string
+		version string
+	}
+)
+
+// newAuditLog opens the destinations of audit records: a file path, stdout, syslog for the local
+// syslog daemon, syslog://host:port or syslog+tcp://host:port for a remote one, or an http(s) url
+// receiving records as POSTs
+func newAuditLog(destinations []string) (*auditLog, error) {
+	audit := &auditLog{}
+	for _, destination := range destinations {
+		var sink auditSink
+		var err error
+		switch {
+		case destination == "stdout":
+			sink = &fileAuditSink{file: os.Stdout}
+		case destination == "syslog" || strings.HasPrefix(destination, "syslog://") || strings.HasPrefix(destination, "syslog+tcp://"):
+			sink, err = newSyslogAuditSink(destination)
+		case strings.HasPrefix(destination, "http://") || strings.HasPrefix(destination, "https://"):
+			sink = &webhookAuditSink{url: destination, client: &http.Client{Timeout: auditWebhookTimeout}}
+		default:
+			var file *os.File
+			file, err = os.OpenFile(strings.TrimPrefix(destination, "file://"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			sink = &fileAuditSink{file: file}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("audit log %s: %w", destination, err)
+		}
+		audit.sinks = append(audit.sinks, sink)
+	}
+	return audit, nil
+}
+
+// AuditChart records that the request of c changed a chart version, for the audit log
+func AuditChart(c *gin.Context, name string, version string) {
+	charts := c.GetStringSlice(auditChartsKey)
+	c.Set(auditChartsKey, append(charts, name, version))
+}
+
+// AuditChanges records what the request of c changed besides chart versions, for the audit log.
+// changes is written as JSON
+func AuditChanges(c *gin.Context, changes interface{}) {
+	c.Set(auditChangesKey, changes)
+}
+
+// isWriteMethod tells whether requests of method change repos, such requests being audited
+func isWriteMethod(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch || method == http.MethodDelete
+}
+
+// records returns the audit records of the request of c for action, from the client at address,
+// once it was answered: one per chart version it changed, or one naming the chart of the route
+func (a *auditLog) records(c *gin.Context, action string, address string) []AuditRecord {
+	record := AuditRecord{
+		Time:      time.Now().UTC(),
+		User:      c.GetString("user"),
+		APIKey:    c.GetString("apikey"),
+		Repo:      c.Param("repo"),
+		Action:    action,
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		Status:    c.Writer.Status(),
+		ClientIP:  address,
+		RequestID: c.GetString("requestid"),
+	}
+	record.Changes, _ = c.Get(auditChangesKey)
+	switch status := record.Status; {
+	case status >= 200 && status < 300:
+		record.Result = "success"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusTooManyRequests:
+		record.Result = "denied"
+	default:
+		record.Result = "failure"
+	}
+
+	var charts []auditChart
+	if changed := c.GetStringSlice(auditChartsKey); len(changed) > 0 {
+		for i := 0; i+1 < len(changed); i += 2 {
+			charts = append(charts, auditChart{changed[i], changed[i+1]})
+		}
+	} else {
+		charts = []auditChart{{c.Param("name"), c.Param("version")}}
+	}
+	records := make([]AuditRecord, 0, len(charts))
+	for _, chart := range charts {
+		record.Chart, record.Version = chart.name, chart.version
+		records = append(records, record)
+	}
+	return records
+}
+
+// write writes the records to every sink, returning the errors of the sinks that failed
+func (a *auditLog) write(records []AuditRecord) []error {
+	var errs []error
+	for _, record := range records {
+		content, err := json.Marshal(record)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, sink := range a.sinks {
+			if err := sink.write(content); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+func (s *fileAuditSink) write(record []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := s.file.Write(append(record, '\n'))
+	return err
+}
+
+func (s *webhookAuditSink) write(record []byte) error {
+	response, err := s.client.Post(s.url, "application/json", bytes.NewReader(record))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook %s: %s", s.url, response.Status)
+	}
+	return nil
+}
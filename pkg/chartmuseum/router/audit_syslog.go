@@ -0,0 +1,49 @@
+//go:build !windows && !plan9
+
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"log/syslog"
+	"strings"
+)
+
+// syslogAuditSink sends records to a syslog daemon, with the auth facility
+type syslogAuditSink struct {
+	writer *syslog.Writer
+}
+
+// newSyslogAuditSink connects to the local syslog daemon for "syslog", or to the remote one of
+// syslog://host:port over UDP or syslog+tcp://host:port over TCP
+func newSyslogAuditSink(destination string) (auditSink, error) {
+	network, address := "", ""
+	if strings.HasPrefix(destination, "syslog://") {
+		network, address = "udp", strings.TrimPrefix(destination, "syslog://")
+	} else if strings.HasPrefix(destination, "syslog+tcp://") {
+		network, address = "tcp", strings.TrimPrefix(destination, "syslog+tcp://")
+	}
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_AUTH, "chartmuseum")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogAuditSink{writer: writer}, nil
+}
+
+func (s *syslogAuditSink) write(record []byte) error {
+	return s.writer.Info(string(record))
+}
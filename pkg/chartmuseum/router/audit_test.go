@@ -0,0 +1,136 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	pathutil "path"
+	"testing"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type AuditTestSuite struct {
+	suite.Suite
+}
+
+func (suite *AuditTestSuite) TestRouter() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	var posted []AuditRecord
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record := AuditRecord{}
+		suite.Nil(json.NewDecoder(r.Body).Decode(&record))
+		posted = append(posted, record)
+	}))
+	defer webhook.Close()
+	file := pathutil.Join(suite.T().TempDir(), "audit.log")
+
+	router := NewRouter(RouterOptions{
+		Logger:        log,
+		Depth:         1,
+		MaxUploadSize: 1 << 20,
+		Username:      "alice",
+		Password:      "wonderland",
+		AuditLog:      []string{file, webhook.URL},
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.Status(200) }, cm_auth.PullAction},
+		{"POST", "/api/:repo/charts", func(c *gin.Context) {
+			AuditChart(c, "mychart", "0.1.0")
+			AuditChart(c, "otherchart", "0.2.0")
+			c.Status(201)
+		}, cm_auth.PushAction},
+		{"DELETE", "/api/:repo/charts/:name/:version", func(c *gin.Context) { c.Status(404) }, DeleteAction},
+		{"PUT", "/api/:repo/settings", func(c *gin.Context) {
+			AuditChanges(c, map[string]interface{}{"allow_overwrite": true})
+			c.Status(200)
+		}, AdminAction},
+	})
+	serve := func(method string, path string, password string) {
+		request, _ := http.NewRequest(method, path, http.NoBody)
+		request.SetBasicAuth("alice", password)
+		request.RemoteAddr = "10.0.0.1:1234"
+		request.Header.Set("X-Forwarded-For", "10.9.9.9")
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	serve("GET", "/org1/index.yaml", "wonderland")
+	serve("POST", "/api/org1/charts", "wonderland")
+	serve("DELETE", "/api/org1/charts/mychart/0.1.0", "wonderland")
+	serve("DELETE", "/api/org1/charts/mychart/0.1.0", "wrong")
+	serve("PUT", "/api/org1/settings", "wonderland")
+
+	content, err := os.Open(file)
+	suite.Nil(err)
+	defer content.Close()
+	var records []AuditRecord
+	for scanner := bufio.NewScanner(content); scanner.Scan(); {
+		record := AuditRecord{}
+		suite.Nil(json.Unmarshal(scanner.Bytes(), &record), "one record per line")
+		records = append(records, record)
+	}
+	suite.Len(records, 5, "reads not audited, one record per chart version")
+	suite.Equal(posted, records, "same records posted to the webhook")
+
+	suite.Equal("alice", records[0].User)
+	suite.Equal("org1", records[0].Repo)
+	suite.Equal("10.0.0.1", records[0].ClientIP, "X-Forwarded-For ignored without trusted proxies")
+	suite.Equal("mychart", records[0].Chart)
+	suite.Equal("0.1.0", records[0].Version)
+	suite.Equal(cm_auth.PushAction, records[0].Action)
+	suite.Equal(201, records[0].Status)
+	suite.Equal("success", records[0].Result)
+	suite.Equal("otherchart", records[1].Chart)
+	suite.Equal("mychart", records[2].Chart, "chart of the route")
+	suite.Equal("failure", records[2].Result)
+	suite.Equal("denied", records[3].Result)
+	suite.Equal(DeleteAction, records[3].Action)
+	suite.NotEmpty(records[3].RequestID)
+	suite.Nil(records[3].Changes)
+	suite.Equal(map[string]interface{}{"allow_overwrite": true}, records[4].Changes, "changes besides chart versions")
+}
+
+func (suite *AuditTestSuite) TestDestinations() {
+	_, err := newAuditLog([]string{pathutil.Join(suite.T().TempDir(), "missing", "audit.log")})
+	suite.NotNil(err, "file in a missing directory")
+	audit, err := newAuditLog([]string{"stdout"})
+	suite.Nil(err)
+	suite.Equal(os.Stdout, audit.sinks[0].(*fileAuditSink).file)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(503)
+	}))
+	defer failing.Close()
+	audit, err = newAuditLog([]string{failing.URL})
+	suite.Nil(err)
+	suite.Len(audit.write([]AuditRecord{{User: "alice"}}), 1, "failing webhook")
+}
+
+func TestAuditTestSuite(t *testing.T) {
+	suite.Run(t, new(AuditTestSuite))
+}
@@ -0,0 +1,106 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// maxCachedDecisions bounds the memory of a decisionCache, expired decisions are dropped once it
+// is reached, and all of them if none expired
+const maxCachedDecisions = 10000
+
+type (
+	// decisionCache caches the auth decisions of external services, such as the LDAP directory or
+	// the auth webhook, so that clients polling index.yaml don't query them on every request.
+	// Decisions are keyed by the hash of what they were made of, credentials included, so that
+	// they are never kept in memory
+	decisionCache struct {
+		allowTTL  time.Duration
+		denyTTL   time.Duration
+		lock      sync.Mutex
+		decisions map[[sha256.Size]byte]cachedDecision
+	}
+
+	cachedDecision struct {
+		allowed bool
+		expires time.Time
+	}
+)
+
+func newDecisionCache(allowTTL time.Duration, denyTTL time.Duration) *decisionCache {
+	return &decisionCache{allowTTL: allowTTL, denyTTL: denyTTL, decisions: map[[sha256.Size]byte]cachedDecision{}}
+}
+
+// decide returns the cached decision of parts, or the one of decide, cached for the TTL of
+// allowed or denied requests unless it failed. A nil cache always decides
+func (c *decisionCache) decide(decide func() (bool, error), parts ...string) (bool, error) {
+	if c == nil {
+		return decide()
+	}
+	key := decisionKey(parts)
+	c.lock.Lock()
+	cached, found := c.decisions[key]
+	c.lock.Unlock()
+	if found && time.Now().Before(cached.expires) {
+		return cached.allowed, nil
+	}
+
+	allowed, err := decide()
+	if err != nil {
+		return false, err
+	}
+	ttl := c.denyTTL
+	if allowed {
+		ttl = c.allowTTL
+	}
+	if ttl <= 0 {
+		return allowed, nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.decisions) >= maxCachedDecisions {
+		now := time.Now()
+		for k, decision := range c.decisions {
+			if now.After(decision.expires) {
+				delete(c.decisions, k)
+			}
+		}
+		if len(c.decisions) >= maxCachedDecisions {
+			c.decisions = map[[sha256.Size]byte]cachedDecision{}
+		}
+	}
+	c.decisions[key] = cachedDecision{allowed: allowed, expires: time.Now().Add(ttl)}
+	return allowed, nil
+}
+
+// decisionKey hashes parts with their lengths, so that they can't be shifted into one another
+func decisionKey(parts []string) [sha256.Size]byte {
+	hash := sha256.New()
+	length := make([]byte, 8)
+	for _, part := range parts {
+		binary.BigEndian.PutUint64(length, uint64(len(part)))
+		hash.Write(length)
+		hash.Write([]byte(part))
+	}
+	var key [sha256.Size]byte
+	copy(key[:], hash.Sum(nil))
+	return key
+}
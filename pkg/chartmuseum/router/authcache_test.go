@@ -0,0 +1,107 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type AuthCacheTestSuite struct {
+	suite.Suite
+}
+
+func (suite *AuthCacheTestSuite) TestDecide() {
+	cache := newDecisionCache(time.Minute, time.Millisecond)
+	calls := 0
+	decision := func(allowed bool, err error) func() (bool, error) {
+		return func() (bool, error) {
+			calls++
+			return allowed, err
+		}
+	}
+
+	allowed, err := cache.decide(decision(true, nil), "alice", "pull")
+	suite.Nil(err)
+	suite.True(allowed)
+	allowed, _ = cache.decide(decision(false, nil), "alice", "pull")
+	suite.True(allowed, "allowed decision cached")
+	suite.Equal(1, calls)
+	allowed, _ = cache.decide(decision(false, nil), "alicep", "ull")
+	suite.False(allowed, "parts not shifted into one another")
+
+	_, err = cache.decide(decision(false, errors.New("unavailable")), "bob", "pull")
+	suite.NotNil(err)
+	allowed, err = cache.decide(decision(true, nil), "bob", "pull")
+	suite.Nil(err)
+	suite.True(allowed, "failures not cached")
+
+	calls = 0
+	cache.decide(decision(false, nil), "eve", "pull")
+	time.Sleep(5 * time.Millisecond)
+	cache.decide(decision(false, nil), "eve", "pull")
+	suite.Equal(2, calls, "denied decision expired")
+
+	var disabled *decisionCache
+	calls = 0
+	disabled.decide(decision(true, nil), "alice", "pull")
+	disabled.decide(decision(true, nil), "alice", "pull")
+	suite.Equal(2, calls, "nil cache always decides")
+}
+
+func (suite *AuthCacheTestSuite) TestWebhook() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	calls := 0
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"result": true}`))
+	}))
+	defer webhook.Close()
+	router := NewRouter(RouterOptions{Logger: log, Depth: 1, AuthWebhookURL: webhook.URL, AuthCacheTTL: time.Minute})
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.Status(200) }, cm_auth.PullAction},
+	})
+	serve := func(path string, password string) int {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", path, nil)
+		request.SetBasicAuth("alice", password)
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		suite.Equal(200, serve("/org1/index.yaml", "wonderland"))
+	}
+	suite.Equal(1, calls, "webhook asked once")
+	serve("/org1/index.yaml", "other")
+	serve("/org2/index.yaml", "wonderland")
+	suite.Equal(3, calls, "decisions cached by credentials and request")
+}
+
+func TestAuthCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(AuthCacheTestSuite))
+}
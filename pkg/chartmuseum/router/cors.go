@@ -0,0 +1,128 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	pathutil "path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE"}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type"}
+)
+
+type (
+	// corsPolicy lets browser-based dashboards of other origins call the API: it answers the
+	// preflight requests, and sets the CORS headers of the responses to the allowed origins
+	corsPolicy struct {
+		origins     []string
+		methods     string
+		headers     string
+		credentials bool
+		maxAge      time.Duration
+	}
+
+	// corsOptions are the settings of a corsPolicy
+	corsOptions struct {
+		// AllowOrigins are origins, "*" or patterns as path.Match, e.g. https://*.example.com
+		AllowOrigins     []string
+		AllowMethods     []string
+		AllowHeaders     []string
+		AllowCredentials bool
+		MaxAge           time.Duration
+	}
+)
+
+func newCORSPolicy(options corsOptions) (*corsPolicy, error) {
+	for _, origin := range options.AllowOrigins {
+		if _, err := pathutil.Match(origin, ""); err != nil {
+			return nil, fmt.Errorf("allowed CORS origin %q: %w", origin, err)
+		}
+	}
+	if len(options.AllowMethods) == 0 {
+		options.AllowMethods = defaultCORSMethods
+	}
+	if len(options.AllowHeaders) == 0 {
+		options.AllowHeaders = defaultCORSHeaders
+	}
+	return &corsPolicy{
+		origins:     options.AllowOrigins,
+		methods:     strings.ToUpper(strings.Join(options.AllowMethods, ", ")),
+		headers:     strings.Join(options.AllowHeaders, ", "),
+		credentials: options.AllowCredentials,
+		maxAge:      options.MaxAge,
+	}, nil
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin of a request from origin, empty if the
+// origin is not allowed
+func (p *corsPolicy) allowOrigin(origin string) string {
+	if origin == "" {
+		if len(p.origins) == 1 {
+			return p.origins[0] // a single origin is always set, as by the former --cors-alloworigin
+		}
+		return ""
+	}
+	for _, allowed := range p.origins {
+		if allowed == "*" && !p.credentials {
+			return "*"
+		}
+		// with credentials, browsers require the origin itself
+		if matched, _ := pathutil.Match(allowed, origin); matched || allowed == "*" {
+			return origin
+		}
+	}
+	return ""
+}
+
+// setHeaders sets the CORS headers of the response to the request of c
+func (p *corsPolicy) setHeaders(c *gin.Context) {
+	origin := p.allowOrigin(c.GetHeader("Origin"))
+	if origin == "" {
+		return
+	}
+	c.Header("Access-Control-Allow-Origin", origin)
+	if origin != "*" {
+		c.Header("Vary", "Origin")
+	}
+	if p.credentials {
+		c.Header("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight answers the preflight OPTIONS request of c, without authentication as browsers
+// send no credentials with them
+func (p *corsPolicy) preflight(c *gin.Context) {
+	p.setHeaders(c)
+	c.Header("Access-Control-Allow-Methods", p.methods)
+	c.Header("Access-Control-Allow-Headers", p.headers)
+	if p.maxAge > 0 {
+		c.Header("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge.Seconds())))
+	}
+	c.Status(204)
+}
+
+// isPreflight tells whether the request of c is a CORS preflight request
+func isPreflight(c *gin.Context) bool {
+	return c.Request.Method == "OPTIONS" && c.GetHeader("Origin") != "" && c.GetHeader("Access-Control-Request-Method") != ""
+}
@@ -0,0 +1,110 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type CORSTestSuite struct {
+	suite.Suite
+}
+
+func (suite *CORSTestSuite) TestAllowOrigin() {
+	policy, err := newCORSPolicy(corsOptions{AllowOrigins: []string{"https://dashboard.example.com", "https://*.dev.example.com"}})
+	suite.Nil(err)
+	suite.Equal("https://dashboard.example.com", policy.allowOrigin("https://dashboard.example.com"))
+	suite.Equal("https://team-a.dev.example.com", policy.allowOrigin("https://team-a.dev.example.com"), "origin pattern")
+	suite.Equal("", policy.allowOrigin("https://other.example.com"))
+	suite.Equal("", policy.allowOrigin(""), "no origin")
+
+	policy, err = newCORSPolicy(corsOptions{AllowOrigins: []string{"*"}})
+	suite.Nil(err)
+	suite.Equal("*", policy.allowOrigin("https://other.example.com"))
+	suite.Equal("*", policy.allowOrigin(""), "single origin set without origin")
+	policy, err = newCORSPolicy(corsOptions{AllowOrigins: []string{"*"}, AllowCredentials: true})
+	suite.Nil(err)
+	suite.Equal("https://other.example.com", policy.allowOrigin("https://other.example.com"), "origin itself with credentials")
+
+	_, err = newCORSPolicy(corsOptions{AllowOrigins: []string{"https://["}})
+	suite.NotNil(err, "invalid pattern")
+}
+
+func (suite *CORSTestSuite) TestRouter() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	router := NewRouter(RouterOptions{
+		Logger:               log,
+		Depth:                1,
+		Username:             "alice",
+		Password:             "wonderland",
+		CORSAllowOrigin:      "https://dashboard.example.com, https://*.dev.example.com",
+		CORSAllowHeaders:     []string{"Authorization", "Content-Type", "X-Request-Id"},
+		CORSAllowCredentials: true,
+		CORSMaxAge:           10 * time.Minute,
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.Status(200) }, cm_auth.PullAction},
+		{"GET", "/api/:repo/charts", func(c *gin.Context) { c.Status(200) }, cm_auth.PullAction},
+	})
+	serve := func(method string, path string, origin string, setHeaders func(*http.Request)) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, path, nil)
+		request.Header.Set("Origin", origin)
+		setHeaders(request)
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	preflight := func(r *http.Request) { r.Header.Set("Access-Control-Request-Method", "GET") }
+	basicAuth := func(r *http.Request) { r.SetBasicAuth("alice", "wonderland") }
+	none := func(*http.Request) {}
+
+	response := serve("OPTIONS", "/api/org1/charts", "https://team-a.dev.example.com", preflight)
+	suite.Equal(204, response.Code, "preflight without credentials")
+	suite.Equal("https://team-a.dev.example.com", response.Header().Get("Access-Control-Allow-Origin"))
+	suite.Equal("true", response.Header().Get("Access-Control-Allow-Credentials"))
+	suite.Equal("GET, HEAD, POST, PUT, DELETE", response.Header().Get("Access-Control-Allow-Methods"))
+	suite.Equal("Authorization, Content-Type, X-Request-Id", response.Header().Get("Access-Control-Allow-Headers"))
+	suite.Equal("600", response.Header().Get("Access-Control-Max-Age"))
+	response = serve("OPTIONS", "/api/org1/charts", "https://other.example.com", preflight)
+	suite.Equal(204, response.Code)
+	suite.Equal("", response.Header().Get("Access-Control-Allow-Origin"), "origin not allowed")
+
+	response = serve("GET", "/api/org1/charts", "https://dashboard.example.com", basicAuth)
+	suite.Equal(200, response.Code)
+	suite.Equal("https://dashboard.example.com", response.Header().Get("Access-Control-Allow-Origin"))
+	suite.Equal("Origin", response.Header().Get("Vary"))
+	response = serve("GET", "/api/org1/charts", "https://dashboard.example.com", none)
+	suite.Equal(401, response.Code)
+	suite.Equal("https://dashboard.example.com", response.Header().Get("Access-Control-Allow-Origin"), "errors readable by browsers")
+	response = serve("GET", "/org1/index.yaml", "https://dashboard.example.com", basicAuth)
+	suite.Equal(200, response.Code)
+	suite.Equal("", response.Header().Get("Access-Control-Allow-Origin"), "only the API")
+}
+
+func TestCORSTestSuite(t *testing.T) {
+	suite.Run(t, new(CORSTestSuite))
+}
@@ -0,0 +1,66 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	pathutil "path"
+	"strings"
+)
+
+// groupGrant grants actions on the repos matching a pattern to the members of a group
+type groupGrant struct {
+	pattern string
+	actions []string
+}
+
+// parseGroupGrants parses the permissions of groups, each a space separated list of repo patterns
+// and the actions granted on the matching repos, e.g. "org1/*:pull,push org2/charts:pull"
+func parseGroupGrants(groups map[string]string) (map[string][]groupGrant, error) {
+	grants := map[string][]groupGrant{}
+	for group, permissions := range groups {
+		if group == "" {
+			return nil, errors.New("missing group for permissions " + permissions)
+		}
+		for _, permission := range strings.Fields(permissions) {
+			pattern, actions, found := strings.Cut(permission, ":")
+			if !found || pattern == "" || actions == "" {
+				return nil, fmt.Errorf("permission %q of group %s must be repo:actions", permission, group)
+			}
+			if _, err := pathutil.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("permission %q of group %s: %w", permission, group, err)
+			}
+			grants[group] = append(grants[group], groupGrant{pattern: pattern, actions: strings.Split(actions, ",")})
+		}
+	}
+	return grants, nil
+}
+
+// grantedActions returns the actions granted on namespace to groups, "*" matching every repo and
+// other patterns matching as path.Match
+func grantedActions(grants map[string][]groupGrant, groups []string, namespace string) []string {
+	var actions []string
+	for _, group := range groups {
+		for _, grant := range grants[group] {
+			if matched, _ := pathutil.Match(grant.pattern, namespace); matched || grant.pattern == "*" {
+				actions = append(actions, grant.actions...)
+			}
+		}
+	}
+	return actions
+}
@@ -0,0 +1,222 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HMACAlgorithm is the scheme of the Authorization header of signed requests
+	HMACAlgorithm = "CM-HMAC-SHA256"
+
+	// HMACDateHeader, HMACNonceHeader and HMACContentHeader are the headers of signed requests
+	// holding the time of signature, a value unique to the request, and the hex SHA-256 of the body
+	HMACDateHeader    = "X-Chartmuseum-Date"
+	HMACNonceHeader   = "X-Chartmuseum-Nonce"
+	HMACContentHeader = "X-Chartmuseum-Content-Sha256"
+
+	// hmacDateFormat is the ISO 8601 basic format of HMACDateHeader, e.g. 20240102T150405Z
+	hmacDateFormat = "20060102T150405Z"
+
+	defaultHMACSkew = 5 * time.Minute
+)
+
+// hmacRequiredHeaders are the headers every signature must cover
+var hmacRequiredHeaders = []string{"host", strings.ToLower(HMACContentHeader), strings.ToLower(HMACDateHeader), strings.ToLower(HMACNonceHeader)}
+
+type (
+	// hmacAuthenticator authenticates requests signed with the secret of a key, in the style of AWS
+	// Signature Version 4, so that automation never sends the secret itself:
+	//
+	//	Authorization: CM-HMAC-SHA256 Credential=<key id>, SignedHeaders=host;x-chartmuseum-content-sha256;x-chartmuseum-date;x-chartmuseum-nonce, Signature=<hex>
+	//
+	// Replays are rejected by the date, which must be within the allowed skew, and by the nonce,
+	// which is remembered until the date expires
+	hmacAuthenticator struct {
+		keys   map[string][]byte
+		skew   time.Duration
+		lock   sync.Mutex
+		nonces map[string]time.Time
+	}
+)
+
+func newHMACAuthenticator(keys map[string]string, skew time.Duration) (*hmacAuthenticator, error) {
+	if skew == 0 {
+		skew = defaultHMACSkew
+	}
+	authenticator := &hmacAuthenticator{keys: map[string][]byte{}, skew: skew, nonces: map[string]time.Time{}}
+	for id, secret := range keys {
+		if id == "" || secret == "" {
+			return nil, fmt.Errorf("HMAC key %q requires an id and a secret", id)
+		}
+		authenticator.keys[id] = []byte(secret)
+	}
+	return authenticator, nil
+}
+
+// isHMACSigned tells whether a request claims to be signed
+func isHMACSigned(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), HMACAlgorithm+" ")
+}
+
+// authenticate returns the id of the key signing r, or an error telling why the signature is
+// rejected. The body of r is read to check its hash, and replaced
+func (a *hmacAuthenticator) authenticate(r *http.Request) (string, error) {
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), HMACAlgorithm+" "), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[name] = value
+	}
+	id, signedHeaders, signature := fields["Credential"], fields["SignedHeaders"], fields["Signature"]
+	secret, found := a.keys[id]
+	if !found {
+		return "", fmt.Errorf("unknown key %q", id)
+	}
+	headers := strings.Split(signedHeaders, ";")
+	for _, required := range hmacRequiredHeaders {
+		if !containsString(headers, required) {
+			return "", fmt.Errorf("header %s is not signed", required)
+		}
+	}
+
+	date, err := time.Parse(hmacDateFormat, r.Header.Get(HMACDateHeader))
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", HMACDateHeader, err)
+	}
+	if skew := time.Since(date); skew > a.skew || skew < -a.skew {
+		return "", fmt.Errorf("%s is not within %s of the server time", HMACDateHeader, a.skew)
+	}
+	nonce := r.Header.Get(HMACNonceHeader)
+	if nonce == "" {
+		return "", fmt.Errorf("missing %s", HMACNonceHeader)
+	}
+
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return "", err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	sum := sha256.Sum256(body)
+	if !hmac.Equal([]byte(hex.EncodeToString(sum[:])), []byte(r.Header.Get(HMACContentHeader))) {
+		return "", fmt.Errorf("%s does not match the body", HMACContentHeader)
+	}
+	expected := hmacSignature(r, headers, secret)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return "", errors.New("signature does not match")
+	}
+
+	// the nonce is checked last, so that requests with invalid signatures can't use it up
+	if !a.useNonce(id+"/"+nonce, date.Add(a.skew)) {
+		return "", fmt.Errorf("%s was already used", HMACNonceHeader)
+	}
+	return id, nil
+}
+
+// useNonce remembers nonce until expires, and tells whether it was not already used
+func (a *hmacAuthenticator) useNonce(nonce string, expires time.Time) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := time.Now()
+	for used, usedExpires := range a.nonces {
+		if now.After(usedExpires) {
+			delete(a.nonces, used)
+		}
+	}
+	if _, used := a.nonces[nonce]; used {
+		return false
+	}
+	a.nonces[nonce] = expires
+	return true
+}
+
+// hmacSignature is the hex HMAC-SHA256 with secret of the string to sign of r:
+//
+//	CM-HMAC-SHA256
+//	<date>
+//	<hex SHA-256 of the canonical request>
+//
+// the canonical request being the method, path, sorted query, signed headers and body hash
+func hmacSignature(r *http.Request, headers []string, secret []byte) string {
+	sort.Strings(headers)
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		strings.Join(headers, ";"),
+		r.Header.Get(HMACContentHeader),
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := HMACAlgorithm + "\n" + r.Header.Get(HMACDateHeader) + "\n" + hex.EncodeToString(hash[:])
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest signs r with the secret of key id, for servers accepting HMAC keys. The body of r
+// is read to hash it, and replaced
+func SignRequest(r *http.Request, id string, secret string) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+	sum := sha256.Sum256(body)
+	r.Header.Set(HMACContentHeader, hex.EncodeToString(sum[:]))
+	r.Header.Set(HMACDateHeader, time.Now().UTC().Format(hmacDateFormat))
+	r.Header.Set(HMACNonceHeader, hex.EncodeToString(nonce))
+	headers := append([]string{}, hmacRequiredHeaders...)
+	signature := hmacSignature(r, headers, []byte(secret))
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s, SignedHeaders=%s, Signature=%s",
+		HMACAlgorithm, id, strings.Join(headers, ";"), signature))
+	return nil
+}
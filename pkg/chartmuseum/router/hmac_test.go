@@ -0,0 +1,131 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type HMACTestSuite struct {
+	suite.Suite
+}
+
+func (suite *HMACTestSuite) signed(method string, url string, body string, id string, secret string) *http.Request {
+	request, _ := http.NewRequest(method, url, strings.NewReader(body))
+	suite.Nil(SignRequest(request, id, secret))
+	return request
+}
+
+func (suite *HMACTestSuite) TestAuthenticate() {
+	authenticator, err := newHMACAuthenticator(map[string]string{"ci": "secret"}, time.Minute)
+	suite.Nil(err)
+
+	request := suite.signed("POST", "http://charts.example.com/api/org1/charts?force=true", "chart", "ci", "secret")
+	user, err := authenticator.authenticate(request)
+	suite.Nil(err)
+	suite.Equal("ci", user)
+	body, _ := io.ReadAll(request.Body)
+	suite.Equal("chart", string(body), "body still readable")
+	_, err = authenticator.authenticate(suite.signed("POST", "http://charts.example.com/api/org1/charts", "", "ci", "secret"))
+	suite.Nil(err, "empty body")
+
+	request = suite.signed("DELETE", "http://charts.example.com/api/org1/charts/mychart/0.1.0", "", "ci", "secret")
+	_, err = authenticator.authenticate(request)
+	suite.Nil(err)
+	request.Body = http.NoBody
+	_, err = authenticator.authenticate(request)
+	suite.EqualError(err, "X-Chartmuseum-Nonce was already used")
+
+	_, err = authenticator.authenticate(suite.signed("GET", "http://charts.example.com/org1/index.yaml", "", "ci", "wrong"))
+	suite.EqualError(err, "signature does not match")
+	_, err = authenticator.authenticate(suite.signed("GET", "http://charts.example.com/org1/index.yaml", "", "other", "secret"))
+	suite.EqualError(err, `unknown key "other"`)
+
+	request = suite.signed("POST", "http://charts.example.com/api/org1/charts", "chart", "ci", "secret")
+	request.Body = io.NopCloser(strings.NewReader("tampered"))
+	_, err = authenticator.authenticate(request)
+	suite.EqualError(err, "X-Chartmuseum-Content-Sha256 does not match the body")
+
+	request = suite.signed("DELETE", "http://charts.example.com/api/org1/charts/mychart/0.1.0", "", "ci", "secret")
+	request.URL.Path = "/api/org2/charts/mychart/0.1.0"
+	_, err = authenticator.authenticate(request)
+	suite.EqualError(err, "signature does not match", "path signed")
+
+	request = suite.signed("GET", "http://charts.example.com/org1/index.yaml", "", "ci", "secret")
+	request.Header.Set(HMACDateHeader, time.Now().Add(-2*time.Minute).UTC().Format(hmacDateFormat))
+	_, err = authenticator.authenticate(request)
+	suite.EqualError(err, "X-Chartmuseum-Date is not within 1m0s of the server time")
+
+	request = suite.signed("GET", "http://charts.example.com/org1/index.yaml", "", "ci", "secret")
+	request.Header.Set("Authorization", strings.Replace(request.Header.Get("Authorization"), "host;", "", 1))
+	_, err = authenticator.authenticate(request)
+	suite.EqualError(err, "header host is not signed")
+
+	_, err = newHMACAuthenticator(map[string]string{"ci": ""}, 0)
+	suite.NotNil(err, "key without secret")
+}
+
+func (suite *HMACTestSuite) TestRouter() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	router := NewRouter(RouterOptions{
+		Logger:        log,
+		Depth:         1,
+		Username:      "alice",
+		Password:      "wonderland",
+		MaxUploadSize: 1 << 20,
+		AuthHMACKeys:  map[string]string{"ci": "secret"},
+		AuthRoles:     map[string]string{"ci": "org1:write", "alice": "*:admin"},
+	})
+	router.SetRoutes([]*Route{
+		{"POST", "/api/:repo/charts", func(c *gin.Context) {
+			body, _ := io.ReadAll(c.Request.Body)
+			c.String(201, c.GetString("user")+":"+string(body))
+		}, cm_auth.PushAction},
+	})
+	serve := func(request *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := serve(suite.signed("POST", "http://charts.example.com/api/org1/charts", "chart", "ci", "secret"))
+	suite.Equal(201, response.Code)
+	suite.Equal("ci:chart", response.Body.String(), "key id is the user, body passed to the handler")
+	suite.Equal(403, serve(suite.signed("POST", "http://charts.example.com/api/org2/charts", "chart", "ci", "secret")).Code,
+		"roles of the key id")
+	suite.Equal(401, serve(suite.signed("POST", "http://charts.example.com/api/org1/charts", "chart", "ci", "wrong")).Code)
+
+	request, _ := http.NewRequest("POST", "http://charts.example.com/api/org2/charts", http.NoBody)
+	request.SetBasicAuth("alice", "wonderland")
+	suite.Equal(201, serve(request).Code, "basic auth alongside signed requests")
+}
+
+func TestHMACTestSuite(t *testing.T) {
+	suite.Run(t, new(HMACTestSuite))
+}
@@ -0,0 +1,150 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	apr1Magic  = "$apr1$"
+	sha1Prefix = "{SHA}"
+	apr1Itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// htpasswd holds the password hashes of the users of an htpasswd file, by user name
+type htpasswd map[string]string
+
+// loadHtpasswd reads an htpasswd file, with bcrypt (htpasswd -B), Apache MD5 (htpasswd -m, the
+// default) or SHA-1 (htpasswd -s) hashes. Empty lines and lines starting with # are skipped
+func loadHtpasswd(path string) (htpasswd, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	users := htpasswd{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(entry, ":")
+		if !found || user == "" {
+			return nil, fmt.Errorf("%s line %d: expected user:hash", path, line)
+		}
+		if !isBcrypt(hash) && !strings.HasPrefix(hash, apr1Magic) && !strings.HasPrefix(hash, sha1Prefix) {
+			return nil, fmt.Errorf("%s line %d: unsupported hash for user %s, use bcrypt, MD5 or SHA-1", path, line, user)
+		}
+		users[user] = hash
+	}
+	return users, nil
+}
+
+// authenticate tells whether password is the one of user
+func (h htpasswd) authenticate(user string, password string) bool {
+	hash, ok := h[user]
+	if !ok {
+		return false
+	}
+	switch {
+	case isBcrypt(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, apr1Magic):
+		salt, _, _ := strings.Cut(strings.TrimPrefix(hash, apr1Magic), "$")
+		return subtle.ConstantTimeCompare([]byte(apr1(password, salt)), []byte(hash)) == 1
+	default:
+		sum := sha1.Sum([]byte(password))
+		expected := sha1Prefix + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+	}
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// apr1 hashes password with the MD5 based algorithm of Apache, $apr1$salt$checksum
+func apr1(password string, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alternate := md5.Sum([]byte(password + salt + password))
+	h := md5.New()
+	h.Write([]byte(password + apr1Magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			h.Write(alternate[:])
+		} else {
+			h.Write(alternate[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write(pw)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(pw)
+		}
+		sum = h.Sum(nil)
+	}
+
+	var checksum strings.Builder
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			checksum.WriteByte(apr1Itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(sum[i[0]])<<16|uint(sum[i[1]])<<8|uint(sum[i[2]]), 4)
+	}
+	encode(uint(sum[11]), 2)
+	return apr1Magic + salt + "$" + checksum.String()
+}
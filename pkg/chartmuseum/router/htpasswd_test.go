@@ -0,0 +1,117 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	pathutil "path"
+	"testing"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/bcrypt"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type HtpasswdTestSuite struct {
+	suite.Suite
+	File string
+}
+
+func (suite *HtpasswdTestSuite) SetupTest() {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bobpass"), bcrypt.MinCost)
+	suite.Nil(err)
+	suite.File = pathutil.Join(suite.T().TempDir(), "users.htpasswd")
+	content := "# chart maintainers\n" +
+		"alice:$apr1$saltsalt$IUEN5/qU3k/tdA8LSEOVz.\n" +
+		"\n" +
+		"bob:" + string(bcryptHash) + "\n" +
+		"carol:{SHA}IGyAQTualsExLMNGt9JRe4RGPt0=\n"
+	suite.Nil(os.WriteFile(suite.File, []byte(content), 0644))
+}
+
+func (suite *HtpasswdTestSuite) TestAuthenticate() {
+	users, err := loadHtpasswd(suite.File)
+	suite.Nil(err, "no error loading htpasswd file")
+	suite.Len(users, 3)
+
+	suite.True(users.authenticate("alice", "testpass"), "MD5 password")
+	suite.False(users.authenticate("alice", "bobpass"), "wrong MD5 password")
+	suite.True(users.authenticate("bob", "bobpass"), "bcrypt password")
+	suite.False(users.authenticate("bob", "testpass"), "wrong bcrypt password")
+	suite.True(users.authenticate("carol", "testpass"), "SHA-1 password")
+	suite.False(users.authenticate("carol", "bobpass"), "wrong SHA-1 password")
+	suite.False(users.authenticate("dave", "testpass"), "unknown user")
+
+	suite.Equal("$apr1$saltsalt$IUEN5/qU3k/tdA8LSEOVz.", apr1("testpass", "saltsalt"), "same hash as htpasswd")
+}
+
+func (suite *HtpasswdTestSuite) TestInvalid() {
+	_, err := loadHtpasswd(pathutil.Join(suite.T().TempDir(), "missing"))
+	suite.NotNil(err, "missing file")
+
+	suite.Nil(os.WriteFile(suite.File, []byte("alice\n"), 0644))
+	_, err = loadHtpasswd(suite.File)
+	suite.EqualError(err, suite.File+" line 1: expected user:hash")
+
+	suite.Nil(os.WriteFile(suite.File, []byte("# users\nalice:plaintext\n"), 0644))
+	_, err = loadHtpasswd(suite.File)
+	suite.EqualError(err, suite.File+" line 2: unsupported hash for user alice, use bcrypt, MD5 or SHA-1")
+}
+
+func (suite *HtpasswdTestSuite) TestRouter() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	routes := []*Route{
+		{"GET", "/", func(c *gin.Context) { c.Status(200) }, cm_auth.PullAction},
+		{"POST", "/api/charts", func(c *gin.Context) { c.Status(201) }, cm_auth.PushAction},
+		{"GET", "/health", func(c *gin.Context) { c.Status(200) }, ""},
+	}
+	serve := func(router *Router, method string, path string, user string, password string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, path, nil)
+		if user != "" {
+			request.SetBasicAuth(user, password)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	router := NewRouter(RouterOptions{Logger: log, HtpasswdFile: suite.File})
+	router.SetRoutes(routes)
+	suite.Equal(200, serve(router, "GET", "/health", "", "").Code, "no auth on health")
+	response := serve(router, "GET", "/", "", "")
+	suite.Equal(401, response.Code, "anonymous pull")
+	suite.Equal(`Basic realm="ChartMuseum"`, response.Header().Get("WWW-Authenticate"))
+	suite.Equal(401, serve(router, "GET", "/", "alice", "bobpass").Code, "wrong password")
+	suite.Equal(200, serve(router, "GET", "/", "alice", "testpass").Code, "pull")
+	suite.Equal(201, serve(router, "POST", "/api/charts", "bob", "bobpass").Code, "push")
+
+	router = NewRouter(RouterOptions{Logger: log, HtpasswdFile: suite.File, AnonymousGet: true})
+	router.SetRoutes(routes)
+	suite.Equal(200, serve(router, "GET", "/", "", "").Code, "anonymous pull")
+	suite.Equal(401, serve(router, "POST", "/api/charts", "", "").Code, "anonymous push")
+	suite.Equal(201, serve(router, "POST", "/api/charts", "carol", "testpass").Code, "push")
+}
+
+func TestHtpasswdTestSuite(t *testing.T) {
+	suite.Run(t, new(HtpasswdTestSuite))
+}
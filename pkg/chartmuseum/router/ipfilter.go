@@ -0,0 +1,116 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+type (
+	// ipFilter allows or denies requests by the address of their client, before authentication.
+	// The rules of every route are checked first, then the write rules for routes changing repos
+	ipFilter struct {
+		allow      []*net.IPNet
+		deny       []*net.IPNet
+		writeAllow []*net.IPNet
+		writeDeny  []*net.IPNet
+	}
+
+	// ipFilterOptions are the CIDRs or addresses of an ipFilter. A client is denied if it matches
+	// a deny rule, or allow rules are set and it matches none of them
+	ipFilterOptions struct {
+		Allow      []string
+		Deny       []string
+		WriteAllow []string
+		WriteDeny  []string
+	}
+)
+
+func newIPFilter(options ipFilterOptions) (*ipFilter, error) {
+	filter := &ipFilter{}
+	for _, rules := range []struct {
+		cidrs []string
+		nets  *[]*net.IPNet
+	}{
+		{options.Allow, &filter.allow},
+		{options.Deny, &filter.deny},
+		{options.WriteAllow, &filter.writeAllow},
+		{options.WriteDeny, &filter.writeDeny},
+	} {
+		for _, cidr := range rules.cidrs {
+			network, err := parseCIDR(cidr)
+			if err != nil {
+				return nil, err
+			}
+			*rules.nets = append(*rules.nets, network)
+		}
+	}
+	return filter, nil
+}
+
+// parseCIDR parses a CIDR, or a single address
+func parseCIDR(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", cidr)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", cidr)
+	}
+	return network, nil
+}
+
+// allows tells whether a client at address may request a route of action
+func (f *ipFilter) allows(address string, action string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	if !matchesRules(ip, f.allow, f.deny) {
+		return false
+	}
+	if !isWriteAction(action) {
+		return true
+	}
+	return matchesRules(ip, f.writeAllow, f.writeDeny)
+}
+
+func matchesRules(ip net.IP, allow []*net.IPNet, deny []*net.IPNet) bool {
+	if containsIP(deny, ip) {
+		return false
+	}
+	return len(allow) == 0 || containsIP(allow, ip)
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
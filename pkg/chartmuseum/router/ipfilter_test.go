@@ -0,0 +1,100 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type IPFilterTestSuite struct {
+	suite.Suite
+}
+
+func (suite *IPFilterTestSuite) TestAllows() {
+	filter, err := newIPFilter(ipFilterOptions{
+		Deny:       []string{"10.0.0.66", "2001:db8::/32"},
+		WriteAllow: []string{"10.0.0.0/24", "::1"},
+		WriteDeny:  []string{"10.0.0.13"},
+	})
+	suite.Nil(err)
+
+	suite.True(filter.allows("192.168.1.1", cm_auth.PullAction), "every client allowed to read")
+	suite.True(filter.allows("192.168.1.1", ""), "every client allowed routes without action")
+	suite.False(filter.allows("192.168.1.1", cm_auth.PushAction), "client outside write allow rules")
+	suite.True(filter.allows("10.0.0.12", cm_auth.PushAction))
+	suite.True(filter.allows("10.0.0.12", AdminAction))
+	suite.False(filter.allows("10.0.0.13", DeleteAction), "write deny rule")
+	suite.True(filter.allows("10.0.0.13", cm_auth.PullAction))
+	suite.False(filter.allows("10.0.0.66", cm_auth.PullAction), "deny rule")
+	suite.False(filter.allows("2001:db8::1", cm_auth.PullAction), "IPv6 deny rule")
+	suite.True(filter.allows("::1", cm_auth.PushAction), "IPv6 address")
+	suite.False(filter.allows("", cm_auth.PullAction), "unknown address")
+
+	filter, err = newIPFilter(ipFilterOptions{Allow: []string{"10.0.0.0/8"}})
+	suite.Nil(err)
+	suite.True(filter.allows("10.1.2.3", cm_auth.PushAction))
+	suite.False(filter.allows("192.168.1.1", cm_auth.PullAction))
+
+	_, err = newIPFilter(ipFilterOptions{Allow: []string{"10.0.0.0/33"}})
+	suite.EqualError(err, `invalid CIDR "10.0.0.0/33"`)
+	_, err = newIPFilter(ipFilterOptions{Deny: []string{"localhost"}})
+	suite.EqualError(err, `invalid address "localhost"`)
+}
+
+func (suite *IPFilterTestSuite) TestRouter() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	routes := []*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.Status(200) }, cm_auth.PullAction},
+		{"POST", "/api/:repo/charts", func(c *gin.Context) { c.Status(201) }, cm_auth.PushAction},
+	}
+	serve := func(router *Router, method string, path string, remoteAddr string, forwardedFor string) int {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, path, nil)
+		request.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			request.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	router := NewRouter(RouterOptions{Logger: log, Depth: 1, IPWriteAllow: []string{"10.20.0.0/16"}})
+	router.SetRoutes(routes)
+	suite.Equal(200, serve(router, "GET", "/org1/index.yaml", "192.168.1.1:1234", ""))
+	suite.Equal(201, serve(router, "POST", "/api/org1/charts", "10.20.1.1:1234", ""))
+	suite.Equal(403, serve(router, "POST", "/api/org1/charts", "192.168.1.1:1234", ""))
+	suite.Equal(403, serve(router, "POST", "/api/org1/charts", "192.168.1.1:1234", "10.20.1.1"),
+		"X-Forwarded-For ignored without trusted proxies")
+
+	router = NewRouter(RouterOptions{Logger: log, Depth: 1, IPWriteAllow: []string{"10.20.0.0/16"}, IPTrustedProxies: []string{"192.168.1.1"}})
+	router.SetRoutes(routes)
+	suite.Equal(201, serve(router, "POST", "/api/org1/charts", "192.168.1.1:1234", "10.20.1.1"), "X-Forwarded-For of a trusted proxy")
+	suite.Equal(403, serve(router, "POST", "/api/org1/charts", "192.168.1.2:1234", "10.20.1.1"), "X-Forwarded-For of another client")
+}
+
+func TestIPFilterTestSuite(t *testing.T) {
+	suite.Run(t, new(IPFilterTestSuite))
+}
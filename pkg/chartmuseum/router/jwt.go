@@ -0,0 +1,337 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/jmespath/go-jmespath"
+)
+
+const (
+	// jwksRefreshInterval is how long the keys of a JWKS are cached
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefreshInterval limits how often a JWKS is fetched again for tokens signed by an
+	// unknown key
+	jwksMinRefreshInterval = time.Minute
+)
+
+var (
+	bearerTokenPattern = regexp.MustCompile(`(?i)^bearer\s+(.+)$`)
+
+	errMissingToken = errors.New("missing bearer token")
+)
+
+type (
+	// jwtAuthenticator verifies bearer tokens signed with HS256 by a shared secret, or with RS256
+	// by a public key or a key of a JSON Web Key Set, and finds the actions they grant for a repo
+	// in their claims. The tokens of an OpenID Connect provider grant the permissions of the groups
+	// of the user, and may be passed as the password of basic auth for clients such as helm
+	jwtAuthenticator struct {
+		secret            []byte
+		publicKey         *rsa.PublicKey
+		jwks              *jwks
+		issuer            string
+		audience          string
+		actionsSearchPath string
+		realm             string
+		service           string
+		parser            *jwt.Parser
+		groupsClaim       string
+		groupGrants       map[string][]groupGrant
+		basicTokens       bool
+	}
+
+	// jwtOptions are the settings of a jwtAuthenticator
+	jwtOptions struct {
+		Secret        string
+		PublicKeyPath string
+		JWKSURL       string
+		// Issuer and Audience are required in the iss and aud claims when set
+		Issuer   string
+		Audience string
+		// ActionsSearchPath is a JMESPath finding the actions granted for $NAMESPACE in the claims
+		ActionsSearchPath string
+		Realm             string
+		Service           string
+		// OIDCIssuerURL discovers the issuer and the key set of an OpenID Connect provider, whose
+		// tokens are for OIDCClientID if Audience is not set
+		OIDCIssuerURL string
+		OIDCClientID  string
+		// GroupsClaim lists the groups of the user, "groups" if empty
+		GroupsClaim string
+		// Groups are the permissions of groups, in the format of parseGroupGrants
+		Groups map[string]string
+		// BasicTokens accepts tokens as the password of basic auth, as for an OIDC provider
+		BasicTokens bool
+	}
+
+	// jwks fetches the RSA public keys of a JSON Web Key Set, by key id
+	jwks struct {
+		url     string
+		client  *http.Client
+		lock    sync.Mutex
+		keys    map[string]*rsa.PublicKey
+		fetched time.Time
+	}
+)
+
+func newJWTAuthenticator(options jwtOptions) (*jwtAuthenticator, error) {
+	if options.Secret == "" && options.PublicKeyPath == "" && options.JWKSURL == "" && options.OIDCIssuerURL == "" {
+		return nil, errors.New("jwt auth requires a secret, a public key, a JWKS URL or an OIDC issuer URL")
+	}
+	authenticator := &jwtAuthenticator{
+		issuer:            options.Issuer,
+		audience:          options.Audience,
+		actionsSearchPath: options.ActionsSearchPath,
+		realm:             options.Realm,
+		service:           options.Service,
+		groupsClaim:       options.GroupsClaim,
+		basicTokens:       options.BasicTokens,
+	}
+	if authenticator.groupsClaim == "" {
+		authenticator.groupsClaim = defaultGroupsClaim
+	}
+	var err error
+	if authenticator.groupGrants, err = parseGroupGrants(options.Groups); err != nil {
+		return nil, err
+	}
+	if authenticator.actionsSearchPath == "" {
+		authenticator.actionsSearchPath = cm_auth.AllowedActionsSearchPath
+	}
+	authenticator.actionsSearchPath = strings.ReplaceAll(authenticator.actionsSearchPath, "$ACCESS_ENTRY_TYPE", cm_auth.AccessEntryType)
+
+	var methods []string
+	if options.Secret != "" {
+		authenticator.secret = []byte(options.Secret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if options.PublicKeyPath != "" {
+		pem, err := os.ReadFile(options.PublicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		authenticator.publicKey, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", options.PublicKeyPath, err)
+		}
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	if options.OIDCIssuerURL != "" {
+		provider, err := discoverOIDC(client, options.OIDCIssuerURL)
+		if err != nil {
+			return nil, err
+		}
+		if options.JWKSURL == "" {
+			options.JWKSURL = provider.JWKSURI
+		}
+		if authenticator.issuer == "" {
+			authenticator.issuer = provider.Issuer
+		}
+		if authenticator.audience == "" {
+			authenticator.audience = options.OIDCClientID
+		}
+		authenticator.basicTokens = true
+	}
+	if options.JWKSURL != "" {
+		authenticator.jwks = &jwks{url: options.JWKSURL, client: client}
+	}
+	if authenticator.publicKey != nil || authenticator.jwks != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	authenticator.parser = jwt.NewParser(jwt.WithValidMethods(methods))
+	return authenticator, nil
+}
+
+// authorize tells whether the bearer token of authHeader grants action for namespace, and
+// otherwise the WWW-Authenticate header challenging the client
+func (a *jwtAuthenticator) authorize(authHeader string, action string, namespace string) (bool, string) {
+	claims, err := a.verify(authHeader)
+	if err == errMissingToken {
+		// clients without a token get it from the realm, following the challenge
+		return false, a.challenge(namespace, action, "")
+	} else if err != nil {
+		return false, a.challenge(namespace, action, "invalid_token")
+	}
+	if !containsString(a.actions(claims, namespace), action) && !containsString(a.groupActions(claims, namespace), action) {
+		return false, a.challenge(namespace, action, "insufficient_scope")
+	}
+	return true, ""
+}
+
+// verify checks the signature, the expiry, the issuer and the audience of a bearer token, and
+// returns its claims
+func (a *jwtAuthenticator) verify(authHeader string) (jwt.MapClaims, error) {
+	var token string
+	if match := bearerTokenPattern.FindStringSubmatch(authHeader); match != nil {
+		token = match[1]
+	} else if a.basicTokens {
+		request := http.Request{Header: http.Header{"Authorization": {authHeader}}}
+		_, token, _ = request.BasicAuth()
+	}
+	if token == "" {
+		return nil, errMissingToken
+	}
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(token, claims, a.key); err != nil {
+		return nil, err
+	}
+	if a.issuer != "" && !claims.VerifyIssuer(a.issuer, true) {
+		return nil, errors.New("unexpected issuer")
+	}
+	if a.audience != "" && !claims.VerifyAudience(a.audience, true) {
+		return nil, errors.New("unexpected audience")
+	}
+	return claims, nil
+}
+
+// key returns the key verifying the signature of token
+func (a *jwtAuthenticator) key(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		if a.secret == nil {
+			return nil, errors.New("no secret")
+		}
+		return a.secret, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	if a.jwks != nil && (kid != "" || a.publicKey == nil) {
+		return a.jwks.key(kid)
+	}
+	return a.publicKey, nil
+}
+
+// actions returns the actions granted by claims for namespace
+func (a *jwtAuthenticator) actions(claims jwt.MapClaims, namespace string) []string {
+	// namespace is quoted in a raw string literal of the search path
+	path := strings.ReplaceAll(a.actionsSearchPath, "$NAMESPACE", strings.ReplaceAll(namespace, "'", `\'`))
+	result, err := jmespath.Search(path, map[string]interface{}(claims))
+	if err != nil {
+		return nil
+	}
+	values, _ := result.([]interface{})
+	actions := make([]string, 0, len(values))
+	for _, value := range values {
+		if action, ok := value.(string); ok {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+func (a *jwtAuthenticator) challenge(namespace string, action string, reason string) string {
+	params := []string{}
+	if a.realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", a.realm))
+	}
+	if a.service != "" {
+		params = append(params, fmt.Sprintf("service=%q", a.service))
+	}
+	params = append(params, fmt.Sprintf("scope=%q", fmt.Sprintf("%s:%s:%s", cm_auth.AccessEntryType, namespace, action)))
+	if reason != "" {
+		params = append(params, fmt.Sprintf("error=%q", reason))
+	}
+	return "Bearer " + strings.Join(params, ",")
+}
+
+// key returns the public key of kid, fetching the key set when its keys are older than
+// jwksRefreshInterval, or when kid is unknown and they are older than jwksMinRefreshInterval
+func (k *jwks) key(kid string) (*rsa.PublicKey, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	key, found := k.keys[kid]
+	age := time.Since(k.fetched)
+	if found && age < jwksRefreshInterval {
+		return key, nil
+	}
+	if !found && age < jwksMinRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	keys, err := k.fetch()
+	k.fetched = time.Now()
+	if err != nil {
+		if found {
+			return key, nil // keep the cached keys while the key set is unavailable
+		}
+		return nil, err
+	}
+	k.keys = keys
+	if key, found = keys[kid]; !found {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+func (k *jwks) fetch() (map[string]*rsa.PublicKey, error) {
+	response, err := k.client.Get(k.url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", k.url, response.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", k.url, err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
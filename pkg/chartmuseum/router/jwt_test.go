@@ -0,0 +1,197 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type JWTTestSuite struct {
+	suite.Suite
+	PrivateKey *rsa.PrivateKey
+	JWKS       *httptest.Server
+	JWKSHits   int
+	Logger     *cm_logger.Logger
+}
+
+func (suite *JWTTestSuite) SetupTest() {
+	pem, err := os.ReadFile(testPrivateKey)
+	suite.Nil(err)
+	suite.PrivateKey, err = jwt.ParseRSAPrivateKeyFromPEM(pem)
+	suite.Nil(err)
+	suite.JWKSHits = 0
+	suite.JWKS = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.JWKSHits++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(suite.PrivateKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(suite.PrivateKey.E)).Bytes()),
+			}},
+		})
+	}))
+	suite.Logger, err = cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+}
+
+func (suite *JWTTestSuite) TearDownTest() {
+	suite.JWKS.Close()
+}
+
+func (suite *JWTTestSuite) token(method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	suite.Nil(err, "no error signing token")
+	return "Bearer " + signed
+}
+
+func access(name string, actions ...string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"exp":    time.Now().Add(time.Minute).Unix(),
+		"access": []interface{}{map[string]interface{}{"type": cm_auth.AccessEntryType, "name": name, "actions": actions}},
+	}
+}
+
+func (suite *JWTTestSuite) TestSecret() {
+	authenticator, err := newJWTAuthenticator(jwtOptions{Secret: "secret", Realm: "https://auth.example.com/token"})
+	suite.Nil(err, "no error creating authenticator")
+
+	header := suite.token(jwt.SigningMethodHS256, []byte("secret"), "", access("org1/repo1", "pull", "push"))
+	allowed, _ := authenticator.authorize(header, cm_auth.PushAction, "org1/repo1")
+	suite.True(allowed, "push granted")
+	allowed, challenge := authenticator.authorize(header, DeleteAction, "org1/repo1")
+	suite.False(allowed, "delete not granted")
+	suite.Equal(`Bearer realm="https://auth.example.com/token",scope="artifact-repository:org1/repo1:delete",error="insufficient_scope"`, challenge)
+	allowed, _ = authenticator.authorize(header, cm_auth.PullAction, "org1/repo2")
+	suite.False(allowed, "other repo")
+
+	allowed, challenge = authenticator.authorize(suite.token(jwt.SigningMethodHS256, []byte("other"), "", access("org1/repo1", "pull")), cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "wrong secret")
+	suite.Contains(challenge, `error="invalid_token"`)
+
+	expired := access("org1/repo1", "pull")
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	allowed, _ = authenticator.authorize(suite.token(jwt.SigningMethodHS256, []byte("secret"), "", expired), cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "expired token")
+
+	allowed, _ = authenticator.authorize(suite.token(jwt.SigningMethodRS256, suite.PrivateKey, "", access("org1/repo1", "pull")), cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "RS256 without public key")
+	allowed, _ = authenticator.authorize("Basic dXNlcjpwYXNz", cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "not a bearer token")
+	allowed, _ = authenticator.authorize(suite.token(jwt.SigningMethodHS256, []byte("secret"), "", access("x' || 'y", "pull")), cm_auth.PullAction, "x' || 'y")
+	suite.True(allowed, "quote in repo name escaped")
+}
+
+func (suite *JWTTestSuite) TestJWKS() {
+	authenticator, err := newJWTAuthenticator(jwtOptions{JWKSURL: suite.JWKS.URL, Issuer: "https://ci.example.com", Audience: "chartmuseum"})
+	suite.Nil(err)
+
+	claims := access("org1/repo1", "pull")
+	claims["iss"] = "https://ci.example.com"
+	claims["aud"] = "chartmuseum"
+	allowed, _ := authenticator.authorize(suite.token(jwt.SigningMethodRS256, suite.PrivateKey, "key1", claims), cm_auth.PullAction, "org1/repo1")
+	suite.True(allowed, "token signed by key of the key set")
+	allowed, _ = authenticator.authorize(suite.token(jwt.SigningMethodRS256, suite.PrivateKey, "key1", claims), cm_auth.PullAction, "org1/repo1")
+	suite.True(allowed)
+	suite.Equal(1, suite.JWKSHits, "keys cached")
+
+	allowed, _ = authenticator.authorize(suite.token(jwt.SigningMethodRS256, suite.PrivateKey, "key2", claims), cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "unknown key")
+	suite.Equal(1, suite.JWKSHits, "key set not fetched again right away")
+
+	claims["aud"] = "other"
+	allowed, _ = authenticator.authorize(suite.token(jwt.SigningMethodRS256, suite.PrivateKey, "key1", claims), cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "wrong audience")
+	claims["aud"] = "chartmuseum"
+	claims["iss"] = "https://other.example.com"
+	allowed, _ = authenticator.authorize(suite.token(jwt.SigningMethodRS256, suite.PrivateKey, "key1", claims), cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "wrong issuer")
+	allowed, _ = authenticator.authorize(suite.token(jwt.SigningMethodHS256, []byte(""), "", access("org1/repo1", "pull")), cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "HS256 without secret")
+
+	authenticator, err = newJWTAuthenticator(jwtOptions{PublicKeyPath: testPublicKey, JWKSURL: suite.JWKS.URL})
+	suite.Nil(err)
+	allowed, _ = authenticator.authorize(suite.token(jwt.SigningMethodRS256, suite.PrivateKey, "", access("org1/repo1", "pull")), cm_auth.PullAction, "org1/repo1")
+	suite.True(allowed, "public key for tokens without kid")
+}
+
+func (suite *JWTTestSuite) TestInvalid() {
+	_, err := newJWTAuthenticator(jwtOptions{Audience: "chartmuseum"})
+	suite.EqualError(err, "jwt auth requires a secret, a public key, a JWKS URL or an OIDC issuer URL")
+	_, err = newJWTAuthenticator(jwtOptions{PublicKeyPath: testPrivateKey + ".missing"})
+	suite.NotNil(err, "missing public key")
+}
+
+func (suite *JWTTestSuite) TestRouter() {
+	routes := []*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.Status(200) }, cm_auth.PullAction},
+		{"DELETE", "/api/:repo/charts/:name/:version", func(c *gin.Context) { c.Status(200) }, DeleteAction},
+	}
+	serve := func(router *Router, method string, path string, header string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, path, nil)
+		request.Header.Set("Authorization", header)
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	router := NewRouter(RouterOptions{Logger: suite.Logger, Depth: 2, BearerAuth: true, AuthJWTSecret: "secret"})
+	router.SetRoutes(routes)
+	push := suite.token(jwt.SigningMethodHS256, []byte("secret"), "", access("org1/repo1", "pull", "push"))
+	suite.Equal(200, serve(router, "GET", "/org1/repo1/index.yaml", push).Code, "pull")
+	response := serve(router, "DELETE", "/api/org1/repo1/charts/mychart/0.1.0", push)
+	suite.Equal(401, response.Code, "delete requires the delete action")
+	suite.Contains(response.Header().Get("WWW-Authenticate"), "artifact-repository:org1/repo1:delete")
+	del := suite.token(jwt.SigningMethodHS256, []byte("secret"), "", access("org1/repo1", "delete"))
+	suite.Equal(200, serve(router, "DELETE", "/api/org1/repo1/charts/mychart/0.1.0", del).Code, "delete")
+
+	router = NewRouter(RouterOptions{
+		Logger:       suite.Logger,
+		Depth:        2,
+		BearerAuth:   true,
+		AuthRealm:    "https://my.site.io/oauth2/token",
+		AuthService:  "my.site.io",
+		AuthCertPath: testPublicKey,
+	})
+	router.SetRoutes(routes)
+	push = suite.token(jwt.SigningMethodRS256, suite.PrivateKey, "", access("org1/repo1", "push"))
+	suite.Equal(200, serve(router, "DELETE", "/api/org1/repo1/charts/mychart/0.1.0", push).Code, "push deletes without jwt options")
+}
+
+func TestJWTTestSuite(t *testing.T) {
+	suite.Run(t, new(JWTTestSuite))
+}
@@ -0,0 +1,231 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultLDAPUserFilter     = "(uid=%s)"
+	defaultLDAPGroupFilter    = "(member=%s)"
+	defaultLDAPGroupAttribute = "cn"
+	defaultLDAPCacheTTL       = 5 * time.Minute
+)
+
+type (
+	// LDAPOptions are the settings of the LDAP bind authentication of basic auth users
+	LDAPOptions struct {
+		// URL of the directory, i.e. ldaps://ldap.example.com:636 or ldap://ldap.example.com:389
+		URL string
+		// StartTLS upgrades a ldap:// connection to TLS
+		StartTLS bool
+		// CACertFile verifies the certificate of the directory, instead of the system roots
+		CACertFile string
+		// BindDN and BindPassword are the service account searching the users and their groups,
+		// the searches are anonymous if BindDN is empty
+		BindDN       string
+		BindPassword string
+		UserBaseDN   string
+		// UserFilter finds the user logging in, its %s replaced by the escaped username
+		UserFilter  string
+		GroupBaseDN string
+		// GroupFilter finds the groups of the user, its %s replaced by the escaped DN of the user
+		GroupFilter string
+		// GroupAttribute is the attribute of groups naming them in Groups
+		GroupAttribute string
+		// Groups are the permissions of groups, in the format of parseGroupGrants. Every user of
+		// the directory is allowed when empty
+		Groups map[string]string
+		// CacheTTL is how long successful logins are cached
+		CacheTTL time.Duration
+	}
+
+	// ldapAuthenticator authenticates basic auth users with a bind to an LDAP directory or Active
+	// Directory, and grants them the permissions of their groups
+	ldapAuthenticator struct {
+		options LDAPOptions
+		grants  map[string][]groupGrant
+		dial    func() (ldap.Client, error)
+		lock    sync.Mutex
+		cache   map[string]ldapLogin
+	}
+
+	// ldapLogin is a cached successful login
+	ldapLogin struct {
+		password [sha256.Size]byte
+		groups   []string
+		expires  time.Time
+	}
+)
+
+func newLDAPAuthenticator(options LDAPOptions) (*ldapAuthenticator, error) {
+	if options.UserBaseDN == "" {
+		return nil, errors.New("ldap auth requires a user base DN")
+	}
+	if len(options.Groups) > 0 && options.GroupBaseDN == "" {
+		return nil, errors.New("ldap group permissions require a group base DN")
+	}
+	if options.UserFilter == "" {
+		options.UserFilter = defaultLDAPUserFilter
+	}
+	if options.GroupFilter == "" {
+		options.GroupFilter = defaultLDAPGroupFilter
+	}
+	if options.GroupAttribute == "" {
+		options.GroupAttribute = defaultLDAPGroupAttribute
+	}
+	if options.CacheTTL == 0 {
+		options.CacheTTL = defaultLDAPCacheTTL
+	}
+	grants, err := parseGroupGrants(options.Groups)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{}
+	if options.CACertFile != "" {
+		pem, err := os.ReadFile(options.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", options.CACertFile)
+		}
+	}
+	authenticator := &ldapAuthenticator{options: options, grants: grants, cache: map[string]ldapLogin{}}
+	authenticator.dial = func() (ldap.Client, error) {
+		conn, err := ldap.DialURL(options.URL, ldap.DialWithTLSConfig(tlsConfig))
+		if err != nil {
+			return nil, err
+		}
+		if options.StartTLS {
+			if err := conn.StartTLS(tlsConfig); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+	return authenticator, nil
+}
+
+// authorize tells whether the directory authenticates user with password, and their groups grant
+// action on namespace when action is set. The error is set when the directory could not be searched
+func (a *ldapAuthenticator) authorize(user string, password string, action string, namespace string) (bool, error) {
+	groups, found, err := a.authenticate(user, password)
+	if err != nil || !found {
+		return false, err
+	}
+	return a.allows(groups, action, namespace), nil
+}
+
+// authenticate tells whether the directory authenticates user with password, and returns their
+// groups. The error is set when the directory could not be searched
+func (a *ldapAuthenticator) authenticate(user string, password string) ([]string, bool, error) {
+	if user == "" || password == "" {
+		// an empty password would be an unauthenticated bind, which directories accept
+		return nil, false, nil
+	}
+	if groups, found := a.cached(user, password); found {
+		return groups, true, nil
+	}
+	groups, found, err := a.login(user, password)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	a.lock.Lock()
+	a.cache[user] = ldapLogin{password: sha256.Sum256([]byte(password)), groups: groups, expires: time.Now().Add(a.options.CacheTTL)}
+	a.lock.Unlock()
+	return groups, true, nil
+}
+
+// allows tells whether groups are granted action on namespace, every action being granted
+// without group permissions
+func (a *ldapAuthenticator) allows(groups []string, action string, namespace string) bool {
+	return len(a.grants) == 0 || action == "" || containsString(grantedActions(a.grants, groups, namespace), action)
+}
+
+func (a *ldapAuthenticator) cached(user string, password string) ([]string, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	login, found := a.cache[user]
+	if !found || time.Now().After(login.expires) || login.password != sha256.Sum256([]byte(password)) {
+		return nil, false
+	}
+	return login.groups, true
+}
+
+// login binds as user to check their password, and returns their groups
+func (a *ldapAuthenticator) login(user string, password string) ([]string, bool, error) {
+	conn, err := a.dial()
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	if a.options.BindDN != "" {
+		if err := conn.Bind(a.options.BindDN, a.options.BindPassword); err != nil {
+			return nil, false, fmt.Errorf("ldap bind as %s: %w", a.options.BindDN, err)
+		}
+	}
+	users, err := conn.Search(ldap.NewSearchRequest(a.options.UserBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, 0, false, fmt.Sprintf(a.options.UserFilter, ldap.EscapeFilter(user)), []string{"dn"}, nil))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, false, fmt.Errorf("ldap search of user %s: %w", user, err)
+	}
+	if users == nil || len(users.Entries) != 1 {
+		return nil, false, nil
+	}
+	userDN := users.Entries[0].DN
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("ldap bind as %s: %w", userDN, err)
+	}
+	if len(a.grants) == 0 {
+		return nil, true, nil
+	}
+
+	// groups are searched as the service account, users may not be allowed to read them
+	if a.options.BindDN != "" {
+		if err := conn.Bind(a.options.BindDN, a.options.BindPassword); err != nil {
+			return nil, false, fmt.Errorf("ldap bind as %s: %w", a.options.BindDN, err)
+		}
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(a.options.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, fmt.Sprintf(a.options.GroupFilter, ldap.EscapeFilter(userDN)), []string{a.options.GroupAttribute}, nil))
+	if err != nil {
+		return nil, false, fmt.Errorf("ldap search of the groups of %s: %w", userDN, err)
+	}
+	groups := make([]string, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		groups = append(groups, entry.GetAttributeValues(a.options.GroupAttribute)...)
+	}
+	return groups, true, nil
+}
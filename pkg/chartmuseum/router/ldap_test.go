@@ -0,0 +1,200 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+// fakeDirectory is an LDAP directory of users, by DN, with their passwords and groups
+type fakeDirectory struct {
+	ldap.Client
+	passwords map[string]string
+	groups    map[string][]string
+	binds     int
+	down      bool
+}
+
+func (d *fakeDirectory) Bind(username, password string) error {
+	d.binds++
+	if d.down {
+		return ldap.NewError(ldap.ErrorNetwork, errors.New("connection refused"))
+	}
+	if expected, found := d.passwords[username]; !found || expected != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+func (d *fakeDirectory) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	result := &ldap.SearchResult{}
+	if strings.HasPrefix(request.Filter, "(uid=") {
+		uid := strings.TrimSuffix(strings.TrimPrefix(request.Filter, "(uid="), ")")
+		if _, found := d.passwords["uid="+uid+",ou=people,dc=example,dc=com"]; found {
+			result.Entries = append(result.Entries, ldap.NewEntry("uid="+uid+",ou=people,dc=example,dc=com", nil))
+		}
+		return result, nil
+	}
+	member := strings.TrimSuffix(strings.TrimPrefix(request.Filter, "(member="), ")")
+	for _, group := range d.groups[member] {
+		result.Entries = append(result.Entries, ldap.NewEntry("cn="+group+",ou=groups,dc=example,dc=com",
+			map[string][]string{"cn": {group}}))
+	}
+	return result, nil
+}
+
+func (d *fakeDirectory) Close() error {
+	return nil
+}
+
+type LDAPTestSuite struct {
+	suite.Suite
+	Directory *fakeDirectory
+}
+
+func (suite *LDAPTestSuite) SetupTest() {
+	suite.Directory = &fakeDirectory{
+		passwords: map[string]string{
+			"cn=chartmuseum,dc=example,dc=com":      "service",
+			"uid=alice,ou=people,dc=example,dc=com": "wonderland",
+			"uid=bob,ou=people,dc=example,dc=com":   "builder",
+		},
+		groups: map[string][]string{
+			"uid=alice,ou=people,dc=example,dc=com": {"developers"},
+		},
+	}
+}
+
+func (suite *LDAPTestSuite) authenticator(groups map[string]string) *ldapAuthenticator {
+	authenticator, err := newLDAPAuthenticator(LDAPOptions{
+		URL:          "ldap://ldap.example.com",
+		BindDN:       "cn=chartmuseum,dc=example,dc=com",
+		BindPassword: "service",
+		UserBaseDN:   "ou=people,dc=example,dc=com",
+		GroupBaseDN:  "ou=groups,dc=example,dc=com",
+		Groups:       groups,
+	})
+	suite.Nil(err, "no error creating authenticator")
+	authenticator.dial = func() (ldap.Client, error) { return suite.Directory, nil }
+	return authenticator
+}
+
+func (suite *LDAPTestSuite) TestGroups() {
+	authenticator := suite.authenticator(map[string]string{"developers": "org1/*:pull,push"})
+
+	allowed, err := authenticator.authorize("alice", "wonderland", cm_auth.PushAction, "org1/repo1")
+	suite.Nil(err)
+	suite.True(allowed, "push granted by group")
+	allowed, _ = authenticator.authorize("alice", "wonderland", DeleteAction, "org1/repo1")
+	suite.False(allowed, "delete not granted")
+	allowed, _ = authenticator.authorize("alice", "wonderland", cm_auth.PullAction, "org2/repo1")
+	suite.False(allowed, "other repo")
+	allowed, _ = authenticator.authorize("bob", "builder", cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "user without groups")
+
+	allowed, err = authenticator.authorize("alice", "wrong", cm_auth.PullAction, "org1/repo1")
+	suite.Nil(err, "invalid credentials are no error")
+	suite.False(allowed, "wrong password")
+	allowed, _ = authenticator.authorize("alice", "", cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "empty password")
+	allowed, _ = authenticator.authorize("carol", "wonderland", cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "unknown user")
+	allowed, _ = authenticator.authorize("*", "wonderland", cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "username escaped in filter")
+}
+
+func (suite *LDAPTestSuite) TestCache() {
+	authenticator := suite.authenticator(nil)
+
+	allowed, err := authenticator.authorize("bob", "builder", cm_auth.PushAction, "any")
+	suite.Nil(err)
+	suite.True(allowed, "every user allowed without group permissions")
+	binds := suite.Directory.binds
+	allowed, _ = authenticator.authorize("bob", "builder", cm_auth.PushAction, "any")
+	suite.True(allowed)
+	suite.Equal(binds, suite.Directory.binds, "login cached")
+
+	allowed, _ = authenticator.authorize("bob", "other", cm_auth.PushAction, "any")
+	suite.False(allowed, "cached login requires the same password")
+	suite.Greater(suite.Directory.binds, binds)
+
+	suite.Directory.down = true
+	_, err = authenticator.authorize("alice", "wonderland", cm_auth.PullAction, "any")
+	suite.NotNil(err, "directory unavailable")
+}
+
+func (suite *LDAPTestSuite) TestInvalid() {
+	_, err := newLDAPAuthenticator(LDAPOptions{URL: "ldap://ldap.example.com"})
+	suite.EqualError(err, "ldap auth requires a user base DN")
+	_, err = newLDAPAuthenticator(LDAPOptions{URL: "ldap://ldap.example.com", UserBaseDN: "dc=example,dc=com",
+		Groups: map[string]string{"developers": "*:pull"}})
+	suite.EqualError(err, "ldap group permissions require a group base DN")
+	_, err = newLDAPAuthenticator(LDAPOptions{URL: "ldap://ldap.example.com", UserBaseDN: "dc=example,dc=com",
+		CACertFile: testPublicKey + ".missing"})
+	suite.NotNil(err, "missing CA certificate")
+}
+
+func (suite *LDAPTestSuite) TestRouter() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	router := NewRouter(RouterOptions{
+		Logger: log,
+		Depth:  1,
+		AuthLDAP: LDAPOptions{
+			URL:         "ldap://ldap.example.com",
+			UserBaseDN:  "ou=people,dc=example,dc=com",
+			GroupBaseDN: "ou=groups,dc=example,dc=com",
+			Groups:      map[string]string{"developers": "team-a:pull"},
+		},
+	})
+	router.ldap.dial = func() (ldap.Client, error) { return suite.Directory, nil }
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.Status(200) }, cm_auth.PullAction},
+	})
+	serve := func(user string, password string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/team-a/index.yaml", nil)
+		if user != "" {
+			request.SetBasicAuth(user, password)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	response := serve("", "")
+	suite.Equal(401, response.Code)
+	suite.Equal(`Basic realm="ChartMuseum"`, response.Header().Get("WWW-Authenticate"))
+	suite.Equal(200, serve("alice", "wonderland").Code)
+	suite.Equal(401, serve("bob", "builder").Code)
+
+	suite.Directory.down = true
+	suite.Equal(500, serve("alice", "changed").Code, "directory unavailable")
+}
+
+func TestLDAPTestSuite(t *testing.T) {
+	suite.Run(t, new(LDAPTestSuite))
+}
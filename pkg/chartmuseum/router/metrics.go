@@ -0,0 +1,46 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Number of requests being served
+	inFlightRequests int64
+
+	inFlightRequestsGauge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "chartmuseum",
+			Name:      "requests_in_flight",
+			Help:      "Current number of requests being served",
+		},
+		func() float64 { return float64(InFlightRequests()) },
+	)
+)
+
+// InFlightRequests returns the number of requests currently being served
+func InFlightRequests() int64 {
+	return atomic.LoadInt64(&inFlightRequests)
+}
+
+func init() {
+	prometheus.MustRegister(inFlightRequestsGauge)
+}
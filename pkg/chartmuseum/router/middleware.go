@@ -36,6 +36,9 @@ var (
 
 func requestWrapper(logger *cm_logger.Logger, logHealth bool, logLatencyInt bool) func(c *gin.Context) {
 	return func(c *gin.Context) {
+		atomic.AddInt64(&inFlightRequests, 1)
+		defer atomic.AddInt64(&inFlightRequests, -1)
+
 		setupContext(c)
 
 		reqPath := c.Request.URL.EscapedPath()
@@ -0,0 +1,219 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	pathutil "path"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+type (
+	// clientCertVerifier checks the client certificates verified against the CA certificate during
+	// the TLS handshake: their names against an allowlist, and their revocation in CRLs or with the
+	// OCSP responders of their issuers
+	clientCertVerifier struct {
+		allowedNames []string
+		crls         []*x509.RevocationList
+		ocsp         bool
+		client       *http.Client
+		lock         sync.Mutex
+		// ocspCache holds the OCSP responses of certificates until their next update, by serial
+		ocspCache map[string]*ocsp.Response
+	}
+
+	// clientCertOptions are the settings of a clientCertVerifier
+	clientCertOptions struct {
+		// AllowedNames are patterns matching the CN or a SAN of allowed certificates as path.Match,
+		// every certificate signed by the CA is allowed if empty
+		AllowedNames []string
+		// CRLFile holds PEM or DER encoded CRLs of the CA
+		CRLFile string
+		OCSP    bool
+	}
+)
+
+func newClientCertVerifier(options clientCertOptions) (*clientCertVerifier, error) {
+	verifier := &clientCertVerifier{
+		allowedNames: options.AllowedNames,
+		ocsp:         options.OCSP,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		ocspCache:    map[string]*ocsp.Response{},
+	}
+	for _, pattern := range options.AllowedNames {
+		if _, err := pathutil.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("allowed client certificate name %q: %w", pattern, err)
+		}
+	}
+	if options.CRLFile != "" {
+		content, err := os.ReadFile(options.CRLFile)
+		if err != nil {
+			return nil, err
+		}
+		if verifier.crls, err = parseCRLs(content); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", options.CRLFile, err)
+		}
+	}
+	return verifier, nil
+}
+
+// parseCRLs parses a DER encoded CRL, or a list of PEM encoded ones
+func parseCRLs(content []byte) ([]*x509.RevocationList, error) {
+	if !bytes.Contains(content, []byte("-----BEGIN")) {
+		crl, err := x509.ParseRevocationList(content)
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.RevocationList{crl}, nil
+	}
+	var crls []*x509.RevocationList
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) == 0 {
+		return nil, errors.New("no X509 CRL")
+	}
+	return crls, nil
+}
+
+// verifyConnection is the tls.Config VerifyConnection of the server, run after the client
+// certificate was verified against the CA certificate
+func (v *clientCertVerifier) verifyConnection(state tls.ConnectionState) error {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return errors.New("no verified client certificate")
+	}
+	chain := state.VerifiedChains[0]
+	cert := chain[0]
+	if !v.allowed(cert) {
+		return fmt.Errorf("client certificate %q is not allowed", clientIdentity(cert))
+	}
+	if len(chain) < 2 {
+		return nil // the CA certificate itself
+	}
+	issuer := chain[1]
+	for _, crl := range v.crls {
+		if crl.CheckSignatureFrom(issuer) != nil {
+			continue // the CRL of another CA
+		}
+		for _, revoked := range crl.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("client certificate %q is revoked", clientIdentity(cert))
+			}
+		}
+	}
+	if v.ocsp && len(cert.OCSPServer) > 0 {
+		response, err := v.ocspResponse(cert, issuer)
+		if err != nil {
+			return fmt.Errorf("checking the revocation of client certificate %q: %w", clientIdentity(cert), err)
+		}
+		if response.Status != ocsp.Good {
+			return fmt.Errorf("client certificate %q is revoked", clientIdentity(cert))
+		}
+	}
+	return nil
+}
+
+// allowed tells whether the CN or a SAN of cert matches an allowed name
+func (v *clientCertVerifier) allowed(cert *x509.Certificate) bool {
+	if len(v.allowedNames) == 0 {
+		return true
+	}
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	for _, pattern := range v.allowedNames {
+		for _, name := range names {
+			if matched, _ := pathutil.Match(pattern, name); matched && name != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ocspResponse asks the first OCSP responder of cert for its status, cached until the next update
+func (v *clientCertVerifier) ocspResponse(cert *x509.Certificate, issuer *x509.Certificate) (*ocsp.Response, error) {
+	serial := cert.SerialNumber.String()
+	v.lock.Lock()
+	cached, found := v.ocspCache[serial]
+	v.lock.Unlock()
+	if found && time.Now().Before(cached.NextUpdate) {
+		return cached, nil
+	}
+
+	request, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpResponse, err := v.client.Post(cert.OCSPServer[0], "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", cert.OCSPServer[0], httpResponse.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResponse.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	response, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if !response.NextUpdate.IsZero() {
+		v.lock.Lock()
+		v.ocspCache[serial] = response
+		v.lock.Unlock()
+	}
+	return response, nil
+}
+
+// clientIdentity is the name of the client of a certificate: its CN, or its first SAN
+func clientIdentity(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
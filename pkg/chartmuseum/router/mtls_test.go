@@ -0,0 +1,234 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	pathutil "path"
+	"testing"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/ocsp"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type MTLSTestSuite struct {
+	suite.Suite
+	CA         *x509.Certificate
+	CAKey      crypto.Signer
+	OCSP       *httptest.Server
+	OCSPHits   int
+	Revoked    *big.Int
+	NextSerial int64
+}
+
+func (suite *MTLSTestSuite) SetupTest() {
+	suite.CA, suite.CAKey = suite.certificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil, nil)
+	suite.OCSPHits = 0
+	suite.Revoked = nil
+	suite.OCSP = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.OCSPHits++
+		body, _ := io.ReadAll(r.Body)
+		request, err := ocsp.ParseRequest(body)
+		suite.Nil(err)
+		status := ocsp.Good
+		if suite.Revoked != nil && request.SerialNumber.Cmp(suite.Revoked) == 0 {
+			status = ocsp.Revoked
+		}
+		response, err := ocsp.CreateResponse(suite.CA, suite.CA, ocsp.Response{
+			Status:       status,
+			SerialNumber: request.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, suite.CAKey)
+		suite.Nil(err)
+		w.Write(response)
+	}))
+}
+
+func (suite *MTLSTestSuite) TearDownTest() {
+	suite.OCSP.Close()
+}
+
+// certificate creates a certificate from template, signed by parent, or self-signed if nil
+func (suite *MTLSTestSuite) certificate(template *x509.Certificate, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Nil(err)
+	suite.NextSerial++
+	template.SerialNumber = big.NewInt(suite.NextSerial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	suite.Nil(err)
+	cert, err := x509.ParseCertificate(der)
+	suite.Nil(err)
+	return cert, key
+}
+
+func (suite *MTLSTestSuite) client(template *x509.Certificate) (*x509.Certificate, crypto.Signer) {
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	return suite.certificate(template, suite.CA, suite.CAKey)
+}
+
+func (suite *MTLSTestSuite) state(cert *x509.Certificate) tls.ConnectionState {
+	return tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert, suite.CA}},
+	}
+}
+
+func (suite *MTLSTestSuite) TestAllowedNames() {
+	verifier, err := newClientCertVerifier(clientCertOptions{AllowedNames: []string{"ci.example.com", "*@example.com"}})
+	suite.Nil(err)
+
+	ci, _ := suite.client(&x509.Certificate{Subject: pkix.Name{CommonName: "ci.example.com"}})
+	suite.Nil(verifier.verifyConnection(suite.state(ci)), "CN allowed")
+	alice, _ := suite.client(&x509.Certificate{EmailAddresses: []string{"alice@example.com"}})
+	suite.Nil(verifier.verifyConnection(suite.state(alice)), "email SAN allowed by pattern")
+	suite.Equal("alice@example.com", clientIdentity(alice), "SAN identity without CN")
+	other, _ := suite.client(&x509.Certificate{Subject: pkix.Name{CommonName: "other"}, DNSNames: []string{"other.example.org"}})
+	suite.EqualError(verifier.verifyConnection(suite.state(other)), `client certificate "other" is not allowed`)
+	suite.NotNil(verifier.verifyConnection(tls.ConnectionState{}), "no verified certificate")
+
+	verifier, err = newClientCertVerifier(clientCertOptions{})
+	suite.Nil(err)
+	suite.Nil(verifier.verifyConnection(suite.state(other)), "every certificate allowed without names")
+
+	_, err = newClientCertVerifier(clientCertOptions{AllowedNames: []string{"["}})
+	suite.NotNil(err, "invalid pattern")
+}
+
+func (suite *MTLSTestSuite) TestCRL() {
+	revoked, _ := suite.client(&x509.Certificate{Subject: pkix.Name{CommonName: "revoked"}})
+	valid, _ := suite.client(&x509.Certificate{Subject: pkix.Name{CommonName: "valid"}})
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(1),
+		ThisUpdate:          time.Now(),
+		NextUpdate:          time.Now().Add(time.Hour),
+		RevokedCertificates: []pkix.RevokedCertificate{{SerialNumber: revoked.SerialNumber, RevocationTime: time.Now()}},
+	}, suite.CA, suite.CAKey)
+	suite.Nil(err)
+	file := pathutil.Join(suite.T().TempDir(), "ca.crl")
+	suite.Nil(os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0644))
+
+	verifier, err := newClientCertVerifier(clientCertOptions{CRLFile: file})
+	suite.Nil(err)
+	suite.EqualError(verifier.verifyConnection(suite.state(revoked)), `client certificate "revoked" is revoked`)
+	suite.Nil(verifier.verifyConnection(suite.state(valid)))
+
+	suite.Nil(os.WriteFile(file, der, 0644))
+	verifier, err = newClientCertVerifier(clientCertOptions{CRLFile: file})
+	suite.Nil(err, "DER encoded CRL")
+	suite.NotNil(verifier.verifyConnection(suite.state(revoked)))
+
+	suite.Nil(os.WriteFile(file, []byte("-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----\n"), 0644))
+	_, err = newClientCertVerifier(clientCertOptions{CRLFile: file})
+	suite.ErrorContains(err, "no X509 CRL")
+}
+
+func (suite *MTLSTestSuite) TestOCSP() {
+	verifier, err := newClientCertVerifier(clientCertOptions{OCSP: true})
+	suite.Nil(err)
+	valid, _ := suite.client(&x509.Certificate{Subject: pkix.Name{CommonName: "valid"}, OCSPServer: []string{suite.OCSP.URL}})
+	revoked, _ := suite.client(&x509.Certificate{Subject: pkix.Name{CommonName: "revoked"}, OCSPServer: []string{suite.OCSP.URL}})
+	suite.Revoked = revoked.SerialNumber
+
+	suite.Nil(verifier.verifyConnection(suite.state(valid)))
+	suite.Nil(verifier.verifyConnection(suite.state(valid)))
+	suite.Equal(1, suite.OCSPHits, "response cached until the next update")
+	suite.EqualError(verifier.verifyConnection(suite.state(revoked)), `client certificate "revoked" is revoked`)
+
+	offline, _ := suite.client(&x509.Certificate{Subject: pkix.Name{CommonName: "offline"}, OCSPServer: []string{suite.OCSP.URL + "/missing"}})
+	suite.OCSP.Close()
+	suite.ErrorContains(verifier.verifyConnection(suite.state(offline)), `checking the revocation of client certificate "offline"`)
+	withoutResponder, _ := suite.client(&x509.Certificate{Subject: pkix.Name{CommonName: "internal"}})
+	suite.Nil(verifier.verifyConnection(suite.state(withoutResponder)), "certificate without OCSP responder")
+}
+
+func (suite *MTLSTestSuite) TestHandshake() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	caFile := pathutil.Join(suite.T().TempDir(), "ca.pem")
+	suite.Nil(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: suite.CA.Raw}), 0644))
+	router := NewRouter(RouterOptions{
+		Logger:         log,
+		Depth:          1,
+		TlsCACert:      caFile,
+		TlsClientNames: []string{"ci"},
+		AuthRoles:      map[string]string{"ci": "team-a:write"},
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.String(200, c.GetString("user")) }, cm_auth.PullAction},
+	})
+
+	server := httptest.NewUnstartedServer(router)
+	pool := x509.NewCertPool()
+	pool.AddCert(suite.CA)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool, VerifyConnection: router.clientCerts.verifyConnection}
+	server.StartTLS()
+	defer server.Close()
+	get := func(cert *x509.Certificate, key crypto.Signer, path string) (*http.Response, error) {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}
+		return (&http.Client{Transport: transport}).Get(server.URL + path)
+	}
+
+	cert, key := suite.client(&x509.Certificate{Subject: pkix.Name{CommonName: "ci"}})
+	response, err := get(cert, key, "/team-a/index.yaml")
+	suite.Nil(err)
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	suite.Equal(200, response.StatusCode)
+	suite.Equal("ci", string(body), "identity of the client exposed to handlers")
+	response, err = get(cert, key, "/team-b/index.yaml")
+	suite.Nil(err)
+	response.Body.Close()
+	suite.Equal(403, response.StatusCode, "no role on repo")
+
+	cert, key = suite.client(&x509.Certificate{Subject: pkix.Name{CommonName: "other"}})
+	_, err = get(cert, key, "/team-a/index.yaml")
+	suite.NotNil(err, "handshake of a certificate not allowed fails")
+}
+
+func TestMTLSTestSuite(t *testing.T) {
+	suite.Run(t, new(MTLSTestSuite))
+}
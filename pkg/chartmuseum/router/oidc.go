@@ -0,0 +1,82 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// defaultGroupsClaim is the claim listing the groups of a user in the tokens of OpenID Connect providers
+const defaultGroupsClaim = "groups"
+
+// oidcProvider is the configuration of an OpenID Connect provider, published at its discovery URL
+type oidcProvider struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// discoverOIDC fetches the configuration of the provider issuing tokens as issuerURL
+func discoverOIDC(client *http.Client, issuerURL string) (*oidcProvider, error) {
+	issuerURL = strings.TrimSuffix(issuerURL, "/")
+	discoveryURL := issuerURL + "/.well-known/openid-configuration"
+	response, err := client.Get(discoveryURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", discoveryURL, response.Status)
+	}
+	var provider oidcProvider
+	if err := json.NewDecoder(response.Body).Decode(&provider); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", discoveryURL, err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != issuerURL {
+		return nil, fmt.Errorf("issuer %q of %s does not match %q", provider.Issuer, discoveryURL, issuerURL)
+	}
+	if provider.JWKSURI == "" {
+		return nil, fmt.Errorf("no jwks_uri in %s", discoveryURL)
+	}
+	return &provider, nil
+}
+
+// groupActions returns the actions granted on namespace to the groups of claims
+func (a *jwtAuthenticator) groupActions(claims jwt.MapClaims, namespace string) []string {
+	return grantedActions(a.groupGrants, claimStrings(claims[a.groupsClaim]), namespace)
+}
+
+// claimStrings returns the strings of a claim holding a string or a list of strings
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
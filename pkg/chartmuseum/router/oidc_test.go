@@ -0,0 +1,185 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type OIDCTestSuite struct {
+	suite.Suite
+	PrivateKey *rsa.PrivateKey
+	Provider   *httptest.Server
+	Issuer     string
+}
+
+// SetupTest starts a fake OpenID Connect provider, publishing its configuration and key set
+func (suite *OIDCTestSuite) SetupTest() {
+	pem, err := os.ReadFile(testPrivateKey)
+	suite.Nil(err)
+	suite.PrivateKey, err = jwt.ParseRSAPrivateKeyFromPEM(pem)
+	suite.Nil(err)
+	suite.Provider = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/realms/ci/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":   suite.Issuer,
+				"jwks_uri": suite.Provider.URL + "/realms/ci/certs",
+			})
+		case "/realms/ci/certs":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"keys": []map[string]string{{
+					"kty": "RSA",
+					"kid": "key1",
+					"n":   base64.RawURLEncoding.EncodeToString(suite.PrivateKey.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(suite.PrivateKey.E)).Bytes()),
+				}},
+			})
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	suite.Issuer = suite.Provider.URL + "/realms/ci"
+}
+
+func (suite *OIDCTestSuite) TearDownTest() {
+	suite.Provider.Close()
+}
+
+func (suite *OIDCTestSuite) token(claims jwt.MapClaims) string {
+	claims["iss"] = suite.Issuer
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	if _, ok := claims["aud"]; !ok {
+		claims["aud"] = "chartmuseum"
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "key1"
+	signed, err := token.SignedString(suite.PrivateKey)
+	suite.Nil(err)
+	return signed
+}
+
+func (suite *OIDCTestSuite) TestGroups() {
+	authenticator, err := newJWTAuthenticator(jwtOptions{
+		OIDCIssuerURL: suite.Issuer + "/",
+		OIDCClientID:  "chartmuseum",
+		Groups: map[string]string{
+			"platform":   "*:pull,push,delete",
+			"developers": "org1/*:pull,push org2/charts:pull",
+		},
+	})
+	suite.Nil(err, "no error discovering provider")
+
+	developer := "Bearer " + suite.token(jwt.MapClaims{"groups": []interface{}{"developers", "other"}})
+	allowed, _ := authenticator.authorize(developer, cm_auth.PushAction, "org1/repo1")
+	suite.True(allowed, "push granted by pattern")
+	allowed, _ = authenticator.authorize(developer, DeleteAction, "org1/repo1")
+	suite.False(allowed, "delete not granted")
+	allowed, _ = authenticator.authorize(developer, cm_auth.PullAction, "org2/charts")
+	suite.True(allowed, "pull granted on repo")
+	allowed, _ = authenticator.authorize(developer, cm_auth.PushAction, "org2/charts")
+	suite.False(allowed, "push not granted on repo")
+	allowed, _ = authenticator.authorize(developer, cm_auth.PullAction, "org3/charts")
+	suite.False(allowed, "other repo")
+
+	platform := "Bearer " + suite.token(jwt.MapClaims{"groups": "platform"})
+	allowed, _ = authenticator.authorize(platform, DeleteAction, "org3/charts")
+	suite.True(allowed, "* matches every repo")
+
+	other := "Bearer " + suite.token(jwt.MapClaims{"groups": []interface{}{"developers"}, "aud": "other"})
+	allowed, _ = authenticator.authorize(other, cm_auth.PullAction, "org1/repo1")
+	suite.False(allowed, "token for another client")
+
+	basic := &http.Request{Header: http.Header{}}
+	basic.SetBasicAuth("alice", suite.token(jwt.MapClaims{"groups": []interface{}{"developers"}}))
+	allowed, _ = authenticator.authorize(basic.Header.Get("Authorization"), cm_auth.PullAction, "org1/repo1")
+	suite.True(allowed, "token passed as basic auth password")
+}
+
+func (suite *OIDCTestSuite) TestGroupsClaim() {
+	authenticator, err := newJWTAuthenticator(jwtOptions{
+		OIDCIssuerURL: suite.Issuer,
+		Audience:      "charts",
+		GroupsClaim:   "roles",
+		Groups:        map[string]string{"admins": "*:pull"},
+	})
+	suite.Nil(err)
+	allowed, _ := authenticator.authorize("Bearer "+suite.token(jwt.MapClaims{"roles": []interface{}{"admins"}, "aud": "charts"}), cm_auth.PullAction, "repo")
+	suite.True(allowed, "custom groups claim and audience")
+	allowed, _ = authenticator.authorize("Bearer "+suite.token(jwt.MapClaims{"groups": []interface{}{"admins"}, "aud": "charts"}), cm_auth.PullAction, "repo")
+	suite.False(allowed, "groups claim ignored")
+}
+
+func (suite *OIDCTestSuite) TestInvalid() {
+	_, err := newJWTAuthenticator(jwtOptions{OIDCIssuerURL: suite.Provider.URL + "/realms/other"})
+	suite.ErrorContains(err, "404 Not Found", "discovery document not found")
+
+	issuer := suite.Issuer
+	suite.Issuer = "https://idp.example.com"
+	_, err = newJWTAuthenticator(jwtOptions{OIDCIssuerURL: issuer})
+	suite.ErrorContains(err, `issuer "https://idp.example.com" of `+issuer+"/.well-known/openid-configuration does not match")
+
+	_, err = parseGroupGrants(map[string]string{"developers": "org1/*"})
+	suite.EqualError(err, `permission "org1/*" of group developers must be repo:actions`)
+	_, err = parseGroupGrants(map[string]string{"": "*:pull"})
+	suite.EqualError(err, "missing group for permissions *:pull")
+	_, err = parseGroupGrants(map[string]string{"developers": "org1/[:pull"})
+	suite.ErrorContains(err, `permission "org1/[:pull" of group developers`)
+}
+
+func (suite *OIDCTestSuite) TestRouter() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	router := NewRouter(RouterOptions{
+		Logger:            log,
+		Depth:             1,
+		AuthOIDCIssuerURL: suite.Issuer,
+		AuthOIDCClientID:  "chartmuseum",
+		AuthOIDCGroups:    map[string]string{"developers": "team-a:pull"},
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.Status(200) }, cm_auth.PullAction},
+	})
+	serve := func(header string) int {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/team-a/index.yaml", nil)
+		request.Header.Set("Authorization", header)
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	suite.Equal(401, serve(""), "auth enabled without --bearer-auth")
+	suite.Equal(200, serve("Bearer "+suite.token(jwt.MapClaims{"groups": []interface{}{"developers"}})))
+}
+
+func TestOIDCTestSuite(t *testing.T) {
+	suite.Run(t, new(OIDCTestSuite))
+}
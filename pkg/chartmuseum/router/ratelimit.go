@@ -0,0 +1,102 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// RateLimitByIP and RateLimitByToken are the keys of rate limits: the client address, or the
+	// authenticated user of the request, the client address for anonymous or unauthenticated requests
+	RateLimitByIP    = "ip"
+	RateLimitByToken = "token"
+
+	// maxRateLimitedClients bounds the memory of a rateLimiter, the limiter of the client seen the
+	// longest ago being dropped once it is reached
+	maxRateLimitedClients = 10000
+)
+
+type (
+	// rateLimiter limits the requests of each client to a route group, the reads or the writes,
+	// with a token bucket of rate requests per second and burst requests
+	rateLimiter struct {
+		rate     rate.Limit
+		burst    int
+		lock     sync.Mutex
+		limiters map[string]*list.Element
+		// recent holds the limiters, the one of the client seen last first
+		recent *list.List
+	}
+
+	clientLimiter struct {
+		client  string
+		limiter *rate.Limiter
+	}
+)
+
+func newRateLimiter(requestsPerSecond float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(requestsPerSecond)))
+	}
+	return &rateLimiter{rate: rate.Limit(requestsPerSecond), burst: burst, limiters: map[string]*list.Element{}, recent: list.New()}
+}
+
+// allow tells whether client may make a request now, or how long it should wait before retrying
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	element, found := l.limiters[client]
+	if found {
+		l.recent.MoveToFront(element)
+	} else {
+		if l.recent.Len() >= maxRateLimitedClients {
+			oldest := l.recent.Back()
+			l.recent.Remove(oldest)
+			delete(l.limiters, oldest.Value.(*clientLimiter).client)
+		}
+		element = l.recent.PushFront(&clientLimiter{client: client, limiter: rate.NewLimiter(l.rate, l.burst)})
+		l.limiters[client] = element
+	}
+	reservation := element.Value.(*clientLimiter).limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// rateLimitedClient is the key of the client of a request for rate limits by, user being the one
+// the request was authenticated as, if any
+func rateLimitedClient(by string, address string, user string) string {
+	if by == RateLimitByToken && user != "" {
+		return "user:" + user
+	}
+	return "ip:" + address
+}
+
+// isWriteAction tells whether action changes repos, such routes being limited as writes
+func isWriteAction(action string) bool {
+	return action != "" && action != cm_auth.PullAction
+}
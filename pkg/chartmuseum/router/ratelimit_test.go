@@ -0,0 +1,120 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	pathutil "path"
+	"testing"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type RateLimitTestSuite struct {
+	suite.Suite
+}
+
+func (suite *RateLimitTestSuite) TestAllow() {
+	limiter := newRateLimiter(0.5, 2)
+	for i := 0; i < 2; i++ {
+		allowed, _ := limiter.allow("ip:10.0.0.1")
+		suite.True(allowed, "within burst")
+	}
+	allowed, retryAfter := limiter.allow("ip:10.0.0.1")
+	suite.False(allowed, "over burst")
+	suite.InDelta(2*time.Second, retryAfter, float64(100*time.Millisecond), "retry once a token is refilled")
+	allowed, _ = limiter.allow("ip:10.0.0.2")
+	suite.True(allowed, "limited by client")
+
+	suite.Equal(1, newRateLimiter(0.5, 0).burst, "at least a request at once")
+	suite.Equal(3, newRateLimiter(2.5, 0).burst, "burst of the rate rounded up")
+
+	suite.Equal("ip:10.0.0.1", rateLimitedClient(RateLimitByIP, "10.0.0.1", "alice"))
+	suite.Equal("ip:10.0.0.1", rateLimitedClient(RateLimitByToken, "10.0.0.1", ""), "client address without user")
+	suite.Equal("user:alice", rateLimitedClient(RateLimitByToken, "10.0.0.1", "alice"))
+}
+
+func (suite *RateLimitTestSuite) TestEviction() {
+	limiter := newRateLimiter(0.5, 1)
+	limiter.allow("ip:10.0.0.1")
+	for i := 0; i < maxRateLimitedClients; i++ {
+		limiter.allow(fmt.Sprintf("ip:10.1.%d.%d", i/256, i%256))
+		if i == 0 {
+			allowed, _ := limiter.allow("ip:10.0.0.2")
+			suite.True(allowed, "first request of a client")
+		}
+	}
+	suite.Len(limiter.limiters, maxRateLimitedClients, "clients bounded")
+	suite.Equal(maxRateLimitedClients, limiter.recent.Len())
+	allowed, _ := limiter.allow("ip:10.0.0.1")
+	suite.True(allowed, "limiter of the client seen the longest ago dropped")
+}
+
+func (suite *RateLimitTestSuite) TestRouter() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	file := pathutil.Join(suite.T().TempDir(), "users.htpasswd")
+	hash := apr1("testpass", "saltsalt")
+	suite.Nil(os.WriteFile(file, []byte("alice:"+hash+"\nbob:"+hash+"\n"), 0644))
+	router := NewRouter(RouterOptions{
+		Logger:         log,
+		Depth:          1,
+		MaxUploadSize:  1 << 20,
+		RateLimitRead:  100,
+		RateLimitWrite: 1,
+		RateLimitBy:    RateLimitByToken,
+		HtpasswdFile:   file,
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.Status(200) }, cm_auth.PullAction},
+		{"POST", "/api/:repo/charts", func(c *gin.Context) { c.Status(201) }, cm_auth.PushAction},
+		{"GET", "/health", func(c *gin.Context) { c.Status(200) }, ""},
+	})
+	serve := func(method string, path string, user string, password string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, path, http.NoBody)
+		request.RemoteAddr = "10.0.0.1:1234"
+		request.SetBasicAuth(user, password)
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	suite.Equal(201, serve("POST", "/api/org1/charts", "alice", "testpass").Code)
+	response := serve("POST", "/api/org1/charts", "alice", "testpass")
+	suite.Equal(429, response.Code, "write limit")
+	suite.Equal("1", response.Header().Get("Retry-After"))
+	suite.Equal(201, serve("POST", "/api/org1/charts", "bob", "testpass").Code, "limited by user")
+	suite.Equal(401, serve("POST", "/api/org1/charts", "mallory", "a").Code, "unauthenticated")
+	suite.Equal(429, serve("POST", "/api/org1/charts", "mallory", "b").Code, "unauthenticated requests limited by address, whatever their credentials")
+	suite.Equal(200, serve("GET", "/org1/index.yaml", "alice", "testpass").Code, "reads limited separately")
+	for i := 0; i < 200; i++ {
+		serve("GET", "/health", "alice", "testpass")
+	}
+	suite.Equal(200, serve("GET", "/health", "alice", "testpass").Code, "routes without action not limited")
+}
+
+func TestRateLimitTestSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitTestSuite))
+}
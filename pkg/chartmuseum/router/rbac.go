@@ -0,0 +1,88 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	pathutil "path"
+	"strings"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// roleActions are the actions granted by each role, a role granting the actions of the previous ones
+var roleActions = map[string][]string{
+	"read":   {cm_auth.PullAction},
+	"write":  {cm_auth.PullAction, cm_auth.PushAction},
+	"delete": {cm_auth.PullAction, cm_auth.PushAction, DeleteAction},
+	"admin":  {cm_auth.PullAction, cm_auth.PushAction, DeleteAction, AdminAction},
+}
+
+// roles grants the actions of roles on repos to users, by user
+type roles map[string][]groupGrant
+
+// parseRoles parses the roles of users, each a space separated list of repo patterns and the role
+// on the matching repos, e.g. "org1/*:write org2/charts:read"
+func parseRoles(userRoles map[string]string) (roles, error) {
+	r := roles{}
+	for user, assignments := range userRoles {
+		if user == "" {
+			return nil, errors.New("missing user for roles " + assignments)
+		}
+		for _, assignment := range strings.Fields(assignments) {
+			pattern, role, found := strings.Cut(assignment, ":")
+			if !found || pattern == "" {
+				return nil, fmt.Errorf("role %q of user %s must be repo:role", assignment, user)
+			}
+			actions, found := roleActions[role]
+			if !found {
+				return nil, fmt.Errorf("unknown role %q of user %s, use read, write, delete or admin", role, user)
+			}
+			if _, err := pathutil.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("role %q of user %s: %w", assignment, user, err)
+			}
+			r[user] = append(r[user], groupGrant{pattern: pattern, actions: actions})
+		}
+	}
+	return r, nil
+}
+
+// allows tells whether the roles of user grant action on repo
+func (r roles) allows(user string, action string, repo string) bool {
+	return user != "" && containsString(grantedActions(r, []string{user}, repo), action)
+}
+
+// tokenSubject returns the sub claim of the bearer token of authHeader, or of the token passed as
+// the password of basic auth. The token must have been verified by an authenticator
+func tokenSubject(authHeader string) string {
+	var token string
+	if match := bearerTokenPattern.FindStringSubmatch(authHeader); match != nil {
+		token = match[1]
+	} else {
+		request := http.Request{Header: http.Header{"Authorization": {authHeader}}}
+		_, token, _ = request.BasicAuth()
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return ""
+	}
+	subject, _ := claims["sub"].(string)
+	return subject
+}
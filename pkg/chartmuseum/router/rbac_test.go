@@ -0,0 +1,174 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	pathutil "path"
+	"testing"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type RBACTestSuite struct {
+	suite.Suite
+}
+
+func (suite *RBACTestSuite) TestRoles() {
+	r, err := parseRoles(map[string]string{
+		"alice": "org1/*:write org2/charts:read",
+		"bob":   "*:admin",
+		"ci":    "org1/repo1:delete",
+	})
+	suite.Nil(err, "no error parsing roles")
+
+	suite.True(r.allows("alice", cm_auth.PushAction, "org1/repo1"), "write pushes")
+	suite.False(r.allows("alice", DeleteAction, "org1/repo1"), "write does not delete")
+	suite.True(r.allows("alice", cm_auth.PullAction, "org2/charts"), "read pulls")
+	suite.False(r.allows("alice", cm_auth.PushAction, "org2/charts"), "read does not push")
+	suite.False(r.allows("alice", cm_auth.PullAction, "org3/charts"), "no role on repo")
+	suite.True(r.allows("ci", DeleteAction, "org1/repo1"), "delete deletes")
+	suite.False(r.allows("ci", AdminAction, "org1/repo1"), "delete does not administer")
+	suite.True(r.allows("bob", AdminAction, ""), "admin on every repo")
+	suite.False(r.allows("carol", cm_auth.PullAction, "org1/repo1"), "user without roles")
+	suite.False(r.allows("", cm_auth.PullAction, "org1/repo1"), "unknown user")
+
+	_, err = parseRoles(map[string]string{"alice": "org1/*:owner"})
+	suite.EqualError(err, `unknown role "owner" of user alice, use read, write, delete or admin`)
+	_, err = parseRoles(map[string]string{"alice": "org1/*"})
+	suite.EqualError(err, `role "org1/*" of user alice must be repo:role`)
+	_, err = parseRoles(map[string]string{"": "*:read"})
+	suite.EqualError(err, "missing user for roles *:read")
+}
+
+func (suite *RBACTestSuite) TestTokenSubject() {
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "ci"}).SignedString([]byte("secret"))
+	suite.Nil(err)
+	suite.Equal("ci", tokenSubject("Bearer "+signed))
+	basic := &http.Request{Header: http.Header{}}
+	basic.SetBasicAuth("oidc", signed)
+	suite.Equal("ci", tokenSubject(basic.Header.Get("Authorization")), "token as basic auth password")
+	suite.Equal("", tokenSubject("Bearer notatoken"))
+}
+
+func (suite *RBACTestSuite) TestRouter() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	file := pathutil.Join(suite.T().TempDir(), "users.htpasswd")
+	suite.Nil(os.WriteFile(file, []byte("alice:$apr1$saltsalt$IUEN5/qU3k/tdA8LSEOVz.\n"), 0644))
+
+	router := NewRouter(RouterOptions{
+		Logger:       log,
+		Depth:        1,
+		HtpasswdFile: file,
+		AnonymousGet: true,
+		AuthRoles:    map[string]string{"alice": "team-a:write"},
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.String(200, c.GetString("user")) }, cm_auth.PullAction},
+		{"POST", "/api/:repo/charts", func(c *gin.Context) { c.String(201, c.GetString("repo")) }, cm_auth.PushAction},
+	})
+	serve := func(method string, path string, user string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, path, nil)
+		if user != "" {
+			request.SetBasicAuth(user, "testpass")
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := serve("POST", "/api/team-a/charts", "alice")
+	suite.Equal(201, response.Code, "role on repo")
+	suite.Equal("team-a", response.Body.String(), "repo set on context")
+	suite.Equal(403, serve("POST", "/api/team-b/charts", "alice").Code, "no role on repo")
+	suite.Equal(401, serve("POST", "/api/team-a/charts", "").Code, "not authenticated")
+	suite.Equal(200, serve("GET", "/team-b/index.yaml", "").Code, "anonymous GET without role")
+}
+
+func (suite *RBACTestSuite) TestAuthorizeRepo() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	file := pathutil.Join(suite.T().TempDir(), "users.htpasswd")
+	suite.Nil(os.WriteFile(file, []byte("alice:$apr1$saltsalt$IUEN5/qU3k/tdA8LSEOVz.\n"), 0644))
+	router := NewRouter(RouterOptions{
+		Logger:       log,
+		Depth:        1,
+		HtpasswdFile: file,
+		AuthRoles:    map[string]string{"alice": "dev:write staging:write"},
+	})
+	router.SetRoutes([]*Route{
+		{"POST", "/api/:repo/charts/:name/:version/promote", func(c *gin.Context) {
+			if router.AuthorizeRepo(c, cm_auth.PushAction, c.Query("to")) {
+				c.String(200, c.GetString("repo"))
+			}
+		}, cm_auth.PushAction},
+	})
+	serve := func(to string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/api/dev/charts/mychart/0.1.0/promote?to="+to, nil)
+		request.SetBasicAuth("alice", "testpass")
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := serve("staging")
+	suite.Equal(200, response.Code, "role on the other repo")
+	suite.Equal("dev", response.Body.String(), "repo of the request restored")
+	suite.Equal(403, serve("prod").Code, "no role on the other repo")
+}
+
+func (suite *RBACTestSuite) TestAllowsRepo() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	file := pathutil.Join(suite.T().TempDir(), "users.htpasswd")
+	suite.Nil(os.WriteFile(file, []byte("alice:$apr1$saltsalt$IUEN5/qU3k/tdA8LSEOVz.\n"), 0644))
+	router := NewRouter(RouterOptions{
+		Logger:       log,
+		Depth:        1,
+		HtpasswdFile: file,
+		AuthRoles:    map[string]string{"alice": "dev:read staging:read"},
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/api/:repo/charts/:name/:version/dependencies", func(c *gin.Context) {
+			c.JSON(200, gin.H{
+				"staging": router.AllowsRepo(c, cm_auth.PullAction, "staging"),
+				"prod":    router.AllowsRepo(c, cm_auth.PullAction, "prod"),
+				"repo":    c.GetString("repo"),
+				"user":    c.GetString("user"),
+			})
+		}, cm_auth.PullAction},
+	})
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/dev/charts/mychart/0.1.0/dependencies", nil)
+	request.SetBasicAuth("alice", "testpass")
+	router.ServeHTTP(recorder, request)
+	suite.Equal(200, recorder.Code, "no error written for the repos not allowed")
+	suite.JSONEq(`{"staging": true, "prod": false, "repo": "dev", "user": "alice"}`, recorder.Body.String())
+	suite.Empty(recorder.Header().Get("WWW-Authenticate"), "no challenge written for the repos not allowed")
+}
+
+func TestRBACTestSuite(t *testing.T) {
+	suite.Run(t, new(RBACTestSuite))
+}
@@ -0,0 +1,186 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	pathutil "path"
+
+	"sigs.k8s.io/yaml"
+)
+
+const defaultBasicRealm = "ChartMuseum"
+
+type (
+	// tenantRealm authenticates the users of the repos of a tenant with its own basic auth users
+	// or bearer tokens, instead of the auth methods of the server, and grants them the actions of
+	// its own roles
+	tenantRealm struct {
+		name     string
+		repos    []string
+		htpasswd htpasswd
+		jwt      *jwtAuthenticator
+		roles    roles
+	}
+
+	// tenantsFile is the content of --auth-tenants-file
+	tenantsFile struct {
+		Tenants []tenantRealmConfig `json:"tenants"`
+	}
+
+	// tenantRealmConfig are the settings of a tenantRealm, in the tenants file
+	tenantRealmConfig struct {
+		// Name is the realm of the basic auth challenge, "ChartMuseum" if empty
+		Name string `json:"name"`
+		// Repos are patterns matching the repos of the tenant as path.Match, "*" matching a single
+		// level. The first tenant matching a repo authenticates its users
+		Repos    []string `json:"repos"`
+		Htpasswd string   `json:"htpasswd"`
+		// OIDC verifies the ID tokens of an OpenID Connect provider, as --auth-oidc-issuer-url
+		OIDC *struct {
+			IssuerURL   string            `json:"issuerurl"`
+			ClientID    string            `json:"clientid"`
+			GroupsClaim string            `json:"groupsclaim"`
+			Groups      map[string]string `json:"group"`
+		} `json:"oidc"`
+		// Token verifies the bearer tokens of a token issuer, as --bearer-auth
+		Token *struct {
+			Realm             string `json:"realm"`
+			Service           string `json:"service"`
+			CertPath          string `json:"certpath"`
+			JWTSecret         string `json:"jwtsecret"`
+			JWKSURL           string `json:"jwksurl"`
+			Issuer            string `json:"issuer"`
+			Audience          string `json:"audience"`
+			ActionsSearchPath string `json:"actionssearchpath"`
+		} `json:"token"`
+		// Roles are the roles of the users of the tenant, in the format of --auth-role. Basic auth
+		// users are granted every action without roles
+		Roles map[string]string `json:"role"`
+	}
+)
+
+// loadTenantRealms reads the realms of the tenants of a tenants file
+func loadTenantRealms(path string) ([]*tenantRealm, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file tenantsFile
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	var realms []*tenantRealm
+	for i, config := range file.Tenants {
+		realm, err := newTenantRealm(config)
+		if err != nil {
+			return nil, fmt.Errorf("%s: tenant %d: %w", path, i+1, err)
+		}
+		realms = append(realms, realm)
+	}
+	return realms, nil
+}
+
+func newTenantRealm(config tenantRealmConfig) (*tenantRealm, error) {
+	if len(config.Repos) == 0 {
+		return nil, errors.New("a tenant requires repos")
+	}
+	for _, pattern := range config.Repos {
+		if _, err := pathutil.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("repo %q: %w", pattern, err)
+		}
+	}
+	if config.OIDC != nil && config.Token != nil {
+		return nil, errors.New("a tenant verifies either OIDC or issuer tokens")
+	}
+	realm := &tenantRealm{name: config.Name, repos: config.Repos}
+	if realm.name == "" {
+		realm.name = defaultBasicRealm
+	}
+	var err error
+	if config.Htpasswd != "" {
+		if realm.htpasswd, err = loadHtpasswd(config.Htpasswd); err != nil {
+			return nil, err
+		}
+	}
+	if config.OIDC != nil {
+		realm.jwt, err = newJWTAuthenticator(jwtOptions{
+			OIDCIssuerURL: config.OIDC.IssuerURL,
+			OIDCClientID:  config.OIDC.ClientID,
+			GroupsClaim:   config.OIDC.GroupsClaim,
+			Groups:        config.OIDC.Groups,
+		})
+	} else if config.Token != nil {
+		realm.jwt, err = newJWTAuthenticator(jwtOptions{
+			Secret:            config.Token.JWTSecret,
+			PublicKeyPath:     config.Token.CertPath,
+			JWKSURL:           config.Token.JWKSURL,
+			Issuer:            config.Token.Issuer,
+			Audience:          config.Token.Audience,
+			ActionsSearchPath: config.Token.ActionsSearchPath,
+			Realm:             config.Token.Realm,
+			Service:           config.Token.Service,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if realm.htpasswd == nil && realm.jwt == nil {
+		return nil, errors.New("a tenant requires an htpasswd file, OIDC or a token issuer")
+	}
+	if len(config.Roles) > 0 {
+		if realm.roles, err = parseRoles(config.Roles); err != nil {
+			return nil, err
+		}
+	}
+	return realm, nil
+}
+
+// tenantRealm returns the realm of the first tenant of repo, nil if the server authenticates its users
+func (router *Router) tenantRealm(repo string) *tenantRealm {
+	if repo == "" {
+		return nil
+	}
+	for _, realm := range router.realms {
+		for _, pattern := range realm.repos {
+			if matched, _ := pathutil.Match(pattern, repo); matched {
+				return realm
+			}
+		}
+	}
+	return nil
+}
+
+// authorize tells whether the credentials of r authenticate a user of the tenant granted action on
+// namespace by their token, if any, and returns the user, or otherwise the WWW-Authenticate header
+// challenging the client
+func (realm *tenantRealm) authorize(r *http.Request, action string, namespace string) (string, bool, string) {
+	if user, password, ok := r.BasicAuth(); ok && realm.htpasswd != nil && realm.htpasswd.authenticate(user, password) {
+		return user, true, ""
+	}
+	if realm.jwt == nil {
+		return "", false, fmt.Sprintf("Basic realm=%q", realm.name)
+	}
+	authHeader := r.Header.Get("Authorization")
+	if allowed, challenge := realm.jwt.authorize(authHeader, action, namespace); !allowed {
+		return "", false, challenge
+	}
+	return tokenSubject(authHeader), true, ""
+}
@@ -0,0 +1,155 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	pathutil "path"
+	"testing"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type RealmTestSuite struct {
+	suite.Suite
+	Dir string
+}
+
+func (suite *RealmTestSuite) SetupTest() {
+	suite.Dir = suite.T().TempDir()
+	// alice:testpass and carol:testpass
+	suite.Nil(os.WriteFile(pathutil.Join(suite.Dir, "server.htpasswd"), []byte("alice:$apr1$saltsalt$IUEN5/qU3k/tdA8LSEOVz.\n"), 0644))
+	suite.Nil(os.WriteFile(pathutil.Join(suite.Dir, "team-a.htpasswd"), []byte("carol:{SHA}IGyAQTualsExLMNGt9JRe4RGPt0=\n"), 0644))
+}
+
+// tenants writes content to the tenants file of the suite, and returns its path
+func (suite *RealmTestSuite) tenants(content string) string {
+	path := pathutil.Join(suite.Dir, "tenants.yaml")
+	suite.Nil(os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func (suite *RealmTestSuite) TestLoad() {
+	realms, err := loadTenantRealms(suite.tenants(`
+tenants:
+  - name: Team A
+    repos: ["team-a", "team-a/*"]
+    htpasswd: ` + pathutil.Join(suite.Dir, "team-a.htpasswd") + `
+    role:
+      carol: "team-a/*:read"
+  - repos: ["team-b"]
+    token:
+      jwtsecret: secret
+`))
+	suite.Nil(err)
+	suite.Len(realms, 2)
+	suite.Equal("Team A", realms[0].name)
+	suite.NotNil(realms[0].roles)
+	suite.Equal(defaultBasicRealm, realms[1].name, "default realm of the challenge")
+	suite.NotNil(realms[1].jwt)
+
+	for content, expected := range map[string]string{
+		"tenants:\n  - htpasswd: users.htpasswd\n":                                                      "tenant 1: a tenant requires repos",
+		"tenants:\n  - repos: [team-a]\n":                                                               "tenant 1: a tenant requires an htpasswd file, OIDC or a token issuer",
+		"tenants:\n  - repos: ['[']\n    token: {jwtsecret: secret}\n":                                  `tenant 1: repo "[": syntax error in pattern`,
+		"tenants:\n  - repos: [team-a]\n    token: {}\n":                                                "tenant 1: jwt auth requires a secret, a public key, a JWKS URL or an OIDC issuer URL",
+		"tenants:\n  - repos: [team-a]\n    token: {jwtsecret: s}\n    role: {carol: 'team-a:owner'}\n": `tenant 1: unknown role "owner" of user carol, use read, write, delete or admin`,
+	} {
+		_, err = loadTenantRealms(suite.tenants(content))
+		suite.EqualError(err, pathutil.Join(suite.Dir, "tenants.yaml")+": "+expected)
+	}
+	_, err = loadTenantRealms(suite.tenants("tenants:\n  - repos: [team-a]\n    htpassword: users.htpasswd\n"))
+	suite.ErrorContains(err, "unknown field", "misspelled setting")
+}
+
+func (suite *RealmTestSuite) TestRouter() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	router := NewRouter(RouterOptions{
+		Logger:        log,
+		Depth:         1,
+		MaxUploadSize: 1 << 20,
+		HtpasswdFile:  pathutil.Join(suite.Dir, "server.htpasswd"),
+		AuthRoles:     map[string]string{"alice": "*:write"},
+		AuthTenantsFile: suite.tenants(`
+tenants:
+  - name: Team A
+    repos: ["team-a"]
+    htpasswd: ` + pathutil.Join(suite.Dir, "team-a.htpasswd") + `
+    role:
+      carol: "team-a:read"
+  - repos: ["team-b"]
+    token:
+      realm: https://auth.team-b.example.com/token
+      service: charts
+      jwtsecret: secret
+`),
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.String(200, c.GetString("user")) }, cm_auth.PullAction},
+		{"POST", "/api/:repo/charts", func(c *gin.Context) { c.Status(201) }, cm_auth.PushAction},
+	})
+	serve := func(method string, path string, setAuth func(*http.Request)) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, path, nil)
+		setAuth(request)
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	basic := func(user string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, "testpass") }
+	}
+
+	response := serve("GET", "/org1/index.yaml", basic("alice"))
+	suite.Equal(200, response.Code, "user of the server")
+	suite.Equal(401, serve("GET", "/org1/index.yaml", basic("carol")).Code, "user of a tenant on another repo")
+
+	response = serve("GET", "/team-a/index.yaml", basic("carol"))
+	suite.Equal(200, response.Code, "user of the tenant")
+	suite.Equal("carol", response.Body.String())
+	suite.Equal(403, serve("POST", "/api/team-a/charts", basic("carol")).Code, "roles of the tenant")
+	response = serve("GET", "/team-a/index.yaml", basic("alice"))
+	suite.Equal(401, response.Code, "user of the server on the repo of a tenant")
+	suite.Equal(`Basic realm="Team A"`, response.Header().Get("WWW-Authenticate"))
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":    "dave",
+		"exp":    time.Now().Add(time.Minute).Unix(),
+		"access": []cm_auth.AccessEntry{{Type: cm_auth.AccessEntryType, Name: "team-b", Actions: []string{"pull", "push"}}},
+	}).SignedString([]byte("secret"))
+	suite.Nil(err)
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	response = serve("POST", "/api/team-b/charts", bearer)
+	suite.Equal(201, response.Code, "token of the issuer of the tenant, without the roles of the server")
+	response = serve("GET", "/team-b/index.yaml", basic("alice"))
+	suite.Equal(401, response.Code)
+	suite.Equal(`Bearer realm="https://auth.team-b.example.com/token",service="charts",scope="artifact-repository:team-b:pull"`,
+		response.Header().Get("WWW-Authenticate"), "challenge of the token issuer of the tenant")
+	suite.Equal(401, serve("GET", "/org1/index.yaml", bearer).Code, "token of a tenant on another repo")
+}
+
+func TestRealmTestSuite(t *testing.T) {
+	suite.Run(t, new(RealmTestSuite))
+}
@@ -19,10 +19,14 @@ package router
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
@@ -33,6 +37,14 @@ import (
 	ginprometheus "github.com/zsais/go-gin-prometheus"
 )
 
+// AdminAction is the auth action required by routes changing the server configuration at runtime,
+// a bearer token must grant it explicitly for the repo, alongside pull and push
+const AdminAction = "admin"
+
+// DeleteAction is the auth action required by routes deleting charts. Tokens verified with a JWT
+// secret, key set, issuer or audience must grant it explicitly, other bearer tokens deleting with push
+const DeleteAction = "delete"
+
 type (
 	// Router handles all incoming HTTP requests
 	Router struct {
@@ -43,6 +55,7 @@ type (
 		TlsCert         string
 		TlsKey          string
 		TlsCACert       string
+		TlsCertFunc     func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 		ContextPath     string
 		Depth           int
 		DepthDynamic    bool
@@ -51,6 +64,26 @@ type (
 		WriteTimeout    time.Duration
 		Host            string
 		WebTemplatePath string
+		htpasswd        htpasswd
+		jwt             *jwtAuthenticator
+		ldap            *ldapAuthenticator
+		hmac            *hmacAuthenticator
+		roles           roles
+		realms          []*tenantRealm
+		tokens          *tokenIssuer
+		sessions        *sessionIssuer
+		clientCerts     *clientCertVerifier
+		ipFilter        *ipFilter
+		trustProxies    bool
+		readLimiter     *rateLimiter
+		writeLimiter    *rateLimiter
+		rateLimitBy     string
+		apiKeys         APIKeyVerifier
+		webhook         *authWebhook
+		authCache       *decisionCache
+		audit           *auditLog
+		cors            *corsPolicy
+		anonymousGet    bool
 	}
 
 	// RouterOptions are options for constructing a Router
@@ -59,10 +92,26 @@ type (
 		LogLatencyInteger     bool
 		Username              string
 		Password              string
+		HtpasswdFile          string
 		ContextPath           string
 		TlsCert               string
 		TlsKey                string
 		TlsCACert             string
+		TlsCertFunc           func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+		TlsClientNames        []string
+		TlsCRLFile            string
+		TlsOCSP               bool
+		IPAllow               []string
+		IPDeny                []string
+		IPWriteAllow          []string
+		IPWriteDeny           []string
+		IPTrustedProxies      []string
+		RateLimitRead         float64
+		RateLimitReadBurst    int
+		RateLimitWrite        float64
+		RateLimitWriteBurst   int
+		RateLimitBy           string
+		AuditLog              []string
 		PathPrefix            string
 		LogHealth             bool
 		EnableMetrics         bool
@@ -74,10 +123,38 @@ type (
 		AuthService           string
 		AuthCertPath          string
 		AuthActionsSearchPath string
+		AuthJWTSecret         string
+		AuthJWKSURL           string
+		AuthIssuer            string
+		AuthAudience          string
+		AuthOIDCIssuerURL     string
+		AuthOIDCClientID      string
+		AuthOIDCGroupsClaim   string
+		AuthOIDCGroups        map[string]string
+		AuthLDAP              LDAPOptions
+		AuthRoles             map[string]string
+		AuthTenantsFile       string
+		AuthTokenEndpoint     bool
+		AuthTokenKeyPath      string
+		AuthTokenTTL          time.Duration
+		AuthPullTokenMaxTTL   time.Duration
+		AuthSession           bool
+		AuthSessionSecret     string
+		AuthSessionTTL        time.Duration
+		AuthHMACKeys          map[string]string
+		AuthHMACSkew          time.Duration
+		AuthWebhookURL        string
+		AuthWebhookTimeout    time.Duration
+		AuthCacheTTL          time.Duration
+		AuthCacheNegativeTTL  time.Duration
 		DepthDynamic          bool
 		ReadTimeout           int
 		WriteTimeout          int
 		CORSAllowOrigin       string
+		CORSAllowMethods      []string
+		CORSAllowHeaders      []string
+		CORSAllowCredentials  bool
+		CORSMaxAge            time.Duration
 		Host                  string
 	}
 
@@ -112,6 +189,7 @@ func NewRouter(options RouterOptions) *Router {
 		TlsCert:         options.TlsCert,
 		TlsKey:          options.TlsKey,
 		TlsCACert:       options.TlsCACert,
+		TlsCertFunc:     options.TlsCertFunc,
 		ContextPath:     options.ContextPath,
 		Depth:           options.Depth,
 		DepthDynamic:    options.DepthDynamic,
@@ -124,13 +202,99 @@ func NewRouter(options RouterOptions) *Router {
 	var err error
 	var authorizer *cm_auth.Authorizer
 
+	// clients are allowed or denied by their address before auth, and only by the address of the
+	// connection unless it is one of the trusted proxies, setting X-Forwarded-For:
+	// --ip-allow="10.0.0.0/8"
+	// --ip-write-allow="10.1.0.0/16"
+	// --ip-trusted-proxies="10.0.0.1"
+	if len(options.IPTrustedProxies) > 0 {
+		if err = engine.SetTrustedProxies(options.IPTrustedProxies); err != nil {
+			router.Logger.Fatal(err)
+		}
+		router.trustProxies = true
+	}
+	if len(options.IPAllow)+len(options.IPDeny)+len(options.IPWriteAllow)+len(options.IPWriteDeny) > 0 {
+		router.ipFilter, err = newIPFilter(ipFilterOptions{
+			Allow:      options.IPAllow,
+			Deny:       options.IPDeny,
+			WriteAllow: options.IPWriteAllow,
+			WriteDeny:  options.IPWriteDeny,
+		})
+		if err != nil {
+			router.Logger.Fatal(err)
+		}
+	}
+
+	// client certificates verified against --tls-ca-cert may be further checked with:
+	// --tls-client-names="ci.my.site.io,*@my.site.io"
+	// --tls-crl="./certs/ca.crl"
+	// --tls-ocsp
+	if options.TlsCACert != "" {
+		router.clientCerts, err = newClientCertVerifier(clientCertOptions{
+			AllowedNames: options.TlsClientNames,
+			CRLFile:      options.TlsCRLFile,
+			OCSP:         options.TlsOCSP,
+		})
+		if err != nil {
+			router.Logger.Fatal(err)
+		}
+	}
+
+	// the requests of each client are limited by route group, with a token bucket refilled with
+	// the rate of requests per second, and keyed by client address or authenticated user:
+	// --rate-limit-read=20 --rate-limit-read-burst=40
+	// --rate-limit-write=1
+	// --rate-limit-by=token
+	switch options.RateLimitBy {
+	case "":
+		router.rateLimitBy = RateLimitByIP
+	case RateLimitByIP, RateLimitByToken:
+		router.rateLimitBy = options.RateLimitBy
+	default:
+		router.Logger.Fatalf("Invalid rate limit key %q, use %s or %s", options.RateLimitBy, RateLimitByIP, RateLimitByToken)
+	}
+	if options.RateLimitRead > 0 {
+		router.readLimiter = newRateLimiter(options.RateLimitRead, options.RateLimitReadBurst)
+	}
+	if options.RateLimitWrite > 0 {
+		router.writeLimiter = newRateLimiter(options.RateLimitWrite, options.RateLimitWriteBurst)
+	}
+
 	// if BearerAuth is true, looks for required inputs.
 	// example input:
 	// --bearer-auth
 	// --auth-realm="https://my.site.io/oauth2/token"
 	// --auth-service="my.site.io"
 	// --auth-cert-path="./certs/authorization-server-cert.pem"
-	if options.BearerAuth {
+	//
+	// tokens are verified with a JWT secret or key set, or their issuer and audience checked, with:
+	// --auth-jwt-secret="secret"
+	// --auth-jwks-url="https://my.site.io/.well-known/jwks.json"
+	// --auth-issuer="https://my.site.io"
+	// --auth-audience="chartmuseum"
+	//
+	// or by an OpenID Connect provider, granting the permissions of the groups of the users, with:
+	// --auth-oidc-issuer-url="https://idp.my.site.io"
+	// --auth-oidc-client-id="chartmuseum"
+	// --auth-oidc-group="platform=*:pull,push,delete"
+	jwtAuth := options.AuthJWTSecret != "" || options.AuthJWKSURL != "" || options.AuthIssuer != "" || options.AuthAudience != ""
+	if options.AuthOIDCIssuerURL != "" || (options.BearerAuth && (jwtAuth || options.AuthTokenEndpoint)) {
+		router.jwt, err = newJWTAuthenticator(jwtOptions{
+			Secret:            options.AuthJWTSecret,
+			PublicKeyPath:     options.AuthCertPath,
+			JWKSURL:           options.AuthJWKSURL,
+			Issuer:            options.AuthIssuer,
+			Audience:          options.AuthAudience,
+			ActionsSearchPath: options.AuthActionsSearchPath,
+			Realm:             options.AuthRealm,
+			Service:           options.AuthService,
+			OIDCIssuerURL:     options.AuthOIDCIssuerURL,
+			OIDCClientID:      options.AuthOIDCClientID,
+			GroupsClaim:       options.AuthOIDCGroupsClaim,
+			Groups:            options.AuthOIDCGroups,
+			BasicTokens:       options.AuthTokenEndpoint,
+		})
+	} else if options.BearerAuth {
 		if options.AuthRealm == "" {
 			router.Logger.Fatal("Missing Auth Realm")
 		}
@@ -147,6 +311,19 @@ func NewRouter(options RouterOptions) *Router {
 			PublicKeyPath:            options.AuthCertPath,
 			AllowedActionsSearchPath: options.AuthActionsSearchPath,
 		})
+	} else if options.AuthLDAP.URL != "" {
+		// --auth-ldap-url="ldaps://ldap.my.site.io"
+		// --auth-ldap-user-base-dn="ou=people,dc=my,dc=site,dc=io"
+		// --auth-ldap-group="developers=org1/*:pull,push"
+		if options.Username != "" || options.HtpasswdFile != "" {
+			router.Logger.Fatal("LDAP auth cannot be used together with a basic auth user or htpasswd file")
+		}
+		router.ldap, err = newLDAPAuthenticator(options.AuthLDAP)
+	} else if options.HtpasswdFile != "" {
+		if options.Username != "" {
+			router.Logger.Fatal("Basic auth user and htpasswd file cannot be used together")
+		}
+		router.htpasswd, err = loadHtpasswd(options.HtpasswdFile)
 	} else if options.Username != "" && options.Password != "" {
 		authorizer, err = cm_auth.NewAuthorizer(&cm_auth.AuthorizerOptions{
 			Realm:    "ChartMuseum",
@@ -159,11 +336,125 @@ func NewRouter(options RouterOptions) *Router {
 		router.Logger.Fatal(err)
 	}
 
-	if authorizer != nil && options.AnonymousGet {
-		authorizer.AnonymousActions = []string{cm_auth.PullAction}
+	router.Authorizer = authorizer
+	router.anonymousGet = options.AnonymousGet
+
+	// --auth-hmac-key="ci=secret" authenticates requests signed with the secret of key "ci", as
+	// the user "ci", alongside the other auth methods
+	if len(options.AuthHMACKeys) > 0 {
+		if router.hmac, err = newHMACAuthenticator(options.AuthHMACKeys, options.AuthHMACSkew); err != nil {
+			router.Logger.Fatal(err)
+		}
 	}
 
-	router.Authorizer = authorizer
+	// --auth-role="alice=org1/*:write org2/charts:read"
+	if len(options.AuthRoles) > 0 {
+		if router.htpasswd == nil && router.ldap == nil && router.jwt == nil && router.Authorizer == nil && router.hmac == nil && router.clientCerts == nil {
+			router.Logger.Fatal("Roles require basic, LDAP, bearer, HMAC or client certificate auth")
+		}
+		if router.roles, err = parseRoles(options.AuthRoles); err != nil {
+			router.Logger.Fatal(err)
+		}
+	}
+
+	// --auth-tenants-file="./tenants.yaml" authenticates the users of the repos of each tenant with
+	// their own htpasswd file, OIDC provider or token issuer
+	if options.AuthTenantsFile != "" {
+		if router.realms, err = loadTenantRealms(options.AuthTenantsFile); err != nil {
+			router.Logger.Fatal(err)
+		}
+	}
+
+	// --auth-token-endpoint serves /auth/token, issuing tokens to basic auth users for the scope of
+	// the challenges, signed with --auth-jwt-secret or --auth-token-key
+	if options.AuthTokenEndpoint {
+		if !options.BearerAuth {
+			router.Logger.Fatal("The token endpoint requires bearer auth")
+		}
+		router.tokens, err = newTokenIssuer(tokenIssuerOptions{
+			Secret:         options.AuthJWTSecret,
+			PrivateKeyPath: options.AuthTokenKeyPath,
+			Issuer:         options.AuthIssuer,
+			Audience:       options.AuthAudience,
+			TTL:            options.AuthTokenTTL,
+			PullMaxTTL:     options.AuthPullTokenMaxTTL,
+		}, router.basicCredentials(options, "The token endpoint"), router.roles)
+		if err != nil {
+			router.Logger.Fatal(err)
+		}
+		router.GET(options.ContextPath+TokenPath, router.tokenHandler)
+	}
+
+	// --auth-session serves a login form at /login to the browsers of the basic auth users, keeping
+	// them logged in with a session cookie signed with --auth-session-secret
+	if options.AuthSession {
+		// the LDAP groups of the users are kept in their session
+		ldap := router.ldap
+		if ldap == nil && options.AuthLDAP.URL != "" {
+			if ldap, err = newLDAPAuthenticator(options.AuthLDAP); err != nil {
+				router.Logger.Fatal(err)
+			}
+		}
+		var authenticate basicAuthenticator
+		if ldap == nil {
+			authenticate = router.basicCredentials(options, "The login form")
+		}
+		router.sessions, err = newSessionIssuer(sessionOptions{
+			Secret:      options.AuthSessionSecret,
+			TTL:         options.AuthSessionTTL,
+			ContextPath: options.ContextPath,
+		}, authenticate, ldap)
+		if err != nil {
+			router.Logger.Fatal(err)
+		}
+		if options.AuthSessionSecret == "" {
+			router.Logger.Warn("No session secret, sessions will not survive a restart nor be shared between instances")
+		}
+		router.GET(options.ContextPath+LoginPath, router.loginHandler)
+		router.POST(options.ContextPath+LoginPath, router.loginHandler)
+		router.GET(options.ContextPath+LogoutPath, router.logoutHandler)
+	}
+
+	// --auth-webhook-url="http://opa.my.site.io:8181/v1/data/chartmuseum" is asked whether to allow
+	// each request, after the other auth methods
+	if options.AuthWebhookURL != "" {
+		router.webhook = newAuthWebhook(options.AuthWebhookURL, options.AuthWebhookTimeout)
+	}
+
+	// --cors-alloworigin="https://dashboard.my.site.io,https://*.dev.my.site.io" lets dashboards of
+	// these origins call the API from browsers
+	if options.CORSAllowOrigin != "" {
+		var origins []string
+		for _, origin := range strings.Split(options.CORSAllowOrigin, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				origins = append(origins, origin)
+			}
+		}
+		router.cors, err = newCORSPolicy(corsOptions{
+			AllowOrigins:     origins,
+			AllowMethods:     options.CORSAllowMethods,
+			AllowHeaders:     options.CORSAllowHeaders,
+			AllowCredentials: options.CORSAllowCredentials,
+			MaxAge:           options.CORSMaxAge,
+		})
+		if err != nil {
+			router.Logger.Fatal(err)
+		}
+	}
+
+	// --audit-log="/var/log/chartmuseum/audit.log,https://audit.my.site.io/records" records every
+	// request changing repos
+	if len(options.AuditLog) > 0 {
+		if router.audit, err = newAuditLog(options.AuditLog); err != nil {
+			router.Logger.Fatal(err)
+		}
+	}
+
+	// --auth-cache-ttl=1m and --auth-cache-negative-ttl=10s cache the decisions of the LDAP
+	// directory and the webhook
+	if options.AuthCacheTTL > 0 || options.AuthCacheNegativeTTL > 0 {
+		router.authCache = newDecisionCache(options.AuthCacheTTL, options.AuthCacheNegativeTTL)
+	}
 
 	router.NoRoute(router.rootHandler)
 
@@ -182,18 +473,22 @@ func (router *Router) Start(port int) {
 		WriteTimeout: router.WriteTimeout,
 	}
 
-	if router.TlsCert != "" && router.TlsKey != "" {
-		if router.TlsCACert != "" {
-			keypair, _ := tls.LoadX509KeyPair(router.TlsCert, router.TlsKey)
-			certpool := x509.NewCertPool()
-			capem, _ := os.ReadFile(router.TlsCACert)
-			if !certpool.AppendCertsFromPEM(capem) {
-				router.Logger.Fatal("Can't parse CA certificate file")
+	if router.TlsCertFunc != nil || (router.TlsCert != "" && router.TlsKey != "") {
+		if router.TlsCACert != "" || router.TlsCertFunc != nil {
+			server.TLSConfig = &tls.Config{GetCertificate: router.TlsCertFunc}
+			if router.TlsCertFunc == nil {
+				keypair, _ := tls.LoadX509KeyPair(router.TlsCert, router.TlsKey)
+				server.TLSConfig.Certificates = []tls.Certificate{keypair}
 			}
-			server.TLSConfig = &tls.Config{
-				Certificates: []tls.Certificate{keypair},
-				ClientAuth:   tls.RequireAndVerifyClientCert,
-				ClientCAs:    certpool,
+			if router.TlsCACert != "" {
+				certpool := x509.NewCertPool()
+				capem, _ := os.ReadFile(router.TlsCACert)
+				if !certpool.AppendCertsFromPEM(capem) {
+					router.Logger.Fatal("Can't parse CA certificate file")
+				}
+				server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+				server.TLSConfig.ClientCAs = certpool
+				server.TLSConfig.VerifyConnection = router.clientCerts.verifyConnection
 			}
 			router.Logger.Fatal(server.ListenAndServeTLS("", ""))
 		} else {
@@ -211,6 +506,11 @@ func (router *Router) SetRoutes(routes []*Route) {
 
 // all incoming requests are passed through this handler
 func (router *Router) rootHandler(c *gin.Context) {
+	isAPIRoute := router.cors != nil && checkApiRoute(strings.TrimPrefix(c.Request.URL.Path, router.ContextPath))
+	if isAPIRoute && isPreflight(c) {
+		router.cors.preflight(c)
+		return
+	}
 	route, params := match(router.Routes, c.Request.Method, c.Request.URL.Path, router.ContextPath, router.Depth,
 		router.DepthDynamic)
 	if route == nil {
@@ -218,36 +518,388 @@ func (router *Router) rootHandler(c *gin.Context) {
 		return
 	}
 	c.Params = params
+	// set before auth, so that browsers can read the errors
+	if isAPIRoute {
+		router.cors.setHeaders(c)
+	}
+	if router.audit != nil && isWriteMethod(c.Request.Method) {
+		defer func() {
+			for _, err := range router.audit.write(router.audit.records(c, route.Action, router.clientAddress(c))) {
+				router.Logger.Errorc(c, "Failed to write audit record", "error", err)
+			}
+		}()
+	}
+	if router.ipFilter != nil && !router.ipFilter.allows(router.clientAddress(c), route.Action) {
+		c.JSON(403, gin.H{"error": "forbidden"})
+		return
+	}
+	limiter := router.readLimiter
+	if isWriteAction(route.Action) {
+		limiter = router.writeLimiter
+	}
+	if route.Action == "" {
+		limiter = nil
+	}
+	// limits by user are checked once the request is authenticated
+	if limiter != nil && router.rateLimitBy == RateLimitByIP && !router.allowRate(c, limiter, "") {
+		return
+	}
+	c.Set("repo", c.Param("repo"))
+	// the client of a verified certificate is the user, unless authenticated otherwise
+	if c.Request.TLS != nil && len(c.Request.TLS.PeerCertificates) > 0 {
+		c.Set("clientcert", c.Request.TLS.PeerCertificates[0])
+		c.Set("user", clientIdentity(c.Request.TLS.PeerCertificates[0]))
+	}
+
+	if action := route.Action; action != "" && !router.isAnonymous(c, action) {
+		decision := router.decide(c, action, c.GetString("repo"))
+		if limiter != nil && router.rateLimitBy == RateLimitByToken && !router.allowRate(c, limiter, decision.user) {
+			return
+		}
+		if !router.respond(c, decision) {
+			return
+		}
+	} else if limiter != nil && router.rateLimitBy == RateLimitByToken && !router.allowRate(c, limiter, "") {
+		return
+	}
+
+	route.Handler(c)
+}
+
+// allowRate tells whether the client of a request, authenticated as user if set, is within the
+// rate limit of limiter, responding with a 429 otherwise
+func (router *Router) allowRate(c *gin.Context, limiter *rateLimiter, user string) bool {
+	client := rateLimitedClient(router.rateLimitBy, router.clientAddress(c), user)
+	allowed, retryAfter := limiter.allow(client)
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(429, gin.H{"error": "too many requests"})
+	}
+	return allowed
+}
+
+// authDecision is the outcome of authorizing a request: the user and API key the client acts as
+// when allowed, or the status and WWW-Authenticate challenge of the error otherwise. login sends
+// browsers to the login form instead
+type authDecision struct {
+	allowed   bool
+	user      string
+	apiKey    string
+	status    int
+	challenge string
+	login     bool
+}
 
-	if route.Action != "" && router.Authorizer != nil {
+// authorize authenticates the client of a request and tells whether it may perform action on the
+// repo of the request, responding with the error otherwise
+func (router *Router) authorize(c *gin.Context, action string) bool {
+	return router.respond(c, router.decide(c, action, c.GetString("repo")))
+}
+
+// respond applies an authorization decision to a request, setting the user the client acts as,
+// or responding with the error
+func (router *Router) respond(c *gin.Context, decision authDecision) bool {
+	if decision.login {
+		router.sessions.redirectToLogin(c)
+		return false
+	}
+	if !decision.allowed {
+		if decision.challenge != "" {
+			c.Header("WWW-Authenticate", decision.challenge)
+		}
+		c.JSON(decision.status, gin.H{"error": authErrors[decision.status]})
+		return false
+	}
+	c.Set("user", decision.user)
+	if decision.apiKey != "" {
+		c.Set("apikey", decision.apiKey)
+	}
+	return true
+}
+
+// authErrors are the error messages of the statuses of denied requests
+var authErrors = map[int]string{
+	401: "unauthorized",
+	403: "forbidden",
+	500: "internal server error",
+}
+
+// decide authenticates the client of a request and tells whether it may perform action on repo,
+// without responding to the request
+func (router *Router) decide(c *gin.Context, action string, repo string) authDecision {
+	unauthorized := func(challenge string) authDecision {
+		return authDecision{status: 401, challenge: challenge}
+	}
+	forbidden := authDecision{status: 403}
+	failed := authDecision{status: 500}
+
+	user := c.GetString("user")
+	namespace := router.namespace(repo)
+	// the users of the repos of tenants with their own realm are authenticated by the realm only
+	var realm *tenantRealm
+	userRoles := router.roles
+	if realm = router.tenantRealm(repo); realm != nil {
+		userRoles = realm.roles
+	}
+	// browsers reading the UI without credentials are authenticated by their session, or sent to
+	// the login form
+	var session *session
+	if realm == nil && router.sessions != nil && c.Request.Header.Get("Authorization") == "" && acceptsSession(c, action, router.ContextPath) {
+		session = router.sessions.sessionOf(c)
+		if session == nil && user == "" && acceptsHTML(c) {
+			return authDecision{login: true}
+		}
+	}
+	apiKey := ""
+	if router.apiKeys != nil {
+		apiKey = apiKeySecret(c.Request)
+	}
+	var keyID string
+	if session != nil {
+		if !router.sessions.allows(session, action, namespace) {
+			return forbidden
+		}
+		user = session.user
+	} else if apiKey != "" {
+		key, err := router.apiKeys(repo, apiKey)
+		if err != nil {
+			router.Logger.Error(err)
+			return failed
+		}
+		if key == nil || !key.Grants(action) {
+			return unauthorized("")
+		}
+		// the key acts on behalf of its creator, still restricted to their roles
+		user, keyID = key.User, key.ID
+	} else if realm != nil {
+		realmUser, allowed, challenge := realm.authorize(c.Request, action, namespace)
+		if !allowed {
+			return unauthorized(challenge)
+		}
+		user = realmUser
+	} else if router.hmac != nil && isHMACSigned(c.Request) {
+		hmacUser, err := router.hmac.authenticate(c.Request)
+		if err != nil {
+			router.Logger.Debugc(c, "Rejected HMAC signature", "error", err)
+			return unauthorized("")
+		}
+		user = hmacUser
+	} else if router.htpasswd != nil {
+		if !router.authenticateBasic(c) {
+			return unauthorized(`Basic realm="ChartMuseum"`)
+		}
+		user, _, _ = c.Request.BasicAuth()
+	} else if router.ldap != nil {
+		basicUser, password, _ := c.Request.BasicAuth()
+		allowed, err := router.authCache.decide(func() (bool, error) {
+			return router.ldap.authorize(basicUser, password, action, namespace)
+		}, "ldap", basicUser, password, action, namespace)
+		if err != nil {
+			router.Logger.Error(err)
+			return failed
+		}
+		if !allowed {
+			return unauthorized(`Basic realm="ChartMuseum"`)
+		}
+		user = basicUser
+	} else if router.jwt != nil {
+		if allowed, challenge := router.jwt.authorize(c.Request.Header.Get("Authorization"), action, namespace); !allowed {
+			return unauthorized(challenge)
+		}
+		user = tokenSubject(c.Request.Header.Get("Authorization"))
+	} else if router.Authorizer != nil {
 		authHeader := c.Request.Header.Get("Authorization")
 
-		namespace := c.Param("repo")
-		if namespace == "" {
-			namespace = cm_auth.DefaultNamespace
+		authorizerAction := action
+		if authorizerAction == DeleteAction {
+			authorizerAction = cm_auth.PushAction
 		}
 
-		permissions, err := router.Authorizer.Authorize(authHeader, route.Action, namespace)
+		permissions, err := router.Authorizer.Authorize(authHeader, authorizerAction, namespace)
 		if err != nil {
 			router.Logger.Error(err)
-			c.JSON(500, gin.H{"error": "internal server error"})
-			return
+			return failed
 		}
 
 		if !permissions.Allowed {
-			if permissions.WWWAuthenticateHeader != "" {
-				c.Header("WWW-Authenticate", permissions.WWWAuthenticateHeader)
+			return unauthorized(permissions.WWWAuthenticateHeader)
+		}
+		if basicUser, _, ok := c.Request.BasicAuth(); ok {
+			user = basicUser
+		} else {
+			user = tokenSubject(authHeader)
+		}
+	}
+
+	if userRoles != nil && !userRoles.allows(user, action, repo) {
+		return forbidden
+	}
+
+	if router.webhook != nil {
+		input := router.webhookInput(c, action, repo, user)
+		allowed, err := router.authCache.decide(func() (bool, error) {
+			return router.webhook.authorize(c.Request.Context(), input)
+		}, input.cacheKey()...)
+		if err != nil {
+			router.Logger.Error(err)
+			return failed
+		}
+		if !allowed {
+			if c.Request.Header.Get("Authorization") == "" && user == "" {
+				return unauthorized(`Basic realm="ChartMuseum"`)
 			}
-			c.JSON(401, gin.H{"error": "unauthorized"})
-			return
+			return forbidden
 		}
 	}
+	return authDecision{allowed: true, user: user, apiKey: keyID}
+}
 
-	if checkApiRoute(c.Request.URL.Path) && router.CORSAllowOrigin != "" {
-		c.Header("Access-Control-Allow-Origin", router.CORSAllowOrigin)
+// AuthorizeRepo tells whether the client of a request may also perform action on repo, such as the
+// destination of a chart copied from the repo of the request, responding with the error otherwise
+func (router *Router) AuthorizeRepo(c *gin.Context, action string, repo string) bool {
+	if router.isAnonymous(c, action) {
+		return true
 	}
+	return router.respond(c, router.decide(c, action, repo))
+}
 
-	route.Handler(c)
+// AllowsRepo tells whether the client of a request may also perform action on repo, as
+// AuthorizeRepo does, without responding with the error, e.g. to leave out the charts of the repos
+// the client cannot read from a response
+func (router *Router) AllowsRepo(c *gin.Context, action string, repo string) bool {
+	if router.isAnonymous(c, action) {
+		return true
+	}
+	return router.decide(c, action, repo).allowed
+}
+
+// clientAddress is the address of the client of a request, the one of the connection unless it
+// is a trusted proxy
+func (router *Router) clientAddress(c *gin.Context) string {
+	if router.trustProxies {
+		return c.ClientIP()
+	}
+	return c.RemoteIP()
+}
+
+// namespace is the repo checked against the scope of bearer tokens
+func (router *Router) namespace(repo string) string {
+	if repo != "" {
+		return repo
+	}
+	return cm_auth.DefaultNamespace
+}
+
+// isAnonymous tells whether a request is allowed without credentials, reading with GET or HEAD
+// when anonymous GET is enabled. Routes pulling with another method, such as the verification of
+// a chart, still require credentials
+func (router *Router) isAnonymous(c *gin.Context, action string) bool {
+	method := c.Request.Method
+	return router.anonymousGet && action == cm_auth.PullAction && (method == http.MethodGet || method == http.MethodHead)
+}
+
+// basicCredentials returns the authenticator of the basic auth users of the token endpoint and of
+// the login form, named by feature in the error logged without users
+func (router *Router) basicCredentials(options RouterOptions, feature string) basicAuthenticator {
+	switch {
+	case options.AuthLDAP.URL != "":
+		ldap, err := newLDAPAuthenticator(options.AuthLDAP)
+		if err != nil {
+			router.Logger.Fatal(err)
+		}
+		return ldap.authorize
+	case options.HtpasswdFile != "":
+		users, err := loadHtpasswd(options.HtpasswdFile)
+		if err != nil {
+			router.Logger.Fatal(err)
+		}
+		return func(user string, password string, _ string, _ string) (bool, error) {
+			return users.authenticate(user, password), nil
+		}
+	case options.Username != "" && options.Password != "":
+		return passwordAuthenticator(options.Username, options.Password)
+	}
+	router.Logger.Fatalf("%s requires basic auth users, in an htpasswd file, an LDAP directory or a single user", feature)
+	return nil
+}
+
+// tokenHandler serves the token endpoint of the registry token protocol
+func (router *Router) tokenHandler(c *gin.Context) {
+	var scopes []string
+	for _, scope := range c.QueryArray("scope") {
+		scopes = append(scopes, strings.Fields(scope)...)
+	}
+	var response *tokenResponse
+	var err error
+	if user, password, ok := c.Request.BasicAuth(); ok {
+		response, err = router.tokens.issue(user, password, scopes, c.Query("service"))
+	}
+	var invalidScope *invalidScopeError
+	switch {
+	case errors.As(err, &invalidScope):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case err != nil:
+		router.Logger.Error(err)
+		c.JSON(500, gin.H{"error": "internal server error"})
+	case response == nil:
+		c.Header("WWW-Authenticate", `Basic realm="ChartMuseum"`)
+		c.JSON(401, gin.H{"error": "unauthorized"})
+	default:
+		c.JSON(200, response)
+	}
+}
+
+// IssuesPullTokens tells whether IssuePullToken mints tokens, when the token endpoint is served
+func (router *Router) IssuesPullTokens() bool {
+	return router.tokens != nil
+}
+
+// IssuePullToken mints a token granting to pull the charts of repo for ttl, or for a day if zero,
+// on behalf of user. It is verified as the other bearer tokens, and can't be revoked
+func (router *Router) IssuePullToken(user string, repo string, ttl time.Duration) (*PullToken, error) {
+	if router.tokens == nil {
+		return nil, ErrPullTokensDisabled
+	}
+	return router.tokens.pullToken(user, repo, ttl)
+}
+
+// AuthMethods lists the methods clients may authenticate with, none when auth is disabled
+func (router *Router) AuthMethods() []string {
+	methods := []string{}
+	if router.htpasswd != nil || (router.Authorizer != nil && router.Authorizer.Type == cm_auth.BasicAuthAuthorizerType) {
+		methods = append(methods, "basic")
+	}
+	if router.jwt != nil || (router.Authorizer != nil && router.Authorizer.Type == cm_auth.BearerAuthAuthorizerType) {
+		methods = append(methods, "bearer")
+	}
+	if router.ldap != nil {
+		methods = append(methods, "ldap")
+	}
+	if router.hmac != nil {
+		methods = append(methods, "hmac")
+	}
+	if router.clientCerts != nil {
+		methods = append(methods, "client_cert")
+	}
+	// API keys are only checked when requests are authenticated
+	if router.apiKeys != nil && len(methods) > 0 {
+		methods = append(methods, "api_key")
+	}
+	if router.sessions != nil {
+		methods = append(methods, "session")
+	}
+	return methods
+}
+
+// AnonymousGet tells whether charts may be pulled without credentials when auth is enabled
+func (router *Router) AnonymousGet() bool {
+	return router.anonymousGet
+}
+
+// authenticateBasic checks the basic auth credentials of a request against the htpasswd file
+func (router *Router) authenticateBasic(c *gin.Context) bool {
+	user, password, ok := c.Request.BasicAuth()
+	return ok && router.htpasswd.authenticate(user, password)
 }
 
 /*
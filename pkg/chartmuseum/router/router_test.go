@@ -88,6 +88,9 @@ func (suite *RouterTestSuite) TestRouterHandleContext() {
 		{"GET", "/api/:repo/systemstats", func(c *gin.Context) {
 			c.Data(200, "text/html", []byte(c.GetString("repo")))
 		}, cm_auth.PullAction},
+		{"POST", "/api/:repo/verify", func(c *gin.Context) {
+			c.Data(200, "text/html", []byte(c.GetString("repo")))
+		}, cm_auth.PullAction},
 	}
 
 	// Test route transformations
@@ -200,6 +203,22 @@ func (suite *RouterTestSuite) TestRouterHandleContext() {
 	basicAuthRouterAnonGet.HandleContext(testContext)
 	suite.Equal(200, testContext.Writer.Status())
 
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("POST", "/api/verify", nil)
+	basicAuthRouterAnonGet.HandleContext(testContext)
+	suite.Equal(401, testContext.Writer.Status(), "pulling with POST requires credentials")
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("POST", "/api/verify", nil)
+	testContext.Request.SetBasicAuth("testuser", "testpass")
+	basicAuthRouterAnonGet.HandleContext(testContext)
+	suite.Equal(200, testContext.Writer.Status())
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("POST", "/api/writetorepo", nil)
+	basicAuthRouterAnonGet.HandleContext(testContext)
+	suite.Equal(401, testContext.Writer.Status(), "anonymous push")
+
 	// Client Certificate Auth
 	clientAuthRouter := NewRouter(RouterOptions{
 		Logger:    log,
@@ -264,6 +283,41 @@ func (suite *RouterTestSuite) TestRouterHandleContext() {
 	suite.Equal(401, testContext.Writer.Status())
 }
 
+func (suite *RouterTestSuite) TestAuthMethods() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	router := NewRouter(RouterOptions{Logger: log})
+	suite.Equal([]string{}, router.AuthMethods())
+	suite.False(router.AnonymousGet())
+	router.SetAPIKeyVerifier(func(string, string) (*APIKey, error) { return nil, nil })
+	suite.Equal([]string{}, router.AuthMethods(), "no API keys without auth")
+
+	router = NewRouter(RouterOptions{
+		Logger:       log,
+		Username:     "testuser",
+		Password:     "testpass",
+		AnonymousGet: true,
+		AuthHMACKeys: map[string]string{"ci": "secret"},
+	})
+	suite.Equal([]string{"basic", "hmac"}, router.AuthMethods())
+	router.SetAPIKeyVerifier(func(string, string) (*APIKey, error) { return nil, nil })
+	suite.Equal([]string{"basic", "hmac", "api_key"}, router.AuthMethods())
+	suite.True(router.AnonymousGet())
+
+	router = NewRouter(RouterOptions{
+		Logger:       log,
+		BearerAuth:   true,
+		AuthRealm:    "https://my.site.io/oauth2/token",
+		AuthService:  "my.site.io",
+		AuthCertPath: testPublicKey,
+		TlsKey:       testClientAuthKey,
+		TlsCert:      testClientAuthCert,
+		TlsCACert:    testClientAuthCA,
+	})
+	suite.Equal([]string{"bearer", "client_cert"}, router.AuthMethods())
+}
+
 func (suite *RouterTestSuite) TestMapURLWithParamsBackToRouteTemplate() {
 	tests := []struct {
 		ctx    *gin.Context
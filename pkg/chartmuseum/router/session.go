@@ -0,0 +1,263 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/rand"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// LoginPath and LogoutPath are the paths of the login form of the browsing UI and of its
+	// logout, relative to the context path
+	LoginPath  = "/login"
+	LogoutPath = "/logout"
+
+	// SessionCookie is the name of the cookie holding the session of a browser
+	SessionCookie = "chartmuseum_session"
+
+	defaultSessionTTL = 12 * time.Hour
+
+	// sessionAudience is the aud claim of sessions, so that the tokens of the token endpoint signed
+	// with the same secret are not mistaken for them
+	sessionAudience = "chartmuseum-session"
+)
+
+var loginPageTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Log in to ChartMuseum</title>
+<style>
+    body {
+        width: 35em;
+        margin: 0 auto;
+        font-family: Tahoma, Verdana, Arial, sans-serif;
+    }
+</style>
+</head>
+<body>
+<h1>Log in to ChartMuseum</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="next" value="{{.Next}}">
+<p><label>Username <input type="text" name="username" autocomplete="username" required autofocus></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><button type="submit">Log in</button></p>
+</form>
+</body>
+</html>
+`))
+
+type (
+	// sessionIssuer logs in the users of a browser with a form, checking their credentials against
+	// the basic auth users, and keeps them logged in with a signed session cookie. Sessions are
+	// only accepted to read outside of the API, whose clients keep sending their credentials
+	sessionIssuer struct {
+		authenticate basicAuthenticator
+		// ldap authenticates the users instead when set, their groups kept in the session so
+		// that the group permissions still apply
+		ldap        *ldapAuthenticator
+		secret      []byte
+		ttl         time.Duration
+		contextPath string
+	}
+
+	// sessionOptions are the settings of a sessionIssuer
+	sessionOptions struct {
+		// Secret signs the sessions with HS256, a random secret being used if empty so that the
+		// sessions do not survive a restart
+		Secret      string
+		TTL         time.Duration
+		ContextPath string
+	}
+
+	// session is the user of a browser, and their LDAP groups
+	session struct {
+		user   string
+		groups []string
+	}
+
+	// loginPage is the data of loginPageTemplate
+	loginPage struct {
+		Action string
+		Next   string
+		Error  string
+	}
+)
+
+func newSessionIssuer(options sessionOptions, authenticate basicAuthenticator, ldap *ldapAuthenticator) (*sessionIssuer, error) {
+	issuer := &sessionIssuer{
+		authenticate: authenticate,
+		ldap:         ldap,
+		secret:       []byte(options.Secret),
+		ttl:          options.TTL,
+		contextPath:  options.ContextPath,
+	}
+	if options.Secret == "" {
+		issuer.secret = make([]byte, 32)
+		if _, err := rand.Read(issuer.secret); err != nil {
+			return nil, err
+		}
+	}
+	if issuer.ttl == 0 {
+		issuer.ttl = defaultSessionTTL
+	}
+	return issuer, nil
+}
+
+// login returns the signed session of user, empty if the credentials are invalid. The error is set
+// if the credentials could not be checked
+func (s *sessionIssuer) login(user string, password string) (string, error) {
+	var groups []string
+	var authenticated bool
+	var err error
+	if s.ldap != nil {
+		groups, authenticated, err = s.ldap.authenticate(user, password)
+	} else {
+		authenticated, err = s.authenticate(user, password, "", "")
+	}
+	if err != nil || !authenticated {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub": user,
+		"aud": sessionAudience,
+		"iat": now.Unix(),
+		"exp": now.Add(s.ttl).Unix(),
+	}
+	if groups != nil {
+		claims["groups"] = groups
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+}
+
+// session returns the session of a signed session cookie, nil if it is invalid or expired
+func (s *sessionIssuer) session(cookie string) *session {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(cookie, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		return s.secret, nil
+	})
+	if err != nil || !token.Valid || !claims.VerifyAudience(sessionAudience, true) {
+		return nil
+	}
+	user, _ := claims["sub"].(string)
+	if user == "" {
+		return nil
+	}
+	return &session{user: user, groups: claimStrings(claims["groups"])}
+}
+
+// sessionOf returns the session of the browser of c, nil without a valid session cookie
+func (s *sessionIssuer) sessionOf(c *gin.Context) *session {
+	cookie, err := c.Cookie(SessionCookie)
+	if err != nil {
+		return nil
+	}
+	return s.session(cookie)
+}
+
+// allows tells whether a session is granted action on namespace by the LDAP groups of the user
+func (s *sessionIssuer) allows(session *session, action string, namespace string) bool {
+	return s.ldap == nil || s.ldap.allows(session.groups, action, namespace)
+}
+
+// loginHandler serves the login form of the browsing UI, and logs in the users posting it
+func (router *Router) loginHandler(c *gin.Context) {
+	s := router.sessions
+	page := loginPage{Action: s.contextPath + LoginPath, Next: s.next(c.Query("next"))}
+	if c.Request.Method == http.MethodGet {
+		s.render(c, 200, page)
+		return
+	}
+	page.Next = s.next(c.PostForm("next"))
+	user := c.PostForm("username")
+	cookie, err := s.login(user, c.PostForm("password"))
+	switch {
+	case err != nil:
+		router.Logger.Error(err)
+		page.Error = "Your credentials could not be checked, please try again later."
+		s.render(c, 500, page)
+	case cookie == "":
+		page.Error = "Invalid username or password."
+		s.render(c, 401, page)
+	default:
+		s.setCookie(c, cookie, int(s.ttl.Seconds()))
+		router.Logger.Debugc(c, "Logged in", "user", user)
+		c.Redirect(303, page.Next)
+	}
+}
+
+// logoutHandler clears the session cookie
+func (router *Router) logoutHandler(c *gin.Context) {
+	router.sessions.setCookie(c, "", -1)
+	c.Redirect(303, router.sessions.contextPath+LoginPath)
+}
+
+// redirectToLogin sends a browser to the login form, back to the page it requested once logged in
+func (s *sessionIssuer) redirectToLogin(c *gin.Context) {
+	c.Redirect(302, s.contextPath+LoginPath+"?"+url.Values{"next": {c.Request.URL.RequestURI()}}.Encode())
+}
+
+func (s *sessionIssuer) render(c *gin.Context, status int, page loginPage) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	loginPageTemplate.Execute(c.Writer, page)
+}
+
+func (s *sessionIssuer) setCookie(c *gin.Context, value string, maxAge int) {
+	path := s.contextPath
+	if path == "" {
+		path = "/"
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SessionCookie, value, maxAge, path, "", c.Request.TLS != nil, true)
+}
+
+// next is the page to redirect to after logging in, only a path of this server so that the form
+// cannot send users to other sites
+func (s *sessionIssuer) next(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, `\`) {
+		return s.contextPath + "/"
+	}
+	return next
+}
+
+// acceptsSession tells whether a request may be authenticated by a session: a read of the
+// browsing UI, outside of the API
+func acceptsSession(c *gin.Context, action string, contextPath string) bool {
+	method := c.Request.Method
+	return action == cm_auth.PullAction && (method == http.MethodGet || method == http.MethodHead) &&
+		!checkApiRoute(strings.TrimPrefix(c.Request.URL.Path, contextPath))
+}
+
+// acceptsHTML tells whether the client of a request is a browser navigating to a page
+func acceptsHTML(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "text/html")
+}
@@ -0,0 +1,159 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type SessionTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SessionTestSuite) TestLogin() {
+	issuer, err := newSessionIssuer(sessionOptions{Secret: "secret", TTL: time.Minute}, passwordAuthenticator("alice", "wonderland"), nil)
+	suite.Nil(err)
+	cookie, err := issuer.login("alice", "wonderland")
+	suite.Nil(err)
+	suite.Equal("alice", issuer.session(cookie).user)
+	cookie, err = issuer.login("alice", "wrong")
+	suite.Nil(err)
+	suite.Equal("", cookie, "invalid credentials")
+
+	other, err := newSessionIssuer(sessionOptions{}, passwordAuthenticator("alice", "wonderland"), nil)
+	suite.Nil(err)
+	cookie, _ = other.login("alice", "wonderland")
+	suite.Nil(issuer.session(cookie), "session signed with another secret")
+	suite.Nil(issuer.session("invalid"))
+	tokens, err := newTokenIssuer(tokenIssuerOptions{Secret: "secret"}, passwordAuthenticator("alice", "wonderland"), nil)
+	suite.Nil(err)
+	token, _ := tokens.issue("alice", "wonderland", nil, "")
+	suite.Nil(issuer.session(token.Token), "token of the token endpoint signed with the same secret")
+
+	suite.Equal("/charts/org1/index.yaml", issuer.next("/charts/org1/index.yaml"))
+	suite.Equal("/", issuer.next("//evil.example.com"), "no redirection to other sites")
+	suite.Equal("/", issuer.next("https://evil.example.com"))
+	suite.Equal("/", issuer.next(""))
+}
+
+func (suite *SessionTestSuite) TestLDAPGroups() {
+	directory := &fakeDirectory{
+		passwords: map[string]string{"uid=alice,ou=people,dc=example,dc=com": "wonderland"},
+		groups:    map[string][]string{"uid=alice,ou=people,dc=example,dc=com": {"developers"}},
+	}
+	authenticator, err := newLDAPAuthenticator(LDAPOptions{
+		URL:         "ldap://ldap.example.com",
+		UserBaseDN:  "ou=people,dc=example,dc=com",
+		GroupBaseDN: "ou=groups,dc=example,dc=com",
+		Groups:      map[string]string{"developers": "org1/*:pull"},
+	})
+	suite.Nil(err)
+	authenticator.dial = func() (ldap.Client, error) { return directory, nil }
+	issuer, err := newSessionIssuer(sessionOptions{Secret: "secret"}, nil, authenticator)
+	suite.Nil(err)
+
+	cookie, err := issuer.login("alice", "wonderland")
+	suite.Nil(err)
+	session := issuer.session(cookie)
+	suite.Equal([]string{"developers"}, session.groups, "groups kept in the session")
+	suite.True(issuer.allows(session, cm_auth.PullAction, "org1/repo1"))
+	suite.False(issuer.allows(session, cm_auth.PullAction, "org2/repo1"), "repo not granted to the groups")
+}
+
+func (suite *SessionTestSuite) TestRouter() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	router := NewRouter(RouterOptions{
+		Logger:            log,
+		Depth:             1,
+		MaxUploadSize:     1 << 20,
+		Username:          "alice",
+		Password:          "wonderland",
+		AuthSession:       true,
+		AuthSessionSecret: "secret",
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/", func(c *gin.Context) { c.String(200, c.GetString("user")) }, cm_auth.PullAction},
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.String(200, c.GetString("user")) }, cm_auth.PullAction},
+		{"GET", "/api/:repo/charts", func(c *gin.Context) { c.Status(200) }, cm_auth.PullAction},
+	})
+	serve := func(request *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	browse := func(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest("GET", path, nil)
+		request.Header.Set("Accept", "text/html,application/xhtml+xml")
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		return serve(request)
+	}
+	login := func(username string, password string, next string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {username}, "password": {password}, "next": {next}}
+		request, _ := http.NewRequest("POST", LoginPath, strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(request)
+	}
+
+	response := browse("/org1/index.yaml")
+	suite.Equal(302, response.Code, "browser sent to the login form")
+	suite.Equal("/login?next=%2Forg1%2Findex.yaml", response.Header().Get("Location"))
+	request, _ := http.NewRequest("GET", "/org1/index.yaml", nil)
+	suite.Equal(401, serve(request).Code, "other clients challenged")
+
+	response = browse("/login?next=%2Forg1%2Findex.yaml")
+	suite.Equal(200, response.Code)
+	suite.Contains(response.Body.String(), `<input type="hidden" name="next" value="/org1/index.yaml">`)
+	response = login("alice", "wrong", "/org1/index.yaml")
+	suite.Equal(401, response.Code)
+	suite.Contains(response.Body.String(), "Invalid username or password.")
+
+	response = login("alice", "wonderland", "/org1/index.yaml")
+	suite.Equal(303, response.Code)
+	suite.Equal("/org1/index.yaml", response.Header().Get("Location"), "back to the page requested")
+	cookies := response.Result().Cookies()
+	suite.Len(cookies, 1)
+	suite.Equal(SessionCookie, cookies[0].Name)
+	suite.True(cookies[0].HttpOnly)
+	response = browse("/org1/index.yaml", cookies[0])
+	suite.Equal(200, response.Code)
+	suite.Equal("alice", response.Body.String(), "user of the session")
+	suite.Equal(200, browse("/", cookies[0]).Code)
+	suite.Equal(401, browse("/api/org1/charts", cookies[0]).Code, "sessions not accepted by the API")
+
+	response = browse(LogoutPath, cookies[0])
+	suite.Equal(303, response.Code)
+	suite.Equal(-1, response.Result().Cookies()[0].MaxAge, "session cookie cleared")
+}
+
+func TestSessionTestSuite(t *testing.T) {
+	suite.Run(t, new(SessionTestSuite))
+}
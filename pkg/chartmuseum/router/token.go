@@ -0,0 +1,259 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// TokenPath is the path of the endpoint issuing bearer tokens, relative to the context path
+	TokenPath = "/auth/token"
+
+	defaultTokenTTL = 5 * time.Minute
+
+	defaultPullTokenTTL    = 24 * time.Hour
+	defaultPullTokenMaxTTL = 7 * 24 * time.Hour
+
+	// registryScopeType is the resource type of the scopes requested by registry clients, granted
+	// as access entries of cm_auth.AccessEntryType
+	registryScopeType = "repository"
+)
+
+type (
+	// tokenIssuer issues the bearer tokens of the registry token protocol to basic auth users: the
+	// clients challenged with WWW-Authenticate: Bearer realm=... request a token for the scope of
+	// the challenge from the realm, and retry with it
+	tokenIssuer struct {
+		authenticate basicAuthenticator
+		roles        roles
+		method       jwt.SigningMethod
+		key          interface{}
+		issuer       string
+		audience     string
+		ttl          time.Duration
+		pullMaxTTL   time.Duration
+	}
+
+	// tokenIssuerOptions are the settings of a tokenIssuer
+	tokenIssuerOptions struct {
+		// Secret signs tokens with HS256, unless PrivateKeyPath signs them with RS256
+		Secret         string
+		PrivateKeyPath string
+		Issuer         string
+		// Audience is the aud claim of tokens, the service requested by the client if empty
+		Audience string
+		TTL      time.Duration
+		// PullMaxTTL is the longest lifetime of pull tokens
+		PullMaxTTL time.Duration
+	}
+
+	// basicAuthenticator tells whether the credentials of a basic auth user grant action on
+	// namespace, or are valid when action is empty
+	basicAuthenticator func(user string, password string, action string, namespace string) (bool, error)
+
+	// invalidScopeError is returned for a scope that is not of the registry token protocol
+	invalidScopeError struct {
+		scope string
+	}
+
+	// tokenResponse is the response of the token endpoint, with the token under the field names of
+	// both registry and OAuth 2 clients
+	tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		IssuedAt    string `json:"issued_at"`
+	}
+
+	// PullToken is a bearer token granting to pull the charts of a single repo until it expires,
+	// e.g. to share a private chart, minted on behalf of a user of the repo
+	PullToken struct {
+		Token   string    `json:"token"`
+		Repo    string    `json:"repo"`
+		User    string    `json:"user,omitempty"`
+		Expires time.Time `json:"expires"`
+	}
+)
+
+// ErrPullTokensDisabled is returned by IssuePullToken when the server issues no tokens
+var ErrPullTokensDisabled = errors.New("pull tokens require the token endpoint")
+
+func (e *invalidScopeError) Error() string {
+	return fmt.Sprintf("invalid scope %q", e.scope)
+}
+
+func newTokenIssuer(options tokenIssuerOptions, authenticate basicAuthenticator, r roles) (*tokenIssuer, error) {
+	issuer := &tokenIssuer{
+		authenticate: authenticate,
+		roles:        r,
+		issuer:       options.Issuer,
+		audience:     options.Audience,
+		ttl:          options.TTL,
+		pullMaxTTL:   options.PullMaxTTL,
+	}
+	if issuer.ttl == 0 {
+		issuer.ttl = defaultTokenTTL
+	}
+	if issuer.pullMaxTTL == 0 {
+		issuer.pullMaxTTL = defaultPullTokenMaxTTL
+	}
+	if options.PrivateKeyPath != "" {
+		pem, err := os.ReadFile(options.PrivateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		if issuer.key, err = jwt.ParseRSAPrivateKeyFromPEM(pem); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", options.PrivateKeyPath, err)
+		}
+		issuer.method = jwt.SigningMethodRS256
+	} else if options.Secret != "" {
+		issuer.key = []byte(options.Secret)
+		issuer.method = jwt.SigningMethodHS256
+	} else {
+		return nil, errors.New("the token endpoint requires a JWT secret or a private key")
+	}
+	return issuer, nil
+}
+
+// issue returns a token granting the actions of scopes that the basic auth user is allowed, for
+// service. It is nil if the credentials are invalid, and the error is set if a scope is invalid or
+// the credentials could not be checked
+func (t *tokenIssuer) issue(user string, password string, scopes []string, service string) (*tokenResponse, error) {
+	// the credentials are checked even without scopes, such as by docker login
+	if authenticated, err := t.authenticate(user, password, "", ""); err != nil || !authenticated {
+		return nil, err
+	}
+	access := []cm_auth.AccessEntry{}
+	for _, scope := range scopes {
+		entry, err := parseScope(scope)
+		if err != nil {
+			return nil, err
+		}
+		if entry, err = t.grant(user, password, entry); err != nil {
+			return nil, err
+		}
+		access = append(access, entry)
+	}
+
+	now := time.Now()
+	token, err := t.sign(user, access, service, now, t.ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenResponse{
+		Token:       token,
+		AccessToken: token,
+		ExpiresIn:   int(t.ttl.Seconds()),
+		IssuedAt:    now.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// pullToken returns a token granting user's pull on repo for ttl, the default lifetime if zero.
+// The user is expected to be allowed to share the repo
+func (t *tokenIssuer) pullToken(user string, repo string, ttl time.Duration) (*PullToken, error) {
+	if ttl < 0 || ttl > t.pullMaxTTL {
+		return nil, fmt.Errorf("the lifetime of pull tokens must be positive and at most %s", t.pullMaxTTL)
+	}
+	if ttl == 0 {
+		ttl = defaultPullTokenTTL
+		if ttl > t.pullMaxTTL {
+			ttl = t.pullMaxTTL
+		}
+	}
+	now := time.Now()
+	access := []cm_auth.AccessEntry{{Type: cm_auth.AccessEntryType, Name: repo, Actions: []string{cm_auth.PullAction}}}
+	token, err := t.sign(user, access, "", now, ttl)
+	if err != nil {
+		return nil, err
+	}
+	// the expiry of the token is rounded down to the second
+	return &PullToken{Token: token, Repo: repo, User: user, Expires: time.Unix(now.Add(ttl).Unix(), 0).UTC()}, nil
+}
+
+// sign returns a token of user granting access for ttl from now, for service
+func (t *tokenIssuer) sign(user string, access []cm_auth.AccessEntry, service string, now time.Time, ttl time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":    user,
+		"iat":    now.Unix(),
+		"nbf":    now.Unix(),
+		"exp":    now.Add(ttl).Unix(),
+		"access": access,
+	}
+	if t.issuer != "" {
+		claims["iss"] = t.issuer
+	}
+	if t.audience != "" {
+		claims["aud"] = t.audience
+	} else if service != "" {
+		claims["aud"] = service
+	}
+	return jwt.NewWithClaims(t.method, claims).SignedString(t.key)
+}
+
+// grant narrows the actions requested by entry to the ones the user is allowed
+func (t *tokenIssuer) grant(user string, password string, entry cm_auth.AccessEntry) (cm_auth.AccessEntry, error) {
+	var actions []string
+	for _, action := range entry.Actions {
+		allowed, err := t.authenticate(user, password, action, entry.Name)
+		if err != nil {
+			return entry, err
+		}
+		if allowed && (t.roles == nil || t.roles.allows(user, action, entry.Name)) {
+			actions = append(actions, action)
+		}
+	}
+	entry.Actions = actions
+	if entry.Actions == nil {
+		entry.Actions = []string{}
+	}
+	return entry, nil
+}
+
+// parseScope parses a scope of the registry token protocol, e.g. "repository:org1/charts:pull,push",
+// the repo possibly holding colons
+func parseScope(scope string) (cm_auth.AccessEntry, error) {
+	first, last := strings.Index(scope, ":"), strings.LastIndex(scope, ":")
+	if first <= 0 || last == first {
+		return cm_auth.AccessEntry{}, &invalidScopeError{scope}
+	}
+	resourceType := scope[:first]
+	if resourceType != registryScopeType && resourceType != cm_auth.AccessEntryType {
+		return cm_auth.AccessEntry{}, &invalidScopeError{scope}
+	}
+	actions := strings.Split(scope[last+1:], ",")
+	if containsString(actions, "*") {
+		actions = roleActions["admin"]
+	}
+	return cm_auth.AccessEntry{Type: cm_auth.AccessEntryType, Name: scope[first+1 : last], Actions: actions}, nil
+}
+
+// passwordAuthenticator authenticates a single basic auth user
+func passwordAuthenticator(username string, password string) basicAuthenticator {
+	return func(user string, pw string, _ string, _ string) (bool, error) {
+		return subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pw), []byte(password)) == 1, nil
+	}
+}
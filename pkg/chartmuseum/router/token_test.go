@@ -0,0 +1,215 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/suite"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+type TokenTestSuite struct {
+	suite.Suite
+}
+
+// claims returns the claims of a token signed by secret
+func (suite *TokenTestSuite) claims(token string, secret string) jwt.MapClaims {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) { return []byte(secret), nil })
+	suite.Nil(err, "token signed by secret")
+	return claims
+}
+
+func (suite *TokenTestSuite) TestIssue() {
+	r, err := parseRoles(map[string]string{"alice": "org1/*:write"})
+	suite.Nil(err)
+	issuer, err := newTokenIssuer(tokenIssuerOptions{Secret: "secret", Issuer: "chartmuseum", TTL: time.Minute},
+		passwordAuthenticator("alice", "wonderland"), r)
+	suite.Nil(err)
+
+	response, err := issuer.issue("alice", "wonderland", []string{"repository:org1/repo1:pull,push,delete", "repository:org2/repo1:pull"}, "charts.example.com")
+	suite.Nil(err)
+	suite.Equal(response.Token, response.AccessToken)
+	suite.Equal(60, response.ExpiresIn)
+	claims := suite.claims(response.Token, "secret")
+	suite.Equal("alice", claims["sub"])
+	suite.Equal("chartmuseum", claims["iss"])
+	suite.Equal("charts.example.com", claims["aud"], "service requested")
+	suite.Equal([]interface{}{
+		map[string]interface{}{"type": cm_auth.AccessEntryType, "name": "org1/repo1", "actions": []interface{}{"pull", "push"}},
+		map[string]interface{}{"type": cm_auth.AccessEntryType, "name": "org2/repo1", "actions": []interface{}{}},
+	}, claims["access"], "actions narrowed to the roles of the user")
+
+	response, err = issuer.issue("alice", "wonderland", nil, "")
+	suite.Nil(err)
+	suite.NotNil(response, "token without scopes, such as for docker login")
+
+	response, err = issuer.issue("alice", "wrong", []string{"repository:org1/repo1:pull"}, "")
+	suite.Nil(err)
+	suite.Nil(response, "invalid credentials")
+
+	_, err = issuer.issue("alice", "wonderland", []string{"registry:catalog:*"}, "")
+	suite.EqualError(err, `invalid scope "registry:catalog:*"`)
+	_, err = issuer.issue("alice", "wonderland", []string{"repository:org1"}, "")
+	suite.EqualError(err, `invalid scope "repository:org1"`)
+
+	entry, err := parseScope("artifact-repository:localhost:5000/org1:*")
+	suite.Nil(err)
+	suite.Equal("localhost:5000/org1", entry.Name, "repo holding a colon")
+	suite.Equal([]string{"pull", "push", "delete", "admin"}, entry.Actions, "* requests every action")
+}
+
+func (suite *TokenTestSuite) TestInvalid() {
+	_, err := newTokenIssuer(tokenIssuerOptions{}, passwordAuthenticator("alice", "wonderland"), nil)
+	suite.EqualError(err, "the token endpoint requires a JWT secret or a private key")
+	_, err = newTokenIssuer(tokenIssuerOptions{PrivateKeyPath: testPublicKey}, passwordAuthenticator("alice", "wonderland"), nil)
+	suite.NotNil(err, "not a private key")
+
+	issuer, err := newTokenIssuer(tokenIssuerOptions{PrivateKeyPath: testPrivateKey}, passwordAuthenticator("alice", "wonderland"), nil)
+	suite.Nil(err)
+	response, err := issuer.issue("alice", "wonderland", []string{"repository:org1/repo1:pull"}, "")
+	suite.Nil(err)
+	authenticator, err := newJWTAuthenticator(jwtOptions{PublicKeyPath: testPublicKey})
+	suite.Nil(err)
+	allowed, _ := authenticator.authorize("Bearer "+response.Token, cm_auth.PullAction, "org1/repo1")
+	suite.True(allowed, "token signed with RS256")
+}
+
+func (suite *TokenTestSuite) TestRouter() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	router := NewRouter(RouterOptions{
+		Logger:            log,
+		Depth:             2,
+		BearerAuth:        true,
+		AuthRealm:         "https://charts.example.com/auth/token",
+		AuthService:       "charts.example.com",
+		AuthJWTSecret:     "secret",
+		AuthTokenEndpoint: true,
+		Username:          "alice",
+		Password:          "wonderland",
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.Status(200) }, cm_auth.PullAction},
+		{"POST", "/api/:repo/charts", func(c *gin.Context) { c.Status(201) }, cm_auth.PushAction},
+	})
+	serve := func(method string, path string, setAuth func(*http.Request)) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, path, nil)
+		setAuth(request)
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	none := func(*http.Request) {}
+
+	response := serve("GET", "/org1/repo1/index.yaml", none)
+	suite.Equal(401, response.Code)
+	suite.Equal(`Bearer realm="https://charts.example.com/auth/token",service="charts.example.com",scope="artifact-repository:org1/repo1:pull"`,
+		response.Header().Get("WWW-Authenticate"), "challenge without error for a missing token")
+
+	query := url.Values{"service": {"charts.example.com"}, "scope": {"artifact-repository:org1/repo1:pull"}}
+	response = serve("GET", TokenPath+"?"+query.Encode(), func(r *http.Request) { r.SetBasicAuth("alice", "wonderland") })
+	suite.Equal(200, response.Code, "token issued")
+	var token tokenResponse
+	suite.Nil(json.Unmarshal(response.Body.Bytes(), &token))
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token.Token) }
+	suite.Equal(200, serve("GET", "/org1/repo1/index.yaml", bearer).Code, "token granting pull")
+	response = serve("POST", "/api/org1/repo1/charts", bearer)
+	suite.Equal(401, response.Code, "token not granting push")
+	suite.Contains(response.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`)
+
+	suite.Equal(401, serve("GET", TokenPath, func(r *http.Request) { r.SetBasicAuth("alice", "wrong") }).Code)
+	suite.Equal(401, serve("GET", TokenPath, none).Code)
+	suite.Equal(400, serve("GET", TokenPath+"?scope=org1", func(r *http.Request) { r.SetBasicAuth("alice", "wonderland") }).Code)
+}
+
+func (suite *TokenTestSuite) TestPullToken() {
+	issuer, err := newTokenIssuer(tokenIssuerOptions{Secret: "secret", Issuer: "chartmuseum", PullMaxTTL: 48 * time.Hour},
+		passwordAuthenticator("alice", "wonderland"), nil)
+	suite.Nil(err)
+
+	token, err := issuer.pullToken("alice", "org1/repo1", 0)
+	suite.Nil(err)
+	suite.Equal("org1/repo1", token.Repo)
+	suite.Equal("alice", token.User)
+	suite.WithinDuration(time.Now().Add(24*time.Hour), token.Expires, time.Minute, "valid for a day by default")
+	claims := suite.claims(token.Token, "secret")
+	suite.Equal("alice", claims["sub"], "minted on behalf of the user")
+	suite.Equal(float64(token.Expires.Unix()), claims["exp"])
+	suite.Equal([]interface{}{
+		map[string]interface{}{"type": cm_auth.AccessEntryType, "name": "org1/repo1", "actions": []interface{}{"pull"}},
+	}, claims["access"], "pull on the repo only")
+
+	token, err = issuer.pullToken("alice", "org1/repo1", time.Hour)
+	suite.Nil(err)
+	suite.WithinDuration(time.Now().Add(time.Hour), token.Expires, time.Minute)
+	_, err = issuer.pullToken("alice", "org1/repo1", 72*time.Hour)
+	suite.EqualError(err, "the lifetime of pull tokens must be positive and at most 48h0m0s")
+
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err)
+	router := NewRouter(RouterOptions{
+		Logger:            log,
+		Depth:             2,
+		BearerAuth:        true,
+		AuthJWTSecret:     "secret",
+		AuthTokenEndpoint: true,
+		Username:          "alice",
+		Password:          "wonderland",
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.String(200, c.GetString("user")) }, cm_auth.PullAction},
+		{"POST", "/api/:repo/charts", func(c *gin.Context) { c.Status(201) }, cm_auth.PushAction},
+	})
+	suite.True(router.IssuesPullTokens())
+	token, err = router.IssuePullToken("alice", "org1/repo1", time.Hour)
+	suite.Nil(err)
+	serve := func(method string, path string, setAuth func(*http.Request)) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, path, nil)
+		setAuth(request)
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token.Token) }
+	response := serve("GET", "/org1/repo1/index.yaml", bearer)
+	suite.Equal(200, response.Code, "pull granted")
+	suite.Equal("alice", response.Body.String(), "pulling on behalf of the user")
+	suite.Equal(200, serve("GET", "/org1/repo1/index.yaml", func(r *http.Request) { r.SetBasicAuth("contractor", token.Token) }).Code,
+		"token passed as basic auth password")
+	suite.Equal(401, serve("POST", "/api/org1/repo1/charts", bearer).Code, "push not granted")
+	suite.Equal(401, serve("GET", "/org1/repo2/index.yaml", bearer).Code, "other repo not granted")
+
+	router = NewRouter(RouterOptions{Logger: log, Depth: 2, Username: "alice", Password: "wonderland"})
+	suite.False(router.IssuesPullTokens())
+	_, err = router.IssuePullToken("alice", "org1/repo1", 0)
+	suite.Equal(ErrPullTokensDisabled, err, "no token endpoint")
+}
+
+func TestTokenTestSuite(t *testing.T) {
+	suite.Run(t, new(TokenTestSuite))
+}
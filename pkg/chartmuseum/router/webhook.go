@@ -0,0 +1,131 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultAuthWebhookTimeout = 5 * time.Second
+
+type (
+	// authWebhook asks an external service, such as OPA or a custom policy engine, whether to allow
+	// each request. The request is described in the input of a POST, as for the OPA data API, and
+	// the service decides with {"result": true}, {"result": {"allow": true}} or {"allow": true}
+	authWebhook struct {
+		url    string
+		client *http.Client
+	}
+
+	// authWebhookInput describes a request to the webhook
+	authWebhookInput struct {
+		Method   string `json:"method"`
+		Path     string `json:"path"`
+		Repo     string `json:"repo"`
+		Action   string `json:"action"`
+		ClientIP string `json:"client_ip"`
+		// User is the user authenticated by the server, empty if none
+		User string `json:"user,omitempty"`
+		// Authorization is the Authorization header of the request, for the service to check
+		// credentials itself
+		Authorization string `json:"authorization,omitempty"`
+	}
+
+	// authWebhookDecision is the response of the webhook, Result is either a bool or an object
+	// holding Allow
+	authWebhookDecision struct {
+		Allow  *bool           `json:"allow"`
+		Result json.RawMessage `json:"result"`
+	}
+)
+
+func newAuthWebhook(url string, timeout time.Duration) *authWebhook {
+	if timeout == 0 {
+		timeout = defaultAuthWebhookTimeout
+	}
+	return &authWebhook{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// webhookInput describes the request of c for action on repo, by user
+func (router *Router) webhookInput(c *gin.Context, action string, repo string, user string) authWebhookInput {
+	return authWebhookInput{
+		Method:        c.Request.Method,
+		Path:          c.Request.URL.Path,
+		Repo:          repo,
+		Action:        action,
+		ClientIP:      router.clientAddress(c),
+		User:          user,
+		Authorization: c.Request.Header.Get("Authorization"),
+	}
+}
+
+// cacheKey is what the decision of the webhook on input is made of
+func (input authWebhookInput) cacheKey() []string {
+	return []string{"webhook", input.Method, input.Path, input.Repo, input.Action, input.ClientIP, input.User, input.Authorization}
+}
+
+// authorize tells whether the webhook allows the request of input. A 401 or 403 response denies
+// it, and the error is set for other failures, so that requests are never allowed when the
+// service is down
+func (w *authWebhook) authorize(ctx context.Context, input authWebhookInput) (bool, error) {
+	body, err := json.Marshal(map[string]authWebhookInput{"input": input})
+	if err != nil {
+		return false, err
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := w.client.Do(request)
+	if err != nil {
+		return false, fmt.Errorf("auth webhook: %w", err)
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, nil
+	default:
+		return false, fmt.Errorf("auth webhook: %s", response.Status)
+	}
+
+	decision := authWebhookDecision{}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&decision); err != nil {
+		return false, fmt.Errorf("auth webhook: invalid decision: %w", err)
+	}
+	if len(decision.Result) > 0 {
+		var allow bool
+		if json.Unmarshal(decision.Result, &allow) == nil {
+			return allow, nil
+		}
+		result := authWebhookDecision{}
+		if err := json.Unmarshal(decision.Result, &result); err != nil {
+			return false, fmt.Errorf("auth webhook: invalid result: %w", err)
+		}
+		decision = result
+	}
+	return decision.Allow != nil && *decision.Allow, nil
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package chartmuseum
 
 import (
+	"crypto/tls"
 	"strings"
 	"time"
 
@@ -39,8 +40,24 @@ type (
 		TlsCert                string
 		TlsKey                 string
 		TlsCACert              string
+		TlsCertFunc            func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+		TlsClientNames         []string
+		TlsCRLFile             string
+		TlsOCSP                bool
+		IPAllow                []string
+		IPDeny                 []string
+		IPWriteAllow           []string
+		IPWriteDeny            []string
+		IPTrustedProxies       []string
+		RateLimitRead          float64
+		RateLimitReadBurst     int
+		RateLimitWrite         float64
+		RateLimitWriteBurst    int
+		RateLimitBy            string
+		AuditLog               []string
 		Username               string
 		Password               string
+		HtpasswdFile           string
 		ChartPostFormFieldName string
 		ProvPostFormFieldName  string
 		ContextPath            string
@@ -63,8 +80,36 @@ type (
 		AuthService            string
 		AuthCertPath           string
 		AuthActionsSearchPath  string
+		AuthJWTSecret          string
+		AuthJWKSURL            string
+		AuthIssuer             string
+		AuthAudience           string
+		AuthOIDCIssuerURL      string
+		AuthOIDCClientID       string
+		AuthOIDCGroupsClaim    string
+		AuthOIDCGroups         map[string]string
+		AuthLDAP               cm_router.LDAPOptions
+		AuthRoles              map[string]string
+		AuthTenantsFile        string
+		AuthTokenEndpoint      bool
+		AuthTokenKeyPath       string
+		AuthTokenTTL           time.Duration
+		AuthPullTokenMaxTTL    time.Duration
+		AuthSession            bool
+		AuthSessionSecret      string
+		AuthSessionTTL         time.Duration
+		AuthHMACKeys           map[string]string
+		AuthHMACSkew           time.Duration
+		AuthWebhookURL         string
+		AuthWebhookTimeout     time.Duration
+		AuthCacheTTL           time.Duration
+		AuthCacheNegativeTTL   time.Duration
 		DepthDynamic           bool
 		CORSAllowOrigin        string
+		CORSAllowMethods       []string
+		CORSAllowHeaders       []string
+		CORSAllowCredentials   bool
+		CORSMaxAge             time.Duration
 		ReadTimeout            int
 		WriteTimeout           int
 		CacheInterval          time.Duration
@@ -85,6 +130,68 @@ type (
 		// which means that the GetChart will increase its latency , be careful to enable this .
 		AlwaysRegenerateIndex bool
 		JSONIndex             bool
+		// LegacyUploadResponse restores the {"saved": true} response on multipart uploads
+		// instead of listing the stored files
+		LegacyUploadResponse bool
+		// MinChartAPIVersion rejects uploaded charts with a lower apiVersion (e.g. "v2"), disabled if empty
+		MinChartAPIVersion string
+		// IndexDebounce waits for more uploads before regenerating a repo index, serving the cached one meanwhile.
+		// IndexDebounceRepos overrides it for some repos, by repo (i.e org1/repo1=30s).
+		// IndexMaxStaleness bounds how long the cached index can be served while waiting
+		IndexDebounce      time.Duration
+		IndexDebounceRepos map[string]string
+		IndexMaxStaleness  time.Duration
+		// UpstreamURLs maps repos to an upstream repo used as a read-through cache for missing charts.
+		// Fetched objects are limited to UpstreamMaxSize bytes and to the upstream hosts plus UpstreamAllowedHosts
+		UpstreamURLs         map[string]string
+		UpstreamMaxSize      int
+		UpstreamAllowedHosts []string
+		// UploadURLAllowedHosts are the hosts charts can be uploaded from by URL, fetched by the server
+		// within MaxUploadSize bytes. Such uploads are rejected if empty
+		UploadURLAllowedHosts []string
+		// StorageBackendName is the type of the storage backend reported by /info, such as local or amazon
+		StorageBackendName string
+		// IndexContentType and JSONIndexContentType are the content types the index is served with,
+		// depending on JSONIndex. Defaults are used when empty
+		IndexContentType     string
+		JSONIndexContentType string
+		// ProvenanceKeyring is a keyring file with the public keys used to verify stored charts on demand
+		ProvenanceKeyring string
+		// ReconcileInterval is how often an index request diffs the cached index with a storage listing,
+		// dropping charts deleted out-of-band. Disabled if 0 as listing a large storage can be expensive
+		ReconcileInterval time.Duration
+		// MultiChartUpload accepts several files in the same multipart form field or batch upload, such uploads are rejected otherwise
+		MultiChartUpload bool
+		// ReservedPrefixes are object prefixes skipped when listing charts, on top of the ones used internally
+		ReservedPrefixes []string
+		// ArtifactHubRepoFile maps repos to a file with the artifacthub-repo.yml content to serve (owners, ignored charts)
+		ArtifactHubRepoFile map[string]string
+		// RollbackRetries is how many times deleting the already stored files of a failed upload is retried
+		RollbackRetries int
+		// MaxIndexSize is the max size in bytes of a served index, disabled if 0. Larger indexes are rejected,
+		// or truncated with an X-Index-Truncated header if TruncateIndex is set
+		MaxIndexSize  int
+		TruncateIndex bool
+		// RequireProvenance rejects charts uploaded without a provenance file, unless a repo setting overrides it
+		RequireProvenance bool
+		// MultipartMaxMemory is how many bytes of a multipart upload are kept in memory, the rest of the files
+		// being stored in temporary files. Defaults to 32MB
+		MultipartMaxMemory int64
+		// HealthDetails adds the version, uptime and start time to the /health response
+		HealthDetails bool
+		// CaseInsensitiveNames rejects uploads of a chart whose name only differs by case from an existing chart
+		CaseInsensitiveNames bool
+		// RedirectDownloads responds to chart downloads with a redirect to a URL of the storage
+		// presigned for this duration, when the backend supports it. Disabled if 0
+		RedirectDownloads time.Duration
+		// StorageListPageSize is the number of objects listed per storage request, for backends
+		// listing pages. Backend default if 0
+		StorageListPageSize int
+		// DownloadStats counts the downloads of chart versions in a store, one of memory, storage or
+		// cache (the redis ExternalCacheStore). Disabled if empty
+		DownloadStats string
+		// DownloadStatsInterval is how often the downloads counted in memory are stored. Defaults to 1m
+		DownloadStatsInterval time.Duration
 	}
 
 	// Server is a generic interface for web servers
@@ -105,10 +212,26 @@ func NewServer(options ServerOptions) (Server, error) {
 		LogLatencyInteger:     options.LogLatencyInteger,
 		Username:              options.Username,
 		Password:              options.Password,
+		HtpasswdFile:          options.HtpasswdFile,
 		ContextPath:           contextPath,
 		TlsCert:               options.TlsCert,
 		TlsKey:                options.TlsKey,
 		TlsCACert:             options.TlsCACert,
+		TlsCertFunc:           options.TlsCertFunc,
+		TlsClientNames:        options.TlsClientNames,
+		TlsCRLFile:            options.TlsCRLFile,
+		TlsOCSP:               options.TlsOCSP,
+		IPAllow:               options.IPAllow,
+		IPDeny:                options.IPDeny,
+		IPWriteAllow:          options.IPWriteAllow,
+		IPWriteDeny:           options.IPWriteDeny,
+		IPTrustedProxies:      options.IPTrustedProxies,
+		RateLimitRead:         options.RateLimitRead,
+		RateLimitReadBurst:    options.RateLimitReadBurst,
+		RateLimitWrite:        options.RateLimitWrite,
+		RateLimitWriteBurst:   options.RateLimitWriteBurst,
+		RateLimitBy:           options.RateLimitBy,
+		AuditLog:              options.AuditLog,
 		LogHealth:             options.LogHealth,
 		EnableMetrics:         options.EnableMetrics,
 		AnonymousGet:          options.AnonymousGet,
@@ -119,8 +242,36 @@ func NewServer(options ServerOptions) (Server, error) {
 		AuthService:           options.AuthService,
 		AuthCertPath:          options.AuthCertPath,
 		AuthActionsSearchPath: options.AuthActionsSearchPath,
+		AuthJWTSecret:         options.AuthJWTSecret,
+		AuthJWKSURL:           options.AuthJWKSURL,
+		AuthIssuer:            options.AuthIssuer,
+		AuthAudience:          options.AuthAudience,
+		AuthOIDCIssuerURL:     options.AuthOIDCIssuerURL,
+		AuthOIDCClientID:      options.AuthOIDCClientID,
+		AuthOIDCGroupsClaim:   options.AuthOIDCGroupsClaim,
+		AuthOIDCGroups:        options.AuthOIDCGroups,
+		AuthLDAP:              options.AuthLDAP,
+		AuthRoles:             options.AuthRoles,
+		AuthTenantsFile:       options.AuthTenantsFile,
+		AuthTokenEndpoint:     options.AuthTokenEndpoint,
+		AuthTokenKeyPath:      options.AuthTokenKeyPath,
+		AuthTokenTTL:          options.AuthTokenTTL,
+		AuthPullTokenMaxTTL:   options.AuthPullTokenMaxTTL,
+		AuthSession:           options.AuthSession,
+		AuthSessionSecret:     options.AuthSessionSecret,
+		AuthSessionTTL:        options.AuthSessionTTL,
+		AuthHMACKeys:          options.AuthHMACKeys,
+		AuthHMACSkew:          options.AuthHMACSkew,
+		AuthWebhookURL:        options.AuthWebhookURL,
+		AuthWebhookTimeout:    options.AuthWebhookTimeout,
+		AuthCacheTTL:          options.AuthCacheTTL,
+		AuthCacheNegativeTTL:  options.AuthCacheNegativeTTL,
 		DepthDynamic:          options.DepthDynamic,
 		CORSAllowOrigin:       options.CORSAllowOrigin,
+		CORSAllowMethods:      options.CORSAllowMethods,
+		CORSAllowHeaders:      options.CORSAllowHeaders,
+		CORSAllowCredentials:  options.CORSAllowCredentials,
+		CORSMaxAge:            options.CORSMaxAge,
 		ReadTimeout:           options.ReadTimeout,
 		WriteTimeout:          options.WriteTimeout,
 		Host:                  options.Host,
@@ -153,6 +304,35 @@ func NewServer(options ServerOptions) (Server, error) {
 		EnforceSemver2:        options.EnforceSemver2,
 		AlwaysRegenerateIndex: options.AlwaysRegenerateIndex,
 		JSONIndex:             options.JSONIndex,
+		LegacyUploadResponse:  options.LegacyUploadResponse,
+		MinChartAPIVersion:    options.MinChartAPIVersion,
+		IndexDebounce:         options.IndexDebounce,
+		IndexMaxStaleness:     options.IndexMaxStaleness,
+		IndexDebounceRepos:    options.IndexDebounceRepos,
+		UpstreamURLs:          options.UpstreamURLs,
+		UpstreamMaxSize:       options.UpstreamMaxSize,
+		UpstreamAllowedHosts:  options.UpstreamAllowedHosts,
+		UploadURLAllowedHosts: options.UploadURLAllowedHosts,
+		UploadURLMaxSize:      options.MaxUploadSize,
+		StorageBackendName:    options.StorageBackendName,
+		IndexContentType:      options.IndexContentType,
+		JSONIndexContentType:  options.JSONIndexContentType,
+		ProvenanceKeyring:     options.ProvenanceKeyring,
+		ReconcileInterval:     options.ReconcileInterval,
+		MultiChartUpload:      options.MultiChartUpload,
+		ReservedPrefixes:      options.ReservedPrefixes,
+		ArtifactHubRepoFile:   options.ArtifactHubRepoFile,
+		RollbackRetries:       options.RollbackRetries,
+		MaxIndexSize:          options.MaxIndexSize,
+		TruncateIndex:         options.TruncateIndex,
+		RequireProvenance:     options.RequireProvenance,
+		MultipartMaxMemory:    options.MultipartMaxMemory,
+		HealthDetails:         options.HealthDetails,
+		CaseInsensitiveNames:  options.CaseInsensitiveNames,
+		RedirectDownloads:     options.RedirectDownloads,
+		StorageListPageSize:   options.StorageListPageSize,
+		DownloadStats:         options.DownloadStats,
+		DownloadStatsInterval: options.DownloadStatsInterval,
 	})
 
 	return server, err
@@ -17,48 +17,171 @@ limitations under the License.
 package multitenant
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	pathutil "path/filepath"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/chartmuseum/storage"
 	"github.com/gin-gonic/gin"
+	"github.com/russross/blackfriday/v2"
 
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
 	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	cm_pkg_storage "helm.sh/chartmuseum/pkg/storage"
 
 	"helm.sh/helm/v3/pkg/chart"
 	helm_repo "helm.sh/helm/v3/pkg/repo"
 )
 
-func (server *MultiTenantServer) getAllCharts(log cm_logger.LoggingFn, repo string, offset int, limit int) (map[string]helm_repo.ChartVersions, *HTTPError) {
+const (
+	// verificationStatusVerified is the status of a chart version signed by a key of the keyring,
+	// whose stored package is the one indexed
+	verificationStatusVerified = "verified"
+	// verificationStatusUnsigned is the status of a chart version without provenance file
+	verificationStatusUnsigned = "unsigned"
+	// verificationStatusInvalid is the status of a chart version whose provenance file is not
+	// signed by a key of the keyring, or records another package digest
+	verificationStatusInvalid = "invalid"
+	// verificationStatusDigestMismatch is the status of a signed chart version whose stored package
+	// is not the one indexed
+	verificationStatusDigestMismatch = "digest_mismatch"
+)
+
+type (
+	// chartVersionList is the response of GET /api/:repo/charts/:name/versions
+	chartVersionList struct {
+		Name             string              `json:"name"`
+		LatestStable     string              `json:"latest_stable,omitempty"`
+		LatestPrerelease string              `json:"latest_prerelease,omitempty"`
+		Versions         []chartVersionEntry `json:"versions"`
+	}
+
+	// chartVerification is the response of GET /api/:repo/charts/:name/:version/verify
+	chartVerification struct {
+		Name     string `json:"name"`
+		Version  string `json:"version"`
+		Verified bool   `json:"verified"`
+		// Status is one of the verificationStatus constants
+		Status string `json:"status"`
+		// Key joins the identities of the signer
+		Key    string                    `json:"key,omitempty"`
+		Signer *cm_repo.ProvenanceSigner `json:"signer,omitempty"`
+		// Digest is the sha256 of the stored package, IndexDigest the one the index has
+		Digest      string `json:"digest"`
+		IndexDigest string `json:"index_digest,omitempty"`
+		Error       string `json:"error,omitempty"`
+	}
+
+	chartVersionEntry struct {
+		Version          string    `json:"version"`
+		AppVersion       string    `json:"app_version,omitempty"`
+		Created          time.Time `json:"created"`
+		Digest           string    `json:"digest,omitempty"`
+		Deprecated       bool      `json:"deprecated,omitempty"`
+		Prerelease       bool      `json:"prerelease"`
+		LatestStable     bool      `json:"latest_stable"`
+		LatestPrerelease bool      `json:"latest_prerelease"`
+	}
+)
+
+// chartOrders compare the latest versions of two charts, by sort param of GET /api/:repo/charts
+var chartOrders = map[string]func(a *helm_repo.ChartVersion, b *helm_repo.ChartVersion) bool{
+	"name": func(a *helm_repo.ChartVersion, b *helm_repo.ChartVersion) bool {
+		return a.Name < b.Name
+	},
+	"created": func(a *helm_repo.ChartVersion, b *helm_repo.ChartVersion) bool {
+		return a.Created.Before(b.Created)
+	},
+	"version": func(a *helm_repo.ChartVersion, b *helm_repo.ChartVersion) bool {
+		versionA, errA := semver.NewVersion(a.Version)
+		versionB, errB := semver.NewVersion(b.Version)
+		if errA != nil || errB != nil {
+			return a.Version < b.Version
+		}
+		return versionA.LessThan(versionB)
+	},
+}
+
+// getAllCharts returns the versions of the charts of repo by name, from offset in the order of their
+// names and at most limit of them, or all of them if limit is -1, with the number of charts of repo
+func (server *MultiTenantServer) getAllCharts(log cm_logger.LoggingFn, repo string, offset int, limit int) (map[string]helm_repo.ChartVersions, int, *HTTPError) {
 	indexFile, err := server.getIndexFile(log, repo)
 	if err != nil {
-		return nil, &HTTPError{http.StatusInternalServerError, err.Message}
-	}
-	if offset == 0 && limit == -1 {
-		return indexFile.Entries, nil
+		return nil, 0, &HTTPError{http.StatusInternalServerError, err.Message}
 	}
+	// copy the entries, the cached index being updated concurrently as charts are uploaded
+	indexFile.IndexLock.RLock()
+	defer indexFile.IndexLock.RUnlock()
 	result := map[string]helm_repo.ChartVersions{}
 	var keys []string
 	for k := range indexFile.Entries {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
-	end := offset + limit
-	if len(keys) < end {
-		end = len(keys)
+	for _, key := range page(keys, offset, limit) {
+		result[key] = append(helm_repo.ChartVersions{}, indexFile.Entries[key]...)
 	}
-	for i := offset; i < end; i++ {
-		result[keys[i]] = indexFile.Entries[keys[i]]
+	return result, len(indexFile.Entries), nil
+}
+
+// listCharts returns the versions of the charts of repo, ordered by sortBy on the latest version of
+// each chart, or in the reverse order if prefixed with "-", the names breaking ties. They are
+// returned from offset and at most limit of them, or all of them if limit is -1, with the number
+// of charts of repo
+func (server *MultiTenantServer) listCharts(log cm_logger.LoggingFn, repo string, sortBy string, offset int, limit int) ([]helm_repo.ChartVersions, int, *HTTPError) {
+	descending := strings.HasPrefix(sortBy, "-")
+	less, found := chartOrders[strings.TrimPrefix(sortBy, "-")]
+	if !found {
+		return nil, 0, &HTTPError{http.StatusBadRequest, fmt.Sprintf("invalid sort %q, use name, created or version, prefixed with - to reverse the order", sortBy)}
 	}
-	return result, nil
+	indexFile, err := server.getIndexFile(log, repo)
+	if err != nil {
+		return nil, 0, &HTTPError{http.StatusInternalServerError, err.Message}
+	}
+	charts := make([]helm_repo.ChartVersions, 0, len(indexFile.Entries))
+	for _, versions := range indexFile.Entries {
+		// the versions of the index are sorted, the latest first
+		if len(versions) > 0 {
+			charts = append(charts, versions)
+		}
+	}
+	sort.Slice(charts, func(i, j int) bool {
+		a, b := charts[i][0], charts[j][0]
+		if descending {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return charts[i][0].Name < charts[j][0].Name
+	})
+	return page(charts, offset, limit), len(charts), nil
+}
+
+// page returns the items from offset, and at most limit of them unless it is -1
+func page[T any](items []T, offset int, limit int) []T {
+	if offset > len(items) {
+		offset = len(items)
+	}
+	items = items[offset:]
+	if limit != -1 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
 }
 
 func (server *MultiTenantServer) getChart(log cm_logger.LoggingFn, repo string, name string) (helm_repo.ChartVersions, *HTTPError) {
-	allCharts, err := server.getAllCharts(log, repo, 0, -1)
+	allCharts, _, err := server.getAllCharts(log, repo, 0, -1)
 	if err != nil {
 		return nil, err
 	}
@@ -69,6 +192,73 @@ func (server *MultiTenantServer) getChart(log cm_logger.LoggingFn, repo string,
 	return chart, nil
 }
 
+// sortedChartVersions returns the versions of the chart name from the latest to the oldest by semver,
+// the ones that are not semver last, and flags the latest stable version and the latest prerelease
+func sortedChartVersions(name string, versions helm_repo.ChartVersions) *chartVersionList {
+	type parsedVersion struct {
+		chartVersion *helm_repo.ChartVersion
+		semver       *semver.Version
+	}
+	parsed := make([]parsedVersion, 0, len(versions))
+	for _, chartVersion := range versions {
+		version, _ := semver.NewVersion(chartVersion.Version)
+		parsed = append(parsed, parsedVersion{chartVersion, version})
+	}
+	sort.SliceStable(parsed, func(i, j int) bool {
+		a, b := parsed[i], parsed[j]
+		switch {
+		case a.semver != nil && b.semver != nil:
+			return a.semver.GreaterThan(b.semver)
+		case a.semver != nil || b.semver != nil:
+			return a.semver != nil
+		}
+		return a.chartVersion.Version > b.chartVersion.Version
+	})
+
+	list := &chartVersionList{Name: name, Versions: make([]chartVersionEntry, 0, len(parsed))}
+	for _, p := range parsed {
+		entry := chartVersionEntry{
+			Version:    p.chartVersion.Version,
+			AppVersion: p.chartVersion.AppVersion,
+			Created:    p.chartVersion.Created,
+			Digest:     p.chartVersion.Digest,
+			Deprecated: p.chartVersion.Deprecated,
+		}
+		if p.semver != nil {
+			entry.Prerelease = p.semver.Prerelease() != ""
+			if !entry.Prerelease && list.LatestStable == "" {
+				entry.LatestStable = true
+				list.LatestStable = entry.Version
+			} else if entry.Prerelease && list.LatestPrerelease == "" {
+				entry.LatestPrerelease = true
+				list.LatestPrerelease = entry.Version
+			}
+		}
+		list.Versions = append(list.Versions, entry)
+	}
+	return list
+}
+
+// matchingChartVersions returns the versions of a chart matching a semver constraint, e.g. "^1.2.x",
+// not found if there is none. Prereleases only match constraints holding a prerelease
+func matchingChartVersions(versions helm_repo.ChartVersions, constraint string) (helm_repo.ChartVersions, *HTTPError) {
+	constraints, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, &HTTPError{http.StatusBadRequest, fmt.Sprintf("invalid constraint %q: %s", constraint, err)}
+	}
+	matching := helm_repo.ChartVersions{}
+	for _, chartVersion := range versions {
+		version, err := semver.NewVersion(chartVersion.Version)
+		if err == nil && constraints.Check(version) {
+			matching = append(matching, chartVersion)
+		}
+	}
+	if len(matching) == 0 {
+		return nil, &HTTPError{http.StatusNotFound, fmt.Sprintf("no chart version matching %s", constraint)}
+	}
+	return matching, nil
+}
+
 func (server *MultiTenantServer) getChartVersion(log cm_logger.LoggingFn, repo string, name string, version string) (*helm_repo.ChartVersion, *HTTPError) {
 	indexFile, err := server.getIndexFile(log, repo)
 	if err != nil {
@@ -98,11 +288,286 @@ func (server *MultiTenantServer) deleteChartVersion(log cm_logger.LoggingFn, rep
 	return nil
 }
 
+// indexedChartVersion returns the version of a chart in the index of repo equal to version, the one
+// written the same way first, nil if the index has none
+func (server *MultiTenantServer) indexedChartVersion(log cm_logger.LoggingFn, repo string, name string, version string) *helm_repo.ChartVersion {
+	want, err := semver.NewVersion(version)
+	if err != nil {
+		return nil
+	}
+	indexFile, getErr := server.getIndexFile(log, repo)
+	if getErr != nil {
+		return nil
+	}
+	indexFile.IndexLock.RLock()
+	defer indexFile.IndexLock.RUnlock()
+	var equal *helm_repo.ChartVersion
+	for _, chartVersion := range indexFile.Entries[name] {
+		if chartVersion.Version == version {
+			return chartVersion
+		}
+		if v, err := semver.NewVersion(chartVersion.Version); err == nil && equal == nil && v.Equal(want) {
+			equal = chartVersion
+		}
+	}
+	return equal
+}
+
+// chartVersionOfPackage returns the index entry of the chart package filename of repo, nil if the
+// index has none
+func (server *MultiTenantServer) chartVersionOfPackage(log cm_logger.LoggingFn, repo string, filename string) *helm_repo.ChartVersion {
+	indexFile, err := server.getIndexFile(log, repo)
+	if err != nil {
+		return nil
+	}
+	name, version := cm_repo.GetExactChartNameVersion(strings.TrimSuffix(filename, "."+cm_repo.ChartPackageFileExtension))
+	indexFile.IndexLock.RLock()
+	defer indexFile.IndexLock.RUnlock()
+	for _, chartVersion := range indexFile.Entries[name] {
+		if chartVersion.Version == version {
+			return chartVersion
+		}
+	}
+	return nil
+}
+
+// chartVersionsWithDigest returns the chart versions of repo whose package has digest, the sha256 of
+// the package in hex, optionally prefixed with sha256: as in OCI references and vulnerability reports
+func (server *MultiTenantServer) chartVersionsWithDigest(log cm_logger.LoggingFn, repo string, digest string) (helm_repo.ChartVersions, *HTTPError) {
+	digest, err := parseSHA256Digest(digest)
+	if err != nil {
+		return nil, err
+	}
+	indexFile, err := server.getIndexFile(log, repo)
+	if err != nil {
+		return nil, err
+	}
+	var matching helm_repo.ChartVersions
+	for _, chartVersions := range indexFile.Entries {
+		for _, chartVersion := range chartVersions {
+			if strings.EqualFold(chartVersion.Digest, digest) {
+				matching = append(matching, chartVersion)
+			}
+		}
+	}
+	if len(matching) == 0 {
+		return nil, &HTTPError{http.StatusNotFound, "no chart version with digest sha256:" + digest}
+	}
+	return matching, nil
+}
+
+// parseSHA256Digest returns the lowercase hex of a sha256 digest, with or without the sha256: prefix
+func parseSHA256Digest(digest string) (string, *HTTPError) {
+	if algorithm, hex, found := strings.Cut(digest, ":"); found {
+		if algorithm != "sha256" {
+			return "", &HTTPError{http.StatusBadRequest, fmt.Sprintf("unsupported digest algorithm %q, must be sha256", algorithm)}
+		}
+		digest = hex
+	}
+	digest = strings.ToLower(digest)
+	if len(digest) != 64 || strings.Trim(digest, "0123456789abcdef") != "" {
+		return "", &HTTPError{http.StatusBadRequest, "invalid digest, must be a sha256 in hex"}
+	}
+	return digest, nil
+}
+
+// checkChartNameCase rejects a chart whose name only differs by case from a chart of the repo,
+// as both cannot coexist on case-insensitive clients
+func (server *MultiTenantServer) checkChartNameCase(log cm_logger.LoggingFn, repo string, content []byte) *HTTPError {
+	if !server.CaseInsensitiveNames {
+		return nil
+	}
+	metadata, err := cm_repo.ChartMetadataFromContent(content)
+	if err != nil {
+		return &HTTPError{http.StatusBadRequest, err.Error()}
+	}
+	indexFile, httpErr := server.getIndexFile(log, repo)
+	if httpErr != nil {
+		return httpErr
+	}
+	for name := range indexFile.Entries {
+		if name != metadata.Name && strings.EqualFold(name, metadata.Name) {
+			return &HTTPError{http.StatusConflict, fmt.Sprintf("chart name %s conflicts with existing chart %s", metadata.Name, name)}
+		}
+	}
+	return nil
+}
+
+// checkChartProvenance enforces the provenance requirement of a repo on an uploaded chart, its provenance
+// file being either uploaded along or already stored. The signature is checked if a keyring is configured
+func (server *MultiTenantServer) checkChartProvenance(log cm_logger.LoggingFn, repo string, filename string, content []byte, provContent []byte) *HTTPError {
+	required, requireErr := server.requireProvenance(log, repo)
+	if requireErr != nil {
+		return requireErr
+	}
+	if !required {
+		return nil
+	}
+	if provContent == nil {
+		provObject, err := server.StorageBackend.GetObject(pathutil.Join(repo, filename+".prov"))
+		if cm_pkg_storage.IsNotFound(err) {
+			return &HTTPError{http.StatusBadRequest, fmt.Sprintf("provenance file required for %s", filename)}
+		}
+		if err != nil {
+			return &HTTPError{http.StatusInternalServerError, err.Error()}
+		}
+		provContent = provObject.Content
+	}
+	if server.ProvenanceKeyring == "" {
+		return nil
+	}
+	if _, err := cm_repo.VerifyProvenance(server.ProvenanceKeyring, filename, content, provContent); err != nil {
+		return &HTTPError{http.StatusBadRequest, fmt.Sprintf("provenance verification failed for %s: %s", filename, err)}
+	}
+	return nil
+}
+
+// verifyChartVersion checks the signature of a stored chart package against its stored provenance file,
+// and the digest of the package against the one of the index, so that a package overwritten in
+// storage since it was indexed is not reported as verified even if signed
+func (server *MultiTenantServer) verifyChartVersion(log cm_logger.LoggingFn, repo string, name string, version string) (*chartVerification, *HTTPError) {
+	chartVersion, err := server.getChartVersion(log, repo, name, version)
+	if err != nil {
+		return nil, err
+	}
+	filename, err := chartFileName(chartVersion)
+	if err != nil {
+		return nil, err
+	}
+	chartObject, err := server.getStorageObject(log, repo, filename)
+	if err != nil {
+		return nil, err
+	}
+	verification := &chartVerification{
+		Name:        chartVersion.Name,
+		Version:     chartVersion.Version,
+		Digest:      fmt.Sprintf("%x", sha256.Sum256(chartObject.Content)),
+		IndexDigest: chartVersion.Digest,
+	}
+	provObject, err := server.getStorageObject(log, repo, filename+".prov")
+	if err != nil {
+		verification.Status = verificationStatusUnsigned
+		verification.Error = "provenance file not found"
+		return verification, nil
+	}
+
+	signer, verifyErr := cm_repo.VerifyProvenanceSigner(server.ProvenanceKeyring, filename, chartObject.Content, provObject.Content)
+	if verifyErr != nil {
+		log(cm_logger.WarnLevel, "Chart provenance verification failed",
+			"repo", repo,
+			"filename", filename,
+			"error", verifyErr.Error(),
+		)
+		verification.Status = verificationStatusInvalid
+		verification.Error = verifyErr.Error()
+		return verification, nil
+	}
+	verification.Signer = signer
+	verification.Key = strings.Join(signer.Identities, ", ")
+	if chartVersion.Digest != "" && chartVersion.Digest != verification.Digest {
+		log(cm_logger.WarnLevel, "Chart package digest does not match the index",
+			"repo", repo,
+			"filename", filename,
+		)
+		verification.Status = verificationStatusDigestMismatch
+		verification.Error = "digest of the stored package does not match the index"
+		return verification, nil
+	}
+	log(cm_logger.DebugLevel, "Chart provenance verified",
+		"repo", repo,
+		"filename", filename,
+	)
+	verification.Verified = true
+	verification.Status = verificationStatusVerified
+	return verification, nil
+}
+
+// getChartValues returns the values.yaml of a chart version, and the digest of its package
+func (server *MultiTenantServer) getChartValues(log cm_logger.LoggingFn, repo string, name string, version string) ([]byte, string, *HTTPError) {
+	return server.getChartFile(log, repo, name, version, "values.yaml", cm_repo.ChartValuesFromContent)
+}
+
+// getChartReadme returns the README of a chart version, rendered as HTML if html is set, and the
+// digest of its package
+func (server *MultiTenantServer) getChartReadme(log cm_logger.LoggingFn, repo string, name string, version string, html bool) ([]byte, string, *HTTPError) {
+	if !html {
+		return server.getChartFile(log, repo, name, version, "README", cm_repo.ChartReadmeFromContent)
+	}
+	return server.getChartFile(log, repo, name, version, "README.html", func(content []byte) ([]byte, error) {
+		readme, err := cm_repo.ChartReadmeFromContent(content)
+		if err != nil {
+			return nil, err
+		}
+		return renderMarkdown(readme), nil
+	})
+}
+
+// getChartMetadata returns the Chart.yaml of a chart version as JSON, with the fields the index
+// leaves out, and the digest of its package
+func (server *MultiTenantServer) getChartMetadata(log cm_logger.LoggingFn, repo string, name string, version string) ([]byte, string, *HTTPError) {
+	return server.getChartFile(log, repo, name, version, "Chart.json", func(content []byte) ([]byte, error) {
+		metadata, err := cm_repo.ChartMetadataFromContent(content)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(metadata)
+	})
+}
+
+// getChartFile returns file of a chart version, as read from its package by read, and the digest of
+// the package. The files are cached by digest, unless the index has none
+func (server *MultiTenantServer) getChartFile(log cm_logger.LoggingFn, repo string, name string, version string, file string,
+	read func(content []byte) ([]byte, error)) ([]byte, string, *HTTPError) {
+	chartVersion, err := server.getChartVersion(log, repo, name, version)
+	if err != nil {
+		return nil, "", err
+	}
+	digest := chartVersion.Digest
+	if digest != "" {
+		if content, found := server.chartFileCache.get(repo, digest, file); found {
+			return content, digest, nil
+		}
+	}
+	filename, err := chartFileName(chartVersion)
+	if err != nil {
+		return nil, "", err
+	}
+	storageObject, err := server.getStorageObject(log, repo, filename)
+	if err != nil {
+		return nil, "", err
+	}
+	content, readErr := read(storageObject.Content)
+	if readErr == cm_repo.ErrorValuesNotFound || readErr == cm_repo.ErrorReadmeNotFound {
+		return nil, "", &HTTPError{http.StatusNotFound, readErr.Error()}
+	} else if readErr != nil {
+		return nil, "", &HTTPError{http.StatusInternalServerError, readErr.Error()}
+	}
+	if digest != "" {
+		server.chartFileCache.add(repo, digest, file, content)
+	}
+	return content, digest, nil
+}
+
+// renderMarkdown renders the README of a chart as HTML. Charts are untrusted content, so the raw
+// HTML of the README is dropped, and links are kept to safe protocols only
+func renderMarkdown(markdown []byte) []byte {
+	renderer := blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+		Flags: blackfriday.CommonHTMLFlags | blackfriday.SkipHTML | blackfriday.Safelink | blackfriday.NofollowLinks | blackfriday.NoreferrerLinks,
+	})
+	return blackfriday.Run(markdown, blackfriday.WithRenderer(renderer))
+}
+
 func (server *MultiTenantServer) getChartFileName(log cm_logger.LoggingFn, repo string, name string, version string) (string, *HTTPError) {
 	chartVersion, err := server.getChartVersion(log, repo, name, version)
 	if err != nil {
 		return "", err
 	}
+	return chartFileName(chartVersion)
+}
+
+// chartFileName returns the filename of the package of a chart version, from its URL, relative or
+// absolute with a chart URL
+func chartFileName(chartVersion *helm_repo.ChartVersion) (string, *HTTPError) {
 	if len(chartVersion.URLs) == 0 {
 		return "", &HTTPError{http.StatusNotFound, "chart filename not found"}
 	}
@@ -110,13 +575,13 @@ func (server *MultiTenantServer) getChartFileName(log cm_logger.LoggingFn, repo
 	if len(split) < 2 {
 		return "", &HTTPError{http.StatusNotFound, "chart filename not found"}
 	}
-	return split[1], nil
+	return split[len(split)-1], nil
 }
 
 func (server *MultiTenantServer) uploadChartPackage(log cm_logger.LoggingFn, repo string, content []byte, force bool) (string, *HTTPError) {
 	var filename string
 
-	filename, err := cm_repo.ChartPackageFilenameFromContent(content)
+	filename, err := server.chartPackageFilenameFromContent(content)
 	if err != nil {
 		return filename, &HTTPError{http.StatusBadRequest, err.Error()}
 	}
@@ -125,6 +590,18 @@ func (server *MultiTenantServer) uploadChartPackage(log cm_logger.LoggingFn, rep
 		// Name wants to break out of current directory
 		return filename, &HTTPError{http.StatusBadRequest, fmt.Sprintf("%s is improperly formatted", filename)}
 	}
+	if err := server.checkChartNameCase(log, repo, content); err != nil {
+		return filename, err
+	}
+	if err := server.checkChartProvenance(log, repo, filename, content, nil); err != nil {
+		return filename, err
+	}
+
+	allowOverwrite, overwriteErr := server.allowOverwrite(log, repo)
+	if overwriteErr != nil {
+		return filename, overwriteErr
+	}
+	overwrite := allowOverwrite || (server.AllowForceOverwrite && force)
 
 	// we should ensure that whether chart is existed even if the `overwrite` option is set
 	// For `overwrite` option , here will increase one `storage.GetObject` than before ; others should be equalvarant with the previous version.
@@ -134,13 +611,13 @@ func (server *MultiTenantServer) uploadChartPackage(log cm_logger.LoggingFn, rep
 	if err == nil {
 		found = true
 		// For those no-overwrite servers, return the Conflict error.
-		if !server.AllowOverwrite && (!server.AllowForceOverwrite || !force) {
+		if !overwrite {
 			return filename, &HTTPError{http.StatusConflict, "file already exists"}
 		}
 		// continue with the `overwrite` servers
 	}
 
-	limitReached, err := server.checkStorageLimit(repo, filename, force)
+	limitReached, err := server.checkStorageLimit(log, repo, filename, force)
 	if err != nil {
 		return filename, &HTTPError{http.StatusInternalServerError, err.Error()}
 	}
@@ -150,9 +627,15 @@ func (server *MultiTenantServer) uploadChartPackage(log cm_logger.LoggingFn, rep
 	log(cm_logger.DebugLevel, "Adding package to storage",
 		"package", filename,
 	)
-	if err := server.PutWithLimit(&gin.Context{}, log, repo, filename, content); err != nil {
+	// without overwrite, the package is created only if a concurrent upload did not store it
+	// meanwhile, with the backends able to check it in the same operation
+	if err := server.PutWithLimit(&gin.Context{}, log, repo, filename, content, !overwrite); err != nil {
+		if cm_pkg_storage.IsExist(err) {
+			return filename, &HTTPError{http.StatusConflict, "file already exists"}
+		}
 		return filename, &HTTPError{http.StatusInternalServerError, err.Error()}
 	}
+	server.recordPackageSize(repo, filename, len(content))
 	if found {
 		// here is a fake conflict error for outside call
 		// In order to not add another return `bool` check (API Compatibility)
@@ -161,6 +644,50 @@ func (server *MultiTenantServer) uploadChartPackage(log cm_logger.LoggingFn, rep
 	return filename, nil
 }
 
+// uploadChartPackageAndProvenance stores a chart package and its provenance file, if any, as if
+// uploaded one after the other. The provenance file is stored first, for repos requiring one, and
+// removed again if the package cannot be stored. updated is set when the package was overwritten
+func (server *MultiTenantServer) uploadChartPackageAndProvenance(log cm_logger.LoggingFn, repo string, content []byte, provContent []byte,
+	force bool) (filename string, updated bool, err *HTTPError) {
+	var storedProv []*chartOrProvenanceFile
+	if provContent != nil {
+		provFilename, provErr := cm_repo.ProvenanceFilenameFromContent(provContent)
+		if provErr != nil {
+			return "", false, &HTTPError{http.StatusBadRequest, provErr.Error()}
+		}
+		_, existsErr := server.StorageBackend.GetObject(pathutil.Join(repo, provFilename))
+		if err := server.uploadProvenanceFile(log, repo, provContent, force); err != nil {
+			return "", false, err
+		}
+		if existsErr != nil {
+			storedProv = append(storedProv, &chartOrProvenanceFile{provFilename, provContent, defaultProvField, false})
+		}
+	}
+	filename, err = server.uploadChartPackage(log, repo, content, force)
+	if err != nil {
+		// a conflict without message is an overwrite
+		if err.Status == http.StatusConflict && err.Message == "" {
+			return filename, true, nil
+		}
+		server.rollbackStoredFiles(log, repo, storedProv)
+		return filename, false, err
+	}
+	return filename, false, nil
+}
+
+// chartPackageFilenameFromContent returns a chart filename from binary content,
+// rejecting charts below the configured minimum apiVersion
+func (server *MultiTenantServer) chartPackageFilenameFromContent(content []byte) (string, error) {
+	meta, err := cm_repo.ChartMetadataFromContent(content)
+	if err != nil {
+		return "", err
+	}
+	if err := cm_repo.CheckChartAPIVersion(meta, server.MinChartAPIVersion); err != nil {
+		return "", err
+	}
+	return cm_repo.ChartPackageFilenameFromNameVersion(meta.Name, meta.Version), nil
+}
+
 func (server *MultiTenantServer) uploadProvenanceFile(log cm_logger.LoggingFn, repo string, content []byte, force bool) *HTTPError {
 	filename, err := cm_repo.ProvenanceFilenameFromContent(content)
 	if err != nil {
@@ -172,13 +699,17 @@ func (server *MultiTenantServer) uploadProvenanceFile(log cm_logger.LoggingFn, r
 		return &HTTPError{http.StatusBadRequest, fmt.Sprintf("%s is improperly formatted", filename)}
 	}
 
-	if !server.AllowOverwrite && (!server.AllowForceOverwrite || !force) {
+	allowOverwrite, overwriteErr := server.allowOverwrite(log, repo)
+	if overwriteErr != nil {
+		return overwriteErr
+	}
+	if !allowOverwrite && (!server.AllowForceOverwrite || !force) {
 		_, err = server.StorageBackend.GetObject(pathutil.Join(repo, filename))
 		if err == nil {
 			return &HTTPError{http.StatusConflict, "file already exists"}
 		}
 	}
-	limitReached, err := server.checkStorageLimit(repo, filename, force)
+	limitReached, err := server.checkStorageLimit(log, repo, filename, force)
 	if err != nil {
 		return &HTTPError{http.StatusInternalServerError, err.Error()}
 	}
@@ -195,25 +726,35 @@ func (server *MultiTenantServer) uploadProvenanceFile(log cm_logger.LoggingFn, r
 	return nil
 }
 
-func (server *MultiTenantServer) checkStorageLimit(repo string, filename string, force bool) (bool, error) {
+func (server *MultiTenantServer) checkStorageLimit(log cm_logger.LoggingFn, repo string, filename string, force bool) (bool, error) {
 	if server.MaxStorageObjects > 0 {
-		allObjects, err := server.StorageBackend.ListObjects(repo)
+		count, exists := 0, false
+		err := cm_pkg_storage.WalkObjects(server.StorageBackend, repo, server.StorageListPageSize, func(objects []storage.Object) error {
+			count += len(objects)
+			for _, object := range objects {
+				if object.Path == filename {
+					exists = true
+				}
+			}
+			return nil
+		})
 		if err != nil {
 			return false, err
 		}
-		if len(allObjects) >= server.MaxStorageObjects {
-			limitReached := true
-			if server.AllowOverwrite || (server.AllowForceOverwrite && force) {
-				// if the max has been reached, we should still allow
-				// user to overwrite an existing file
-				for _, object := range allObjects {
-					if object.Path == filename {
-						limitReached = false
-						break
-					}
-				}
+		if count >= server.MaxStorageObjects {
+			// if the max has been reached, we should still allow
+			// user to overwrite an existing file
+			if !exists {
+				return true, nil
 			}
-			return limitReached, nil
+			allowOverwrite, overwriteErr := server.allowOverwrite(log, repo)
+			if overwriteErr != nil {
+				return false, errors.New(overwriteErr.Message)
+			}
+			if allowOverwrite || (server.AllowForceOverwrite && force) {
+				return false, nil
+			}
+			return true, nil
 		}
 	}
 	return false, nil
@@ -229,12 +770,20 @@ func extractFromChart(content []byte) (name string, version string, err error) {
 	return cv.Metadata.Name, cv.Metadata.Version, nil
 }
 
+// PutWithLimit stores a chart package, removing the oldest version of the chart beyond the
+// per-chart limit. With create, the package is only stored if there is none at its path yet
 func (server *MultiTenantServer) PutWithLimit(ctx *gin.Context, log cm_logger.LoggingFn, repo string,
-	filename string, content []byte,
+	filename string, content []byte, create bool,
 ) error {
+	put := server.StorageBackend.PutObject
+	if create {
+		put = func(path string, content []byte) error {
+			return cm_pkg_storage.CreateObject(server.StorageBackend, path, content)
+		}
+	}
 	if server.ChartLimits == nil {
 		log(cm_logger.DebugLevel, "PutWithLimit: per-chart-limit not set")
-		return server.StorageBackend.PutObject(pathutil.Join(repo, filename), content)
+		return put(pathutil.Join(repo, filename), content)
 	}
 	limit := server.ChartLimits.Limit
 	name, _, err := extractFromChart(content)
@@ -246,22 +795,24 @@ func (server *MultiTenantServer) PutWithLimit(ctx *gin.Context, log cm_logger.Lo
 	defer server.ChartLimits.Unlock()
 	// clean the oldest chart(both index and storage)
 	// storage cache first
-	objs, err := server.StorageBackend.ListObjects(repo)
-	if err != nil {
-		return err
-	}
 	var newObjs []storage.Object
-	for _, obj := range objs {
-		n, _ := cm_repo.GetExactChartNameVersion(obj.Path)
-		if strings.Compare(n, name) != 0 || strings.HasSuffix(obj.Path, ".prov") {
-			continue
+	err = cm_pkg_storage.WalkObjects(server.StorageBackend, repo, server.StorageListPageSize, func(objs []storage.Object) error {
+		for _, obj := range objs {
+			n, _ := cm_repo.GetExactChartNameVersion(obj.Path)
+			if strings.Compare(n, name) != 0 || strings.HasSuffix(obj.Path, ".prov") {
+				continue
+			}
+			log(cm_logger.DebugLevel, "PutWithLimit", "current object name", obj.Path)
+			newObjs = append(newObjs, obj)
 		}
-		log(cm_logger.DebugLevel, "PutWithLimit", "current object name", obj.Path)
-		newObjs = append(newObjs, obj)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	if len(newObjs) < limit {
 		log(cm_logger.DebugLevel, "PutWithLimit", "current objects", len(newObjs))
-		return server.StorageBackend.PutObject(pathutil.Join(repo, filename), content)
+		return put(pathutil.Join(repo, filename), content)
 	}
 	sort.Slice(newObjs, func(i, j int) bool {
 		return newObjs[i].LastModified.Unix() < newObjs[j].LastModified.Unix()
@@ -281,7 +832,7 @@ func (server *MultiTenantServer) PutWithLimit(ctx *gin.Context, log cm_logger.Lo
 	if err != nil {
 		return fmt.Errorf("PutWithLimit: extract chartversion from storage object: %w", err)
 	}
-	if err = server.StorageBackend.PutObject(pathutil.Join(repo, filename), content); err != nil {
+	if err = put(pathutil.Join(repo, filename), content); err != nil {
 		return fmt.Errorf("PutWithLimit: put new chart: %w", err)
 	}
 	go server.emitEvent(ctx, repo, deleteChart, &helm_repo.ChartVersion{
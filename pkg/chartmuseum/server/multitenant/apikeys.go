@@ -0,0 +1,207 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	pathutil "path"
+	"strings"
+	"time"
+
+	cm_auth "github.com/chartmuseum/auth"
+
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+)
+
+const (
+	// apiKeysFilename is the object storing the API keys of a repo, next to its charts
+	apiKeysFilename = "api-keys.json"
+)
+
+type (
+	// storedAPIKey is an API key as stored, with the hash of its secret only
+	storedAPIKey struct {
+		cm_router.APIKey
+		Hash string `json:"hash"`
+	}
+
+	// createAPIKeyRequest is the body of POST /api/:repo/keys
+	createAPIKeyRequest struct {
+		Name    string   `json:"name" binding:"required"`
+		Actions []string `json:"actions" binding:"required"`
+		// ExpiresIn is the lifetime of the key in seconds, it never expires if zero
+		ExpiresIn int `json:"expires_in"`
+	}
+
+	// createdAPIKey is the response of POST /api/:repo/keys, the only one holding the secret
+	createdAPIKey struct {
+		cm_router.APIKey
+		Key string `json:"key"`
+	}
+)
+
+// apiKeyActions are the actions API keys can grant, managing the repo is left to its users
+var apiKeyActions = []string{cm_auth.PullAction, cm_auth.PushAction, cm_router.DeleteAction}
+
+// getAPIKeys reads the API keys of a repo from storage, so that keys created or revoked through
+// any instance are seen by all of them
+func (server *MultiTenantServer) getAPIKeys(repo string) ([]*storedAPIKey, *HTTPError) {
+	var keys []*storedAPIKey
+	object, err := server.StorageBackend.GetObject(pathutil.Join(repo, apiKeysFilename))
+	if err != nil {
+		// no keys created for this repo
+		return keys, nil
+	}
+	if err := json.Unmarshal(object.Content, &keys); err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, "invalid API keys: " + err.Error()}
+	}
+	return keys, nil
+}
+
+func (server *MultiTenantServer) saveAPIKeys(repo string, keys []*storedAPIKey) *HTTPError {
+	content, err := json.Marshal(keys)
+	if err != nil {
+		return &HTTPError{http.StatusInternalServerError, err.Error()}
+	}
+	if err := server.StorageBackend.PutObject(pathutil.Join(repo, apiKeysFilename), content); err != nil {
+		return &HTTPError{http.StatusInternalServerError, err.Error()}
+	}
+	return nil
+}
+
+// createAPIKey creates a key of repo on behalf of user, and returns it with its secret
+func (server *MultiTenantServer) createAPIKey(repo string, user string, req createAPIKeyRequest) (*createdAPIKey, *HTTPError) {
+	if len(req.Actions) == 0 {
+		return nil, &HTTPError{http.StatusBadRequest, "an API key requires actions"}
+	}
+	for _, action := range req.Actions {
+		if !validAPIKeyAction(action) {
+			return nil, &HTTPError{http.StatusBadRequest, fmt.Sprintf("invalid action %q, use %s", action, strings.Join(apiKeyActions, ", "))}
+		}
+	}
+	if req.ExpiresIn < 0 {
+		return nil, &HTTPError{http.StatusBadRequest, "expires_in must be positive"}
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, err.Error()}
+	}
+	id := hex.EncodeToString(random[:8])
+	secret := cm_router.APIKeyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(random[8:])
+	key := &storedAPIKey{
+		APIKey: cm_router.APIKey{
+			ID:      id,
+			Name:    req.Name,
+			User:    user,
+			Actions: req.Actions,
+			Created: time.Now().UTC(),
+		},
+		Hash: apiKeyHash(secret),
+	}
+	if req.ExpiresIn > 0 {
+		expires := key.Created.Add(time.Duration(req.ExpiresIn) * time.Second)
+		key.Expires = &expires
+	}
+
+	server.apiKeysLock.Lock()
+	defer server.apiKeysLock.Unlock()
+	keys, herr := server.getAPIKeys(repo)
+	if herr != nil {
+		return nil, herr
+	}
+	if herr := server.saveAPIKeys(repo, append(keys, key)); herr != nil {
+		return nil, herr
+	}
+	return &createdAPIKey{APIKey: key.APIKey, Key: secret}, nil
+}
+
+// listAPIKeys returns the keys of repo, without their hashes
+func (server *MultiTenantServer) listAPIKeys(repo string) ([]cm_router.APIKey, *HTTPError) {
+	keys, herr := server.getAPIKeys(repo)
+	if herr != nil {
+		return nil, herr
+	}
+	list := make([]cm_router.APIKey, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, key.APIKey)
+	}
+	return list, nil
+}
+
+// revokeAPIKey deletes the key of repo with id, and returns it
+func (server *MultiTenantServer) revokeAPIKey(repo string, id string) (*cm_router.APIKey, *HTTPError) {
+	server.apiKeysLock.Lock()
+	defer server.apiKeysLock.Unlock()
+	keys, herr := server.getAPIKeys(repo)
+	if herr != nil {
+		return nil, herr
+	}
+	for i, key := range keys {
+		if key.ID == id {
+			if herr := server.saveAPIKeys(repo, append(keys[:i], keys[i+1:]...)); herr != nil {
+				return nil, herr
+			}
+			return &key.APIKey, nil
+		}
+	}
+	return nil, &HTTPError{http.StatusNotFound, "API key not found"}
+}
+
+// verifyAPIKey is the cm_router.APIKeyVerifier of the server, returning the unexpired key of repo
+// matching secret
+func (server *MultiTenantServer) verifyAPIKey(repo string, secret string) (*cm_router.APIKey, error) {
+	id, _, found := strings.Cut(strings.TrimPrefix(secret, cm_router.APIKeyPrefix), "_")
+	if !found {
+		return nil, nil
+	}
+	keys, herr := server.getAPIKeys(repo)
+	if herr != nil {
+		return nil, fmt.Errorf("%s", herr.Message)
+	}
+	hash := apiKeyHash(secret)
+	for _, key := range keys {
+		if key.ID == id && subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) == 1 {
+			if key.Expires != nil && time.Now().After(*key.Expires) {
+				return nil, nil
+			}
+			return &key.APIKey, nil
+		}
+	}
+	return nil, nil
+}
+
+func validAPIKeyAction(action string) bool {
+	for _, valid := range apiKeyActions {
+		if action == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func apiKeyHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -17,7 +17,9 @@ limitations under the License.
 package multitenant
 
 import (
+	"fmt"
 	"net/http"
+	"os"
 
 	"sigs.k8s.io/yaml"
 
@@ -27,12 +29,36 @@ import (
 
 const artifactHubFileContentType = "application/x-yaml"
 
+// loadArtifactHubFiles reads the artifacthub-repo.yml content configured for each repo
+func loadArtifactHubFiles(artifactHubRepoFiles map[string]string) (map[string]*cm_repo.ArtifactHubFile, error) {
+	artifactHubFiles := map[string]*cm_repo.ArtifactHubFile{}
+	for repo, path := range artifactHubRepoFiles {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read artifacthub-repo.yml file for repo %q: %s", repo, err)
+		}
+		artifactHubFile, err := cm_repo.ArtifactHubFileFromContent(content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid artifacthub-repo.yml file for repo %q: %s", repo, err)
+		}
+		artifactHubFiles[repo] = artifactHubFile
+	}
+	return artifactHubFiles, nil
+}
+
 func (server *MultiTenantServer) getArtifactHubYml(log cm_logger.LoggingFn, repo string) ([]byte, *HTTPError) {
-	if _, ok := server.ArtifactHubRepoID[repo]; !ok {
+	repoID, hasRepoID := server.ArtifactHubRepoID[repo]
+	configured, hasFile := server.artifactHubFiles[repo]
+	if !hasRepoID && !hasFile {
 		return nil, &HTTPError{http.StatusNotFound, "Artifact Hub repository ID not found"}
 	}
-	artifactHubFile := &cm_repo.ArtifactHubFile{
-		RepoID: server.ArtifactHubRepoID[repo],
+	artifactHubFile := &cm_repo.ArtifactHubFile{}
+	if hasFile {
+		*artifactHubFile = *configured
+	}
+	// the repo ID flag takes precedence over the one in the configured file
+	if hasRepoID {
+		artifactHubFile.RepoID = repoID
 	}
 	log(cm_logger.DebugLevel, "Generating artifacthub-repo.yml file", "repo", repo)
 	rawArtifactHubFile, err := yaml.Marshal(&artifactHubFile)
@@ -0,0 +1,294 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	pathutil "path"
+	"sort"
+	"strings"
+	"time"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+)
+
+const (
+	// batchBundleField is the form field of the tarballs of packages and provenance files of batch uploads
+	batchBundleField = "bundle"
+	// batchBundleMaxSize bounds the files extracted from a gzipped bundle, larger than the upload
+	batchBundleMaxSize = 1 << 30
+
+	batchStatusSaved    = "saved"
+	batchStatusUpdated  = "updated"
+	batchStatusConflict = "conflict"
+	batchStatusFailed   = "failed"
+)
+
+type (
+	// batchFile is a file of a batch upload, named as uploaded until its content is read
+	batchFile struct {
+		name    string
+		content []byte
+		prov    bool
+	}
+
+	// batchFileResult is the result of storing a single file of a batch upload
+	batchFileResult struct {
+		Filename string `json:"filename"`
+		Status   string `json:"status"`
+		Error    string `json:"error,omitempty"`
+	}
+)
+
+// postBatchRequestHandler stores the packages and provenance files of a multipart form, each chart
+// version on its own, so that a file which cannot be stored does not fail the others. The response
+// lists the result of each file, with a 207 unless all of them are stored
+func (server *MultiTenantServer) postBatchRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	log := server.Logger.ContextLoggingFn(c)
+	_, force := c.GetQuery("force")
+	files, err := server.batchFiles(c.Request)
+	if err != nil {
+		if len(c.Errors) > 0 {
+			return // this is a "request too large", already answered with a 413
+		}
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf(
+			"no package or provenance file found in form fields %s, %s and %s",
+			server.ChartPostFormFieldName, server.ProvPostFormFieldName, batchBundleField),
+		})
+		return
+	}
+
+	if !server.MultiChartUpload {
+		packages, provs := 0, 0
+		for _, file := range files {
+			if file.prov {
+				provs++
+			} else {
+				packages++
+			}
+		}
+		if packages > 1 || provs > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "multiple charts in a batch upload require --multi-chart-upload"})
+			return
+		}
+	}
+
+	results := server.uploadBatch(c, log, repo, files, force)
+	status, saved := http.StatusCreated, []string{}
+	for _, result := range results {
+		if result.Status == batchStatusSaved || result.Status == batchStatusUpdated {
+			saved = append(saved, result.Filename)
+		} else {
+			status = http.StatusMultiStatus
+		}
+	}
+	sort.Strings(saved)
+	c.JSON(status, gin.H{"saved": saved, "files": results})
+}
+
+// batchFiles reads the packages and provenance files of the chart, prov and bundle fields of a
+// multipart form
+func (server *MultiTenantServer) batchFiles(req *http.Request) ([]*batchFile, *HTTPError) {
+	if err := req.ParseMultipartForm(server.MultipartMaxMemory); err != nil {
+		if errors.Is(err, multipart.ErrMessageTooLarge) {
+			return nil, &HTTPError{http.StatusRequestEntityTooLarge, err.Error()}
+		}
+		return nil, &HTTPError{http.StatusBadRequest, fmt.Sprintf("malformed multipart request: %s", err)}
+	}
+	var files []*batchFile
+	read := map[string]bool{}
+	for _, field := range []string{defaultFormField, server.ChartPostFormFieldName, defaultProvField, server.ProvPostFormFieldName, batchBundleField} {
+		// the configured fields are the default ones unless set otherwise
+		if read[field] {
+			continue
+		}
+		read[field] = true
+		for _, header := range req.MultipartForm.File[field] {
+			content, err := readMultipartFile(header)
+			if err != nil {
+				return nil, &HTTPError{http.StatusInternalServerError, err.Error()}
+			}
+			switch field {
+			case batchBundleField:
+				bundled, err := bundleFiles(content)
+				if err != nil {
+					return nil, &HTTPError{http.StatusBadRequest, fmt.Sprintf("invalid bundle %s: %s", header.Filename, err)}
+				}
+				files = append(files, bundled...)
+			case defaultProvField, server.ProvPostFormFieldName:
+				files = append(files, &batchFile{name: header.Filename, content: content, prov: true})
+			default:
+				files = append(files, &batchFile{name: header.Filename, content: content})
+			}
+		}
+	}
+	return files, nil
+}
+
+func readMultipartFile(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// bundleFiles returns the packages and provenance files of a tarball, gzipped or not, by the
+// suffix of their names
+func bundleFiles(content []byte) ([]*batchFile, error) {
+	var reader io.Reader = bytes.NewReader(content)
+	if bytes.HasPrefix(content, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = io.LimitReader(gzipReader, batchBundleMaxSize)
+	}
+	var files []*batchFile
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		prov := strings.HasSuffix(header.Name, "."+cm_repo.ChartPackageFileExtension+".prov")
+		if !prov && !strings.HasSuffix(header.Name, "."+cm_repo.ChartPackageFileExtension) {
+			continue
+		}
+		fileContent, err := io.ReadAll(tarReader)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &batchFile{name: pathutil.Base(header.Name), content: fileContent, prov: prov})
+	}
+}
+
+// uploadBatch stores each package with its provenance file, if any, and the provenance files of no
+// package of the batch, reporting the result of each file in the order of their names
+func (server *MultiTenantServer) uploadBatch(c *gin.Context, log cm_logger.LoggingFn, repo string, files []*batchFile,
+	force bool) []batchFileResult {
+	results := map[string]batchFileResult{}
+	fail := func(filename string, status string, err string) {
+		results[filename] = batchFileResult{Filename: filename, Status: status, Error: err}
+	}
+	packages := map[string][]byte{}
+	provs := map[string][]byte{}
+	for _, file := range files {
+		filenameFromContent := server.chartPackageFilenameFromContent
+		if file.prov {
+			filenameFromContent = cm_repo.ProvenanceFilenameFromContent
+		}
+		filename, err := filenameFromContent(file.content)
+		switch {
+		case err != nil:
+			fail(file.name, batchStatusFailed, err.Error())
+		case pathutil.Base(filename) != filename:
+			fail(file.name, batchStatusFailed, fmt.Sprintf("%s is improperly formatted", filename))
+		case server.isReservedObject(filename):
+			fail(filename, batchStatusFailed, fmt.Sprintf("%s uses a reserved prefix", filename))
+		case file.prov:
+			provs[filename] = file.content
+		default:
+			packages[filename] = file.content
+		}
+	}
+
+	for filename, content := range packages {
+		provContent, signed := provs[filename+".prov"]
+		delete(provs, filename+".prov")
+		_, updated, err := server.uploadChartPackageAndProvenance(log, repo, content, provContent, force)
+		if err != nil {
+			status := batchStatusFailed
+			if err.Status == http.StatusConflict {
+				status = batchStatusConflict
+			}
+			fail(filename, status, err.Message)
+			if signed {
+				fail(filename+".prov", batchStatusFailed, "package not stored")
+			}
+			continue
+		}
+		status := batchStatusSaved
+		if updated {
+			status = batchStatusUpdated
+		}
+		results[filename] = batchFileResult{Filename: filename, Status: status}
+		if signed {
+			results[filename+".prov"] = batchFileResult{Filename: filename + ".prov", Status: status}
+		}
+
+		chart, chartErr := cm_repo.ChartVersionFromStorageObject(cm_storage.Object{
+			Path:         pathutil.Join(repo, filename),
+			Content:      content,
+			LastModified: time.Now(),
+		})
+		if chartErr != nil {
+			log(cm_logger.ErrorLevel, "cannot get chart from content", "error", chartErr.Error())
+			continue
+		}
+		action := addChart
+		if updated {
+			action = updateChart
+		}
+		cm_router.AuditChart(c, chart.Name, chart.Version)
+		server.emitEvent(c, repo, action, chart)
+	}
+
+	for filename, content := range provs {
+		if err := server.uploadProvenanceFile(log, repo, content, force); err != nil {
+			status := batchStatusFailed
+			if err.Status == http.StatusConflict {
+				status = batchStatusConflict
+			}
+			fail(filename, status, err.Message)
+			continue
+		}
+		results[filename] = batchFileResult{Filename: filename, Status: batchStatusSaved}
+	}
+
+	sorted := make([]batchFileResult, 0, len(results))
+	for _, result := range results {
+		sorted = append(sorted, result)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Filename < sorted[j].Filename })
+	return sorted
+}
@@ -36,14 +36,18 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	pathutil "path"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
 	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	cm_pkg_storage "helm.sh/chartmuseum/pkg/storage"
 
 	cm_storage "github.com/chartmuseum/storage"
 	"github.com/gin-gonic/gin"
@@ -57,7 +61,10 @@ type (
 		// cryptic JSON field names to minimize size saved in cache
 		RepoName  string         `json:"a"`
 		RepoIndex *cm_repo.Index `json:"b"`
-		RepoLock  sync.RWMutex
+		// PackageSizes are the sizes of the packages of the versions of the repo, by digest
+		PackageSizes map[string]int64 `json:"c,omitempty"`
+		Stats        *repoStats       `json:"d,omitempty"`
+		RepoLock     sync.RWMutex
 	}
 
 	memoryCacheStore struct {
@@ -81,6 +88,10 @@ const (
 )
 
 var (
+	// defaultReservedPrefixes hold objects stored next to the charts which are not charts
+	// (deleted charts, audit records, attachments), always skipped when listing charts
+	defaultReservedPrefixes = []string{"trash/", "audit/", "attachments/"}
+
 	EntrySavedMessage             = "Entry saved in cache store"
 	CouldNotSaveEntryErrorMessage = "Could not save entry in cache store"
 )
@@ -151,11 +162,13 @@ func (server *MultiTenantServer) regenerateRepositoryIndexWorker(log cm_logger.L
 	log(cm_logger.DebugLevel, "Regenerating index.yaml",
 		"repo", repo,
 	)
+	started := time.Now()
 	index := &cm_repo.Index{
 		IndexFile:  entry.RepoIndex.IndexFile,
 		RepoName:   repo,
 		Raw:        entry.RepoIndex.Raw,
 		ChartURL:   entry.RepoIndex.ChartURL,
+		Digest:     entry.RepoIndex.Digest,
 		IndexLock:  sync.RWMutex{},
 		OutputJSON: server.JSONIndex,
 	}
@@ -180,6 +193,7 @@ func (server *MultiTenantServer) regenerateRepositoryIndexWorker(log cm_logger.L
 		return nil, err
 	}
 
+	server.applyChartDeprecations(log, repo, index)
 	err = index.Regenerate()
 	if err != nil {
 		return nil, err
@@ -190,6 +204,7 @@ func (server *MultiTenantServer) regenerateRepositoryIndexWorker(log cm_logger.L
 	)
 
 	entry.RepoIndex = index
+	server.updateRepoStats(entry, started)
 	err = server.saveCacheEntry(log, entry)
 	return index, err
 }
@@ -198,22 +213,34 @@ func (server *MultiTenantServer) fetchChartsInStorage(log cm_logger.LoggingFn, r
 	log(cm_logger.DebugLevel, "Fetching chart list from storage",
 		"repo", repo,
 	)
-	allObjects, err := server.StorageBackend.ListObjects(repo)
-	if err != nil {
-		return []cm_storage.Object{}, err
-	}
-
 	// filter out storage objects that dont have extension used for chart packages (.tgz)
+	// or that are stored under a reserved prefix, a page at a time
 	filteredObjects := []cm_storage.Object{}
-	for _, object := range allObjects {
-		if object.HasExtension(cm_repo.ChartPackageFileExtension) {
-			filteredObjects = append(filteredObjects, object)
+	err := cm_pkg_storage.WalkObjects(server.StorageBackend, repo, server.StorageListPageSize, func(objects []cm_storage.Object) error {
+		for _, object := range objects {
+			if object.HasExtension(cm_repo.ChartPackageFileExtension) && !server.isReservedObject(object.Path) {
+				filteredObjects = append(filteredObjects, object)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return []cm_storage.Object{}, err
 	}
 
 	return filteredObjects, nil
 }
 
+// isReservedObject checks whether an object path, relative to its repo, starts with a reserved prefix
+func (server *MultiTenantServer) isReservedObject(path string) bool {
+	for _, prefix := range server.ReservedPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (server *MultiTenantServer) removeIndexObject(log cm_logger.LoggingFn, repo string, index *cm_repo.Index, object cm_storage.Object) error {
 	chartVersion, err := server.getObjectChartVersion(repo, object, false)
 	if err != nil {
@@ -277,7 +304,9 @@ func (server *MultiTenantServer) addIndexObjectsAsync(log cm_logger.LoggingFn, r
 			case <-ctx.Done():
 				return
 			default:
+				atomic.AddInt64(&indexWorkersBusy, 1)
 				chartVersion, err := server.getObjectChartVersion(repo, o, true)
+				atomic.AddInt64(&indexWorkersBusy, -1)
 				if err != nil {
 					err = server.checkInvalidChartPackageError(log, repo, o, err, "added")
 					if err != nil {
@@ -320,8 +349,14 @@ func (server *MultiTenantServer) getObjectChartVersion(repo string, object cm_st
 		if len(object.Content) == 0 {
 			return nil, cm_repo.ErrorInvalidChartPackage
 		}
+		server.recordPackageSize(repo, op, len(object.Content))
+	}
+	chartVersion, err := cm_repo.ChartVersionFromStorageObject(object)
+	if err != nil {
+		return nil, err
 	}
-	return cm_repo.ChartVersionFromStorageObject(object)
+	server.addAlternateChartURL(repo, chartVersion)
+	return chartVersion, nil
 }
 
 func (server *MultiTenantServer) checkInvalidChartPackageError(log cm_logger.LoggingFn, repo string, object cm_storage.Object, err error, action string) error {
@@ -347,6 +382,8 @@ func (server *MultiTenantServer) initCacheEntry(log cm_logger.LoggingFn, repo st
 	if _, ok := server.Tenants[repo]; !ok {
 		server.Tenants[repo] = &tenantInternals{
 			FetchedObjectsLock: &sync.Mutex{},
+			PendingEventsLock:  &sync.Mutex{},
+			FlushEventsLock:    &sync.Mutex{},
 		}
 	}
 
@@ -430,26 +467,14 @@ func (server *MultiTenantServer) saveCacheEntry(log cm_logger.LoggingFn, entry *
 }
 
 func (server *MultiTenantServer) newRepositoryIndex(log cm_logger.LoggingFn, repo string) *cm_repo.Index {
-	var chartURL string
-	if server.ChartURL != "" {
-		chartURL = server.ChartURL
-		if repo != "" {
-			chartURL = chartURL + "/" + repo
-		}
-	}
-
-	serverInfo := &cm_repo.ServerInfo{
-		ContextPath: server.Router.ContextPath,
-	}
-
 	if !server.UseStatefiles {
-		return cm_repo.NewIndex(chartURL, repo, serverInfo, server.JSONIndex)
+		return server.newEmptyRepositoryIndex(repo)
 	}
 
 	objectPath := pathutil.Join(repo, cm_repo.StatefileFilename)
 	object, err := server.StorageBackend.GetObject(objectPath)
 	if err != nil {
-		return cm_repo.NewIndex(chartURL, repo, serverInfo, server.JSONIndex)
+		return server.newEmptyRepositoryIndex(repo)
 	}
 
 	indexFile := &cm_repo.IndexFile{}
@@ -464,7 +489,7 @@ func (server *MultiTenantServer) newRepositoryIndex(log cm_logger.LoggingFn, rep
 			"repo", repo,
 			"error", err.Error(),
 		)
-		return cm_repo.NewIndex(chartURL, repo, serverInfo, server.JSONIndex)
+		return server.newEmptyRepositoryIndex(repo)
 	}
 
 	log(cm_logger.DebugLevel, "index-cache.yaml loaded",
@@ -475,12 +500,28 @@ func (server *MultiTenantServer) newRepositoryIndex(log cm_logger.LoggingFn, rep
 		IndexFile:  indexFile,
 		RepoName:   repo,
 		Raw:        object.Content,
-		ChartURL:   chartURL,
+		ChartURL:   server.repoChartURL(repo),
 		IndexLock:  sync.RWMutex{},
 		OutputJSON: server.JSONIndex,
 	}
 }
 
+// newEmptyRepositoryIndex returns an index of repo without any chart
+func (server *MultiTenantServer) newEmptyRepositoryIndex(repo string) *cm_repo.Index {
+	serverInfo := &cm_repo.ServerInfo{
+		ContextPath: server.Router.ContextPath,
+	}
+	return cm_repo.NewIndex(server.repoChartURL(repo), repo, serverInfo, server.JSONIndex)
+}
+
+// repoChartURL is the absolute URL of the charts of repo in its index, empty for relative URLs
+func (server *MultiTenantServer) repoChartURL(repo string) string {
+	if server.ChartURL == "" || repo == "" {
+		return server.ChartURL
+	}
+	return server.ChartURL + "/" + repo
+}
+
 func (server *MultiTenantServer) initCacheTimer() {
 	if server.CacheInterval > 0 {
 		// delta update the cache every X duration
@@ -496,6 +537,7 @@ func (server *MultiTenantServer) initCacheTimer() {
 }
 
 func (server *MultiTenantServer) emitEvent(c *gin.Context, repo string, operationType operationType, chart *helm_repo.ChartVersion) {
+	atomic.AddInt64(&indexEventsQueued, 1)
 	server.EventChan <- event{
 		Context:      c,
 		RepoName:     repo,
@@ -510,38 +552,53 @@ func (server *MultiTenantServer) startEventListener() {
 		e := <-server.EventChan
 		log := server.Logger.ContextLoggingFn(e.Context)
 
-		repo := e.RepoName
 		log(cm_logger.DebugLevel, "Event received", zap.Any("event", e))
 
-		entry, err := server.initCacheEntry(log, repo)
-		if err != nil {
-			log(cm_logger.ErrorLevel, "Error initializing cache entry", zap.Error(err), zap.String("repo", repo))
+		if server.indexDebounce(e.RepoName) > 0 {
+			server.debounceEvent(log, e)
 			continue
 		}
-		entry.RepoLock.RLock()
-		index := entry.RepoIndex
-		entry.RepoLock.RUnlock()
+		server.handleEvents(log, e.RepoName, []event{e})
+	}
+}
 
-		server.TenantCacheKeyLock.Lock()
-		_, ok := server.Tenants[e.RepoName]
-		server.TenantCacheKeyLock.Unlock()
+// handleEvents applies the events to the cached index of a repo, then regenerates it once
+func (server *MultiTenantServer) handleEvents(log cm_logger.LoggingFn, repo string, events []event) {
+	defer atomic.AddInt64(&indexEventsQueued, -int64(len(events)))
 
-		if !ok {
-			log(cm_logger.ErrorLevel, "Error find tenants repo name", zap.Error(err), zap.String("repo", repo))
-			continue
-		}
+	entry, err := server.initCacheEntry(log, repo)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error initializing cache entry", zap.Error(err), zap.String("repo", repo))
+		return
+	}
+
+	server.TenantCacheKeyLock.Lock()
+	_, ok := server.Tenants[repo]
+	server.TenantCacheKeyLock.Unlock()
+
+	if !ok {
+		log(cm_logger.ErrorLevel, "Error find tenants repo name", zap.Error(err), zap.String("repo", repo))
+		return
+	}
 
+	var handled []event
+	entry.RepoLock.Lock()
+	started := time.Now()
+	index := entry.RepoIndex
+	index.IndexLock.Lock()
+	for _, e := range events {
 		if e.ChartVersion == nil {
 			log(cm_logger.WarnLevel, "Event does not contain chart version", zap.String("repo", repo),
 				"operation_type", e.OpType)
 			continue
 		}
 
-		entry.RepoLock.Lock()
 		switch e.OpType {
 		case updateChart:
+			server.addAlternateChartURL(repo, e.ChartVersion)
 			index.UpdateEntry(e.ChartVersion)
 		case addChart:
+			server.addAlternateChartURL(repo, e.ChartVersion)
 			index.AddEntry(e.ChartVersion)
 		case deleteChart:
 			index.RemoveEntry(e.ChartVersion)
@@ -550,28 +607,137 @@ func (server *MultiTenantServer) startEventListener() {
 				"operation_type", e.OpType)
 			continue
 		}
+		handled = append(handled, e)
+	}
+	index.IndexLock.Unlock()
+	if len(handled) == 0 {
+		entry.RepoLock.Unlock()
+		return
+	}
 
-		err = index.Regenerate()
-		if err != nil {
-			log(cm_logger.ErrorLevel, "Error regenerating index", zap.Error(err), zap.String("repo", repo))
-			continue
-		}
-		entry.RepoIndex = index
+	server.applyChartDeprecations(log, repo, index)
+	err = index.Regenerate()
+	if err != nil {
 		entry.RepoLock.Unlock()
-		err = server.saveCacheEntry(log, entry)
-		if err != nil {
-			log(cm_logger.ErrorLevel, "Error saving cache entry", zap.Error(err), zap.String("repo", repo))
-			continue
+		log(cm_logger.ErrorLevel, "Error regenerating index", zap.Error(err), zap.String("repo", repo))
+		return
+	}
+	entry.RepoIndex = index
+	server.updateRepoStats(entry, started)
+	entry.RepoLock.Unlock()
+	err = server.saveCacheEntry(log, entry)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error saving cache entry", zap.Error(err), zap.String("repo", repo))
+		return
+	}
+
+	if server.UseStatefiles {
+		// Dont wait, save index-cache.yaml to storage in the background.
+		// It is not crucial if this does not succeed, we will just log any errors
+		go server.saveStatefile(log, repo, entry.RepoIndex.Raw)
+	}
+
+	for _, e := range handled {
+		log(cm_logger.DebugLevel, "Event handled successfully", zap.Any("event", e))
+	}
+}
+
+// debounceEvent queues an event so that a burst of uploads to the same repo
+// results in a single index regeneration, the cached index is served meanwhile
+func (server *MultiTenantServer) debounceEvent(log cm_logger.LoggingFn, e event) {
+	repo := e.RepoName
+	server.TenantCacheKeyLock.Lock()
+	tenant, ok := server.Tenants[repo]
+	server.TenantCacheKeyLock.Unlock()
+	if !ok {
+		// nothing cached for this repo yet, there is no index to keep serving
+		server.handleEvents(log, repo, []event{e})
+		return
+	}
+
+	tenant.PendingEventsLock.Lock()
+	defer tenant.PendingEventsLock.Unlock()
+
+	now := time.Now()
+	if len(tenant.PendingEvents) == 0 {
+		tenant.PendingEventsSince = now
+	}
+	tenant.PendingEvents = append(tenant.PendingEvents, e)
+	if tenant.PendingEventsTimer != nil {
+		tenant.PendingEventsTimer.Stop()
+	}
+	tenant.PendingEventsTimer = time.AfterFunc(server.debounceDelay(repo, now, tenant.PendingEventsSince), func() {
+		server.flushPendingEvents(server.Logger.ContextLoggingFn(&gin.Context{}), repo)
+	})
+	log(cm_logger.DebugLevel, "Index regeneration debounced",
+		"repo", repo,
+		"pending", len(tenant.PendingEvents),
+	)
+}
+
+// indexDebounce returns how long to wait for more events before regenerating the index of repo,
+// the one set for the repo if any
+func (server *MultiTenantServer) indexDebounce(repo string) time.Duration {
+	if debounce, ok := server.indexDebounces[repo]; ok {
+		return debounce
+	}
+	return server.IndexDebounce
+}
+
+// parseIndexDebounces parses the durations of IndexDebounceRepos, by repo
+func parseIndexDebounces(values map[string]string) (map[string]time.Duration, error) {
+	debounces := make(map[string]time.Duration, len(values))
+	for repo, value := range values {
+		debounce, err := time.ParseDuration(value)
+		if err != nil || debounce < 0 {
+			return nil, fmt.Errorf("invalid index debounce for repo %q: %s", repo, value)
 		}
+		debounces[repo] = debounce
+	}
+	return debounces, nil
+}
 
-		if server.UseStatefiles {
-			// Dont wait, save index-cache.yaml to storage in the background.
-			// It is not crucial if this does not succeed, we will just log any errors
-			go server.saveStatefile(log, e.RepoName, entry.RepoIndex.Raw)
+// debounceDelay returns how long to wait for more events before regenerating the index of repo,
+// bounded so the index is never staler than IndexMaxStaleness
+func (server *MultiTenantServer) debounceDelay(repo string, now time.Time, pendingSince time.Time) time.Duration {
+	delay := server.indexDebounce(repo)
+	if server.IndexMaxStaleness > 0 {
+		if remaining := pendingSince.Add(server.IndexMaxStaleness).Sub(now); remaining < delay {
+			delay = remaining
 		}
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
 
-		log(cm_logger.DebugLevel, "Event handled successfully", zap.Any("event", e))
+// flushPendingEvents regenerates the index of a repo with its debounced events, if any
+func (server *MultiTenantServer) flushPendingEvents(log cm_logger.LoggingFn, repo string) {
+	server.TenantCacheKeyLock.Lock()
+	tenant, ok := server.Tenants[repo]
+	server.TenantCacheKeyLock.Unlock()
+	if !ok {
+		return
+	}
+
+	// held while handling, so that batches of the same repo are applied in order. Events keep
+	// being queued meanwhile, only the pending batch is taken under PendingEventsLock
+	tenant.FlushEventsLock.Lock()
+	defer tenant.FlushEventsLock.Unlock()
+
+	tenant.PendingEventsLock.Lock()
+	if tenant.PendingEventsTimer != nil {
+		tenant.PendingEventsTimer.Stop()
+		tenant.PendingEventsTimer = nil
+	}
+	events := tenant.PendingEvents
+	tenant.PendingEvents = nil
+	tenant.PendingEventsLock.Unlock()
+	if len(events) == 0 {
+		return
 	}
+	server.handleEvents(log, repo, events)
 }
 
 func (server *MultiTenantServer) rebuildIndex() {
@@ -644,6 +810,161 @@ func (server *MultiTenantServer) refreshCacheEntry(log cm_logger.LoggingFn, repo
 	}
 }
 
+// reconcileIndexIfDue drops index entries whose package was deleted out-of-band (and adds the ones
+// uploaded out-of-band) by diffing the cached index with a fresh storage listing, at most once per ReconcileInterval
+func (server *MultiTenantServer) reconcileIndexIfDue(log cm_logger.LoggingFn, repo string) {
+	if server.ReconcileInterval <= 0 {
+		return
+	}
+
+	server.TenantCacheKeyLock.Lock()
+	tenant, ok := server.Tenants[repo]
+	// an unknown tenant is going to be built from a fresh listing anyway
+	due := ok && time.Since(tenant.LastReconciled) >= server.ReconcileInterval
+	if due {
+		tenant.LastReconciled = time.Now()
+	}
+	server.TenantCacheKeyLock.Unlock()
+
+	if due {
+		server.reconcileIndex(log, repo)
+	}
+}
+
+// reconcileIndexForMissingObject reconciles the index of a repo when it references a chart package
+// which is not in storage anymore, so that the phantom entry is not served again
+func (server *MultiTenantServer) reconcileIndexForMissingObject(log cm_logger.LoggingFn, repo string, filename string) {
+	entry, err := server.initCacheEntry(log, repo)
+	if err != nil {
+		return
+	}
+
+	referenced := false
+	entry.RepoLock.RLock()
+	for _, chartVersions := range entry.RepoIndex.Entries {
+		for _, cv := range chartVersions {
+			if len(cv.URLs) > 0 && pathutil.Base(cv.URLs[0]) == filename {
+				referenced = true
+				break
+			}
+		}
+	}
+	entry.RepoLock.RUnlock()
+
+	if referenced {
+		log(cm_logger.WarnLevel, "Index references a chart package missing from storage",
+			"repo", repo,
+			"filename", filename,
+		)
+		server.refreshCacheEntry(log, repo, entry)
+	}
+}
+
+func (server *MultiTenantServer) reconcileIndex(log cm_logger.LoggingFn, repo string) {
+	entry, err := server.initCacheEntry(log, repo)
+	if err != nil {
+		log(cm_logger.ErrorLevel, err.Error(),
+			"repo", repo,
+		)
+		return
+	}
+	log(cm_logger.DebugLevel, "Reconciling index with storage",
+		"repo", repo,
+	)
+	server.refreshCacheEntry(log, repo, entry)
+}
+
+// regenerateIndex rebuilds the index of a repo from a fresh listing of its storage, rather than on
+// top of the cached index or statefile, e.g. after charts were changed in the bucket out-of-band.
+// The cached index is kept if the regeneration fails
+func (server *MultiTenantServer) regenerateIndex(log cm_logger.LoggingFn, repo string) (*cm_repo.Index, error) {
+	entry, err := server.initCacheEntry(log, repo)
+	if err != nil {
+		return nil, err
+	}
+	fo := <-server.getChartList(log, repo)
+	if fo.err != nil {
+		return nil, fo.err
+	}
+
+	entry.RepoLock.Lock()
+	defer entry.RepoLock.Unlock()
+	previous := entry.RepoIndex
+	entry.RepoIndex = server.newEmptyRepositoryIndex(repo)
+	diff := cm_storage.GetObjectSliceDiff(nil, fo.objects, server.TimestampTolerance)
+	ir := <-server.regenerateRepositoryIndex(log, entry, diff)
+	if ir.err != nil {
+		entry.RepoIndex = previous
+		return nil, ir.err
+	}
+	entry.RepoIndex = ir.index
+	if server.UseStatefiles {
+		go server.saveStatefile(log, repo, ir.index.Raw)
+	}
+	log(cm_logger.InfoLevel, "Index regenerated from storage",
+		"repo", repo,
+	)
+	return ir.index, nil
+}
+
+// flushCache drops the cached index of the given repos (all known tenants if repos is nil), along
+// with the chart files, index representations, package sizes and upstream index kept in memory for
+// them, and returns the number of cache entries removed
+func (server *MultiTenantServer) flushCache(log cm_logger.LoggingFn, repos []string) int {
+	if repos == nil {
+		server.TenantCacheKeyLock.Lock()
+		for repo := range server.Tenants {
+			repos = append(repos, repo)
+		}
+		server.TenantCacheKeyLock.Unlock()
+	}
+
+	flushed := 0
+	for _, repo := range repos {
+		if server.flushCacheEntry(log, repo) {
+			flushed++
+		}
+		flushed += server.chartFileCache.flush(repo)
+		flushed += server.indexRepresentations.flush(repo)
+		flushed += server.flushPackageSizes(repo)
+		flushed += server.upstream.flush(repo)
+	}
+	return flushed
+}
+
+func (server *MultiTenantServer) flushCacheEntry(log cm_logger.LoggingFn, repo string) bool {
+	if server.ExternalCacheStore == nil {
+		entry, ok := server.InternalCacheStore.Load(repo)
+		if !ok {
+			return false
+		}
+		// wait for any in-flight index regeneration holding the entry.
+		// RepoLock must be taken before TenantCacheKeyLock, as in getIndexFile
+		entry.RepoLock.Lock()
+		defer entry.RepoLock.Unlock()
+		server.TenantCacheKeyLock.Lock()
+		defer server.TenantCacheKeyLock.Unlock()
+		server.InternalCacheStore.Delete(repo)
+	} else {
+		server.TenantCacheKeyLock.Lock()
+		defer server.TenantCacheKeyLock.Unlock()
+		if _, err := server.ExternalCacheStore.Get(repo); err != nil {
+			return false
+		}
+		if err := server.ExternalCacheStore.Delete(repo); err != nil {
+			log(cm_logger.ErrorLevel, "Could not delete entry from cache store",
+				"error", err.Error(),
+				"repo", repo,
+			)
+			return false
+		}
+	}
+	log(cm_logger.InfoLevel, "Entry flushed from cache store",
+		"repo", repo,
+	)
+	return true
+}
+
 func (m *memoryCacheStore) Load(key interface{}) (*cacheEntry, bool) {
 	var entry *cacheEntry
 	var okinterface bool
@@ -660,3 +981,7 @@ func (m *memoryCacheStore) Load(key interface{}) (*cacheEntry, bool) {
 func (m *memoryCacheStore) Store(key, value interface{}) {
 	m.cache.Store(key, value)
 }
+
+func (m *memoryCacheStore) Delete(key interface{}) {
+	m.cache.Delete(key)
+}
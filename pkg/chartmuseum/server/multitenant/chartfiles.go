@@ -0,0 +1,102 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+const (
+	// chartFileCacheMaxSize bounds the size of the files of chart packages kept in memory, in bytes
+	chartFileCacheMaxSize = 32 << 20
+)
+
+type (
+	// chartFileCache keeps the files of chart packages last read through the API, such as their
+	// values.yaml, by repo and digest of the package, so that overwritten versions are never served
+	// stale files. The least recently read ones are dropped once the cache holds more than maxSize bytes
+	chartFileCache struct {
+		lock    sync.Mutex
+		maxSize int
+		size    int
+		order   *list.List
+		entries map[string]*list.Element
+	}
+
+	chartFileCacheEntry struct {
+		key     string
+		content []byte
+	}
+)
+
+func newChartFileCache(maxSize int) *chartFileCache {
+	return &chartFileCache{maxSize: maxSize, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func chartFileCacheKey(repo string, digest string, file string) string {
+	return repo + "@" + digest + "/" + file
+}
+
+func (c *chartFileCache) get(repo string, digest string, file string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, found := c.entries[chartFileCacheKey(repo, digest, file)]
+	if !found {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*chartFileCacheEntry).content, true
+}
+
+// flush drops the files of the chart packages of repo, returning how many were dropped
+func (c *chartFileCache) flush(repo string) int {
+	prefix := repo + "@"
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	flushed := 0
+	for key, element := range c.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		c.order.Remove(element)
+		delete(c.entries, key)
+		c.size -= len(element.Value.(*chartFileCacheEntry).content)
+		flushed++
+	}
+	return flushed
+}
+
+func (c *chartFileCache) add(repo string, digest string, file string, content []byte) {
+	if len(content) > c.maxSize {
+		return
+	}
+	key := chartFileCacheKey(repo, digest, file)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, found := c.entries[key]; found {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&chartFileCacheEntry{key: key, content: content})
+	c.size += len(content)
+	for c.size > c.maxSize {
+		oldest := c.order.Remove(c.order.Back()).(*chartFileCacheEntry)
+		delete(c.entries, oldest.key)
+		c.size -= len(oldest.content)
+	}
+}
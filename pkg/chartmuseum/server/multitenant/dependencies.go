@@ -0,0 +1,259 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	pathutil "path"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+	"helm.sh/helm/v3/pkg/chart"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+const (
+	// maxDependencyGraphNodes bounds the charts of a dependency graph, truncated past it
+	maxDependencyGraphNodes = 500
+
+	// dependencyStatusFound is the status of the charts stored in this server
+	dependencyStatusFound = "found"
+	// dependencyStatusMissing is the status of the dependencies on a repo of this server holding no
+	// version of the chart matching the constraint
+	dependencyStatusMissing = "missing"
+	// dependencyStatusForbidden is the status of the dependencies on a repo of this server the client
+	// cannot read
+	dependencyStatusForbidden = "forbidden"
+	// dependencyStatusBundled is the status of the dependencies packaged with the chart, in its charts
+	// directory
+	dependencyStatusBundled = "bundled"
+	// dependencyStatusExternal is the status of the dependencies on another chart repository
+	dependencyStatusExternal = "external"
+)
+
+type (
+	// dependencyGraph is the graph of the dependencies of a chart version, from the root chart version
+	// to the dependencies of the charts found in this server, recursively
+	dependencyGraph struct {
+		Root  string            `json:"root"`
+		Nodes []*dependencyNode `json:"nodes"`
+		Edges []dependencyEdge  `json:"edges"`
+		// Truncated is set when the graph has more than maxDependencyGraphNodes charts
+		Truncated bool `json:"truncated"`
+	}
+
+	// dependencyNode is a chart of a dependency graph, a version stored in this server if found, or
+	// the dependency of another chart otherwise
+	dependencyNode struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		// Repo is the repo of this server of the chart, if any
+		Repo string `json:"repo"`
+		// Repository is the repository of the dependency, as set in its Chart.yaml or requirements.yaml
+		Repository string `json:"repository,omitempty"`
+		Status     string `json:"status"`
+	}
+
+	// dependencyEdge is the dependency of a chart on another
+	dependencyEdge struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+		// Version is the version constraint of the dependency
+		Version   string `json:"version"`
+		Alias     string `json:"alias,omitempty"`
+		Condition string `json:"condition,omitempty"`
+	}
+)
+
+func (server *MultiTenantServer) getChartDependenciesRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	version, err := chartVersionParam(c, true)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	graph, err := server.dependencyGraph(c, server.Logger.ContextLoggingFn(c), repo, name, version)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	c.JSON(200, graph)
+}
+
+// dependencyGraph returns the graph of the dependencies of a chart version, as set in the Chart.yaml
+// or requirements.yaml of the packages. The dependencies on the repos of this server the client may
+// read are resolved to their latest version matching the constraint, and their own dependencies added
+func (server *MultiTenantServer) dependencyGraph(c *gin.Context, log cm_logger.LoggingFn, repo string, name string,
+	version string) (*dependencyGraph, *HTTPError) {
+	chartVersion, err := server.getChartVersion(log, repo, name, version)
+	if err != nil {
+		return nil, err
+	}
+	root := foundDependencyNode(repo, chartVersion)
+	graph := &dependencyGraph{Root: root.ID, Nodes: []*dependencyNode{root}, Edges: []dependencyEdge{}}
+	nodes := map[string]*dependencyNode{root.ID: root}
+	for queue := []*dependencyNode{root}; len(queue) > 0; queue = queue[1:] {
+		parent := queue[0]
+		dependencies, err := server.chartDependencies(log, parent.Repo, parent.Name, parent.Version)
+		if err != nil {
+			return nil, err
+		}
+		for _, dependency := range dependencies {
+			node, err := server.resolveDependency(c, log, parent, dependency)
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := nodes[node.ID]; !exists {
+				if len(nodes) == maxDependencyGraphNodes {
+					graph.Truncated = true
+					return graph, nil
+				}
+				nodes[node.ID] = node
+				graph.Nodes = append(graph.Nodes, node)
+				if node.Status == dependencyStatusFound {
+					queue = append(queue, node)
+				}
+			}
+			graph.Edges = append(graph.Edges, dependencyEdge{
+				From:      parent.ID,
+				To:        node.ID,
+				Version:   dependency.Version,
+				Alias:     dependency.Alias,
+				Condition: dependency.Condition,
+			})
+		}
+	}
+	return graph, nil
+}
+
+// chartDependencies returns the dependencies of a chart version, read from its package
+func (server *MultiTenantServer) chartDependencies(log cm_logger.LoggingFn, repo string, name string, version string) ([]*chart.Dependency, *HTTPError) {
+	content, _, err := server.getChartMetadata(log, repo, name, version)
+	if err != nil {
+		return nil, err
+	}
+	metadata := &chart.Metadata{}
+	if jsonErr := json.Unmarshal(content, metadata); jsonErr != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, jsonErr.Error()}
+	}
+	return metadata.Dependencies, nil
+}
+
+// resolveDependency returns the node of a dependency of the chart parent, the latest version of the
+// chart matching the constraint when found in this server
+func (server *MultiTenantServer) resolveDependency(c *gin.Context, log cm_logger.LoggingFn, parent *dependencyNode,
+	dependency *chart.Dependency) (*dependencyNode, *HTTPError) {
+	node := &dependencyNode{
+		Name:       dependency.Name,
+		Version:    dependency.Version,
+		Repository: dependency.Repository,
+	}
+	if dependency.Repository == "" || strings.HasPrefix(dependency.Repository, "file://") {
+		// packaged with each chart depending on it
+		node.ID = pathutil.Join(parent.ID, "charts", dependency.Name)
+		node.Status = dependencyStatusBundled
+		return node, nil
+	}
+	repo, local := server.dependencyRepo(c, dependency.Repository)
+	if !local {
+		node.ID = dependency.Repository + "#" + dependency.Name + "@" + dependency.Version
+		node.Status = dependencyStatusExternal
+		return node, nil
+	}
+	node.Repo = repo
+	node.ID = pathutil.Join(repo, dependency.Name) + "@" + dependency.Version
+	if repo != c.GetString("repo") && !server.Router.AllowsRepo(c, cm_auth.PullAction, repo) {
+		node.Status = dependencyStatusForbidden
+		return node, nil
+	}
+	versions, err := server.getChart(log, repo, dependency.Name)
+	if err != nil && err.Status != http.StatusNotFound {
+		return nil, err
+	}
+	if latest := latestMatchingChartVersion(versions, dependency.Version); latest != nil {
+		return foundDependencyNode(repo, latest), nil
+	}
+	node.Status = dependencyStatusMissing
+	return node, nil
+}
+
+// dependencyRepo returns the repo of this server a chart repository URL points to, if any, by the
+// chart URL of the server or the host of the request
+func (server *MultiTenantServer) dependencyRepo(c *gin.Context, repository string) (string, bool) {
+	u, err := url.Parse(repository)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	var prefix string
+	if chartURL, err := url.Parse(server.ChartURL); err == nil && server.ChartURL != "" && chartURL.Host == u.Host {
+		prefix = chartURL.Path
+	} else if u.Host == c.Request.Host {
+		prefix = server.Router.ContextPath
+	} else {
+		return "", false
+	}
+	path := strings.Trim(u.Path, "/")
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			return "", false
+		}
+		path = strings.TrimPrefix(strings.TrimPrefix(path, prefix), "/")
+	}
+	if path == "" {
+		return "", server.Router.Depth == 0 || server.Router.DepthDynamic
+	}
+	return path, server.validRepo(path)
+}
+
+// latestMatchingChartVersion returns the latest version matching a constraint, any version if empty
+func latestMatchingChartVersion(versions helm_repo.ChartVersions, constraint string) *helm_repo.ChartVersion {
+	if constraint == "" {
+		constraint = "*"
+	}
+	matching, err := matchingChartVersions(versions, constraint)
+	if err != nil {
+		return nil
+	}
+	var latest *helm_repo.ChartVersion
+	var latestVersion *semver.Version
+	for _, chartVersion := range matching {
+		version, _ := semver.NewVersion(chartVersion.Version)
+		if latest == nil || version.GreaterThan(latestVersion) {
+			latest, latestVersion = chartVersion, version
+		}
+	}
+	return latest
+}
+
+func foundDependencyNode(repo string, chartVersion *helm_repo.ChartVersion) *dependencyNode {
+	return &dependencyNode{
+		ID:      pathutil.Join(repo, chartVersion.Name, chartVersion.Version),
+		Name:    chartVersion.Name,
+		Version: chartVersion.Version,
+		Repo:    repo,
+		Status:  dependencyStatusFound,
+	}
+}
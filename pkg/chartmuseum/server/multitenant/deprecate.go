@@ -0,0 +1,244 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"encoding/json"
+	"net/http"
+	pathutil "path"
+	"strconv"
+	"sync"
+	"time"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	cm_pkg_storage "helm.sh/chartmuseum/pkg/storage"
+)
+
+const (
+	// chartDeprecationsFilename is the object storing the deprecated chart versions of a repo, next to its charts
+	chartDeprecationsFilename = "chart-deprecations.json"
+)
+
+type (
+	// chartDeprecations are the times chart versions were deprecated at, by chart name and version
+	chartDeprecations map[string]map[string]time.Time
+
+	// deprecatedChartVersion is the result of deprecating a chart version
+	deprecatedChartVersion struct {
+		Name         string    `json:"name"`
+		Version      string    `json:"version"`
+		DeprecatedAt time.Time `json:"deprecated_at"`
+		// PackageRewritten is set when the stored package was repackaged with deprecated set in Chart.yaml
+		PackageRewritten bool `json:"package_rewritten"`
+		// ProvenanceDropped is set when the provenance file of the rewritten package, which no longer
+		// matches it, was deleted
+		ProvenanceDropped bool `json:"provenance_dropped,omitempty"`
+	}
+)
+
+func (server *MultiTenantServer) deprecateChartVersionRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	version, err := chartVersionParam(c, true)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	rewrite, _ := strconv.ParseBool(c.Query("rewrite"))
+	log := server.Logger.ContextLoggingFn(c)
+	result, err := server.deprecateChartVersion(c, log, repo, name, version, rewrite)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+
+	log(cm_logger.InfoLevel, "Chart version deprecated",
+		"repo", repo,
+		"name", name,
+		"version", result.Version,
+		"rewrite", rewrite,
+		"user", c.GetString("user"),
+		"client_ip", c.ClientIP(),
+	)
+	c.JSON(200, result)
+}
+
+// deprecateChartVersion marks a chart version deprecated in the index of repo. The deprecation is
+// saved next to the charts so that it outlives index regenerations, a version uploaded again staying
+// deprecated. With rewrite, the stored package is also repackaged so that installing it warns too
+func (server *MultiTenantServer) deprecateChartVersion(c *gin.Context, log cm_logger.LoggingFn, repo string, name string,
+	version string, rewrite bool) (*deprecatedChartVersion, *HTTPError) {
+	chartVersion, err := server.getChartVersion(log, repo, name, version)
+	if err != nil {
+		return nil, err
+	}
+	filename, err := chartFileName(chartVersion)
+	if err != nil {
+		return nil, err
+	}
+	result := &deprecatedChartVersion{Name: chartVersion.Name, Version: chartVersion.Version}
+
+	var content []byte
+	var provenance bool
+	if rewrite {
+		object, err := server.getStorageObject(log, repo, filename)
+		if err != nil {
+			return nil, err
+		}
+		_, provErr := server.StorageBackend.GetObject(pathutil.Join(repo, filename+".prov"))
+		provenance = provErr == nil
+		if provenance {
+			required, err := server.requireProvenance(log, repo)
+			if err != nil {
+				return nil, err
+			}
+			if required {
+				return nil, &HTTPError{http.StatusConflict, "cannot rewrite " + filename + ", provenance files are required"}
+			}
+		}
+		var rewriteErr error
+		content, rewriteErr = cm_repo.DeprecateChartPackage(object.Content)
+		if rewriteErr != nil {
+			return nil, &HTTPError{http.StatusInternalServerError, rewriteErr.Error()}
+		}
+	}
+
+	deprecatedAt, err := server.addChartDeprecation(repo, chartVersion.Name, chartVersion.Version)
+	if err != nil {
+		return nil, err
+	}
+	result.DeprecatedAt = deprecatedAt
+
+	if !rewrite {
+		// the index entries are shared with the cached index, updated by the event only
+		deprecated := *chartVersion
+		metadata := *chartVersion.Metadata
+		metadata.Deprecated = true
+		deprecated.Metadata = &metadata
+		server.emitEvent(c, repo, updateChart, &deprecated)
+		return result, nil
+	}
+
+	rewrittenObject := cm_storage.Object{
+		Path:         pathutil.Join(repo, filename),
+		Content:      content,
+		LastModified: time.Now(),
+	}
+	rewritten, rewriteErr := cm_repo.ChartVersionFromStorageObject(rewrittenObject)
+	if rewriteErr != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, rewriteErr.Error()}
+	}
+	if err := server.StorageBackend.PutObject(rewrittenObject.Path, content); err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, err.Error()}
+	}
+	server.recordPackageSize(repo, filename, len(content))
+	result.PackageRewritten = true
+	if provenance {
+		if err := server.StorageBackend.DeleteObject(pathutil.Join(repo, filename+".prov")); err != nil {
+			log(cm_logger.WarnLevel, "Failed to delete the provenance file of a rewritten package",
+				"repo", repo,
+				"package", filename,
+				"error", err.Error(),
+			)
+		} else {
+			result.ProvenanceDropped = true
+		}
+	}
+	server.emitEvent(c, repo, updateChart, rewritten)
+	return result, nil
+}
+
+// addChartDeprecation saves a chart version as deprecated in repo, returning the time it was
+// deprecated at, the one of the first deprecation if it already was
+func (server *MultiTenantServer) addChartDeprecation(repo string, name string, version string) (time.Time, *HTTPError) {
+	lock, _ := server.chartDeprecationsLocks.LoadOrStore(repo, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	deprecations, err := server.getChartDeprecations(repo)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if deprecatedAt, found := deprecations[name][version]; found {
+		return deprecatedAt, nil
+	}
+	deprecatedAt := time.Now().UTC()
+	if deprecations[name] == nil {
+		deprecations[name] = map[string]time.Time{}
+	}
+	deprecations[name][version] = deprecatedAt
+	if err := server.saveChartDeprecations(repo, deprecations); err != nil {
+		return time.Time{}, err
+	}
+	return deprecatedAt, nil
+}
+
+// getChartDeprecations reads the deprecated chart versions of a repo from storage
+func (server *MultiTenantServer) getChartDeprecations(repo string) (chartDeprecations, *HTTPError) {
+	deprecations := chartDeprecations{}
+	object, err := server.StorageBackend.GetObject(pathutil.Join(repo, chartDeprecationsFilename))
+	if cm_pkg_storage.IsNotFound(err) {
+		// no chart version deprecated in this repo
+		return deprecations, nil
+	}
+	if err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, "cannot read chart deprecations: " + err.Error()}
+	}
+	if err := json.Unmarshal(object.Content, &deprecations); err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, "invalid chart deprecations: " + err.Error()}
+	}
+	return deprecations, nil
+}
+
+func (server *MultiTenantServer) saveChartDeprecations(repo string, deprecations chartDeprecations) *HTTPError {
+	content, err := json.Marshal(deprecations)
+	if err != nil {
+		return &HTTPError{http.StatusInternalServerError, err.Error()}
+	}
+	if err := server.StorageBackend.PutObject(pathutil.Join(repo, chartDeprecationsFilename), content); err != nil {
+		return &HTTPError{http.StatusInternalServerError, err.Error()}
+	}
+	return nil
+}
+
+// applyChartDeprecations marks the deprecated chart versions of repo in its index, before it is
+// regenerated, as packages only tell the versions deprecated in their Chart.yaml
+func (server *MultiTenantServer) applyChartDeprecations(log cm_logger.LoggingFn, repo string, index *cm_repo.Index) {
+	deprecations, err := server.getChartDeprecations(repo)
+	if err != nil {
+		log(cm_logger.WarnLevel, err.Message,
+			"repo", repo,
+		)
+		return
+	}
+	if len(deprecations) == 0 {
+		return
+	}
+	index.IndexLock.Lock()
+	defer index.IndexLock.Unlock()
+	for name, versions := range deprecations {
+		for _, chartVersion := range index.Entries[name] {
+			if _, found := versions[chartVersion.Version]; found {
+				chartVersion.Deprecated = true
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+)
+
+type (
+	// chartVersionDiff is the difference between two versions of a chart, as served by the API
+	chartVersionDiff struct {
+		Name string `json:"name"`
+		From string `json:"from"`
+		To   string `json:"to"`
+		*cm_repo.ChartDiff
+	}
+)
+
+func (server *MultiTenantServer) getChartVersionDiffRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	from, err := chartVersionQuery(c, "from")
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	to, err := chartVersionQuery(c, "to")
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "unified" {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("invalid format %q, must be json or unified", format)})
+		return
+	}
+
+	log := server.Logger.ContextLoggingFn(c)
+	diff, err := server.chartVersionDiff(log, repo, name, from, to)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	if format == "unified" {
+		c.Data(200, "text/x-diff; charset=utf-8", []byte(diff.Unified()))
+		return
+	}
+	c.JSON(200, diff)
+}
+
+// chartVersionQuery returns the version of a chart set by a query parameter, as chartVersionParam
+func chartVersionQuery(c *gin.Context, key string) (string, *HTTPError) {
+	version := c.Query(key)
+	if version == "" {
+		return "", &HTTPError{http.StatusBadRequest, fmt.Sprintf("missing %s version", key)}
+	}
+	if version == "latest" {
+		return version, nil
+	}
+	if err := cm_repo.ValidateChartVersionConstraint(version); err != nil {
+		return "", &HTTPError{http.StatusBadRequest, fmt.Sprintf("invalid %s version: %s", key, err)}
+	}
+	return version, nil
+}
+
+// chartVersionDiff compares the Chart.yaml, values.yaml and templates of the packages of two
+// versions of a chart
+func (server *MultiTenantServer) chartVersionDiff(log cm_logger.LoggingFn, repo string, name string, from string,
+	to string) (*chartVersionDiff, *HTTPError) {
+	fromVersion, fromContent, err := server.chartVersionPackage(log, repo, name, from)
+	if err != nil {
+		return nil, err
+	}
+	toVersion, toContent, err := server.chartVersionPackage(log, repo, name, to)
+	if err != nil {
+		return nil, err
+	}
+	diff, diffErr := cm_repo.DiffChartPackages(fromContent, toContent)
+	if diffErr != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, diffErr.Error()}
+	}
+	return &chartVersionDiff{Name: name, From: fromVersion, To: toVersion, ChartDiff: diff}, nil
+}
+
+// chartVersionPackage returns the version of a chart, resolved if latest, and the content of its
+// package
+func (server *MultiTenantServer) chartVersionPackage(log cm_logger.LoggingFn, repo string, name string,
+	version string) (string, []byte, *HTTPError) {
+	chartVersion, err := server.getChartVersion(log, repo, name, version)
+	if err != nil {
+		return "", nil, err
+	}
+	filename, err := chartFileName(chartVersion)
+	if err != nil {
+		return "", nil, err
+	}
+	storageObject, err := server.getStorageObject(log, repo, filename)
+	if err != nil {
+		return "", nil, err
+	}
+	return chartVersion.Version, storageObject.Content, nil
+}
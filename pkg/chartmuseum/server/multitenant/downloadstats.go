@@ -0,0 +1,316 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	pathutil "path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+
+	"helm.sh/chartmuseum/pkg/cache"
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+)
+
+const (
+	// downloadStatsFilename is the object storing the download counts of a repo, next to its charts
+	downloadStatsFilename = "download-stats.json"
+	// downloadStatsRedisPrefix prefixes the Redis hashes counting the downloads of a chart by version
+	downloadStatsRedisPrefix = "chartmuseum-downloads:"
+
+	defaultDownloadStatsInterval = time.Minute
+)
+
+type (
+	// downloadCounts are the downloads of the versions of charts, by name and version
+	downloadCounts map[string]map[string]int64
+
+	// downloadStatsStore keeps the download counts of repos
+	downloadStatsStore interface {
+		// add adds counts to the ones of repo
+		add(repo string, counts downloadCounts) error
+		// get returns the counts of the versions of the chart name of repo
+		get(repo string, name string) (map[string]int64, error)
+	}
+
+	// downloadStats counts the downloads of chart packages in memory, and adds them to the store
+	// every interval, so that downloads never wait for the store
+	downloadStats struct {
+		store   downloadStatsStore
+		lock    sync.Mutex
+		pending map[string]downloadCounts
+	}
+
+	// memoryDownloadStatsStore keeps the counts in memory, lost on restart
+	memoryDownloadStatsStore struct {
+		lock   sync.Mutex
+		counts map[string]downloadCounts
+	}
+
+	// storageDownloadStatsStore keeps the counts of each repo in an object next to its charts. The
+	// counts of instances flushing at the same time may be lost, the cache store suits many instances
+	storageDownloadStatsStore struct {
+		backend cm_storage.Backend
+		lock    sync.Mutex
+	}
+
+	// redisDownloadStatsStore keeps the counts of each chart in a Redis hash, incremented atomically
+	// by every instance
+	redisDownloadStatsStore struct {
+		client *redis.Client
+	}
+
+	// chartDownloadStats is the response of GET /api/:repo/charts/:name/stats
+	chartDownloadStats struct {
+		Name      string                 `json:"name"`
+		Downloads int64                  `json:"downloads"`
+		Versions  []versionDownloadStats `json:"versions"`
+	}
+
+	versionDownloadStats struct {
+		Version   string `json:"version"`
+		Downloads int64  `json:"downloads"`
+	}
+)
+
+// newDownloadStats returns the download stats kept in the store named kind, one of memory, storage
+// or cache, or nil if kind is empty
+func newDownloadStats(kind string, backend cm_storage.Backend, externalCacheStore cache.Store) (*downloadStats, error) {
+	var store downloadStatsStore
+	switch kind {
+	case "":
+		return nil, nil
+	case "memory":
+		store = &memoryDownloadStatsStore{counts: map[string]downloadCounts{}}
+	case "storage":
+		store = &storageDownloadStatsStore{backend: backend}
+	case "cache":
+		redisStore, ok := externalCacheStore.(*cache.RedisStore)
+		if !ok {
+			return nil, errors.New("download stats in the cache store require a redis cache store")
+		}
+		store = &redisDownloadStatsStore{client: redisStore.Client}
+	default:
+		return nil, fmt.Errorf("invalid download stats store %q, must be memory, storage or cache", kind)
+	}
+	return &downloadStats{store: store, pending: map[string]downloadCounts{}}, nil
+}
+
+// count counts a download of the package filename of repo
+func (d *downloadStats) count(repo string, filename string) {
+	name, version := cm_repo.GetExactChartNameVersion(strings.TrimSuffix(filename, "."+cm_repo.ChartPackageFileExtension))
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.pending[repo] = d.pending[repo].with(name, version, 1)
+}
+
+// flush adds the downloads counted since the last flush to the store. The downloads of repos whose
+// counts could not be stored are kept for the next flush
+func (d *downloadStats) flush(log cm_logger.LoggingFn) {
+	d.lock.Lock()
+	pending := d.pending
+	d.pending = map[string]downloadCounts{}
+	d.lock.Unlock()
+
+	for repo, counts := range pending {
+		if err := d.store.add(repo, counts); err != nil {
+			log(cm_logger.WarnLevel, "Error storing download stats",
+				"repo", repo,
+				"error", err.Error(),
+			)
+			d.lock.Lock()
+			for name, versions := range counts {
+				for version, downloads := range versions {
+					d.pending[repo] = d.pending[repo].with(name, version, downloads)
+				}
+			}
+			d.lock.Unlock()
+		}
+	}
+}
+
+// get returns the downloads of the versions of the chart name of repo, counting the ones not
+// stored yet
+func (d *downloadStats) get(repo string, name string) (map[string]int64, error) {
+	versions, err := d.store.get(repo, name)
+	if err != nil {
+		return nil, err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for version, downloads := range d.pending[repo][name] {
+		versions[version] += downloads
+	}
+	return versions, nil
+}
+
+// with adds downloads of the version of the chart name to counts, and returns them, allocated if nil
+func (counts downloadCounts) with(name string, version string, downloads int64) downloadCounts {
+	if counts == nil {
+		counts = downloadCounts{}
+	}
+	if counts[name] == nil {
+		counts[name] = map[string]int64{}
+	}
+	counts[name][version] += downloads
+	return counts
+}
+
+func (s *memoryDownloadStatsStore) add(repo string, counts downloadCounts) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for name, versions := range counts {
+		for version, downloads := range versions {
+			s.counts[repo] = s.counts[repo].with(name, version, downloads)
+		}
+	}
+	return nil
+}
+
+func (s *memoryDownloadStatsStore) get(repo string, name string) (map[string]int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	versions := map[string]int64{}
+	for version, downloads := range s.counts[repo][name] {
+		versions[version] = downloads
+	}
+	return versions, nil
+}
+
+func (s *storageDownloadStatsStore) add(repo string, counts downloadCounts) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stored, err := s.read(repo)
+	if err != nil {
+		return err
+	}
+	for name, versions := range counts {
+		for version, downloads := range versions {
+			stored = stored.with(name, version, downloads)
+		}
+	}
+	content, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return s.backend.PutObject(pathutil.Join(repo, downloadStatsFilename), content)
+}
+
+func (s *storageDownloadStatsStore) get(repo string, name string) (map[string]int64, error) {
+	stored, err := s.read(repo)
+	if err != nil {
+		return nil, err
+	}
+	versions := stored[name]
+	if versions == nil {
+		versions = map[string]int64{}
+	}
+	return versions, nil
+}
+
+func (s *storageDownloadStatsStore) read(repo string) (downloadCounts, error) {
+	counts := downloadCounts{}
+	object, err := s.backend.GetObject(pathutil.Join(repo, downloadStatsFilename))
+	if err != nil {
+		// no downloads stored for this repo
+		return counts, nil
+	}
+	if err := json.Unmarshal(object.Content, &counts); err != nil {
+		return nil, fmt.Errorf("invalid download stats: %w", err)
+	}
+	return counts, nil
+}
+
+func (s *redisDownloadStatsStore) add(repo string, counts downloadCounts) error {
+	pipeline := s.client.Pipeline()
+	for name, versions := range counts {
+		for version, downloads := range versions {
+			pipeline.HIncrBy(redisDownloadStatsKey(repo, name), version, downloads)
+		}
+	}
+	_, err := pipeline.Exec()
+	return err
+}
+
+func (s *redisDownloadStatsStore) get(repo string, name string) (map[string]int64, error) {
+	fields, err := s.client.HGetAll(redisDownloadStatsKey(repo, name)).Result()
+	if err != nil {
+		return nil, err
+	}
+	versions := map[string]int64{}
+	for version, downloads := range fields {
+		if versions[version], err = strconv.ParseInt(downloads, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid download stats of %s %s: %w", name, version, err)
+		}
+	}
+	return versions, nil
+}
+
+func redisDownloadStatsKey(repo string, name string) string {
+	return downloadStatsRedisPrefix + pathutil.Join(repo, name)
+}
+
+// countDownload counts a download of filename of repo, if it is a chart package
+func (server *MultiTenantServer) countDownload(repo string, filename string) {
+	if server.downloadStats != nil && strings.HasSuffix(filename, "."+cm_repo.ChartPackageFileExtension) {
+		server.downloadStats.count(repo, filename)
+	}
+}
+
+// initDownloadStatsTimer stores the downloads counted in memory every DownloadStatsInterval
+func (server *MultiTenantServer) initDownloadStatsTimer() {
+	if server.downloadStats == nil {
+		return
+	}
+	go func() {
+		log := server.Logger.ContextLoggingFn(&gin.Context{})
+		t := time.NewTicker(server.DownloadStatsInterval)
+		for range t.C {
+			server.downloadStats.flush(log)
+		}
+	}()
+}
+
+// getChartDownloadStats returns the downloads of the versions of the chart name of repo in the index,
+// the latest first
+func (server *MultiTenantServer) getChartDownloadStats(log cm_logger.LoggingFn, repo string, name string) (*chartDownloadStats, *HTTPError) {
+	chart, err := server.getChart(log, repo, name)
+	if err != nil {
+		return nil, err
+	}
+	counts, countErr := server.downloadStats.get(repo, name)
+	if countErr != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, countErr.Error()}
+	}
+	stats := &chartDownloadStats{Name: name, Versions: make([]versionDownloadStats, 0, len(chart))}
+	for _, chartVersion := range chart {
+		downloads := counts[chartVersion.Version]
+		stats.Downloads += downloads
+		stats.Versions = append(stats.Versions, versionDownloadStats{Version: chartVersion.Version, Downloads: downloads})
+	}
+	return stats, nil
+}
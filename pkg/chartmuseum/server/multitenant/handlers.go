@@ -18,17 +18,27 @@ package multitenant
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	pathutil "path"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	cm_auth "github.com/chartmuseum/auth"
 	cm_storage "github.com/chartmuseum/storage"
 
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
 	cm_repo "helm.sh/chartmuseum/pkg/repo"
 
 	"helm.sh/helm/v3/pkg/chart"
@@ -40,10 +50,18 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	// rollbackRetryDelay is multiplied by the attempt number between retries of a rollback deletion
+	rollbackRetryDelay = 100 * time.Millisecond
+	// apiVersion is the version of the API reported by /info, changed on breaking changes only
+	apiVersion = "v1"
+)
+
 var (
 	objectSavedResponse   = gin.H{"saved": true}
 	objectDeletedResponse = gin.H{"deleted": true}
 	healthCheckResponse   = gin.H{"healthy": true}
+	processStartTime      = time.Now()
 	welcomePageHTML       = []byte(`<!DOCTYPE html>
 <html>
 <head>
@@ -82,6 +100,7 @@ type (
 		filename string
 		content  []byte
 		field    string // file was extracted from this form field
+		exists   bool   // file was already in storage before the upload
 	}
 	filenameFromContentFn func([]byte) (string, error)
 )
@@ -108,18 +127,313 @@ func (server *MultiTenantServer) getStaticFilesHandler(c *gin.Context) {
 	}
 }
 
+// getInfoHandler reports the version of the server with the features enabled, for clients to adapt
+// to its configuration
 func (server *MultiTenantServer) getInfoHandler(c *gin.Context) {
-	versionResponse := gin.H{"version": server.Version}
-	c.JSON(200, versionResponse)
+	c.JSON(200, gin.H{
+		"version":     server.Version,
+		"api_version": apiVersion,
+		"features":    server.features(),
+	})
+}
+
+// features are the features of the server which clients may depend on
+func (server *MultiTenantServer) features() gin.H {
+	return gin.H{
+		"multitenant":             server.Router.Depth > 0 || server.Router.DepthDynamic,
+		"depth":                   server.Router.Depth,
+		"depth_dynamic":           server.Router.DepthDynamic,
+		"api":                     server.APIEnabled,
+		"overwrite":               server.AllowOverwrite,
+		"force_overwrite":         server.AllowForceOverwrite,
+		"delete":                  server.APIEnabled && !server.DisableDelete,
+		"multi_chart_upload":      server.MultiChartUpload,
+		"upload_by_url":           server.APIEnabled && len(server.UploadURLAllowedHosts) > 0,
+		"download_stats":          server.downloadStats != nil,
+		"provenance_verification": server.ProvenanceKeyring != "",
+		"require_provenance":      server.RequireProvenance,
+		"storage":                 server.StorageBackendName,
+		"auth": gin.H{
+			"methods":       server.Router.AuthMethods(),
+			"anonymous_get": server.Router.AnonymousGet(),
+		},
+	}
 }
 
 func (server *MultiTenantServer) getHealthCheckHandler(c *gin.Context) {
-	c.JSON(200, healthCheckResponse)
+	if !server.HealthDetails {
+		c.JSON(200, healthCheckResponse)
+		return
+	}
+	c.JSON(200, gin.H{
+		"healthy":        true,
+		"version":        server.Version,
+		"uptime_seconds": int64(time.Since(processStartTime).Seconds()),
+		"started_at":     processStartTime.UTC().Format(time.RFC3339),
+	})
+}
+
+func (server *MultiTenantServer) flushCacheRequestHandler(c *gin.Context) {
+	log := server.Logger.ContextLoggingFn(c)
+	var repos []string
+	if repo, ok := c.GetQuery("repo"); ok {
+		repos = []string{repo}
+	}
+	flushed := server.flushCache(log, repos)
+	c.JSON(200, gin.H{"flushed": flushed})
+}
+
+func (server *MultiTenantServer) regenerateIndexRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	log := server.Logger.ContextLoggingFn(c)
+	index, err := server.regenerateIndex(log, repo)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Could not regenerate index",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	versions := 0
+	for _, chartVersions := range index.Entries {
+		versions += len(chartVersions)
+	}
+	c.JSON(200, gin.H{"regenerated": true, "charts": len(index.Entries), "versions": versions})
+}
+
+func (server *MultiTenantServer) getTenantSettingsRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	settings, err := server.getTenantSettings(repo)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	c.JSON(200, settings)
+}
+
+func (server *MultiTenantServer) putTenantSettingsRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	log := server.Logger.ContextLoggingFn(c)
+	settings := &tenantSettings{}
+	if bindErr := c.ShouldBindJSON(settings); bindErr != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("invalid settings: %s", bindErr)})
+		return
+	}
+	previous, err := server.getTenantSettings(repo)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	if err := server.saveTenantSettings(repo, settings); err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+
+	cm_router.AuditChanges(c, gin.H{"previous_settings": previous, "settings": settings})
+	log(cm_logger.InfoLevel, "Tenant settings changed",
+		"repo", repo,
+		"previous", previous,
+		"settings", settings,
+		"user", c.GetString("user"),
+		"client_ip", c.ClientIP(),
+	)
+	c.JSON(200, settings)
+}
+
+func (server *MultiTenantServer) createAPIKeyRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	log := server.Logger.ContextLoggingFn(c)
+	req := createAPIKeyRequest{}
+	if bindErr := c.ShouldBindJSON(&req); bindErr != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("invalid API key request: %s", bindErr)})
+		return
+	}
+	key, err := server.createAPIKey(repo, c.GetString("user"), req)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+
+	log(cm_logger.InfoLevel, "API key created",
+		"repo", repo,
+		"id", key.ID,
+		"name", key.Name,
+		"actions", key.Actions,
+		"user", key.User,
+		"client_ip", c.ClientIP(),
+	)
+	c.JSON(201, key)
+}
+
+func (server *MultiTenantServer) createPullTokenRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	log := server.Logger.ContextLoggingFn(c)
+	req := createPullTokenRequest{}
+	// the body is optional
+	if bindErr := c.ShouldBindJSON(&req); bindErr != nil && !errors.Is(bindErr, io.EOF) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("invalid pull token request: %s", bindErr)})
+		return
+	}
+	token, err := server.createPullToken(repo, c.GetString("user"), req)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+
+	log(cm_logger.InfoLevel, "Pull token created",
+		"repo", repo,
+		"name", req.Name,
+		"expires", token.Expires,
+		"user", token.User,
+		"client_ip", c.ClientIP(),
+	)
+	c.JSON(201, token)
+}
+
+func (server *MultiTenantServer) listAPIKeysRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	keys, err := server.listAPIKeys(repo)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	c.JSON(200, gin.H{"keys": keys})
+}
+
+func (server *MultiTenantServer) revokeAPIKeyRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	log := server.Logger.ContextLoggingFn(c)
+	key, err := server.revokeAPIKey(repo, c.Param("id"))
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+
+	log(cm_logger.InfoLevel, "API key revoked",
+		"repo", repo,
+		"id", key.ID,
+		"name", key.Name,
+		"user", c.GetString("user"),
+		"client_ip", c.ClientIP(),
+	)
+	c.JSON(200, gin.H{"revoked": true})
+}
+
+func (server *MultiTenantServer) renameChartRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	log := server.Logger.ContextLoggingFn(c)
+	req := renameChartRequest{}
+	if bindErr := c.ShouldBindJSON(&req); bindErr != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("invalid rename request: %s", bindErr)})
+		return
+	}
+	results, err := server.renameChart(c, repo, name, req)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+
+	log(cm_logger.InfoLevel, "Chart renamed",
+		"repo", repo,
+		"name", name,
+		"to", req.To,
+		"dry_run", req.DryRun,
+		"delete_originals", req.DeleteOriginals,
+		"user", c.GetString("user"),
+		"client_ip", c.ClientIP(),
+	)
+	c.JSON(200, gin.H{"name": name, "to": req.To, "dry_run": req.DryRun, "versions": results})
+}
+
+func (server *MultiTenantServer) promoteChartVersionRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	version, err := chartVersionParam(c, true)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	to := c.Query("to")
+	if !server.validRepo(to) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("invalid destination repo %q", to)})
+		return
+	}
+	if to == repo {
+		c.JSON(400, gin.H{"error": "chart version already in " + repo})
+		return
+	}
+	deleteSource, _ := strconv.ParseBool(c.Query("delete_source"))
+	if deleteSource && server.DisableDelete {
+		c.JSON(403, gin.H{"error": "deleting charts is disabled"})
+		return
+	}
+	// the route only authorizes pushing to the source
+	if !server.Router.AuthorizeRepo(c, cm_auth.PullAction, repo) {
+		return
+	}
+	if !server.Router.AuthorizeRepo(c, cm_auth.PushAction, to) {
+		return
+	}
+	if deleteSource && !server.Router.AuthorizeRepo(c, cm_router.DeleteAction, repo) {
+		return
+	}
+	_, force := c.GetQuery("force")
+	log := server.Logger.ContextLoggingFn(c)
+	promotion, err := server.promoteChartVersion(c, log, repo, name, version, to, deleteSource, force)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+
+	log(cm_logger.InfoLevel, "Chart version promoted",
+		"repo", repo,
+		"name", name,
+		"version", promotion.Version,
+		"to", to,
+		"delete_source", deleteSource,
+		"user", c.GetString("user"),
+		"client_ip", c.ClientIP(),
+	)
+	c.JSON(201, promotion)
+}
+
+func (server *MultiTenantServer) getStatsRequestHandler(c *gin.Context) {
+	c.JSON(200, server.stats())
 }
 
 func (server *MultiTenantServer) getIndexFileRequestHandler(c *gin.Context) {
+	server.serveIndex(c, indexFormat{})
+}
+
+func (server *MultiTenantServer) headIndexFileRequestHandler(c *gin.Context) {
+	// the headers of the index a GET would serve, without its body
+	server.getIndexFileRequestHandler(c)
+}
+
+func (server *MultiTenantServer) getIndexJSONRequestHandler(c *gin.Context) {
+	fields, err := indexJSONFields(c.Query("fields"))
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	server.serveIndex(c, indexFormat{json: true, fields: fields})
+}
+
+func (server *MultiTenantServer) headIndexJSONRequestHandler(c *gin.Context) {
+	server.getIndexJSONRequestHandler(c)
+}
+
+// serveIndex serves the index of the repo of a request in format
+func (server *MultiTenantServer) serveIndex(c *gin.Context, format indexFormat) {
 	repo := c.Param("repo")
 	log := server.Logger.ContextLoggingFn(c)
+	if server.indexDebounce(repo) > 0 && strings.Contains(c.GetHeader("Cache-Control"), "no-cache") {
+		// forced fetch, do not wait for the debounce window
+		server.flushPendingEvents(log, repo)
+	}
+	server.reconcileIndexIfDue(log, repo)
 	indexFile, err := server.getIndexFile(log, repo)
 	if err != nil {
 		c.JSON(err.Status, gin.H{"error": err.Message})
@@ -127,11 +441,32 @@ func (server *MultiTenantServer) getIndexFileRequestHandler(c *gin.Context) {
 	}
 	indexFile.IndexLock.RLock()
 	defer indexFile.IndexLock.RUnlock()
-	c.Data(200, indexFileContentType, indexFile.Raw)
-}
-
-func (server *MultiTenantServer) headIndexFileRequestHandler(c *gin.Context) {
-	c.Status(200)
+	served, digest, generated := indexFile.IndexFile, indexFile.Digest, indexFile.Generated
+	if _, ok := server.UpstreamURLs[repo]; ok {
+		// the upstream index may change while this one does not, so only its content tags it
+		served, digest, generated = server.mergeUpstreamIndex(log, repo, indexFile), "", time.Time{}
+	}
+	raw, err := server.serializedIndex(log, repo, indexFile, served, format, digest, generated)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	if server.MaxIndexSize > 0 && len(raw) > server.MaxIndexSize {
+		// upstream charts are left out of a truncated index
+		raw, err = server.limitIndexSize(log, repo, len(raw), func() ([]byte, error) {
+			if format.json {
+				return indexFile.IndexFile.TruncatedJSON(server.MaxIndexSize, format.fields)
+			}
+			return indexFile.IndexFile.Truncated(server.MaxIndexSize, indexFile.OutputJSON)
+		})
+		if err != nil {
+			c.JSON(err.Status, gin.H{"error": err.Message})
+			return
+		}
+		digest = ""
+		c.Header("X-Index-Truncated", "true")
+	}
+	server.writeIndexResponse(c, repo, format, raw, digest, generated)
 }
 
 func (server *MultiTenantServer) getArtifactHubFileRequestHandler(c *gin.Context) {
@@ -150,17 +485,104 @@ func (server *MultiTenantServer) getStorageObjectRequestHandler(c *gin.Context)
 	repo := c.Param("repo")
 	filename := c.Param("filename")
 	log := server.Logger.ContextLoggingFn(c)
-	storageObject, err := server.getStorageObject(log, repo, filename)
+	if signedURL, ok := server.presignStorageObject(log, repo, filename); ok {
+		server.countDownload(repo, filename)
+		c.Redirect(http.StatusFound, signedURL)
+		return
+	}
+	var err *HTTPError
+	if r, ok := server.requestedByteRange(c, log, repo, filename); ok {
+		err = server.writeStorageObjectRange(c, log, repo, filename, r)
+	} else {
+		err = server.writeStorageObject(c, log, repo, filename)
+	}
+	if err == nil {
+		return
+	}
+	if err.Status == http.StatusNotFound {
+		if _, ok := server.UpstreamURLs[repo]; ok {
+			var storageObject *StorageObject
+			storageObject, err = server.getUpstreamStorageObject(c, repo, filename)
+			if err == nil {
+				server.countDownload(repo, filename)
+				c.Data(200, storageObject.ContentType, storageObject.Content)
+				return
+			}
+		} else if strings.HasSuffix(filename, cm_repo.ChartPackageFileExtension) {
+			server.reconcileIndexForMissingObject(log, repo, filename)
+		}
+	}
+	c.JSON(err.Status, gin.H{"error": err.Message})
+}
+func (server *MultiTenantServer) headStorageObjectRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	filename := c.Param("filename")
+	log := server.Logger.ContextLoggingFn(c)
+	reader, size, contentType, err := server.getStorageObjectStream(log, repo, filename)
+	if err != nil {
+		c.Status(err.Status)
+		return
+	}
+	reader.Close()
+	if strings.HasSuffix(filename, cm_repo.ChartPackageFileExtension) {
+		if chartVersion := server.chartVersionOfPackage(log, repo, filename); chartVersion != nil {
+			setChartVersionHeaders(c, chartVersion)
+			if digest, decodeErr := hex.DecodeString(chartVersion.Digest); decodeErr == nil {
+				c.Header("Digest", "sha-256="+base64.StdEncoding.EncodeToString(digest))
+			}
+		}
+	}
+	c.Header("Content-Type", contentType)
+	if size >= 0 {
+		c.Header("Content-Length", strconv.FormatInt(size, 10))
+	}
+	c.Header("Accept-Ranges", "bytes")
+	c.Status(200)
+}
+
+// setChartVersionHeaders tags a response about a chart version with the digest of its package, and
+// the time it was stored
+func setChartVersionHeaders(c *gin.Context, chartVersion *helm_repo.ChartVersion) {
+	if chartVersion.Digest != "" {
+		c.Header("ETag", fmt.Sprintf("%q", chartVersion.Digest))
+	}
+	if !chartVersion.Created.IsZero() {
+		c.Header("Last-Modified", chartVersion.Created.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (server *MultiTenantServer) getChartVersionsRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	log := server.Logger.ContextLoggingFn(c)
+	chart, err := server.getChart(log, repo, name)
 	if err != nil {
 		c.JSON(err.Status, gin.H{"error": err.Message})
 		return
 	}
-	c.Data(200, storageObject.ContentType, storageObject.Content)
+	c.JSON(200, sortedChartVersions(name, chart))
 }
+
+func (server *MultiTenantServer) getChartDownloadStatsRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	log := server.Logger.ContextLoggingFn(c)
+	stats, err := server.getChartDownloadStats(log, repo, name)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	c.JSON(200, stats)
+}
+
 func (server *MultiTenantServer) getStorageObjectTemplateRequestHandler(c *gin.Context) {
 	repo := c.Param("repo")
 	name := c.Param("name")
-	version := c.Param("version")
+	version, err := chartVersionParam(c, true)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
 
 	log := server.Logger.ContextLoggingFn(c)
 
@@ -189,49 +611,90 @@ func (server *MultiTenantServer) getStorageObjectTemplateRequestHandler(c *gin.C
 func (server *MultiTenantServer) getStorageObjectValuesRequestHandler(c *gin.Context) {
 	repo := c.Param("repo")
 	name := c.Param("name")
-	version := c.Param("version")
+	version, err := chartVersionParam(c, true)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
 
 	log := server.Logger.ContextLoggingFn(c)
-
-	fileName, err := server.getChartFileName(log, repo, name, version)
+	values, digest, err := server.getChartValues(log, repo, name, version)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Message})
+		c.JSON(err.Status, gin.H{"error": err.Message})
 		return
 	}
+	serveChartFile(c, "application/yaml", values, digest)
+}
 
-	storageObject, err := server.getStorageObject(log, repo, fileName)
+func (server *MultiTenantServer) getStorageObjectReadmeRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	version, err := chartVersionParam(c, true)
 	if err != nil {
 		c.JSON(err.Status, gin.H{"error": err.Message})
 		return
 	}
-	chrt, err1 := loader.LoadArchive(bytes.NewReader(storageObject.Content))
-	if err1 != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err1})
+	format := c.DefaultQuery("format", "markdown")
+	if format != "markdown" && format != "html" {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("invalid format %q, must be markdown or html", format)})
 		return
 	}
-	var data []byte
-	for _, file := range chrt.Raw {
-		if file.Name == "values.yaml" {
-			data = file.Data
-			break
-		}
-	}
-	if data == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "values.yaml not found"})
+
+	log := server.Logger.ContextLoggingFn(c)
+	readme, digest, err := server.getChartReadme(log, repo, name, version, format == "html")
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
 		return
 	}
-	c.Data(200, "application/yaml", data)
+	if format == "html" {
+		serveChartFile(c, "text/html; charset=utf-8", readme, digest)
+	} else {
+		serveChartFile(c, "text/markdown; charset=utf-8", readme, digest)
+	}
 }
-func (server *MultiTenantServer) getAllChartsRequestHandler(c *gin.Context) {
+
+func (server *MultiTenantServer) getStorageObjectMetadataRequestHandler(c *gin.Context) {
 	repo := c.Param("repo")
+	name := c.Param("name")
+	version, err := chartVersionParam(c, true)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+
+	log := server.Logger.ContextLoggingFn(c)
+	metadata, digest, err := server.getChartMetadata(log, repo, name, version)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	serveChartFile(c, "application/json; charset=utf-8", metadata, digest)
+}
+
+// serveChartFile responds with a file read from a chart package, tagged with the digest of the
+// package when the index has it, or 304 if the client holds it already
+func serveChartFile(c *gin.Context, contentType string, content []byte, digest string) {
+	if digest != "" {
+		etag := fmt.Sprintf("%q", digest)
+		c.Header("ETag", etag)
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+	c.Data(200, contentType, content)
+}
+
+// pagination returns the offset and limit query params of a request listing charts, the limit being -1
+// if not set
+func pagination(c *gin.Context) (int, int, *HTTPError) {
 	offset := 0
 	offsetString, offsetExists := c.GetQuery("offset")
 	if offsetExists {
 		var convErr error
 		offset, convErr = strconv.Atoi(offsetString)
 		if convErr != nil || offset < 0 {
-			c.JSON(400, gin.H{"error": "offset is not a valid non-negative integer"})
-			return
+			return 0, 0, &HTTPError{http.StatusBadRequest, "offset is not a valid non-negative integer"}
 		}
 	}
 
@@ -241,25 +704,89 @@ func (server *MultiTenantServer) getAllChartsRequestHandler(c *gin.Context) {
 		var convErr error
 		limit, convErr = strconv.Atoi(limitString)
 		if convErr != nil || limit <= 0 {
-			c.JSON(400, gin.H{"error": "limit is not a valid positive integer"})
-			return
+			return 0, 0, &HTTPError{http.StatusBadRequest, "limit is not a valid positive integer"}
 		}
 	}
+	return offset, limit, nil
+}
+
+func (server *MultiTenantServer) getAllChartsRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	offset, limit, err := pagination(c)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
 
 	log := server.Logger.ContextLoggingFn(c)
-	allCharts, err := server.getAllCharts(log, repo, offset, limit)
+	// a JSON object can't keep an order, so sorted charts are returned as a list
+	if sortBy, sorted := c.GetQuery("sort"); sorted {
+		charts, total, err := server.listCharts(log, repo, sortBy, offset, limit)
+		if err != nil {
+			c.JSON(err.Status, gin.H{"error": err.Message})
+			return
+		}
+		setPaginationHeaders(c, total, offset, limit)
+		c.JSON(200, charts)
+		return
+	}
+	allCharts, total, err := server.getAllCharts(log, repo, offset, limit)
 	if err != nil {
 		c.JSON(err.Status, gin.H{"error": err.Message})
 		return
 	}
+	setPaginationHeaders(c, total, offset, limit)
 	c.JSON(200, allCharts)
 }
 
+// setPaginationHeaders sets the total number of charts, and the link to the next page if any
+func setPaginationHeaders(c *gin.Context, total int, offset int, limit int) {
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	if limit == -1 || offset+limit >= total {
+		return
+	}
+	next := *c.Request.URL
+	query := next.Query()
+	query.Set("offset", strconv.Itoa(offset+limit))
+	next.RawQuery = query.Encode()
+	c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+}
+
+func (server *MultiTenantServer) searchChartsRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	offset, limit, err := pagination(c)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	// ?regex and ?regex=true match the query as a regexp
+	regexString, regex := c.GetQuery("regex")
+	if regex && regexString != "" {
+		regex, _ = strconv.ParseBool(regexString)
+	}
+	query, err := newChartQuery(c.Query("q"), regex)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+
+	log := server.Logger.ContextLoggingFn(c)
+	results, err := server.searchCharts(log, repo, query, offset, limit)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	c.JSON(200, results)
+}
+
 func (server *MultiTenantServer) getChartRequestHandler(c *gin.Context) {
 	repo := c.Param("repo")
 	name := c.Param("name")
 	log := server.Logger.ContextLoggingFn(c)
 	chart, err := server.getChart(log, repo, name)
+	if constraint, found := c.GetQuery("constraint"); found && err == nil {
+		chart, err = matchingChartVersions(chart, constraint)
+	}
 	if err != nil {
 		c.JSON(err.Status, gin.H{"error": err.Message})
 		return
@@ -271,7 +798,10 @@ func (server *MultiTenantServer) headChartRequestHandler(c *gin.Context) {
 	repo := c.Param("repo")
 	name := c.Param("name")
 	log := server.Logger.ContextLoggingFn(c)
-	_, err := server.getChart(log, repo, name)
+	chart, err := server.getChart(log, repo, name)
+	if constraint, found := c.GetQuery("constraint"); found && err == nil {
+		_, err = matchingChartVersions(chart, constraint)
+	}
 	if err != nil {
 		c.Status(err.Status)
 		return
@@ -282,7 +812,11 @@ func (server *MultiTenantServer) headChartRequestHandler(c *gin.Context) {
 func (server *MultiTenantServer) getChartVersionRequestHandler(c *gin.Context) {
 	repo := c.Param("repo")
 	name := c.Param("name")
-	version := c.Param("version")
+	version, err := chartVersionParam(c, true)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
 	log := server.Logger.ContextLoggingFn(c)
 	chartVersion, err := server.getChartVersion(log, repo, name, version)
 	if err != nil {
@@ -295,22 +829,58 @@ func (server *MultiTenantServer) getChartVersionRequestHandler(c *gin.Context) {
 func (server *MultiTenantServer) headChartVersionRequestHandler(c *gin.Context) {
 	repo := c.Param("repo")
 	name := c.Param("name")
-	version := c.Param("version")
+	version, err := chartVersionParam(c, true)
+	if err != nil {
+		c.Status(err.Status)
+		return
+	}
 	log := server.Logger.ContextLoggingFn(c)
-	_, err := server.getChartVersion(log, repo, name, version)
+	chartVersion, err := server.getChartVersion(log, repo, name, version)
 	if err != nil {
 		c.Status(err.Status)
 		return
 	}
-	c.Status(200)
+	// the length of the chart version a GET would serve
+	content, marshalErr := json.Marshal(chartVersion)
+	if marshalErr != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	setChartVersionHeaders(c, chartVersion)
+	writeData(c, "application/json; charset=utf-8", content)
+}
+
+func (server *MultiTenantServer) verifyChartVersionRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	version, err := chartVersionParam(c, true)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	log := server.Logger.ContextLoggingFn(c)
+	result, err := server.verifyChartVersion(log, repo, name, version)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	c.JSON(200, result)
 }
 
 func (server *MultiTenantServer) deleteChartVersionRequestHandler(c *gin.Context) {
 	repo := c.Param("repo")
 	name := c.Param("name")
-	version := c.Param("version")
+	version, err := chartVersionParam(c, false)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
 	log := server.Logger.ContextLoggingFn(c)
-	err := server.deleteChartVersion(log, repo, name, version)
+	if chartVersion := server.indexedChartVersion(log, repo, name, version); chartVersion != nil {
+		// the version as stored, which may be written differently (v1.2.3 for 1.2.3)
+		version = chartVersion.Version
+	}
+	err = server.deleteChartVersion(log, repo, name, version)
 	if err != nil {
 		c.JSON(err.Status, gin.H{"error": err.Message})
 		return
@@ -327,16 +897,91 @@ func (server *MultiTenantServer) deleteChartVersionRequestHandler(c *gin.Context
 	c.JSON(200, objectDeletedResponse)
 }
 
+func (server *MultiTenantServer) deleteChartRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersions, err := server.getChart(log, repo, name)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+
+	deleted := []string{}
+	failed := map[string]string{}
+	for _, chartVersion := range chartVersions {
+		if err := server.deleteChartVersion(log, repo, name, chartVersion.Version); err != nil {
+			failed[chartVersion.Version] = err.Message
+			continue
+		}
+		deleted = append(deleted, chartVersion.Version)
+		server.emitEvent(c, repo, deleteChart, &helm_repo.ChartVersion{
+			Metadata: &chart.Metadata{
+				Name:    name,
+				Version: chartVersion.Version,
+			},
+		})
+	}
+	if len(failed) > 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    fmt.Sprintf("failed to delete %d of %d versions of %s", len(failed), len(chartVersions), name),
+			"versions": deleted,
+			"failed":   failed,
+		})
+		return
+	}
+	c.JSON(200, gin.H{"deleted": true, "versions": deleted})
+}
+
+func (server *MultiTenantServer) deleteChartByDigestRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	digest := c.Query("digest")
+	if digest == "" {
+		c.JSON(400, gin.H{"error": "the digest query param is required"})
+		return
+	}
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersions, err := server.chartVersionsWithDigest(log, repo, digest)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+
+	deleted := []gin.H{}
+	for _, chartVersion := range chartVersions {
+		if err := server.deleteChartVersion(log, repo, chartVersion.Name, chartVersion.Version); err != nil {
+			c.JSON(err.Status, gin.H{
+				"error":  fmt.Sprintf("failed to delete %s %s: %s", chartVersion.Name, chartVersion.Version, err.Message),
+				"charts": deleted,
+			})
+			return
+		}
+		deleted = append(deleted, gin.H{"name": chartVersion.Name, "version": chartVersion.Version})
+		server.emitEvent(c, repo, deleteChart, &helm_repo.ChartVersion{
+			Metadata: &chart.Metadata{
+				Name:    chartVersion.Name,
+				Version: chartVersion.Version,
+			},
+		})
+	}
+	c.JSON(200, gin.H{"deleted": true, "charts": deleted})
+}
+
 func (server *MultiTenantServer) postRequestHandler(c *gin.Context) {
-	if c.ContentType() == "multipart/form-data" {
+	atomic.AddInt64(&uploadsInFlight, 1)
+	defer atomic.AddInt64(&uploadsInFlight, -1)
+
+	switch c.ContentType() {
+	case "multipart/form-data":
 		server.postPackageAndProvenanceRequestHandler(c) // new route handling form-based chart and/or prov files
-	} else {
+	case "application/json":
+		server.postPackageURLRequestHandler(c) // chart package fetched from a URL
+	default:
 		server.postPackageRequestHandler(c) // classic binary data, chart package only route
 	}
 }
 
 func (server *MultiTenantServer) postPackageRequestHandler(c *gin.Context) {
-	repo := c.Param("repo")
 	content, getContentErr := c.GetRawData()
 	if getContentErr != nil {
 		if len(c.Errors) > 0 {
@@ -345,6 +990,12 @@ func (server *MultiTenantServer) postPackageRequestHandler(c *gin.Context) {
 		c.JSON(500, gin.H{"error": fmt.Sprintf("%s", getContentErr)})
 		return
 	}
+	server.savePackage(c, content)
+}
+
+// savePackage stores the chart package of an upload, and responds
+func (server *MultiTenantServer) savePackage(c *gin.Context, content []byte) {
+	repo := c.Param("repo")
 	log := server.Logger.ContextLoggingFn(c)
 	_, force := c.GetQuery("force")
 	action := addChart
@@ -371,6 +1022,8 @@ func (server *MultiTenantServer) postPackageRequestHandler(c *gin.Context) {
 		LastModified: time.Now()})
 	if chartErr != nil {
 		log(cm_logger.ErrorLevel, "cannot get chart from content", zap.Error(chartErr), zap.Binary("content", content))
+	} else {
+		cm_router.AuditChart(c, chart.Name, chart.Version)
 	}
 	server.emitEvent(c, repo, action, chart)
 
@@ -379,6 +1032,9 @@ func (server *MultiTenantServer) postPackageRequestHandler(c *gin.Context) {
 
 // TODO: whether need update cache
 func (server *MultiTenantServer) postProvenanceFileRequestHandler(c *gin.Context) {
+	atomic.AddInt64(&uploadsInFlight, 1)
+	defer atomic.AddInt64(&uploadsInFlight, -1)
+
 	repo := c.Param("repo")
 	content, getContentErr := c.GetRawData()
 	if getContentErr != nil {
@@ -399,22 +1055,32 @@ func (server *MultiTenantServer) postProvenanceFileRequestHandler(c *gin.Context
 }
 
 func (server *MultiTenantServer) postPackageAndProvenanceRequestHandler(c *gin.Context) {
+	if batch, _ := strconv.ParseBool(c.Query("batch")); batch {
+		server.postBatchRequestHandler(c)
+		return
+	}
 	log := server.Logger.ContextLoggingFn(&gin.Context{})
 	repo := c.Param("repo")
 	_, force := c.GetQuery("force")
-	var chartContent []byte
-	var path string
 	// action used to determine what operation to emit
 	action := addChart
 	cpFiles, status, err := server.getChartAndProvFiles(c.Request, repo, force)
 	if err != nil {
+		if len(c.Errors) > 0 {
+			return // this is a "request too large", already answered with a 413
+		}
 		c.JSON(status, gin.H{"error": fmt.Sprintf("%s", err)})
 		return
 	}
 	switch status {
 	case http.StatusOK:
 	case http.StatusConflict:
-		if !server.AllowOverwrite && (!server.AllowForceOverwrite || !force) {
+		allowOverwrite, err := server.allowOverwrite(log, repo)
+		if err != nil {
+			c.JSON(err.Status, gin.H{"error": err.Message})
+			return
+		}
+		if !allowOverwrite && (!server.AllowForceOverwrite || !force) {
 			c.JSON(status, gin.H{"error": fmt.Sprintf("%s", fmt.Errorf("chart already exists"))}) // conflict
 			return
 		}
@@ -437,6 +1103,24 @@ func (server *MultiTenantServer) postPackageAndProvenanceRequestHandler(c *gin.C
 		return
 	}
 
+	for _, ppf := range cpFiles {
+		if !strings.HasSuffix(ppf.filename, "."+cm_repo.ChartPackageFileExtension) {
+			continue
+		}
+		if err := server.checkChartNameCase(log, repo, ppf.content); err != nil {
+			c.JSON(err.Status, gin.H{"error": err.Message})
+			return
+		}
+		var provContent []byte
+		if prov, ok := cpFiles[ppf.filename+".prov"]; ok {
+			provContent = prov.content
+		}
+		if err := server.checkChartProvenance(log, repo, ppf.filename, ppf.content, provContent); err != nil {
+			c.JSON(err.Status, gin.H{"error": err.Message})
+			return
+		}
+	}
+
 	// At this point input is presumed valid, we now proceed to store it
 	// Undo transaction if there is an error
 	var storedFiles []*chartOrProvenanceFile
@@ -448,32 +1132,97 @@ func (server *MultiTenantServer) postPackageAndProvenanceRequestHandler(c *gin.C
 		err := server.StorageBackend.PutObject(pathutil.Join(repo, ppf.filename), ppf.content)
 		if err == nil {
 			storedFiles = append(storedFiles, ppf)
+			server.recordPackageSize(repo, ppf.filename, len(ppf.content))
 		} else {
 			// Clean up what's already been saved
-			for _, ppf := range storedFiles {
-				server.StorageBackend.DeleteObject(ppf.filename)
+			orphaned := server.rollbackStoredFiles(log, repo, storedFiles)
+			if len(orphaned) > 0 {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("%s", err), "orphaned": orphaned})
+				return
 			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("%s", err)})
 			return
 		}
-		if ppf.field == defaultFormField {
-			// find the content of chart
-			chartContent = ppf.content
-			path = pathutil.Join(repo, ppf.filename)
+	}
+
+	for _, ppf := range storedFiles {
+		if ppf.field != defaultFormField && ppf.field != server.ChartPostFormFieldName {
+			continue
+		}
+		chart, chartErr := cm_repo.ChartVersionFromStorageObject(cm_storage.Object{
+			Path:         pathutil.Join(repo, ppf.filename),
+			Content:      ppf.content,
+			LastModified: time.Now()})
+		if chartErr != nil {
+			log(cm_logger.ErrorLevel, "cannot get chart from content", zap.Error(chartErr), zap.Binary("content", ppf.content))
+			continue
+		}
+		// with several charts uploaded, only the ones already stored are updates
+		chartAction := action
+		if action == updateChart && !ppf.exists {
+			chartAction = addChart
 		}
+		cm_router.AuditChart(c, chart.Name, chart.Version)
+		server.emitEvent(c, repo, chartAction, chart)
 	}
 
-	chart, chartErr := cm_repo.ChartVersionFromStorageObject(cm_storage.Object{
-		Path:         path,
-		Content:      chartContent,
-		LastModified: time.Now()})
-	if chartErr != nil {
-		log(cm_logger.ErrorLevel, "cannot get chart from content", zap.Error(err), zap.Binary("content", chartContent))
+	if server.LegacyUploadResponse {
+		c.JSON(http.StatusCreated, objectSavedResponse)
+		return
 	}
+	c.JSON(http.StatusCreated, savedFilesResponse(storedFiles))
+}
 
-	server.emitEvent(c, repo, action, chart)
+// rollbackStoredFiles deletes the files of a failed upload, retrying each deletion.
+// It returns the files which could not be deleted and need to be cleaned up manually
+func (server *MultiTenantServer) rollbackStoredFiles(log cm_logger.LoggingFn, repo string, storedFiles []*chartOrProvenanceFile) []string {
+	var orphaned []string
+	for _, ppf := range storedFiles {
+		objectPath := pathutil.Join(repo, ppf.filename)
+		err := server.StorageBackend.DeleteObject(objectPath)
+		for attempt := 1; err != nil && attempt <= server.RollbackRetries; attempt++ {
+			time.Sleep(time.Duration(attempt) * rollbackRetryDelay)
+			err = server.StorageBackend.DeleteObject(objectPath)
+		}
+		if err != nil {
+			log(cm_logger.ErrorLevel, "failed to roll back upload, object left orphaned in storage",
+				"repo", repo,
+				"filename", ppf.filename,
+				"error", err.Error(),
+			)
+			orphaned = append(orphaned, ppf.filename)
+		}
+	}
+	sort.Strings(orphaned)
+	return orphaned
+}
 
-	c.JSON(http.StatusCreated, objectSavedResponse)
+// savedFilesResponse lists the stored filenames so clients can confirm
+// that every uploaded file (e.g. the provenance file) actually landed
+func savedFilesResponse(storedFiles []*chartOrProvenanceFile) gin.H {
+	filenames := make([]string, 0, len(storedFiles))
+	for _, ppf := range storedFiles {
+		filenames = append(filenames, ppf.filename)
+	}
+	sort.Strings(filenames)
+	return gin.H{"saved": filenames}
+}
+
+// chartVersionParam validates the version route param. Versions looked up in the index can also be
+// "latest" or a constraint, the others must be versions, as Helm parses them
+func chartVersionParam(c *gin.Context, lookup bool) (string, *HTTPError) {
+	version := c.Param("version")
+	if lookup && version == "latest" {
+		return version, nil
+	}
+	validate := cm_repo.ValidateChartVersion
+	if lookup {
+		validate = cm_repo.ValidateChartVersionConstraint
+	}
+	if err := validate(version); err != nil {
+		return "", &HTTPError{http.StatusBadRequest, err.Error()}
+	}
+	return version, nil
 }
 
 func (server *MultiTenantServer) getChartAndProvFiles(req *http.Request, repo string, force bool) (map[string]*chartOrProvenanceFile, int, error) {
@@ -483,65 +1232,80 @@ func (server *MultiTenantServer) getChartAndProvFiles(req *http.Request, repo st
 	}
 
 	ffp := []fieldFuncPair{
-		{defaultFormField, cm_repo.ChartPackageFilenameFromContent},
-		{server.ChartPostFormFieldName, cm_repo.ChartPackageFilenameFromContent},
+		{defaultFormField, server.chartPackageFilenameFromContent},
+		{server.ChartPostFormFieldName, server.chartPackageFilenameFromContent},
 		{defaultProvField, cm_repo.ProvenanceFilenameFromContent},
 		{server.ProvPostFormFieldName, cm_repo.ProvenanceFilenameFromContent},
 	}
 
+	if err := req.ParseMultipartForm(server.MultipartMaxMemory); err != nil {
+		// form values over the memory limit are a client error, like a malformed body
+		if errors.Is(err, multipart.ErrMessageTooLarge) {
+			return nil, http.StatusRequestEntityTooLarge, err
+		}
+		return nil, http.StatusBadRequest, fmt.Errorf("malformed multipart request: %s", err)
+	}
+
 	validReturnStatusCode := http.StatusOK
 	cpFiles := make(map[string]*chartOrProvenanceFile)
 	for _, ff := range ffp {
-		content, err := extractContentFromRequest(req, ff.field)
+		contents, err := extractContentsFromRequest(req, ff.field)
 		if err != nil {
 			return nil, http.StatusInternalServerError, err
 		}
-		if content == nil {
-			continue
-		}
-		filename, err := ff.fn(content)
-		if err != nil {
-			return nil, http.StatusBadRequest, err
-		}
-		if _, ok := cpFiles[filename]; ok {
-			continue
-		}
-		// if the file already exists, we don't need to validate it again
-		if validReturnStatusCode == http.StatusConflict {
-			cpFiles[filename] = &chartOrProvenanceFile{filename, content, ff.field}
-			continue
-		}
-		// check filename
-		if pathutil.Base(filename) != filename {
-			return nil, http.StatusBadRequest, fmt.Errorf("%s is improperly formatted", filename) // Name wants to break out of current directory
-		}
-		// check existence
-		status, err := server.validateChartOrProv(repo, filename, force)
-		if err != nil {
-			return nil, status, err
+		if len(contents) > 1 && !server.MultiChartUpload {
+			return nil, http.StatusBadRequest, fmt.Errorf("multiple files in form field %s", ff.field)
 		}
-		// return conflict status code if the file already exists
-		if status == http.StatusConflict {
-			validReturnStatusCode = status
+		for _, content := range contents {
+			filename, err := ff.fn(content)
+			if err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+			if _, ok := cpFiles[filename]; ok {
+				continue
+			}
+			// check filename
+			if pathutil.Base(filename) != filename {
+				return nil, http.StatusBadRequest, fmt.Errorf("%s is improperly formatted", filename) // Name wants to break out of current directory
+			}
+			if server.isReservedObject(filename) {
+				return nil, http.StatusBadRequest, fmt.Errorf("%s uses a reserved prefix", filename) // would never show up in the index
+			}
+			// check existence
+			status, err := server.validateChartOrProv(repo, filename, force)
+			if err != nil {
+				return nil, status, err
+			}
+			// return conflict status code if the file already exists
+			if status == http.StatusConflict {
+				validReturnStatusCode = status
+			}
+			cpFiles[filename] = &chartOrProvenanceFile{filename, content, ff.field, status == http.StatusConflict}
 		}
-		cpFiles[filename] = &chartOrProvenanceFile{filename, content, ff.field}
 	}
 
 	// validState code can be 200 or 409. Returning 409 means that the chart already exists
 	return cpFiles, validReturnStatusCode, nil
 }
 
-func extractContentFromRequest(req *http.Request, field string) ([]byte, error) {
-	file, header, _ := req.FormFile(field)
-	if file == nil || header == nil {
-		return nil, nil // field is not present
-	}
-	buf := bytes.NewBuffer(nil)
-	_, err := io.Copy(buf, file)
-	if err != nil {
-		return nil, err // IO error
+// extractContentsFromRequest returns the content of every file sent in a form field,
+// clients may repeat a field to upload several charts at once
+func extractContentsFromRequest(req *http.Request, field string) ([][]byte, error) {
+	var contents [][]byte
+	for _, header := range req.MultipartForm.File[field] {
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		buf := bytes.NewBuffer(nil)
+		_, err = io.Copy(buf, file)
+		file.Close()
+		if err != nil {
+			return nil, err // IO error
+		}
+		contents = append(contents, buf.Bytes())
 	}
-	return buf.Bytes(), nil
+	return contents, nil
 }
 
 func (server *MultiTenantServer) validateChartOrProv(repo, filename string, force bool) (int, error) {
@@ -17,19 +17,21 @@ limitations under the License.
 package multitenant
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"net/http"
 	pathutil "path"
+	"strconv"
+	"strings"
+	"time"
 
 	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
 
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
 	cm_repo "helm.sh/chartmuseum/pkg/repo"
 )
 
-const (
-	indexFileContentType = "application/x-yaml"
-)
-
 func (server *MultiTenantServer) getIndexFile(log cm_logger.LoggingFn, repo string) (*cm_repo.Index, *HTTPError) {
 	entry, err := server.initCacheEntry(log, repo)
 	if err != nil {
@@ -85,6 +87,144 @@ func (server *MultiTenantServer) getIndexFile(log cm_logger.LoggingFn, repo stri
 	return entry.RepoIndex, nil
 }
 
+// limitIndexSize handles an index larger than the max index size, either rejecting it so that clients
+// use the paginated API instead, or truncating it to the charts which fit
+func (server *MultiTenantServer) limitIndexSize(log cm_logger.LoggingFn, repo string, size int, truncate func() ([]byte, error)) ([]byte, *HTTPError) {
+	log(cm_logger.WarnLevel, "index exceeds max index size",
+		"repo", repo,
+		"size", size,
+		"max_index_size", server.MaxIndexSize,
+	)
+	if !server.TruncateIndex {
+		return nil, &HTTPError{http.StatusRequestEntityTooLarge, fmt.Sprintf(
+			"index exceeds the max size of %d bytes, list charts with the paginated /api/charts endpoint instead", server.MaxIndexSize)}
+	}
+	raw, err := truncate()
+	if err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, err.Error()}
+	}
+	return raw, nil
+}
+
+// indexFileContentType returns the content type the index is served with, depending on its format
+func (server *MultiTenantServer) indexFileContentType() string {
+	if server.JSONIndex {
+		return server.JSONIndexContentType
+	}
+	return server.IndexContentType
+}
+
+// serializedIndex returns the raw index of repo in format, from index or served, the index merged
+// with its upstream one. The JSON representations of an index with a digest are kept until it changes
+func (server *MultiTenantServer) serializedIndex(log cm_logger.LoggingFn, repo string, index *cm_repo.Index, served *cm_repo.IndexFile,
+	format indexFormat, digest string, generated time.Time) ([]byte, *HTTPError) {
+	local := served == index.IndexFile
+	var raw []byte
+	var err error
+	switch {
+	case !format.json && local:
+		return index.Raw, nil
+	case !format.json:
+		return server.marshalMergedIndex(log, repo, index, served), nil
+	case len(format.fields) == 0 && index.OutputJSON && local:
+		return index.Raw, nil
+	case digest != "":
+		raw, err = server.indexRepresentations.get(repo, digest, generated, format.key(), func() ([]byte, error) {
+			return served.JSON(format.fields)
+		})
+	default:
+		raw, err = served.JSON(format.fields)
+	}
+	if err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, err.Error()}
+	}
+	return raw, nil
+}
+
+// writeIndexResponse serves the raw index of repo in format, gzip or deflate compressed if the client
+// accepts it. Each representation gets its own weak ETag, from the digest of the content of the index
+// or of raw if empty, and Vary: Accept-Encoding, so that caches never serve one for the other. The
+// compressed representations of an index with a digest are kept until the index changes. A HEAD
+// request gets the headers of the representation only
+func (server *MultiTenantServer) writeIndexResponse(c *gin.Context, repo string, format indexFormat, raw []byte, digest string, generated time.Time) {
+	if !generated.IsZero() {
+		c.Header("Last-Modified", generated.UTC().Format(http.TimeFormat))
+	}
+	encoding := indexContentEncoding(c.GetHeader("Accept-Encoding"))
+	cached := digest != ""
+	if !cached {
+		digest = fmt.Sprintf("%x", sha256.Sum256(raw))
+	}
+	etag := digest[:32] + format.etagSuffix()
+	if encoding != "" {
+		etag += "-" + encoding
+	}
+	etag = `W/"` + etag + `"`
+
+	c.Header("Vary", "Accept-Encoding")
+	c.Header("ETag", etag)
+	if indexNotModified(c, etag, generated) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	contentType := server.indexFileContentType()
+	if format.json {
+		contentType = server.JSONIndexContentType
+	}
+	if encoding == "" {
+		writeData(c, contentType, raw)
+		return
+	}
+	encode := func() ([]byte, error) {
+		return encodeIndex(encoding, raw)
+	}
+	var content []byte
+	var err error
+	if cached {
+		content, err = server.indexRepresentations.get(repo, digest, generated, format.key()+";"+encoding, encode)
+	} else {
+		content, err = encode()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Encoding", encoding)
+	writeData(c, contentType, content)
+}
+
+// indexNotModified checks whether the client holds the index tagged etag, generated at generated,
+// by If-None-Match or, without it, If-Modified-Since
+func indexNotModified(c *gin.Context, etag string, generated time.Time) bool {
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		for _, match := range strings.Split(ifNoneMatch, ",") {
+			match = strings.TrimSpace(match)
+			// weak comparison, the W/ prefix is ignored
+			if match == "*" || strings.TrimPrefix(match, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if generated.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(c.GetHeader("If-Modified-Since"))
+	return err == nil && !generated.Truncate(time.Second).After(since)
+}
+
+// writeData responds with data, or with its content type and length only to a HEAD request
+func writeData(c *gin.Context, contentType string, data []byte) {
+	if c.Request.Method != http.MethodHead {
+		c.Data(200, contentType, data)
+		return
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Length", strconv.Itoa(len(data)))
+	c.Status(200)
+}
+
 func (server *MultiTenantServer) saveStatefile(log cm_logger.LoggingFn, repo string, content []byte) {
 	err := server.StorageBackend.PutObject(pathutil.Join(repo, cm_repo.StatefileFilename), content)
 	if err != nil {
@@ -0,0 +1,82 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+)
+
+// indexFormat is the format an index is served in, index.yaml or index.json
+type indexFormat struct {
+	json bool
+	// fields are the only fields of the chart versions of index.json, all of them if empty
+	fields []string
+}
+
+// key identifies the format among the representations of an index
+func (format indexFormat) key() string {
+	if !format.json {
+		return ""
+	}
+	if len(format.fields) == 0 {
+		return "json"
+	}
+	return "json?fields=" + strings.Join(format.fields, ",")
+}
+
+// etagSuffix tells the ETag of the format from the ones of the other formats of the same index
+func (format indexFormat) etagSuffix() string {
+	if !format.json {
+		return ""
+	}
+	if len(format.fields) == 0 {
+		return "-json"
+	}
+	return fmt.Sprintf("-json-%x", sha256.Sum256([]byte(format.key())))[:14]
+}
+
+// indexJSONFields parses the comma-separated fields of the chart versions of index.json, sorted
+// without duplicates so that the same fields in any order share their representation
+func indexJSONFields(query string) ([]string, *HTTPError) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+	known := cm_repo.ChartVersionFields()
+	seen := map[string]bool{}
+	var fields []string
+	for _, field := range strings.Split(query, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || seen[field] {
+			continue
+		}
+		i := sort.SearchStrings(known, field)
+		if i == len(known) || known[i] != field {
+			return nil, &HTTPError{http.StatusBadRequest,
+				fmt.Sprintf("unknown field %q, must be one of %s", field, strings.Join(known, ", "))}
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
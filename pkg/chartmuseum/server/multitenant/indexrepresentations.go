@@ -0,0 +1,154 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	indexEncodingGzip    = "gzip"
+	indexEncodingDeflate = "deflate"
+
+	// maxIndexRepresentations bounds the representations kept of the index of a repo, each JSON
+	// selection of fields of index.json and its compressed ones being one
+	maxIndexRepresentations = 16
+)
+
+type (
+	// indexRepresentations keeps the representations of the index of each repo, such as compressed
+	// or as JSON, so that an index is serialized and compressed once per representation rather than
+	// on every request
+	indexRepresentations struct {
+		lock    sync.Mutex
+		indexes map[string]*representedIndex
+	}
+
+	// representedIndex holds the representations of an index by key, identified by the digest of its
+	// content and the time it was generated, as both are part of the raw index
+	representedIndex struct {
+		lock      sync.Mutex
+		digest    string
+		generated time.Time
+		content   map[string][]byte
+	}
+)
+
+func newIndexRepresentations() *indexRepresentations {
+	return &indexRepresentations{indexes: map[string]*representedIndex{}}
+}
+
+// get returns the representation key of the index of repo, built if it was not yet. The
+// representations of a previous index of the repo are dropped, and past maxIndexRepresentations
+// the others are built on every request
+func (r *indexRepresentations) get(repo string, digest string, generated time.Time, key string,
+	build func() ([]byte, error)) ([]byte, error) {
+	r.lock.Lock()
+	index, ok := r.indexes[repo]
+	if !ok || index.digest != digest || !index.generated.Equal(generated) {
+		index = &representedIndex{digest: digest, generated: generated, content: map[string][]byte{}}
+		r.indexes[repo] = index
+	}
+	r.lock.Unlock()
+
+	// concurrent requests for a new index wait for a single build
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	if content, ok := index.content[key]; ok {
+		return content, nil
+	}
+	content, err := build()
+	if err != nil {
+		return nil, err
+	}
+	if len(index.content) < maxIndexRepresentations {
+		index.content[key] = content
+	}
+	return content, nil
+}
+
+// flush drops the representations of the index of repo, returning how many were dropped
+func (r *indexRepresentations) flush(repo string) int {
+	r.lock.Lock()
+	index, ok := r.indexes[repo]
+	delete(r.indexes, repo)
+	r.lock.Unlock()
+	if !ok {
+		return 0
+	}
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	return len(index.content)
+}
+
+// encodeIndex compresses a raw index with encoding, gzip or deflate (the zlib format)
+func encodeIndex(encoding string, raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if encoding == indexEncodingDeflate {
+		w = zlib.NewWriter(&buf)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// indexContentEncoding returns the encoding to compress the index with for an Accept-Encoding header,
+// gzip or deflate with the highest quality value, gzip on a tie, or none
+func indexContentEncoding(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(coding, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			if q, found := strings.CutPrefix(strings.TrimSpace(param), "q="); found {
+				if value, err := strconv.ParseFloat(q, 64); err == nil {
+					quality = value
+				}
+			}
+		}
+		qualities[name] = quality
+	}
+	best, bestQuality := "", 0.0
+	for _, encoding := range []string{indexEncodingGzip, indexEncodingDeflate} {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
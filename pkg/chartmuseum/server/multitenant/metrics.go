@@ -0,0 +1,74 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+)
+
+var (
+	// Number of chart or provenance uploads being handled
+	uploadsInFlight int64
+	// Number of chart packages being loaded from storage to build an index
+	indexWorkersBusy int64
+	// Number of upload/delete events waiting to be applied to an index
+	indexEventsQueued int64
+
+	uploadsInFlightGauge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "chartmuseum",
+			Name:      "uploads_in_flight",
+			Help:      "Current number of uploads being handled",
+		},
+		func() float64 { return float64(atomic.LoadInt64(&uploadsInFlight)) },
+	)
+	indexWorkersBusyGauge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "chartmuseum",
+			Name:      "index_workers_busy",
+			Help:      "Current number of chart packages being loaded to build an index (limited by --index-limit)",
+		},
+		func() float64 { return float64(atomic.LoadInt64(&indexWorkersBusy)) },
+	)
+	indexEventsQueuedGauge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "chartmuseum",
+			Name:      "index_events_queued",
+			Help:      "Current number of uploads and deletes waiting to be applied to an index",
+		},
+		func() float64 { return float64(atomic.LoadInt64(&indexEventsQueued)) },
+	)
+)
+
+func init() {
+	prometheus.MustRegister(uploadsInFlightGauge, indexWorkersBusyGauge, indexEventsQueuedGauge)
+}
+
+// stats returns the current load of the server, the same values as the gauges above
+func (server *MultiTenantServer) stats() map[string]int64 {
+	return map[string]int64{
+		"requests_in_flight":  cm_router.InFlightRequests(),
+		"uploads_in_flight":   atomic.LoadInt64(&uploadsInFlight),
+		"index_workers_busy":  atomic.LoadInt64(&indexWorkersBusy),
+		"index_worker_limit":  int64(server.IndexLimit),
+		"index_events_queued": atomic.LoadInt64(&indexEventsQueued),
+	}
+}
@@ -0,0 +1,303 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+)
+
+const openAPIVersion = "3.0.3"
+
+type (
+	// openAPIDocument is an OpenAPI 3 document describing the routes served
+	openAPIDocument struct {
+		OpenAPI    string                                  `json:"openapi"`
+		Info       openAPIInfo                             `json:"info"`
+		Servers    []openAPIServer                         `json:"servers"`
+		Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+		Components openAPIComponents                       `json:"components"`
+	}
+
+	openAPIInfo struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	}
+
+	openAPIServer struct {
+		URL string `json:"url"`
+	}
+
+	openAPIOperation struct {
+		OperationID string                     `json:"operationId"`
+		Summary     string                     `json:"summary,omitempty"`
+		Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+		RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+		Responses   map[string]openAPIResponse `json:"responses"`
+		Security    []map[string][]string      `json:"security,omitempty"`
+		Action      string                     `json:"x-chartmuseum-action,omitempty"`
+	}
+
+	openAPIParameter struct {
+		Name        string        `json:"name"`
+		In          string        `json:"in"`
+		Description string        `json:"description,omitempty"`
+		Required    bool          `json:"required"`
+		Schema      openAPISchema `json:"schema"`
+	}
+
+	openAPIRequestBody struct {
+		Required bool                        `json:"required"`
+		Content  map[string]openAPIMediaType `json:"content"`
+	}
+
+	openAPIResponse struct {
+		Description string                      `json:"description"`
+		Content     map[string]openAPIMediaType `json:"content,omitempty"`
+	}
+
+	openAPIMediaType struct {
+		Schema *openAPISchema `json:"schema,omitempty"`
+	}
+
+	openAPISchema struct {
+		Ref        string                   `json:"$ref,omitempty"`
+		Type       string                   `json:"type,omitempty"`
+		Properties map[string]openAPISchema `json:"properties,omitempty"`
+	}
+
+	openAPIComponents struct {
+		Schemas         map[string]openAPISchema         `json:"schemas"`
+		SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes,omitempty"`
+	}
+
+	openAPISecurityScheme struct {
+		Type   string `json:"type"`
+		Scheme string `json:"scheme"`
+	}
+
+	// openAPIRoute describes a route of the server beyond its method and path
+	openAPIRoute struct {
+		id      string
+		summary string
+		// query lists the query parameters of the route, none required
+		query []string
+		// body lists the content types of the request body, if any
+		body   []string
+		status int
+	}
+)
+
+// openAPIRoutes describe the routes of the server, by method and path as registered
+var openAPIRoutes = map[string]openAPIRoute{
+	"GET /":                     {id: "getWelcomePage", summary: "HTML welcome page"},
+	"GET /info":                 {id: "getInfo", summary: "Version and features of the server"},
+	"GET /health":               {id: "getHealth", summary: "Health check"},
+	"GET /openapi.json":         {id: "getOpenAPI", summary: "OpenAPI document of the routes served"},
+	"GET /static":               {id: "getStaticFile", summary: "Static files of the web template"},
+	"GET /artifacthub-repo.yml": {id: "getArtifactHubRepoFile", summary: "Artifact Hub repository metadata file"},
+
+	"GET /:repo/index.yaml":        {id: "getIndex", summary: "Index of the charts of a repo"},
+	"HEAD /:repo/index.yaml":       {id: "headIndex", summary: "Size and digest of the index of a repo"},
+	"GET /:repo/index.json":        {id: "getIndexJSON", summary: "Index of the charts of a repo, JSON encoded", query: []string{"fields"}},
+	"HEAD /:repo/index.json":       {id: "headIndexJSON", summary: "Size and digest of the JSON encoded index of a repo"},
+	"GET /:repo/charts/:filename":  {id: "getChartFile", summary: "Download a chart package or provenance file"},
+	"HEAD /:repo/charts/:filename": {id: "headChartFile", summary: "Size and digest of a chart package or provenance file"},
+
+	"GET /api/:repo/charts":        {id: "listCharts", summary: "List all charts", query: []string{"offset", "limit", "sort"}},
+	"GET /api/:repo/charts/search": {id: "searchCharts", summary: "Search charts", query: []string{"q", "regex", "offset", "limit"}},
+	"POST /api/:repo/charts": {id: "uploadChart", summary: "Upload a chart package, as the body, a form or a URL",
+		query: []string{"force", "batch"}, body: []string{"application/octet-stream", "multipart/form-data", "application/json"}, status: http.StatusCreated},
+	"DELETE /api/:repo/charts": {id: "deleteChartByDigest", summary: "Delete the chart version of a digest", query: []string{"digest"}},
+	"POST /api/:repo/prov": {id: "uploadProvenanceFile", summary: "Upload a provenance file",
+		query: []string{"force"}, body: []string{"application/octet-stream", "multipart/form-data"}, status: http.StatusCreated},
+	"HEAD /api/:repo/charts/:name":                      {id: "headChart", summary: "Check a chart exists", query: []string{"constraint"}},
+	"GET /api/:repo/charts/:name":                       {id: "getChart", summary: "Describe the versions of a chart", query: []string{"constraint"}},
+	"DELETE /api/:repo/charts/:name":                    {id: "deleteChart", summary: "Delete all versions of a chart"},
+	"GET /api/:repo/charts/:name/versions":              {id: "getChartVersions", summary: "List the versions of a chart"},
+	"GET /api/:repo/charts/:name/diff":                  {id: "diffChartVersions", summary: "Diff the templates, values and Chart.yaml of two chart versions", query: []string{"from", "to", "format"}},
+	"GET /api/:repo/charts/:name/stats":                 {id: "getChartDownloadStats", summary: "Download counts of a chart"},
+	"POST /api/:repo/charts/:name/rename":               {id: "renameChart", summary: "Rename a chart", body: []string{"application/json"}},
+	"HEAD /api/:repo/charts/:name/:version":             {id: "headChartVersion", summary: "Check a chart version exists"},
+	"GET /api/:repo/charts/:name/:version":              {id: "getChartVersion", summary: "Describe a chart version"},
+	"DELETE /api/:repo/charts/:name/:version":           {id: "deleteChartVersion", summary: "Delete a chart version"},
+	"GET /api/:repo/charts/:name/:version/templates":    {id: "getChartVersionTemplates", summary: "Templates of a chart version"},
+	"GET /api/:repo/charts/:name/:version/values":       {id: "getChartVersionValues", summary: "Values of a chart version"},
+	"GET /api/:repo/charts/:name/:version/readme":       {id: "getChartVersionReadme", summary: "README of a chart version", query: []string{"format"}},
+	"GET /api/:repo/charts/:name/:version/metadata":     {id: "getChartVersionMetadata", summary: "Metadata of a chart version"},
+	"GET /api/:repo/charts/:name/:version/dependencies": {id: "getChartVersionDependencies", summary: "Graph of the dependencies of a chart version"},
+	"POST /api/:repo/charts/:name/:version/promote": {id: "promoteChartVersion", summary: "Copy a chart version to another repo",
+		query: []string{"to", "delete_source", "force"}, status: http.StatusCreated},
+	"POST /api/:repo/charts/:name/:version/deprecate": {id: "deprecateChartVersion", summary: "Mark a chart version deprecated in the index",
+		query: []string{"rewrite"}},
+	"POST /api/:repo/charts/:name/:version/verify": {id: "verifyChartVersion", summary: "Verify the provenance and the package digest of a chart version"},
+	"GET /api/:repo/charts/:name/:version/verify":  {id: "getChartVersionVerification", summary: "Verify the provenance and the package digest of a chart version"},
+	"GET /api/:repo/settings":                      {id: "getSettings", summary: "Settings of a repo"},
+	"PUT /api/:repo/settings":                      {id: "putSettings", summary: "Change the settings of a repo", body: []string{"application/json"}},
+	"GET /api/:repo/stats":                         {id: "getRepoStats", summary: "Charts, versions and storage used by a repo"},
+	"GET /api/:repo/orphans":                       {id: "getOrphanReport", summary: "Objects of a repo the index does not reference, and packages of the index not stored"},
+	"POST /api/:repo/index/regenerate":             {id: "regenerateIndex", summary: "Rebuild the index of a repo from storage"},
+	"GET /api/:repo/keys":                          {id: "listAPIKeys", summary: "List the API keys of a repo"},
+	"POST /api/:repo/keys":                         {id: "createAPIKey", summary: "Create an API key", body: []string{"application/json"}, status: http.StatusCreated},
+	"DELETE /api/:repo/keys/:id":                   {id: "revokeAPIKey", summary: "Revoke an API key"},
+	"POST /api/:repo/pull-tokens": {id: "createPullToken", summary: "Create a token granting to pull the charts of a repo",
+		body: []string{"application/json"}, status: http.StatusCreated},
+	"POST /api/debug/flush-cache": {id: "flushCache", summary: "Drop cached indexes", query: []string{"repo"}},
+	"GET /api/debug/stats":        {id: "getStats", summary: "Requests, uploads and index workers in flight"},
+}
+
+func (server *MultiTenantServer) getOpenAPIRequestHandler(c *gin.Context) {
+	c.JSON(200, server.openAPIDocument())
+}
+
+// openAPIDocument describes the routes registered on the router, with the paths of the repos at the
+// depth of the router
+func (server *MultiTenantServer) openAPIDocument() *openAPIDocument {
+	serverURL := server.Router.ContextPath
+	if serverURL == "" {
+		serverURL = "/"
+	}
+	doc := &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info:    openAPIInfo{Title: "ChartMuseum", Version: apiVersion},
+		Servers: []openAPIServer{{URL: serverURL}},
+		Paths:   map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: map[string]openAPISchema{
+				"Error": {Type: "object", Properties: map[string]openAPISchema{"error": {Type: "string"}}},
+			},
+		},
+	}
+	// the credentials of the basic and LDAP users are sent with basic auth, the API keys either as a
+	// basic auth password or as a bearer token
+	var basic, bearer bool
+	for _, method := range server.Router.AuthMethods() {
+		basic = basic || method == "basic" || method == "ldap" || method == "api_key"
+		bearer = bearer || method == "bearer" || method == "api_key"
+	}
+	var security []map[string][]string
+	if basic || bearer {
+		doc.Components.SecuritySchemes = map[string]openAPISecurityScheme{}
+	}
+	if basic {
+		doc.Components.SecuritySchemes["basicAuth"] = openAPISecurityScheme{Type: "http", Scheme: "basic"}
+		security = append(security, map[string][]string{"basicAuth": {}})
+	}
+	if bearer {
+		doc.Components.SecuritySchemes["bearerAuth"] = openAPISecurityScheme{Type: "http", Scheme: "bearer"}
+		security = append(security, map[string][]string{"bearerAuth": {}})
+	}
+
+	for _, route := range server.Router.Routes {
+		path, params := server.openAPIPath(route.Path)
+		described, ok := openAPIRoutes[route.Method+" "+route.Path]
+		if !ok {
+			described.id = strings.ToLower(route.Method) + strings.NewReplacer("/", "_", ":", "", ".", "_", "-", "_").Replace(route.Path)
+		}
+		operation := &openAPIOperation{
+			OperationID: described.id,
+			Summary:     described.summary,
+			Parameters:  params,
+			Responses:   openAPIResponses(described.status),
+			Action:      route.Action,
+		}
+		for _, name := range described.query {
+			operation.Parameters = append(operation.Parameters, openAPIParameter{
+				Name:   name,
+				In:     "query",
+				Schema: openAPISchema{Type: "string"},
+			})
+		}
+		if len(described.body) > 0 {
+			operation.RequestBody = &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{}}
+			for _, contentType := range described.body {
+				operation.RequestBody.Content[contentType] = openAPIMediaType{}
+			}
+		}
+		if route.Action != "" && len(security) > 0 {
+			operation.Security = append([]map[string][]string{}, security...)
+			// pulling with GET or HEAD needs no credentials with anonymous GET
+			if server.Router.AnonymousGet() && route.Action == cm_auth.PullAction && (route.Method == http.MethodGet || route.Method == http.MethodHead) {
+				operation.Security = append(operation.Security, map[string][]string{})
+			}
+		}
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*openAPIOperation{}
+		}
+		// the first route registered for a method and path is the one served
+		if _, exists := doc.Paths[path][strings.ToLower(route.Method)]; !exists {
+			doc.Paths[path][strings.ToLower(route.Method)] = operation
+		}
+	}
+	return doc
+}
+
+// openAPIPath returns the OpenAPI path of a route and its path parameters. The repo is left out of
+// the paths of a single tenant server, and has a parameter per path segment at the depth of the
+// router, or a single one holding the whole path with a dynamic depth
+func (server *MultiTenantServer) openAPIPath(routePath string) (string, []openAPIParameter) {
+	var segments []string
+	var params []openAPIParameter
+	for _, segment := range strings.Split(routePath, "/") {
+		switch {
+		case segment == ":repo" && server.Router.DepthDynamic:
+			segments = append(segments, "{repo}")
+			params = append(params, openAPIPathParameter("repo", "path of the repo, any number of segments"))
+		case segment == ":repo" && server.Router.Depth == 1:
+			segments = append(segments, "{repo}")
+			params = append(params, openAPIPathParameter("repo", "name of the repo"))
+		case segment == ":repo":
+			for i := 1; i <= server.Router.Depth; i++ {
+				name := fmt.Sprintf("repo%d", i)
+				segments = append(segments, "{"+name+"}")
+				params = append(params, openAPIPathParameter(name, fmt.Sprintf("segment %d of the path of the repo", i)))
+			}
+		case strings.HasPrefix(segment, ":"):
+			segments = append(segments, "{"+segment[1:]+"}")
+			params = append(params, openAPIPathParameter(segment[1:], ""))
+		default:
+			segments = append(segments, segment)
+		}
+	}
+	path := strings.Join(segments, "/")
+	if path == "" {
+		path = "/"
+	}
+	return path, params
+}
+
+func openAPIPathParameter(name string, description string) openAPIParameter {
+	return openAPIParameter{Name: name, In: "path", Description: description, Required: true, Schema: openAPISchema{Type: "string"}}
+}
+
+// openAPIResponses are the responses of an operation, with the errors of the API
+func openAPIResponses(status int) map[string]openAPIResponse {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return map[string]openAPIResponse{
+		fmt.Sprint(status): {Description: http.StatusText(status)},
+		"default": {
+			Description: "Error",
+			Content:     map[string]openAPIMediaType{"application/json": {Schema: &openAPISchema{Ref: "#/components/schemas/Error"}}},
+		},
+	}
+}
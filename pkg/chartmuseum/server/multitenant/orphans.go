@@ -0,0 +1,181 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"net/http"
+	pathutil "path"
+	"sort"
+	"strings"
+	"time"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	cm_pkg_storage "helm.sh/chartmuseum/pkg/storage"
+)
+
+const (
+	// orphanReasonProvenance is the reason of a provenance file whose chart package is not stored
+	orphanReasonProvenance = "provenance file without chart package"
+	// orphanReasonInvalidPackage is the reason of a chart package which cannot be read, left out of the index
+	orphanReasonInvalidPackage = "invalid chart package"
+	// orphanReasonUnindexedPackage is the reason of a valid chart package the index does not reference,
+	// such as a package stored under another name than the one of its chart version
+	orphanReasonUnindexedPackage = "chart package not in the index"
+	// orphanReasonUnknown is the reason of the other objects, neither charts nor stored by the server
+	orphanReasonUnknown = "not a chart package or provenance file"
+)
+
+// serverObjectFilenames are the objects the server stores next to the charts of a repo
+var serverObjectFilenames = []string{cm_repo.StatefileFilename, apiKeysFilename, downloadStatsFilename, tenantSettingsFilename,
+	chartDeprecationsFilename}
+
+type (
+	// orphanReport lists the drift between the storage of a repo and its index
+	orphanReport struct {
+		// Objects are the objects of the repo the index does not reference
+		Objects []orphanedObject `json:"objects"`
+		// MissingPackages are the chart versions of the index whose package is not stored
+		MissingPackages []missingPackage `json:"missing_packages"`
+	}
+
+	orphanedObject struct {
+		Path         string    `json:"path"`
+		LastModified time.Time `json:"last_modified"`
+		Reason       string    `json:"reason"`
+	}
+
+	missingPackage struct {
+		Name     string `json:"name"`
+		Version  string `json:"version"`
+		Filename string `json:"filename"`
+	}
+)
+
+func (server *MultiTenantServer) getOrphanReportRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	log := server.Logger.ContextLoggingFn(c)
+	report, err := server.orphanReport(log, repo)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	c.JSON(200, report)
+}
+
+// orphanReport compares the objects stored in a repo with its index, the objects under a reserved
+// prefix and the ones the server stores left out. Only the chart packages the index does not
+// reference are read, to tell the invalid ones
+func (server *MultiTenantServer) orphanReport(log cm_logger.LoggingFn, repo string) (*orphanReport, *HTTPError) {
+	index, err := server.getIndexFile(log, repo)
+	if err != nil {
+		return nil, err
+	}
+	var objects []cm_storage.Object
+	walkErr := cm_pkg_storage.WalkObjects(server.StorageBackend, repo, server.StorageListPageSize, func(page []cm_storage.Object) error {
+		for _, object := range page {
+			if !server.isReservedObject(object.Path) && !isServerObject(object.Path) {
+				objects = append(objects, object)
+			}
+		}
+		return nil
+	})
+	if walkErr != nil {
+		log(cm_logger.ErrorLevel, walkErr.Error(),
+			"repo", repo,
+		)
+		return nil, &HTTPError{http.StatusInternalServerError, walkErr.Error()}
+	}
+	// the index references packages by filename, wherever they are stored in the repo
+	stored := make(map[string]bool, len(objects))
+	storedPackages := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		stored[object.Path] = true
+		storedPackages[pathutil.Base(object.Path)] = true
+	}
+
+	report := &orphanReport{Objects: []orphanedObject{}, MissingPackages: []missingPackage{}}
+	indexed := map[string]bool{}
+	index.IndexLock.RLock()
+	for _, chartVersions := range index.Entries {
+		for _, chartVersion := range chartVersions {
+			filename, err := chartFileName(chartVersion)
+			if err != nil {
+				continue
+			}
+			indexed[filename] = true
+			if !storedPackages[filename] {
+				report.MissingPackages = append(report.MissingPackages, missingPackage{
+					Name:     chartVersion.Name,
+					Version:  chartVersion.Version,
+					Filename: filename,
+				})
+			}
+		}
+	}
+	index.IndexLock.RUnlock()
+
+	for _, object := range objects {
+		var reason string
+		switch {
+		case strings.HasSuffix(object.Path, "."+cm_repo.ProvenanceFileExtension):
+			if !stored[strings.TrimSuffix(object.Path, ".prov")] {
+				reason = orphanReasonProvenance
+			}
+		case object.HasExtension(cm_repo.ChartPackageFileExtension):
+			if !indexed[pathutil.Base(object.Path)] {
+				reason = server.unindexedPackageReason(repo, object)
+			}
+		default:
+			reason = orphanReasonUnknown
+		}
+		if reason != "" {
+			report.Objects = append(report.Objects, orphanedObject{Path: object.Path, LastModified: object.LastModified, Reason: reason})
+		}
+	}
+	sort.Slice(report.Objects, func(i, j int) bool { return report.Objects[i].Path < report.Objects[j].Path })
+	sort.Slice(report.MissingPackages, func(i, j int) bool {
+		return report.MissingPackages[i].Filename < report.MissingPackages[j].Filename
+	})
+	return report, nil
+}
+
+// unindexedPackageReason tells why a chart package stored in repo is not in its index
+func (server *MultiTenantServer) unindexedPackageReason(repo string, object cm_storage.Object) string {
+	loaded, err := server.StorageBackend.GetObject(pathutil.Join(repo, object.Path))
+	if err != nil {
+		return orphanReasonUnindexedPackage
+	}
+	if _, err := cm_repo.ChartPackageFilenameFromContent(loaded.Content); err != nil || len(loaded.Content) == 0 {
+		return orphanReasonInvalidPackage
+	}
+	return orphanReasonUnindexedPackage
+}
+
+// isServerObject tells the objects the server stores next to the charts of a repo, by name
+func isServerObject(path string) bool {
+	name := pathutil.Base(path)
+	for _, filename := range serverObjectFilenames {
+		if name == filename {
+			return true
+		}
+	}
+	return false
+}
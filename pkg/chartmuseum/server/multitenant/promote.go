@@ -0,0 +1,124 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"net/http"
+	pathutil "path"
+	"strings"
+	"time"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+	"helm.sh/helm/v3/pkg/chart"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+)
+
+type (
+	// chartPromotion is the result of copying a chart version to another repo
+	chartPromotion struct {
+		Name    string   `json:"name"`
+		Version string   `json:"version"`
+		From    string   `json:"from"`
+		To      string   `json:"to"`
+		Saved   []string `json:"saved"`
+		// Updated is set when the version already existed in the destination and was overwritten
+		Updated       bool `json:"updated"`
+		SourceDeleted bool `json:"source_deleted"`
+	}
+)
+
+// validRepo tells whether repo is a repo of the server other than the default one, as many path
+// segments as the depth of the router, or any number of them with a dynamic depth
+func (server *MultiTenantServer) validRepo(repo string) bool {
+	if repo == "" || pathutil.Clean(repo) != repo || strings.HasPrefix(repo, "/") {
+		return false
+	}
+	segments := strings.Split(repo, "/")
+	for _, segment := range segments {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	if server.Router.DepthDynamic {
+		return true
+	}
+	return len(segments) == server.Router.Depth
+}
+
+// promoteChartVersion copies the package of a chart version and its provenance file, if any, from
+// repo to the repo to, as uploaded there, then deletes the version from repo if deleteSource is set
+func (server *MultiTenantServer) promoteChartVersion(c *gin.Context, log cm_logger.LoggingFn, repo string, name string, version string,
+	to string, deleteSource bool, force bool) (*chartPromotion, *HTTPError) {
+	chartVersion, err := server.getChartVersion(log, repo, name, version)
+	if err != nil {
+		return nil, err
+	}
+	version = chartVersion.Version
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(name, version)
+	object, getErr := server.StorageBackend.GetObject(pathutil.Join(repo, filename))
+	if getErr != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, getErr.Error()}
+	}
+	var provContent []byte
+	if provObject, provErr := server.StorageBackend.GetObject(pathutil.Join(repo, filename+".prov")); provErr == nil {
+		provContent = provObject.Content
+	}
+	_, updated, err := server.uploadChartPackageAndProvenance(log, to, object.Content, provContent, force)
+	if err != nil {
+		return nil, err
+	}
+	promotion := &chartPromotion{Name: name, Version: version, From: repo, To: to, Saved: []string{filename}, Updated: updated}
+	if provContent != nil {
+		promotion.Saved = append(promotion.Saved, filename+".prov")
+	}
+
+	promoted, chartErr := cm_repo.ChartVersionFromStorageObject(cm_storage.Object{
+		Path:         pathutil.Join(to, filename),
+		Content:      object.Content,
+		LastModified: time.Now(),
+	})
+	if chartErr != nil {
+		log(cm_logger.ErrorLevel, "cannot get chart from content", "error", chartErr.Error())
+	} else {
+		action := addChart
+		if promotion.Updated {
+			action = updateChart
+		}
+		server.emitEvent(c, to, action, promoted)
+	}
+	cm_router.AuditChart(c, name, version)
+
+	if deleteSource {
+		if err := server.deleteChartVersion(log, repo, name, version); err != nil {
+			// the promoted version is stored, only the cleanup failed
+			return promotion, &HTTPError{err.Status, "promoted to " + to + ", but failed to delete the source: " + err.Message}
+		}
+		server.emitEvent(c, repo, deleteChart, &helm_repo.ChartVersion{
+			Metadata: &chart.Metadata{
+				Name:    name,
+				Version: version,
+			},
+		})
+		promotion.SourceDeleted = true
+	}
+	return promotion, nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+)
+
+type (
+	// createPullTokenRequest is the optional body of POST /api/:repo/pull-tokens
+	createPullTokenRequest struct {
+		// Name tells whom the token is shared with, in the logs only
+		Name string `json:"name"`
+		// ExpiresIn is the lifetime of the token in seconds, a day if zero
+		ExpiresIn int `json:"expires_in"`
+	}
+)
+
+// createPullToken mints a token granting to pull the charts of repo, on behalf of user
+func (server *MultiTenantServer) createPullToken(repo string, user string, req createPullTokenRequest) (*cm_router.PullToken, *HTTPError) {
+	if req.ExpiresIn < 0 {
+		return nil, &HTTPError{http.StatusBadRequest, "expires_in must be positive"}
+	}
+	token, err := server.Router.IssuePullToken(user, repo, time.Duration(req.ExpiresIn)*time.Second)
+	if errors.Is(err, cm_router.ErrPullTokensDisabled) {
+		return nil, &HTTPError{http.StatusNotFound, err.Error()}
+	} else if err != nil {
+		return nil, &HTTPError{http.StatusBadRequest, err.Error()}
+	}
+	return token, nil
+}
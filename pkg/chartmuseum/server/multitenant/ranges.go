@@ -0,0 +1,150 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	pathutil "path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	cm_pkg_storage "helm.sh/chartmuseum/pkg/storage"
+)
+
+// byteRange is a range of bytes of a Range header, as read by cm_pkg_storage.GetObjectRange
+type byteRange struct {
+	offset int64
+	length int64
+}
+
+// parseByteRange parses a Range header of a single range of bytes. Other ranges, such as multiple
+// ranges, are not supported and the whole object is served instead, as is the case of invalid ones
+func parseByteRange(header string) (byteRange, bool) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return byteRange{}, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return byteRange{}, false
+	}
+	if first == "" {
+		// the last bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return byteRange{}, false
+		}
+		return byteRange{-n, -1}, true
+	}
+	offset, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || offset < 0 {
+		return byteRange{}, false
+	}
+	if last == "" {
+		return byteRange{offset, -1}, true
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < offset {
+		return byteRange{}, false
+	}
+	return byteRange{offset, end - offset + 1}, true
+}
+
+// requestedByteRange returns the range of bytes of an object requested by the client, if any. With
+// If-Range, the range applies only if the chart package is unchanged since the client read its ETag
+// or Last-Modified, otherwise the whole object is served
+func (server *MultiTenantServer) requestedByteRange(c *gin.Context, log cm_logger.LoggingFn, repo string, filename string) (byteRange, bool) {
+	r, ok := parseByteRange(c.GetHeader("Range"))
+	if !ok {
+		return r, false
+	}
+	ifRange := c.GetHeader("If-Range")
+	if ifRange == "" {
+		return r, true
+	}
+	if !strings.HasSuffix(filename, cm_repo.ChartPackageFileExtension) {
+		return r, false
+	}
+	chartVersion := server.chartVersionOfPackage(log, repo, filename)
+	if chartVersion == nil {
+		return r, false
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return r, chartVersion.Digest != "" && ifRange == fmt.Sprintf("%q", chartVersion.Digest)
+	}
+	modified, err := http.ParseTime(ifRange)
+	return r, err == nil && !chartVersion.Created.IsZero() &&
+		chartVersion.Created.UTC().Truncate(time.Second).Equal(modified)
+}
+
+// writeStorageObject serves an object, streamed from storage, or returns the error if not served
+func (server *MultiTenantServer) writeStorageObject(c *gin.Context, log cm_logger.LoggingFn, repo string, filename string) *HTTPError {
+	reader, size, contentType, err := server.getStorageObjectStream(log, repo, filename)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	server.countDownload(repo, filename)
+	c.Header("Accept-Ranges", "bytes")
+	c.DataFromReader(http.StatusOK, size, contentType, reader, nil)
+	return nil
+}
+
+// writeStorageObjectRange serves a range of an object, or returns the error if not served
+func (server *MultiTenantServer) writeStorageObjectRange(c *gin.Context, log cm_logger.LoggingFn, repo string, filename string,
+	r byteRange) *HTTPError {
+	contentType, herr := storageObjectContentType(log, repo, filename)
+	if herr != nil {
+		return herr
+	}
+	objectRange, err := cm_pkg_storage.GetObjectRange(server.StorageBackend, pathutil.Join(repo, filename), r.offset, r.length)
+	var notSatisfiable *cm_pkg_storage.RangeNotSatisfiableError
+	if errors.As(err, &notSatisfiable) {
+		if notSatisfiable.Size >= 0 {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", notSatisfiable.Size))
+		}
+		return &HTTPError{http.StatusRequestedRangeNotSatisfiable, err.Error()}
+	}
+	if err != nil {
+		log(cm_logger.WarnLevel, err.Error(),
+			"repo", repo,
+			"filename", filename,
+		)
+		return storageObjectError(err)
+	}
+	defer objectRange.Close()
+	if objectRange.Offset == 0 {
+		// resumed downloads are counted once, by their first range
+		server.countDownload(repo, filename)
+	}
+	size := "*"
+	if objectRange.Size >= 0 {
+		size = strconv.FormatInt(objectRange.Size, 10)
+	}
+	c.Header("Accept-Ranges", "bytes")
+	c.DataFromReader(http.StatusPartialContent, objectRange.Length, contentType, objectRange, map[string]string{
+		"Content-Range": fmt.Sprintf("bytes %d-%d/%s", objectRange.Offset, objectRange.Offset+objectRange.Length-1, size),
+	})
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"net/http"
+	pathutil "path"
+	"time"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+	"helm.sh/helm/v3/pkg/chart"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+)
+
+type (
+	renameChartRequest struct {
+		To              string `json:"to"`
+		DeleteOriginals bool   `json:"delete_originals"`
+		DryRun          bool   `json:"dry_run"`
+	}
+
+	// renamedChartVersion is the result of renaming a single version
+	renamedChartVersion struct {
+		Version string `json:"version"`
+		Status  string `json:"status"`
+		Error   string `json:"error,omitempty"`
+		// ProvenanceDropped is set when the version had a provenance file, which no longer
+		// matches the repackaged chart and is not copied
+		ProvenanceDropped bool `json:"provenance_dropped,omitempty"`
+	}
+)
+
+const (
+	renameStatusRenamed     = "renamed"
+	renameStatusWouldRename = "would_rename"
+	renameStatusConflict    = "conflict"
+	renameStatusFailed      = "failed"
+)
+
+// renameChart republishes every version of a chart under another name. Packages are rewritten
+// as the chart name is part of Chart.yaml, so provenance files cannot be carried over
+func (server *MultiTenantServer) renameChart(c *gin.Context, repo string, name string, req renameChartRequest) ([]renamedChartVersion, *HTTPError) {
+	log := server.Logger.ContextLoggingFn(c)
+	if req.To == "" || pathutil.Base(req.To) != req.To || server.isReservedObject(req.To) {
+		return nil, &HTTPError{http.StatusBadRequest, "invalid chart name: " + req.To}
+	}
+	if req.To == name {
+		return nil, &HTTPError{http.StatusBadRequest, "chart already named " + name}
+	}
+	if req.DeleteOriginals && server.DisableDelete {
+		return nil, &HTTPError{http.StatusForbidden, "deleting charts is disabled"}
+	}
+	chartVersions, err := server.getChart(log, repo, name)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]renamedChartVersion, 0, len(chartVersions))
+	for _, chartVersion := range chartVersions {
+		result := server.renameChartVersion(c, log, repo, name, chartVersion.Version, req)
+		if result.Status == renameStatusFailed {
+			log(cm_logger.ErrorLevel, "failed to rename chart version",
+				"repo", repo,
+				"name", name,
+				"version", chartVersion.Version,
+				"to", req.To,
+				"error", result.Error,
+			)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (server *MultiTenantServer) renameChartVersion(c *gin.Context, log cm_logger.LoggingFn, repo string, name string, version string, req renameChartRequest) renamedChartVersion {
+	result := renamedChartVersion{Version: version}
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(name, version)
+	renamedFilename := cm_repo.ChartPackageFilenameFromNameVersion(req.To, version)
+
+	if _, err := server.StorageBackend.GetObject(pathutil.Join(repo, renamedFilename)); err == nil {
+		result.Status = renameStatusConflict
+		result.Error = renamedFilename + " already exists"
+		return result
+	}
+	object, err := server.StorageBackend.GetObject(pathutil.Join(repo, filename))
+	if err != nil {
+		result.Status = renameStatusFailed
+		result.Error = err.Error()
+		return result
+	}
+	_, err = server.StorageBackend.GetObject(pathutil.Join(repo, filename+".prov"))
+	result.ProvenanceDropped = err == nil
+
+	content, err := cm_repo.RenameChartPackage(object.Content, req.To)
+	if err != nil {
+		result.Status = renameStatusFailed
+		result.Error = err.Error()
+		return result
+	}
+	if req.DryRun {
+		result.Status = renameStatusWouldRename
+		return result
+	}
+
+	renamedObject := cm_storage.Object{
+		Path:         pathutil.Join(repo, renamedFilename),
+		Content:      content,
+		LastModified: time.Now(),
+	}
+	renamed, err := cm_repo.ChartVersionFromStorageObject(renamedObject)
+	if err != nil {
+		result.Status = renameStatusFailed
+		result.Error = err.Error()
+		return result
+	}
+	if err := server.StorageBackend.PutObject(renamedObject.Path, content); err != nil {
+		result.Status = renameStatusFailed
+		result.Error = err.Error()
+		return result
+	}
+	server.recordPackageSize(repo, renamedFilename, len(content))
+	server.emitEvent(c, repo, addChart, renamed)
+	result.Status = renameStatusRenamed
+
+	if req.DeleteOriginals {
+		if err := server.deleteChartVersion(log, repo, name, version); err != nil {
+			// the renamed version is stored, only the cleanup failed
+			result.Error = "failed to delete original: " + err.Message
+			return result
+		}
+		server.emitEvent(c, repo, deleteChart, &helm_repo.ChartVersion{
+			Metadata: &chart.Metadata{
+				Name:    name,
+				Version: version,
+			},
+		})
+	}
+	return result
+}
@@ -26,9 +26,11 @@ func (s *MultiTenantServer) Routes() []*cm_router.Route {
 	var routes []*cm_router.Route
 
 	serverInfoRoutes := []*cm_router.Route{
-		{Method: "GET", Path: "/", Handler: s.getWelcomePageHandler, Action: cm_auth.PullAction},
 		{Method: "GET", Path: "/info", Handler: s.getInfoHandler, Action: ""},
 		{Method: "GET", Path: "/health", Handler: s.getHealthCheckHandler, Action: ""},
+		{Method: "GET", Path: "/openapi.json", Handler: s.getOpenAPIRequestHandler, Action: ""},
+		// last, so that the routes above are not mistaken for the welcome page of a repo
+		{Method: "GET", Path: "/", Handler: s.getWelcomePageHandler, Action: cm_auth.PullAction},
 	}
 
 	artifactHubRoutes := []*cm_router.Route{
@@ -38,25 +40,62 @@ func (s *MultiTenantServer) Routes() []*cm_router.Route {
 	helmChartRepositoryRoutes := []*cm_router.Route{
 		{Method: "GET", Path: "/:repo/index.yaml", Handler: s.getIndexFileRequestHandler, Action: cm_auth.PullAction},
 		{Method: "HEAD", Path: "/:repo/index.yaml", Handler: s.headIndexFileRequestHandler, Action: cm_auth.PullAction},
+		{Method: "GET", Path: "/:repo/index.json", Handler: s.getIndexJSONRequestHandler, Action: cm_auth.PullAction},
+		{Method: "HEAD", Path: "/:repo/index.json", Handler: s.headIndexJSONRequestHandler, Action: cm_auth.PullAction},
 		{Method: "GET", Path: "/:repo/charts/:filename", Handler: s.getStorageObjectRequestHandler, Action: cm_auth.PullAction},
+		{Method: "HEAD", Path: "/:repo/charts/:filename", Handler: s.headStorageObjectRequestHandler, Action: cm_auth.PullAction},
 	}
 
 	chartManipulationRoutes := []*cm_router.Route{
 		{Method: "GET", Path: "/api/:repo/charts", Handler: s.getAllChartsRequestHandler, Action: cm_auth.PullAction},
+		// before the versions of a chart, so that search is not mistaken for a chart name
+		{Method: "GET", Path: "/api/:repo/charts/search", Handler: s.searchChartsRequestHandler, Action: cm_auth.PullAction},
 		{Method: "HEAD", Path: "/api/:repo/charts/:name", Handler: s.headChartRequestHandler, Action: cm_auth.PullAction},
 		{Method: "GET", Path: "/api/:repo/charts/:name", Handler: s.getChartRequestHandler, Action: cm_auth.PullAction},
+		// before the versions of a chart, so that versions and diff are not mistaken for a version
+		{Method: "GET", Path: "/api/:repo/charts/:name/versions", Handler: s.getChartVersionsRequestHandler, Action: cm_auth.PullAction},
+		{Method: "GET", Path: "/api/:repo/charts/:name/diff", Handler: s.getChartVersionDiffRequestHandler, Action: cm_auth.PullAction},
 		{Method: "HEAD", Path: "/api/:repo/charts/:name/:version", Handler: s.headChartVersionRequestHandler, Action: cm_auth.PullAction},
 		{Method: "GET", Path: "/api/:repo/charts/:name/:version", Handler: s.getChartVersionRequestHandler, Action: cm_auth.PullAction},
 		{Method: "GET", Path: "/api/:repo/charts/:name/:version/templates", Handler: s.getStorageObjectTemplateRequestHandler, Action: cm_auth.PullAction},
 		{Method: "GET", Path: "/api/:repo/charts/:name/:version/values", Handler: s.getStorageObjectValuesRequestHandler, Action: cm_auth.PullAction},
+		{Method: "GET", Path: "/api/:repo/charts/:name/:version/readme", Handler: s.getStorageObjectReadmeRequestHandler, Action: cm_auth.PullAction},
+		{Method: "GET", Path: "/api/:repo/charts/:name/:version/metadata", Handler: s.getStorageObjectMetadataRequestHandler, Action: cm_auth.PullAction},
+		{Method: "GET", Path: "/api/:repo/charts/:name/:version/dependencies", Handler: s.getChartDependenciesRequestHandler, Action: cm_auth.PullAction},
 		{Method: "POST", Path: "/api/:repo/charts", Handler: s.postRequestHandler, Action: cm_auth.PushAction},
 		{Method: "POST", Path: "/api/:repo/prov", Handler: s.postProvenanceFileRequestHandler, Action: cm_auth.PushAction},
+		{Method: "GET", Path: "/api/:repo/settings", Handler: s.getTenantSettingsRequestHandler, Action: cm_router.AdminAction},
+		{Method: "GET", Path: "/api/:repo/stats", Handler: s.getRepoStatsRequestHandler, Action: cm_auth.PullAction},
+		{Method: "GET", Path: "/api/:repo/orphans", Handler: s.getOrphanReportRequestHandler, Action: cm_router.AdminAction},
+		{Method: "PUT", Path: "/api/:repo/settings", Handler: s.putTenantSettingsRequestHandler, Action: cm_router.AdminAction},
+		{Method: "POST", Path: "/api/:repo/index/regenerate", Handler: s.regenerateIndexRequestHandler, Action: cm_router.AdminAction},
+		{Method: "POST", Path: "/api/:repo/charts/:name/rename", Handler: s.renameChartRequestHandler, Action: cm_router.AdminAction},
+		// a write, which also authorizes pulling from the source, pushing to the destination repo and deleting from the source itself
+		{Method: "POST", Path: "/api/:repo/charts/:name/:version/promote", Handler: s.promoteChartVersionRequestHandler, Action: cm_auth.PushAction},
+		{Method: "POST", Path: "/api/:repo/charts/:name/:version/deprecate", Handler: s.deprecateChartVersionRequestHandler, Action: cm_auth.PushAction},
+		{Method: "GET", Path: "/api/:repo/keys", Handler: s.listAPIKeysRequestHandler, Action: cm_router.AdminAction},
+		{Method: "POST", Path: "/api/:repo/keys", Handler: s.createAPIKeyRequestHandler, Action: cm_router.AdminAction},
+		{Method: "DELETE", Path: "/api/:repo/keys/:id", Handler: s.revokeAPIKeyRequestHandler, Action: cm_router.AdminAction},
+	}
+
+	pullTokenRoutes := []*cm_router.Route{
+		{Method: "POST", Path: "/api/:repo/pull-tokens", Handler: s.createPullTokenRequestHandler, Action: cm_router.AdminAction},
+	}
+
+	// before the versions of a chart, so that stats is not mistaken for a version
+	downloadStatsRoutes := []*cm_router.Route{
+		{Method: "GET", Path: "/api/:repo/charts/:name/stats", Handler: s.getChartDownloadStatsRequestHandler, Action: cm_auth.PullAction},
+	}
+
+	debugRoutes := []*cm_router.Route{
+		{Method: "POST", Path: "/api/debug/flush-cache", Handler: s.flushCacheRequestHandler, Action: cm_router.AdminAction},
+		{Method: "GET", Path: "/api/debug/stats", Handler: s.getStatsRequestHandler, Action: cm_router.AdminAction},
 	}
 
 	routes = append(routes, serverInfoRoutes...)
 	routes = append(routes, helmChartRepositoryRoutes...)
 
-	if len(s.ArtifactHubRepoID) != 0 {
+	if len(s.ArtifactHubRepoID) != 0 || len(s.artifactHubFiles) != 0 {
 		routes = append(routes, artifactHubRoutes...)
 	}
 
@@ -70,11 +109,31 @@ func (s *MultiTenantServer) Routes() []*cm_router.Route {
 	}
 
 	if s.APIEnabled {
+		// debug routes first, so they are not mistaken for a repo named "debug"
+		routes = append(routes, debugRoutes...)
+		if s.downloadStats != nil {
+			routes = append(routes, downloadStatsRoutes...)
+		}
 		routes = append(routes, chartManipulationRoutes...)
 	}
 
+	if s.APIEnabled && s.Router.IssuesPullTokens() {
+		routes = append(routes, pullTokenRoutes...)
+	}
+
+	if s.APIEnabled && s.ProvenanceKeyring != "" {
+		routes = append(routes, &cm_router.Route{Method: "GET", Path: "/api/:repo/charts/:name/:version/verify", Handler: s.verifyChartVersionRequestHandler, Action: cm_auth.PullAction})
+		routes = append(routes, &cm_router.Route{Method: "POST", Path: "/api/:repo/charts/:name/:version/verify", Handler: s.verifyChartVersionRequestHandler, Action: cm_auth.PullAction})
+	}
+
 	if s.APIEnabled && !s.DisableDelete {
-		routes = append(routes, &cm_router.Route{Method: "DELETE", Path: "/api/:repo/charts/:name/:version", Handler: s.deleteChartVersionRequestHandler, Action: cm_auth.PushAction})
+		routes = append(routes, &cm_router.Route{Method: "DELETE", Path: "/api/:repo/charts/:name/:version", Handler: s.deleteChartVersionRequestHandler, Action: cm_router.DeleteAction})
+		routes = append(routes, &cm_router.Route{Method: "DELETE", Path: "/api/:repo/charts/:name", Handler: s.deleteChartRequestHandler, Action: cm_router.DeleteAction})
+		routes = append(routes, &cm_router.Route{Method: "DELETE", Path: "/api/:repo/charts", Handler: s.deleteChartByDigestRequestHandler, Action: cm_router.DeleteAction})
+	}
+
+	for _, route := range routes {
+		route.Handler = s.storageCircuitHandler(route.Handler)
 	}
 
 	return routes
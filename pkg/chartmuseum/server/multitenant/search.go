@@ -0,0 +1,166 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	// maxSearchQueryLength bounds the queries of GET /api/:repo/charts/search
+	maxSearchQueryLength = 256
+
+	// scores of a term matching a field of a chart, a search ranking the charts by their total
+	nameExactScore    = 100
+	namePrefixScore   = 50
+	nameScore         = 30
+	keywordExactScore = 20
+	keywordScore      = 10
+	descriptionScore  = 10
+	maintainerScore   = 5
+)
+
+type (
+	// chartSearchResult is the latest version of a chart matching a search, with its score
+	chartSearchResult struct {
+		*helm_repo.ChartVersion
+		Score int `json:"score"`
+	}
+
+	// chartQuery matches the charts holding every term of a query, or matching a regexp, in their
+	// name, description, keywords or maintainers, case-insensitively
+	chartQuery struct {
+		terms  []string
+		regexp *regexp.Regexp
+	}
+)
+
+func newChartQuery(query string, regex bool) (*chartQuery, *HTTPError) {
+	if strings.TrimSpace(query) == "" {
+		return nil, &HTTPError{http.StatusBadRequest, "missing search query q"}
+	}
+	if len(query) > maxSearchQueryLength {
+		return nil, &HTTPError{http.StatusBadRequest, fmt.Sprintf("search query longer than %d characters", maxSearchQueryLength)}
+	}
+	if !regex {
+		return &chartQuery{terms: strings.Fields(strings.ToLower(query))}, nil
+	}
+	re, err := regexp.Compile("(?i)" + query)
+	if err != nil {
+		return nil, &HTTPError{http.StatusBadRequest, fmt.Sprintf("invalid search regexp: %s", err)}
+	}
+	return &chartQuery{regexp: re}, nil
+}
+
+// score ranks chart against the query, zero if it does not match
+func (q *chartQuery) score(chart *helm_repo.ChartVersion) int {
+	if q.regexp != nil {
+		return q.fieldScores(chart, func(value string) (bool, bool, bool) {
+			location := q.regexp.FindStringIndex(value)
+			if location == nil {
+				return false, false, false
+			}
+			return true, location[0] == 0, location[0] == 0 && location[1] == len(value)
+		})
+	}
+	total := 0
+	for _, term := range q.terms {
+		score := q.fieldScores(chart, func(value string) (bool, bool, bool) {
+			value = strings.ToLower(value)
+			return strings.Contains(value, term), strings.HasPrefix(value, term), value == term
+		})
+		if score == 0 {
+			return 0
+		}
+		total += score
+	}
+	return total
+}
+
+// fieldScores sums the scores of the fields of chart matched by match, which tells whether a
+// value matches, starts with a match, and is entirely matched
+func (q *chartQuery) fieldScores(chart *helm_repo.ChartVersion, match func(string) (bool, bool, bool)) int {
+	score := 0
+	if found, prefix, exact := match(chart.Name); exact {
+		score += nameExactScore
+	} else if prefix {
+		score += namePrefixScore
+	} else if found {
+		score += nameScore
+	}
+	keyword := 0
+	for _, value := range chart.Keywords {
+		if found, _, exact := match(value); exact {
+			keyword = keywordExactScore
+			break
+		} else if found {
+			keyword = keywordScore
+		}
+	}
+	score += keyword
+	if found, _, _ := match(chart.Description); found {
+		score += descriptionScore
+	}
+	for _, maintainer := range chart.Maintainers {
+		if maintainer == nil {
+			continue
+		}
+		if found, _, _ := match(maintainer.Name); found {
+			score += maintainerScore
+			break
+		}
+		if found, _, _ := match(maintainer.Email); found {
+			score += maintainerScore
+			break
+		}
+	}
+	return score
+}
+
+// searchCharts returns the latest versions of the charts of repo matching query, the best ranked
+// first, from offset and at most limit of them, or all of them if limit is -1
+func (server *MultiTenantServer) searchCharts(log cm_logger.LoggingFn, repo string, query *chartQuery, offset int, limit int) ([]chartSearchResult, *HTTPError) {
+	indexFile, err := server.getIndexFile(log, repo)
+	if err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, err.Message}
+	}
+	results := []chartSearchResult{}
+	for _, versions := range indexFile.Entries {
+		// the versions of the index are sorted, the latest first
+		if len(versions) == 0 {
+			continue
+		}
+		if score := query.score(versions[0]); score > 0 {
+			results = append(results, chartSearchResult{ChartVersion: versions[0], Score: score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Name < results[j].Name
+	})
+	return page(results, offset, limit), nil
+}
@@ -18,6 +18,7 @@ package multitenant
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
@@ -40,6 +41,8 @@ var (
 const (
 	defaultFormField = "chart"
 	defaultProvField = "prov"
+	// defaultMultipartMaxMemory is the limit used by net/http when parsing a multipart form implicitly
+	defaultMultipartMaxMemory = 32 << 20
 )
 
 type (
@@ -74,6 +77,46 @@ type (
 		WebTemplatePath       string
 		AlwaysRegenerateIndex bool
 		JSONIndex             bool
+		LegacyUploadResponse  bool
+		MinChartAPIVersion    string
+		IndexDebounce         time.Duration
+		IndexMaxStaleness     time.Duration
+		IndexDebounceRepos    map[string]string
+		UpstreamURLs          map[string]string
+		UpstreamMaxSize       int
+		UpstreamAllowedHosts  []string
+		UploadURLAllowedHosts []string
+		UploadURLMaxSize      int
+		StorageBackendName    string
+		IndexContentType      string
+		JSONIndexContentType  string
+		ProvenanceKeyring     string
+		ReconcileInterval     time.Duration
+		MultiChartUpload      bool
+		ReservedPrefixes      []string
+		ArtifactHubRepoFile   map[string]string
+		RollbackRetries       int
+		MaxIndexSize          int
+		TruncateIndex         bool
+		RequireProvenance     bool
+		MultipartMaxMemory    int64
+		HealthDetails         bool
+		CaseInsensitiveNames  bool
+		RedirectDownloads     time.Duration
+		StorageListPageSize   int
+		DownloadStatsInterval time.Duration
+		artifactHubFiles      map[string]*cm_repo.ArtifactHubFile
+		indexDebounces        map[string]time.Duration
+		upstream              *upstreamProxy
+		uploadURLClient       *http.Client
+		apiKeysLock           sync.Mutex
+		packageSizes          sync.Map
+		chartFileCache        *chartFileCache
+		indexRepresentations  *indexRepresentations
+		downloadStats         *downloadStats
+
+		// chartDeprecationsLocks serializes the changes of the deprecated chart versions of each repo
+		chartDeprecationsLocks sync.Map
 	}
 
 	ObjectsPerChartLimit struct {
@@ -108,12 +151,50 @@ type (
 		EnforceSemver2        bool
 		AlwaysRegenerateIndex bool
 		JSONIndex             bool
+		LegacyUploadResponse  bool
+		MinChartAPIVersion    string
+		IndexDebounce         time.Duration
+		IndexMaxStaleness     time.Duration
+		IndexDebounceRepos    map[string]string
+		UpstreamURLs          map[string]string
+		UpstreamMaxSize       int
+		UpstreamAllowedHosts  []string
+		UploadURLAllowedHosts []string
+		UploadURLMaxSize      int
+		StorageBackendName    string
+		IndexContentType      string
+		JSONIndexContentType  string
+		ProvenanceKeyring     string
+		ReconcileInterval     time.Duration
+		MultiChartUpload      bool
+		ReservedPrefixes      []string
+		ArtifactHubRepoFile   map[string]string
+		RollbackRetries       int
+		MaxIndexSize          int
+		TruncateIndex         bool
+		RequireProvenance     bool
+		MultipartMaxMemory    int64
+		HealthDetails         bool
+		CaseInsensitiveNames  bool
+		RedirectDownloads     time.Duration
+		StorageListPageSize   int
+		// DownloadStats is the store of the download counts of chart versions, one of memory, storage
+		// or cache, downloads are not counted if empty
+		DownloadStats         string
+		DownloadStatsInterval time.Duration
 	}
 
 	tenantInternals struct {
 		FetchedObjectsLock      *sync.Mutex
 		FetchedObjectsChans     []chan fetchedObjects
 		RegeneratedIndexesChans []chan indexRegeneration
+		PendingEventsLock       *sync.Mutex
+		// FlushEventsLock is held while a batch of pending events is applied
+		FlushEventsLock    *sync.Mutex
+		PendingEvents      []event
+		PendingEventsSince time.Time
+		PendingEventsTimer *time.Timer
+		LastReconciled     time.Time
 	}
 
 	fetchedObjects struct {
@@ -129,6 +210,27 @@ type (
 
 // NewMultiTenantServer creates a new MultiTenantServer instance
 func NewMultiTenantServer(options MultiTenantServerOptions) (*MultiTenantServer, error) {
+	if options.MinChartAPIVersion != "" {
+		if _, err := cm_repo.ParseChartAPIVersion(options.MinChartAPIVersion); err != nil {
+			return nil, fmt.Errorf("%s: %s", err, options.MinChartAPIVersion)
+		}
+	}
+	if err := validateUpstreamURLs(options.UpstreamURLs); err != nil {
+		return nil, err
+	}
+	indexDebounces, err := parseIndexDebounces(options.IndexDebounceRepos)
+	if err != nil {
+		return nil, err
+	}
+	artifactHubFiles, err := loadArtifactHubFiles(options.ArtifactHubRepoFile)
+	if err != nil {
+		return nil, err
+	}
+	downloadStats, err := newDownloadStats(options.DownloadStats, options.StorageBackend, options.ExternalCacheStore)
+	if err != nil {
+		return nil, err
+	}
+
 	var chartURL string
 	if options.ChartURL != "" {
 		chartURL = options.ChartURL + options.Router.ContextPath
@@ -169,7 +271,57 @@ func NewMultiTenantServer(options MultiTenantServerOptions) (*MultiTenantServer,
 		ArtifactHubRepoID:      options.ArtifactHubRepoID,
 		AlwaysRegenerateIndex:  options.AlwaysRegenerateIndex,
 		JSONIndex:              options.JSONIndex,
+		LegacyUploadResponse:   options.LegacyUploadResponse,
+		MinChartAPIVersion:     options.MinChartAPIVersion,
+		IndexDebounce:          options.IndexDebounce,
+		IndexMaxStaleness:      options.IndexMaxStaleness,
+		IndexDebounceRepos:     options.IndexDebounceRepos,
+		UpstreamURLs:           options.UpstreamURLs,
+		UpstreamMaxSize:        options.UpstreamMaxSize,
+		UpstreamAllowedHosts:   options.UpstreamAllowedHosts,
+		UploadURLAllowedHosts:  options.UploadURLAllowedHosts,
+		UploadURLMaxSize:       options.UploadURLMaxSize,
+		StorageBackendName:     options.StorageBackendName,
+		IndexContentType:       options.IndexContentType,
+		JSONIndexContentType:   options.JSONIndexContentType,
+		ProvenanceKeyring:      options.ProvenanceKeyring,
+		ReconcileInterval:      options.ReconcileInterval,
+		MultiChartUpload:       options.MultiChartUpload,
+		ReservedPrefixes:       append(append([]string{}, defaultReservedPrefixes...), options.ReservedPrefixes...),
+		ArtifactHubRepoFile:    options.ArtifactHubRepoFile,
+		RollbackRetries:        options.RollbackRetries,
+		MaxIndexSize:           options.MaxIndexSize,
+		TruncateIndex:          options.TruncateIndex,
+		RequireProvenance:      options.RequireProvenance,
+		MultipartMaxMemory:     options.MultipartMaxMemory,
+		HealthDetails:          options.HealthDetails,
+		CaseInsensitiveNames:   options.CaseInsensitiveNames,
+		RedirectDownloads:      options.RedirectDownloads,
+		StorageListPageSize:    options.StorageListPageSize,
+		DownloadStatsInterval:  options.DownloadStatsInterval,
+		artifactHubFiles:       artifactHubFiles,
+		indexDebounces:         indexDebounces,
+		chartFileCache:         newChartFileCache(chartFileCacheMaxSize),
+		indexRepresentations:   newIndexRepresentations(),
+		downloadStats:          downloadStats,
+	}
+	if server.DownloadStatsInterval <= 0 {
+		server.DownloadStatsInterval = defaultDownloadStatsInterval
+	}
+	if server.IndexContentType == "" {
+		server.IndexContentType = cm_repo.IndexFileContentType
 	}
+	if server.MultipartMaxMemory <= 0 {
+		server.MultipartMaxMemory = defaultMultipartMaxMemory
+	}
+	if server.JSONIndexContentType == "" {
+		server.JSONIndexContentType = cm_repo.JSONIndexFileContentType
+	}
+	if server.UploadURLMaxSize <= 0 {
+		server.UploadURLMaxSize = defaultUploadURLMaxSize
+	}
+	server.upstream = server.newUpstreamProxy()
+	server.uploadURLClient = server.newUploadURLClient()
 
 	if server.WebTemplatePath != "" {
 		// check if template file exists to avoid panic when calling LoadHTMLGlob
@@ -182,7 +334,10 @@ func NewMultiTenantServer(options MultiTenantServerOptions) (*MultiTenantServer,
 	}
 
 	server.Router.SetRoutes(server.Routes())
-	err := server.primeCache()
+	if server.APIEnabled {
+		server.Router.SetAPIKeyVerifier(server.verifyAPIKey)
+	}
+	err = server.primeCache()
 
 	if options.GenIndex && server.Router.Depth == 0 {
 		server.genIndex()
@@ -191,6 +346,7 @@ func NewMultiTenantServer(options MultiTenantServerOptions) (*MultiTenantServer,
 	server.EventChan = make(chan event, server.IndexLimit)
 	go server.startEventListener()
 	server.initCacheTimer()
+	server.initDownloadStatsTimer()
 
 	return server, err
 }
@@ -17,25 +17,42 @@ limitations under the License.
 package multitenant
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	pathutil "path"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"helm.sh/chartmuseum/pkg/cache"
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
 	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
 	"helm.sh/chartmuseum/pkg/repo"
+	cm_pkg_storage "helm.sh/chartmuseum/pkg/storage"
 
+	"github.com/alicebob/miniredis"
 	"github.com/chartmuseum/storage"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/suite"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
 	"sigs.k8s.io/yaml"
 )
 
@@ -48,6 +65,7 @@ var (
 	testTarballPathV0        = "../../../../testdata/charts/mychart/mychart-0.0.1.tgz"
 	testServiceTarballPathV0 = "../../../../testdata/charts/mychart-service/mychart-service-0.0.1.tgz"
 	testProvfilePath         = "../../../../testdata/charts/mychart/mychart-0.1.0.tgz.prov"
+	testTarballPathAPIV2     = "../../../../testdata/charts/mychart3/mychart3-0.1.0.tgz"
 	otherTestTarballPath     = "../../../../testdata/charts/otherchart/otherchart-0.1.0.tgz"
 	otherTestProvfilePath    = "../../../../testdata/charts/otherchart/otherchart-0.1.0.tgz.prov"
 	badTestTarballPath       = "../../../../testdata/badcharts/mybadchart/mybadchart-1.0.0.tgz"
@@ -72,6 +90,8 @@ type MultiTenantServerTestSuite struct {
 	ArtifactHubRepoIDServer *MultiTenantServer
 	UpdateToDateServer      *MultiTenantServer
 	CacheInternalServer     *MultiTenantServer
+	MinAPIVersionServer     *MultiTenantServer
+	IndexDebounceServer     *MultiTenantServer
 	TempDirectory           string
 	TestTarballFilename     string
 	TestProvfileFilename    string
@@ -127,6 +147,10 @@ func (suite *MultiTenantServerTestSuite) doRequest(stype string, method string,
 		suite.UpdateToDateServer.Router.HandleContext(c)
 	case "cache-interval":
 		suite.CacheInternalServer.Router.HandleContext(c)
+	case "min-chart-api-version":
+		suite.MinAPIVersionServer.Router.HandleContext(c)
+	case "index-debounce":
+		suite.IndexDebounceServer.Router.HandleContext(c)
 	}
 
 	return c.Writer
@@ -566,6 +590,46 @@ func (suite *MultiTenantServerTestSuite) SetupSuite() {
 	suite.NotNil(server)
 	suite.Nil(err, "cannot create cache interval server")
 	suite.CacheInternalServer = server
+
+	router = cm_router.NewRouter(cm_router.RouterOptions{
+		Logger:        logger,
+		Depth:         0,
+		MaxUploadSize: maxUploadSize,
+	})
+	server, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 router,
+		StorageBackend:         backend,
+		TimestampTolerance:     time.Duration(0),
+		EnableAPI:              true,
+		AllowOverwrite:         true,
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		CacheInterval:          time.Second,
+		MinChartAPIVersion:     "v2",
+	})
+	suite.NotNil(server)
+	suite.Nil(err, "no error creating new min chart api version server")
+	suite.MinAPIVersionServer = server
+
+	router = cm_router.NewRouter(cm_router.RouterOptions{
+		Logger:        logger,
+		Depth:         0,
+		MaxUploadSize: maxUploadSize,
+	})
+	server, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 router,
+		StorageBackend:         backend,
+		TimestampTolerance:     time.Duration(0),
+		EnableAPI:              true,
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		IndexDebounce:          time.Hour,
+	})
+	suite.NotNil(server)
+	suite.Nil(err, "no error creating new index debounce server")
+	suite.IndexDebounceServer = server
 }
 
 func (suite *MultiTenantServerTestSuite) TearDownSuite() {
@@ -820,6 +884,29 @@ func (suite *MultiTenantServerTestSuite) TestOverwriteServer() {
 	}
 }
 
+func (suite *MultiTenantServerTestSuite) TestUploadSavedFilesResponse() {
+	buffer := bytes.NewBufferString("")
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+	res := suite.doRequest("overwrite", "POST", "/api/charts", buf, w.FormDataContentType(), buffer)
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+
+	var saved struct {
+		Saved []string `json:"saved"`
+	}
+	err := json.Unmarshal(buffer.Bytes(), &saved)
+	suite.Nil(err, "no error parsing upload response")
+	suite.Equal([]string{"mychart-0.1.0.tgz", "mychart-0.1.0.tgz.prov"}, saved.Saved, "stored files listed in upload response")
+
+	suite.OverwriteServer.LegacyUploadResponse = true
+	defer func() { suite.OverwriteServer.LegacyUploadResponse = false }()
+
+	buffer = bytes.NewBufferString("")
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+	res = suite.doRequest("overwrite", "POST", "/api/charts", buf, w.FormDataContentType(), buffer)
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+	suite.JSONEq(`{"saved": true}`, buffer.String(), "legacy upload response")
+}
+
 func (suite *MultiTenantServerTestSuite) TestBadChartUpload() {
 	content, err := os.ReadFile(badTestTarballPath)
 	suite.Nil(err, "no error opening test tarball")
@@ -951,6 +1038,467 @@ func (suite *MultiTenantServerTestSuite) TestPerChartLimit() {
 	suite.Equal(200, res.Status(), "200 GET /api/charts/mychart-service-0.0.1")
 }
 
+func (suite *MultiTenantServerTestSuite) TestMinAPIVersionServer() {
+	ns := "min-chart-api-version"
+
+	// mychart is packaged with apiVersion: v1
+	content, err := os.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	body := bytes.NewBuffer(content)
+	res := suite.doRequest(ns, "POST", "/api/charts", body, "")
+	suite.Equal(400, res.Status(), "400 POST /api/charts (apiVersion v1)")
+
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+	res = suite.doRequest(ns, "POST", "/api/charts", buf, w.FormDataContentType())
+	suite.Equal(400, res.Status(), "400 POST /api/charts (apiVersion v1, multipart)")
+
+	// mychart3 is packaged with apiVersion: v2
+	content, err = os.ReadFile(testTarballPathAPIV2)
+	suite.Nil(err, "no error opening test tarball")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest(ns, "POST", "/api/charts", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/charts (apiVersion v2)")
+
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPathAPIV2})
+	res = suite.doRequest(ns, "POST", "/api/charts", buf, w.FormDataContentType())
+	suite.Equal(201, res.Status(), "201 POST /api/charts (apiVersion v2, multipart)")
+
+	res = suite.doRequest(ns, "DELETE", "/api/charts/mychart3/0.1.0", nil, "")
+	suite.Equal(200, res.Status(), "200 DELETE /api/charts/mychart3/0.1.0")
+
+	_, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:             suite.Depth0Server.Logger,
+		Router:             cm_router.NewRouter(cm_router.RouterOptions{Logger: suite.Depth0Server.Logger}),
+		StorageBackend:     suite.Depth0Server.StorageBackend,
+		MinChartAPIVersion: "2",
+	})
+	suite.NotNil(err, "error creating server with invalid min chart api version")
+}
+
+func (suite *MultiTenantServerTestSuite) TestUpstreamProxy() {
+	content, err := os.ReadFile(otherTestTarballPath)
+	suite.Nil(err, "no error opening other test tarball")
+
+	upstreamIndex := `apiVersion: v1
+entries:
+  otherchart:
+  - name: otherchart
+    version: 0.1.0
+    digest: %s
+    urls:
+    - otherchart-0.1.0.tgz
+  elsewhere:
+  - name: elsewhere
+    version: 0.1.0
+    urls:
+    - http://elsewhere.example.com/elsewhere-0.1.0.tgz
+`
+	digest := fmt.Sprintf("%x", sha256.Sum256([]byte("tampered")))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.yaml":
+			fmt.Fprintf(w, upstreamIndex, digest)
+		case "/otherchart-0.1.0.tgz":
+			w.Write(content)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:         storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "upstream-proxy")),
+		TimestampTolerance:     time.Duration(0),
+		EnableAPI:              true,
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		UpstreamURLs:           map[string]string{"": upstream.URL},
+		UpstreamMaxSize:        maxUploadSize,
+	})
+	suite.Nil(err, "no error creating upstream proxy server")
+
+	get := func(urlStr string) (int, string) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", urlStr, nil)
+		server.Router.HandleContext(c)
+		return c.Writer.Status(), recorder.Body.String()
+	}
+
+	status, body := get("/index.yaml")
+	suite.Equal(200, status, "200 GET /index.yaml")
+	suite.Contains(body, "charts/otherchart-0.1.0.tgz", "upstream chart merged in index")
+	suite.Contains(body, "charts/elsewhere-0.1.0.tgz", "upstream chart served through proxy")
+
+	status, _ = get("/charts/otherchart-0.1.0.tgz")
+	suite.Equal(502, status, "502 GET /charts/otherchart-0.1.0.tgz not matching the upstream digest")
+	suite.NoFileExists(pathutil.Join(suite.TempDirectory, "upstream-proxy", "otherchart-0.1.0.tgz"), "package not stored")
+
+	digest = fmt.Sprintf("%x", sha256.Sum256(content))
+	suite.Equal(1, server.upstream.flush(""), "upstream index flushed")
+	status, body = get("/charts/otherchart-0.1.0.tgz")
+	suite.Equal(200, status, "200 GET /charts/otherchart-0.1.0.tgz from upstream")
+	suite.Equal(string(content), body, "upstream chart content")
+
+	status, _ = get("/charts/elsewhere-0.1.0.tgz")
+	suite.Equal(502, status, "502 GET /charts/elsewhere-0.1.0.tgz from a host not allowed")
+
+	status, _ = get("/charts/missing-0.1.0.tgz")
+	suite.Equal(404, status, "404 GET /charts/missing-0.1.0.tgz missing upstream")
+
+	server.UpstreamMaxSize = 10
+	_, err = server.fetchUpstream(&url.URL{Scheme: "http", Host: strings.TrimPrefix(upstream.URL, "http://"), Path: "/index.yaml"})
+	suite.NotNil(err, "error fetching upstream object over max size")
+	server.UpstreamMaxSize = maxUploadSize
+
+	upstream.Close()
+	status, body = get("/charts/otherchart-0.1.0.tgz")
+	suite.Equal(200, status, "200 GET /charts/otherchart-0.1.0.tgz from local storage")
+	suite.Equal(string(content), body, "stored chart content")
+
+	_, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend: suite.Depth0Server.StorageBackend,
+		UpstreamURLs:   map[string]string{"": "ftp://charts.example.com"},
+	})
+	suite.NotNil(err, "error creating server with invalid upstream repo url")
+}
+
+func (suite *MultiTenantServerTestSuite) TestChartVersionParam() {
+	res := suite.doRequest("depth0", "GET", "/api/charts/mychart/v0.1.0", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /api/charts/mychart/v0.1.0")
+
+	res = suite.doRequest("depth0", "HEAD", "/api/charts/mychart/v0.1.0", nil, "")
+	suite.Equal(200, res.Status(), "200 HEAD /api/charts/mychart/v0.1.0")
+
+	res = suite.doRequest("depth0", "GET", "/api/charts/mychart/latest", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /api/charts/mychart/latest")
+
+	// constraints are looked up in the index
+	for _, version := range []string{"0.1", "~0.1", "%3E%3D0.1.0%20%3C0.2.0"} {
+		res = suite.doRequest("depth0", "GET", "/api/charts/mychart/"+version, nil, "")
+		suite.Equal(200, res.Status(), fmt.Sprintf("200 GET /api/charts/mychart/%s", version))
+	}
+
+	for _, version := range []string{"..", "0.1.0..", "..%5C0.1.0", "%20"} {
+		res = suite.doRequest("depth0", "GET", "/api/charts/mychart/"+version, nil, "")
+		suite.Equal(400, res.Status(), fmt.Sprintf("400 GET /api/charts/mychart/%s", version))
+
+		res = suite.doRequest("depth0", "DELETE", "/api/charts/mychart/"+version, nil, "")
+		suite.Equal(400, res.Status(), fmt.Sprintf("400 DELETE /api/charts/mychart/%s", version))
+	}
+
+	res = suite.doRequest("depth0", "DELETE", "/api/charts/mychart/latest", nil, "")
+	suite.Equal(400, res.Status(), "400 DELETE /api/charts/mychart/latest")
+
+	// an escaped slash never reaches the handlers
+	res = suite.doRequest("depth0", "DELETE", "/api/charts/mychart/0.1.0%2F..%2F", nil, "")
+	suite.Equal(404, res.Status(), "404 DELETE /api/charts/mychart/0.1.0%2F..%2F")
+
+	// packages of versions written with a v prefix are deleted by the version as stored
+	dir := pathutil.Join(suite.TempDirectory, "version-param")
+	for _, version := range []string{"v1.2.3", "v1.2.4"} {
+		ch := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "vchart", Version: version}}
+		_, err := chartutil.Save(ch, dir)
+		suite.Nil(err, "no error saving vchart %s", version)
+	}
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating server")
+	deleteVersion := func(version string) int {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("DELETE", "/api/charts/vchart/"+version, nil)
+		server.Router.HandleContext(c)
+		return c.Writer.Status()
+	}
+	for version, filename := range map[string]string{"1.2.3": "vchart-v1.2.3.tgz", "v1.2.4": "vchart-v1.2.4.tgz"} {
+		suite.Equal(200, deleteVersion(version), fmt.Sprintf("200 DELETE /api/charts/vchart/%s", version))
+		_, err = os.Stat(pathutil.Join(dir, filename))
+		suite.True(os.IsNotExist(err), "%s deleted", filename)
+	}
+	suite.Eventually(func() bool {
+		indexFile, err := server.getIndexFile(logger.ContextLoggingFn(&gin.Context{}), "")
+		if err != nil {
+			return false
+		}
+		indexFile.IndexLock.RLock()
+		defer indexFile.IndexLock.RUnlock()
+		return !indexFile.Has("vchart", "v1.2.3") && !indexFile.Has("vchart", "v1.2.4")
+	}, time.Second, 10*time.Millisecond, "deleted versions removed from the index")
+}
+
+func (suite *MultiTenantServerTestSuite) TestIndexContentType() {
+	res := suite.doRequest("depth0", "GET", "/index.yaml", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /index.yaml")
+	suite.Equal("application/x-yaml", res.Header().Get("Content-Type"), "default index content type")
+
+	suite.Depth0Server.IndexContentType = "application/x-yaml; charset=utf-8"
+	defer func() { suite.Depth0Server.IndexContentType = repo.IndexFileContentType }()
+	res = suite.doRequest("depth0", "GET", "/index.yaml", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /index.yaml")
+	suite.Equal("application/x-yaml; charset=utf-8", res.Header().Get("Content-Type"), "configured index content type")
+
+	suite.Depth0Server.JSONIndex = true
+	defer func() { suite.Depth0Server.JSONIndex = false }()
+	suite.Equal("application/json", suite.Depth0Server.indexFileContentType(), "default JSON index content type")
+}
+
+func (suite *MultiTenantServerTestSuite) TestVerifyChartVersion() {
+	res := suite.doRequest("depth0", "POST", "/api/charts/mychart/0.1.0/verify", nil, "")
+	suite.Equal(404, res.Status(), "404 POST /api/charts/mychart/0.1.0/verify without keyring")
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:            logger,
+		Router:            cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:    suite.Depth0Server.StorageBackend,
+		EnableAPI:         true,
+		ProvenanceKeyring: "../../../../testdata/pgp/helm-test-key.pub",
+	})
+	suite.Nil(err, "no error creating server with provenance keyring")
+
+	verify := func(urlStr string) (int, string) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("POST", urlStr, nil)
+		server.Router.HandleContext(c)
+		return c.Writer.Status(), recorder.Body.String()
+	}
+
+	status, body := verify("/api/charts/mychart/0.1.0/verify")
+	suite.Equal(200, status, "200 POST /api/charts/mychart/0.1.0/verify")
+	var result struct {
+		Verified bool   `json:"verified"`
+		Key      string `json:"key"`
+	}
+	suite.Nil(json.Unmarshal([]byte(body), &result), "no error parsing verify response")
+	suite.True(result.Verified, "chart verified")
+	suite.NotEmpty(result.Key, "signing key returned")
+
+	status, _ = verify("/api/charts/mychart/9.9.9/verify")
+	suite.Equal(404, status, "404 POST /api/charts/mychart/9.9.9/verify")
+
+	dir := pathutil.Join(suite.TempDirectory, "verify")
+	backend := storage.NewLocalFilesystemBackend(dir)
+	for filename, path := range map[string]string{
+		"mychart-0.1.0.tgz":      testTarballPath,
+		"mychart-0.1.0.tgz.prov": testProvfilePath,
+		"mychart-0.2.0.tgz":      "../../../../testdata/charts/mychart/mychart-0.2.0.tgz",
+		"mychart-0.2.0.tgz.prov": testProvfilePath,
+		"otherchart-0.1.0.tgz":   otherTestTarballPath,
+	} {
+		content, err := os.ReadFile(path)
+		suite.Nil(err, "no error reading %s", path)
+		suite.Nil(backend.PutObject(filename, content), "no error storing %s", filename)
+	}
+	server, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:            logger,
+		Router:            cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:    backend,
+		EnableAPI:         true,
+		ProvenanceKeyring: "../../../../testdata/pgp/helm-test-key.pub",
+	})
+	suite.Nil(err, "no error creating verify server")
+	get := func(urlStr string) (int, *chartVerification) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", urlStr, nil)
+		server.Router.HandleContext(c)
+		verification := &chartVerification{}
+		suite.Nil(json.Unmarshal(recorder.Body.Bytes(), verification), "no error parsing GET %s", urlStr)
+		return c.Writer.Status(), verification
+	}
+
+	content, err := os.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test tarball")
+	digest := fmt.Sprintf("%x", sha256.Sum256(content))
+	status, verification := get("/api/charts/mychart/0.1.0/verify")
+	suite.Equal(200, status, "200 GET /api/charts/mychart/0.1.0/verify")
+	suite.True(verification.Verified, "chart verified")
+	suite.Equal(verificationStatusVerified, verification.Status)
+	suite.Equal("mychart", verification.Name)
+	suite.Equal("0.1.0", verification.Version)
+	suite.Equal(digest, verification.Digest, "digest of the stored package")
+	suite.Equal(digest, verification.IndexDigest, "digest of the index")
+	suite.NotNil(verification.Signer, "signer returned")
+	suite.NotEmpty(verification.Signer.Identities, "identities of the signer")
+	suite.Len(verification.Signer.Fingerprint, 40, "fingerprint of the signer")
+	suite.Equal(strings.Join(verification.Signer.Identities, ", "), verification.Key)
+
+	status, verification = get("/api/charts/mychart/0.2.0/verify")
+	suite.Equal(200, status, "200 GET /api/charts/mychart/0.2.0/verify")
+	suite.False(verification.Verified, "provenance file of another package")
+	suite.Equal(verificationStatusInvalid, verification.Status)
+	suite.NotEmpty(verification.Error)
+	suite.Nil(verification.Signer)
+
+	status, verification = get("/api/charts/otherchart/latest/verify")
+	suite.Equal(200, status, "200 GET /api/charts/otherchart/latest/verify")
+	suite.False(verification.Verified, "no provenance file")
+	suite.Equal(verificationStatusUnsigned, verification.Status)
+	suite.Equal("0.1.0", verification.Version, "latest version resolved")
+
+	// the index recording another package than the stored one, as if overwritten since indexed
+	index, herr := server.getIndexFile(logger.ContextLoggingFn(&gin.Context{}), "")
+	suite.Nil(herr, "no error getting the index")
+	index.IndexLock.Lock()
+	for _, chartVersion := range index.Entries["mychart"] {
+		if chartVersion.Version == "0.1.0" {
+			chartVersion.Digest = strings.Repeat("0", 64)
+		}
+	}
+	index.IndexLock.Unlock()
+	status, verification = get("/api/charts/mychart/0.1.0/verify")
+	suite.Equal(200, status, "200 GET /api/charts/mychart/0.1.0/verify with another digest indexed")
+	suite.False(verification.Verified, "stored package not the one indexed")
+	suite.Equal(verificationStatusDigestMismatch, verification.Status)
+	suite.Equal(digest, verification.Digest)
+	suite.NotNil(verification.Signer, "signer of the stored package returned")
+}
+
+func (suite *MultiTenantServerTestSuite) TestReconcileIndex() {
+	dir := pathutil.Join(suite.TempDirectory, "reconcile")
+	backend := storage.NewLocalFilesystemBackend(dir)
+	content, err := os.ReadFile(otherTestTarballPath)
+	suite.Nil(err, "no error opening other test tarball")
+	suite.Nil(backend.PutObject("otherchart-0.1.0.tgz", content), "no error storing other test tarball")
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend: backend,
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating reconcile server")
+
+	get := func(urlStr string) (int, string) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", urlStr, nil)
+		server.Router.HandleContext(c)
+		return c.Writer.Status(), recorder.Body.String()
+	}
+
+	_, body := get("/index.yaml")
+	suite.Contains(body, "otherchart-0.1.0.tgz", "chart in index")
+
+	// deleted out-of-band, the cached index is served as is
+	suite.Nil(backend.DeleteObject("otherchart-0.1.0.tgz"), "no error deleting other test tarball")
+	_, body = get("/index.yaml")
+	suite.Contains(body, "otherchart-0.1.0.tgz", "phantom chart in cached index")
+
+	// a download of the phantom chart reconciles the index
+	status, _ := get("/charts/otherchart-0.1.0.tgz")
+	suite.Equal(404, status, "404 GET /charts/otherchart-0.1.0.tgz")
+	_, body = get("/index.yaml")
+	suite.NotContains(body, "otherchart-0.1.0.tgz", "phantom chart dropped after failed download")
+
+	server.ReconcileInterval = time.Nanosecond
+	suite.Nil(backend.PutObject("otherchart-0.1.0.tgz", content), "no error storing other test tarball")
+	_, body = get("/index.yaml")
+	suite.Contains(body, "otherchart-0.1.0.tgz", "chart uploaded out-of-band added by reconciliation")
+
+	suite.Nil(backend.DeleteObject("otherchart-0.1.0.tgz"), "no error deleting other test tarball")
+	_, body = get("/index.yaml")
+	suite.NotContains(body, "otherchart-0.1.0.tgz", "chart deleted out-of-band dropped by reconciliation")
+}
+
+func (suite *MultiTenantServerTestSuite) TestTenantSettings() {
+	defer suite.Depth0Server.StorageBackend.DeleteObject(tenantSettingsFilename)
+
+	buffer := bytes.NewBufferString("")
+	res := suite.doRequest("depth0", "GET", "/api/settings", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 GET /api/settings")
+	suite.JSONEq(`{"allow_overwrite": null, "require_provenance": null}`, buffer.String(), "no overwrite override")
+
+	content, err := os.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	res = suite.doRequest("depth0", "POST", "/api/charts", bytes.NewBuffer(content), "")
+	suite.Equal(409, res.Status(), "409 POST /api/charts without overwrite")
+
+	res = suite.doRequest("depth0", "PUT", "/api/settings", bytes.NewBufferString(`{"allow_overwrite": true}`), "application/json")
+	suite.Equal(200, res.Status(), "200 PUT /api/settings")
+
+	buffer = bytes.NewBufferString("")
+	res = suite.doRequest("depth0", "GET", "/api/settings", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 GET /api/settings")
+	suite.JSONEq(`{"allow_overwrite": true, "require_provenance": null}`, buffer.String(), "overwrite allowed for repo")
+
+	res = suite.doRequest("depth0", "POST", "/api/charts", bytes.NewBuffer(content), "")
+	suite.Equal(201, res.Status(), "201 POST /api/charts with overwrite allowed for repo")
+
+	res = suite.doRequest("depth0", "PUT", "/api/settings", bytes.NewBufferString(`{"allow_overwrite": null}`), "application/json")
+	suite.Equal(200, res.Status(), "200 PUT /api/settings")
+	res = suite.doRequest("depth0", "POST", "/api/charts", bytes.NewBuffer(content), "")
+	suite.Equal(409, res.Status(), "409 POST /api/charts with override removed")
+
+	res = suite.doRequest("depth0", "PUT", "/api/settings", bytes.NewBufferString(`{"allow_overwrite": "yes"}`), "application/json")
+	suite.Equal(400, res.Status(), "400 PUT /api/settings with invalid settings")
+
+	res = suite.doRequest("depth1", "GET", "/api/org1/settings", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /api/org1/settings")
+}
+
+// unreadableObjectBackend fails to read the objects named filename, in any repo
+type unreadableObjectBackend struct {
+	storage.Backend
+	filename string
+}
+
+func (b *unreadableObjectBackend) GetObject(path string) (storage.Object, error) {
+	if pathutil.Base(path) == b.filename {
+		return storage.Object{}, errors.New("connection timed out")
+	}
+	return b.Backend.GetObject(path)
+}
+
+func (suite *MultiTenantServerTestSuite) TestTenantSettingsUnreadable() {
+	backend := &unreadableObjectBackend{Backend: cm_pkg_storage.NewMemoryBackend(), filename: tenantSettingsFilename}
+	for filename, path := range map[string]string{"mychart-0.1.0.tgz": testTarballPath, "mychart-0.1.0.tgz.prov": testProvfilePath} {
+		content, err := os.ReadFile(path)
+		suite.Nil(err, "no error reading %s", path)
+		suite.Nil(backend.PutObject(filename, content), "no error storing %s", filename)
+	}
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend: backend,
+		EnableAPI:      true,
+		AllowOverwrite: true,
+	})
+	suite.Nil(err, "no error creating server")
+	do := func(method string, urlStr string, path string) int {
+		content, err := os.ReadFile(path)
+		suite.Nil(err, "no error reading %s", path)
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest(method, urlStr, bytes.NewBuffer(content))
+		server.Router.HandleContext(c)
+		return c.Writer.Status()
+	}
+
+	suite.Equal(500, do("GET", "/api/settings", os.DevNull), "500 GET /api/settings")
+	suite.Equal(500, do("POST", "/api/charts", testTarballPath), "500 POST /api/charts overwriting without the repo settings")
+	suite.Equal(500, do("POST", "/api/prov", testProvfilePath), "500 POST /api/prov without the repo settings")
+
+	// provenance may be required by the repo settings
+	server.AllowOverwrite = false
+	suite.Equal(500, do("POST", "/api/charts", otherTestTarballPath), "500 POST /api/charts without the repo settings")
+	_, err = backend.GetObject("otherchart-0.1.0.tgz")
+	suite.True(cm_pkg_storage.IsNotFound(err), "chart not stored without the repo settings")
+}
+
 func (suite *MultiTenantServerTestSuite) TestMaxUploadSizeServer() {
 	// trigger 413s, "request too large"
 	content, err := os.ReadFile(testTarballPath)
@@ -1032,81 +1580,2580 @@ func (suite *MultiTenantServerTestSuite) TestCacheInterval() {
 	suite.Equal(200, res.Status(), "200 GET /api/charts/mychart-0.1.0")
 }
 
-func (suite *MultiTenantServerTestSuite) TestArtifactHubRepoID() {
-	buffer := bytes.NewBufferString("")
-	res := suite.doRequest("artifacthub", "GET", "/artifacthub-repo.yml", nil, "", buffer)
-	suite.Equal(200, res.Status(), "200 GET /artifacthub-repo.yml")
+func (suite *MultiTenantServerTestSuite) TestFlushCache() {
+	res := suite.doRequest("depth1", "GET", "/org1/index.yaml", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /org1/index.yaml")
 
-	artifactHubYmlString := buffer.Bytes()
-	artifactHubYmlFile := &repo.ArtifactHubFile{}
-	yaml.Unmarshal(artifactHubYmlString, artifactHubYmlFile)
-	suite.Equal(artifactHubYmlFile.RepoID, suite.ArtifactHubIds[""])
-}
+	server := suite.Depth1Server
+	server.chartFileCache.add("org1", "digest", "values.yaml", []byte("replicas: 1"))
+	server.chartFileCache.add("org2", "digest", "values.yaml", []byte("replicas: 2"))
+	server.recordPackageSize("org1", "flushed-0.1.0.tgz", 10)
 
-func (suite *MultiTenantServerTestSuite) TestRoutes() {
-	suite.testAllRoutes("", 0)
-	for org, teams := range suite.StorageDirectory {
-		suite.testAllRoutes(org, 1)
-		for team, repos := range teams {
-			suite.testAllRoutes(pathutil.Join(org, team), 2)
-			for _, repo := range repos {
-				suite.testAllRoutes(pathutil.Join(org, team, repo), 3)
-			}
-		}
+	buffer := bytes.NewBufferString("")
+	res = suite.doRequest("depth1", "POST", "/api/debug/flush-cache?repo=org1", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 POST /api/debug/flush-cache?repo=org1")
+	suite.JSONEq(`{"flushed": 3}`, buffer.String(), "org1 cache entry, chart file and package size flushed")
+	_, found := server.chartFileCache.get("org1", "digest", "values.yaml")
+	suite.False(found, "org1 chart file flushed")
+	_, found = server.chartFileCache.get("org2", "digest", "values.yaml")
+	suite.True(found, "org2 chart file kept")
+	_, found = server.packageSizes.Load("org1/flushed-0.1.0.tgz")
+	suite.False(found, "org1 package size flushed")
+
+	buffer = bytes.NewBufferString("")
+	res = suite.doRequest("depth1", "POST", "/api/debug/flush-cache?repo=org1", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 POST /api/debug/flush-cache?repo=org1")
+	suite.JSONEq(`{"flushed": 0}`, buffer.String(), "org1 cache entry already flushed")
+
+	res = suite.doRequest("depth1", "GET", "/org1/index.yaml", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /org1/index.yaml after flush")
+
+	var flushed struct {
+		Flushed int `json:"flushed"`
 	}
+	buffer = bytes.NewBufferString("")
+	res = suite.doRequest("depth1", "POST", "/api/debug/flush-cache", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 POST /api/debug/flush-cache")
+	err := json.Unmarshal(buffer.Bytes(), &flushed)
+	suite.Nil(err, "no error parsing flush response")
+	suite.GreaterOrEqual(flushed.Flushed, 1, "all cache entries flushed")
+
+	res = suite.doRequest("disabled", "POST", "/api/debug/flush-cache", nil, "")
+	suite.Equal(404, res.Status(), "404 POST /api/debug/flush-cache with API disabled")
 }
 
-func (suite *MultiTenantServerTestSuite) testAllRoutes(repo string, depth int) {
-	var res gin.ResponseWriter
+func (suite *MultiTenantServerTestSuite) TestIndexDebounce() {
+	server := suite.IndexDebounceServer
+	res := suite.doRequest("index-debounce", "GET", "/index.yaml", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /index.yaml")
 
-	stype := fmt.Sprintf("depth%d", depth)
+	pendingEvents := func() int {
+		tenant := server.Tenants[""]
+		tenant.PendingEventsLock.Lock()
+		defer tenant.PendingEventsLock.Unlock()
+		return len(tenant.PendingEvents)
+	}
+	forceIndex := func() string {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", "/index.yaml", nil)
+		c.Request.Header.Set("Cache-Control", "no-cache")
+		server.Router.HandleContext(c)
+		suite.Equal(200, c.Writer.Status(), "200 GET /index.yaml with Cache-Control: no-cache")
+		return recorder.Body.String()
+	}
 
-	// GET /
-	res = suite.doRequest(stype, "GET", "/", nil, "")
-	suite.Equal(200, res.Status(), "200 GET /")
+	content, err := os.ReadFile(otherTestTarballPath)
+	suite.Nil(err, "no error opening other test tarball")
+	res = suite.doRequest("index-debounce", "POST", "/api/charts", bytes.NewBuffer(content), "")
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+	suite.Eventually(func() bool { return pendingEvents() == 1 }, time.Second, 10*time.Millisecond, "upload event pending")
 
-	// GET /health
-	res = suite.doRequest(stype, "GET", "/health", nil, "")
-	suite.Equal(200, res.Status(), "200 GET /health")
+	buffer := bytes.NewBufferString("")
+	res = suite.doRequest("index-debounce", "GET", "/index.yaml", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 GET /index.yaml")
+	suite.NotContains(buffer.String(), "otherchart", "cached index served during debounce window")
+
+	suite.Contains(forceIndex(), "otherchart", "index regenerated with Cache-Control: no-cache")
+	suite.Equal(0, pendingEvents(), "no pending events after forced regeneration")
+
+	res = suite.doRequest("index-debounce", "DELETE", "/api/charts/otherchart/0.1.0", nil, "")
+	suite.Equal(200, res.Status(), "200 DELETE /api/charts/otherchart/0.1.0")
+	suite.Eventually(func() bool { return pendingEvents() == 1 }, time.Second, 10*time.Millisecond, "delete event pending")
+	suite.NotContains(forceIndex(), "otherchart", "index regenerated after delete")
+
+	now := time.Now()
+	suite.Equal(time.Hour, server.debounceDelay("", now, now.Add(-5*time.Minute)), "delay not capped without max staleness")
+	server.IndexMaxStaleness = 10 * time.Minute
+	defer func() { server.IndexMaxStaleness = 0 }()
+	suite.Equal(5*time.Minute, server.debounceDelay("", now, now.Add(-5*time.Minute)), "delay capped by max staleness")
+	suite.Equal(time.Duration(0), server.debounceDelay("", now, now.Add(-time.Hour)), "no delay past max staleness")
+
+	debounces, err := parseIndexDebounces(map[string]string{"org1/busy": "2m", "org1/eager": "0"})
+	suite.Nil(err, "no error parsing index debounces")
+	server.indexDebounces = debounces
+	defer func() { server.indexDebounces = nil }()
+	suite.Equal(2*time.Minute, server.debounceDelay("org1/busy", now, now), "debounce of the repo")
+	suite.Equal(time.Duration(0), server.indexDebounce("org1/eager"), "debounce disabled for the repo")
+	suite.Equal(time.Hour, server.indexDebounce("org1/other"), "server debounce for the other repos")
+	_, err = parseIndexDebounces(map[string]string{"org1/busy": "soon"})
+	suite.NotNil(err, "invalid index debounce")
+}
 
-	var repoPrefix string
-	if repo != "" {
-		repoPrefix = pathutil.Join("/", repo)
-	} else {
-		repoPrefix = ""
+func (suite *MultiTenantServerTestSuite) TestStats() {
+	buffer := bytes.NewBufferString("")
+	res := suite.doRequest("depth0", "GET", "/api/debug/stats", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 GET /api/debug/stats")
+
+	var stats map[string]int64
+	err := json.Unmarshal(buffer.Bytes(), &stats)
+	suite.Nil(err, "no error parsing stats")
+	suite.GreaterOrEqual(stats["requests_in_flight"], int64(1), "stats request in flight")
+	for _, key := range []string{"uploads_in_flight", "index_workers_busy", "index_worker_limit", "index_events_queued"} {
+		suite.Contains(stats, key, fmt.Sprintf("%s in stats", key))
 	}
 
-	// GET /:repo/index.yaml
-	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/index.yaml", repoPrefix), nil, "")
-	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/index.yaml", repoPrefix))
-
-	// HEAD /:repo/index.yaml
-	res = suite.doRequest(stype, "HEAD", fmt.Sprintf("%s/index.yaml", repoPrefix), nil, "")
-	suite.Equal(200, res.Status(), fmt.Sprintf("200 HEAD %s/index.yaml", repoPrefix))
-
-	// GET /:repo/artifacthub-repo.yaml
-	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/artifacthub-repo.yml", repoPrefix), nil, "")
-	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/artifacthub-repo.yml", repoPrefix))
+	families, err := prometheus.DefaultGatherer.Gather()
+	suite.Nil(err, "no error gathering metrics")
+	gauges := map[string]bool{}
+	for _, family := range families {
+		gauges[family.GetName()] = true
+	}
+	for _, name := range []string{"chartmuseum_requests_in_flight", "chartmuseum_uploads_in_flight", "chartmuseum_index_workers_busy", "chartmuseum_index_events_queued"} {
+		suite.True(gauges[name], fmt.Sprintf("%s gauge registered", name))
+	}
 
-	// Issue #21
-	suite.NotEqual("", res.Header().Get("X-Request-Id"), "X-Request-Id header is present")
-	suite.Equal("", res.Header().Get("X-Blah-Blah-Blah"), "X-Blah-Blah-Blah header is not present")
+	res = suite.doRequest("disabled", "GET", "/api/debug/stats", nil, "")
+	suite.Equal(404, res.Status(), "404 GET /api/debug/stats with API disabled")
+}
 
-	// GET /:repo/charts/:filename
-	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart-0.1.0.tgz", repoPrefix), nil, "")
-	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/mychart-0.1.0.tgz", repoPrefix))
+func (suite *MultiTenantServerTestSuite) TestIndexVaryAndETags() {
+	getIndex := func(headers map[string]string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", "/index.yaml", nil)
+		for k, v := range headers {
+			c.Request.Header.Set(k, v)
+		}
+		suite.Depth0Server.Router.HandleContext(c)
+		c.Writer.WriteHeaderNow()
+		return recorder
+	}
 
-	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart-0.1.0.tgz.prov", repoPrefix), nil, "")
-	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/mychart-0.1.0.tgz.prov", repoPrefix))
+	identity := getIndex(nil)
+	suite.Equal(200, identity.Code, "200 GET /index.yaml")
+	suite.Equal("Accept-Encoding", identity.Header().Get("Vary"), "Vary header on identity index")
+	suite.Empty(identity.Header().Get("Content-Encoding"), "identity index not compressed")
+	identityETag := identity.Header().Get("ETag")
+	suite.True(strings.HasPrefix(identityETag, `W/"`), "weak ETag on identity index")
+
+	gzipped := getIndex(map[string]string{"Accept-Encoding": "gzip, deflate"})
+	suite.Equal(200, gzipped.Code, "200 GET /index.yaml with gzip")
+	suite.Equal("Accept-Encoding", gzipped.Header().Get("Vary"), "Vary header on gzip index")
+	suite.Equal("gzip", gzipped.Header().Get("Content-Encoding"), "gzip index compressed")
+	gzipETag := gzipped.Header().Get("ETag")
+	suite.True(strings.HasPrefix(gzipETag, `W/"`), "weak ETag on gzip index")
+	suite.NotEqual(identityETag, gzipETag, "distinct ETags per representation")
+
+	gz, err := gzip.NewReader(gzipped.Body)
+	suite.Nil(err, "no error reading gzip index")
+	content, err := io.ReadAll(gz)
+	suite.Nil(err, "no error decompressing gzip index")
+	suite.Equal(identity.Body.String(), string(content), "same index in both representations")
+
+	res := getIndex(map[string]string{"If-None-Match": identityETag})
+	suite.Equal(304, res.Code, "304 GET /index.yaml with identity ETag")
+	res = getIndex(map[string]string{"If-None-Match": identityETag, "Accept-Encoding": "gzip"})
+	suite.Equal(200, res.Code, "200 GET /index.yaml with identity ETag, gzip accepted")
+	res = getIndex(map[string]string{"If-None-Match": gzipETag, "Accept-Encoding": "gzip"})
+	suite.Equal(304, res.Code, "304 GET /index.yaml with gzip ETag")
+	res = getIndex(map[string]string{"If-None-Match": `"other", ` + identityETag})
+	suite.Equal(304, res.Code, "304 GET /index.yaml with the ETag in a list")
+	res = getIndex(map[string]string{"If-None-Match": "*"})
+	suite.Equal(304, res.Code, "304 GET /index.yaml with any ETag")
+
+	lastModified := identity.Header().Get("Last-Modified")
+	generated, err := http.ParseTime(lastModified)
+	suite.Nil(err, "Last-Modified of the index")
+	res = getIndex(map[string]string{"If-Modified-Since": lastModified})
+	suite.Equal(304, res.Code, "304 GET /index.yaml not modified since Last-Modified")
+	suite.Empty(res.Body.String(), "no body when not modified")
+	res = getIndex(map[string]string{"If-Modified-Since": generated.Add(-time.Second).Format(http.TimeFormat)})
+	suite.Equal(200, res.Code, "200 GET /index.yaml modified since")
+	res = getIndex(map[string]string{"If-Modified-Since": lastModified, "If-None-Match": `"other"`})
+	suite.Equal(200, res.Code, "200 GET /index.yaml, If-Modified-Since ignored with If-None-Match")
+
+	// regenerating an unchanged index keeps its ETag and Last-Modified
+	regenerated := suite.doRequest("depth0", "POST", "/api/index/regenerate", nil, "")
+	suite.Equal(200, regenerated.Status(), "200 POST /api/index/regenerate")
+	identity = getIndex(nil)
+	regenerated = suite.doRequest("depth0", "POST", "/api/index/regenerate", nil, "")
+	suite.Equal(200, regenerated.Status(), "200 POST /api/index/regenerate again")
+	res = getIndex(map[string]string{"If-None-Match": identity.Header().Get("ETag")})
+	suite.Equal(304, res.Code, "304 GET /index.yaml with identity ETag after regeneration")
+	suite.Equal(identity.Header().Get("Last-Modified"), res.Header().Get("Last-Modified"), "same Last-Modified after regeneration")
+	res = getIndex(map[string]string{"Accept-Encoding": "gzip;q=0"})
+	suite.Empty(res.Header().Get("Content-Encoding"), "gzip refused with q=0")
+
+	deflated := getIndex(map[string]string{"Accept-Encoding": "deflate"})
+	suite.Equal("deflate", deflated.Header().Get("Content-Encoding"), "deflate index compressed")
+	deflateETag := deflated.Header().Get("ETag")
+	suite.NotEqual(identityETag, deflateETag, "distinct ETags per representation")
+	suite.NotEqual(gzipETag, deflateETag, "distinct ETags per representation")
+	zr, err := zlib.NewReader(deflated.Body)
+	suite.Nil(err, "no error reading deflate index")
+	content, err = io.ReadAll(zr)
+	suite.Nil(err, "no error decompressing deflate index")
+	suite.Equal(identity.Body.String(), string(content), "same index deflated")
+	res = getIndex(map[string]string{"If-None-Match": deflateETag, "Accept-Encoding": "deflate"})
+	suite.Equal(304, res.Code, "304 GET /index.yaml with deflate ETag")
+
+	for acceptEncoding, encoding := range map[string]string{
+		"gzip;q=0.5, deflate":    "deflate",
+		"deflate;q=0.5, GZIP":    "gzip",
+		"br, *;q=0.1":            "gzip",
+		"*, gzip;q=0":            "deflate",
+		"identity, br":           "",
+		"deflate;q=0, gzip;q=0 ": "",
+	} {
+		res = getIndex(map[string]string{"Accept-Encoding": acceptEncoding})
+		suite.Equal(encoding, res.Header().Get("Content-Encoding"), "encoding for Accept-Encoding: %s", acceptEncoding)
+	}
 
-	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/fakechart-0.1.0.tgz", repoPrefix), nil, "")
-	suite.Equal(404, res.Status(), fmt.Sprintf("404 GET %s/charts/fakechart-0.1.0.tgz", repoPrefix))
+	// compressed once per encoding until the index changes
+	first := getIndex(map[string]string{"Accept-Encoding": "gzip"})
+	again := getIndex(map[string]string{"Accept-Encoding": "gzip"})
+	suite.Equal(first.Body.Bytes(), again.Body.Bytes(), "same gzip index")
+	encoded := suite.Depth0Server.indexRepresentations.indexes[""]
+	suite.NotNil(encoded, "compressed representations of the index kept")
+	suite.Contains(encoded.content, ";gzip", "gzip representation kept")
+	suite.Contains(encoded.content, ";deflate", "deflate representation kept")
+}
 
-	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/fakechart-0.1.0.tgz.prov", repoPrefix), nil, "")
-	suite.Equal(404, res.Status(), fmt.Sprintf("404 GET %s/charts/fakechart-0.1.0.tgz.prov", repoPrefix))
+func (suite *MultiTenantServerTestSuite) TestIndexJSON() {
+	getIndex := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", path, nil)
+		for k, v := range headers {
+			c.Request.Header.Set(k, v)
+		}
+		suite.Depth0Server.Router.HandleContext(c)
+		c.Writer.WriteHeaderNow()
+		return recorder
+	}
 
-	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/fakechart-0.1.0.bad", repoPrefix), nil, "")
-	suite.Equal(500, res.Status(), fmt.Sprintf("500 GET %s/charts/fakechart-0.1.0.bad", repoPrefix))
+	yamlIndex := getIndex("/index.yaml", nil)
+	suite.Equal(200, yamlIndex.Code, "200 GET /index.yaml")
+	indexFile := &repo.IndexFile{}
+	suite.Nil(yaml.Unmarshal(yamlIndex.Body.Bytes(), indexFile), "index.yaml parsed")
+
+	res := getIndex("/index.json", nil)
+	suite.Equal(200, res.Code, "200 GET /index.json")
+	suite.Equal("application/json", res.Header().Get("Content-Type"), "JSON content type")
+	jsonIndexFile := &repo.IndexFile{}
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), jsonIndexFile), "index.json parsed")
+	suite.Equal(len(indexFile.Entries), len(jsonIndexFile.Entries), "same charts in index.json")
+	suite.Equal(indexFile.Entries["mychart"][0].Digest, jsonIndexFile.Entries["mychart"][0].Digest, "same chart versions in index.json")
+	jsonETag := res.Header().Get("ETag")
+	suite.NotEqual(yamlIndex.Header().Get("ETag"), jsonETag, "distinct ETags for index.yaml and index.json")
+	res = getIndex("/index.json", map[string]string{"If-None-Match": jsonETag})
+	suite.Equal(304, res.Code, "304 GET /index.json with its ETag")
+	res = getIndex("/index.yaml", map[string]string{"If-None-Match": jsonETag})
+	suite.Equal(200, res.Code, "200 GET /index.yaml with the ETag of index.json")
+
+	res = getIndex("/index.json?fields=version,%20name,name", nil)
+	suite.Equal(200, res.Code, "200 GET /index.json with fields")
+	filtered := struct {
+		Entries map[string][]map[string]interface{} `json:"entries"`
+	}{}
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &filtered), "filtered index.json parsed")
+	suite.NotEmpty(filtered.Entries["mychart"], "chart versions in filtered index.json")
+	for _, chartVersion := range filtered.Entries["mychart"] {
+		suite.Len(chartVersion, 2, "only the fields requested")
+		suite.Equal("mychart", chartVersion["name"])
+	}
+	fieldsETag := res.Header().Get("ETag")
+	suite.NotEqual(jsonETag, fieldsETag, "distinct ETags per fields")
+	res = getIndex("/index.json?fields=name,version", map[string]string{"If-None-Match": fieldsETag})
+	suite.Equal(304, res.Code, "304 GET /index.json with the same fields in another order")
+
+	res = getIndex("/index.json?fields=name,nope", nil)
+	suite.Equal(400, res.Code, "400 GET /index.json with an unknown field")
+	suite.Contains(res.Body.String(), "urls", "known fields listed")
+
+	res = getIndex("/index.json?fields=name", map[string]string{"Accept-Encoding": "gzip"})
+	suite.Equal("gzip", res.Header().Get("Content-Encoding"), "filtered index.json compressed")
+	gz, err := gzip.NewReader(res.Body)
+	suite.Nil(err, "no error reading gzip index.json")
+	content, err := io.ReadAll(gz)
+	suite.Nil(err, "no error decompressing gzip index.json")
+	suite.Equal(getIndex("/index.json?fields=name", nil).Body.String(), string(content), "same filtered index.json compressed")
+
+	head := suite.doRequest("depth0", "HEAD", "/index.json", nil, "")
+	suite.Equal(200, head.Status(), "200 HEAD /index.json")
+}
+
+func (suite *MultiTenantServerTestSuite) TestMultiChartUpload() {
+	backend := storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "multichart"))
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend:         backend,
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		EnableAPI:              true,
+	})
+	suite.Nil(err, "no error creating multi chart server")
+
+	post := func() (int, string) {
+		buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "chart"}, []string{testTarballPath, otherTestTarballPath})
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("POST", "/api/charts", buf)
+		c.Request.Header.Set("Content-Type", w.FormDataContentType())
+		server.Router.HandleContext(c)
+		return c.Writer.Status(), recorder.Body.String()
+	}
+
+	status, body := post()
+	suite.Equal(400, status, "400 POST /api/charts with duplicate chart fields")
+	suite.Contains(body, "multiple files in form field chart", "duplicate field error")
+	_, err = backend.GetObject("mychart-0.1.0.tgz")
+	suite.NotNil(err, "no chart stored on duplicate chart fields")
+
+	server.MultiChartUpload = true
+	status, body = post()
+	suite.Equal(201, status, "201 POST /api/charts with multi chart upload")
+	suite.Equal(`{"saved":["mychart-0.1.0.tgz","otherchart-0.1.0.tgz"]}`, body, "both charts saved")
+	for _, filename := range []string{"mychart-0.1.0.tgz", "otherchart-0.1.0.tgz"} {
+		_, err = backend.GetObject(filename)
+		suite.Nil(err, fmt.Sprintf("%s stored", filename))
+	}
+
+	status, _ = post()
+	suite.Equal(409, status, "409 POST /api/charts with multi chart upload of existing charts")
+}
+
+func (suite *MultiTenantServerTestSuite) TestBatchUpload() {
+	dir := pathutil.Join(suite.TempDirectory, "batch")
+	backend := storage.NewLocalFilesystemBackend(dir)
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend:         backend,
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		EnableAPI:              true,
+	})
+	suite.Nil(err, "no error creating batch server")
+
+	uploads := suite.T().TempDir()
+	bundlePath := pathutil.Join(uploads, "release.tar.gz")
+	bundle, err := os.Create(bundlePath)
+	suite.Nil(err)
+	gzipWriter := gzip.NewWriter(bundle)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, path := range []string{testTarballPath, testProvfilePath, "../../../../README.md"} {
+		content, err := os.ReadFile(path)
+		suite.Nil(err)
+		suite.Nil(tarWriter.WriteHeader(&tar.Header{Name: "release/" + pathutil.Base(path), Mode: 0644, Size: int64(len(content))}))
+		_, err = tarWriter.Write(content)
+		suite.Nil(err)
+	}
+	suite.Nil(tarWriter.Close())
+	suite.Nil(gzipWriter.Close())
+	suite.Nil(bundle.Close())
+	garbagePath := pathutil.Join(uploads, "garbage.tgz")
+	suite.Nil(os.WriteFile(garbagePath, []byte("not a chart"), 0644))
+
+	post := func(fields []string, filenames []string) (int, string) {
+		buf, w := suite.getBodyWithMultipartFormFiles(fields, filenames)
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("POST", "/api/charts?batch=true", buf)
+		c.Request.Header.Set("Content-Type", w.FormDataContentType())
+		server.Router.HandleContext(c)
+		return recorder.Code, recorder.Body.String()
+	}
+
+	status, body := post([]string{"bundle"}, []string{bundlePath})
+	suite.Equal(201, status, "201 POST /api/charts?batch=true with a chart and its provenance file")
+	suite.JSONEq(`{"saved": ["mychart-0.1.0.tgz", "mychart-0.1.0.tgz.prov"], "files": [
+		{"filename": "mychart-0.1.0.tgz", "status": "saved"},
+		{"filename": "mychart-0.1.0.tgz.prov", "status": "saved"}]}`, body)
+
+	status, body = post([]string{"chart", "chart"}, []string{testTarballPathV2, otherTestTarballPath})
+	suite.Equal(400, status, "400 POST /api/charts?batch=true with several charts")
+	suite.Contains(body, "--multi-chart-upload")
+
+	server.MultiChartUpload = true
+	status, body = post([]string{"chart", "chart", "chart", "prov"},
+		[]string{testTarballPath, testTarballPathV2, garbagePath, testProvfilePath})
+	suite.Equal(207, status, "207 POST /api/charts?batch=true with files not stored")
+	suite.JSONEq(`{"saved": ["mychart-0.2.0.tgz"], "files": [
+		{"filename": "garbage.tgz", "status": "failed", "error": "gzip: invalid header"},
+		{"filename": "mychart-0.1.0.tgz", "status": "conflict", "error": "file already exists"},
+		{"filename": "mychart-0.1.0.tgz.prov", "status": "failed", "error": "package not stored"},
+		{"filename": "mychart-0.2.0.tgz", "status": "saved"}]}`, body)
+	for _, filename := range []string{"mychart-0.1.0.tgz", "mychart-0.1.0.tgz.prov", "mychart-0.2.0.tgz"} {
+		_, err = backend.GetObject(filename)
+		suite.Nil(err, fmt.Sprintf("%s stored", filename))
+	}
+}
+
+func (suite *MultiTenantServerTestSuite) TestUploadURL() {
+	content, err := os.ReadFile(testTarballPath)
+	suite.Nil(err)
+	digest := fmt.Sprintf("%x", sha256.Sum256(content))
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mychart-0.1.0.tgz":
+			w.Write(content)
+		case "/redirect":
+			http.Redirect(w, r, "http://example.com/mychart-0.1.0.tgz", http.StatusFound)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer remote.Close()
+	remoteHost := strings.TrimPrefix(remote.URL, "http://")
+
+	backend := storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "uploadurl"))
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend: backend,
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating upload url server")
+	post := func(body string) (int, string) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("POST", "/api/charts", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		server.Router.HandleContext(c)
+		return recorder.Code, recorder.Body.String()
+	}
+	upload := func(path string, sha string) string {
+		return fmt.Sprintf(`{"url": "%s%s", "sha256": "%s"}`, remote.URL, path, sha)
+	}
+
+	status, _ := post(upload("/mychart-0.1.0.tgz", digest))
+	suite.Equal(403, status, "403 POST /api/charts by URL without allowed hosts")
+
+	server.UploadURLAllowedHosts = []string{remoteHost}
+	status, _ = post(`{"url": "`)
+	suite.Equal(400, status, "400 POST /api/charts with invalid JSON")
+	status, _ = post(upload("/mychart-0.1.0.tgz", ""))
+	suite.Equal(400, status, "400 POST /api/charts by URL without digest")
+	status, _ = post(`{"url": "file:///etc/passwd", "sha256": "` + digest + `"}`)
+	suite.Equal(400, status, "400 POST /api/charts by URL of a file")
+	status, _ = post(`{"url": "http://example.com/mychart-0.1.0.tgz", "sha256": "` + digest + `"}`)
+	suite.Equal(403, status, "403 POST /api/charts by URL of a host not allowed")
+	status, _ = post(upload("/redirect", digest))
+	suite.Equal(502, status, "502 POST /api/charts by URL redirected to a host not allowed")
+	status, _ = post(upload("/missing.tgz", digest))
+	suite.Equal(502, status, "502 POST /api/charts by URL not found")
+	status, body := post(upload("/mychart-0.1.0.tgz", strings.Repeat("0", 64)))
+	suite.Equal(400, status, "400 POST /api/charts by URL with another digest")
+	suite.Contains(body, "digest mismatch")
+	_, err = backend.GetObject("mychart-0.1.0.tgz")
+	suite.NotNil(err, "no chart stored on digest mismatch")
+
+	status, _ = post(upload("/mychart-0.1.0.tgz", "sha256:"+digest))
+	suite.Equal(201, status, "201 POST /api/charts by URL")
+	stored, err := backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "chart stored")
+	suite.Equal(content, stored.Content)
+	status, _ = post(upload("/mychart-0.1.0.tgz", digest))
+	suite.Equal(409, status, "409 POST /api/charts by URL of an existing chart")
+
+	server.UploadURLMaxSize = 10
+	suite.Nil(backend.DeleteObject("mychart-0.1.0.tgz"))
+	status, _ = post(upload("/mychart-0.1.0.tgz", digest))
+	suite.Equal(413, status, "413 POST /api/charts by URL of a chart too large")
+}
+
+func (suite *MultiTenantServerTestSuite) TestInfo() {
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger: logger,
+		Router: cm_router.NewRouter(cm_router.RouterOptions{
+			Logger:        logger,
+			MaxUploadSize: maxUploadSize,
+			Depth:         2,
+			Username:      "user",
+			Password:      "pass",
+			AnonymousGet:  true,
+		}),
+		StorageBackend:        storage.NewLocalFilesystemBackend(suite.TempDirectory),
+		StorageBackendName:    "local",
+		Version:               "v0.16.1",
+		EnableAPI:             true,
+		AllowOverwrite:        true,
+		UploadURLAllowedHosts: []string{"charts.example.com"},
+	})
+	suite.Nil(err, "no error creating info server")
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("GET", "/info", nil)
+	server.Router.HandleContext(c)
+	suite.Equal(200, recorder.Code, "200 GET /info without credentials")
+	suite.JSONEq(`{
+		"version": "v0.16.1",
+		"api_version": "v1",
+		"features": {
+			"multitenant": true,
+			"depth": 2,
+			"depth_dynamic": false,
+			"api": true,
+			"overwrite": true,
+			"force_overwrite": false,
+			"delete": true,
+			"multi_chart_upload": false,
+			"upload_by_url": true,
+			"download_stats": false,
+			"provenance_verification": false,
+			"require_provenance": false,
+			"storage": "local",
+			"auth": {"methods": ["basic", "api_key"], "anonymous_get": true}
+		}
+	}`, recorder.Body.String())
+}
+
+func (suite *MultiTenantServerTestSuite) TestOpenAPI() {
+	openAPI := func(server *MultiTenantServer) map[string]map[string]*openAPIOperation {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", "/openapi.json", nil)
+		server.Router.HandleContext(c)
+		suite.Equal(200, recorder.Code, "200 GET /openapi.json")
+		doc := openAPIDocument{}
+		suite.Nil(json.Unmarshal(recorder.Body.Bytes(), &doc))
+		suite.Equal("3.0.3", doc.OpenAPI)
+
+		operations := 0
+		ids := map[string]bool{}
+		for _, methods := range doc.Paths {
+			for _, operation := range methods {
+				suite.False(ids[operation.OperationID], "operation id %s is unique", operation.OperationID)
+				ids[operation.OperationID] = true
+				operations++
+			}
+		}
+		suite.Equal(len(server.Router.Routes), operations, "an operation per route")
+		for _, route := range server.Router.Routes {
+			_, described := openAPIRoutes[route.Method+" "+route.Path]
+			suite.True(described, "%s %s is described", route.Method, route.Path)
+		}
+		return doc.Paths
+	}
+
+	paths := openAPI(suite.Depth0Server)
+	suite.Contains(paths, "/index.yaml")
+	suite.Contains(paths, "/api/charts/{name}/{version}")
+	suite.Equal("uploadChart", paths["/api/charts"]["post"].OperationID)
+	suite.Contains(paths["/api/charts"]["post"].Responses, "201")
+	suite.Nil(paths["/api/charts"]["post"].Security, "no auth")
+
+	paths = openAPI(suite.Depth2Server)
+	suite.Contains(paths, "/{repo1}/{repo2}/index.yaml")
+	suite.Equal([]string{"repo1", "repo2", "name", "version"}, func() []string {
+		var names []string
+		for _, param := range paths["/api/{repo1}/{repo2}/charts/{name}/{version}"]["get"].Parameters {
+			names = append(names, param.Name)
+		}
+		return names
+	}())
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger: logger,
+		Router: cm_router.NewRouter(cm_router.RouterOptions{
+			Logger:        logger,
+			MaxUploadSize: maxUploadSize,
+			Depth:         1,
+			Username:      "user",
+			Password:      "pass",
+			AnonymousGet:  true,
+		}),
+		StorageBackend: storage.NewLocalFilesystemBackend(suite.TempDirectory),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating openapi server")
+	paths = openAPI(server)
+	suite.Equal([]map[string][]string{{"basicAuth": {}}, {"bearerAuth": {}}, {}}, paths["/{repo}/index.yaml"]["get"].Security,
+		"basic auth users and API keys, or anonymous GET")
+	suite.Equal([]map[string][]string{{"basicAuth": {}}, {"bearerAuth": {}}}, paths["/api/{repo}/charts"]["post"].Security)
+	suite.Nil(paths["/info"]["get"].Security, "served without credentials")
+	suite.Equal("getWelcomePage", paths["/"]["get"].OperationID)
+}
+
+func (suite *MultiTenantServerTestSuite) TestRegenerateIndex() {
+	dir := pathutil.Join(suite.TempDirectory, "regenerate")
+	suite.Nil(os.MkdirAll(dir, 0755), "no error creating regenerate dir")
+	copyChart := func(path string) {
+		content, err := os.ReadFile(path)
+		suite.Nil(err)
+		suite.Nil(os.WriteFile(pathutil.Join(dir, pathutil.Base(path)), content, 0644))
+	}
+	copyChart(testTarballPath)
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating regenerate server")
+	do := func(method string, urlStr string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest(method, urlStr, nil)
+		server.Router.HandleContext(c)
+		return recorder
+	}
+
+	suite.Equal(200, do("GET", "/api/charts/mychart/0.1.0").Code, "200 GET /api/charts/mychart/0.1.0 before regenerating")
+	// changed in storage out-of-band
+	suite.Nil(os.Remove(pathutil.Join(dir, "mychart-0.1.0.tgz")))
+	copyChart(testTarballPathV2)
+	copyChart(otherTestTarballPath)
+	suite.Equal(200, do("GET", "/api/charts/mychart/0.1.0").Code, "cached index served until regenerated")
+
+	res := do("POST", "/api/index/regenerate")
+	suite.Equal(200, res.Code, "200 POST /api/index/regenerate")
+	suite.JSONEq(`{"regenerated": true, "charts": 2, "versions": 2}`, res.Body.String())
+	suite.Equal(404, do("GET", "/api/charts/mychart/0.1.0").Code, "404 GET /api/charts/mychart/0.1.0 deleted out-of-band")
+	suite.Equal(200, do("GET", "/api/charts/mychart/0.2.0").Code, "200 GET /api/charts/mychart/0.2.0 added out-of-band")
+	suite.Equal(200, do("GET", "/api/charts/otherchart/0.1.0").Code, "200 GET /api/charts/otherchart/0.1.0 added out-of-band")
+}
+
+func (suite *MultiTenantServerTestSuite) TestReservedPrefixes() {
+	backend := storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "reserved"))
+	content, err := os.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	suite.Nil(backend.PutObject("_artifacts-mychart-0.1.0.tgz", content), "no error storing reserved object")
+	content, err = os.ReadFile(otherTestTarballPath)
+	suite.Nil(err, "no error opening other test tarball")
+	suite.Nil(backend.PutObject("otherchart-0.1.0.tgz", content), "no error storing other test tarball")
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend:         backend,
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		EnableAPI:              true,
+		ReservedPrefixes:       []string{"_artifacts-"},
+	})
+	suite.Nil(err, "no error creating reserved prefixes server")
+
+	for _, path := range []string{"trash/mychart-0.1.0.tgz", "audit/2023.json", "attachments/mychart/README.md", "_artifacts-mychart-0.1.0.tgz"} {
+		suite.True(server.isReservedObject(path), fmt.Sprintf("%s is reserved", path))
+	}
+	suite.False(server.isReservedObject("mychart-0.1.0.tgz"), "chart is not reserved")
+
+	do := func(method string, urlStr string, body io.Reader, contentType string) (int, string) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest(method, urlStr, body)
+		c.Request.Header.Set("Content-Type", contentType)
+		server.Router.HandleContext(c)
+		return c.Writer.Status(), recorder.Body.String()
+	}
+
+	_, body := do("GET", "/index.yaml", nil, "")
+	suite.Contains(body, "otherchart-0.1.0.tgz", "chart in index")
+	suite.NotContains(body, "mychart", "reserved object not in index")
+
+	_, body = do("GET", "/api/charts", nil, "")
+	suite.Contains(body, "otherchart", "chart listed")
+	suite.NotContains(body, "mychart", "reserved object not listed")
+
+	server.ReservedPrefixes = append(server.ReservedPrefixes, "mychart-")
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	status, body := do("POST", "/api/charts", buf, w.FormDataContentType())
+	suite.Equal(400, status, "400 POST /api/charts with reserved filename")
+	suite.Contains(body, "mychart-0.1.0.tgz uses a reserved prefix", "reserved prefix error")
+}
+
+// failingBackend fails the second put of a test, and the first deletions of each object
+type failingBackend struct {
+	storage.Backend
+	puts           []string
+	deletes        []string
+	deleteFailures int
+}
+
+func (b *failingBackend) PutObject(path string, content []byte) error {
+	b.puts = append(b.puts, path)
+	if len(b.puts) == 2 {
+		return errors.New("put failed")
+	}
+	return b.Backend.PutObject(path, content)
+}
+
+func (b *failingBackend) DeleteObject(path string) error {
+	b.deletes = append(b.deletes, path)
+	if len(b.deletes) <= b.deleteFailures {
+		return errors.New("delete failed")
+	}
+	return b.Backend.DeleteObject(path)
+}
+
+func (suite *MultiTenantServerTestSuite) TestUploadRollback() {
+	backend := &failingBackend{Backend: storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "rollback"))}
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, Depth: 1, MaxUploadSize: maxUploadSize}),
+		StorageBackend:         backend,
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		EnableAPI:              true,
+		RollbackRetries:        1,
+	})
+	suite.Nil(err, "no error creating rollback server")
+
+	post := func() (int, string) {
+		backend.puts, backend.deletes = nil, nil
+		buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("POST", "/api/org1/charts", buf)
+		c.Request.Header.Set("Content-Type", w.FormDataContentType())
+		server.Router.HandleContext(c)
+		return c.Writer.Status(), recorder.Body.String()
+	}
+
+	// the deletion fails once, then succeeds on retry
+	backend.deleteFailures = 1
+	status, body := post()
+	suite.Equal(500, status, "500 POST /api/org1/charts with failing storage")
+	suite.NotContains(body, "orphaned", "no orphaned files after retried rollback")
+	suite.Equal([]string{backend.puts[0], backend.puts[0]}, backend.deletes, "rollback deletes exactly the stored file")
+	suite.True(strings.HasPrefix(backend.puts[0], "org1/"), "stored file in repo")
+	_, err = backend.Backend.GetObject(backend.puts[0])
+	suite.NotNil(err, "stored file rolled back")
+
+	// the deletion keeps failing, the stored file is reported
+	backend.deleteFailures = 2
+	status, body = post()
+	suite.Equal(500, status, "500 POST /api/org1/charts with failing storage and rollback")
+	suite.Len(backend.deletes, 2, "rollback deletion retried")
+	suite.Contains(body, fmt.Sprintf(`"orphaned":["%s"]`, pathutil.Base(backend.puts[0])), "orphaned file in response")
+	_, err = backend.Backend.GetObject(backend.puts[0])
+	suite.Nil(err, "orphaned file left in storage")
+}
+
+func (suite *MultiTenantServerTestSuite) TestMaxIndexSize() {
+	backend := storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "maxindexsize"))
+	for _, path := range []string{testTarballPath, otherTestTarballPath} {
+		content, err := os.ReadFile(path)
+		suite.Nil(err, "no error opening test tarball")
+		suite.Nil(backend.PutObject(pathutil.Base(path), content), "no error storing test tarball")
+	}
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend: backend,
+	})
+	suite.Nil(err, "no error creating max index size server")
+
+	get := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", "/index.yaml", nil)
+		server.Router.HandleContext(c)
+		c.Writer.WriteHeaderNow()
+		return recorder
+	}
+
+	res := get()
+	suite.Equal(200, res.Code, "200 GET /index.yaml")
+	suite.Empty(res.Header().Get("X-Index-Truncated"), "index not truncated without max size")
+	size := res.Body.Len()
+
+	server.MaxIndexSize = size
+	suite.Equal(200, get().Code, "200 GET /index.yaml with index at max size")
+
+	server.MaxIndexSize = size - 1
+	res = get()
+	suite.Equal(413, res.Code, "413 GET /index.yaml with index over max size")
+	suite.Contains(res.Body.String(), "/api/charts", "error points at the paginated API")
+
+	server.TruncateIndex = true
+	res = get()
+	suite.Equal(200, res.Code, "200 GET /index.yaml with truncated index")
+	suite.Equal("true", res.Header().Get("X-Index-Truncated"), "X-Index-Truncated header")
+	suite.LessOrEqual(res.Body.Len(), size-1, "truncated index within max size")
+	suite.Contains(res.Body.String(), "mychart-0.1.0.tgz", "first chart kept")
+	suite.NotContains(res.Body.String(), "otherchart-0.1.0.tgz", "last chart dropped")
+}
+
+func (suite *MultiTenantServerTestSuite) TestRenameChart() {
+	backend := storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "rename"))
+	for _, path := range []string{testTarballPath, testProvfilePath, testTarballPathV2} {
+		content, err := os.ReadFile(path)
+		suite.Nil(err, "no error opening test file")
+		suite.Nil(backend.PutObject(pathutil.Base(path), content), "no error storing test file")
+	}
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend: backend,
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating rename server")
+
+	type renameResponse struct {
+		Versions []renamedChartVersion `json:"versions"`
+	}
+	rename := func(body string) (int, renameResponse) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("POST", "/api/charts/mychart/rename", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		server.Router.HandleContext(c)
+		res := renameResponse{}
+		json.Unmarshal(recorder.Body.Bytes(), &res)
+		return c.Writer.Status(), res
+	}
+	statuses := func(res renameResponse) map[string]string {
+		result := map[string]string{}
+		for _, v := range res.Versions {
+			result[v.Version] = v.Status
+		}
+		return result
+	}
+
+	status, res := rename(`{"to": "newchart", "dry_run": true}`)
+	suite.Equal(200, status, "200 POST /api/charts/mychart/rename dry run")
+	suite.Equal(map[string]string{"0.1.0": "would_rename", "0.2.0": "would_rename"}, statuses(res), "dry run results")
+	_, err = backend.GetObject("newchart-0.1.0.tgz")
+	suite.NotNil(err, "nothing stored on dry run")
+
+	status, res = rename(`{"to": "newchart"}`)
+	suite.Equal(200, status, "200 POST /api/charts/mychart/rename")
+	suite.Equal(map[string]string{"0.1.0": "renamed", "0.2.0": "renamed"}, statuses(res), "rename results")
+	for _, v := range res.Versions {
+		suite.Equal(v.Version == "0.1.0", v.ProvenanceDropped, fmt.Sprintf("provenance dropped for %s", v.Version))
+	}
+	object, err := backend.GetObject("newchart-0.1.0.tgz")
+	suite.Nil(err, "renamed chart stored")
+	filename, err := repo.ChartPackageFilenameFromContent(object.Content)
+	suite.Nil(err, "renamed chart is valid")
+	suite.Equal("newchart-0.1.0.tgz", filename, "name embedded in renamed chart")
+	_, err = backend.GetObject("newchart-0.1.0.tgz.prov")
+	suite.NotNil(err, "provenance file not copied")
+	_, err = backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "original kept")
+
+	status, res = rename(`{"to": "newchart", "delete_originals": true}`)
+	suite.Equal(200, status, "200 POST /api/charts/mychart/rename again")
+	suite.Equal(map[string]string{"0.1.0": "conflict", "0.2.0": "conflict"}, statuses(res), "existing renamed versions not overwritten")
+	_, err = backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "original kept on conflict")
+
+	suite.Nil(backend.DeleteObject("newchart-0.2.0.tgz"), "no error deleting renamed chart")
+	status, res = rename(`{"to": "newchart", "delete_originals": true}`)
+	suite.Equal(200, status, "200 POST /api/charts/mychart/rename deleting originals")
+	suite.Equal(map[string]string{"0.1.0": "conflict", "0.2.0": "renamed"}, statuses(res), "only missing version renamed")
+	_, err = backend.GetObject("mychart-0.2.0.tgz")
+	suite.NotNil(err, "original deleted")
+
+	for _, body := range []string{`{"to": "mychart"}`, `{"to": "../newchart"}`, `{}`} {
+		status, _ = rename(body)
+		suite.Equal(400, status, fmt.Sprintf("400 POST /api/charts/mychart/rename with %s", body))
+	}
+}
+
+func (suite *MultiTenantServerTestSuite) TestAPIKeys() {
+	backend := storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "apikeys"))
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger: logger,
+		Router: cm_router.NewRouter(cm_router.RouterOptions{
+			Logger:        logger,
+			Depth:         1,
+			MaxUploadSize: maxUploadSize,
+			Username:      "admin",
+			Password:      "secret",
+			AuthRoles:     map[string]string{"admin": "org1:admin"},
+		}),
+		StorageBackend:         backend,
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		EnableAPI:              true,
+	})
+	suite.Nil(err, "no error creating API keys server")
+
+	do := func(method string, urlStr string, body string, setAuth func(*http.Request)) (int, []byte) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest(method, urlStr, strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		setAuth(c.Request)
+		server.Router.HandleContext(c)
+		return c.Writer.Status(), recorder.Body.Bytes()
+	}
+	admin := func(r *http.Request) { r.SetBasicAuth("admin", "secret") }
+	withKey := func(key string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+key) }
+	}
+	content, err := os.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+
+	status, _ := do("POST", "/api/org1/keys", `{"name": "ci", "actions": ["pull"]}`, func(*http.Request) {})
+	suite.Equal(401, status, "401 POST /api/org1/keys without credentials")
+	status, _ = do("POST", "/api/org1/keys", `{"name": "ci", "actions": ["admin"]}`, admin)
+	suite.Equal(400, status, "400 POST /api/org1/keys granting admin")
+	status, _ = do("POST", "/api/org1/keys", `{"actions": ["pull"]}`, admin)
+	suite.Equal(400, status, "400 POST /api/org1/keys without name")
+
+	status, body := do("POST", "/api/org1/keys", `{"name": "ci", "actions": ["pull", "push"]}`, admin)
+	suite.Equal(201, status, "201 POST /api/org1/keys")
+	created := createdAPIKey{}
+	suite.Nil(json.Unmarshal(body, &created))
+	suite.True(strings.HasPrefix(created.Key, cm_router.APIKeyPrefix), "secret returned on creation")
+	suite.Equal("admin", created.User, "key created on behalf of the user")
+	suite.Nil(created.Expires, "key without expiry")
+
+	object, err := backend.GetObject(pathutil.Join("org1", apiKeysFilename))
+	suite.Nil(err, "keys stored next to the charts of the repo")
+	suite.NotContains(string(object.Content), created.Key, "secret not stored")
+
+	req := bytes.NewReader(content)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/api/org1/charts", req)
+	c.Request.SetBasicAuth("ci", created.Key)
+	server.Router.HandleContext(c)
+	suite.Equal(201, c.Writer.Status(), "201 POST /api/org1/charts with the key as basic auth password")
+	status, _ = do("GET", "/org1/index.yaml", "", withKey(created.Key))
+	suite.Equal(200, status, "200 GET /org1/index.yaml with the key as bearer token")
+	status, _ = do("DELETE", "/api/org1/charts/mychart/0.1.0", "", withKey(created.Key))
+	suite.Equal(401, status, "401 DELETE /api/org1/charts/mychart/0.1.0 not granted by the key")
+	status, _ = do("GET", "/api/org1/keys", "", withKey(created.Key))
+	suite.Equal(401, status, "401 GET /api/org1/keys with a key")
+	status, _ = do("GET", "/org2/index.yaml", "", withKey(created.Key))
+	suite.Equal(401, status, "401 GET /org2/index.yaml with the key of another repo")
+	status, _ = do("GET", "/org1/index.yaml", "", withKey(created.Key+"x"))
+	suite.Equal(401, status, "401 GET /org1/index.yaml with an invalid key")
+
+	status, body = do("GET", "/api/org1/keys", "", admin)
+	suite.Equal(200, status, "200 GET /api/org1/keys")
+	suite.Contains(string(body), `"name":"ci"`, "key listed")
+	suite.NotContains(string(body), "hash", "hashes not listed")
+
+	status, body = do("POST", "/api/org1/keys", `{"name": "expired", "actions": ["pull"], "expires_in": 1}`, admin)
+	suite.Equal(201, status, "201 POST /api/org1/keys expiring")
+	expiring := createdAPIKey{}
+	suite.Nil(json.Unmarshal(body, &expiring))
+	suite.NotNil(expiring.Expires, "key with expiry")
+	keys, _ := server.getAPIKeys("org1")
+	past := time.Now().Add(-time.Minute)
+	keys[1].Expires = &past
+	suite.Nil(server.saveAPIKeys("org1", keys))
+	status, _ = do("GET", "/org1/index.yaml", "", withKey(expiring.Key))
+	suite.Equal(401, status, "401 GET /org1/index.yaml with an expired key")
+
+	status, _ = do("DELETE", "/api/org1/keys/"+created.ID, "", admin)
+	suite.Equal(200, status, "200 DELETE /api/org1/keys/:id")
+	status, _ = do("GET", "/org1/index.yaml", "", withKey(created.Key))
+	suite.Equal(401, status, "401 GET /org1/index.yaml with a revoked key")
+	status, _ = do("DELETE", "/api/org1/keys/"+created.ID, "", admin)
+	suite.Equal(404, status, "404 DELETE /api/org1/keys/:id already revoked")
+}
+
+func (suite *MultiTenantServerTestSuite) TestChartQuery() {
+	newChart := func(name string, description string, keywords ...string) *helm_repo.ChartVersion {
+		return &helm_repo.ChartVersion{Metadata: &chart.Metadata{
+			Name:        name,
+			Description: description,
+			Keywords:    keywords,
+			Maintainers: []*chart.Maintainer{{Name: "Platform Team", Email: "platform@example.com"}},
+		}}
+	}
+	redis := newChart("redis", "In-memory data store", "cache", "database")
+	redisExporter := newChart("redis-exporter", "Prometheus exporter of Redis metrics", "monitoring")
+	sentinel := newChart("sentinel", "Failover of redis")
+
+	query, err := newChartQuery("redis", false)
+	suite.Nil(err)
+	suite.Equal(nameExactScore, query.score(redis), "exact name")
+	suite.Equal(namePrefixScore+descriptionScore, query.score(redisExporter), "name prefix and description")
+	suite.Equal(descriptionScore, query.score(sentinel), "description")
+
+	query, err = newChartQuery("Cache PLATFORM", false)
+	suite.Nil(err)
+	suite.Equal(keywordExactScore+maintainerScore, query.score(redis), "every term, case-insensitively")
+	suite.Equal(0, query.score(redisExporter), "a term missing")
+
+	query, err = newChartQuery("^redis(-.*)?$", true)
+	suite.Nil(err)
+	suite.Equal(nameExactScore, query.score(redis))
+	suite.Equal(nameExactScore, query.score(redisExporter))
+	suite.Equal(0, query.score(sentinel))
+
+	_, err = newChartQuery(" ", false)
+	suite.Equal(400, err.Status, "missing query")
+	_, err = newChartQuery(strings.Repeat("a", maxSearchQueryLength+1), false)
+	suite.Equal(400, err.Status, "query too long")
+	_, err = newChartQuery("[", true)
+	suite.Equal(400, err.Status, "invalid regexp")
+}
+
+func (suite *MultiTenantServerTestSuite) TestMatchingChartVersions() {
+	var versions helm_repo.ChartVersions
+	for _, version := range []string{"2.0.0", "1.3.0-rc.1", "1.2.5", "1.2.0", "1.1.0", "invalid"} {
+		versions = append(versions, &helm_repo.ChartVersion{Metadata: &chart.Metadata{Name: "mychart", Version: version}})
+	}
+	versionsOf := func(matching helm_repo.ChartVersions) []string {
+		var result []string
+		for _, chartVersion := range matching {
+			result = append(result, chartVersion.Version)
+		}
+		return result
+	}
+
+	matching, err := matchingChartVersions(versions, "^1.2.x")
+	suite.Nil(err)
+	suite.Equal([]string{"1.2.5", "1.2.0"}, versionsOf(matching), "prerelease not matched")
+	matching, err = matchingChartVersions(versions, ">=1.2.0-0 <2.0.0-0")
+	suite.Nil(err)
+	suite.Equal([]string{"1.3.0-rc.1", "1.2.5", "1.2.0"}, versionsOf(matching), "prerelease matched")
+	_, err = matchingChartVersions(versions, "~3")
+	suite.Equal(404, err.Status)
+	_, err = matchingChartVersions(versions, "1.2.x || ")
+	suite.Equal(400, err.Status)
+}
+
+func (suite *MultiTenantServerTestSuite) TestChartFileCache() {
+	cache := newChartFileCache(8)
+	cache.add("org1", "digest1", "values.yaml", []byte("a: 1"))
+	values, found := cache.get("org1", "digest1", "values.yaml")
+	suite.True(found)
+	suite.Equal("a: 1", string(values))
+	_, found = cache.get("org2", "digest1", "values.yaml")
+	suite.False(found, "files cached by repo")
+	_, found = cache.get("org1", "digest1", "README")
+	suite.False(found, "files cached by name")
+
+	cache.add("org1", "digest2", "values.yaml", []byte("b: 1"))
+	cache.get("org1", "digest1", "values.yaml")
+	cache.add("org1", "digest3", "values.yaml", []byte("c: 1"))
+	_, found = cache.get("org1", "digest2", "values.yaml")
+	suite.False(found, "least recently read files dropped")
+	_, found = cache.get("org1", "digest1", "values.yaml")
+	suite.True(found, "files read since kept")
+	suite.Equal(8, cache.size)
+
+	cache.add("org1", "digest4", "values.yaml", []byte("too large for the cache"))
+	_, found = cache.get("org1", "digest4", "values.yaml")
+	suite.False(found, "files larger than the cache not kept")
+	suite.Equal(8, cache.size)
+}
+
+func (suite *MultiTenantServerTestSuite) TestChartDependencies() {
+	dir := pathutil.Join(suite.TempDirectory, "dependencies")
+	save := func(repo string, name string, version string, dependencies ...*chart.Dependency) {
+		ch := &chart.Chart{Metadata: &chart.Metadata{
+			APIVersion:   chart.APIVersionV2,
+			Name:         name,
+			Version:      version,
+			Dependencies: dependencies,
+		}}
+		suite.Nil(os.MkdirAll(pathutil.Join(dir, repo), 0755))
+		_, err := chartutil.Save(ch, pathutil.Join(dir, repo))
+		suite.Nil(err, "no error packaging %s-%s", name, version)
+	}
+	save("dev", "app", "1.0.0",
+		&chart.Dependency{Name: "lib", Version: "^1.0.0", Repository: "https://charts.example.com/dev"},
+		&chart.Dependency{Name: "db", Version: "~2.0", Repository: "https://charts.example.com/staging/"},
+		&chart.Dependency{Name: "secret", Version: "*", Repository: "https://charts.example.com/prod"},
+		&chart.Dependency{Name: "missing", Version: "1.0.0", Repository: "https://charts.example.com/dev"},
+		&chart.Dependency{Name: "common", Version: "1.0.0", Repository: "file://../common"},
+		&chart.Dependency{Name: "redis", Version: "17.x", Repository: "https://charts.bitnami.com/bitnami", Alias: "cache", Condition: "cache.enabled"},
+	)
+	save("dev", "lib", "1.0.0")
+	save("dev", "lib", "1.2.0", &chart.Dependency{Name: "app", Repository: "https://charts.example.com/dev"})
+	save("dev", "lib", "2.0.0")
+	save("staging", "db", "2.0.1", &chart.Dependency{Name: "lib", Version: ">=2.0.0", Repository: "https://charts.example.com/dev"})
+	save("prod", "secret", "1.0.0")
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger: logger,
+		Router: cm_router.NewRouter(cm_router.RouterOptions{
+			Logger:        logger,
+			MaxUploadSize: maxUploadSize,
+			Depth:         1,
+			Username:      "alice",
+			Password:      "pass",
+			AuthRoles:     map[string]string{"alice": "dev:read staging:read"},
+		}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		ChartURL:       "https://charts.example.com",
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating dependencies server")
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", path, nil)
+		c.Request.SetBasicAuth("alice", "pass")
+		server.Router.HandleContext(c)
+		return recorder
+	}
+
+	res := get("/api/dev/charts/app/latest/dependencies")
+	suite.Equal(200, res.Code, "200 GET /api/dev/charts/app/latest/dependencies")
+	suite.JSONEq(`{
+		"root": "dev/app/1.0.0",
+		"nodes": [
+			{"id": "dev/app/1.0.0", "name": "app", "version": "1.0.0", "repo": "dev", "status": "found"},
+			{"id": "dev/lib/1.2.0", "name": "lib", "version": "1.2.0", "repo": "dev", "status": "found"},
+			{"id": "staging/db/2.0.1", "name": "db", "version": "2.0.1", "repo": "staging", "status": "found"},
+			{"id": "prod/secret@*", "name": "secret", "version": "*", "repo": "prod", "repository": "https://charts.example.com/prod", "status": "forbidden"},
+			{"id": "dev/missing@1.0.0", "name": "missing", "version": "1.0.0", "repo": "dev", "repository": "https://charts.example.com/dev", "status": "missing"},
+			{"id": "dev/app/1.0.0/charts/common", "name": "common", "version": "1.0.0", "repo": "", "repository": "file://../common", "status": "bundled"},
+			{"id": "https://charts.bitnami.com/bitnami#redis@17.x", "name": "redis", "version": "17.x", "repo": "", "repository": "https://charts.bitnami.com/bitnami", "status": "external"},
+			{"id": "dev/lib/2.0.0", "name": "lib", "version": "2.0.0", "repo": "dev", "status": "found"}
+		],
+		"edges": [
+			{"from": "dev/app/1.0.0", "to": "dev/lib/1.2.0", "version": "^1.0.0"},
+			{"from": "dev/app/1.0.0", "to": "staging/db/2.0.1", "version": "~2.0"},
+			{"from": "dev/app/1.0.0", "to": "prod/secret@*", "version": "*"},
+			{"from": "dev/app/1.0.0", "to": "dev/missing@1.0.0", "version": "1.0.0"},
+			{"from": "dev/app/1.0.0", "to": "dev/app/1.0.0/charts/common", "version": "1.0.0"},
+			{"from": "dev/app/1.0.0", "to": "https://charts.bitnami.com/bitnami#redis@17.x", "version": "17.x", "alias": "cache", "condition": "cache.enabled"},
+			{"from": "dev/lib/1.2.0", "to": "dev/app/1.0.0", "version": ""},
+			{"from": "staging/db/2.0.1", "to": "dev/lib/2.0.0", "version": ">=2.0.0"}
+		],
+		"truncated": false
+	}`, res.Body.String())
+
+	suite.Equal(404, get("/api/dev/charts/app/9.9.9/dependencies").Code, "404 unknown version")
+	suite.Equal(403, get("/api/prod/charts/secret/1.0.0/dependencies").Code, "403 repo not allowed")
+}
+
+func (suite *MultiTenantServerTestSuite) TestChartVersionDiff() {
+	dir := pathutil.Join(suite.TempDirectory, "diff")
+	for version, tag := range map[string]string{"1.0.0": "1.0", "1.1.0": "1.1"} {
+		ch := &chart.Chart{
+			Metadata:  &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "app", Version: version},
+			Raw:       []*chart.File{{Name: "values.yaml", Data: []byte("tag: \"" + tag + "\"\n")}},
+			Templates: []*chart.File{{Name: "templates/deployment.yaml", Data: []byte("kind: Deployment\n")}},
+		}
+		if version == "1.1.0" {
+			ch.Templates = append(ch.Templates, &chart.File{Name: "templates/service.yaml", Data: []byte("kind: Service\n")})
+		}
+		_, err := chartutil.Save(ch, dir)
+		suite.Nil(err, "no error packaging app-%s", version)
+	}
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating diff server")
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", path, nil)
+		server.Router.HandleContext(c)
+		return recorder
+	}
+
+	res := get("/api/charts/app/diff?from=1.0.0&to=latest")
+	suite.Equal(200, res.Code, "200 GET /api/charts/app/diff")
+	suite.JSONEq(`{
+		"name": "app",
+		"from": "1.0.0",
+		"to": "1.1.0",
+		"files": [
+			{"path": "Chart.yaml", "status": "modified", "diff": "@@ -1,3 +1,3 @@\n apiVersion: v2\n name: app\n-version: 1.0.0\n+version: 1.1.0\n"},
+			{"path": "templates/service.yaml", "status": "added", "diff": "@@ -0,0 +1 @@\n+kind: Service\n"},
+			{"path": "values.yaml", "status": "modified", "diff": "@@ -1 +1 @@\n-tag: \"1.0\"\n+tag: \"1.1\"\n"}
+		],
+		"values": [
+			{"path": "tag", "status": "modified", "from": "1.0", "to": "1.1"}
+		]
+	}`, res.Body.String())
+
+	res = get("/api/charts/app/diff?from=v1.0.0&to=1.1.0&format=unified")
+	suite.Equal(200, res.Code, "200 GET /api/charts/app/diff?format=unified")
+	suite.Equal("text/x-diff; charset=utf-8", res.Header().Get("Content-Type"))
+	suite.Contains(res.Body.String(), "--- /dev/null\n+++ b/templates/service.yaml\n@@ -0,0 +1 @@\n+kind: Service\n")
+
+	suite.Equal(400, get("/api/charts/app/diff?from=1.0.0").Code, "400 missing to version")
+	suite.Equal(200, get("/api/charts/app/diff?from=1.0&to=1.1.0").Code, "200 from version constraint")
+	suite.Equal(400, get("/api/charts/app/diff?from=../1.0.0&to=1.1.0").Code, "400 invalid from version")
+	suite.Equal(400, get("/api/charts/app/diff?from=1.0.0&to=1.1.0&format=html").Code, "400 invalid format")
+	suite.Equal(404, get("/api/charts/app/diff?from=1.0.0&to=9.9.9").Code, "404 unknown version")
+}
+
+func (suite *MultiTenantServerTestSuite) TestStorageObjectRanges() {
+	dir := pathutil.Join(suite.TempDirectory, "ranges")
+	filename, err := chartutil.Save(&chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "app", Version: "1.0.0"}}, dir)
+	suite.Nil(err, "no error packaging app-1.0.0")
+	content, err := os.ReadFile(filename)
+	suite.Nil(err, "no error reading app-1.0.0")
+	size := len(content)
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating ranges server")
+	get := func(headers map[string]string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", "/charts/app-1.0.0.tgz", nil)
+		for k, v := range headers {
+			c.Request.Header.Set(k, v)
+		}
+		server.Router.HandleContext(c)
+		return recorder
+	}
+
+	res := get(nil)
+	suite.Equal(200, res.Code, "200 GET /charts/app-1.0.0.tgz")
+	suite.Equal("bytes", res.Header().Get("Accept-Ranges"), "ranges accepted")
+	suite.Equal(content, res.Body.Bytes(), "whole package")
+
+	res = get(map[string]string{"Range": "bytes=0-9"})
+	suite.Equal(206, res.Code, "206 GET /charts/app-1.0.0.tgz with a range")
+	suite.Equal(fmt.Sprintf("bytes 0-9/%d", size), res.Header().Get("Content-Range"))
+	suite.Equal("10", res.Header().Get("Content-Length"))
+	suite.Equal(content[:10], res.Body.Bytes(), "first bytes")
+
+	res = get(map[string]string{"Range": "bytes=10-"})
+	suite.Equal(206, res.Code, "206 GET /charts/app-1.0.0.tgz resumed")
+	suite.Equal(fmt.Sprintf("bytes 10-%d/%d", size-1, size), res.Header().Get("Content-Range"))
+	suite.Equal(content[10:], res.Body.Bytes(), "rest of the package")
+
+	res = get(map[string]string{"Range": "bytes=-5"})
+	suite.Equal(206, res.Code, "206 GET /charts/app-1.0.0.tgz with a suffix range")
+	suite.Equal(content[size-5:], res.Body.Bytes(), "last bytes")
+
+	res = get(map[string]string{"Range": fmt.Sprintf("bytes=%d-", size)})
+	suite.Equal(416, res.Code, "416 GET /charts/app-1.0.0.tgz past the end")
+	suite.Equal(fmt.Sprintf("bytes */%d", size), res.Header().Get("Content-Range"))
+
+	for _, header := range []string{"bytes=0-1,4-5", "bytes=5-1", "items=0-1", "bytes=x-"} {
+		res = get(map[string]string{"Range": header})
+		suite.Equal(200, res.Code, "200 GET /charts/app-1.0.0.tgz with range %s", header)
+		suite.Equal(content, res.Body.Bytes(), "whole package with range %s", header)
+	}
+
+	head := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(head)
+	c.Request, _ = http.NewRequest("HEAD", "/charts/app-1.0.0.tgz", nil)
+	server.Router.HandleContext(c)
+	etag, lastModified := head.Header().Get("ETag"), head.Header().Get("Last-Modified")
+	suite.NotEmpty(etag, "ETag of the package")
+	suite.Equal("bytes", head.Header().Get("Accept-Ranges"), "ranges accepted")
+
+	res = get(map[string]string{"Range": "bytes=10-", "If-Range": etag})
+	suite.Equal(206, res.Code, "206 GET /charts/app-1.0.0.tgz with the ETag of the package")
+	res = get(map[string]string{"Range": "bytes=10-", "If-Range": lastModified})
+	suite.Equal(206, res.Code, "206 GET /charts/app-1.0.0.tgz with the Last-Modified of the package")
+	res = get(map[string]string{"Range": "bytes=10-", "If-Range": `"other"`})
+	suite.Equal(200, res.Code, "200 GET /charts/app-1.0.0.tgz with another ETag")
+	suite.Equal(content, res.Body.Bytes(), "whole package when changed")
+	res = get(map[string]string{"Range": "bytes=10-", "If-Range": "Mon, 02 Jan 2006 15:04:05 GMT"})
+	suite.Equal(200, res.Code, "200 GET /charts/app-1.0.0.tgz with another Last-Modified")
+
+	recorder := httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("GET", "/charts/other-1.0.0.tgz", nil)
+	c.Request.Header.Set("Range", "bytes=0-9")
+	server.Router.HandleContext(c)
+	suite.Equal(404, recorder.Code, "404 GET /charts/other-1.0.0.tgz with a range")
+}
+
+func (suite *MultiTenantServerTestSuite) TestOrphanReport() {
+	dir := pathutil.Join(suite.TempDirectory, "orphans")
+	files := map[string]string{
+		"mychart-0.1.0.tgz":      testTarballPath,
+		"mychart-0.1.0.tgz.prov": testProvfilePath,
+		"mychart-0.2.0.tgz":      "../../../../testdata/charts/mychart/mychart-0.2.0.tgz",
+		"mychart-0.9.0.tgz.prov": testProvfilePath,
+		"sub/mychart-0.0.1.tgz":  "../../../../testdata/charts/mychart/mychart-0.0.1.tgz",
+	}
+	for filename, path := range files {
+		content, err := os.ReadFile(path)
+		suite.Nil(err, "no error reading %s", path)
+		suite.Nil(os.MkdirAll(pathutil.Dir(pathutil.Join(dir, "dev", filename)), 0755))
+		suite.Nil(os.WriteFile(pathutil.Join(dir, "dev", filename), content, 0644))
+	}
+	for filename, content := range map[string]string{
+		"bad-1.0.0.tgz":         "not a chart",
+		"index.yaml":            "apiVersion: v1",
+		"index-cache.yaml":      "apiVersion: v1",
+		"tenant-settings.json":  "{}",
+		"trash/deleted-1.0.tgz": "deleted",
+	} {
+		suite.Nil(os.MkdirAll(pathutil.Dir(pathutil.Join(dir, "dev", filename)), 0755))
+		suite.Nil(os.WriteFile(pathutil.Join(dir, "dev", filename), []byte(content), 0644))
+	}
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize, Depth: 1}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating orphans server")
+	report := func() orphanReport {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", "/api/dev/orphans", nil)
+		server.Router.HandleContext(c)
+		suite.Equal(200, recorder.Code, "200 GET /api/dev/orphans")
+		var report orphanReport
+		suite.Nil(json.Unmarshal(recorder.Body.Bytes(), &report), "no error decoding orphan report")
+		return report
+	}
+	reasons := func(report orphanReport) map[string]string {
+		reasons := map[string]string{}
+		for _, object := range report.Objects {
+			suite.False(object.LastModified.IsZero(), "last modified time of %s", object.Path)
+			reasons[object.Path] = object.Reason
+		}
+		return reasons
+	}
+
+	got := report()
+	suite.Equal(map[string]string{
+		"bad-1.0.0.tgz":          orphanReasonInvalidPackage,
+		"index.yaml":             orphanReasonUnknown,
+		"mychart-0.9.0.tgz.prov": orphanReasonProvenance,
+	}, reasons(got), "objects the index does not reference")
+	suite.Empty(got.MissingPackages, "every package of the index stored")
+
+	// changed in the bucket while the index is cached
+	suite.Nil(os.Remove(pathutil.Join(dir, "dev", "mychart-0.2.0.tgz")))
+	content, err := os.ReadFile(otherTestTarballPath)
+	suite.Nil(err, "no error reading other test tarball")
+	suite.Nil(os.WriteFile(pathutil.Join(dir, "dev", "otherchart-0.1.0.tgz"), content, 0644))
+	got = report()
+	suite.Equal([]missingPackage{{Name: "mychart", Version: "0.2.0", Filename: "mychart-0.2.0.tgz"}}, got.MissingPackages)
+	suite.Equal(orphanReasonUnindexedPackage, reasons(got)["otherchart-0.1.0.tgz"], "package added since indexed")
+
+	res := suite.doRequest("depth0", "GET", "/api/orphans", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /api/orphans")
+}
+
+func (suite *MultiTenantServerTestSuite) TestDeprecateChartVersion() {
+	dir := pathutil.Join(suite.TempDirectory, "deprecate")
+	suite.Nil(os.MkdirAll(pathutil.Join(dir, "dev"), 0755))
+	for filename, path := range map[string]string{
+		"mychart-0.1.0.tgz":      testTarballPath,
+		"mychart-0.1.0.tgz.prov": testProvfilePath,
+		"mychart-0.2.0.tgz":      "../../../../testdata/charts/mychart/mychart-0.2.0.tgz",
+	} {
+		content, err := os.ReadFile(path)
+		suite.Nil(err, "no error reading %s", path)
+		suite.Nil(os.WriteFile(pathutil.Join(dir, "dev", filename), content, 0644))
+	}
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize, Depth: 1}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating deprecate server")
+	log := logger.ContextLoggingFn(&gin.Context{})
+	do := func(method string, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest(method, path, nil)
+		server.Router.HandleContext(c)
+		return recorder
+	}
+	deprecate := func(path string) deprecatedChartVersion {
+		recorder := do("POST", path)
+		suite.Equal(200, recorder.Code, "200 POST %s", path)
+		var result deprecatedChartVersion
+		suite.Nil(json.Unmarshal(recorder.Body.Bytes(), &result), "no error decoding deprecation")
+		return result
+	}
+	indexed := func(version string) bool {
+		chartVersion, err := server.getChartVersion(log, "dev", "mychart", version)
+		suite.Nil(err, "mychart %s indexed", version)
+		return chartVersion.Deprecated
+	}
+	suite.False(indexed("0.1.0"), "not deprecated yet")
+
+	result := deprecate("/api/dev/charts/mychart/0.1.0/deprecate")
+	suite.Equal("0.1.0", result.Version)
+	suite.False(result.PackageRewritten, "package kept")
+	suite.Eventually(func() bool { return indexed("0.1.0") }, time.Second, 10*time.Millisecond, "deprecated in the index")
+	suite.False(indexed("0.2.0"), "other version not deprecated")
+	suite.FileExists(pathutil.Join(dir, "dev", "mychart-0.1.0.tgz.prov"), "provenance file kept")
+	suite.Equal(result.DeprecatedAt, deprecate("/api/dev/charts/mychart/0.1.0/deprecate").DeprecatedAt,
+		"deprecating again keeps the time")
+
+	suite.Equal(200, do("POST", "/api/dev/index/regenerate").Code, "200 POST /api/dev/index/regenerate")
+	suite.True(indexed("0.1.0"), "still deprecated once the index is regenerated from storage")
+
+	result = deprecate("/api/dev/charts/mychart/latest/deprecate?rewrite=true")
+	suite.Equal("0.2.0", result.Version, "latest version deprecated")
+	suite.True(result.PackageRewritten, "package rewritten")
+	suite.False(result.ProvenanceDropped, "no provenance file")
+	content, err := os.ReadFile(pathutil.Join(dir, "dev", "mychart-0.2.0.tgz"))
+	suite.Nil(err, "no error reading rewritten package")
+	rewritten, err := repo.ChartVersionFromStorageObject(storage.Object{Path: "mychart-0.2.0.tgz", Content: content})
+	suite.Nil(err, "rewritten package valid")
+	suite.True(rewritten.Deprecated, "deprecated in Chart.yaml")
+	suite.Eventually(func() bool {
+		chartVersion, _ := server.getChartVersion(log, "dev", "mychart", "0.2.0")
+		return chartVersion.Digest == rewritten.Digest
+	}, time.Second, 10*time.Millisecond, "rewritten package indexed")
+	suite.True(indexed("0.2.0"))
+
+	result = deprecate("/api/dev/charts/mychart/0.1.0/deprecate?rewrite=true")
+	suite.True(result.ProvenanceDropped, "provenance file of the rewritten package dropped")
+	suite.NoFileExists(pathutil.Join(dir, "dev", "mychart-0.1.0.tgz.prov"))
+
+	suite.Equal(404, do("POST", "/api/dev/charts/mychart/9.9.9/deprecate").Code, "404 unknown version")
+	suite.Equal(400, do("POST", "/api/dev/charts/mychart/bad/deprecate").Code, "400 invalid version")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(version string) {
+			defer wg.Done()
+			_, err := server.addChartDeprecation("dev", "otherchart", version)
+			suite.Nil(err, "no error deprecating otherchart %s", version)
+		}(fmt.Sprintf("1.0.%d", i))
+	}
+	wg.Wait()
+	deprecations, herr := server.getChartDeprecations("dev")
+	suite.Nil(herr, "no error reading deprecations")
+	suite.Len(deprecations["otherchart"], 10, "concurrent deprecations all saved")
+
+	saved, err := os.ReadFile(pathutil.Join(dir, "dev", chartDeprecationsFilename))
+	suite.Nil(err, "no error reading deprecations")
+	server.StorageBackend = &unreadableObjectBackend{Backend: server.StorageBackend, filename: chartDeprecationsFilename}
+	suite.Equal(500, do("POST", "/api/dev/charts/mychart/0.2.0/deprecate").Code, "500 with unreadable deprecations")
+	content, err = os.ReadFile(pathutil.Join(dir, "dev", chartDeprecationsFilename))
+	suite.Nil(err, "no error reading deprecations")
+	suite.Equal(saved, content, "deprecations kept")
+}
+
+func (suite *MultiTenantServerTestSuite) TestRepoStats() {
+	dir := pathutil.Join(suite.TempDirectory, "repostats")
+	suite.Nil(os.MkdirAll(pathutil.Join(dir, "dev"), 0755))
+	var storageBytes int64
+	save := func(name string, version string) []byte {
+		ch := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: version}}
+		filename, err := chartutil.Save(ch, suite.TempDirectory)
+		suite.Nil(err, "no error packaging %s-%s", name, version)
+		content, err := os.ReadFile(filename)
+		suite.Nil(err, "no error reading %s", filename)
+		suite.Nil(os.Remove(filename))
+		return content
+	}
+	for _, nameVersion := range [][2]string{{"app", "1.0.0"}, {"app", "1.1.0"}, {"lib", "1.0.0"}} {
+		content := save(nameVersion[0], nameVersion[1])
+		storageBytes += int64(len(content))
+		suite.Nil(os.WriteFile(pathutil.Join(dir, "dev", nameVersion[0]+"-"+nameVersion[1]+".tgz"), content, 0644))
+	}
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize, Depth: 1}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating repo stats server")
+	do := func(method string, path string, body []byte) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest(method, path, bytes.NewBuffer(body))
+		server.Router.HandleContext(c)
+		return recorder
+	}
+	stats := func() repoStats {
+		res := do("GET", "/api/dev/stats", nil)
+		suite.Equal(200, res.Code, "200 GET /api/dev/stats")
+		var stats repoStats
+		suite.Nil(json.Unmarshal(res.Body.Bytes(), &stats), "no error decoding repo stats")
+		return stats
+	}
+
+	got := stats()
+	suite.Equal(2, got.Charts, "charts counted")
+	suite.Equal(3, got.Versions, "versions counted")
+	suite.Equal(storageBytes, got.StorageBytes, "sizes of the packages read while indexing")
+	suite.Equal(0, got.UnsizedVersions, "every package sized")
+	suite.NotNil(got.NewestUpload, "newest upload set")
+	suite.False(got.IndexGenerated.IsZero(), "index generation time set")
+	suite.True(got.IndexBuildDuration > 0, "index build duration set")
+
+	content := save("db", "2.0.0")
+	res := do("POST", "/api/dev/charts", content)
+	suite.Equal(201, res.Code, "201 POST /api/dev/charts")
+	suite.Eventually(func() bool { return stats().Versions == 4 }, time.Second, 10*time.Millisecond, "upload applied to the stats")
+	got = stats()
+	suite.Equal(3, got.Charts, "uploaded chart counted")
+	suite.Equal(storageBytes+int64(len(content)), got.StorageBytes, "size of the uploaded package recorded")
+	suite.Equal(0, got.UnsizedVersions, "uploaded package sized")
+
+	res = do("DELETE", "/api/dev/charts/app/1.0.0", nil)
+	suite.Equal(200, res.Code, "200 DELETE /api/dev/charts/app/1.0.0")
+	suite.Eventually(func() bool { return stats().Versions == 3 }, time.Second, 10*time.Millisecond, "delete applied to the stats")
+	suite.Equal(3, stats().Charts, "chart with versions left still counted")
+
+	res = do("GET", "/api/prod/stats", nil)
+	suite.Equal(200, res.Code, "200 GET /api/prod/stats of an empty repo")
+	suite.JSONEq(`{"charts": 0, "versions": 0, "storage_bytes": 0, "unsized_versions": 0, "newest_upload": null}`,
+		stripRepoStatsTimes(res.Body.Bytes()), "empty repo stats")
+}
+
+// stripRepoStatsTimes drops the fields of repo stats depending on when the index was regenerated
+func stripRepoStatsTimes(content []byte) string {
+	var stats map[string]interface{}
+	_ = json.Unmarshal(content, &stats)
+	delete(stats, "index_generated")
+	delete(stats, "index_build_duration_seconds")
+	content, _ = json.Marshal(stats)
+	return string(content)
+}
+
+func (suite *MultiTenantServerTestSuite) TestChartReadme() {
+	dir := pathutil.Join(suite.TempDirectory, "readme")
+	suite.Nil(os.MkdirAll(dir, 0755), "no error creating readme dir")
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "readmechart", Version: "0.1.0"},
+		Files: []*chart.File{{Name: "README.md", Data: []byte(
+			"# readmechart\n\n<script>alert(1)</script>\n\n[docs](https://example.com) [click](javascript:alert(1))\n")}},
+	}
+	_, err := chartutil.Save(ch, dir)
+	suite.Nil(err, "no error packaging readmechart")
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating readme server")
+	get := func(path string, ifNoneMatch string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", path, nil)
+		if ifNoneMatch != "" {
+			c.Request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		server.Router.HandleContext(c)
+		return recorder
+	}
+
+	res := get("/api/charts/readmechart/0.1.0/readme", "")
+	suite.Equal(200, res.Code, "200 GET /api/charts/readmechart/0.1.0/readme")
+	suite.Equal("text/markdown; charset=utf-8", res.Header().Get("Content-Type"))
+	suite.Contains(res.Body.String(), "# readmechart", "raw markdown")
+	etag := res.Header().Get("ETag")
+	suite.NotEmpty(etag, "README tagged with the digest of the package")
+	suite.Equal(304, get("/api/charts/readmechart/latest/readme", etag).Code, "304 GET README held by the client")
+
+	res = get("/api/charts/readmechart/0.1.0/readme?format=html", "")
+	suite.Equal(200, res.Code, "200 GET /api/charts/readmechart/0.1.0/readme?format=html")
+	suite.Equal("text/html; charset=utf-8", res.Header().Get("Content-Type"))
+	suite.Contains(res.Body.String(), "<h1>readmechart</h1>", "rendered markdown")
+	suite.Contains(res.Body.String(), `href="https://example.com"`, "safe link kept")
+	suite.NotContains(res.Body.String(), "<script>", "raw HTML dropped")
+	suite.NotContains(res.Body.String(), `href="javascript:`, "unsafe link dropped")
+	_, found := server.chartFileCache.get("", strings.Trim(etag, `"`), "README.html")
+	suite.True(found, "rendered README cached")
+
+	suite.Equal(400, get("/api/charts/readmechart/0.1.0/readme?format=pdf", "").Code, "400 GET README of invalid format")
+	suite.Equal(404, get("/api/charts/readmechart/0.2.0/readme", "").Code, "404 GET README of missing version")
+}
+
+func (suite *MultiTenantServerTestSuite) TestChartMetadata() {
+	dir := pathutil.Join(suite.TempDirectory, "metadata")
+	suite.Nil(os.MkdirAll(dir, 0755), "no error creating metadata dir")
+	ch := &chart.Chart{Metadata: &chart.Metadata{
+		APIVersion:   chart.APIVersionV2,
+		Name:         "metadatachart",
+		Version:      "0.1.0",
+		KubeVersion:  ">=1.25.0",
+		Annotations:  map[string]string{"artifacthub.io/license": "Apache-2.0"},
+		Dependencies: []*chart.Dependency{{Name: "redis", Version: "17.x", Repository: "https://charts.example.com", Condition: "redis.enabled"}},
+	}}
+	_, err := chartutil.Save(ch, dir)
+	suite.Nil(err, "no error packaging metadatachart")
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating metadata server")
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("GET", "/api/charts/metadatachart/latest/metadata", nil)
+	server.Router.HandleContext(c)
+
+	suite.Equal(200, recorder.Code, "200 GET /api/charts/metadatachart/latest/metadata")
+	suite.NotEmpty(recorder.Header().Get("ETag"), "metadata tagged with the digest of the package")
+	var metadata chart.Metadata
+	suite.Nil(json.Unmarshal(recorder.Body.Bytes(), &metadata))
+	suite.Equal(ch.Metadata.Annotations, metadata.Annotations, "annotations of Chart.yaml")
+	suite.Equal(ch.Metadata.Dependencies, metadata.Dependencies, "dependencies of Chart.yaml")
+	suite.Equal(">=1.25.0", metadata.KubeVersion)
+}
+
+func (suite *MultiTenantServerTestSuite) TestSortedChartVersions() {
+	chartVersion := func(version string) *helm_repo.ChartVersion {
+		return &helm_repo.ChartVersion{Metadata: &chart.Metadata{Name: "mychart", Version: version}}
+	}
+	list := sortedChartVersions("mychart", helm_repo.ChartVersions{
+		chartVersion("1.2.0"), chartVersion("not-semver"), chartVersion("1.10.0-rc.1"),
+		chartVersion("1.10.0-beta.2"), chartVersion("v1.9.0"), chartVersion("0.9.0"),
+	})
+	suite.Equal("mychart", list.Name)
+	suite.Equal("v1.9.0", list.LatestStable)
+	suite.Equal("1.10.0-rc.1", list.LatestPrerelease)
+	var versions []string
+	for _, entry := range list.Versions {
+		versions = append(versions, entry.Version)
+	}
+	suite.Equal([]string{"1.10.0-rc.1", "1.10.0-beta.2", "v1.9.0", "1.2.0", "0.9.0", "not-semver"}, versions,
+		"sorted by semver rather than as strings, the versions that are not semver last")
+	suite.True(list.Versions[0].Prerelease)
+	suite.True(list.Versions[0].LatestPrerelease)
+	suite.False(list.Versions[1].LatestPrerelease, "older prerelease")
+	suite.True(list.Versions[2].LatestStable)
+	suite.False(list.Versions[3].LatestStable, "older stable version")
+	suite.False(list.Versions[5].Prerelease || list.Versions[5].LatestStable, "no flags for versions that are not semver")
+
+	list = sortedChartVersions("mychart", helm_repo.ChartVersions{chartVersion("0.1.0-alpha")})
+	suite.Equal("", list.LatestStable, "no stable version")
+	suite.Equal("0.1.0-alpha", list.LatestPrerelease)
+}
+
+func (suite *MultiTenantServerTestSuite) TestDownloadStats() {
+	dir := pathutil.Join(suite.TempDirectory, "downloadstats")
+	suite.Nil(os.MkdirAll(dir, 0755), "no error creating downloadstats dir")
+	for _, path := range []string{testTarballPath, testTarballPathV2} {
+		content, err := os.ReadFile(path)
+		suite.Nil(err)
+		suite.Nil(os.WriteFile(pathutil.Join(dir, pathutil.Base(path)), content, 0644))
+	}
+	redisMock, err := miniredis.Run()
+	suite.Nil(err, "able to create miniredis instance")
+	defer redisMock.Close()
+
+	logger := suite.Depth0Server.Logger
+	backend := storage.NewLocalFilesystemBackend(dir)
+	newServer := func(store string, externalCacheStore cache.Store) (*MultiTenantServer, error) {
+		return NewMultiTenantServer(MultiTenantServerOptions{
+			Logger:                logger,
+			Router:                cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+			StorageBackend:        backend,
+			ExternalCacheStore:    externalCacheStore,
+			EnableAPI:             true,
+			DownloadStats:         store,
+			DownloadStatsInterval: time.Hour,
+		})
+	}
+	get := func(server *MultiTenantServer, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", path, nil)
+		server.Router.HandleContext(c)
+		return recorder
+	}
+	stats := func(server *MultiTenantServer) chartDownloadStats {
+		res := get(server, "/api/charts/mychart/stats")
+		suite.Equal(200, res.Code, "200 GET /api/charts/mychart/stats")
+		var stats chartDownloadStats
+		suite.Nil(json.Unmarshal(res.Body.Bytes(), &stats))
+		return stats
+	}
+
+	for _, store := range []string{"storage", "cache"} {
+		server, err := newServer(store, cache.NewRedisStore(redisMock.Addr(), "", 0))
+		suite.Nil(err, "no error creating server with download stats in %s", store)
+		suite.Equal(200, get(server, "/charts/mychart-0.1.0.tgz").Code)
+		suite.Equal(200, get(server, "/charts/mychart-0.1.0.tgz").Code)
+		suite.Equal(200, get(server, "/charts/mychart-0.2.0.tgz").Code)
+		suite.Equal(404, get(server, "/charts/mychart-0.3.0.tgz").Code)
+		suite.Equal(chartDownloadStats{Name: "mychart", Downloads: 3, Versions: []versionDownloadStats{
+			{Version: "0.2.0", Downloads: 1},
+			{Version: "0.1.0", Downloads: 2},
+		}}, stats(server), "downloads counted before they are stored, the latest version first")
+
+		server.downloadStats.flush(server.Logger.ContextLoggingFn(&gin.Context{}))
+		suite.Equal(200, get(server, "/charts/mychart-0.1.0.tgz").Code)
+		restarted, err := newServer(store, cache.NewRedisStore(redisMock.Addr(), "", 0))
+		suite.Nil(err)
+		suite.Equal(int64(2), stats(restarted).Versions[1].Downloads, "stored downloads kept by %s", store)
+		suite.Equal(int64(3), stats(server).Versions[1].Downloads, "stored downloads and the ones not stored yet")
+		suite.Equal(404, get(server, "/api/charts/fakechart/stats").Code, "404 GET stats of a missing chart")
+	}
+
+	server, err := newServer("", nil)
+	suite.Nil(err)
+	suite.Equal(400, get(server, "/api/charts/mychart/stats").Code, "stats taken for a version without --download-stats")
+	_, err = newServer("cache", nil)
+	suite.EqualError(err, "download stats in the cache store require a redis cache store")
+	_, err = newServer("s3", nil)
+	suite.EqualError(err, `invalid download stats store "s3", must be memory, storage or cache`)
+}
+
+func (suite *MultiTenantServerTestSuite) TestDeleteChart() {
+	dir := pathutil.Join(suite.TempDirectory, "deletechart")
+	suite.Nil(os.MkdirAll(dir, 0755), "no error creating deletechart dir")
+	for _, path := range []string{testTarballPath, testProvfilePath, testTarballPathV2, testServiceTarballPathV0} {
+		content, err := os.ReadFile(path)
+		suite.Nil(err)
+		suite.Nil(os.WriteFile(pathutil.Join(dir, pathutil.Base(path)), content, 0644))
+	}
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating deletechart server")
+	do := func(method string, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest(method, path, nil)
+		server.Router.HandleContext(c)
+		return recorder
+	}
+
+	res := do("DELETE", "/api/charts/mychart")
+	suite.Equal(200, res.Code, "200 DELETE /api/charts/mychart")
+	var response struct {
+		Deleted  bool     `json:"deleted"`
+		Versions []string `json:"versions"`
+	}
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &response))
+	suite.True(response.Deleted)
+	suite.ElementsMatch([]string{"0.1.0", "0.2.0"}, response.Versions, "every version deleted")
+	for _, filename := range []string{"mychart-0.1.0.tgz", "mychart-0.1.0.tgz.prov", "mychart-0.2.0.tgz"} {
+		_, err := os.Stat(pathutil.Join(dir, filename))
+		suite.True(os.IsNotExist(err), "%s deleted from storage", filename)
+	}
+	suite.Eventually(func() bool { return do("GET", "/api/charts/mychart").Code == 404 }, time.Second, 10*time.Millisecond,
+		"chart dropped from the index")
+	suite.Equal(200, do("GET", "/api/charts/mychart-service").Code, "other charts kept")
+	suite.Equal(404, do("DELETE", "/api/charts/mychart").Code, "404 DELETE /api/charts/mychart once deleted")
+}
+
+func (suite *MultiTenantServerTestSuite) TestDeleteChartByDigest() {
+	dir := pathutil.Join(suite.TempDirectory, "deletedigest")
+	suite.Nil(os.MkdirAll(dir, 0755), "no error creating deletedigest dir")
+	for _, path := range []string{testTarballPath, testProvfilePath, testTarballPathV2} {
+		content, err := os.ReadFile(path)
+		suite.Nil(err)
+		suite.Nil(os.WriteFile(pathutil.Join(dir, pathutil.Base(path)), content, 0644))
+	}
+	content, err := os.ReadFile(testTarballPath)
+	suite.Nil(err)
+	digest := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating deletedigest server")
+	del := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("DELETE", "/api/charts?"+query, nil)
+		server.Router.HandleContext(c)
+		return recorder
+	}
+
+	suite.Equal(400, del("").Code, "400 DELETE /api/charts without digest")
+	suite.Equal(400, del("digest=sha512:"+digest).Code, "400 DELETE /api/charts with a sha512 digest")
+	suite.Equal(400, del("digest=sha256:1234").Code, "400 DELETE /api/charts with an invalid digest")
+	suite.Equal(404, del("digest=sha256:"+strings.Repeat("0", 64)).Code, "404 DELETE /api/charts with an unknown digest")
+
+	res := del("digest=sha256:" + strings.ToUpper(digest))
+	suite.Equal(200, res.Code, "200 DELETE /api/charts?digest=sha256:...")
+	suite.JSONEq(`{"deleted": true, "charts": [{"name": "mychart", "version": "0.1.0"}]}`, res.Body.String())
+	for _, filename := range []string{"mychart-0.1.0.tgz", "mychart-0.1.0.tgz.prov"} {
+		_, err := os.Stat(pathutil.Join(dir, filename))
+		suite.True(os.IsNotExist(err), "%s deleted from storage", filename)
+	}
+	_, err = os.Stat(pathutil.Join(dir, "mychart-0.2.0.tgz"))
+	suite.Nil(err, "other versions kept")
+	suite.Eventually(func() bool { return del("digest="+digest).Code == 404 }, time.Second, 10*time.Millisecond,
+		"version dropped from the index")
+}
+
+func (suite *MultiTenantServerTestSuite) TestHead() {
+	dir := pathutil.Join(suite.TempDirectory, "head")
+	suite.Nil(os.MkdirAll(dir, 0755), "no error creating head dir")
+	content, err := os.ReadFile(testTarballPath)
+	suite.Nil(err)
+	suite.Nil(os.WriteFile(pathutil.Join(dir, "mychart-0.1.0.tgz"), content, 0644))
+	digest := sha256.Sum256(content)
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating head server")
+	do := func(method string, urlStr string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest(method, urlStr, nil)
+		server.Router.HandleContext(c)
+		return recorder
+	}
+
+	get := do("GET", "/index.yaml")
+	res := do("HEAD", "/index.yaml")
+	suite.Equal(200, res.Code, "200 HEAD /index.yaml")
+	suite.Empty(res.Body.String(), "no body for HEAD /index.yaml")
+	suite.Equal(strconv.Itoa(get.Body.Len()), res.Header().Get("Content-Length"), "Content-Length of the index")
+	suite.Equal(get.Header().Get("ETag"), res.Header().Get("ETag"), "ETag of the index")
+	suite.NotEmpty(res.Header().Get("Last-Modified"), "Last-Modified of the index")
+
+	res = do("HEAD", "/charts/mychart-0.1.0.tgz")
+	suite.Equal(200, res.Code, "200 HEAD /charts/mychart-0.1.0.tgz")
+	suite.Empty(res.Body.String(), "no body for HEAD /charts/mychart-0.1.0.tgz")
+	suite.Equal(strconv.Itoa(len(content)), res.Header().Get("Content-Length"), "Content-Length of the package")
+	suite.Equal("sha-256="+base64.StdEncoding.EncodeToString(digest[:]), res.Header().Get("Digest"), "Digest of the package")
+	suite.Equal(fmt.Sprintf("%q", fmt.Sprintf("%x", digest)), res.Header().Get("ETag"), "ETag of the package")
+	_, err = http.ParseTime(res.Header().Get("Last-Modified"))
+	suite.Nil(err, "Last-Modified of the package")
+	suite.Equal(404, do("HEAD", "/charts/mychart-0.2.0.tgz").Code, "404 HEAD /charts/mychart-0.2.0.tgz")
+
+	get = do("GET", "/api/charts/mychart/0.1.0")
+	res = do("HEAD", "/api/charts/mychart/0.1.0")
+	suite.Equal(200, res.Code, "200 HEAD /api/charts/mychart/0.1.0")
+	suite.Empty(res.Body.String(), "no body for HEAD /api/charts/mychart/0.1.0")
+	suite.Equal(strconv.Itoa(get.Body.Len()), res.Header().Get("Content-Length"), "Content-Length of the chart version")
+	suite.Equal(fmt.Sprintf("%q", fmt.Sprintf("%x", digest)), res.Header().Get("ETag"), "ETag of the chart version")
+	suite.NotEmpty(res.Header().Get("Last-Modified"), "Last-Modified of the chart version")
+}
+
+func (suite *MultiTenantServerTestSuite) TestPromoteChartVersion() {
+	dir := pathutil.Join(suite.TempDirectory, "promote")
+	suite.Nil(os.MkdirAll(pathutil.Join(dir, "dev"), 0755), "no error creating promote dir")
+	for _, path := range []string{testTarballPath, testProvfilePath} {
+		content, err := os.ReadFile(path)
+		suite.Nil(err)
+		suite.Nil(os.WriteFile(pathutil.Join(dir, "dev", pathutil.Base(path)), content, 0644))
+	}
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, Depth: 1, MaxUploadSize: maxUploadSize}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating promote server")
+	do := func(method string, urlStr string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest(method, urlStr, nil)
+		server.Router.HandleContext(c)
+		return recorder
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(pathutil.Join(dir, path))
+		return err == nil
+	}
+
+	suite.Equal(400, do("POST", "/api/dev/charts/mychart/0.1.0/promote").Code, "400 promote without destination")
+	suite.Equal(400, do("POST", "/api/dev/charts/mychart/0.1.0/promote?to=dev").Code, "400 promote to the source")
+	suite.Equal(400, do("POST", "/api/dev/charts/mychart/0.1.0/promote?to=org/staging").Code, "400 promote to a repo of another depth")
+	suite.Equal(400, do("POST", "/api/dev/charts/mychart/0.1.0/promote?to=..").Code, "400 promote out of storage")
+	suite.Equal(404, do("POST", "/api/dev/charts/fakechart/0.1.0/promote?to=staging").Code, "404 promote unknown chart")
+
+	res := do("POST", "/api/dev/charts/mychart/0.1.0/promote?to=staging")
+	suite.Equal(201, res.Code, "201 promote to staging")
+	suite.JSONEq(`{"name": "mychart", "version": "0.1.0", "from": "dev", "to": "staging",
+		"saved": ["mychart-0.1.0.tgz", "mychart-0.1.0.tgz.prov"], "updated": false, "source_deleted": false}`, res.Body.String())
+	suite.True(exists("staging/mychart-0.1.0.tgz"), "package copied")
+	suite.True(exists("staging/mychart-0.1.0.tgz.prov"), "provenance file copied")
+	suite.True(exists("dev/mychart-0.1.0.tgz"), "source kept")
+	suite.Equal(409, do("POST", "/api/dev/charts/mychart/0.1.0/promote?to=staging").Code, "409 promote again")
+
+	suite.Eventually(func() bool { return do("GET", "/api/staging/charts/mychart/0.1.0").Code == 200 }, time.Second, 10*time.Millisecond,
+		"version added to the index of staging")
+	res = do("POST", "/api/staging/charts/mychart/latest/promote?to=prod&delete_source=true")
+	suite.Equal(201, res.Code, "201 promote latest to prod")
+	suite.Contains(res.Body.String(), `"source_deleted":true`)
+	suite.True(exists("prod/mychart-0.1.0.tgz"), "package moved")
+	suite.False(exists("staging/mychart-0.1.0.tgz"), "source package deleted")
+	suite.False(exists("staging/mychart-0.1.0.tgz.prov"), "source provenance file deleted")
+
+	suite.Equal(400, suite.doRequest("depth0", "POST", "/api/charts/mychart/0.1.0/promote?to=staging", nil, "").Status(),
+		"400 promote without other repos")
+
+	// promotions are writes
+	server, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, Depth: 1, IPWriteDeny: []string{"192.0.2.0/24"}}),
+		StorageBackend: storage.NewLocalFilesystemBackend(dir),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating promote server with write IP rules")
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/api/dev/charts/mychart/0.1.0/promote?to=qa", nil)
+	c.Request.RemoteAddr = "192.0.2.1:1234"
+	server.Router.HandleContext(c)
+	suite.Equal(403, recorder.Code, "403 promote from a client denied writes")
+	suite.False(exists("qa/mychart-0.1.0.tgz"), "package not copied")
+}
+
+func (suite *MultiTenantServerTestSuite) TestPullTokens() {
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger: logger,
+		Router: cm_router.NewRouter(cm_router.RouterOptions{
+			Logger:              logger,
+			Depth:               1,
+			MaxUploadSize:       maxUploadSize,
+			BearerAuth:          true,
+			AuthJWTSecret:       "secret",
+			AuthTokenEndpoint:   true,
+			AuthPullTokenMaxTTL: 72 * time.Hour,
+			Username:            "admin",
+			Password:            "secret",
+		}),
+		StorageBackend:         storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "pulltokens")),
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		EnableAPI:              true,
+	})
+	suite.Nil(err, "no error creating pull tokens server")
+
+	do := func(method string, urlStr string, body string, token string) (int, []byte) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest(method, urlStr, strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		server.Router.HandleContext(c)
+		return c.Writer.Status(), recorder.Body.Bytes()
+	}
+	issued, err := server.Router.IssuePullToken("admin", "org1", time.Hour)
+	suite.Nil(err)
+	status, _ := do("POST", "/api/org1/pull-tokens", "", issued.Token)
+	suite.Equal(401, status, "401 POST /api/org1/pull-tokens with a pull token")
+
+	// an admin token of the token endpoint
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", cm_router.TokenPath+"?scope=artifact-repository:org1:pull,admin", nil)
+	request.SetBasicAuth("admin", "secret")
+	server.Router.ServeHTTP(recorder, request)
+	suite.Equal(200, recorder.Code, "admin token issued")
+	var admin struct {
+		Token string `json:"token"`
+	}
+	suite.Nil(json.Unmarshal(recorder.Body.Bytes(), &admin))
+
+	status, body := do("POST", "/api/org1/pull-tokens", "", admin.Token)
+	suite.Equal(201, status, "201 POST /api/org1/pull-tokens without body")
+	token := cm_router.PullToken{}
+	suite.Nil(json.Unmarshal(body, &token))
+	suite.Equal("org1", token.Repo)
+	suite.Equal("admin", token.User, "token minted on behalf of the user")
+	suite.WithinDuration(time.Now().Add(24*time.Hour), token.Expires, time.Minute, "token valid for a day")
+
+	status, _ = do("GET", "/org1/index.yaml", "", token.Token)
+	suite.Equal(200, status, "200 GET /org1/index.yaml with the pull token")
+	status, _ = do("GET", "/org2/index.yaml", "", token.Token)
+	suite.Equal(401, status, "401 GET /org2/index.yaml with the pull token of another repo")
+	status, _ = do("POST", "/api/org1/pull-tokens", "", token.Token)
+	suite.Equal(401, status, "401 POST /api/org1/pull-tokens with the pull token")
+
+	status, body = do("POST", "/api/org1/pull-tokens", `{"name": "contractor", "expires_in": 3600}`, admin.Token)
+	suite.Equal(201, status, "201 POST /api/org1/pull-tokens expiring in an hour")
+	suite.Nil(json.Unmarshal(body, &token))
+	suite.WithinDuration(time.Now().Add(time.Hour), token.Expires, time.Minute, "token valid for an hour")
+	status, _ = do("POST", "/api/org1/pull-tokens", `{"expires_in": 604800}`, admin.Token)
+	suite.Equal(400, status, "400 POST /api/org1/pull-tokens beyond the longest lifetime")
+	status, _ = do("POST", "/api/org1/pull-tokens", `{"expires_in": -1}`, admin.Token)
+	suite.Equal(400, status, "400 POST /api/org1/pull-tokens with a negative lifetime")
+	status, _ = do("POST", "/api/org1/pull-tokens", `{`, admin.Token)
+	suite.Equal(400, status, "400 POST /api/org1/pull-tokens with invalid JSON")
+	status, _ = do("POST", "/api/org1/pull-tokens", "", "")
+	suite.Equal(401, status, "401 POST /api/org1/pull-tokens without credentials")
+
+	res := suite.doRequest("depth0", "POST", "/api/pull-tokens", nil, "")
+	suite.Equal(404, res.Status(), "404 POST /api/pull-tokens without the token endpoint")
+}
+func (suite *MultiTenantServerTestSuite) TestRequireProvenance() {
+	backend := storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "requireprov"))
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, Depth: 1, MaxUploadSize: maxUploadSize}),
+		StorageBackend:         backend,
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		EnableAPI:              true,
+	})
+	suite.Nil(err, "no error creating require provenance server")
+
+	required := true
+	suite.Nil(server.saveTenantSettings("signed", &tenantSettings{RequireProvenance: &required}), "no error saving tenant settings")
+
+	post := func(urlStr string, body io.Reader, contentType string) int {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("POST", urlStr, body)
+		c.Request.Header.Set("Content-Type", contentType)
+		server.Router.HandleContext(c)
+		return c.Writer.Status()
+	}
+	postTarball := func(urlStr string, path string) int {
+		content, err := os.ReadFile(path)
+		suite.Nil(err, "no error opening test tarball")
+		return post(urlStr, bytes.NewReader(content), "application/octet-stream")
+	}
+
+	suite.Equal(201, postTarball("/api/unsigned/charts", testTarballPath), "201 POST /api/unsigned/charts without provenance")
+	suite.Equal(400, postTarball("/api/signed/charts", testTarballPath), "400 POST /api/signed/charts without provenance")
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	suite.Equal(400, post("/api/signed/charts", buf, w.FormDataContentType()), "400 POST /api/signed/charts multipart without provenance")
+
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+	suite.Equal(201, post("/api/signed/charts", buf, w.FormDataContentType()), "201 POST /api/signed/charts with provenance")
+
+	// provenance file uploaded beforehand
+	content, err := os.ReadFile(otherTestProvfilePath)
+	suite.Nil(err, "no error opening other test provenance file")
+	suite.Equal(201, post("/api/signed/prov", bytes.NewReader(content), "application/octet-stream"), "201 POST /api/signed/prov")
+	suite.Equal(201, postTarball("/api/signed/charts", otherTestTarballPath), "201 POST /api/signed/charts with stored provenance")
+
+	// with a keyring, a provenance file not matching the chart is rejected
+	server.ProvenanceKeyring = "../../../../testdata/pgp/helm-test-key.pub"
+	content, err = os.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error opening test provenance file")
+	suite.Nil(backend.PutObject("signed/mychart-0.2.0.tgz.prov", content), "no error storing mismatched provenance file")
+	suite.Equal(400, postTarball("/api/signed/charts", testTarballPathV2), "400 POST /api/signed/charts with mismatched provenance")
+}
+
+func (suite *MultiTenantServerTestSuite) TestMultipartErrors() {
+	backend := storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "multiparterrors"))
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend:         backend,
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		EnableAPI:              true,
+		MultipartMaxMemory:     1024,
+	})
+	suite.Nil(err, "no error creating multipart errors server")
+
+	post := func(body io.Reader, contentType string) int {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("POST", "/api/charts", body)
+		c.Request.Header.Set("Content-Type", contentType)
+		server.Router.HandleContext(c)
+		c.Writer.WriteHeaderNow()
+		return recorder.Code
+	}
+
+	status := post(strings.NewReader("not a multipart body"), "multipart/form-data; boundary=xyz")
+	suite.Equal(400, status, "400 POST /api/charts with malformed multipart body")
+	status = post(strings.NewReader("--xyz\r\n"), "multipart/form-data")
+	suite.Equal(400, status, "400 POST /api/charts with multipart body without boundary")
+
+	// files over the memory limit are buffered on disk
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	suite.Equal(201, post(buf, w.FormDataContentType()), "201 POST /api/charts with chart over memory limit")
+
+	// form values are always kept in memory
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	suite.Nil(mw.WriteField("description", strings.Repeat("x", 11<<20)), "no error writing form field")
+	suite.Nil(mw.Close(), "no error closing multipart writer")
+	suite.Equal(413, post(body, mw.FormDataContentType()), "413 POST /api/charts with form values over memory limit")
+
+	body = &bytes.Buffer{}
+	mw = multipart.NewWriter(body)
+	part, err := mw.CreateFormFile("chart", "mychart-0.1.0.tgz")
+	suite.Nil(err, "no error creating form file")
+	part.Write(make([]byte, maxUploadSize+1))
+	suite.Nil(mw.Close(), "no error closing multipart writer")
+	suite.Equal(413, post(body, mw.FormDataContentType()), "413 POST /api/charts over max upload size")
+}
+
+func (suite *MultiTenantServerTestSuite) TestHealthDetails() {
+	buffer := bytes.NewBufferString("")
+	res := suite.doRequest("depth0", "GET", "/health", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 GET /health")
+	suite.JSONEq(`{"healthy": true}`, buffer.String(), "health without details")
+
+	suite.Depth0Server.HealthDetails = true
+	defer func() { suite.Depth0Server.HealthDetails = false }()
+	buffer = bytes.NewBufferString("")
+	res = suite.doRequest("depth0", "GET", "/health", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 GET /health with details")
+
+	var health struct {
+		Healthy       bool   `json:"healthy"`
+		Version       string `json:"version"`
+		UptimeSeconds int64  `json:"uptime_seconds"`
+		StartedAt     string `json:"started_at"`
+	}
+	suite.Nil(json.Unmarshal(buffer.Bytes(), &health), "no error parsing health response")
+	suite.True(health.Healthy, "healthy")
+	suite.Equal(suite.Depth0Server.Version, health.Version, "version in health")
+	suite.GreaterOrEqual(health.UptimeSeconds, int64(0), "uptime in health")
+	startedAt, err := time.Parse(time.RFC3339, health.StartedAt)
+	suite.Nil(err, "start time is RFC 3339")
+	suite.False(startedAt.After(time.Now()), "start time in the past")
+}
+
+func (suite *MultiTenantServerTestSuite) TestCaseInsensitiveNames() {
+	dir := pathutil.Join(suite.TempDirectory, "casenames")
+	suite.Nil(os.MkdirAll(dir, 0755), "no error creating casenames dir")
+	content, err := os.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	suite.Nil(os.WriteFile(pathutil.Join(dir, "mychart-0.1.0.tgz"), content, 0644), "no error storing mychart")
+
+	ch := &chart.Chart{Metadata: &chart.Metadata{
+		APIVersion: chart.APIVersionV2,
+		Name:       "MyChart",
+		Version:    "0.1.0",
+	}}
+	packageDir := pathutil.Join(suite.TempDirectory, "casenames-packages")
+	suite.Nil(os.MkdirAll(packageDir, 0755), "no error creating casenames packages dir")
+	mixedCasePath, err := chartutil.Save(ch, packageDir)
+	suite.Nil(err, "no error packaging MyChart")
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend:         storage.NewLocalFilesystemBackend(dir),
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		EnableAPI:              true,
+	})
+	suite.Nil(err, "no error creating case insensitive names server")
+
+	post := func(body io.Reader, contentType string, buffer *bytes.Buffer) int {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("POST", "/api/charts", body)
+		c.Request.Header.Set("Content-Type", contentType)
+		server.Router.HandleContext(c)
+		buffer.Write(recorder.Body.Bytes())
+		return c.Writer.Status()
+	}
+	mixedCaseContent, err := os.ReadFile(mixedCasePath)
+	suite.Nil(err, "no error opening mixed case tarball")
+
+	server.CaseInsensitiveNames = true
+	buffer := bytes.NewBufferString("")
+	suite.Equal(409, post(bytes.NewReader(mixedCaseContent), "application/octet-stream", buffer), "409 POST /api/charts MyChart")
+	suite.Contains(buffer.String(), "conflicts with existing chart mychart", "conflicting chart reported")
+
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{mixedCasePath})
+	buffer = bytes.NewBufferString("")
+	suite.Equal(409, post(buf, w.FormDataContentType(), buffer), "409 POST /api/charts multipart MyChart")
+	suite.Contains(buffer.String(), "conflicts with existing chart mychart", "conflicting chart reported")
+
+	// the same name with the same case is still accepted
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPathV2})
+	buffer = bytes.NewBufferString("")
+	suite.Equal(201, post(buf, w.FormDataContentType(), buffer), "201 POST /api/charts mychart 0.2.0")
+
+	// case-sensitive by default
+	server.CaseInsensitiveNames = false
+	buffer = bytes.NewBufferString("")
+	suite.Equal(201, post(bytes.NewReader(mixedCaseContent), "application/octet-stream", buffer), "201 POST /api/charts MyChart case-sensitive")
+}
+
+// alternateURLBackend serves every object from a fake content-addressed location
+type alternateURLBackend struct {
+	*storage.LocalFilesystemBackend
+}
+
+func (b alternateURLBackend) AlternateURL(path string) (string, bool) {
+	return "ipfs://" + path, true
+}
+
+func (suite *MultiTenantServerTestSuite) TestAlternateChartURL() {
+	dir := pathutil.Join(suite.TempDirectory, "alternateurl")
+	suite.Nil(os.MkdirAll(dir, 0755), "no error creating alternateurl dir")
+	content, err := os.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	suite.Nil(os.WriteFile(pathutil.Join(dir, "mychart-0.1.0.tgz"), content, 0644), "no error storing mychart")
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend:         alternateURLBackend{storage.NewLocalFilesystemBackend(dir)},
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		EnableAPI:              true,
+	})
+	suite.Nil(err, "no error creating alternate URL server")
+
+	chartURLs := func(name string) []string {
+		log := logger.ContextLoggingFn(&gin.Context{})
+		index, herr := server.getIndexFile(log, "")
+		suite.Nil(herr, "no error getting index")
+		for _, cv := range index.Entries[name] {
+			return cv.URLs
+		}
+		return nil
+	}
+	suite.Equal([]string{"charts/mychart-0.1.0.tgz", "ipfs://mychart-0.1.0.tgz"}, chartURLs("mychart"), "alternate URL of stored chart")
+
+	content, err = os.ReadFile(otherTestTarballPath)
+	suite.Nil(err, "no error opening other test tarball")
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/api/charts", bytes.NewBuffer(content))
+	server.Router.HandleContext(c)
+	suite.Equal(201, c.Writer.Status(), "201 POST /api/charts")
+	suite.Eventually(func() bool {
+		return len(chartURLs("otherchart")) == 2
+	}, time.Second, 10*time.Millisecond, "alternate URL of uploaded chart")
+	suite.Equal("ipfs://otherchart-0.1.0.tgz", chartURLs("otherchart")[1])
+}
+
+// presignerBackend presigns every object with a fake storage URL
+type presignerBackend struct {
+	*storage.LocalFilesystemBackend
+}
+
+func (b presignerBackend) PresignGetObject(path string, expiry time.Duration) (string, error) {
+	return "https://storage.example.com/" + path + "?expires=" + expiry.String(), nil
+}
+
+func (suite *MultiTenantServerTestSuite) TestRedirectDownloads() {
+	dir := pathutil.Join(suite.TempDirectory, "redirectdownloads")
+	suite.Nil(os.MkdirAll(dir, 0755), "no error creating redirectdownloads dir")
+	content, err := os.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	suite.Nil(os.WriteFile(pathutil.Join(dir, "mychart-0.1.0.tgz"), content, 0644), "no error storing mychart")
+	provContent, err := os.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error opening test provenance file")
+	suite.Nil(os.WriteFile(pathutil.Join(dir, "mychart-0.1.0.tgz.prov"), provContent, 0644), "no error storing mychart provenance")
+
+	newServer := func(backend storage.Backend) *MultiTenantServer {
+		logger := suite.Depth0Server.Logger
+		server, err := NewMultiTenantServer(MultiTenantServerOptions{
+			Logger:                 logger,
+			Router:                 cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+			StorageBackend:         backend,
+			ChartPostFormFieldName: "chart",
+			ProvPostFormFieldName:  "prov",
+			RedirectDownloads:      5 * time.Minute,
+		})
+		suite.Nil(err, "no error creating redirect server")
+		return server
+	}
+	get := func(server *MultiTenantServer, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", path, nil)
+		server.Router.HandleContext(c)
+		return recorder
+	}
+
+	server := newServer(presignerBackend{storage.NewLocalFilesystemBackend(dir)})
+	res := get(server, "/charts/mychart-0.1.0.tgz")
+	suite.Equal(302, res.Code, "302 GET /charts/mychart-0.1.0.tgz")
+	suite.Equal("https://storage.example.com/mychart-0.1.0.tgz?expires=5m0s", res.Header().Get("Location"), "presigned URL")
+	res = get(server, "/charts/mychart-0.1.0.tgz.prov")
+	suite.Equal(302, res.Code, "302 GET /charts/mychart-0.1.0.tgz.prov")
+	res = get(server, "/charts/mychart-0.1.0.zip")
+	suite.Equal(500, res.Code, "500 GET /charts/mychart-0.1.0.zip")
+	res = get(server, "/charts/mychart-0.2.0.tgz")
+	suite.Equal(404, res.Code, "404 GET /charts/mychart-0.2.0.tgz missing from storage")
+	suite.Empty(res.Header().Get("Location"), "missing chart not redirected")
+
+	server = newServer(storage.NewLocalFilesystemBackend(dir))
+	res = get(server, "/charts/mychart-0.1.0.tgz")
+	suite.Equal(200, res.Code, "200 GET /charts/mychart-0.1.0.tgz without presigned URLs")
+	suite.Equal(content, res.Body.Bytes(), "chart proxied")
+	suite.Equal(fmt.Sprint(len(content)), res.Header().Get("Content-Length"), "chart streamed with its size")
+}
+
+// pagedBackend lists objects a page at a time only, counting the pages listed
+type pagedBackend struct {
+	*cm_pkg_storage.MemoryBackend
+	pages int
+}
+
+func (b *pagedBackend) ListObjects(prefix string) ([]storage.Object, error) {
+	return nil, errors.New("listing all objects at once")
+}
+
+func (b *pagedBackend) ListObjectsPage(prefix string, token string, limit int) ([]storage.Object, string, error) {
+	b.pages++
+	return b.MemoryBackend.ListObjectsPage(prefix, token, limit)
+}
+
+func (suite *MultiTenantServerTestSuite) TestStorageListPages() {
+	backend := &pagedBackend{MemoryBackend: cm_pkg_storage.NewMemoryBackend()}
+	for _, path := range []string{testTarballPathV0, testTarballPath, testTarballPathV2, testProvfilePath} {
+		content, err := os.ReadFile(path)
+		suite.Nil(err, "no error reading %s", path)
+		suite.Nil(backend.PutObject(pathutil.Base(path), content))
+	}
+
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend:         backend,
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+		StorageListPageSize:    2,
+	})
+	suite.Nil(err, "no error creating paged server")
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("GET", "/index.yaml", nil)
+	server.Router.HandleContext(c)
+	suite.Equal(200, recorder.Code, "200 GET /index.yaml")
+	suite.Equal(2, backend.pages, "four objects listed two at a time")
+	for _, version := range []string{"0.0.1", "0.1.0", "0.2.0"} {
+		suite.Contains(recorder.Body.String(), "charts/mychart-"+version+".tgz", "charts of every page indexed")
+	}
+}
+
+// unavailableBackend fails every read once down
+type unavailableBackend struct {
+	storage.Backend
+	down bool
+}
+
+func (b *unavailableBackend) ListObjects(prefix string) ([]storage.Object, error) {
+	if b.down {
+		return nil, errors.New("connection timed out")
+	}
+	return b.Backend.ListObjects(prefix)
+}
+
+func (b *unavailableBackend) GetObject(path string) (storage.Object, error) {
+	if b.down {
+		return storage.Object{}, errors.New("connection timed out")
+	}
+	return b.Backend.GetObject(path)
+}
+
+func (suite *MultiTenantServerTestSuite) TestStorageCircuitBreaker() {
+	unavailable := &unavailableBackend{Backend: cm_pkg_storage.NewMemoryBackend()}
+	backend := cm_pkg_storage.NewCircuitBreakerBackend(unavailable, cm_pkg_storage.CircuitBreakerOptions{
+		Threshold: 1,
+		Cooldown:  90 * time.Second,
+	})
+	logger := suite.Depth0Server.Logger
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:                 logger,
+		Router:                 cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend:         backend,
+		ChartPostFormFieldName: "chart",
+		ProvPostFormFieldName:  "prov",
+	})
+	suite.Nil(err, "no error creating circuit breaker server")
+	unavailable.down = true
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", path, nil)
+		server.Router.HandleContext(c)
+		return recorder
+	}
+
+	res := get("/charts/mychart-0.1.0.tgz")
+	suite.Equal(404, res.Code, "404 GET /charts/mychart-0.1.0.tgz before the circuit opens")
+	suite.Empty(res.Header().Get("Retry-After"))
+
+	res = get("/charts/mychart-0.1.0.tgz")
+	suite.Equal(503, res.Code, "503 GET /charts/mychart-0.1.0.tgz while the circuit is open")
+	suite.Equal("90", res.Header().Get("Retry-After"), "retry after the cooldown")
+
+	res = get("/index.yaml")
+	suite.Equal(503, res.Code, "503 GET /index.yaml while the circuit is open")
+	suite.NotEmpty(res.Header().Get("Retry-After"))
+
+	res = get("/health")
+	suite.Equal(200, res.Code, "200 GET /health")
+}
+
+func (suite *MultiTenantServerTestSuite) TestArtifactHubRepoID() {
+	buffer := bytes.NewBufferString("")
+	res := suite.doRequest("artifacthub", "GET", "/artifacthub-repo.yml", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 GET /artifacthub-repo.yml")
+
+	artifactHubYmlString := buffer.Bytes()
+	artifactHubYmlFile := &repo.ArtifactHubFile{}
+	yaml.Unmarshal(artifactHubYmlString, artifactHubYmlFile)
+	suite.Equal(artifactHubYmlFile.RepoID, suite.ArtifactHubIds[""])
+}
+
+func (suite *MultiTenantServerTestSuite) TestArtifactHubRepoFile() {
+	dir := pathutil.Join(suite.TempDirectory, "artifacthub")
+	suite.Nil(os.MkdirAll(dir, 0755), "no error creating artifacthub dir")
+	repoFile := pathutil.Join(dir, "artifacthub-repo.yml")
+	content := "repositoryID: from-file\nowners:\n- name: owner\n  email: owner@example.com\n"
+	suite.Nil(os.WriteFile(repoFile, []byte(content), 0644), "no error writing artifacthub-repo.yml")
+
+	// a chart with Artifact Hub annotations, which must be kept in the index
+	backend := storage.NewLocalFilesystemBackend(dir)
+	ch := &chart.Chart{Metadata: &chart.Metadata{
+		APIVersion:  chart.APIVersionV2,
+		Name:        "annotated",
+		Version:     "0.1.0",
+		Annotations: map[string]string{"artifacthub.io/license": "Apache-2.0"},
+	}}
+	_, err := chartutil.Save(ch, dir)
+	suite.Nil(err, "no error packaging annotated chart")
+
+	logger := suite.Depth0Server.Logger
+	newServer := func(repoID map[string]string, repoFile map[string]string) (*MultiTenantServer, error) {
+		return NewMultiTenantServer(MultiTenantServerOptions{
+			Logger:              logger,
+			Router:              cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+			StorageBackend:      backend,
+			ArtifactHubRepoID:   repoID,
+			ArtifactHubRepoFile: repoFile,
+		})
+	}
+	get := func(server *MultiTenantServer, urlStr string) (int, []byte) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("GET", urlStr, nil)
+		server.Router.HandleContext(c)
+		return c.Writer.Status(), recorder.Body.Bytes()
+	}
+
+	server, err := newServer(nil, map[string]string{"": repoFile})
+	suite.Nil(err, "no error creating artifacthub server")
+	status, body := get(server, "/artifacthub-repo.yml")
+	suite.Equal(200, status, "200 GET /artifacthub-repo.yml")
+	artifactHubFile := &repo.ArtifactHubFile{}
+	suite.Nil(yaml.Unmarshal(body, artifactHubFile), "no error parsing artifacthub-repo.yml")
+	suite.Equal("from-file", artifactHubFile.RepoID, "repository ID from file")
+	suite.Equal([]repo.ArtifactHubOwner{{Name: "owner", Email: "owner@example.com"}}, artifactHubFile.Owners, "owners from file")
+
+	_, body = get(server, "/index.yaml")
+	suite.Contains(string(body), "artifacthub.io/license: Apache-2.0", "Artifact Hub annotation in index")
+
+	server, err = newServer(map[string]string{"": "from-flag"}, map[string]string{"": repoFile})
+	suite.Nil(err, "no error creating artifacthub server")
+	_, body = get(server, "/artifacthub-repo.yml")
+	artifactHubFile = &repo.ArtifactHubFile{}
+	suite.Nil(yaml.Unmarshal(body, artifactHubFile), "no error parsing artifacthub-repo.yml")
+	suite.Equal("from-flag", artifactHubFile.RepoID, "repository ID flag takes precedence")
+	suite.Len(artifactHubFile.Owners, 1, "owners from file with repository ID flag")
+
+	suite.Nil(os.WriteFile(repoFile, []byte("repository: typo\n"), 0644), "no error writing artifacthub-repo.yml")
+	_, err = newServer(nil, map[string]string{"": repoFile})
+	suite.NotNil(err, "error creating server with invalid artifacthub-repo.yml")
+}
+
+func (suite *MultiTenantServerTestSuite) TestRoutes() {
+	suite.testAllRoutes("", 0)
+	for org, teams := range suite.StorageDirectory {
+		suite.testAllRoutes(org, 1)
+		for team, repos := range teams {
+			suite.testAllRoutes(pathutil.Join(org, team), 2)
+			for _, repo := range repos {
+				suite.testAllRoutes(pathutil.Join(org, team, repo), 3)
+			}
+		}
+	}
+}
+
+func (suite *MultiTenantServerTestSuite) testAllRoutes(repo string, depth int) {
+	var res gin.ResponseWriter
+
+	stype := fmt.Sprintf("depth%d", depth)
+
+	// GET /
+	res = suite.doRequest(stype, "GET", "/", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /")
+
+	// GET /health
+	res = suite.doRequest(stype, "GET", "/health", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /health")
+
+	var repoPrefix string
+	if repo != "" {
+		repoPrefix = pathutil.Join("/", repo)
+	} else {
+		repoPrefix = ""
+	}
+
+	// GET /:repo/index.yaml
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/index.yaml", repoPrefix), nil, "")
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/index.yaml", repoPrefix))
+
+	// HEAD /:repo/index.yaml
+	res = suite.doRequest(stype, "HEAD", fmt.Sprintf("%s/index.yaml", repoPrefix), nil, "")
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 HEAD %s/index.yaml", repoPrefix))
+
+	// GET /:repo/artifacthub-repo.yaml
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/artifacthub-repo.yml", repoPrefix), nil, "")
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/artifacthub-repo.yml", repoPrefix))
+
+	// Issue #21
+	suite.NotEqual("", res.Header().Get("X-Request-Id"), "X-Request-Id header is present")
+	suite.Equal("", res.Header().Get("X-Blah-Blah-Blah"), "X-Blah-Blah-Blah header is not present")
+
+	// GET /:repo/charts/:filename
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart-0.1.0.tgz", repoPrefix), nil, "")
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/mychart-0.1.0.tgz", repoPrefix))
+
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart-0.1.0.tgz.prov", repoPrefix), nil, "")
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/mychart-0.1.0.tgz.prov", repoPrefix))
+
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/fakechart-0.1.0.tgz", repoPrefix), nil, "")
+	suite.Equal(404, res.Status(), fmt.Sprintf("404 GET %s/charts/fakechart-0.1.0.tgz", repoPrefix))
+
+	// HEAD /:repo/charts/:filename
+	res = suite.doRequest(stype, "HEAD", fmt.Sprintf("%s/charts/mychart-0.1.0.tgz", repoPrefix), nil, "")
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 HEAD %s/charts/mychart-0.1.0.tgz", repoPrefix))
+
+	res = suite.doRequest(stype, "HEAD", fmt.Sprintf("%s/charts/fakechart-0.1.0.tgz", repoPrefix), nil, "")
+	suite.Equal(404, res.Status(), fmt.Sprintf("404 HEAD %s/charts/fakechart-0.1.0.tgz", repoPrefix))
+
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/fakechart-0.1.0.tgz.prov", repoPrefix), nil, "")
+	suite.Equal(404, res.Status(), fmt.Sprintf("404 GET %s/charts/fakechart-0.1.0.tgz.prov", repoPrefix))
+
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/fakechart-0.1.0.bad", repoPrefix), nil, "")
+	suite.Equal(500, res.Status(), fmt.Sprintf("500 GET %s/charts/fakechart-0.1.0.bad", repoPrefix))
 
 	apiPrefix := pathutil.Join("/api", repo)
 
@@ -1126,6 +4173,59 @@ func (suite *MultiTenantServerTestSuite) testAllRoutes(repo string, depth int) {
 	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts?offset=-1&limit=5", apiPrefix), nil, "")
 	suite.Equal(400, res.Status(), fmt.Sprintf("400 GET %s/charts?limit=0", apiPrefix))
 
+	// GET /api/:repo/charts?sort=-name
+	buffer := bytes.NewBuffer(nil)
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts?sort=-name", apiPrefix), nil, "", buffer)
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts?sort=-name", apiPrefix))
+	var sorted []helm_repo.ChartVersions
+	suite.Nil(json.Unmarshal(buffer.Bytes(), &sorted))
+	suite.NotEmpty(sorted)
+	for i := 1; i < len(sorted); i++ {
+		suite.Greater(sorted[i-1][0].Name, sorted[i][0].Name, "charts in the reverse order of names")
+	}
+	suite.Equal(strconv.Itoa(len(sorted)), res.Header().Get("X-Total-Count"), "total number of charts")
+	suite.Empty(res.Header().Get("Link"), "no next page")
+
+	// GET /api/:repo/charts?sort=created&limit=1
+	buffer.Reset()
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts?sort=created&limit=1", apiPrefix), nil, "", buffer)
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts?sort=created&limit=1", apiPrefix))
+	suite.Nil(json.Unmarshal(buffer.Bytes(), &sorted))
+	suite.Len(sorted, 1, "a page of one chart")
+	if res.Header().Get("X-Total-Count") != "1" {
+		suite.Equal(fmt.Sprintf(`<%s/charts?limit=1&offset=1&sort=created>; rel="next"`, apiPrefix), res.Header().Get("Link"), "link to the next page")
+	}
+
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts?sort=size", apiPrefix), nil, "")
+	suite.Equal(400, res.Status(), fmt.Sprintf("400 GET %s/charts?sort=size", apiPrefix))
+
+	// GET /api/:repo/charts/search
+	buffer.Reset()
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/search?q=MyChart", apiPrefix), nil, "", buffer)
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/search?q=MyChart", apiPrefix))
+	var results []chartSearchResult
+	suite.Nil(json.Unmarshal(buffer.Bytes(), &results))
+	if suite.NotEmpty(results, "chart found by name") {
+		suite.Equal("mychart", results[0].Name, "exact name ranked first")
+		suite.Equal(nameExactScore, results[0].Score)
+	}
+
+	buffer.Reset()
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/search?q=%%5Emy.%%2At%%24&regex=true", apiPrefix), nil, "", buffer)
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/search?q=^my.*t$&regex=true", apiPrefix))
+	suite.Contains(buffer.String(), `"name":"mychart"`, "chart found by regexp")
+
+	buffer.Reset()
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/search?q=fakechart", apiPrefix), nil, "", buffer)
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/search?q=fakechart", apiPrefix))
+	suite.Equal("[]", buffer.String(), "no chart found")
+
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/search", apiPrefix), nil, "")
+	suite.Equal(400, res.Status(), fmt.Sprintf("400 GET %s/charts/search without query", apiPrefix))
+
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/search?q=%%28&regex", apiPrefix), nil, "")
+	suite.Equal(400, res.Status(), fmt.Sprintf("400 GET %s/charts/search?q=(&regex", apiPrefix))
+
 	// GET /api/:repo/charts/:name
 	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart", apiPrefix), nil, "")
 	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/mychart", apiPrefix))
@@ -1140,6 +4240,18 @@ func (suite *MultiTenantServerTestSuite) testAllRoutes(repo string, depth int) {
 	res = suite.doRequest(stype, "HEAD", fmt.Sprintf("%s/charts/fakechart", apiPrefix), nil, "")
 	suite.Equal(404, res.Status(), fmt.Sprintf("404 HEAD %s/charts/fakechart", apiPrefix))
 
+	// GET /api/:repo/charts/:name?constraint=
+	buffer.Reset()
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart?constraint=%%3C1.0.0", apiPrefix), nil, "", buffer)
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/mychart?constraint=<1.0.0", apiPrefix))
+	suite.Contains(buffer.String(), `"version":"0.1.0"`, "matching version")
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart?constraint=%%5E9.x", apiPrefix), nil, "")
+	suite.Equal(404, res.Status(), fmt.Sprintf("404 GET %s/charts/mychart?constraint=^9.x", apiPrefix))
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart?constraint=latest", apiPrefix), nil, "")
+	suite.Equal(400, res.Status(), fmt.Sprintf("400 GET %s/charts/mychart?constraint=latest", apiPrefix))
+	res = suite.doRequest(stype, "HEAD", fmt.Sprintf("%s/charts/mychart?constraint=%%5E9.x", apiPrefix), nil, "")
+	suite.Equal(404, res.Status(), fmt.Sprintf("404 HEAD %s/charts/mychart?constraint=^9.x", apiPrefix))
+
 	// GET /api/:repo/charts/:name/:version
 	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart/0.1.0", apiPrefix), nil, "")
 	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/mychart/0.1.0", apiPrefix))
@@ -1169,6 +4281,7 @@ func (suite *MultiTenantServerTestSuite) testAllRoutes(repo string, depth int) {
 	// GET /api/:repo/charts/:name/:version/values
 	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart/0.1.0/values", apiPrefix), nil, "")
 	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/mychart/0.1.0/values", apiPrefix))
+	suite.NotEmpty(res.Header().Get("ETag"), "values tagged with the digest of the package")
 
 	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart/latest/values", apiPrefix), nil, "")
 	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/mychart/latest/values", apiPrefix))
@@ -1179,6 +4292,39 @@ func (suite *MultiTenantServerTestSuite) testAllRoutes(repo string, depth int) {
 	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/fakechart/0.1.0/values", apiPrefix), nil, "")
 	suite.Equal(404, res.Status(), fmt.Sprintf("404 GET %s/charts/fakechart/0.1.0/values", apiPrefix))
 
+	// GET /api/:repo/charts/:name/versions
+	buffer = bytes.NewBufferString("")
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart/versions", apiPrefix), nil, "", buffer)
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/mychart/versions", apiPrefix))
+	var versionList chartVersionList
+	suite.Nil(json.Unmarshal(buffer.Bytes(), &versionList), "versions as JSON")
+	suite.Equal("mychart", versionList.Name)
+	suite.NotEmpty(versionList.Versions)
+	suite.Equal(versionList.Versions[0].Version, versionList.LatestStable, "latest stable version first")
+	suite.True(versionList.Versions[0].LatestStable)
+
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/fakechart/versions", apiPrefix), nil, "")
+	suite.Equal(404, res.Status(), fmt.Sprintf("404 GET %s/charts/fakechart/versions", apiPrefix))
+
+	// GET /api/:repo/charts/:name/:version/readme
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart/0.1.0/readme", apiPrefix), nil, "")
+	suite.Equal(404, res.Status(), fmt.Sprintf("404 GET %s/charts/mychart/0.1.0/readme", apiPrefix))
+
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/fakechart/0.1.0/readme", apiPrefix), nil, "")
+	suite.Equal(404, res.Status(), fmt.Sprintf("404 GET %s/charts/fakechart/0.1.0/readme", apiPrefix))
+
+	// GET /api/:repo/charts/:name/:version/metadata
+	buffer = bytes.NewBufferString("")
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/mychart/0.1.0/metadata", apiPrefix), nil, "", buffer)
+	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/charts/mychart/0.1.0/metadata", apiPrefix))
+	var metadata chart.Metadata
+	suite.Nil(json.Unmarshal(buffer.Bytes(), &metadata), "Chart.yaml as JSON")
+	suite.Equal("mychart", metadata.Name)
+	suite.Equal("0.1.0", metadata.Version)
+
+	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/charts/fakechart/0.1.0/metadata", apiPrefix), nil, "")
+	suite.Equal(404, res.Status(), fmt.Sprintf("404 GET %s/charts/fakechart/0.1.0/metadata", apiPrefix))
+
 	// HEAD /api/:repo/charts/:name/:version
 	res = suite.doRequest(stype, "HEAD", fmt.Sprintf("%s/charts/mychart/0.1.0", apiPrefix), nil, "")
 	suite.Equal(200, res.Status(), fmt.Sprintf("200 HEAD %s/charts/mychart/0.1.0", apiPrefix))
@@ -1199,6 +4345,17 @@ func (suite *MultiTenantServerTestSuite) testAllRoutes(repo string, depth int) {
 	res = suite.doRequest(stype, "DELETE", fmt.Sprintf("%s/charts/mychart/0.1.0", apiPrefix), nil, "")
 	suite.Equal(404, res.Status(), fmt.Sprintf("200 DELETE %s/charts/mychart/0.1.0", apiPrefix))
 
+	// DELETE /api/:repo/charts/:name
+	res = suite.doRequest(stype, "DELETE", fmt.Sprintf("%s/charts/fakechart", apiPrefix), nil, "")
+	suite.Equal(404, res.Status(), fmt.Sprintf("404 DELETE %s/charts/fakechart", apiPrefix))
+
+	// DELETE /api/:repo/charts?digest=
+	res = suite.doRequest(stype, "DELETE", fmt.Sprintf("%s/charts", apiPrefix), nil, "")
+	suite.Equal(400, res.Status(), fmt.Sprintf("400 DELETE %s/charts without digest", apiPrefix))
+
+	res = suite.doRequest(stype, "DELETE", fmt.Sprintf("%s/charts?digest=sha256:%s", apiPrefix, strings.Repeat("0", 64)), nil, "")
+	suite.Equal(404, res.Status(), fmt.Sprintf("404 DELETE %s/charts with an unknown digest", apiPrefix))
+
 	// GET /:repo/index.yaml (after delete)
 	res = suite.doRequest(stype, "GET", fmt.Sprintf("%s/index.yaml", repoPrefix), nil, "")
 	suite.Equal(200, res.Status(), fmt.Sprintf("200 GET %s/index.yaml", repoPrefix))
@@ -0,0 +1,102 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"encoding/json"
+	"net/http"
+	pathutil "path"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_pkg_storage "helm.sh/chartmuseum/pkg/storage"
+)
+
+const (
+	// tenantSettingsFilename is the object storing the runtime settings of a repo, next to its charts
+	tenantSettingsFilename = "tenant-settings.json"
+)
+
+type (
+	// tenantSettings are per-repo overrides of server options, a nil field means the server option applies
+	tenantSettings struct {
+		AllowOverwrite    *bool `json:"allow_overwrite"`
+		RequireProvenance *bool `json:"require_provenance"`
+	}
+)
+
+// getTenantSettings reads the settings of a repo from storage, so that changes made through
+// any instance are seen by all of them
+func (server *MultiTenantServer) getTenantSettings(repo string) (*tenantSettings, *HTTPError) {
+	settings := &tenantSettings{}
+	object, err := server.StorageBackend.GetObject(pathutil.Join(repo, tenantSettingsFilename))
+	if cm_pkg_storage.IsNotFound(err) {
+		// no settings saved for this repo
+		return settings, nil
+	}
+	if err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, "cannot read tenant settings: " + err.Error()}
+	}
+	if err := json.Unmarshal(object.Content, settings); err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, "invalid tenant settings: " + err.Error()}
+	}
+	return settings, nil
+}
+
+func (server *MultiTenantServer) saveTenantSettings(repo string, settings *tenantSettings) *HTTPError {
+	content, err := json.Marshal(settings)
+	if err != nil {
+		return &HTTPError{http.StatusInternalServerError, err.Error()}
+	}
+	if err := server.StorageBackend.PutObject(pathutil.Join(repo, tenantSettingsFilename), content); err != nil {
+		return &HTTPError{http.StatusInternalServerError, err.Error()}
+	}
+	return nil
+}
+
+// allowOverwrite returns whether charts can be re-uploaded to a repo without ?force,
+// the repo setting taking precedence over the server option. It fails if the settings of the
+// repo cannot be read, rather than guessing
+func (server *MultiTenantServer) allowOverwrite(log cm_logger.LoggingFn, repo string) (bool, *HTTPError) {
+	settings, err := server.getTenantSettings(repo)
+	if err != nil {
+		log(cm_logger.ErrorLevel, err.Message,
+			"repo", repo,
+		)
+		return false, err
+	}
+	if settings.AllowOverwrite != nil {
+		return *settings.AllowOverwrite, nil
+	}
+	return server.AllowOverwrite, nil
+}
+
+// requireProvenance returns whether charts uploaded to a repo must come with a provenance file,
+// the repo setting taking precedence over the server option. It fails if the settings of the
+// repo cannot be read, so that uploads are not let through without provenance
+func (server *MultiTenantServer) requireProvenance(log cm_logger.LoggingFn, repo string) (bool, *HTTPError) {
+	settings, err := server.getTenantSettings(repo)
+	if err != nil {
+		log(cm_logger.ErrorLevel, err.Message,
+			"repo", repo,
+		)
+		return false, err
+	}
+	if settings.RequireProvenance != nil {
+		return *settings.RequireProvenance, nil
+	}
+	return server.RequireProvenance, nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	pathutil "path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+)
+
+type (
+	// repoStats are the statistics of a repo, computed when its index is regenerated and saved with
+	// it, so that serving them is cheap
+	repoStats struct {
+		Charts       int   `json:"charts"`
+		Versions     int   `json:"versions"`
+		StorageBytes int64 `json:"storage_bytes"`
+		// UnsizedVersions counts the versions whose package size is not known, such as the ones read
+		// from a statefile, left out of StorageBytes
+		UnsizedVersions    int        `json:"unsized_versions"`
+		NewestUpload       *time.Time `json:"newest_upload"`
+		IndexGenerated     time.Time  `json:"index_generated"`
+		IndexBuildDuration float64    `json:"index_build_duration_seconds"`
+	}
+)
+
+func (server *MultiTenantServer) getRepoStatsRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	log := server.Logger.ContextLoggingFn(c)
+	if _, err := server.getIndexFile(log, repo); err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	entry, err := server.initCacheEntry(log, repo)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	entry.RepoLock.Lock()
+	if entry.Stats == nil {
+		// cached before stats were kept, the build duration is unknown until the index is regenerated
+		server.updateRepoStats(entry, time.Time{})
+	}
+	stats := *entry.Stats
+	entry.RepoLock.Unlock()
+	c.JSON(200, stats)
+}
+
+// recordPackageSize keeps the size of a chart package stored in repo, for the stats of the repo
+// once its index is next regenerated
+func (server *MultiTenantServer) recordPackageSize(repo string, filename string, size int) {
+	if !strings.HasSuffix(filename, cm_repo.ChartPackageFileExtension) {
+		return
+	}
+	server.packageSizes.Store(pathutil.Join(repo, pathutil.Base(filename)), int64(size))
+}
+
+// flushPackageSizes drops the sizes recorded for the chart packages of repo, returning how many
+// were dropped. They are recorded again as the packages are read to rebuild the index
+func (server *MultiTenantServer) flushPackageSizes(repo string) int {
+	flushed := 0
+	server.packageSizes.Range(func(key, _ interface{}) bool {
+		path := key.(string)
+		if pathutil.Join(repo, pathutil.Base(path)) == path {
+			server.packageSizes.Delete(key)
+			flushed++
+		}
+		return true
+	})
+	return flushed
+}
+
+// updateRepoStats updates the stats of the repo of entry once its index is regenerated, started
+// being when the regeneration started. The sizes of the packages are kept by digest, so that only
+// the versions added or updated since the last regeneration are sized, from the sizes recorded when
+// their packages were stored or read. The caller must hold the lock of entry
+func (server *MultiTenantServer) updateRepoStats(entry *cacheEntry, started time.Time) {
+	index := entry.RepoIndex
+	stats := &repoStats{
+		Charts:         len(index.Entries),
+		IndexGenerated: index.Generated,
+	}
+	if !started.IsZero() {
+		stats.IndexBuildDuration = time.Since(started).Seconds()
+	}
+	sizes := make(map[string]int64, len(entry.PackageSizes))
+	for _, chartVersions := range index.Entries {
+		for _, chartVersion := range chartVersions {
+			stats.Versions++
+			if stats.NewestUpload == nil || chartVersion.Created.After(*stats.NewestUpload) {
+				created := chartVersion.Created
+				stats.NewestUpload = &created
+			}
+			size, found := entry.PackageSizes[chartVersion.Digest]
+			if filename, err := chartFileName(chartVersion); err == nil {
+				if recorded, ok := server.packageSizes.LoadAndDelete(pathutil.Join(entry.RepoName, filename)); ok {
+					size, found = recorded.(int64), true
+				}
+			}
+			if !found || chartVersion.Digest == "" {
+				stats.UnsizedVersions++
+				continue
+			}
+			sizes[chartVersion.Digest] = size
+			stats.StorageBytes += size
+		}
+	}
+	entry.PackageSizes = sizes
+	entry.Stats = stats
+}
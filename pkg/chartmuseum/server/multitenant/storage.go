@@ -17,14 +17,21 @@ limitations under the License.
 package multitenant
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	pathutil "path"
+	"strconv"
 	"strings"
+	"time"
 
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
 	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	cm_pkg_storage "helm.sh/chartmuseum/pkg/storage"
 
 	"github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
 )
 
 var (
@@ -33,6 +40,13 @@ var (
 )
 
 type (
+	// circuitWriter answers 503 with a Retry-After header instead of 500 while the circuit
+	// breaker of the storage backend is open, so that clients back off until it is retried
+	circuitWriter struct {
+		gin.ResponseWriter
+		backend storage.Backend
+	}
+
 	StorageObject struct {
 		*storage.Object
 		ContentType string
@@ -40,14 +54,9 @@ type (
 )
 
 func (server *MultiTenantServer) getStorageObject(log cm_logger.LoggingFn, repo string, filename string) (*StorageObject, *HTTPError) {
-	isChartPackage := strings.HasSuffix(filename, cm_repo.ChartPackageFileExtension)
-	isProvenanceFile := strings.HasSuffix(filename, cm_repo.ProvenanceFileExtension)
-	if !isChartPackage && !isProvenanceFile {
-		log(cm_logger.WarnLevel, "unsupported file extension",
-			"repo", repo,
-			"filename", filename,
-		)
-		return nil, &HTTPError{http.StatusInternalServerError, "unsupported file extension"}
+	contentType, herr := storageObjectContentType(log, repo, filename)
+	if herr != nil {
+		return nil, herr
 	}
 
 	objectPath := pathutil.Join(repo, filename)
@@ -60,14 +69,7 @@ func (server *MultiTenantServer) getStorageObject(log cm_logger.LoggingFn, repo
 			"filename", filename,
 		)
 		// TODO determine if this is true 404
-		return nil, &HTTPError{http.StatusNotFound, "object not found"}
-	}
-
-	var contentType string
-	if isProvenanceFile {
-		contentType = provenanceFileContentType
-	} else {
-		contentType = chartPackageContentType
+		return nil, storageObjectError(err)
 	}
 
 	storageObject := &StorageObject{
@@ -77,3 +79,117 @@ func (server *MultiTenantServer) getStorageObject(log cm_logger.LoggingFn, repo
 
 	return storageObject, nil
 }
+
+// getStorageObjectStream is getStorageObject reading the content as it is sent, the size is -1
+// if unknown
+func (server *MultiTenantServer) getStorageObjectStream(log cm_logger.LoggingFn, repo string, filename string) (io.ReadCloser, int64, string, *HTTPError) {
+	contentType, herr := storageObjectContentType(log, repo, filename)
+	if herr != nil {
+		return nil, 0, "", herr
+	}
+
+	reader, size, err := cm_pkg_storage.GetObjectStream(server.StorageBackend, pathutil.Join(repo, filename))
+	if err != nil {
+		log(cm_logger.WarnLevel, err.Error(),
+			"repo", repo,
+			"filename", filename,
+		)
+		return nil, 0, "", storageObjectError(err)
+	}
+	return reader, size, contentType, nil
+}
+
+// storageObjectError is the response to a failure to read an object, a 404 unless the storage
+// circuit breaker is open
+func storageObjectError(err error) *HTTPError {
+	if errors.Is(err, cm_pkg_storage.ErrCircuitOpen) {
+		return &HTTPError{http.StatusServiceUnavailable, err.Error()}
+	}
+	return &HTTPError{http.StatusNotFound, "object not found"}
+}
+
+func storageObjectContentType(log cm_logger.LoggingFn, repo string, filename string) (string, *HTTPError) {
+	switch {
+	case strings.HasSuffix(filename, cm_repo.ChartPackageFileExtension):
+		return chartPackageContentType, nil
+	case strings.HasSuffix(filename, cm_repo.ProvenanceFileExtension):
+		return provenanceFileContentType, nil
+	}
+	log(cm_logger.WarnLevel, "unsupported file extension",
+		"repo", repo,
+		"filename", filename,
+	)
+	return "", &HTTPError{http.StatusInternalServerError, "unsupported file extension"}
+}
+
+// addAlternateChartURL appends the location the storage backend serves a chart package from,
+// such as the CID of the package with IPFS, to the URLs of a chart version
+func (server *MultiTenantServer) addAlternateChartURL(repo string, chartVersion *helm_repo.ChartVersion) {
+	if len(chartVersion.URLs) == 0 {
+		return
+	}
+	alternateURL, ok := cm_pkg_storage.AlternateURL(server.StorageBackend, pathutil.Join(repo, pathutil.Base(chartVersion.URLs[0])))
+	if !ok {
+		return
+	}
+	for _, u := range chartVersion.URLs[1:] {
+		if u == alternateURL {
+			return
+		}
+	}
+	chartVersion.URLs = append(chartVersion.URLs, alternateURL)
+}
+
+// presignStorageObject returns a URL of the storage to download a chart package or provenance
+// file from, if RedirectDownloads is set and the storage backend supports presigned URLs. Objects
+// missing from the storage are not redirected to, so that they are answered with a 404 and, for a
+// chart package removed out of band, dropped from the index
+func (server *MultiTenantServer) presignStorageObject(log cm_logger.LoggingFn, repo string, filename string) (string, bool) {
+	if server.RedirectDownloads <= 0 {
+		return "", false
+	}
+	if !strings.HasSuffix(filename, cm_repo.ChartPackageFileExtension) && !strings.HasSuffix(filename, cm_repo.ProvenanceFileExtension) {
+		return "", false
+	}
+	// charts missing from storage are fetched from the upstream repo
+	if _, ok := server.UpstreamURLs[repo]; ok {
+		return "", false
+	}
+	signedURL, err := cm_pkg_storage.Presign(server.StorageBackend, pathutil.Join(repo, filename), server.RedirectDownloads)
+	if err != nil {
+		if err != cm_pkg_storage.ErrPresignUnsupported {
+			log(cm_logger.WarnLevel, "error presigning storage object, proxying download",
+				"repo", repo,
+				"filename", filename,
+				"error", err.Error(),
+			)
+		}
+		return "", false
+	}
+	reader, _, err := cm_pkg_storage.GetObjectStream(server.StorageBackend, pathutil.Join(repo, filename))
+	if err != nil {
+		return "", false
+	}
+	reader.Close()
+	return signedURL, true
+}
+
+// storageCircuitHandler wraps a handler, answering 503 rather than 500 while the storage
+// circuit breaker is open
+func (server *MultiTenantServer) storageCircuitHandler(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer = &circuitWriter{ResponseWriter: c.Writer, backend: server.StorageBackend}
+		handler(c)
+	}
+}
+
+func (w *circuitWriter) WriteHeader(code int) {
+	if code == http.StatusInternalServerError || code == http.StatusServiceUnavailable {
+		if retryAfter := cm_pkg_storage.CircuitRetryAfter(w.backend); retryAfter > 0 {
+			seconds := int64((retryAfter + time.Second - 1) / time.Second) // rounded up
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+			code = http.StatusServiceUnavailable
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
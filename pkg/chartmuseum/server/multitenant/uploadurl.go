@@ -0,0 +1,130 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+const (
+	// defaultUploadURLMaxSize is the max size of the charts uploaded by URL, Helm's limit
+	defaultUploadURLMaxSize = 20 << 20
+	uploadURLTimeout        = time.Minute
+)
+
+type (
+	// uploadURLRequest is the JSON body of POST /api/:repo/charts uploading a chart by URL
+	uploadURLRequest struct {
+		URL    string `json:"url"`
+		SHA256 string `json:"sha256"`
+	}
+)
+
+// newUploadURLClient returns the client fetching the charts uploaded by URL, following redirects to
+// allowed hosts only
+func (server *MultiTenantServer) newUploadURLClient() *http.Client {
+	return &http.Client{
+		Timeout: uploadURLTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if !server.uploadURLHostAllowed(req.URL.Host) {
+				return fmt.Errorf("redirect to host not allowed: %s", req.URL.Host)
+			}
+			return nil
+		},
+	}
+}
+
+// uploadURLHostAllowed checks a host is one charts can be uploaded from by URL
+func (server *MultiTenantServer) uploadURLHostAllowed(host string) bool {
+	for _, allowedHost := range server.UploadURLAllowedHosts {
+		if allowedHost == host {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchChartPackage fetches the chart package uploaded by URL, checking its sha256 digest
+func (server *MultiTenantServer) fetchChartPackage(log cm_logger.LoggingFn, req uploadURLRequest) ([]byte, *HTTPError) {
+	if len(server.UploadURLAllowedHosts) == 0 {
+		return nil, &HTTPError{http.StatusForbidden, "uploads by URL are disabled"}
+	}
+	digest, err := parseSHA256Digest(req.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	u, parseErr := url.Parse(req.URL)
+	if parseErr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, &HTTPError{http.StatusBadRequest, "invalid url, must be an absolute http(s) URL"}
+	}
+	if !server.uploadURLHostAllowed(u.Host) {
+		return nil, &HTTPError{http.StatusForbidden, fmt.Sprintf("uploads from host %s are not allowed", u.Host)}
+	}
+
+	resp, getErr := server.uploadURLClient.Get(u.String())
+	if getErr != nil {
+		log(cm_logger.WarnLevel, "failed to fetch chart uploaded by URL",
+			"url", u.Redacted(),
+			"error", getErr.Error(),
+		)
+		return nil, &HTTPError{http.StatusBadGateway, "failed to fetch " + u.Redacted()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{http.StatusBadGateway, fmt.Sprintf("unexpected status from %s: %d", u.Redacted(), resp.StatusCode)}
+	}
+	content, readErr := io.ReadAll(io.LimitReader(resp.Body, int64(server.UploadURLMaxSize)+1))
+	if readErr != nil {
+		return nil, &HTTPError{http.StatusBadGateway, fmt.Sprintf("failed to fetch %s: %s", u.Redacted(), readErr)}
+	}
+	if len(content) > server.UploadURLMaxSize {
+		return nil, &HTTPError{http.StatusRequestEntityTooLarge, fmt.Sprintf("chart exceeds max size of %d bytes", server.UploadURLMaxSize)}
+	}
+	sum := sha256.Sum256(content)
+	if actual := hex.EncodeToString(sum[:]); actual != digest {
+		return nil, &HTTPError{http.StatusBadRequest, fmt.Sprintf("digest mismatch, fetched sha256:%s", actual)}
+	}
+	return content, nil
+}
+
+func (server *MultiTenantServer) postPackageURLRequestHandler(c *gin.Context) {
+	req := uploadURLRequest{}
+	if bindErr := c.ShouldBindJSON(&req); bindErr != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("invalid upload request: %s", bindErr)})
+		return
+	}
+	content, err := server.fetchChartPackage(server.Logger.ContextLoggingFn(c), req)
+	if err != nil {
+		c.JSON(err.Status, gin.H{"error": err.Message})
+		return
+	}
+	server.savePackage(c, content)
+}
@@ -0,0 +1,357 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	pathutil "path"
+	"strings"
+	"sync"
+	"time"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+)
+
+const (
+	// upstreamIndexTTL is how long an upstream index.yaml is reused before being fetched again
+	upstreamIndexTTL = time.Minute
+	upstreamTimeout  = 30 * time.Second
+)
+
+type (
+	// upstreamIndex is the upstream index.yaml of a repo, locked while it is fetched
+	upstreamIndex struct {
+		sync.Mutex
+		indexFile *helm_repo.IndexFile
+		fetched   time.Time
+	}
+
+	upstreamProxy struct {
+		// the lock only guards indexes, each index being fetched under its own lock
+		sync.Mutex
+		client  *http.Client
+		indexes map[string]*upstreamIndex
+	}
+)
+
+// validateUpstreamURLs checks every configured upstream repo is an absolute http(s) URL
+func validateUpstreamURLs(upstreamURLs map[string]string) error {
+	for repo, upstreamURL := range upstreamURLs {
+		u, err := url.Parse(upstreamURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid upstream repo url for repo %q: %s", repo, upstreamURL)
+		}
+	}
+	return nil
+}
+
+func (server *MultiTenantServer) newUpstreamProxy() *upstreamProxy {
+	return &upstreamProxy{
+		client: &http.Client{
+			Timeout: upstreamTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 10 {
+					return errors.New("stopped after 10 redirects")
+				}
+				if !server.upstreamHostAllowed(req.URL.Host) {
+					return fmt.Errorf("redirect to host not allowed: %s", req.URL.Host)
+				}
+				return nil
+			},
+		},
+		indexes: map[string]*upstreamIndex{},
+	}
+}
+
+// upstreamHostAllowed checks a host is either one of the upstream repos or explicitly allowed
+func (server *MultiTenantServer) upstreamHostAllowed(host string) bool {
+	for _, upstreamURL := range server.UpstreamURLs {
+		if u, err := url.Parse(upstreamURL); err == nil && u.Host == host {
+			return true
+		}
+	}
+	for _, allowedHost := range server.UpstreamAllowedHosts {
+		if allowedHost == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (server *MultiTenantServer) fetchUpstream(u *url.URL) ([]byte, error) {
+	if !server.upstreamHostAllowed(u.Host) {
+		return nil, fmt.Errorf("upstream host not allowed: %s", u.Host)
+	}
+	resp, err := server.upstream.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from upstream %s: %d", u.Redacted(), resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, int64(server.UpstreamMaxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > server.UpstreamMaxSize {
+		return nil, fmt.Errorf("upstream object %s exceeds max size of %d bytes", u.Redacted(), server.UpstreamMaxSize)
+	}
+	return content, nil
+}
+
+// flush drops the upstream index of repo, returning 1 if it was cached
+func (proxy *upstreamProxy) flush(repo string) int {
+	proxy.Lock()
+	cached, ok := proxy.indexes[repo]
+	delete(proxy.indexes, repo)
+	proxy.Unlock()
+	if !ok {
+		return 0
+	}
+	cached.Lock()
+	defer cached.Unlock()
+	if cached.indexFile == nil {
+		return 0
+	}
+	return 1
+}
+
+// resolveUpstreamURL resolves a (possibly relative) reference against the upstream repo url
+func resolveUpstreamURL(upstreamURL string, ref string) (*url.URL, error) {
+	base, err := url.Parse(strings.TrimSuffix(upstreamURL, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	return base.ResolveReference(u), nil
+}
+
+func (server *MultiTenantServer) getUpstreamIndex(log cm_logger.LoggingFn, repo string) (*helm_repo.IndexFile, error) {
+	server.upstream.Lock()
+	cached, ok := server.upstream.indexes[repo]
+	if !ok {
+		cached = &upstreamIndex{}
+		server.upstream.indexes[repo] = cached
+	}
+	server.upstream.Unlock()
+
+	// concurrent requests for the index of a repo wait for a single fetch, without holding up the
+	// other repos
+	cached.Lock()
+	defer cached.Unlock()
+	if cached.indexFile != nil && time.Since(cached.fetched) < upstreamIndexTTL {
+		return cached.indexFile, nil
+	}
+
+	u, err := resolveUpstreamURL(server.UpstreamURLs[repo], "index.yaml")
+	if err != nil {
+		return nil, err
+	}
+	content, err := server.fetchUpstream(u)
+	if err != nil {
+		return nil, err
+	}
+	indexFile := &helm_repo.IndexFile{}
+	if err := yaml.Unmarshal(content, indexFile); err != nil {
+		return nil, err
+	}
+
+	log(cm_logger.DebugLevel, "Upstream index fetched",
+		"repo", repo,
+		"upstream", u.Redacted(),
+	)
+	cached.indexFile, cached.fetched = indexFile, time.Now()
+	return indexFile, nil
+}
+
+// getUpstreamStorageObject fetches a chart package or provenance file missing from storage from the
+// upstream repo, then stores it so that subsequent requests are served locally
+func (server *MultiTenantServer) getUpstreamStorageObject(c *gin.Context, repo string, filename string) (*StorageObject, *HTTPError) {
+	log := server.Logger.ContextLoggingFn(c)
+
+	indexFile, err := server.getUpstreamIndex(log, repo)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "failed to fetch upstream index",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return nil, &HTTPError{http.StatusBadGateway, "failed to fetch upstream index"}
+	}
+
+	isProvenanceFile := strings.HasSuffix(filename, cm_repo.ProvenanceFileExtension)
+	chartFilename := strings.TrimSuffix(filename, ".prov")
+	var chartVersion *helm_repo.ChartVersion
+	for _, chartVersions := range indexFile.Entries {
+		for _, cv := range chartVersions {
+			if cv.Metadata != nil && len(cv.URLs) > 0 &&
+				cm_repo.ChartPackageFilenameFromNameVersion(cv.Name, cv.Version) == chartFilename {
+				chartVersion = cv
+				break
+			}
+		}
+	}
+	if chartVersion == nil {
+		return nil, &HTTPError{http.StatusNotFound, "object not found"}
+	}
+
+	ref := chartVersion.URLs[0]
+	if isProvenanceFile {
+		ref += ".prov"
+	}
+	u, err := resolveUpstreamURL(server.UpstreamURLs[repo], ref)
+	if err != nil {
+		return nil, &HTTPError{http.StatusBadGateway, "invalid upstream chart url"}
+	}
+	content, err := server.fetchUpstream(u)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "failed to fetch upstream object",
+			"repo", repo,
+			"filename", filename,
+			"error", err.Error(),
+		)
+		return nil, &HTTPError{http.StatusBadGateway, "failed to fetch upstream object"}
+	}
+
+	contentType := provenanceFileContentType
+	if !isProvenanceFile {
+		contentType = chartPackageContentType
+		if f, err := cm_repo.ChartPackageFilenameFromContent(content); err != nil || f != filename {
+			log(cm_logger.ErrorLevel, "upstream chart package does not match requested file",
+				"repo", repo,
+				"filename", filename,
+			)
+			return nil, &HTTPError{http.StatusBadGateway, "upstream chart package does not match requested file"}
+		}
+		// stored packages are served for good, a tampered or truncated download must not be
+		if digest := fmt.Sprintf("%x", sha256.Sum256(content)); chartVersion.Digest == "" || !strings.EqualFold(digest, chartVersion.Digest) {
+			log(cm_logger.ErrorLevel, "upstream chart package does not match the digest of the upstream index",
+				"repo", repo,
+				"filename", filename,
+				"digest", digest,
+				"index_digest", chartVersion.Digest,
+			)
+			return nil, &HTTPError{http.StatusBadGateway, "upstream chart package does not match the digest of the upstream index"}
+		}
+	}
+
+	objectPath := pathutil.Join(repo, filename)
+	if err := server.StorageBackend.PutObject(objectPath, content); err != nil {
+		log(cm_logger.ErrorLevel, "failed to store upstream object",
+			"repo", repo,
+			"filename", filename,
+			"error", err.Error(),
+		)
+		return nil, &HTTPError{http.StatusInternalServerError, "failed to store upstream object"}
+	}
+	server.recordPackageSize(repo, filename, len(content))
+	log(cm_logger.InfoLevel, "Upstream object stored",
+		"repo", repo,
+		"filename", filename,
+	)
+
+	object := cm_storage.Object{
+		Path:         objectPath,
+		Content:      content,
+		LastModified: time.Now(),
+	}
+	if !isProvenanceFile {
+		chart, err := cm_repo.ChartVersionFromStorageObject(object)
+		if err == nil {
+			server.emitEvent(c, repo, addChart, chart)
+		}
+	}
+	return &StorageObject{Object: &object, ContentType: contentType}, nil
+}
+
+// mergeUpstreamIndex returns the index of a repo with the upstream chart versions missing locally
+// added, pointing at this server so that they are fetched through it, or the local index if the
+// upstream one cannot be fetched
+func (server *MultiTenantServer) mergeUpstreamIndex(log cm_logger.LoggingFn, repo string, index *cm_repo.Index) *cm_repo.IndexFile {
+	upstreamIndexFile, err := server.getUpstreamIndex(log, repo)
+	if err != nil {
+		log(cm_logger.WarnLevel, "failed to fetch upstream index, serving local index",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return index.IndexFile
+	}
+
+	merged := &cm_repo.IndexFile{
+		IndexFile: &helm_repo.IndexFile{
+			APIVersion: index.APIVersion,
+			Generated:  index.Generated,
+			Entries:    map[string]helm_repo.ChartVersions{},
+		},
+		ServerInfo: index.ServerInfo,
+	}
+	for name, chartVersions := range index.Entries {
+		merged.Entries[name] = append(helm_repo.ChartVersions{}, chartVersions...)
+	}
+	for name, chartVersions := range upstreamIndexFile.Entries {
+		for _, cv := range chartVersions {
+			if cv.Metadata == nil || index.HasEntry(cv) {
+				continue
+			}
+			chartURL := pathutil.Join("charts", cm_repo.ChartPackageFilenameFromNameVersion(cv.Name, cv.Version))
+			if index.ChartURL != "" {
+				chartURL = index.ChartURL + "/" + chartURL
+			}
+			proxied := *cv
+			proxied.URLs = []string{chartURL}
+			merged.Entries[name] = append(merged.Entries[name], &proxied)
+		}
+	}
+	merged.SortEntries()
+	return merged
+}
+
+// marshalMergedIndex serializes the index of a repo merged with its upstream one, or returns the
+// raw local index if it fails
+func (server *MultiTenantServer) marshalMergedIndex(log cm_logger.LoggingFn, repo string, index *cm_repo.Index, merged *cm_repo.IndexFile) []byte {
+	var raw []byte
+	var err error
+	if index.OutputJSON {
+		raw, err = json.Marshal(merged)
+	} else {
+		raw, err = yaml.Marshal(merged)
+	}
+	if err != nil {
+		log(cm_logger.WarnLevel, "failed to merge upstream index, serving local index",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return index.Raw
+	}
+	return raw
+}
@@ -72,6 +72,8 @@ func (conf *Config) UpdateFromCLIContext(c *cli.Context) error {
 					conf.Set(key, c.String(name))
 				case intType:
 					conf.Set(key, c.Int(name))
+				case floatType:
+					conf.Set(key, c.Float64(name))
 				case boolType:
 					conf.Set(key, c.Bool(name))
 				case durationType:
@@ -46,6 +46,10 @@ func (suite *ConfigTestSuite) SetupSuite() {
 basicauth:
     user: "myuser"
     pass: "mypass"
+ldap:
+    url: "ldaps://ldap.example.com"
+    group:
+        developers: "org1/*:pull,push"
 `,
 	)
 
@@ -117,6 +121,9 @@ func (suite *ConfigTestSuite) TestUpdateFromCLIContext() {
 	suite.Nil(err)
 	suite.Equal("myuser", conf.GetString("basicauth.user"))
 	suite.Equal("mypass", conf.GetString("basicauth.pass"))
+	suite.Equal("ldaps://ldap.example.com", conf.GetString("ldap.url"))
+	suite.Equal("(uid=%s)", conf.GetString("ldap.userfilter"))
+	suite.Equal(map[string]string{"developers": "org1/*:pull,push"}, conf.GetStringMapString("ldap.group"))
 
 	// valid config file and populated context, context vars should override config file
 	conf = NewConfig()
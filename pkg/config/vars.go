@@ -41,6 +41,7 @@ var CLIFlags []cli.Flag
 var (
 	stringType   configVarType = "string"
 	intType      configVarType = "int"
+	floatType    configVarType = "float64"
 	boolType     configVarType = "bool"
 	durationType configVarType = "time.Duration"
 	keyValueType configVarType = "keyValue"
@@ -147,6 +148,24 @@ var configVars = map[string]configVar{
 			EnvVar: "JSON_INDEX",
 		},
 	},
+	"index-content-type": {
+		Type:    stringType,
+		Default: "application/x-yaml",
+		CLIFlag: cli.StringFlag{
+			Name:   "index-content-type",
+			Usage:  "content type of the index served in YAML format (e.g. \"application/x-yaml; charset=utf-8\" or \"text/yaml\")",
+			EnvVar: "INDEX_CONTENT_TYPE",
+		},
+	},
+	"json-index-content-type": {
+		Type:    stringType,
+		Default: "application/json",
+		CLIFlag: cli.StringFlag{
+			Name:   "json-index-content-type",
+			Usage:  "content type of the index served in JSON format (see --json-index) and of index.json",
+			EnvVar: "JSON_INDEX_CONTENT_TYPE",
+		},
+	},
 	"allowoverwrite": {
 		Type:    boolType,
 		Default: false,
@@ -219,6 +238,15 @@ var configVars = map[string]configVar{
 			EnvVar: "BASIC_AUTH_PASS",
 		},
 	},
+	"basicauth.htpasswd": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "basic-auth-htpasswd",
+			Usage:  "htpasswd file of the users for basic http authentication (bcrypt, MD5 or SHA-1 hashes)",
+			EnvVar: "BASIC_AUTH_HTPASSWD",
+		},
+	},
 	"authanonymousget": {
 		Type:    boolType,
 		Default: false,
@@ -255,6 +283,252 @@ var configVars = map[string]configVar{
 			EnvVar: "TLS_CA_CERT",
 		},
 	},
+	"tls.clientnames": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "tls-client-names",
+			Usage:  "comma separated patterns of the CN or a SAN of the client certificates allowed with --tls-ca-cert (i.e. ci.example.com,*@example.com)",
+			EnvVar: "TLS_CLIENT_NAMES",
+		},
+	},
+	"tls.crl": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "tls-crl",
+			Usage:  "path to the CRLs of --tls-ca-cert, PEM or DER encoded, rejecting revoked client certificates",
+			EnvVar: "TLS_CRL",
+		},
+	},
+	"tls.ocsp": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "tls-ocsp",
+			Usage:  "check the revocation of client certificates with the OCSP responders of their issuers",
+			EnvVar: "TLS_OCSP",
+		},
+	},
+	"tls.vaultpath": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "tls-vault-path",
+			Usage:  "Vault path of the TLS certificate of the server, renewed before it expires, i.e. pki/issue/chartmuseum or secret/data/chartmuseum/tls",
+			EnvVar: "TLS_VAULT_PATH",
+		},
+	},
+	"tls.vaultcommonname": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "tls-vault-common-name",
+			Usage:  "common name of the certificate issued by the PKI secrets engine at --tls-vault-path",
+			EnvVar: "TLS_VAULT_COMMON_NAME",
+		},
+	},
+	"vault.address": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "vault-address",
+			Usage:  "address of the Vault holding storage credentials or the TLS certificate (i.e. https://vault.example.com:8200)",
+			EnvVar: "VAULT_ADDRESS",
+		},
+	},
+	"vault.namespace": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "vault-namespace",
+			Usage:  "Vault Enterprise namespace",
+			EnvVar: "VAULT_NAMESPACE",
+		},
+	},
+	"vault.cacert": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "vault-ca-cert",
+			Usage:  "path to the CA certificate verifying Vault",
+			EnvVar: "VAULT_CA_CERT",
+		},
+	},
+	"vault.auth": {
+		Type:    stringType,
+		Default: "token",
+		CLIFlag: cli.StringFlag{
+			Name:   "vault-auth",
+			Usage:  "auth method of Vault: token, approle or kubernetes",
+			EnvVar: "VAULT_AUTH",
+		},
+	},
+	"vault.authmount": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "vault-auth-mount",
+			Usage:  "path the Vault auth method is mounted at, the name of the method by default",
+			EnvVar: "VAULT_AUTH_MOUNT",
+		},
+	},
+	"vault.token": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "vault-token",
+			Usage:  "Vault token of the token auth method",
+			EnvVar: "VAULT_TOKEN",
+		},
+	},
+	"vault.roleid": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "vault-role-id",
+			Usage:  "role id of the approle auth method",
+			EnvVar: "VAULT_ROLE_ID",
+		},
+	},
+	"vault.secretid": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "vault-secret-id",
+			Usage:  "secret id of the approle auth method",
+			EnvVar: "VAULT_SECRET_ID",
+		},
+	},
+	"vault.role": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "vault-role",
+			Usage:  "Vault role of the kubernetes auth method",
+			EnvVar: "VAULT_ROLE",
+		},
+	},
+	"vault.kubernetestokenfile": {
+		Type:    stringType,
+		Default: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		CLIFlag: cli.StringFlag{
+			Name:   "vault-kubernetes-token-file",
+			Usage:  "service account token of the kubernetes auth method",
+			EnvVar: "VAULT_KUBERNETES_TOKEN_FILE",
+		},
+	},
+	"vault.refreshinterval": {
+		Type:    durationType,
+		Default: 5 * time.Minute,
+		CLIFlag: cli.DurationFlag{
+			Name:   "vault-refresh-interval",
+			Usage:  "how often the Vault secrets without lease, such as KV secrets, are read again",
+			EnvVar: "VAULT_REFRESH_INTERVAL",
+			Value:  5 * time.Minute,
+		},
+	},
+	"ipfilter.allow": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "ip-allow",
+			Usage:  "comma separated CIDRs or addresses of the clients allowed to make any request, all by default (i.e. 10.0.0.0/8,192.168.1.10)",
+			EnvVar: "IP_ALLOW",
+		},
+	},
+	"ipfilter.deny": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "ip-deny",
+			Usage:  "comma separated CIDRs or addresses of the clients denied any request",
+			EnvVar: "IP_DENY",
+		},
+	},
+	"ipfilter.writeallow": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "ip-write-allow",
+			Usage:  "comma separated CIDRs or addresses of the clients allowed to push, delete and administer charts, such as CI runners",
+			EnvVar: "IP_WRITE_ALLOW",
+		},
+	},
+	"ipfilter.writedeny": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "ip-write-deny",
+			Usage:  "comma separated CIDRs or addresses of the clients denied to push, delete and administer charts",
+			EnvVar: "IP_WRITE_DENY",
+		},
+	},
+	"ipfilter.trustedproxies": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "ip-trusted-proxies",
+			Usage:  "comma separated CIDRs or addresses of the proxies whose X-Forwarded-For header gives the address of the client",
+			EnvVar: "IP_TRUSTED_PROXIES",
+		},
+	},
+	"auditlog": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name: "audit-log",
+			Usage: "comma separated destinations of the audit records of the requests changing repos: a file, stdout, syslog, " +
+				"syslog://host:port, syslog+tcp://host:port or an http(s) url",
+			EnvVar: "AUDIT_LOG",
+		},
+	},
+	"ratelimit.read": {
+		Type:    floatType,
+		Default: 0,
+		CLIFlag: cli.Float64Flag{
+			Name:   "rate-limit-read",
+			Usage:  "requests per second each client can make to read charts, unlimited by default",
+			EnvVar: "RATE_LIMIT_READ",
+		},
+	},
+	"ratelimit.readburst": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "rate-limit-read-burst",
+			Usage:  "requests each client can make at once to read charts, --rate-limit-read rounded up by default",
+			EnvVar: "RATE_LIMIT_READ_BURST",
+		},
+	},
+	"ratelimit.write": {
+		Type:    floatType,
+		Default: 0,
+		CLIFlag: cli.Float64Flag{
+			Name:   "rate-limit-write",
+			Usage:  "requests per second each client can make to push, delete and administer charts, unlimited by default",
+			EnvVar: "RATE_LIMIT_WRITE",
+		},
+	},
+	"ratelimit.writeburst": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "rate-limit-write-burst",
+			Usage:  "requests each client can make at once to push, delete and administer charts, --rate-limit-write rounded up by default",
+			EnvVar: "RATE_LIMIT_WRITE_BURST",
+		},
+	},
+	"ratelimit.by": {
+		Type:    stringType,
+		Default: "ip",
+		CLIFlag: cli.StringFlag{
+			Name:   "rate-limit-by",
+			Usage:  "what rate limits are kept by: ip, the client address, or token, the authenticated user of the request",
+			Value:  "ip",
+			EnvVar: "RATE_LIMIT_BY",
+		},
+	},
 	"cache.store": {
 		Type:    stringType,
 		Default: "",
@@ -301,6 +575,15 @@ var configVars = map[string]configVar{
 			EnvVar: "STORAGE",
 		},
 	},
+	"storage.url": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-url",
+			Usage:  "URL of a storage backend registered for its scheme (e.g. file:///var/lib/charts), takes precedence over --storage",
+			EnvVar: "STORAGE_URL",
+		},
+	},
 	"storage.timestamptolerance": {
 		Type:    durationType,
 		Default: time.Duration(0),
@@ -319,6 +602,167 @@ var configVars = map[string]configVar{
 			EnvVar: "STORAGE_LOCAL_ROOTDIR",
 		},
 	},
+	"storage.local.fsync": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "storage-local-fsync",
+			Usage:  "flush charts to disk before acknowledging uploads and deletes for local storage backend",
+			EnvVar: "STORAGE_LOCAL_FSYNC",
+		},
+	},
+	"storage.layout": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-layout",
+			Usage:  "template of the keys of chart files in storage, with {repo}, {name}, {first} and {file} (i.e charts/{repo}/{first}/{name}/{file})",
+			EnvVar: "STORAGE_LAYOUT",
+		},
+	},
+	"storage.route": {
+		Type: keyValueType,
+		CLIFlag: cli.GenericFlag{
+			Name:  "storage-route",
+			Value: &KeyValueFlag{},
+			Usage: "store the repos under a prefix in their own storage backend, as a key value pair of the prefix and a URL " +
+				"in the format of --storage-url (i.e team-a=s3://team-a-charts?region=us-east-1), can be repeated",
+			EnvVar: "STORAGE_ROUTE",
+		},
+	},
+	"storage.replica.url": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-replica-url",
+			Usage:  "URL of a secondary storage backend receiving copies of all writes, in the format of --storage-url",
+			EnvVar: "STORAGE_REPLICA_URL",
+		},
+	},
+	"storage.replica.queuesize": {
+		Type:    intType,
+		Default: 1000,
+		CLIFlag: cli.IntFlag{
+			Name:   "storage-replica-queue-size",
+			Usage:  "number of writes waiting to be copied to --storage-replica-url, further writes are left to the reconciliation",
+			EnvVar: "STORAGE_REPLICA_QUEUE_SIZE",
+			Value:  1000,
+		},
+	},
+	"storage.replica.reconcileinterval": {
+		Type:    durationType,
+		Default: time.Hour,
+		CLIFlag: cli.DurationFlag{
+			Name:   "storage-replica-reconcile-interval",
+			Usage:  "how often --storage-replica-url is compared to the storage backend and repaired (0 to disable)",
+			EnvVar: "STORAGE_REPLICA_RECONCILE_INTERVAL",
+			Value:  time.Hour,
+		},
+	},
+	"storage.retry.attempts": {
+		Type:    intType,
+		Default: 1,
+		CLIFlag: cli.IntFlag{
+			Name:   "storage-retry-attempts",
+			Usage:  "number of times a failed storage operation is tried, e.g. on throttling (1 to disable retries)",
+			EnvVar: "STORAGE_RETRY_ATTEMPTS",
+			Value:  1,
+		},
+	},
+	"storage.retry.backoff": {
+		Type:    durationType,
+		Default: 100 * time.Millisecond,
+		CLIFlag: cli.DurationFlag{
+			Name:   "storage-retry-backoff",
+			Usage:  "wait after the first failure of a storage operation, doubled after each failure",
+			EnvVar: "STORAGE_RETRY_BACKOFF",
+			Value:  100 * time.Millisecond,
+		},
+	},
+	"storage.retry.maxbackoff": {
+		Type:    durationType,
+		Default: 5 * time.Second,
+		CLIFlag: cli.DurationFlag{
+			Name:   "storage-retry-max-backoff",
+			Usage:  "longest wait between two attempts of a storage operation",
+			EnvVar: "STORAGE_RETRY_MAX_BACKOFF",
+			Value:  5 * time.Second,
+		},
+	},
+	"storage.retry.timeout": {
+		Type:    durationType,
+		Default: time.Duration(0),
+		CLIFlag: cli.DurationFlag{
+			Name:   "storage-retry-timeout",
+			Usage:  "time after which an attempt of a storage operation is failed (0 to disable)",
+			EnvVar: "STORAGE_RETRY_TIMEOUT",
+		},
+	},
+	"storage.circuitbreaker.threshold": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "storage-circuit-breaker-threshold",
+			Usage:  "number of consecutive failed storage operations after which storage operations fail fast with a 503 (0 to disable)",
+			EnvVar: "STORAGE_CIRCUIT_BREAKER_THRESHOLD",
+		},
+	},
+	"storage.circuitbreaker.cooldown": {
+		Type:    durationType,
+		Default: 30 * time.Second,
+		CLIFlag: cli.DurationFlag{
+			Name:   "storage-circuit-breaker-cooldown",
+			Usage:  "how long storage operations fail fast once --storage-circuit-breaker-threshold is reached, before the storage backend is tried again",
+			Value:  30 * time.Second,
+			EnvVar: "STORAGE_CIRCUIT_BREAKER_COOLDOWN",
+		},
+	},
+	"storage.tiered.cachedir": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-tiered-cache-dir",
+			Usage:  "directory on local disk caching the most recently read charts of the storage backend",
+			EnvVar: "STORAGE_TIERED_CACHE_DIR",
+		},
+	},
+	"storage.tiered.cachemaxsize": {
+		Type:    intType,
+		Default: 1073741824,
+		CLIFlag: cli.IntFlag{
+			Name:   "storage-tiered-cache-max-size",
+			Usage:  "size of --storage-tiered-cache-dir, in bytes, after which the least recently read charts are evicted",
+			EnvVar: "STORAGE_TIERED_CACHE_MAX_SIZE",
+			Value:  1073741824,
+		},
+	},
+	"storage.encryption.keyfile": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-encryption-key-file",
+			Usage:  "file holding a base64 encoded 32 bytes key, encrypting charts before they are written to the storage backend",
+			EnvVar: "STORAGE_ENCRYPTION_KEY_FILE",
+		},
+	},
+	"storage.encryption.kmskeyid": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-encryption-kms-key-id",
+			Usage:  "AWS KMS key (id, ARN or alias) generating the keys encrypting charts before they are written to the storage backend",
+			EnvVar: "STORAGE_ENCRYPTION_KMS_KEY_ID",
+		},
+	},
+	"storage.encryption.kmsregion": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-encryption-kms-region",
+			Usage:  "region of --storage-encryption-kms-key-id, defaults to the region of the AWS config",
+			EnvVar: "STORAGE_ENCRYPTION_KMS_REGION",
+		},
+	},
 	"storage.amazon.bucket": {
 		Type:    stringType,
 		Default: "",
@@ -364,6 +808,15 @@ var configVars = map[string]configVar{
 			EnvVar: "STORAGE_AMAZON_SSE",
 		},
 	},
+	"storage.amazon.ssekmskeyid": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-amazon-sse-kms-key-id",
+			Usage:  "KMS key id, key ARN or alias ARN to encrypt charts with aws:kms server side encryption",
+			EnvVar: "STORAGE_AMAZON_SSE_KMS_KEY_ID",
+		},
+	},
 	"storage.amazon.forcepathstyle": {
 		Type:    boolType,
 		Default: true,
@@ -373,449 +826,1564 @@ var configVars = map[string]configVar{
 			EnvVar: "STORAGE_AMAZON_FORCE_PATH_STYLE",
 		},
 	},
-	"storage.google.bucket": {
+	"storage.amazon.vaultpath": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-google-bucket",
-			Usage:  "gcs bucket to store charts for google storage backend",
-			EnvVar: "STORAGE_GOOGLE_BUCKET",
+			Name:   "storage-amazon-vault-path",
+			Usage:  "Vault path of the AWS credentials, with the fields access_key, secret_key and security_token of the AWS secrets engine (i.e. aws/creds/chartmuseum)",
+			EnvVar: "STORAGE_AMAZON_VAULT_PATH",
 		},
 	},
-	"storage.google.prefix": {
+	"storage.digitalocean.bucket": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-google-prefix",
-			Usage:  "prefix to store charts for --storage-google-bucket",
-			EnvVar: "STORAGE_GOOGLE_PREFIX",
+			Name:   "storage-digitalocean-bucket",
+			Usage:  "space to store charts for digitalocean storage backend",
+			EnvVar: "STORAGE_DIGITALOCEAN_BUCKET",
 		},
 	},
-	"storage.oracle.bucket": {
+	"storage.digitalocean.prefix": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-oracle-bucket",
-			Usage:  "ocs bucket to store charts for oracle cloud storage",
-			EnvVar: "STORAGE_ORACLE_BUCKET",
+			Name:   "storage-digitalocean-prefix",
+			Usage:  "prefix to store charts for digitalocean storage backend",
+			EnvVar: "STORAGE_DIGITALOCEAN_PREFIX",
 		},
 	},
-	"storage.oracle.prefix": {
+	"storage.digitalocean.region": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-oracle-prefix",
-			Usage:  "prefix to store charts for --storage-oracle-bucket",
-			EnvVar: "STORAGE_ORACLE_PREFIX",
+			Name:   "storage-digitalocean-region",
+			Usage:  "region of the space for digitalocean storage backend (e.g. fra1, nyc3)",
+			EnvVar: "STORAGE_DIGITALOCEAN_REGION",
 		},
 	},
-	"storage.oracle.region": {
+	"storage.minio.endpoint": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-oracle-region",
-			Usage:  "region to store charts for --storage-oracle-bucket",
-			EnvVar: "STORAGE_ORACLE_REGION",
+			Name:   "storage-minio-endpoint",
+			Usage:  "URL of the MinIO server for minio storage backend (e.g. https://minio.example.com:9000)",
+			EnvVar: "STORAGE_MINIO_ENDPOINT",
 		},
 	},
-	"storage.oracle.compartmentid": {
+	"storage.minio.bucket": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-oracle-compartmentid",
-			Usage:  "compartment ocid of --storage-oracle-bucket",
-			EnvVar: "STORAGE_ORACLE_COMPARTMENTID",
+			Name:   "storage-minio-bucket",
+			Usage:  "bucket to store charts for minio storage backend",
+			EnvVar: "STORAGE_MINIO_BUCKET",
 		},
 	},
-	"storage.microsoft.container": {
+	"storage.minio.prefix": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-microsoft-container",
-			Usage:  "container to store charts for microsoft storage backend",
-			EnvVar: "STORAGE_MICROSOFT_CONTAINER",
+			Name:   "storage-minio-prefix",
+			Usage:  "prefix to store charts for minio storage backend",
+			EnvVar: "STORAGE_MINIO_PREFIX",
 		},
 	},
-	"storage.microsoft.prefix": {
+	"storage.minio.region": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-microsoft-prefix",
-			Usage:  "prefix to store charts for --storage-microsoft-prefix",
-			EnvVar: "STORAGE_MICROSOFT_PREFIX",
+			Name:   "storage-minio-region",
+			Usage:  "region signing the requests for minio storage backend, us-east-1 if empty",
+			EnvVar: "STORAGE_MINIO_REGION",
 		},
 	},
-	"storage.alibaba.bucket": {
+	"storage.minio.accesskeyid": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-alibaba-bucket",
-			Usage:  "OSS bucket to store charts for Alibaba Cloud storage backend",
-			EnvVar: "STORAGE_ALIBABA_BUCKET",
+			Name:   "storage-minio-access-key-id",
+			Usage:  "access key id for minio storage backend, AWS_ACCESS_KEY_ID if empty",
+			EnvVar: "STORAGE_MINIO_ACCESS_KEY_ID",
 		},
 	},
-	"storage.alibaba.prefix": {
+	"storage.minio.secretaccesskey": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-alibaba-prefix",
-			Usage:  "prefix to store charts for --storage-alibaba-cloud-bucket",
-			EnvVar: "STORAGE_ALIBABA_PREFIX",
+			Name:   "storage-minio-secret-access-key",
+			Usage:  "secret access key for minio storage backend, AWS_SECRET_ACCESS_KEY if empty",
+			EnvVar: "STORAGE_MINIO_SECRET_ACCESS_KEY",
 		},
 	},
-	"storage.alibaba.endpoint": {
+	"storage.minio.cacert": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-alibaba-endpoint",
-			Usage:  "OSS endpoint",
-			EnvVar: "STORAGE_ALIBABA_ENDPOINT",
+			Name:   "storage-minio-ca-cert",
+			Usage:  "PEM file of the certificate authorities trusted for the MinIO server, in addition to those of the system",
+			EnvVar: "STORAGE_MINIO_CA_CERT",
 		},
 	},
-	"storage.alibaba.sse": {
-		Type:    stringType,
-		Default: "",
-		CLIFlag: cli.StringFlag{
-			Name:   "storage-alibaba-sse",
-			Usage:  "server side encryption algorithm for Alibaba Cloud storage backend, AES256 or KMS",
-			EnvVar: "STORAGE_ALIBABA_SSE",
+	"storage.minio.insecureskipverify": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "storage-minio-insecure-skip-verify",
+			Usage:  "skip the verification of the certificate of the MinIO server",
+			EnvVar: "STORAGE_MINIO_INSECURE_SKIP_VERIFY",
 		},
 	},
-	"storage.openstack.container": {
+	"storage.b2.bucketid": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-openstack-container",
-			Usage:  "container to store charts for openstack storage backend",
-			EnvVar: "STORAGE_OPENSTACK_CONTAINER",
+			Name:   "storage-b2-bucket-id",
+			Usage:  "id of the bucket to store charts for b2 storage backend",
+			EnvVar: "STORAGE_B2_BUCKET_ID",
 		},
 	},
-	"storage.openstack.prefix": {
+	"storage.b2.prefix": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-openstack-prefix",
-			Usage:  "prefix to store charts for --storage-openstack-container",
-			EnvVar: "STORAGE_OPENSTACK_PREFIX",
+			Name:   "storage-b2-prefix",
+			Usage:  "prefix to store charts for b2 storage backend",
+			EnvVar: "STORAGE_B2_PREFIX",
 		},
 	},
-	"storage.openstack.region": {
+	"storage.b2.keyid": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-openstack-region",
-			Usage:  "region of --storage-openstack-container",
-			EnvVar: "STORAGE_OPENSTACK_REGION",
+			Name:   "storage-b2-key-id",
+			Usage:  "application key id for b2 storage backend",
+			EnvVar: "B2_APPLICATION_KEY_ID",
 		},
 	},
-	"storage.openstack.cacert": {
+	"storage.b2.applicationkey": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-openstack-cacert",
-			Usage:  "path to a CA cert bundle for your openstack endpoint",
-			EnvVar: "STORAGE_OPENSTACK_CACERT",
+			Name:   "storage-b2-application-key",
+			Usage:  "application key for b2 storage backend",
+			EnvVar: "B2_APPLICATION_KEY",
 		},
 	},
-	"storage.openstack.auth": {
+	"storage.sftp.host": {
 		Type:    stringType,
-		Default: "auto",
+		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-openstack-auth",
-			Usage:  "the OpenStack auth protocol to use. Set \"v1\" for v1 or \"auto\" for v2 and v3",
-			EnvVar: "STORAGE_OPENSTACK_AUTH",
+			Name:   "storage-sftp-host",
+			Usage:  "host of the SFTP server, with an optional port, for sftp storage backend",
+			EnvVar: "STORAGE_SFTP_HOST",
 		},
 	},
-	"storage.baidu.prefix": {
+	"storage.sftp.basepath": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-baidu-prefix",
-			Usage:  "prefix to store charts for --storage-baidu-cloud-bucket",
-			EnvVar: "STORAGE_BAIDU_PREFIX",
+			Name:   "storage-sftp-basepath",
+			Usage:  "directory to store charts on the SFTP server for sftp storage backend",
+			EnvVar: "STORAGE_SFTP_BASEPATH",
 		},
 	},
-	"storage.baidu.bucket": {
+	"storage.sftp.user": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-baidu-bucket",
-			Usage:  "BOS bucket to store charts for Baidu Cloud storage backend",
-			EnvVar: "STORAGE_BAIDU_BUCKET",
+			Name:   "storage-sftp-user",
+			Usage:  "user for sftp storage backend",
+			EnvVar: "STORAGE_SFTP_USER",
 		},
 	},
-	"storage.baidu.endpoint": {
+	"storage.sftp.password": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-baidu-endpoint",
-			Usage:  "BOS endpoint",
-			EnvVar: "STORAGE_BAIDU_ENDPOINT",
+			Name:   "storage-sftp-password",
+			Usage:  "password for sftp storage backend",
+			EnvVar: "STORAGE_SFTP_PASSWORD",
 		},
 	},
-	"storage.etcd.endpoint": {
+	"storage.sftp.privatekeyfile": {
 		Type:    stringType,
-		Default: "http://localhost:2379",
+		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-etcd-endpoint",
-			Usage:  "ETCD endpoint",
-			EnvVar: "STORAGE_ETCD_ENDPOINT",
+			Name:   "storage-sftp-private-key-file",
+			Usage:  "private key file for sftp storage backend",
+			EnvVar: "STORAGE_SFTP_PRIVATE_KEY_FILE",
 		},
 	},
-	"storage.etcd.cafile": {
+	"storage.sftp.knownhostsfile": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-etcd-cafile",
-			Usage:  "ca-file provided to etcd",
-			EnvVar: "STORAGE_ETCD_CAFILE",
+			Name:   "storage-sftp-known-hosts-file",
+			Usage:  "known hosts file to verify the SFTP server host key for sftp storage backend",
+			EnvVar: "STORAGE_SFTP_KNOWN_HOSTS_FILE",
 		},
 	},
-	"storage.etcd.certfile": {
+	"storage.sftp.insecureignorehostkey": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "storage-sftp-insecure-ignore-host-key",
+			Usage:  "do not verify the SFTP server host key for sftp storage backend",
+			EnvVar: "STORAGE_SFTP_INSECURE_IGNORE_HOST_KEY",
+		},
+	},
+	"storage.webdav.url": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-etcd-certfile",
-			Usage:  "cert provided to etcd",
-			EnvVar: "STORAGE_ETCD_CERTFILE",
+			Name:   "storage-webdav-url",
+			Usage:  "URL of the collection to store charts for webdav storage backend",
+			EnvVar: "STORAGE_WEBDAV_URL",
 		},
 	},
-	"storage.etcd.keyfile": {
+	"storage.webdav.username": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-etcd-keyfile",
-			Usage:  "key provided to etcd",
-			EnvVar: "STORAGE_ETCD_KEYFILE",
+			Name:   "storage-webdav-username",
+			Usage:  "username for webdav storage backend",
+			EnvVar: "STORAGE_WEBDAV_USERNAME",
 		},
 	},
-	"storage.etcd.prefix": {
+	"storage.webdav.password": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-etcd-prefix",
-			Usage:  "prefix used in etcd to store the charts in etcd",
-			EnvVar: "STORAGE_ETCD_PREFIX",
+			Name:   "storage-webdav-password",
+			Usage:  "password for webdav storage backend",
+			EnvVar: "STORAGE_WEBDAV_PASSWORD",
 		},
 	},
-	"storage.tencent.bucket": {
+	"storage.sql.dialect": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-tencent-bucket",
-			Usage:  "COS bucket to store charts for Tencent Cloud storage backend",
-			EnvVar: "STORAGE_TENCENT_BUCKET",
+			Name:   "storage-sql-dialect",
+			Usage:  "database of sql storage backend, can be one of: postgres, mysql",
+			EnvVar: "STORAGE_SQL_DIALECT",
 		},
 	},
-	"storage.tencent.prefix": {
+	"storage.sql.dsn": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-tencent-prefix",
-			Usage:  "prefix to store charts for --storage-tencent-cloud-bucket",
-			EnvVar: "STORAGE_TENCENT_PREFIX",
+			Name:   "storage-sql-dsn",
+			Usage:  "data source name of the database for sql storage backend, as accepted by its driver",
+			EnvVar: "STORAGE_SQL_DSN",
 		},
 	},
-	"storage.tencent.endpoint": {
+	"storage.sql.table": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "storage-tencent-endpoint",
-			Usage:  "COS endpoint",
-			EnvVar: "STORAGE_TENCENT_ENDPOINT",
+			Name:   "storage-sql-table",
+			Usage:  "table to store charts for sql storage backend (default chartmuseum_objects)",
+			EnvVar: "STORAGE_SQL_TABLE",
 		},
 	},
-	"chartpostformfieldname": {
+	"storage.ipfs.apiurl": {
 		Type:    stringType,
-		Default: "chart",
+		Default: "http://127.0.0.1:5001",
 		CLIFlag: cli.StringFlag{
-			Name:   "chart-post-form-field-name",
-			Usage:  "form field which will be queried for the chart file content",
-			EnvVar: "CHART_POST_FORM_FIELD_NAME",
+			Name:   "storage-ipfs-api-url",
+			Usage:  "URL of the RPC API of the node for ipfs storage backend",
+			EnvVar: "STORAGE_IPFS_API_URL",
 		},
 	},
-	"provpostformfieldname": {
+	"storage.ipfs.root": {
 		Type:    stringType,
-		Default: "prov",
+		Default: "/chartmuseum",
 		CLIFlag: cli.StringFlag{
-			Name:   "prov-post-form-field-name",
-			Usage:  "form field which will be queried for the provenance file content",
-			EnvVar: "PROV_POST_FORM_FIELD_NAME",
+			Name:   "storage-ipfs-root",
+			Usage:  "MFS directory to store charts for ipfs storage backend",
+			EnvVar: "STORAGE_IPFS_ROOT",
 		},
 	},
-	"maxstorageobjects": {
-		Type:    intType,
-		Default: 0,
-		CLIFlag: cli.IntFlag{
-			Name:   "max-storage-objects",
-			Usage:  "maximum number of objects allowed in storage (per tenant)",
-			EnvVar: "MAX_STORAGE_OBJECTS",
+	"storage.ipfs.gateway": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-ipfs-gateway",
+			Usage:  "HTTP gateway used in the alternate chart URLs of ipfs storage backend (default ipfs:// URLs)",
+			EnvVar: "STORAGE_IPFS_GATEWAY",
 		},
 	},
-	"maxuploadsize": {
-		Type:    intType,
-		Default: 1024 * 1024 * 20, // 20MB, per Helm's limit
-		CLIFlag: cli.IntFlag{
-			Name:   "max-upload-size",
-			Usage:  "max size of post body (in bytes)",
-			EnvVar: "MAX_UPLOAD_SIZE",
-			Value:  1024 * 1024 * 20,
+	"storage.rados.pool": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-rados-pool",
+			Usage:  "ceph pool to store charts for rados storage backend",
+			EnvVar: "STORAGE_RADOS_POOL",
 		},
 	},
-	"indexlimit": {
-		Type:    intType,
-		Default: 0,
-		CLIFlag: cli.IntFlag{
-			Name:   "index-limit",
-			Usage:  "parallel scan limit for the repo indexer",
-			EnvVar: "INDEX_LIMIT",
+	"storage.rados.namespace": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-rados-namespace",
+			Usage:  "namespace of --storage-rados-pool to store charts in",
+			EnvVar: "STORAGE_RADOS_NAMESPACE",
 		},
 	},
-	"contextpath": {
+	"storage.rados.cluster": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "context-path",
-			Usage:  "base context path",
-			EnvVar: "CONTEXT_PATH",
+			Name:   "storage-rados-cluster",
+			Usage:  "ceph cluster name for rados storage backend (default ceph)",
+			EnvVar: "STORAGE_RADOS_CLUSTER",
 		},
 	},
-	"depth": {
-		Type:    intType,
-		Default: 0,
-		CLIFlag: cli.IntFlag{
-			Name:   "depth",
-			Usage:  "levels of nested repos for multitenancy",
-			EnvVar: "DEPTH",
+	"storage.rados.user": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-rados-user",
+			Usage:  "ceph user for rados storage backend (default client.admin)",
+			EnvVar: "STORAGE_RADOS_USER",
 		},
 	},
-	"bearerauth": {
-		Type:    boolType,
-		Default: false,
-		CLIFlag: cli.BoolFlag{
-			Name:   "bearer-auth",
-			Usage:  "enable bearer auth",
-			EnvVar: "BEARER_AUTH",
+	"storage.rados.configfile": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-rados-config-file",
+			Usage:  "path of ceph.conf for rados storage backend (default search paths of librados)",
+			EnvVar: "STORAGE_RADOS_CONFIG_FILE",
 		},
 	},
-	"authrealm": {
+	"storage.hdfs.namenode": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "auth-realm",
-			Usage:  "authorization server url",
-			EnvVar: "AUTH_REALM",
+			Name:   "storage-hdfs-namenode-uri",
+			Usage:  "URI of the HDFS namenode and directory to store charts in (e.g. hdfs://namenode:8020/charts), namenodes of a HA cluster are separated by commas",
+			EnvVar: "STORAGE_HDFS_NAMENODE_URI",
 		},
 	},
-	"authservice": {
+	"storage.hdfs.user": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "auth-service",
-			Usage:  "authorization server service name",
-			EnvVar: "AUTH_SERVICE",
+			Name:   "storage-hdfs-user",
+			Usage:  "HDFS user without Kerberos, defaults to HADOOP_USER_NAME or the current user",
+			EnvVar: "STORAGE_HDFS_USER",
 		},
 	},
-	"authcertpath": {
+	"storage.hdfs.kerberosprincipal": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "auth-cert-path",
-			Usage:  "path to authorization server public pem file",
-			EnvVar: "AUTH_CERT_PATH",
+			Name:   "storage-hdfs-kerberos-principal",
+			Usage:  "Kerberos principal to authenticate to HDFS as (e.g. chartmuseum@EXAMPLE.COM), enables Kerberos",
+			EnvVar: "STORAGE_HDFS_KERBEROS_PRINCIPAL",
 		},
 	},
-	"authactionssearchpath": {
+	"storage.hdfs.kerberoskeytab": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "auth-actions-search-path",
-			Usage:  "JMESPath to find allowed actions in a jwt token",
-			EnvVar: "AUTH_ACTIONS_SEARCH_PATH",
+			Name:   "storage-hdfs-kerberos-keytab",
+			Usage:  "keytab of the Kerberos principal, the credentials cache of kinit is used if not set",
+			EnvVar: "STORAGE_HDFS_KERBEROS_KEYTAB",
 		},
 	},
-	"depthdynamic": {
-		Type:    boolType,
-		Default: false,
-		CLIFlag: cli.BoolFlag{
-			Name:   "depth-dynamic",
-			Usage:  "the length of repo variable",
-			EnvVar: "DEPTH_DYNAMIC",
+	"storage.hdfs.kerberosconfig": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-hdfs-kerberos-config",
+			Usage:  "path to krb5.conf, defaults to KRB5_CONFIG or /etc/krb5.conf",
+			EnvVar: "STORAGE_HDFS_KERBEROS_CONFIG",
 		},
 	},
-	"cors.alloworigin": {
+	"storage.hdfs.kerberosserviceprincipal": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "cors-alloworigin",
-			Usage:  "value to set in the Access-Control-Allow-Origin HTTP header",
-			EnvVar: "CORS_ALLOW_ORIGIN",
+			Name:   "storage-hdfs-kerberos-service-principal",
+			Usage:  "Kerberos principal of the namenodes, as dfs.namenode.kerberos.principal (default nn/_HOST)",
+			EnvVar: "STORAGE_HDFS_KERBEROS_SERVICE_PRINCIPAL",
 		},
 	},
-	"enforce-semver2": {
+	"storage.hdfs.datanodehostname": {
 		Type:    boolType,
 		Default: false,
 		CLIFlag: cli.BoolFlag{
-			Name:   "enforce-semver2",
-			Usage:  "(deprecated) enforce the chart museum server only accepts the valid chart version as Helm does",
-			EnvVar: "ENFORCE_SEMVER2",
+			Name:   "storage-hdfs-datanode-hostname",
+			Usage:  "connect to HDFS datanodes by hostname rather than IP address",
+			EnvVar: "STORAGE_HDFS_DATANODE_HOSTNAME",
 		},
-		Deprecated: true,
 	},
-	"cacheinterval": {
-		Type:    durationType,
-		Default: 5 * time.Minute,
-		CLIFlag: cli.DurationFlag{
-			Name:   "cache-interval",
-			Usage:  "set the interval of delta updating the cache",
-			EnvVar: "CACHE_INTERVAL",
+	"storage.google.bucket": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-google-bucket",
+			Usage:  "gcs bucket to store charts for google storage backend",
+			EnvVar: "STORAGE_GOOGLE_BUCKET",
 		},
 	},
-	"listen.host": {
+	"storage.google.prefix": {
 		Type:    stringType,
-		Default: "0.0.0.0",
+		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "listen-host",
-			Usage:  "specifies the host to listen on",
-			EnvVar: "LISTEN_HOST",
+			Name:   "storage-google-prefix",
+			Usage:  "prefix to store charts for --storage-google-bucket",
+			EnvVar: "STORAGE_GOOGLE_PREFIX",
 		},
 	},
-	"per-chart-limit": {
-		Type:    intType,
-		Default: 0,
-		CLIFlag: cli.IntFlag{
-			Name:   "per-chart-limit",
-			Usage:  "limits the museum server stores the max N versions per chart",
-			EnvVar: "PER_CHART_LIMIT",
+	"storage.google.credentialsfile": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-google-credentials-file",
+			Usage:  "service account JSON key file for google storage backend, defaults to application default credentials",
+			EnvVar: "STORAGE_GOOGLE_CREDENTIALS_FILE",
 		},
 	},
-	"web-template-path": {
+	"storage.google.vaultpath": {
 		Type:    stringType,
 		Default: "",
 		CLIFlag: cli.StringFlag{
-			Name:   "web-template-path",
-			Usage:  "path to the folder, which contains the custom welcome page",
-			EnvVar: "WEB_TEMPLATE_PATH",
+			Name:   "storage-google-vault-path",
+			Usage:  "Vault path of the Google credentials, with the token or private_key_data fields of the GCP secrets engine (i.e. gcp/roleset/chartmuseum/token)",
+			EnvVar: "STORAGE_GOOGLE_VAULT_PATH",
 		},
 	},
-	"artifact-hub-repo-id": {
-		Type: keyValueType,
-		CLIFlag: cli.GenericFlag{
-			Name:  "artifact-hub-repo-id",
-			Value: &KeyValueFlag{},
-			Usage: "the artifact hub repositoryID used to populate a artifacthub-repo.yml file. " +
-				"This can be a single repository ID for depth=0 servers or a key value pair for depth=N servers (i.e org1/repo1=foo).",
-			EnvVar: "ARTIFACT_HUB_REPO_ID",
+	"storage.oracle.bucket": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-oracle-bucket",
+			Usage:  "ocs bucket to store charts for oracle cloud storage",
+			EnvVar: "STORAGE_ORACLE_BUCKET",
 		},
 	},
-	"always-regenerate-chart-index": {
-		Type: boolType,
-		CLIFlag: cli.BoolFlag{
-			Name:   "always-regenerate-chart-index",
-			Usage:  "always regenerate the chart index and ignore the chart cache (this will result in decreased performance and an increase in resource consumption)",
+	"storage.oracle.prefix": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-oracle-prefix",
+			Usage:  "prefix to store charts for --storage-oracle-bucket",
+			EnvVar: "STORAGE_ORACLE_PREFIX",
+		},
+	},
+	"storage.oracle.region": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-oracle-region",
+			Usage:  "region to store charts for --storage-oracle-bucket",
+			EnvVar: "STORAGE_ORACLE_REGION",
+		},
+	},
+	"storage.oracle.compartmentid": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-oracle-compartmentid",
+			Usage:  "compartment ocid of --storage-oracle-bucket",
+			EnvVar: "STORAGE_ORACLE_COMPARTMENTID",
+		},
+	},
+	"storage.oracle.auth": {
+		Type:    stringType,
+		Default: "config-file",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-oracle-auth",
+			Usage:  "authentication method for oracle storage backend, can be one of: config-file, instance-principal",
+			EnvVar: "STORAGE_ORACLE_AUTH",
+		},
+	},
+	"storage.oracle.configfile": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-oracle-config-file",
+			Usage:  "OCI config file for oracle storage backend, used when ~/.oci/config does not exist",
+			EnvVar: "STORAGE_ORACLE_CONFIG_FILE",
+		},
+	},
+	"storage.microsoft.container": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-microsoft-container",
+			Usage:  "container to store charts for microsoft storage backend",
+			EnvVar: "STORAGE_MICROSOFT_CONTAINER",
+		},
+	},
+	"storage.microsoft.prefix": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-microsoft-prefix",
+			Usage:  "prefix to store charts for --storage-microsoft-prefix",
+			EnvVar: "STORAGE_MICROSOFT_PREFIX",
+		},
+	},
+	"storage.microsoft.account": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-microsoft-account",
+			Usage:  "storage account name for microsoft storage backend, defaults to AZURE_STORAGE_ACCOUNT",
+			EnvVar: "STORAGE_MICROSOFT_ACCOUNT",
+		},
+	},
+	"storage.microsoft.accesskey": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-microsoft-access-key",
+			Usage:  "storage account access key for microsoft storage backend, defaults to AZURE_STORAGE_ACCESS_KEY",
+			EnvVar: "STORAGE_MICROSOFT_ACCESS_KEY",
+		},
+	},
+	"storage.microsoft.vaultpath": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-microsoft-vault-path",
+			Usage:  "Vault path of the storage account, with the fields account and access_key (i.e. secret/data/chartmuseum/azure)",
+			EnvVar: "STORAGE_MICROSOFT_VAULT_PATH",
+		},
+	},
+	"storage.alibaba.bucket": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-alibaba-bucket",
+			Usage:  "OSS bucket to store charts for Alibaba Cloud storage backend",
+			EnvVar: "STORAGE_ALIBABA_BUCKET",
+		},
+	},
+	"storage.alibaba.prefix": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-alibaba-prefix",
+			Usage:  "prefix to store charts for --storage-alibaba-cloud-bucket",
+			EnvVar: "STORAGE_ALIBABA_PREFIX",
+		},
+	},
+	"storage.alibaba.endpoint": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-alibaba-endpoint",
+			Usage:  "OSS endpoint",
+			EnvVar: "STORAGE_ALIBABA_ENDPOINT",
+		},
+	},
+	"storage.alibaba.sse": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-alibaba-sse",
+			Usage:  "server side encryption algorithm for Alibaba Cloud storage backend, AES256 or KMS",
+			EnvVar: "STORAGE_ALIBABA_SSE",
+		},
+	},
+	"storage.alibaba.securitytoken": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-alibaba-security-token",
+			Usage:  "STS security token for alibaba storage backend, used with temporary access keys",
+			EnvVar: "ALIBABA_CLOUD_SECURITY_TOKEN",
+		},
+	},
+	"storage.openstack.container": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-openstack-container",
+			Usage:  "container to store charts for openstack storage backend",
+			EnvVar: "STORAGE_OPENSTACK_CONTAINER",
+		},
+	},
+	"storage.openstack.prefix": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-openstack-prefix",
+			Usage:  "prefix to store charts for --storage-openstack-container",
+			EnvVar: "STORAGE_OPENSTACK_PREFIX",
+		},
+	},
+	"storage.openstack.region": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-openstack-region",
+			Usage:  "region of --storage-openstack-container",
+			EnvVar: "STORAGE_OPENSTACK_REGION",
+		},
+	},
+	"storage.openstack.cacert": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-openstack-cacert",
+			Usage:  "path to a CA cert bundle for your openstack endpoint",
+			EnvVar: "STORAGE_OPENSTACK_CACERT",
+		},
+	},
+	"storage.openstack.auth": {
+		Type:    stringType,
+		Default: "auto",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-openstack-auth",
+			Usage:  "the OpenStack auth protocol to use. Set \"v1\" for v1, \"auto\" to negotiate the Keystone version from OS_AUTH_URL, or \"v2\" or \"v3\" to use that Keystone version",
+			EnvVar: "STORAGE_OPENSTACK_AUTH",
+		},
+	},
+	"storage.baidu.prefix": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-baidu-prefix",
+			Usage:  "prefix to store charts for --storage-baidu-cloud-bucket",
+			EnvVar: "STORAGE_BAIDU_PREFIX",
+		},
+	},
+	"storage.baidu.bucket": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-baidu-bucket",
+			Usage:  "BOS bucket to store charts for Baidu Cloud storage backend",
+			EnvVar: "STORAGE_BAIDU_BUCKET",
+		},
+	},
+	"storage.baidu.endpoint": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-baidu-endpoint",
+			Usage:  "BOS endpoint",
+			EnvVar: "STORAGE_BAIDU_ENDPOINT",
+		},
+	},
+	"storage.baidu.accesskeyid": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-baidu-access-key-id",
+			Usage:  "access key id for Baidu Cloud storage backend, defaults to BAIDU_CLOUD_ACCESS_KEY_ID",
+			EnvVar: "STORAGE_BAIDU_ACCESS_KEY_ID",
+		},
+	},
+	"storage.baidu.accesskeysecret": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-baidu-access-key-secret",
+			Usage:  "access key secret for Baidu Cloud storage backend, defaults to BAIDU_CLOUD_ACCESS_KEY_SECRET",
+			EnvVar: "STORAGE_BAIDU_ACCESS_KEY_SECRET",
+		},
+	},
+	"storage.etcd.endpoint": {
+		Type:    stringType,
+		Default: "http://localhost:2379",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-etcd-endpoint",
+			Usage:  "ETCD endpoint",
+			EnvVar: "STORAGE_ETCD_ENDPOINT",
+		},
+	},
+	"storage.etcd.cafile": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-etcd-cafile",
+			Usage:  "ca-file provided to etcd",
+			EnvVar: "STORAGE_ETCD_CAFILE",
+		},
+	},
+	"storage.etcd.certfile": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-etcd-certfile",
+			Usage:  "cert provided to etcd",
+			EnvVar: "STORAGE_ETCD_CERTFILE",
+		},
+	},
+	"storage.etcd.keyfile": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-etcd-keyfile",
+			Usage:  "key provided to etcd",
+			EnvVar: "STORAGE_ETCD_KEYFILE",
+		},
+	},
+	"storage.etcd.prefix": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-etcd-prefix",
+			Usage:  "prefix used in etcd to store the charts in etcd",
+			EnvVar: "STORAGE_ETCD_PREFIX",
+		},
+	},
+	"storage.etcd.maxobjectsize": {
+		Type:    intType,
+		Default: 1048576,
+		CLIFlag: cli.IntFlag{
+			Name:   "storage-etcd-max-object-size",
+			Usage:  "largest chart package or provenance file stored in etcd, in bytes, below the etcd request size limit (0 for no limit)",
+			EnvVar: "STORAGE_ETCD_MAX_OBJECT_SIZE",
+			Value:  1048576,
+		},
+	},
+	"storage.tencent.bucket": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-tencent-bucket",
+			Usage:  "COS bucket to store charts for Tencent Cloud storage backend",
+			EnvVar: "STORAGE_TENCENT_BUCKET",
+		},
+	},
+	"storage.tencent.prefix": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-tencent-prefix",
+			Usage:  "prefix to store charts for --storage-tencent-cloud-bucket",
+			EnvVar: "STORAGE_TENCENT_PREFIX",
+		},
+	},
+	"storage.tencent.endpoint": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-tencent-endpoint",
+			Usage:  "COS endpoint",
+			EnvVar: "STORAGE_TENCENT_ENDPOINT",
+		},
+	},
+	"storage.tencent.region": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-tencent-region",
+			Usage:  "COS region (e.g. ap-beijing), used for the endpoint when --storage-tencent-endpoint is not set",
+			EnvVar: "STORAGE_TENCENT_REGION",
+		},
+	},
+	"storage.tencent.secretid": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-tencent-secret-id",
+			Usage:  "secret id for Tencent Cloud storage backend, defaults to TENCENT_CLOUD_COS_SECRET_ID",
+			EnvVar: "STORAGE_TENCENT_SECRET_ID",
+		},
+	},
+	"storage.tencent.secretkey": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "storage-tencent-secret-key",
+			Usage:  "secret key for Tencent Cloud storage backend, defaults to TENCENT_CLOUD_COS_SECRET_KEY",
+			EnvVar: "STORAGE_TENCENT_SECRET_KEY",
+		},
+	},
+	"chartpostformfieldname": {
+		Type:    stringType,
+		Default: "chart",
+		CLIFlag: cli.StringFlag{
+			Name:   "chart-post-form-field-name",
+			Usage:  "form field which will be queried for the chart file content",
+			EnvVar: "CHART_POST_FORM_FIELD_NAME",
+		},
+	},
+	"provpostformfieldname": {
+		Type:    stringType,
+		Default: "prov",
+		CLIFlag: cli.StringFlag{
+			Name:   "prov-post-form-field-name",
+			Usage:  "form field which will be queried for the provenance file content",
+			EnvVar: "PROV_POST_FORM_FIELD_NAME",
+		},
+	},
+	"maxstorageobjects": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "max-storage-objects",
+			Usage:  "maximum number of objects allowed in storage (per tenant)",
+			EnvVar: "MAX_STORAGE_OBJECTS",
+		},
+	},
+	"maxuploadsize": {
+		Type:    intType,
+		Default: 1024 * 1024 * 20, // 20MB, per Helm's limit
+		CLIFlag: cli.IntFlag{
+			Name:   "max-upload-size",
+			Usage:  "max size of post body (in bytes)",
+			EnvVar: "MAX_UPLOAD_SIZE",
+			Value:  1024 * 1024 * 20,
+		},
+	},
+	"indexlimit": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "index-limit",
+			Usage:  "parallel scan limit for the repo indexer",
+			EnvVar: "INDEX_LIMIT",
+		},
+	},
+	"contextpath": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "context-path",
+			Usage:  "base context path",
+			EnvVar: "CONTEXT_PATH",
+		},
+	},
+	"depth": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "depth",
+			Usage:  "levels of nested repos for multitenancy",
+			EnvVar: "DEPTH",
+		},
+	},
+	"bearerauth": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "bearer-auth",
+			Usage:  "enable bearer auth",
+			EnvVar: "BEARER_AUTH",
+		},
+	},
+	"authrealm": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-realm",
+			Usage:  "authorization server url",
+			EnvVar: "AUTH_REALM",
+		},
+	},
+	"authservice": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-service",
+			Usage:  "authorization server service name",
+			EnvVar: "AUTH_SERVICE",
+		},
+	},
+	"authcertpath": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-cert-path",
+			Usage:  "path to authorization server public pem file",
+			EnvVar: "AUTH_CERT_PATH",
+		},
+	},
+	"authactionssearchpath": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-actions-search-path",
+			Usage:  "JMESPath to find allowed actions in a jwt token",
+			EnvVar: "AUTH_ACTIONS_SEARCH_PATH",
+		},
+	},
+	"authjwtsecret": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-jwt-secret",
+			Usage:  "shared secret verifying HS256 bearer tokens",
+			EnvVar: "AUTH_JWT_SECRET",
+		},
+	},
+	"authjwksurl": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-jwks-url",
+			Usage:  "URL of the JSON Web Key Set verifying RS256 bearer tokens",
+			EnvVar: "AUTH_JWKS_URL",
+		},
+	},
+	"authissuer": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-issuer",
+			Usage:  "issuer required in the iss claim of bearer tokens",
+			EnvVar: "AUTH_ISSUER",
+		},
+	},
+	"authaudience": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-audience",
+			Usage:  "audience required in the aud claim of bearer tokens",
+			EnvVar: "AUTH_AUDIENCE",
+		},
+	},
+	"authoidcissuerurl": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-oidc-issuer-url",
+			Usage:  "URL of the OpenID Connect provider issuing bearer tokens, its configuration being discovered",
+			EnvVar: "AUTH_OIDC_ISSUER_URL",
+		},
+	},
+	"authoidcclientid": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-oidc-client-id",
+			Usage:  "client id of the OpenID Connect provider, required in the aud claim of bearer tokens unless --auth-audience is set",
+			EnvVar: "AUTH_OIDC_CLIENT_ID",
+		},
+	},
+	"authoidcgroupsclaim": {
+		Type:    stringType,
+		Default: "groups",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-oidc-groups-claim",
+			Usage:  "claim of bearer tokens listing the groups of the user",
+			EnvVar: "AUTH_OIDC_GROUPS_CLAIM",
+		},
+	},
+	"authoidcgroup": {
+		Type: keyValueType,
+		CLIFlag: cli.GenericFlag{
+			Name:  "auth-oidc-group",
+			Value: &KeyValueFlag{},
+			Usage: "permissions of a group of the OpenID Connect provider, as a key value pair of the group and a space separated list " +
+				"of repo patterns and actions (i.e platform=org1/*:pull,push org2/charts:pull), can be repeated",
+			EnvVar: "AUTH_OIDC_GROUP",
+		},
+	},
+	"authrole": {
+		Type: keyValueType,
+		CLIFlag: cli.GenericFlag{
+			Name:  "auth-role",
+			Value: &KeyValueFlag{},
+			Usage: "roles of a user on repos, as a key value pair of the user and a space separated list of repo patterns and " +
+				"roles among read, write, delete and admin (i.e alice=org1/*:write org2/charts:read), can be repeated",
+			EnvVar: "AUTH_ROLE",
+		},
+	},
+	"authtenantsfile": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-tenants-file",
+			Usage:  "YAML file of the tenants authenticating the users of their repos with their own htpasswd file, OIDC provider or token issuer",
+			EnvVar: "AUTH_TENANTS_FILE",
+		},
+	},
+	"authtokenendpoint": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "auth-token-endpoint",
+			Usage:  "serve /auth/token, issuing bearer tokens to basic auth users for the scope of the WWW-Authenticate challenges",
+			EnvVar: "AUTH_TOKEN_ENDPOINT",
+		},
+	},
+	"authtokenkey": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-token-key",
+			Usage:  "RSA private key signing the tokens of --auth-token-endpoint with RS256, instead of --auth-jwt-secret",
+			EnvVar: "AUTH_TOKEN_KEY",
+		},
+	},
+	"authtokenttl": {
+		Type:    durationType,
+		Default: 5 * time.Minute,
+		CLIFlag: cli.DurationFlag{
+			Name:   "auth-token-ttl",
+			Usage:  "lifetime of the tokens of --auth-token-endpoint",
+			EnvVar: "AUTH_TOKEN_TTL",
+			Value:  5 * time.Minute,
+		},
+	},
+	"authpulltokenmaxttl": {
+		Type:    durationType,
+		Default: 7 * 24 * time.Hour,
+		CLIFlag: cli.DurationFlag{
+			Name:   "auth-pull-token-max-ttl",
+			Usage:  "longest lifetime of the pull tokens of repos, issued with --auth-token-endpoint",
+			EnvVar: "AUTH_PULL_TOKEN_MAX_TTL",
+			Value:  7 * 24 * time.Hour,
+		},
+	},
+	"authsession": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "auth-session",
+			Usage:  "serve a login form at /login, keeping the basic auth users of browsers logged in with a session cookie",
+			EnvVar: "AUTH_SESSION",
+		},
+	},
+	"authsessionsecret": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-session-secret",
+			Usage:  "secret signing the session cookies of --auth-session, random by default",
+			EnvVar: "AUTH_SESSION_SECRET",
+		},
+	},
+	"authsessionttl": {
+		Type:    durationType,
+		Default: 12 * time.Hour,
+		CLIFlag: cli.DurationFlag{
+			Name:   "auth-session-ttl",
+			Usage:  "lifetime of the sessions of --auth-session",
+			EnvVar: "AUTH_SESSION_TTL",
+			Value:  12 * time.Hour,
+		},
+	},
+	"authhmackey": {
+		Type: keyValueType,
+		CLIFlag: cli.GenericFlag{
+			Name:  "auth-hmac-key",
+			Value: &KeyValueFlag{},
+			Usage: "key authenticating HMAC-SHA256 signed requests as the user of its id, as a key value pair of the id " +
+				"and the secret (i.e ci=secret), can be repeated",
+			EnvVar: "AUTH_HMAC_KEY",
+		},
+	},
+	"authhmacskew": {
+		Type:    durationType,
+		Default: 5 * time.Minute,
+		CLIFlag: cli.DurationFlag{
+			Name:   "auth-hmac-skew",
+			Usage:  "largest difference between the date of signed requests and the server time",
+			EnvVar: "AUTH_HMAC_SKEW",
+			Value:  5 * time.Minute,
+		},
+	},
+	"authwebhookurl": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-webhook-url",
+			Usage:  "url of an external service, such as OPA, asked with a POST whether to allow each request",
+			EnvVar: "AUTH_WEBHOOK_URL",
+		},
+	},
+	"authwebhooktimeout": {
+		Type:    durationType,
+		Default: 5 * time.Second,
+		CLIFlag: cli.DurationFlag{
+			Name:   "auth-webhook-timeout",
+			Usage:  "timeout of the requests to --auth-webhook-url",
+			EnvVar: "AUTH_WEBHOOK_TIMEOUT",
+			Value:  5 * time.Second,
+		},
+	},
+	"authcachettl": {
+		Type:    durationType,
+		Default: 0,
+		CLIFlag: cli.DurationFlag{
+			Name:   "auth-cache-ttl",
+			Usage:  "how long requests allowed by the LDAP directory or the auth webhook are cached, not cached by default",
+			EnvVar: "AUTH_CACHE_TTL",
+		},
+	},
+	"authcachenegativettl": {
+		Type:    durationType,
+		Default: 0,
+		CLIFlag: cli.DurationFlag{
+			Name:   "auth-cache-negative-ttl",
+			Usage:  "how long requests denied by the LDAP directory or the auth webhook are cached, not cached by default",
+			EnvVar: "AUTH_CACHE_NEGATIVE_TTL",
+		},
+	},
+	"ldap.url": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-ldap-url",
+			Usage:  "url of the LDAP directory authenticating basic auth users (i.e. ldaps://ldap.example.com)",
+			EnvVar: "AUTH_LDAP_URL",
+		},
+	},
+	"ldap.starttls": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "auth-ldap-start-tls",
+			Usage:  "upgrade the ldap:// connection to the LDAP directory with StartTLS",
+			EnvVar: "AUTH_LDAP_START_TLS",
+		},
+	},
+	"ldap.cacert": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-ldap-ca-cert",
+			Usage:  "CA certificate file verifying the certificate of the LDAP directory",
+			EnvVar: "AUTH_LDAP_CA_CERT",
+		},
+	},
+	"ldap.binddn": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-ldap-bind-dn",
+			Usage:  "DN of the service account searching users and groups in the LDAP directory (anonymous if empty)",
+			EnvVar: "AUTH_LDAP_BIND_DN",
+		},
+	},
+	"ldap.bindpassword": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-ldap-bind-password",
+			Usage:  "password of --auth-ldap-bind-dn",
+			EnvVar: "AUTH_LDAP_BIND_PASSWORD",
+		},
+	},
+	"ldap.userbasedn": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-ldap-user-base-dn",
+			Usage:  "base DN of the users in the LDAP directory",
+			EnvVar: "AUTH_LDAP_USER_BASE_DN",
+		},
+	},
+	"ldap.userfilter": {
+		Type:    stringType,
+		Default: "(uid=%s)",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-ldap-user-filter",
+			Usage:  "filter finding a user in the LDAP directory, %s replaced by the username",
+			EnvVar: "AUTH_LDAP_USER_FILTER",
+			Value:  "(uid=%s)",
+		},
+	},
+	"ldap.groupbasedn": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-ldap-group-base-dn",
+			Usage:  "base DN of the groups in the LDAP directory",
+			EnvVar: "AUTH_LDAP_GROUP_BASE_DN",
+		},
+	},
+	"ldap.groupfilter": {
+		Type:    stringType,
+		Default: "(member=%s)",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-ldap-group-filter",
+			Usage:  "filter finding the groups of a user in the LDAP directory, %s replaced by the DN of the user",
+			EnvVar: "AUTH_LDAP_GROUP_FILTER",
+			Value:  "(member=%s)",
+		},
+	},
+	"ldap.groupattribute": {
+		Type:    stringType,
+		Default: "cn",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-ldap-group-attribute",
+			Usage:  "attribute naming the groups of the LDAP directory in --auth-ldap-group",
+			EnvVar: "AUTH_LDAP_GROUP_ATTRIBUTE",
+			Value:  "cn",
+		},
+	},
+	"ldap.group": {
+		Type: keyValueType,
+		CLIFlag: cli.GenericFlag{
+			Name:  "auth-ldap-group",
+			Value: &KeyValueFlag{},
+			Usage: "permissions of a group of the LDAP directory, as a key value pair of the group and a space separated list " +
+				"of repo patterns and actions (i.e developers=org1/*:pull,push org2/charts:pull), can be repeated. Every user is allowed if not set",
+			EnvVar: "AUTH_LDAP_GROUP",
+		},
+	},
+	"ldap.cachettl": {
+		Type:    durationType,
+		Default: 5 * time.Minute,
+		CLIFlag: cli.DurationFlag{
+			Name:   "auth-ldap-cache-ttl",
+			Usage:  "how long successful LDAP logins are cached",
+			EnvVar: "AUTH_LDAP_CACHE_TTL",
+			Value:  5 * time.Minute,
+		},
+	},
+	"depthdynamic": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "depth-dynamic",
+			Usage:  "the length of repo variable",
+			EnvVar: "DEPTH_DYNAMIC",
+		},
+	},
+	"cors.alloworigin": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "cors-alloworigin",
+			Usage:  "comma separated origins allowed to call the API from browsers, * or patterns (i.e. https://*.example.com)",
+			EnvVar: "CORS_ALLOW_ORIGIN",
+		},
+	},
+	"cors.allowmethods": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "cors-allowmethods",
+			Usage:  "comma separated methods allowed by CORS preflight requests, GET, HEAD, POST, PUT and DELETE by default",
+			EnvVar: "CORS_ALLOW_METHODS",
+		},
+	},
+	"cors.allowheaders": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "cors-allowheaders",
+			Usage:  "comma separated headers allowed by CORS preflight requests, Authorization and Content-Type by default",
+			EnvVar: "CORS_ALLOW_HEADERS",
+		},
+	},
+	"cors.allowcredentials": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "cors-allowcredentials",
+			Usage:  "allow browsers to send cookies and credentials with cross-origin requests",
+			EnvVar: "CORS_ALLOW_CREDENTIALS",
+		},
+	},
+	"cors.maxage": {
+		Type:    durationType,
+		Default: 0,
+		CLIFlag: cli.DurationFlag{
+			Name:   "cors-maxage",
+			Usage:  "how long browsers may cache the answers to CORS preflight requests",
+			EnvVar: "CORS_MAX_AGE",
+		},
+	},
+	"enforce-semver2": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "enforce-semver2",
+			Usage:  "(deprecated) enforce the chart museum server only accepts the valid chart version as Helm does",
+			EnvVar: "ENFORCE_SEMVER2",
+		},
+		Deprecated: true,
+	},
+	"cacheinterval": {
+		Type:    durationType,
+		Default: 5 * time.Minute,
+		CLIFlag: cli.DurationFlag{
+			Name:   "cache-interval",
+			Usage:  "set the interval of delta updating the cache",
+			EnvVar: "CACHE_INTERVAL",
+		},
+	},
+	"listen.host": {
+		Type:    stringType,
+		Default: "0.0.0.0",
+		CLIFlag: cli.StringFlag{
+			Name:   "listen-host",
+			Usage:  "specifies the host to listen on",
+			EnvVar: "LISTEN_HOST",
+		},
+	},
+	"per-chart-limit": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "per-chart-limit",
+			Usage:  "limits the museum server stores the max N versions per chart",
+			EnvVar: "PER_CHART_LIMIT",
+		},
+	},
+	"web-template-path": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "web-template-path",
+			Usage:  "path to the folder, which contains the custom welcome page",
+			EnvVar: "WEB_TEMPLATE_PATH",
+		},
+	},
+	"artifact-hub-repo-id": {
+		Type: keyValueType,
+		CLIFlag: cli.GenericFlag{
+			Name:  "artifact-hub-repo-id",
+			Value: &KeyValueFlag{},
+			Usage: "the artifact hub repositoryID used to populate a artifacthub-repo.yml file. " +
+				"This can be a single repository ID for depth=0 servers or a key value pair for depth=N servers (i.e org1/repo1=foo).",
+			EnvVar: "ARTIFACT_HUB_REPO_ID",
+		},
+	},
+	"artifact-hub-repo-file": {
+		Type: keyValueType,
+		CLIFlag: cli.GenericFlag{
+			Name:  "artifact-hub-repo-file",
+			Value: &KeyValueFlag{},
+			Usage: "path to a file with the content of the artifacthub-repo.yml file (e.g. owners). " +
+				"This can be a single path for depth=0 servers or a key value pair for depth=N servers (i.e org1/repo1=/path/to/file.yml).",
+			EnvVar: "ARTIFACT_HUB_REPO_FILE",
+		},
+	},
+	"always-regenerate-chart-index": {
+		Type: boolType,
+		CLIFlag: cli.BoolFlag{
+			Name:   "always-regenerate-chart-index",
+			Usage:  "always regenerate the chart index and ignore the chart cache (this will result in decreased performance and an increase in resource consumption)",
 			EnvVar: "ALWAYS_REGENERATE_CHART_INDEX",
 		},
 	},
+	"legacy-upload-response": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "legacy-upload-response",
+			Usage:  "respond to multipart chart uploads with {\"saved\": true} instead of the list of stored files",
+			EnvVar: "LEGACY_UPLOAD_RESPONSE",
+		},
+	},
+	"multi-chart-upload": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "multi-chart-upload",
+			Usage:  "accept several charts in the same multipart form field or batch upload, such uploads are rejected otherwise",
+			EnvVar: "MULTI_CHART_UPLOAD",
+		},
+	},
+	"reserved-prefixes": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "reserved-prefixes",
+			Usage:  "comma-separated object prefixes which are not charts and are skipped when generating the index, on top of trash/, audit/ and attachments/",
+			EnvVar: "RESERVED_PREFIXES",
+		},
+	},
+	"upload-rollback-retries": {
+		Type:    intType,
+		Default: 3,
+		CLIFlag: cli.IntFlag{
+			Name:   "upload-rollback-retries",
+			Usage:  "how many times deleting the already stored files of a failed upload is retried",
+			EnvVar: "UPLOAD_ROLLBACK_RETRIES",
+		},
+	},
+	"max-index-size": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "max-index-size",
+			Usage:  "max size in bytes of a served index, larger indexes are rejected (0 for no limit)",
+			EnvVar: "MAX_INDEX_SIZE",
+		},
+	},
+	"truncate-index": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "truncate-index",
+			Usage:  "serve an index larger than --max-index-size truncated, with an X-Index-Truncated header, instead of rejecting it",
+			EnvVar: "TRUNCATE_INDEX",
+		},
+	},
+	"require-provenance": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "require-provenance",
+			Usage:  "reject charts uploaded without a provenance file, repos can override it with their settings",
+			EnvVar: "REQUIRE_PROVENANCE",
+		},
+	},
+	"multipart-max-memory": {
+		Type:    intType,
+		Default: 33554432,
+		CLIFlag: cli.IntFlag{
+			Name:   "multipart-max-memory",
+			Usage:  "max bytes of a multipart upload kept in memory, the rest of the files being buffered in temporary files",
+			EnvVar: "MULTIPART_MAX_MEMORY",
+		},
+	},
+	"health-details": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "health-details",
+			Usage:  "include the version, uptime and start time in /health responses",
+			EnvVar: "HEALTH_DETAILS",
+		},
+	},
+	"case-insensitive-chart-names": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "case-insensitive-chart-names",
+			Usage:  "reject uploads of a chart whose name only differs by case from an existing chart (e.g. MyChart and mychart)",
+			EnvVar: "CASE_INSENSITIVE_CHART_NAMES",
+		},
+	},
+	"min-chart-api-version": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "min-chart-api-version",
+			Usage:  "reject uploaded charts with an apiVersion lower than this one (e.g. v2)",
+			EnvVar: "MIN_CHART_API_VERSION",
+		},
+	},
+	"index-debounce": {
+		Type:    durationType,
+		Default: time.Duration(0),
+		CLIFlag: cli.DurationFlag{
+			Name:   "index-debounce",
+			Usage:  "wait this long for more uploads before regenerating a repo index, serving the cached index meanwhile",
+			EnvVar: "INDEX_DEBOUNCE",
+		},
+	},
+	"index-debounce-max-staleness": {
+		Type:    durationType,
+		Default: time.Duration(0),
+		CLIFlag: cli.DurationFlag{
+			Name:   "index-debounce-max-staleness",
+			Usage:  "maximum time the cached index is served while --index-debounce waits for more uploads",
+			EnvVar: "INDEX_DEBOUNCE_MAX_STALENESS",
+		},
+	},
+	"index-debounce-repo": {
+		Type: keyValueType,
+		CLIFlag: cli.GenericFlag{
+			Name:  "index-debounce-repo",
+			Value: &KeyValueFlag{},
+			Usage: "the --index-debounce of a repo, as a key value pair of the repo and a duration " +
+				"(i.e org1/repo1=30s, or org1/repo2=0 to regenerate its index on every upload), can be repeated",
+			EnvVar: "INDEX_DEBOUNCE_REPO",
+		},
+	},
+	"upstream-repo-url": {
+		Type: keyValueType,
+		CLIFlag: cli.GenericFlag{
+			Name:  "upstream-repo-url",
+			Value: &KeyValueFlag{},
+			Usage: "an upstream repo url charts missing from storage are fetched from and cached (read-through proxy). " +
+				"This can be a single url for depth=0 servers or a key value pair for depth=N servers (i.e org1/repo1=https://charts.example.com).",
+			EnvVar: "UPSTREAM_REPO_URL",
+		},
+	},
+	"upstream-max-size": {
+		Type:    intType,
+		Default: 20971520, // 20 MB
+		CLIFlag: cli.IntFlag{
+			Name:   "upstream-max-size",
+			Usage:  "max size in bytes of an index or chart fetched from an upstream repo",
+			EnvVar: "UPSTREAM_MAX_SIZE",
+		},
+	},
+	"upstream-allowed-hosts": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "upstream-allowed-hosts",
+			Usage:  "comma-separated hosts charts can be fetched from in addition to the upstream repo hosts",
+			EnvVar: "UPSTREAM_ALLOWED_HOSTS",
+		},
+	},
+	"upload-url-allowed-hosts": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "upload-url-allowed-hosts",
+			Usage:  "comma-separated hosts charts can be uploaded from by URL, such uploads are rejected otherwise",
+			EnvVar: "UPLOAD_URL_ALLOWED_HOSTS",
+		},
+	},
+	"provenance-keyring": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "provenance-keyring",
+			Usage:  "keyring file with the public keys used to verify stored charts (enables GET /api/charts/<name>/<version>/verify)",
+			EnvVar: "PROVENANCE_KEYRING",
+		},
+	},
+	"index-reconcile-interval": {
+		Type:    durationType,
+		Default: time.Duration(0),
+		CLIFlag: cli.DurationFlag{
+			Name:   "index-reconcile-interval",
+			Usage:  "how often serving an index checks it against a storage listing, dropping charts deleted out-of-band (0 to disable)",
+			EnvVar: "INDEX_RECONCILE_INTERVAL",
+		},
+	},
+	"redirect-downloads": {
+		Type:    durationType,
+		Default: time.Duration(0),
+		CLIFlag: cli.DurationFlag{
+			Name:   "redirect-downloads",
+			Usage:  "redirect chart downloads to storage URLs presigned for this long, for S3 and GCS (0 to disable)",
+			EnvVar: "REDIRECT_DOWNLOADS",
+		},
+	},
+	"storage-list-page-size": {
+		Type:    intType,
+		Default: 1000,
+		CLIFlag: cli.IntFlag{
+			Name:   "storage-list-page-size",
+			Usage:  "number of objects listed per storage request when building an index, for backends listing pages",
+			EnvVar: "STORAGE_LIST_PAGE_SIZE",
+		},
+	},
+	"download-stats": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "download-stats",
+			Usage:  "count the downloads of chart versions, stored in one of: memory, storage, cache (redis)",
+			EnvVar: "DOWNLOAD_STATS",
+		},
+	},
+	"download-stats-interval": {
+		Type:    durationType,
+		Default: time.Minute,
+		CLIFlag: cli.DurationFlag{
+			Name:   "download-stats-interval",
+			Usage:  "interval of storing the downloads counted in memory",
+			EnvVar: "DOWNLOAD_STATS_INTERVAL",
+		},
+	},
 }
 
 type KeyValueFlag struct {
@@ -16,6 +16,32 @@ limitations under the License.
 
 package repo
 
+import "sigs.k8s.io/yaml"
+
+// ArtifactHubFile is the artifacthub-repo.yml marker read by Artifact Hub when indexing a repo
 type ArtifactHubFile struct {
-	RepoID string `json:"repositoryID"`
+	RepoID string              `json:"repositoryID"`
+	Owners []ArtifactHubOwner  `json:"owners,omitempty"`
+	Ignore []ArtifactHubIgnore `json:"ignore,omitempty"`
+}
+
+// ArtifactHubOwner is a user allowed to claim the repo on Artifact Hub
+type ArtifactHubOwner struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email"`
+}
+
+// ArtifactHubIgnore excludes charts from Artifact Hub, version being a regexp matching all versions if empty
+type ArtifactHubIgnore struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// ArtifactHubFileFromContent parses an artifacthub-repo.yml, rejecting unknown fields
+func ArtifactHubFileFromContent(content []byte) (*ArtifactHubFile, error) {
+	artifactHubFile := &ArtifactHubFile{}
+	if err := yaml.UnmarshalStrict(content, artifactHubFile); err != nil {
+		return nil, err
+	}
+	return artifactHubFile, nil
 }
@@ -17,17 +17,23 @@ limitations under the License.
 package repo
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	pathutil "path"
 	"strconv"
 	"strings"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/chartmuseum/storage"
 
 	helm_chart "helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
 	helm_repo "helm.sh/helm/v3/pkg/repo"
 )
 
@@ -40,6 +46,14 @@ var (
 
 	// ErrorInvalidChartPackage is raised when a chart package is invalid
 	ErrorInvalidChartPackage = errors.New("invalid chart package")
+	// ErrorInvalidChartAPIVersion is raised when a chart apiVersion cannot be parsed
+	ErrorInvalidChartAPIVersion = errors.New("invalid chart apiVersion")
+	// ErrorInvalidChartVersion is raised when a chart version is not valid semver
+	ErrorInvalidChartVersion = errors.New("invalid chart version")
+	// ErrorValuesNotFound is raised when a chart package holds no values.yaml
+	ErrorValuesNotFound = errors.New("values.yaml not found")
+	// ErrorReadmeNotFound is raised when a chart package holds no README
+	ErrorReadmeNotFound = errors.New("README not found")
 )
 
 // ChartPackageFilenameFromNameVersion returns a chart filename from a name and version
@@ -48,17 +62,85 @@ func ChartPackageFilenameFromNameVersion(name string, version string) string {
 	return filename
 }
 
+// ValidateChartVersion validates a chart version coming from a request, as parsed by Helm (v1.2.3 or
+// 1.2 being valid), so that it cannot turn a chart package filename into another path
+func ValidateChartVersion(version string) error {
+	if strings.Contains(version, "/") || strings.Contains(version, "..") {
+		return ErrorInvalidChartVersion
+	}
+	if _, err := semver.NewVersion(version); err != nil {
+		return ErrorInvalidChartVersion
+	}
+	return nil
+}
+
+// ValidateChartVersionConstraint validates a chart version coming from a request to look a chart
+// version up in an index, which can also be a constraint such as ~1.2
+func ValidateChartVersionConstraint(version string) error {
+	if strings.Contains(version, "/") || strings.Contains(version, "..") {
+		return ErrorInvalidChartVersion
+	}
+	if _, err := semver.NewConstraint(version); err != nil {
+		return ErrorInvalidChartVersion
+	}
+	return nil
+}
+
 // ChartPackageFilenameFromContent returns a chart filename from binary content
 func ChartPackageFilenameFromContent(content []byte) (string, error) {
-	chart, err := chartFromContent(content)
+	meta, err := ChartMetadataFromContent(content)
 	if err != nil {
 		return "", err
 	}
-	meta := chart.Metadata
 	filename := fmt.Sprintf("%s-%s.%s", meta.Name, meta.Version, ChartPackageFileExtension)
 	return filename, nil
 }
 
+// ChartMetadataFromContent returns the chart metadata (Chart.yaml) from binary content
+func ChartMetadataFromContent(content []byte) (*helm_chart.Metadata, error) {
+	chart, err := chartFromContent(content)
+	if err != nil {
+		return nil, err
+	}
+	return chart.Metadata, nil
+}
+
+// CheckChartAPIVersion returns an error if the chart apiVersion is lower than minAPIVersion (e.g. "v2")
+func CheckChartAPIVersion(meta *helm_chart.Metadata, minAPIVersion string) error {
+	if minAPIVersion == "" {
+		return nil
+	}
+	min, err := ParseChartAPIVersion(minAPIVersion)
+	if err != nil {
+		return err
+	}
+	// charts without an apiVersion predate Helm 3 and are treated as v1
+	apiVersion := meta.APIVersion
+	if apiVersion == "" {
+		apiVersion = helm_chart.APIVersionV1
+	}
+	current, err := ParseChartAPIVersion(apiVersion)
+	if err != nil {
+		return err
+	}
+	if current < min {
+		return fmt.Errorf("chart apiVersion %s is lower than the minimum required %s", apiVersion, minAPIVersion)
+	}
+	return nil
+}
+
+// ParseChartAPIVersion returns the numeric part of a chart apiVersion (e.g. 2 for "v2")
+func ParseChartAPIVersion(apiVersion string) (int, error) {
+	if !strings.HasPrefix(apiVersion, "v") {
+		return 0, ErrorInvalidChartAPIVersion
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(apiVersion, "v"))
+	if err != nil || n < 1 {
+		return 0, ErrorInvalidChartAPIVersion
+	}
+	return n, nil
+}
+
 // ChartVersionFromStorageObject returns a chart version from a storage object
 func ChartVersionFromStorageObject(object storage.Object) (*helm_repo.ChartVersion, error) {
 	if len(object.Content) == 0 {
@@ -106,6 +188,119 @@ func StorageObjectFromChartVersion(chartVersion *helm_repo.ChartVersion) storage
 	return object
 }
 
+// RenameChartPackage repackages a chart under another name, as the name in Chart.yaml must match the package filename
+func RenameChartPackage(content []byte, name string) ([]byte, error) {
+	chart, err := chartFromContent(content)
+	if err != nil {
+		return nil, ErrorInvalidChartPackage
+	}
+	chart.Metadata.Name = name
+	if err := chart.Metadata.Validate(); err != nil {
+		return nil, err
+	}
+	return savedChartContent(chart)
+}
+
+// DeprecateChartPackage repackages a chart marked deprecated in Chart.yaml, which Helm warns about
+// when installing it
+func DeprecateChartPackage(content []byte) ([]byte, error) {
+	chart, err := chartFromContent(content)
+	if err != nil {
+		return nil, ErrorInvalidChartPackage
+	}
+	chart.Metadata.Deprecated = true
+	return savedChartContent(chart)
+}
+
+// savedChartContent packages a chart, returning the content of the package
+func savedChartContent(chart *helm_chart.Chart) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "chartmuseum-repackage")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path, err := chartutil.Save(chart, dir)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// readmeFileNames are the names of the README of a chart, in lower case, as shown by helm show readme
+var readmeFileNames = []string{"readme.md", "readme.txt", "readme"}
+
+// ChartValuesFromContent returns the values.yaml of a chart package, reading the archive up to it
+// only, rather than loading the whole chart
+func ChartValuesFromContent(content []byte) ([]byte, error) {
+	return chartFileFromContent(content, func(file string) bool { return file == "values.yaml" }, ErrorValuesNotFound)
+}
+
+// ChartReadmeFromContent returns the README of a chart package, whichever of README.md, README.txt
+// or README comes first in the archive, in any case
+func ChartReadmeFromContent(content []byte) ([]byte, error) {
+	return chartFileFromContent(content, func(file string) bool {
+		for _, name := range readmeFileNames {
+			if strings.EqualFold(file, name) {
+				return true
+			}
+		}
+		return false
+	}, ErrorReadmeNotFound)
+}
+
+// chartFileFromContent returns the first file of the directory of a chart package matching match,
+// rather than a file of its subcharts, or notFound if none does
+func chartFileFromContent(content []byte, match func(file string) bool, notFound error) ([]byte, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, ErrorInvalidChartPackage
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, notFound
+		}
+		if err != nil {
+			return nil, ErrorInvalidChartPackage
+		}
+		dir, file, found := strings.Cut(strings.TrimPrefix(header.Name, "./"), "/")
+		if !found || dir == "" || !match(file) || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		return io.ReadAll(tarReader)
+	}
+}
+
+// chartFilesFromContent returns the files of the directory of a chart package matching match, by
+// their path in the directory, rather than the files of its subcharts
+func chartFilesFromContent(content []byte, match func(file string) bool) (map[string][]byte, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, ErrorInvalidChartPackage
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+	files := map[string][]byte{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, ErrorInvalidChartPackage
+		}
+		dir, file, found := strings.Cut(strings.TrimPrefix(header.Name, "./"), "/")
+		if !found || dir == "" || !match(file) || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if files[file], err = io.ReadAll(tarReader); err != nil {
+			return nil, ErrorInvalidChartPackage
+		}
+	}
+}
+
 func chartFromContent(content []byte) (*helm_chart.Chart, error) {
 	chart, err := loader.LoadArchive(bytes.NewBuffer(content))
 	return chart, err
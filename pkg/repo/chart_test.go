@@ -25,6 +25,7 @@ import (
 	"github.com/stretchr/testify/suite"
 
 	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
 	helm_repo "helm.sh/helm/v3/pkg/repo"
 )
 
@@ -45,6 +46,42 @@ func (suite *ChartTestSuite) TestChartPackageFilenameFromNameVersion() {
 	suite.Equal("mychart-2.3.4.tgz", filename, "filename as expected")
 }
 
+func (suite *ChartTestSuite) TestCheckChartAPIVersion() {
+	v1 := &chart.Metadata{Name: "mychart", Version: "0.1.0", APIVersion: chart.APIVersionV1}
+	v2 := &chart.Metadata{Name: "mychart", Version: "0.1.0", APIVersion: chart.APIVersionV2}
+	legacy := &chart.Metadata{Name: "mychart", Version: "0.1.0"}
+
+	suite.Nil(CheckChartAPIVersion(v1, ""), "no minimum, v1 allowed")
+	suite.Nil(CheckChartAPIVersion(v1, "v1"), "v1 allowed with minimum v1")
+	suite.Nil(CheckChartAPIVersion(v2, "v1"), "v2 allowed with minimum v1")
+	suite.Nil(CheckChartAPIVersion(v2, "v2"), "v2 allowed with minimum v2")
+	suite.NotNil(CheckChartAPIVersion(v1, "v2"), "v1 rejected with minimum v2")
+	suite.NotNil(CheckChartAPIVersion(legacy, "v2"), "missing apiVersion rejected with minimum v2")
+	suite.Equal(ErrorInvalidChartAPIVersion, CheckChartAPIVersion(v2, "2"), "invalid minimum apiVersion")
+
+	n, err := ParseChartAPIVersion("v2")
+	suite.Nil(err, "no error parsing v2")
+	suite.Equal(2, n, "v2 parsed as 2")
+	_, err = ParseChartAPIVersion("vX")
+	suite.Equal(ErrorInvalidChartAPIVersion, err, "error parsing vX")
+}
+
+func (suite *ChartTestSuite) TestValidateChartVersion() {
+	for _, valid := range []string{"1.2.3", "v1.2.3", "1.2", "1.2.3-rc.1+build.5"} {
+		suite.Nil(ValidateChartVersion(valid), "valid chart version %q", valid)
+		suite.Nil(ValidateChartVersionConstraint(valid), "valid chart version constraint %q", valid)
+	}
+	for _, invalid := range []string{"", "v", "1.0.0/../", "../1.0.0", "1.0.0/x", "latest", "~1.2"} {
+		suite.Equal(ErrorInvalidChartVersion, ValidateChartVersion(invalid), "invalid chart version %q", invalid)
+	}
+
+	suite.Nil(ValidateChartVersionConstraint("~1.2"), "valid chart version constraint ~1.2")
+	suite.Nil(ValidateChartVersionConstraint(">=1.0 <2"), "valid chart version range")
+	for _, invalid := range []string{"1.0.0/../", "../1.0.0", ">=1..0", "latest"} {
+		suite.Equal(ErrorInvalidChartVersion, ValidateChartVersionConstraint(invalid), "invalid chart version constraint %q", invalid)
+	}
+}
+
 func (suite *ChartTestSuite) TestChartVersionFromStorageObject() {
 	object := storage.Object{
 		Path:         "mychart-2.3.4.tgz",
@@ -200,6 +237,45 @@ func (suite *ChartTestSuite) TestChartPackageFilenameFromContent() {
 	suite.Equal("mychart-0.1.0.tgz", filename, "chart tarball filename as expected")
 }
 
+func (suite *ChartTestSuite) TestChartValuesFromContent() {
+	values, err := ChartValuesFromContent(suite.TarballContent)
+	suite.Nil(err, "no error getting values from test tarball content")
+	suite.NotNil(values, "empty values.yaml")
+
+	content, err := os.ReadFile("../../testdata/charts/mychart3/mychart3-0.1.0.tgz")
+	suite.Nil(err)
+	_, err = ChartValuesFromContent(content)
+	suite.Equal(ErrorValuesNotFound, err, "chart without values.yaml")
+
+	_, err = ChartValuesFromContent([]byte("not a chart"))
+	suite.Equal(ErrorInvalidChartPackage, err)
+}
+
+func (suite *ChartTestSuite) TestChartReadmeFromContent() {
+	_, err := ChartReadmeFromContent(suite.TarballContent)
+	suite.Equal(ErrorReadmeNotFound, err, "chart without README")
+
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "readmechart", Version: "0.1.0"},
+		Files:    []*chart.File{{Name: "Readme.md", Data: []byte("# readmechart")}},
+	}
+	dependency := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "dependency", Version: "0.1.0"},
+		Files:    []*chart.File{{Name: "README.md", Data: []byte("# dependency")}},
+	}
+	ch.AddDependency(dependency)
+	path, err := chartutil.Save(ch, suite.T().TempDir())
+	suite.Nil(err)
+	content, err := os.ReadFile(path)
+	suite.Nil(err)
+	readme, err := ChartReadmeFromContent(content)
+	suite.Nil(err, "README in any case")
+	suite.Equal("# readmechart", string(readme), "README of the chart rather than of its subcharts")
+
+	_, err = ChartReadmeFromContent([]byte("not a chart"))
+	suite.Equal(ErrorInvalidChartPackage, err)
+}
+
 func TestChartTestSuite(t *testing.T) {
 	suite.Run(t, new(ChartTestSuite))
 }
@@ -0,0 +1,351 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ChartDiffAdded is the status of the files and values only in the newer chart version
+	ChartDiffAdded = "added"
+	// ChartDiffRemoved is the status of the files and values only in the older chart version
+	ChartDiffRemoved = "removed"
+	// ChartDiffModified is the status of the files and values of both chart versions which differ
+	ChartDiffModified = "modified"
+
+	// diffContextLines is the number of unchanged lines around the changes of a hunk
+	diffContextLines = 3
+	// maxDiffEdits bounds the line edits searched between two files, past which the whole file is
+	// shown as replaced rather than spending quadratic time on it
+	maxDiffEdits = 1000
+)
+
+type (
+	// ChartDiff is the difference between the Chart.yaml, values.yaml and templates of two chart
+	// packages, unchanged files left out
+	ChartDiff struct {
+		Files  []*ChartFileDiff `json:"files"`
+		Values []*ValueDiff     `json:"values"`
+	}
+
+	// ChartFileDiff is the difference between a file of two chart packages
+	ChartFileDiff struct {
+		// Path is the path of the file in the chart directory, such as templates/deployment.yaml
+		Path   string `json:"path"`
+		Status string `json:"status"`
+		// Diff holds the hunks of the unified diff of the file
+		Diff string `json:"diff"`
+	}
+
+	// ValueDiff is the difference between a value of the values.yaml of two chart packages, by the
+	// dotted path of its key. Lists are compared as a whole
+	ValueDiff struct {
+		Path   string      `json:"path"`
+		Status string      `json:"status"`
+		From   interface{} `json:"from"`
+		To     interface{} `json:"to"`
+	}
+
+	// lineEdit is a line kept (' '), removed ('-') or added ('+') by a diff
+	lineEdit struct {
+		op   byte
+		line string
+	}
+)
+
+// isChartDiffFile tells the files of the directory of a chart compared by DiffChartPackages
+func isChartDiffFile(file string) bool {
+	return file == "Chart.yaml" || file == "values.yaml" || strings.HasPrefix(file, "templates/")
+}
+
+// DiffChartPackages compares the Chart.yaml, values.yaml and templates of two chart packages, the
+// files of their subcharts left out
+func DiffChartPackages(from []byte, to []byte) (*ChartDiff, error) {
+	fromFiles, err := chartFilesFromContent(from, isChartDiffFile)
+	if err != nil {
+		return nil, err
+	}
+	toFiles, err := chartFilesFromContent(to, isChartDiffFile)
+	if err != nil {
+		return nil, err
+	}
+	diff := &ChartDiff{Files: []*ChartFileDiff{}}
+	paths := make([]string, 0, len(fromFiles)+len(toFiles))
+	for path := range fromFiles {
+		paths = append(paths, path)
+	}
+	for path := range toFiles {
+		if _, found := fromFiles[path]; !found {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fromContent, inFrom := fromFiles[path]
+		toContent, inTo := toFiles[path]
+		if bytes.Equal(fromContent, toContent) && inFrom == inTo {
+			continue
+		}
+		fileDiff := &ChartFileDiff{Path: path, Status: ChartDiffModified}
+		if !inFrom {
+			fileDiff.Status = ChartDiffAdded
+		} else if !inTo {
+			fileDiff.Status = ChartDiffRemoved
+		}
+		fileDiff.Diff = unifiedHunks(splitLines(string(fromContent)), splitLines(string(toContent)))
+		diff.Files = append(diff.Files, fileDiff)
+	}
+	diff.Values, err = diffValues(fromFiles["values.yaml"], toFiles["values.yaml"])
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// Unified returns the unified diff of all the files changed, as applied by patch -p1
+func (diff *ChartDiff) Unified() string {
+	var b strings.Builder
+	for _, file := range diff.Files {
+		from, to := "a/"+file.Path, "b/"+file.Path
+		if file.Status == ChartDiffAdded {
+			from = "/dev/null"
+		} else if file.Status == ChartDiffRemoved {
+			to = "/dev/null"
+		}
+		fmt.Fprintf(&b, "--- %s\n+++ %s\n%s", from, to, file.Diff)
+	}
+	return b.String()
+}
+
+// diffValues compares two values.yaml by the dotted paths of their values, empty if missing
+func diffValues(from []byte, to []byte) ([]*ValueDiff, error) {
+	fromValues := map[string]interface{}{}
+	toValues := map[string]interface{}{}
+	if err := yaml.Unmarshal(from, &fromValues); err != nil {
+		return nil, fmt.Errorf("invalid values.yaml: %s", err)
+	}
+	if err := yaml.Unmarshal(to, &toValues); err != nil {
+		return nil, fmt.Errorf("invalid values.yaml: %s", err)
+	}
+	fromFlat := map[string]interface{}{}
+	toFlat := map[string]interface{}{}
+	flattenValues("", fromValues, fromFlat)
+	flattenValues("", toValues, toFlat)
+	diffs := []*ValueDiff{}
+	for path, fromValue := range fromFlat {
+		toValue, found := toFlat[path]
+		switch {
+		case !found:
+			diffs = append(diffs, &ValueDiff{Path: path, Status: ChartDiffRemoved, From: fromValue})
+		case !reflect.DeepEqual(fromValue, toValue):
+			diffs = append(diffs, &ValueDiff{Path: path, Status: ChartDiffModified, From: fromValue, To: toValue})
+		}
+	}
+	for path, toValue := range toFlat {
+		if _, found := fromFlat[path]; !found {
+			diffs = append(diffs, &ValueDiff{Path: path, Status: ChartDiffAdded, To: toValue})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	return diffs, nil
+}
+
+// flattenValues adds the values of a map to flat by their dotted paths, below prefix. Empty maps
+// are kept as values so that adding or removing one shows
+func flattenValues(prefix string, values map[string]interface{}, flat map[string]interface{}) {
+	for key, value := range values {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			flattenValues(path, nested, flat)
+			continue
+		}
+		flat[path] = value
+	}
+}
+
+// splitLines splits content in lines, each with its newline but the last one if missing
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// unifiedHunks returns the hunks of the unified diff of two files, as lines, with
+// diffContextLines lines of context
+func unifiedHunks(from []string, to []string) string {
+	edits := diffLines(from, to)
+	var b strings.Builder
+	// the lines of both files before each edit
+	fromLine := make([]int, len(edits)+1)
+	toLine := make([]int, len(edits)+1)
+	for i, edit := range edits {
+		fromLine[i+1], toLine[i+1] = fromLine[i], toLine[i]
+		if edit.op != '+' {
+			fromLine[i+1]++
+		}
+		if edit.op != '-' {
+			toLine[i+1]++
+		}
+	}
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		// extend the hunk to the next changes closer than twice the context
+		end := i
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(edits) && edits[run].op == ' ' {
+				run++
+			}
+			if run == len(edits) || run-end > 2*diffContextLines {
+				if run-end > diffContextLines {
+					run = end + diffContextLines
+				}
+				end = run
+				break
+			}
+			end = run
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n",
+			hunkRange(fromLine[start], fromLine[end]-fromLine[start]),
+			hunkRange(toLine[start], toLine[end]-toLine[start]))
+		for _, edit := range edits[start:end] {
+			b.WriteByte(edit.op)
+			b.WriteString(edit.line)
+			if !strings.HasSuffix(edit.line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return b.String()
+}
+
+// hunkRange formats the range of lines of a hunk in a file, from the line before it
+func hunkRange(before int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// diffLines returns the shortest edits turning from into to, by the algorithm of Myers, or the
+// replacement of all the lines if more than maxDiffEdits are needed
+func diffLines(from []string, to []string) []lineEdit {
+	n, m := len(from), len(to)
+	max := n + m
+	if max > 2*maxDiffEdits {
+		max = 2 * maxDiffEdits
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace keeps v[-d..d] before each step d, for backtracking
+	var trace [][]int
+	found := false
+	for d := 0; d <= max && d <= maxDiffEdits && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && from[x] == to[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		edits := make([]lineEdit, 0, n+m)
+		for _, line := range from {
+			edits = append(edits, lineEdit{'-', line})
+		}
+		for _, line := range to {
+			edits = append(edits, lineEdit{'+', line})
+		}
+		return edits
+	}
+
+	var reversed []lineEdit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		previous := func(k int) int { return trace[d][k+d] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && previous(k-1) < previous(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = previous(prevK)
+		}
+		prevY := prevX - prevK
+		for x > prevX && y > prevY && x > 0 && y > 0 {
+			reversed = append(reversed, lineEdit{' ', from[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, lineEdit{'+', to[y-1]})
+			} else {
+				reversed = append(reversed, lineEdit{'-', from[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	edits := make([]lineEdit, len(reversed))
+	for i, edit := range reversed {
+		edits[len(reversed)-1-i] = edit
+	}
+	return edits
+}
@@ -0,0 +1,136 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+type DiffTestSuite struct {
+	suite.Suite
+}
+
+func (suite *DiffTestSuite) packageChart(version string, values string, templates map[string]string) []byte {
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "diffchart", Version: version},
+		Raw:      []*chart.File{{Name: "values.yaml", Data: []byte(values)}},
+	}
+	for name, data := range templates {
+		ch.Templates = append(ch.Templates, &chart.File{Name: name, Data: []byte(data)})
+	}
+	dependency := &chart.Chart{
+		Metadata:  &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "dependency", Version: version},
+		Templates: []*chart.File{{Name: "templates/cm.yaml", Data: []byte(version)}},
+	}
+	ch.AddDependency(dependency)
+	path, err := chartutil.Save(ch, suite.T().TempDir())
+	suite.Nil(err)
+	content, err := os.ReadFile(path)
+	suite.Nil(err)
+	return content
+}
+
+func (suite *DiffTestSuite) TestDiffChartPackages() {
+	from := suite.packageChart("1.0.0", "image:\n  tag: \"1.0\"\n  pullPolicy: Always\nreplicas: 1\nlegacy: true\n", map[string]string{
+		"templates/deployment.yaml": "kind: Deployment\nreplicas: {{ .Values.replicas }}\n",
+		"templates/legacy.yaml":     "kind: ConfigMap\n",
+		"templates/service.yaml":    "kind: Service\n",
+	})
+	to := suite.packageChart("1.1.0", "image:\n  tag: \"1.1\"\n  pullPolicy: Always\nreplicas: 1\ningress:\n  enabled: false\n", map[string]string{
+		"templates/deployment.yaml": "kind: Deployment\nreplicas: {{ .Values.replicas }}\nimage: {{ .Values.image.tag }}\n",
+		"templates/ingress.yaml":    "kind: Ingress",
+		"templates/service.yaml":    "kind: Service\n",
+	})
+
+	diff, err := DiffChartPackages(from, to)
+	suite.Nil(err, "no error comparing chart packages")
+	var paths []string
+	for _, file := range diff.Files {
+		paths = append(paths, fmt.Sprintf("%s %s", file.Status, file.Path))
+	}
+	suite.Equal([]string{
+		"modified Chart.yaml",
+		"modified templates/deployment.yaml",
+		"added templates/ingress.yaml",
+		"removed templates/legacy.yaml",
+		"modified values.yaml",
+	}, paths, "files changed, without the unchanged ones and the ones of subcharts")
+	suite.Equal("@@ -1,2 +1,3 @@\n kind: Deployment\n replicas: {{ .Values.replicas }}\n+image: {{ .Values.image.tag }}\n", diff.Files[1].Diff)
+	suite.Equal("@@ -0,0 +1 @@\n+kind: Ingress\n\\ No newline at end of file\n", diff.Files[2].Diff)
+	suite.Equal("@@ -1 +0,0 @@\n-kind: ConfigMap\n", diff.Files[3].Diff)
+
+	suite.Equal([]*ValueDiff{
+		{Path: "image.tag", Status: ChartDiffModified, From: "1.0", To: "1.1"},
+		{Path: "ingress.enabled", Status: ChartDiffAdded, To: false},
+		{Path: "legacy", Status: ChartDiffRemoved, From: true},
+	}, diff.Values, "values changed by path")
+
+	unified := diff.Unified()
+	suite.Contains(unified, "--- a/templates/deployment.yaml\n+++ b/templates/deployment.yaml\n@@ -1,2 +1,3 @@\n")
+	suite.Contains(unified, "--- /dev/null\n+++ b/templates/ingress.yaml\n")
+	suite.Contains(unified, "--- a/templates/legacy.yaml\n+++ /dev/null\n")
+
+	diff, err = DiffChartPackages(from, from)
+	suite.Nil(err)
+	suite.Empty(diff.Files, "no file changed")
+	suite.Empty(diff.Values, "no value changed")
+
+	_, err = DiffChartPackages(from, []byte("not a chart"))
+	suite.Equal(ErrorInvalidChartPackage, err)
+}
+
+func (suite *DiffTestSuite) TestUnifiedHunks() {
+	var from, to []string
+	for i := 1; i <= 20; i++ {
+		from = append(from, fmt.Sprintf("%d\n", i))
+		switch i {
+		case 2:
+			to = append(to, "two\n")
+		case 10:
+		case 18:
+			to = append(to, "18\n", "18.5\n")
+		default:
+			to = append(to, fmt.Sprintf("%d\n", i))
+		}
+	}
+	suite.Equal(strings.Join([]string{
+		"@@ -1,5 +1,5 @@", " 1", "-2", "+two", " 3", " 4", " 5",
+		"@@ -7,7 +7,6 @@", " 7", " 8", " 9", "-10", " 11", " 12", " 13",
+		"@@ -16,5 +15,6 @@", " 16", " 17", " 18", "+18.5", " 19", " 20",
+	}, "\n")+"\n", unifiedHunks(from, to), "distant changes in their own hunks")
+
+	to = append(append([]string{}, from[:3]...), from[4:]...)
+	to[5] = "changed\n"
+	suite.Equal(strings.Join([]string{
+		"@@ -1,10 +1,9 @@", " 1", " 2", " 3", "-4", " 5", " 6", "-7", "+changed", " 8", " 9", " 10",
+	}, "\n")+"\n", unifiedHunks(from, to), "close changes in one hunk")
+
+	edits := diffLines(from, nil)
+	suite.Len(edits, 20, "all lines removed")
+}
+
+func TestDiffTestSuite(t *testing.T) {
+	suite.Run(t, new(DiffTestSuite))
+}
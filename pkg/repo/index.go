@@ -17,7 +17,12 @@ limitations under the License.
 package repo
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,6 +35,9 @@ var (
 	// IndexFileContentType is the http content-type header for index.yaml
 	IndexFileContentType = "application/x-yaml"
 	StatefileFilename    = "index-cache.yaml"
+
+	// JSONIndexFileContentType is the http content-type header for an index generated in JSON format
+	JSONIndexFileContentType = "application/json"
 )
 
 type (
@@ -51,9 +59,26 @@ type (
 		RepoName   string `json:"b"`
 		Raw        []byte `json:"c"`
 		ChartURL   string `json:"d"`
+		// Digest is the digest of the content of the index, regardless of when it was generated
+		Digest     string `json:"e"`
 		IndexLock  sync.RWMutex
 		OutputJSON bool
 	}
+
+	// filteredIndexFile is an index file with chart versions limited to some fields
+	filteredIndexFile struct {
+		APIVersion  string                                  `json:"apiVersion"`
+		Generated   time.Time                               `json:"generated"`
+		Entries     map[string][]map[string]json.RawMessage `json:"entries"`
+		PublicKeys  []string                                `json:"publicKeys,omitempty"`
+		Annotations map[string]string                       `json:"annotations,omitempty"`
+		ServerInfo  *ServerInfo                             `json:"serverInfo"`
+	}
+)
+
+var (
+	chartVersionFields     []string
+	chartVersionFieldsOnce sync.Once
 )
 
 // NewIndex creates a new instance of Index
@@ -62,17 +87,26 @@ func NewIndex(chartURL string, repo string, serverInfo *ServerInfo, outputJSON b
 		IndexFile:  &helm_repo.IndexFile{},
 		ServerInfo: serverInfo,
 	}
-	index := Index{indexFile, repo, []byte{}, chartURL, sync.RWMutex{}, outputJSON}
+	index := Index{indexFile, repo, []byte{}, chartURL, "", sync.RWMutex{}, outputJSON}
 	index.Entries = map[string]helm_repo.ChartVersions{}
 	index.APIVersion = helm_repo.APIVersionV1
 	index.Regenerate()
 	return &index
 }
 
-// Regenerate sorts entries in index file and sets current time for generated key
+// Regenerate sorts entries in index file and sets current time for generated key, unless the content
+// of the index is unchanged, so that clients polling it are not served a new index for nothing
 func (index *Index) Regenerate() (err error) {
+	index.IndexLock.Lock()
 	index.SortEntries()
-	index.Generated = time.Now().Round(time.Second)
+	index.IndexLock.Unlock()
+	digest, err := index.contentDigest()
+	if err != nil {
+		return err
+	}
+	if digest != index.Digest || index.Generated.IsZero() {
+		index.Generated = time.Now().Round(time.Second)
+	}
 
 	var raw []byte
 	if index.OutputJSON {
@@ -86,10 +120,81 @@ func (index *Index) Regenerate() (err error) {
 	index.IndexLock.Lock()
 	defer index.IndexLock.Unlock()
 	index.Raw = raw
+	index.Digest = digest
 	index.updateMetrics()
 	return nil
 }
 
+// contentDigest returns the sha256 digest of the index, leaving out the time it was generated
+func (index *Index) contentDigest() (string, error) {
+	content := *index.IndexFile.IndexFile
+	content.Generated = time.Time{}
+	hash := sha256.New()
+	if err := json.NewEncoder(hash).Encode(&IndexFile{IndexFile: &content, ServerInfo: index.ServerInfo}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Truncated serializes the index with as many charts (all their versions, in name order) as fit in maxSize bytes
+func (indexFile *IndexFile) Truncated(maxSize int, outputJSON bool) ([]byte, error) {
+	return indexFile.truncated(maxSize, func(truncated *IndexFile) ([]byte, error) {
+		if outputJSON {
+			return json.Marshal(truncated)
+		}
+		return yaml.Marshal(truncated)
+	})
+}
+
+// TruncatedJSON serializes the index as JSON, its chart versions limited to fields if any, with as
+// many charts as fit in maxSize bytes
+func (indexFile *IndexFile) TruncatedJSON(maxSize int, fields []string) ([]byte, error) {
+	return indexFile.truncated(maxSize, func(truncated *IndexFile) ([]byte, error) {
+		return truncated.JSON(fields)
+	})
+}
+
+func (indexFile *IndexFile) truncated(maxSize int, marshalIndexFile func(*IndexFile) ([]byte, error)) ([]byte, error) {
+	names := make([]string, 0, len(indexFile.Entries))
+	for name := range indexFile.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	marshal := func(n int) ([]byte, error) {
+		truncated := &IndexFile{
+			IndexFile: &helm_repo.IndexFile{
+				APIVersion: indexFile.APIVersion,
+				Generated:  indexFile.Generated,
+				Entries:    map[string]helm_repo.ChartVersions{},
+			},
+			ServerInfo: indexFile.ServerInfo,
+		}
+		for _, name := range names[:n] {
+			truncated.Entries[name] = indexFile.Entries[name]
+		}
+		return marshalIndexFile(truncated)
+	}
+
+	// binary search the number of charts kept
+	var fits []byte
+	low, high := 0, len(names)
+	for low <= high {
+		n := (low + high) / 2
+		raw, err := marshal(n)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) <= maxSize || n == 0 {
+			fits = raw
+			low = n + 1
+		} else {
+			high = n - 1
+		}
+	}
+	return fits, nil
+}
+
 // RemoveEntry removes a chart version from index
 func (index *Index) RemoveEntry(chartVersion *helm_repo.ChartVersion) {
 	if entries, ok := index.Entries[chartVersion.Name]; ok {
@@ -166,3 +271,81 @@ func (index *Index) updateMetrics() {
 	chartTotalGaugeVec.WithLabelValues(index.RepoName).Set(float64(len(index.Entries)))
 	chartVersionTotalGaugeVec.WithLabelValues(index.RepoName).Set(float64(nChartVersions))
 }
+
+// JSON serializes the index as JSON, its chart versions limited to fields if any, as named in JSON
+// (see ChartVersionFields)
+func (indexFile *IndexFile) JSON(fields []string) ([]byte, error) {
+	if len(fields) == 0 {
+		return json.Marshal(indexFile)
+	}
+	keep := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		keep[field] = true
+	}
+	filtered := &filteredIndexFile{
+		APIVersion:  indexFile.APIVersion,
+		Generated:   indexFile.Generated,
+		Entries:     make(map[string][]map[string]json.RawMessage, len(indexFile.Entries)),
+		PublicKeys:  indexFile.PublicKeys,
+		Annotations: indexFile.Annotations,
+		ServerInfo:  indexFile.ServerInfo,
+	}
+	for name, chartVersions := range indexFile.Entries {
+		versions := make([]map[string]json.RawMessage, 0, len(chartVersions))
+		for _, chartVersion := range chartVersions {
+			raw, err := json.Marshal(chartVersion)
+			if err != nil {
+				return nil, err
+			}
+			var all map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &all); err != nil {
+				return nil, err
+			}
+			version := make(map[string]json.RawMessage, len(keep))
+			for field, value := range all {
+				if keep[field] {
+					version[field] = value
+				}
+			}
+			versions = append(versions, version)
+		}
+		filtered.Entries[name] = versions
+	}
+	return json.Marshal(filtered)
+}
+
+// ChartVersionFields returns the names of the fields of the chart versions of an index, in JSON
+func ChartVersionFields() []string {
+	chartVersionFieldsOnce.Do(func() {
+		chartVersionFields = jsonFieldNames(reflect.TypeOf(helm_repo.ChartVersion{}))
+		sort.Strings(chartVersionFields)
+	})
+	return chartVersionFields
+}
+
+// jsonFieldNames returns the JSON names of the fields of a struct type, and of its embedded structs
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				names = append(names, jsonFieldNames(embedded)...)
+				continue
+			}
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
@@ -70,6 +70,25 @@ func (suite *IndexTestSuite) TestRegenerate() {
 	suite.Nil(err)
 }
 
+func (suite *IndexTestSuite) TestRegenerateUnchanged() {
+	index := NewIndex("", "", &ServerInfo{}, false)
+	index.AddEntry(getChartVersion("a", 0, time.Now()))
+	suite.Nil(index.Regenerate())
+	digest := index.Digest
+	suite.Len(digest, 64, "sha256 digest of the index")
+
+	generated := time.Now().Add(-time.Hour).Round(time.Second)
+	index.Generated = generated
+	suite.Nil(index.Regenerate())
+	suite.Equal(digest, index.Digest, "same digest of an unchanged index")
+	suite.Equal(generated, index.Generated, "generated time kept for an unchanged index")
+
+	index.AddEntry(getChartVersion("a", 1, time.Now()))
+	suite.Nil(index.Regenerate())
+	suite.NotEqual(digest, index.Digest, "new digest of a changed index")
+	suite.True(index.Generated.After(generated), "generated time updated for a changed index")
+}
+
 func (suite *IndexTestSuite) TestUpdate() {
 	now := time.Now()
 	for _, name := range []string{"a", "b", "c"} {
@@ -145,3 +164,60 @@ func (suite *IndexTestSuite) TestJSONIndex() {
 func TestIndexTestSuite(t *testing.T) {
 	suite.Run(t, new(IndexTestSuite))
 }
+
+func (suite *IndexTestSuite) TestTruncated() {
+	index := NewIndex("", "", &ServerInfo{}, false)
+	for _, name := range []string{"a", "b", "c"} {
+		for i := 0; i < 3; i++ {
+			index.AddEntry(getChartVersion(name, i, time.Now()))
+		}
+	}
+	suite.NoError(index.Regenerate())
+
+	raw, err := index.IndexFile.Truncated(len(index.Raw), false)
+	suite.NoError(err)
+	suite.Equal(index.Raw, raw, "index fitting in max size not truncated")
+
+	raw, err = index.IndexFile.Truncated(len(index.Raw)-1, false)
+	suite.NoError(err)
+	suite.LessOrEqual(len(raw), len(index.Raw)-1, "truncated index fits in max size")
+	truncated := &IndexFile{}
+	suite.NoError(yaml.Unmarshal(raw, truncated))
+	suite.Len(truncated.Entries, 2, "last chart dropped")
+	suite.Len(truncated.Entries["b"], 3, "all versions of kept charts")
+	suite.NotContains(truncated.Entries, "c", "charts dropped in name order")
+
+	raw, err = index.IndexFile.Truncated(1, true)
+	suite.NoError(err)
+	suite.True(json.Valid(raw), "truncated JSON index")
+	truncated = &IndexFile{}
+	suite.NoError(json.Unmarshal(raw, truncated))
+	suite.Empty(truncated.Entries, "no chart fits")
+}
+
+func (suite *IndexTestSuite) TestJSONFields() {
+	index := NewIndex("", "", &ServerInfo{}, false)
+	index.AddEntry(getChartVersion("a", 0, time.Now()))
+	suite.NoError(index.Regenerate())
+
+	raw, err := index.IndexFile.JSON(nil)
+	suite.NoError(err)
+	all := &IndexFile{}
+	suite.NoError(json.Unmarshal(raw, all))
+	suite.Equal(index.IndexFile.Entries["a"][0].Version, all.Entries["a"][0].Version, "all fields without fields")
+
+	raw, err = index.IndexFile.JSON([]string{"name", "version"})
+	suite.NoError(err)
+	filtered := struct {
+		APIVersion string                         `json:"apiVersion"`
+		Entries    map[string][]map[string]string `json:"entries"`
+	}{}
+	suite.NoError(json.Unmarshal(raw, &filtered))
+	suite.Equal("v1", filtered.APIVersion)
+	suite.Equal([]map[string]string{{"name": "a", "version": "1.0.0"}}, filtered.Entries["a"], "only the fields requested")
+
+	fields := ChartVersionFields()
+	suite.Contains(fields, "urls")
+	suite.Contains(fields, "apiVersion", "fields of the embedded chart metadata")
+	suite.NotContains(fields, "Metadata")
+}
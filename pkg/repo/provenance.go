@@ -20,6 +20,9 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"os"
+	pathutil "path"
+	"sort"
 	"strings"
 
 	"regexp"
@@ -64,3 +67,60 @@ func provenanceDigestFromContent(content []byte) (string, error) {
 	digest, err := provenance.Digest(bytes.NewBuffer(content))
 	return digest, err
 }
+
+// ProvenanceSigner is the key which signed a provenance file
+type ProvenanceSigner struct {
+	Identities  []string `json:"identities"`
+	Fingerprint string   `json:"fingerprint"`
+	KeyID       string   `json:"key_id"`
+}
+
+// VerifyProvenance verifies a chart package against its provenance file using the public keys
+// of a keyring file, and returns the identities of the key which signed it
+func VerifyProvenance(keyring string, filename string, chartContent []byte, provContent []byte) ([]string, error) {
+	signer, err := VerifyProvenanceSigner(keyring, filename, chartContent, provContent)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Identities, nil
+}
+
+// VerifyProvenanceSigner verifies a chart package against its provenance file as VerifyProvenance,
+// the signature and the digest of the package it records, and returns the key which signed it
+func VerifyProvenanceSigner(keyring string, filename string, chartContent []byte, provContent []byte) (*ProvenanceSigner, error) {
+	signatory, err := provenance.NewFromKeyring(keyring, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// helm only verifies files, the chart keeps its name as the provenance file references it
+	dir, err := os.MkdirTemp("", "chartmuseum-verify")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	chartPath := pathutil.Join(dir, pathutil.Base(filename))
+	provPath := chartPath + ".prov"
+	if err := os.WriteFile(chartPath, chartContent, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(provPath, provContent, 0600); err != nil {
+		return nil, err
+	}
+
+	verification, err := signatory.Verify(chartPath, provPath)
+	if err != nil {
+		return nil, err
+	}
+	key := verification.SignedBy.PrimaryKey
+	signer := &ProvenanceSigner{
+		Identities:  []string{},
+		Fingerprint: fmt.Sprintf("%X", key.Fingerprint),
+		KeyID:       key.KeyIdString(),
+	}
+	for name := range verification.SignedBy.Identities {
+		signer.Identities = append(signer.Identities, name)
+	}
+	sort.Strings(signer.Identities)
+	return signer, nil
+}
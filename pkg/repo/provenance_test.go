@@ -17,6 +17,7 @@ limitations under the License.
 package repo
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -94,6 +95,33 @@ name: mychart`)
 	suite.Equal(ErrorInvalidProvenanceFile, err, "ErrorInvalidProvenanceFile from bad content, no version")
 }
 
+func (suite *ProvenanceTestSuite) TestVerifyProvenance() {
+	keyring := "../../testdata/pgp/helm-test-key.pub"
+	chartContent, err := os.ReadFile("../../testdata/charts/mychart/mychart-0.1.0.tgz")
+	suite.Nil(err, "no error reading test chart")
+	provContent, err := os.ReadFile("../../testdata/charts/mychart/mychart-0.1.0.tgz.prov")
+	suite.Nil(err, "no error reading test provenance file")
+
+	identities, err := VerifyProvenance(keyring, "mychart-0.1.0.tgz", chartContent, provContent)
+	suite.Nil(err, "no error verifying signed chart")
+	suite.NotEmpty(identities, "signer identities returned")
+
+	signer, err := VerifyProvenanceSigner(keyring, "mychart-0.1.0.tgz", chartContent, provContent)
+	suite.Nil(err, "no error verifying signed chart")
+	suite.Equal(identities, signer.Identities, "same signer identities")
+	suite.Len(signer.Fingerprint, 40, "fingerprint of the signing key")
+	suite.Equal(signer.Fingerprint[24:], signer.KeyID, "key ID, the end of its fingerprint")
+
+	_, err = VerifyProvenance(keyring, "mychart-0.1.0.tgz", append(chartContent, 0), provContent)
+	suite.NotNil(err, "error verifying tampered chart")
+
+	_, err = VerifyProvenance(keyring, "mychart-0.2.0.tgz", chartContent, provContent)
+	suite.NotNil(err, "error verifying chart under another filename")
+
+	_, err = VerifyProvenance("../../testdata/pgp/missing.pub", "mychart-0.1.0.tgz", chartContent, provContent)
+	suite.NotNil(err, "error with missing keyring")
+}
+
 func TestProvenanceTestSuite(t *testing.T) {
 	suite.Run(t, new(ProvenanceTestSuite))
 }
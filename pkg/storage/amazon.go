@@ -0,0 +1,94 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	pathutil "path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	cm_storage "github.com/chartmuseum/storage"
+)
+
+const (
+	// SSEAES256 encrypts objects with keys managed by S3
+	SSEAES256 = s3.ServerSideEncryptionAes256
+	// SSEKMS encrypts objects with a KMS key
+	SSEKMS = s3.ServerSideEncryptionAwsKms
+	// SSEKMSDSSE encrypts objects twice with a KMS key
+	SSEKMSDSSE = s3.ServerSideEncryptionAwsKmsDsse
+)
+
+func init() {
+	// s3://<bucket>/<prefix>?region=<region>&endpoint=<url>&sse=<encryption>&kmskeyid=<key>,
+	// credentials from the environment
+	Register("s3", func(u *url.URL) (Backend, error) {
+		if u.Host == "" {
+			return nil, errors.New("missing bucket in s3 storage URL")
+		}
+		query := u.Query()
+		backend := cm_storage.NewAmazonS3Backend(u.Host, u.Path, query.Get("region"), query.Get("endpoint"), "")
+		return NewAmazonS3SSEBackend(backend, query.Get("sse"), query.Get("kmskeyid"))
+	})
+}
+
+// AmazonS3KMSBackend is an Amazon S3 backend encrypting every uploaded object with a
+// given KMS key, rather than the AWS managed key of the bucket
+type AmazonS3KMSBackend struct {
+	*cm_storage.AmazonS3Backend
+	SSEKMSKeyID string
+}
+
+// NewAmazonS3SSEBackend sets the server-side encryption of all uploads of an Amazon S3
+// backend. sse is AES256, aws:kms or aws:kms:dsse, and defaults to aws:kms with a KMS key
+// id, which may be a key id, key ARN or alias ARN
+func NewAmazonS3SSEBackend(backend *cm_storage.AmazonS3Backend, sse string, kmsKeyID string) (Backend, error) {
+	if sse == "" && kmsKeyID != "" {
+		sse = SSEKMS
+	}
+	switch sse {
+	case "", SSEAES256:
+		if kmsKeyID != "" {
+			return nil, fmt.Errorf("a KMS key id requires %s server-side encryption", SSEKMS)
+		}
+	case SSEKMS, SSEKMSDSSE:
+	default:
+		return nil, fmt.Errorf("unsupported server-side encryption: %s", sse)
+	}
+	backend.SSE = sse
+	if kmsKeyID == "" {
+		return backend, nil
+	}
+	return &AmazonS3KMSBackend{AmazonS3Backend: backend, SSEKMSKeyID: kmsKeyID}, nil
+}
+
+// PutObject uploads an object to Amazon S3 bucket, at prefix, encrypted with the KMS key
+func (b *AmazonS3KMSBackend) PutObject(path string, content []byte) error {
+	_, err := b.Uploader.Upload(&s3manager.UploadInput{
+		Bucket:               aws.String(b.Bucket),
+		Key:                  aws.String(pathutil.Join(b.Prefix, path)),
+		Body:                 bytes.NewReader(content),
+		ServerSideEncryption: aws.String(b.SSE),
+		SSEKMSKeyId:          aws.String(b.SSEKMSKeyID),
+	})
+	return err
+}
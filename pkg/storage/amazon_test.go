@@ -0,0 +1,98 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/stretchr/testify/suite"
+)
+
+type AmazonTestSuite struct {
+	suite.Suite
+	Server  *httptest.Server
+	Headers http.Header
+	Path    string
+}
+
+// SetupTest starts a fake S3 endpoint recording the last PUT request
+func (suite *AmazonTestSuite) SetupTest() {
+	suite.T().Setenv("AWS_ACCESS_KEY_ID", "x")
+	suite.T().Setenv("AWS_SECRET_ACCESS_KEY", "x")
+	suite.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			suite.Headers = r.Header.Clone()
+			suite.Path = r.URL.Path
+		}
+		w.Header().Set("ETag", `"x"`)
+	}))
+}
+
+func (suite *AmazonTestSuite) TearDownTest() {
+	suite.Server.Close()
+}
+
+func (suite *AmazonTestSuite) newBackend() *cm_storage.AmazonS3Backend {
+	return cm_storage.NewAmazonS3Backend("charts", "prefix", "us-east-1", suite.Server.URL, "")
+}
+
+func (suite *AmazonTestSuite) TestKMSKey() {
+	backend, err := NewAmazonS3SSEBackend(suite.newBackend(), "", "alias/charts")
+	suite.Nil(err, "no error with KMS key")
+	suite.IsType(&AmazonS3KMSBackend{}, backend)
+
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("x")), "no error putting object")
+	suite.Equal("/charts/prefix/mychart-0.1.0.tgz", suite.Path)
+	suite.Equal("aws:kms", suite.Headers.Get("X-Amz-Server-Side-Encryption"), "aws:kms encryption")
+	suite.Equal("alias/charts", suite.Headers.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"), "KMS key set")
+}
+
+func (suite *AmazonTestSuite) TestAlgorithm() {
+	backend, err := NewAmazonS3SSEBackend(suite.newBackend(), "AES256", "")
+	suite.Nil(err, "no error with AES256")
+	suite.IsType(&cm_storage.AmazonS3Backend{}, backend, "no wrapper without KMS key")
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("x")), "no error putting object")
+	suite.Equal("AES256", suite.Headers.Get("X-Amz-Server-Side-Encryption"), "AES256 encryption")
+
+	backend, err = NewAmazonS3SSEBackend(suite.newBackend(), "", "")
+	suite.Nil(err, "no error without encryption")
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("x")), "no error putting object")
+	suite.Empty(suite.Headers.Get("X-Amz-Server-Side-Encryption"), "no encryption header")
+
+	_, err = NewAmazonS3SSEBackend(suite.newBackend(), "AES256", "alias/charts")
+	suite.EqualError(err, "a KMS key id requires aws:kms server-side encryption")
+	_, err = NewAmazonS3SSEBackend(suite.newBackend(), "aes", "")
+	suite.EqualError(err, "unsupported server-side encryption: aes")
+}
+
+func (suite *AmazonTestSuite) TestOpen() {
+	backend, err := Open("s3://charts/prefix?region=us-east-1&endpoint=" + suite.Server.URL + "&kmskeyid=alias/charts")
+	suite.Nil(err, "no error opening s3 URL")
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("x")), "no error putting object")
+	suite.Equal("/charts/prefix/mychart-0.1.0.tgz", suite.Path, "bucket and prefix of URL")
+	suite.Equal("alias/charts", suite.Headers.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"), "KMS key of URL")
+
+	_, err = Open("s3:///prefix")
+	suite.EqualError(err, "missing bucket in s3 storage URL")
+}
+
+func TestAmazonTestSuite(t *testing.T) {
+	suite.Run(t, new(AmazonTestSuite))
+}
@@ -0,0 +1,502 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	pathutil "path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// B2DefaultAuthURL is the endpoint used to authorize an account with the B2 native API
+	B2DefaultAuthURL = "https://api.backblazeb2.com"
+
+	b2MaxFileCount = 1000
+)
+
+type (
+	// B2Backend is a storage backend for Backblaze B2, using its native API
+	B2Backend struct {
+		BucketID string
+		Prefix   string
+		// PartSize is the size of the parts of large files, defaults to the size
+		// recommended by B2. Files larger than a part are uploaded in parts
+		PartSize int64
+
+		keyID          string
+		applicationKey string
+		authURL        string
+		client         *http.Client
+		authLock       sync.Mutex
+		auth           *b2Authorization
+		bucketLock     sync.Mutex
+		bucketName     string
+	}
+
+	// B2Options are optional settings of a B2Backend
+	B2Options struct {
+		AuthURL  string
+		PartSize int64
+		Client   *http.Client
+	}
+
+	b2Authorization struct {
+		AccountID           string `json:"accountId"`
+		AuthorizationToken  string `json:"authorizationToken"`
+		APIURL              string `json:"apiUrl"`
+		DownloadURL         string `json:"downloadUrl"`
+		RecommendedPartSize int64  `json:"recommendedPartSize"`
+		Allowed             struct {
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"allowed"`
+	}
+
+	b2File struct {
+		FileID          string `json:"fileId"`
+		FileName        string `json:"fileName"`
+		Action          string `json:"action"`
+		UploadTimestamp int64  `json:"uploadTimestamp"`
+	}
+
+	b2UploadURL struct {
+		UploadURL          string `json:"uploadUrl"`
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+
+	// b2Error is the error body returned by the B2 API
+	b2Error struct {
+		Status  int    `json:"status"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+func init() {
+	// b2://<bucket id>/<prefix>, with the application key in the URL user info
+	// or in B2_APPLICATION_KEY_ID and B2_APPLICATION_KEY
+	Register("b2", func(u *url.URL) (Backend, error) {
+		if u.Host == "" {
+			return nil, fmt.Errorf("missing bucket id in b2 storage URL")
+		}
+		keyID, applicationKey := os.Getenv("B2_APPLICATION_KEY_ID"), os.Getenv("B2_APPLICATION_KEY")
+		if u.User != nil {
+			keyID = u.User.Username()
+			applicationKey, _ = u.User.Password()
+		}
+		if keyID == "" || applicationKey == "" {
+			return nil, fmt.Errorf("missing b2 application key")
+		}
+		return NewB2Backend(keyID, applicationKey, u.Host, u.Path), nil
+	})
+}
+
+func (e *b2Error) Error() string {
+	return fmt.Sprintf("b2: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Is makes missing files match fs.ErrNotExist
+func (e *b2Error) Is(target error) bool {
+	return target == fs.ErrNotExist && e.Status == http.StatusNotFound
+}
+
+// NewB2Backend creates a new instance of B2Backend. The account is authorized on first use
+func NewB2Backend(keyID string, applicationKey string, bucketID string, prefix string) *B2Backend {
+	return NewB2BackendWithOptions(keyID, applicationKey, bucketID, prefix, nil)
+}
+
+// NewB2BackendWithOptions creates a new instance of B2Backend with options
+func NewB2BackendWithOptions(keyID string, applicationKey string, bucketID string, prefix string, options *B2Options) *B2Backend {
+	b := &B2Backend{
+		BucketID:       bucketID,
+		Prefix:         strings.Trim(prefix, "/"),
+		keyID:          keyID,
+		applicationKey: applicationKey,
+		authURL:        B2DefaultAuthURL,
+		client:         http.DefaultClient,
+	}
+	if options != nil {
+		if options.AuthURL != "" {
+			b.authURL = options.AuthURL
+		}
+		if options.Client != nil {
+			b.client = options.Client
+		}
+		b.PartSize = options.PartSize
+	}
+	return b
+}
+
+// ListObjects lists all objects in a B2 bucket, at prefix
+func (b *B2Backend) ListObjects(prefix string) ([]Object, error) {
+	var objects []Object
+	prefix = pathutil.Join(b.Prefix, prefix)
+	listPrefix := ""
+	if prefix != "" && prefix != "." {
+		listPrefix = prefix + "/"
+	}
+	req := map[string]interface{}{
+		"bucketId":     b.BucketID,
+		"prefix":       listPrefix,
+		"delimiter":    "/",
+		"maxFileCount": b2MaxFileCount,
+	}
+	for {
+		var res struct {
+			Files        []b2File `json:"files"`
+			NextFileName *string  `json:"nextFileName"`
+		}
+		if err := b.call("b2_list_file_names", req, &res); err != nil {
+			return objects, err
+		}
+		for _, file := range res.Files {
+			// folders are listed as such with the delimiter
+			if file.Action != "upload" {
+				continue
+			}
+			path := strings.TrimPrefix(file.FileName, listPrefix)
+			if path == "" || strings.Contains(path, "/") {
+				continue
+			}
+			objects = append(objects, Object{
+				Path:         path,
+				Content:      []byte{},
+				LastModified: time.UnixMilli(file.UploadTimestamp),
+			})
+		}
+		if res.NextFileName == nil {
+			break
+		}
+		req["startFileName"] = *res.NextFileName
+	}
+	return objects, nil
+}
+
+// GetObject retrieves an object from a B2 bucket, at path
+func (b *B2Backend) GetObject(path string) (Object, error) {
+	object := Object{Path: path}
+	auth, err := b.authorize(false)
+	if err != nil {
+		return object, err
+	}
+	bucketName, err := b.getBucketName()
+	if err != nil {
+		return object, err
+	}
+	fileURL := fmt.Sprintf("%s/file/%s/%s", auth.DownloadURL, bucketName, b2EscapeFileName(pathutil.Join(b.Prefix, path)))
+	res, err := b.do(func(auth *b2Authorization) (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", auth.AuthorizationToken)
+		return req, nil
+	})
+	if err != nil {
+		return object, err
+	}
+	defer res.Body.Close()
+	object.Content, err = io.ReadAll(res.Body)
+	if err != nil {
+		return object, err
+	}
+	if timestamp, err := strconv.ParseInt(res.Header.Get("X-Bz-Upload-Timestamp"), 10, 64); err == nil {
+		object.LastModified = time.UnixMilli(timestamp)
+	}
+	return object, nil
+}
+
+// PutObject uploads an object to a B2 bucket, at path. Content larger than a part
+// is uploaded as a large file
+func (b *B2Backend) PutObject(path string, content []byte) error {
+	auth, err := b.authorize(false)
+	if err != nil {
+		return err
+	}
+	fileName := pathutil.Join(b.Prefix, path)
+	partSize := b.PartSize
+	if partSize <= 0 {
+		partSize = auth.RecommendedPartSize
+	}
+	if partSize > 0 && int64(len(content)) > partSize {
+		return b.putLargeFile(fileName, content, partSize)
+	}
+
+	var uploadURL b2UploadURL
+	if err := b.call("b2_get_upload_url", map[string]string{"bucketId": b.BucketID}, &uploadURL); err != nil {
+		return err
+	}
+	return b.upload(uploadURL, content, map[string]string{
+		"X-Bz-File-Name": b2EscapeFileName(fileName),
+		"Content-Type":   "b2/x-auto",
+	})
+}
+
+func (b *B2Backend) putLargeFile(fileName string, content []byte, partSize int64) error {
+	var file b2File
+	err := b.call("b2_start_large_file", map[string]string{
+		"bucketId":    b.BucketID,
+		"fileName":    fileName,
+		"contentType": "b2/x-auto",
+	}, &file)
+	if err != nil {
+		return err
+	}
+	var uploadURL b2UploadURL
+	if err := b.call("b2_get_upload_part_url", map[string]string{"fileId": file.FileID}, &uploadURL); err != nil {
+		return b.cancelLargeFile(file.FileID, err)
+	}
+	var sha1s []string
+	for start := int64(0); start < int64(len(content)); start += partSize {
+		end := start + partSize
+		if end > int64(len(content)) {
+			end = int64(len(content))
+		}
+		part := content[start:end]
+		sum := sha1.Sum(part)
+		sha1s = append(sha1s, hex.EncodeToString(sum[:]))
+		err := b.upload(uploadURL, part, map[string]string{
+			"X-Bz-Part-Number": strconv.Itoa(len(sha1s)),
+		})
+		if err != nil {
+			return b.cancelLargeFile(file.FileID, err)
+		}
+	}
+	err = b.call("b2_finish_large_file", map[string]interface{}{
+		"fileId":        file.FileID,
+		"partSha1Array": sha1s,
+	}, nil)
+	if err != nil {
+		return b.cancelLargeFile(file.FileID, err)
+	}
+	return nil
+}
+
+// cancelLargeFile discards the parts of a failed large file upload, returning the upload error
+func (b *B2Backend) cancelLargeFile(fileID string, err error) error {
+	b.call("b2_cancel_large_file", map[string]string{"fileId": fileID}, nil)
+	return err
+}
+
+// DeleteObject removes every version of an object from a B2 bucket, at path
+func (b *B2Backend) DeleteObject(path string) error {
+	fileName := pathutil.Join(b.Prefix, path)
+	var res struct {
+		Files []b2File `json:"files"`
+	}
+	err := b.call("b2_list_file_versions", map[string]interface{}{
+		"bucketId":      b.BucketID,
+		"startFileName": fileName,
+		"prefix":        fileName,
+		"maxFileCount":  b2MaxFileCount,
+	}, &res)
+	if err != nil {
+		return err
+	}
+	deleted := false
+	for _, file := range res.Files {
+		if file.FileName != fileName {
+			continue
+		}
+		err := b.call("b2_delete_file_version", map[string]string{
+			"fileName": file.FileName,
+			"fileId":   file.FileID,
+		}, nil)
+		if err != nil {
+			return err
+		}
+		deleted = true
+	}
+	if !deleted {
+		return &b2Error{Status: http.StatusNotFound, Code: "not_found", Message: "file not present: " + fileName}
+	}
+	return nil
+}
+
+// authorize returns the authorization of the account, authorizing it again when
+// it is missing or has expired
+func (b *B2Backend) authorize(expired bool) (*b2Authorization, error) {
+	b.authLock.Lock()
+	defer b.authLock.Unlock()
+	if b.auth != nil && !expired {
+		return b.auth, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, b.authURL+"/b2api/v2/b2_authorize_account", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(b.keyID, b.applicationKey)
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, b2ErrorFromResponse(res)
+	}
+	auth := &b2Authorization{}
+	if err := json.NewDecoder(res.Body).Decode(auth); err != nil {
+		return nil, err
+	}
+	b.auth = auth
+	return auth, nil
+}
+
+// getBucketName resolves the name of the bucket, which downloads by name require
+func (b *B2Backend) getBucketName() (string, error) {
+	b.bucketLock.Lock()
+	defer b.bucketLock.Unlock()
+	if b.bucketName != "" {
+		return b.bucketName, nil
+	}
+	auth, err := b.authorize(false)
+	if err != nil {
+		return "", err
+	}
+	if auth.Allowed.BucketID == b.BucketID && auth.Allowed.BucketName != "" {
+		b.bucketName = auth.Allowed.BucketName
+		return b.bucketName, nil
+	}
+	var res struct {
+		Buckets []struct {
+			BucketName string `json:"bucketName"`
+		} `json:"buckets"`
+	}
+	err = b.call("b2_list_buckets", map[string]string{
+		"accountId": auth.AccountID,
+		"bucketId":  b.BucketID,
+	}, &res)
+	if err != nil {
+		return "", err
+	}
+	if len(res.Buckets) == 0 {
+		return "", fmt.Errorf("b2: bucket %s not found", b.BucketID)
+	}
+	b.bucketName = res.Buckets[0].BucketName
+	return b.bucketName, nil
+}
+
+// call invokes an operation of the B2 API, decoding its result into v when not nil
+func (b *B2Backend) call(operation string, body interface{}, v interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	res, err := b.do(func(auth *b2Authorization) (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, auth.APIURL+"/b2api/v2/"+operation, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", auth.AuthorizationToken)
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// do sends a request built with the account authorization, authorizing again and
+// retrying once when the authorization token has expired
+func (b *B2Backend) do(newRequest func(auth *b2Authorization) (*http.Request, error)) (*http.Response, error) {
+	auth, err := b.authorize(false)
+	if err != nil {
+		return nil, err
+	}
+	for retried := false; ; retried = true {
+		req, err := newRequest(auth)
+		if err != nil {
+			return nil, err
+		}
+		res, err := b.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode == http.StatusOK {
+			return res, nil
+		}
+		err = b2ErrorFromResponse(res)
+		res.Body.Close()
+		if b2Err, ok := err.(*b2Error); ok && b2Err.Code == "expired_auth_token" && !retried {
+			if auth, err = b.authorize(true); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return nil, err
+	}
+}
+
+// upload sends content to an upload URL, which carries its own authorization token
+func (b *B2Backend) upload(uploadURL b2UploadURL, content []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, uploadURL.UploadURL, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	sum := sha1.Sum(content)
+	req.Header.Set("Authorization", uploadURL.AuthorizationToken)
+	req.Header.Set("X-Bz-Content-Sha1", hex.EncodeToString(sum[:]))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req.ContentLength = int64(len(content))
+	res, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return b2ErrorFromResponse(res)
+	}
+	return nil
+}
+
+func b2ErrorFromResponse(res *http.Response) error {
+	b2Err := &b2Error{Status: res.StatusCode}
+	if err := json.NewDecoder(res.Body).Decode(b2Err); err != nil || b2Err.Code == "" {
+		b2Err.Code = http.StatusText(res.StatusCode)
+	}
+	b2Err.Status = res.StatusCode
+	return b2Err
+}
+
+// b2EscapeFileName percent-encodes a file name, keeping its slashes
+func b2EscapeFileName(fileName string) string {
+	segments := strings.Split(fileName, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,283 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeB2 is an in-memory implementation of the parts of the B2 native API used by B2Backend
+type fakeB2 struct {
+	sync.Mutex
+	server     *httptest.Server
+	files      map[string][]byte
+	timestamps map[string]int64
+	largeFiles map[string][][]byte
+	largeNames map[string]string
+	tokens     int
+	expireNext bool
+	partSize   int64
+}
+
+func newFakeB2() *fakeB2 {
+	f := &fakeB2{
+		files:      map[string][]byte{},
+		timestamps: map[string]int64{},
+		largeFiles: map[string][][]byte{},
+		largeNames: map[string]string{},
+		partSize:   100,
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+func (f *fakeB2) token() string {
+	return "token" + strconv.Itoa(f.tokens)
+}
+
+func (f *fakeB2) fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "code": code, "message": code})
+}
+
+func (f *fakeB2) handle(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if r.URL.Path == "/b2api/v2/b2_authorize_account" {
+		if user, pass, _ := r.BasicAuth(); user != "keyid" || pass != "key" {
+			f.fail(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		f.tokens++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"accountId":           "account",
+			"authorizationToken":  f.token(),
+			"apiUrl":              f.server.URL,
+			"downloadUrl":         f.server.URL,
+			"recommendedPartSize": f.partSize,
+		})
+		return
+	}
+	if r.Header.Get("Authorization") != f.token() && !strings.HasPrefix(r.URL.Path, "/upload") {
+		f.fail(w, http.StatusUnauthorized, "bad_auth_token")
+		return
+	}
+	if f.expireNext {
+		f.expireNext = false
+		f.fail(w, http.StatusUnauthorized, "expired_auth_token")
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/file/bucket/") {
+		name, _ := url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/file/bucket/"))
+		content, ok := f.files[name]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "not_found")
+			return
+		}
+		w.Header().Set("X-Bz-Upload-Timestamp", strconv.FormatInt(f.timestamps[name], 10))
+		w.Write(content)
+		return
+	}
+	if r.URL.Path == "/upload" {
+		name, _ := url.PathUnescape(r.Header.Get("X-Bz-File-Name"))
+		content, _ := io.ReadAll(r.Body)
+		f.files[name] = content
+		f.timestamps[name] = time.Now().UnixMilli()
+		w.Write([]byte("{}"))
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/upload-part/") {
+		fileID := strings.TrimPrefix(r.URL.Path, "/upload-part/")
+		part, _ := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+		content, _ := io.ReadAll(r.Body)
+		if part != len(f.largeFiles[fileID])+1 {
+			f.fail(w, http.StatusBadRequest, "bad_part_number")
+			return
+		}
+		f.largeFiles[fileID] = append(f.largeFiles[fileID], content)
+		w.Write([]byte("{}"))
+		return
+	}
+
+	var req map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&req)
+	str := func(key string) string {
+		value, _ := req[key].(string)
+		return value
+	}
+	var res interface{}
+	switch strings.TrimPrefix(r.URL.Path, "/b2api/v2/") {
+	case "b2_list_buckets":
+		res = map[string]interface{}{"buckets": []map[string]string{{"bucketName": "bucket"}}}
+	case "b2_get_upload_url":
+		res = map[string]string{"uploadUrl": f.server.URL + "/upload", "authorizationToken": "upload"}
+	case "b2_list_file_names", "b2_list_file_versions":
+		var names []string
+		for name := range f.files {
+			if strings.HasPrefix(name, str("prefix")) && name >= str("startFileName") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		var files []map[string]interface{}
+		folders := map[string]bool{}
+		for _, name := range names {
+			rest := strings.TrimPrefix(name, str("prefix"))
+			if i := strings.Index(rest, "/"); i >= 0 && str("delimiter") == "/" {
+				folder := str("prefix") + rest[:i+1]
+				if !folders[folder] {
+					folders[folder] = true
+					files = append(files, map[string]interface{}{"fileName": folder, "action": "folder"})
+				}
+				continue
+			}
+			files = append(files, map[string]interface{}{
+				"fileId":          "id-" + name,
+				"fileName":        name,
+				"action":          "upload",
+				"uploadTimestamp": f.timestamps[name],
+			})
+		}
+		// paginate one file at a time to exercise nextFileName
+		var next interface{}
+		if len(files) > 1 {
+			next = files[1]["fileName"]
+			files = files[:1]
+		}
+		res = map[string]interface{}{"files": files, "nextFileName": next}
+	case "b2_delete_file_version":
+		if str("fileId") != "id-"+str("fileName") {
+			f.fail(w, http.StatusBadRequest, "bad_request")
+			return
+		}
+		delete(f.files, str("fileName"))
+		res = map[string]string{}
+	case "b2_start_large_file":
+		fileID := fmt.Sprintf("large%d", len(f.largeNames))
+		f.largeNames[fileID] = str("fileName")
+		res = map[string]string{"fileId": fileID}
+	case "b2_get_upload_part_url":
+		res = map[string]string{"uploadUrl": f.server.URL + "/upload-part/" + str("fileId"), "authorizationToken": "upload"}
+	case "b2_finish_large_file":
+		fileID := str("fileId")
+		var content []byte
+		for _, part := range f.largeFiles[fileID] {
+			content = append(content, part...)
+		}
+		f.files[f.largeNames[fileID]] = content
+		f.timestamps[f.largeNames[fileID]] = time.Now().UnixMilli()
+		res = map[string]string{}
+	default:
+		f.fail(w, http.StatusBadRequest, "bad_request")
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+type B2TestSuite struct {
+	suite.Suite
+	B2      *fakeB2
+	Backend *B2Backend
+}
+
+func (suite *B2TestSuite) SetupTest() {
+	suite.B2 = newFakeB2()
+	suite.Backend = NewB2BackendWithOptions("keyid", "key", "bucketid", "/charts/", &B2Options{AuthURL: suite.B2.server.URL})
+}
+
+func (suite *B2TestSuite) TearDownTest() {
+	suite.B2.server.Close()
+}
+
+func (suite *B2TestSuite) TestObjects() {
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("content")), "no error putting object")
+	suite.Nil(suite.Backend.PutObject("myrepo/mychart-0.2.0.tgz", []byte("nested")), "no error putting nested object")
+	suite.Contains(suite.B2.files, "charts/mychart-0.1.0.tgz", "object stored under prefix")
+
+	object, err := suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "no error getting object")
+	suite.Equal("content", string(object.Content), "object content")
+	suite.False(object.LastModified.IsZero(), "object last modified")
+
+	_, err = suite.Backend.GetObject("missing.tgz")
+	suite.True(IsNotFound(err), "missing object not found")
+
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.1.tgz", []byte("content")), "no error putting object")
+	objects, err := suite.Backend.ListObjects("")
+	suite.Nil(err, "no error listing objects")
+	suite.Len(objects, 2, "nested objects are not listed")
+	suite.Equal("mychart-0.1.0.tgz", objects[0].Path, "object path relative to prefix")
+
+	objects, err = suite.Backend.ListObjects("myrepo")
+	suite.Nil(err, "no error listing repo objects")
+	suite.Len(objects, 1, "repo objects listed")
+	suite.Equal("mychart-0.2.0.tgz", objects[0].Path, "object path relative to repo")
+
+	suite.Nil(suite.Backend.DeleteObject("mychart-0.1.0.tgz"), "no error deleting object")
+	suite.NotContains(suite.B2.files, "charts/mychart-0.1.0.tgz", "object deleted")
+	suite.True(IsNotFound(suite.Backend.DeleteObject("mychart-0.1.0.tgz")), "deleting a missing object")
+}
+
+func (suite *B2TestSuite) TestLargeFile() {
+	content := []byte(strings.Repeat("0123456789", 25))
+	suite.Nil(suite.Backend.PutObject("big-0.1.0.tgz", content), "no error putting large object")
+	suite.Len(suite.B2.largeFiles["large0"], 3, "uploaded in parts")
+
+	object, err := suite.Backend.GetObject("big-0.1.0.tgz")
+	suite.Nil(err, "no error getting large object")
+	suite.Equal(content, object.Content, "large object content")
+}
+
+func (suite *B2TestSuite) TestExpiredToken() {
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("content")), "no error putting object")
+	suite.B2.expireNext = true
+	_, err := suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "no error getting object with an expired token")
+	suite.Equal(2, suite.B2.tokens, "account authorized again")
+
+	backend := NewB2BackendWithOptions("keyid", "wrong", "bucketid", "", &B2Options{AuthURL: suite.B2.server.URL})
+	_, err = backend.ListObjects("")
+	suite.NotNil(err, "error with a wrong key")
+	suite.False(IsNotFound(err), "a wrong key is not a missing object")
+}
+
+func (suite *B2TestSuite) TestOpen() {
+	_, err := Open("b2:///charts")
+	suite.NotNil(err, "error without bucket id")
+	backend, err := Open("b2://keyid:key@bucketid/charts")
+	suite.Nil(err, "no error opening b2 URL")
+	suite.Equal("bucketid", backend.(*B2Backend).BucketID, "bucket id from URL")
+	suite.Equal("charts", backend.(*B2Backend).Prefix, "prefix from URL")
+}
+
+func TestB2TestSuite(t *testing.T) {
+	suite.Run(t, new(B2TestSuite))
+}
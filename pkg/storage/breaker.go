@@ -0,0 +1,227 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+const (
+	defaultCircuitThreshold = 5
+	defaultCircuitCooldown  = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned by a CircuitBreakerBackend while its circuit is open
+var ErrCircuitOpen = errors.New("storage backend unavailable, circuit breaker open")
+
+type (
+	// CircuitBreakerBackend stops calling the wrapped backend once it fails persistently, so
+	// that requests fail fast rather than each waiting on a storage timeout.
+	//
+	// After Threshold consecutive failures the circuit opens: operations fail with
+	// ErrCircuitOpen for Cooldown. The next operation is then let through as a probe, closing
+	// the circuit if it succeeds and opening it again otherwise. Missing objects are not failures.
+	CircuitBreakerBackend struct {
+		Backend
+		CircuitBreakerOptions
+		lock      sync.Mutex
+		failures  int
+		openUntil time.Time
+		probing   bool
+	}
+
+	// CircuitBreakerOptions are the settings of a CircuitBreakerBackend
+	CircuitBreakerOptions struct {
+		// Threshold is the number of consecutive failures opening the circuit, 5 if zero
+		Threshold int
+		// Cooldown is how long the circuit stays open, 30s if zero
+		Cooldown time.Duration
+		// Logger reports the circuit opening and closing, they are not logged if nil
+		Logger *cm_logger.Logger
+	}
+)
+
+// NewCircuitBreakerBackend creates a new instance of CircuitBreakerBackend
+func NewCircuitBreakerBackend(backend Backend, options CircuitBreakerOptions) *CircuitBreakerBackend {
+	if options.Threshold <= 0 {
+		options.Threshold = defaultCircuitThreshold
+	}
+	if options.Cooldown <= 0 {
+		options.Cooldown = defaultCircuitCooldown
+	}
+	return &CircuitBreakerBackend{Backend: backend, CircuitBreakerOptions: options}
+}
+
+// RetryAfter returns how long the circuit stays open, 0 if it is closed
+func (b *CircuitBreakerBackend) RetryAfter() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// ListObjects lists objects of the wrapped backend, unless the circuit is open
+func (b *CircuitBreakerBackend) ListObjects(prefix string) ([]Object, error) {
+	return guard(b, func() ([]Object, error) {
+		return b.Backend.ListObjects(prefix)
+	})
+}
+
+// ListObjectsPage lists a page of objects of the wrapped backend, unless the circuit is open
+func (b *CircuitBreakerBackend) ListObjectsPage(prefix string, token string, limit int) ([]Object, string, error) {
+	type page struct {
+		objects []Object
+		next    string
+	}
+	p, err := guard(b, func() (page, error) {
+		objects, next, err := ListObjectsPage(b.Backend, prefix, token, limit)
+		return page{objects, next}, err
+	})
+	return p.objects, p.next, err
+}
+
+// GetObject retrieves an object of the wrapped backend, unless the circuit is open
+func (b *CircuitBreakerBackend) GetObject(path string) (Object, error) {
+	return guard(b, func() (Object, error) {
+		return b.Backend.GetObject(path)
+	})
+}
+
+// GetObjectStream opens an object of the wrapped backend, unless the circuit is open
+func (b *CircuitBreakerBackend) GetObjectStream(path string) (io.ReadCloser, int64, error) {
+	type stream struct {
+		reader io.ReadCloser
+		size   int64
+	}
+	s, err := guard(b, func() (stream, error) {
+		reader, size, err := GetObjectStream(b.Backend, path)
+		return stream{reader, size}, err
+	})
+	return s.reader, s.size, err
+}
+
+// GetObjectRange opens a range of an object of the wrapped backend, unless the circuit is open. A
+// range not satisfiable is not a failure of the backend
+func (b *CircuitBreakerBackend) GetObjectRange(path string, offset int64, length int64) (*ObjectRange, error) {
+	var notSatisfiable *RangeNotSatisfiableError
+	r, err := guard(b, func() (*ObjectRange, error) {
+		r, err := GetObjectRange(b.Backend, path, offset, length)
+		if errors.As(err, &notSatisfiable) {
+			return nil, nil
+		}
+		return r, err
+	})
+	if err == nil && notSatisfiable != nil {
+		return nil, notSatisfiable
+	}
+	return r, err
+}
+
+// PutObject puts an object in the wrapped backend, unless the circuit is open
+func (b *CircuitBreakerBackend) PutObject(path string, content []byte) error {
+	_, err := guard(b, func() (struct{}, error) {
+		return struct{}{}, b.Backend.PutObject(path, content)
+	})
+	return err
+}
+
+// DeleteObject removes an object from the wrapped backend, unless the circuit is open
+func (b *CircuitBreakerBackend) DeleteObject(path string) error {
+	_, err := guard(b, func() (struct{}, error) {
+		return struct{}{}, b.Backend.DeleteObject(path)
+	})
+	return err
+}
+
+func guard[T any](b *CircuitBreakerBackend, fn func() (T, error)) (T, error) {
+	allowed, probe := b.allow()
+	if !allowed {
+		var zero T
+		return zero, ErrCircuitOpen
+	}
+	result, err := fn()
+	b.record(probe, err)
+	return result, err
+}
+
+// allow reports whether an operation may call the wrapped backend, and whether it is the probe,
+// the first operation after the cooldown
+func (b *CircuitBreakerBackend) allow() (bool, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.failures < b.Threshold {
+		return true, false
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+func (b *CircuitBreakerBackend) record(probe bool, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	wasOpen := b.failures >= b.Threshold
+	if probe {
+		b.probing = false
+	}
+	if err == nil || IsNotFound(err) {
+		b.failures = 0
+		if wasOpen && b.Logger != nil {
+			b.Logger.Infow("Storage circuit breaker closed, storage backend recovered")
+		}
+		return
+	}
+	b.failures++
+	if b.failures >= b.Threshold {
+		b.openUntil = time.Now().Add(b.Cooldown)
+		if b.Logger != nil {
+			b.Logger.Warnw("Storage circuit breaker open, failing storage operations fast",
+				"failures", b.failures,
+				"cooldown", b.Cooldown.String(),
+				"error", err.Error(),
+			)
+		}
+	}
+}
+
+// CircuitRetryAfter returns how long the circuit of the circuit breaker of backend, possibly
+// wrapped by other backends, stays open, 0 if it is closed or there is no circuit breaker
+func CircuitRetryAfter(backend Backend) time.Duration {
+	for {
+		switch b := backend.(type) {
+		case *CircuitBreakerBackend:
+			return b.RetryAfter()
+		case *ReplicatedBackend:
+			backend = b.Backend
+		case *TieredBackend:
+			backend = b.Backend
+		case *EncryptedBackend:
+			backend = b.Backend
+		default:
+			return 0
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CircuitBreakerTestSuite struct {
+	suite.Suite
+	Flaky   *flakyBackend
+	Backend *CircuitBreakerBackend
+}
+
+func (suite *CircuitBreakerTestSuite) SetupTest() {
+	suite.Flaky = &flakyBackend{Backend: NewMemoryBackend()}
+	suite.Backend = NewCircuitBreakerBackend(suite.Flaky, CircuitBreakerOptions{Threshold: 3, Cooldown: 50 * time.Millisecond})
+}
+
+func (suite *CircuitBreakerTestSuite) TestOpen() {
+	suite.Flaky.failures = 100
+	for i := 0; i < 3; i++ {
+		suite.NotEqual(ErrCircuitOpen, suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("x")), "closed before threshold")
+	}
+	suite.Equal(3, suite.Flaky.calls)
+	suite.True(suite.Backend.RetryAfter() > 0, "open after threshold")
+
+	_, err := suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.Equal(ErrCircuitOpen, err, "fails fast while open")
+	_, err = suite.Backend.ListObjects("")
+	suite.Equal(ErrCircuitOpen, err)
+	suite.Equal(3, suite.Flaky.calls, "backend not called while open")
+
+	time.Sleep(60 * time.Millisecond)
+	suite.Equal(time.Duration(0), suite.Backend.RetryAfter())
+	suite.NotEqual(ErrCircuitOpen, suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("x")), "probe after cooldown")
+	suite.Equal(4, suite.Flaky.calls)
+	suite.Equal(ErrCircuitOpen, suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("x")), "open again after failed probe")
+
+	suite.Flaky.failures = 0
+	time.Sleep(60 * time.Millisecond)
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("x")), "successful probe")
+	suite.Nil(suite.Backend.DeleteObject("mychart-0.1.0.tgz"), "closed after successful probe")
+}
+
+func (suite *CircuitBreakerTestSuite) TestNotFound() {
+	for i := 0; i < 5; i++ {
+		_, err := suite.Backend.GetObject("missing-0.1.0.tgz")
+		suite.True(IsNotFound(err), "missing object not found")
+	}
+	suite.Equal(time.Duration(0), suite.Backend.RetryAfter(), "missing objects are not failures")
+
+	suite.Flaky.failures = 2
+	suite.Backend.ListObjects("")
+	suite.Backend.ListObjects("")
+	suite.Backend.ListObjects("")
+	suite.Flaky.failures = 2
+	suite.Backend.ListObjects("")
+	suite.Backend.ListObjects("")
+	suite.Equal(time.Duration(0), suite.Backend.RetryAfter(), "success resets consecutive failures")
+}
+
+func (suite *CircuitBreakerTestSuite) TestWrapped() {
+	suite.Flaky.failures = 3
+	for i := 0; i < 3; i++ {
+		suite.Backend.ListObjects("")
+	}
+	tiered, err := NewTieredBackend(suite.Backend, suite.T().TempDir(), 1024)
+	suite.Nil(err)
+	suite.True(CircuitRetryAfter(tiered) > 0, "open circuit of wrapped breaker")
+	suite.Equal(time.Duration(0), CircuitRetryAfter(suite.Flaky), "no breaker")
+}
+
+func TestCircuitBreakerTestSuite(t *testing.T) {
+	suite.Run(t, new(CircuitBreakerTestSuite))
+}
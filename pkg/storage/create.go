@@ -0,0 +1,44 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+// Creator is implemented by backends which can create an object only if there is none at its
+// path, in a single operation, so that concurrent uploads of a new object cannot both succeed.
+// CreateObject fails with an error wrapping fs.ErrExist if the object already exists
+type Creator interface {
+	CreateObject(path string, content []byte) error
+}
+
+// CreateObject creates the object at path, failing with an error wrapping fs.ErrExist if it
+// already exists, with the backends implementing Creator. Other backends put the object, the
+// caller having checked that it does not exist beforehand. Retry and circuit breaker backends
+// create with the wrapped backend
+func CreateObject(backend Backend, path string, content []byte) error {
+	wrapped := backend
+	for {
+		switch b := wrapped.(type) {
+		case Creator:
+			return b.CreateObject(path, content)
+		case *RetryBackend:
+			wrapped = b.Backend
+		case *CircuitBreakerBackend:
+			wrapped = b.Backend
+		default:
+			return backend.PutObject(path, content)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	pathutil "path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+// encryptedMagic starts the content of objects written by EncryptedBackend, followed by the
+// length of the encrypted data key, the encrypted data key, the nonce and the ciphertext
+var encryptedMagic = []byte("CMENC\x01")
+
+type (
+	// EncryptedBackend encrypts the content of objects before they are written to the wrapped
+	// backend, and decrypts them when read, so that the storage only holds ciphertext.
+	//
+	// Each object is encrypted with AES-256-GCM by its own data key, stored along the object
+	// encrypted by a KeyWrapper (envelope encryption). The path of the object is authenticated,
+	// so an object copied to another path fails to decrypt. Objects written without encryption
+	// are read as is, so that encryption can be enabled on an existing storage.
+	EncryptedBackend struct {
+		Backend
+		Keys KeyWrapper
+	}
+
+	// KeyWrapper generates data keys, and decrypts them
+	KeyWrapper interface {
+		// GenerateDataKey returns a new 32 bytes data key, and the data key encrypted
+		GenerateDataKey() (plaintext []byte, ciphertext []byte, err error)
+		// DecryptDataKey returns the data key of its ciphertext
+		DecryptDataKey(ciphertext []byte) ([]byte, error)
+	}
+
+	// LocalKeyWrapper encrypts data keys with AES-256-GCM by a locally held key
+	LocalKeyWrapper struct {
+		aead cipher.AEAD
+	}
+
+	// KMSKeyWrapper generates data keys with AWS KMS, encrypted by a KMS key
+	KMSKeyWrapper struct {
+		Client kmsiface.KMSAPI
+		KeyID  string
+	}
+)
+
+// NewEncryptedBackend wraps a backend, encrypting objects with data keys from keys
+func NewEncryptedBackend(backend Backend, keys KeyWrapper) *EncryptedBackend {
+	return &EncryptedBackend{Backend: backend, Keys: keys}
+}
+
+// GetObject retrieves an object and decrypts its content
+func (b *EncryptedBackend) GetObject(path string) (Object, error) {
+	object, err := b.Backend.GetObject(path)
+	if err != nil {
+		return object, err
+	}
+	if !bytes.HasPrefix(object.Content, encryptedMagic) {
+		return object, nil // written before encryption was enabled
+	}
+	object.Content, err = b.decrypt(path, object.Content)
+	if err != nil {
+		return object, fmt.Errorf("decrypting %s: %w", path, err)
+	}
+	return object, nil
+}
+
+// PutObject encrypts the content of an object with a new data key and puts it
+func (b *EncryptedBackend) PutObject(path string, content []byte) error {
+	encrypted, err := b.encrypt(path, content)
+	if err != nil {
+		return fmt.Errorf("encrypting %s: %w", path, err)
+	}
+	return b.Backend.PutObject(path, encrypted)
+}
+
+func (b *EncryptedBackend) encrypt(path string, content []byte) ([]byte, error) {
+	dataKey, encryptedDataKey, err := b.Keys.GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(encryptedDataKey) > 0xffff {
+		return nil, errors.New("encrypted data key too long")
+	}
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, len(encryptedMagic)+2+len(encryptedDataKey)+aead.NonceSize())
+	header = append(header, encryptedMagic...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(encryptedDataKey)))
+	header = append(header, encryptedDataKey...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(append(header, nonce...), nonce, content, encryptedAAD(path)), nil
+}
+
+func (b *EncryptedBackend) decrypt(path string, content []byte) ([]byte, error) {
+	content = content[len(encryptedMagic):]
+	if len(content) < 2 {
+		return nil, errors.New("truncated content")
+	}
+	keyLength := int(binary.BigEndian.Uint16(content))
+	content = content[2:]
+	if len(content) < keyLength {
+		return nil, errors.New("truncated content")
+	}
+	dataKey, err := b.Keys.DecryptDataKey(content[:keyLength])
+	if err != nil {
+		return nil, err
+	}
+	content = content[keyLength:]
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < aead.NonceSize() {
+		return nil, errors.New("truncated content")
+	}
+	return aead.Open(nil, content[:aead.NonceSize()], content[aead.NonceSize():], encryptedAAD(path))
+}
+
+// encryptedAAD is the additional data authenticated with the content, "/org/mychart-0.1.0.tgz"
+// and "org/mychart-0.1.0.tgz" being the same object
+func encryptedAAD(path string) []byte {
+	return []byte(strings.TrimPrefix(pathutil.Clean("/"+path), "/"))
+}
+
+// NewLocalKeyWrapper creates a new instance of LocalKeyWrapper, key must be 32 bytes long
+func NewLocalKeyWrapper(key []byte) (*LocalKeyWrapper, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes long, got %d", len(key))
+	}
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalKeyWrapper{aead: aead}, nil
+}
+
+// GenerateDataKey returns a new random data key, and the data key encrypted by the local key
+func (w *LocalKeyWrapper) GenerateDataKey() ([]byte, []byte, error) {
+	dataKey := make([]byte, 32)
+	nonce := make([]byte, w.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	return dataKey, w.aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+// DecryptDataKey decrypts a data key with the local key
+func (w *LocalKeyWrapper) DecryptDataKey(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < w.aead.NonceSize() {
+		return nil, errors.New("truncated data key")
+	}
+	nonce := ciphertext[:w.aead.NonceSize()]
+	return w.aead.Open(nil, nonce, ciphertext[w.aead.NonceSize():], nil)
+}
+
+// GenerateDataKey returns a new AES-256 data key of KMS, and the data key encrypted by the KMS key
+func (w *KMSKeyWrapper) GenerateDataKey() ([]byte, []byte, error) {
+	out, err := w.Client.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(w.KeyID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+// DecryptDataKey decrypts a data key with KMS
+func (w *KMSKeyWrapper) DecryptDataKey(ciphertext []byte) ([]byte, error) {
+	out, err := w.Client.Decrypt(&kms.DecryptInput{
+		KeyId:          aws.String(w.KeyID),
+		CiphertextBlob: ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
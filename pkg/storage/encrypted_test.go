@@ -0,0 +1,132 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/stretchr/testify/suite"
+)
+
+type EncryptedTestSuite struct {
+	suite.Suite
+	Storage *MemoryBackend
+	Backend *EncryptedBackend
+}
+
+// fakeKMS "encrypts" data keys by prefixing them with the key id
+type fakeKMS struct {
+	kmsiface.KMSAPI
+}
+
+func (k fakeKMS) GenerateDataKey(input *kms.GenerateDataKeyInput) (*kms.GenerateDataKeyOutput, error) {
+	plaintext := bytes.Repeat([]byte{7}, 32)
+	return &kms.GenerateDataKeyOutput{
+		Plaintext:      plaintext,
+		CiphertextBlob: append([]byte(aws.StringValue(input.KeyId)), plaintext...),
+	}, nil
+}
+
+func (k fakeKMS) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{Plaintext: input.CiphertextBlob[len(aws.StringValue(input.KeyId)):]}, nil
+}
+
+func (suite *EncryptedTestSuite) SetupTest() {
+	keys, err := NewLocalKeyWrapper(bytes.Repeat([]byte{1}, 32))
+	suite.Nil(err, "no error creating local key wrapper")
+	suite.Storage = NewMemoryBackend()
+	suite.Backend = NewEncryptedBackend(suite.Storage, keys)
+}
+
+func (suite *EncryptedTestSuite) TestEncryption() {
+	content := []byte("mychart package content")
+	suite.Nil(suite.Backend.PutObject("org/mychart-0.1.0.tgz", content), "no error putting object")
+
+	stored, err := suite.Storage.GetObject("org/mychart-0.1.0.tgz")
+	suite.Nil(err)
+	suite.True(bytes.HasPrefix(stored.Content, encryptedMagic), "stored encrypted")
+	suite.False(bytes.Contains(stored.Content, content), "no plaintext stored")
+
+	object, err := suite.Backend.GetObject("/org/mychart-0.1.0.tgz")
+	suite.Nil(err, "no error getting object")
+	suite.Equal(content, object.Content, "content decrypted")
+
+	suite.Nil(suite.Backend.PutObject("org/mychart-0.1.0.tgz", content))
+	again, _ := suite.Storage.GetObject("org/mychart-0.1.0.tgz")
+	suite.NotEqual(stored.Content, again.Content, "new data key and nonce for each write")
+
+	objects, err := suite.Backend.ListObjects("org")
+	suite.Nil(err)
+	suite.Len(objects, 1, "listing passed through")
+	suite.Nil(suite.Backend.DeleteObject("org/mychart-0.1.0.tgz"), "delete passed through")
+}
+
+func (suite *EncryptedTestSuite) TestTampering() {
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("mychart")))
+	stored, _ := suite.Storage.GetObject("mychart-0.1.0.tgz")
+
+	suite.Nil(suite.Storage.PutObject("otherchart-0.1.0.tgz", stored.Content))
+	_, err := suite.Backend.GetObject("otherchart-0.1.0.tgz")
+	suite.ErrorContains(err, "decrypting otherchart-0.1.0.tgz", "object moved to another path")
+
+	stored.Content[len(stored.Content)-1] ^= 1
+	suite.Nil(suite.Storage.PutObject("mychart-0.1.0.tgz", stored.Content))
+	_, err = suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.NotNil(err, "modified ciphertext")
+
+	suite.Nil(suite.Storage.PutObject("mychart-0.1.0.tgz", encryptedMagic))
+	_, err = suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.EqualError(err, "decrypting mychart-0.1.0.tgz: truncated content")
+
+	otherKeys, _ := NewLocalKeyWrapper(bytes.Repeat([]byte{2}, 32))
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("mychart")))
+	_, err = NewEncryptedBackend(suite.Storage, otherKeys).GetObject("mychart-0.1.0.tgz")
+	suite.NotNil(err, "wrong key")
+}
+
+func (suite *EncryptedTestSuite) TestPlaintext() {
+	suite.Nil(suite.Storage.PutObject("mychart-0.1.0.tgz", []byte("mychart")))
+	object, err := suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "no error getting object written without encryption")
+	suite.Equal("mychart", string(object.Content))
+
+	_, err = suite.Backend.GetObject("otherchart-0.1.0.tgz")
+	suite.True(IsNotFound(err), "missing object not found")
+}
+
+func (suite *EncryptedTestSuite) TestKMS() {
+	backend := NewEncryptedBackend(suite.Storage, &KMSKeyWrapper{Client: fakeKMS{}, KeyID: "alias/charts"})
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("mychart")), "no error putting object")
+	stored, _ := suite.Storage.GetObject("mychart-0.1.0.tgz")
+	suite.Contains(string(stored.Content), "alias/charts", "data key encrypted by KMS stored")
+	object, err := backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "no error getting object")
+	suite.Equal("mychart", string(object.Content))
+}
+
+func (suite *EncryptedTestSuite) TestLocalKey() {
+	_, err := NewLocalKeyWrapper([]byte("short"))
+	suite.EqualError(err, "encryption key must be 32 bytes long, got 5")
+}
+
+func TestEncryptedTestSuite(t *testing.T) {
+	suite.Run(t, new(EncryptedTestSuite))
+}
@@ -0,0 +1,47 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+
+	gcs "cloud.google.com/go/storage"
+	cm_storage "github.com/chartmuseum/storage"
+)
+
+func init() {
+	// gs://<bucket>/<prefix>, credentials from the environment
+	Register("gs", func(u *url.URL) (Backend, error) {
+		if u.Host == "" {
+			return nil, errors.New("missing bucket in gs storage URL")
+		}
+		// cm_storage.NewGoogleCSBackend panics on missing credentials
+		ctx := context.Background()
+		client, err := gcs.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &cm_storage.GoogleCSBackend{
+			Prefix:  strings.Trim(u.Path, "/"),
+			Client:  client.Bucket(u.Host),
+			Context: ctx,
+		}, nil
+	})
+}
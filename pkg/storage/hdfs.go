@@ -0,0 +1,334 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	pathutil "path"
+	"strings"
+
+	"github.com/colinmarc/hdfs/v2"
+	krb "github.com/jcmturner/gokrb5/v8/client"
+	krb_config "github.com/jcmturner/gokrb5/v8/config"
+	krb_credentials "github.com/jcmturner/gokrb5/v8/credentials"
+	krb_keytab "github.com/jcmturner/gokrb5/v8/keytab"
+)
+
+// defaultHDFSNamenodePort is the RPC port of the namenode when the URI has none
+const defaultHDFSNamenodePort = "8020"
+
+type (
+	// HDFSBackend is a storage backend for a directory of a Hadoop Distributed File System.
+	//
+	// Objects are written to a temporary file in the same directory, which is renamed over the
+	// object once complete, so readers never see a partially written chart.
+	HDFSBackend struct {
+		Root   string
+		client hdfsClient
+	}
+
+	// HDFSOptions are optional settings of a HDFSBackend
+	HDFSOptions struct {
+		// User is the HDFS user the client acts as without Kerberos, HADOOP_USER_NAME or the
+		// current user if empty
+		User string
+		// KerberosPrincipal enables Kerberos authentication as this principal, user@REALM or
+		// user in the default realm
+		KerberosPrincipal string
+		// KerberosKeytab is the keytab of KerberosPrincipal. The credentials cache of KRB5CCNAME,
+		// obtained with kinit, is used if empty
+		KerberosKeytab string
+		// KerberosConfig is the path of krb5.conf, KRB5_CONFIG or /etc/krb5.conf if empty
+		KerberosConfig string
+		// KerberosServicePrincipal is the principal of the namenodes, as the
+		// dfs.namenode.kerberos.principal property of hdfs-site.xml. nn/_HOST if empty
+		KerberosServicePrincipal string
+		// UseDatanodeHostname connects to the datanodes by hostname rather than IP address
+		UseDatanodeHostname bool
+	}
+
+	// hdfsClient is the part of the HDFS client used by the backend
+	hdfsClient interface {
+		ReadDir(dirname string) ([]os.FileInfo, error)
+		Open(name string) (io.ReadCloser, os.FileInfo, error)
+		Create(name string) (io.WriteCloser, error)
+		MkdirAll(dirname string, perm os.FileMode) error
+		Rename(oldpath, newpath string) error
+		Remove(name string) error
+	}
+
+	hdfsClientAdapter struct {
+		*hdfs.Client
+	}
+)
+
+func init() {
+	// hdfs://<namenode>:<port>/<directory>?user=<user>&principal=<principal>&keytab=<path>
+	// &krb5conf=<path>&spn=<namenode principal>&datanodehostname=true
+	Register("hdfs", func(u *url.URL) (Backend, error) {
+		query := u.Query()
+		namenode := url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}
+		return NewHDFSBackend(namenode.String(), HDFSOptions{
+			User:                     query.Get("user"),
+			KerberosPrincipal:        query.Get("principal"),
+			KerberosKeytab:           query.Get("keytab"),
+			KerberosConfig:           query.Get("krb5conf"),
+			KerberosServicePrincipal: query.Get("spn"),
+			UseDatanodeHostname:      query.Get("datanodehostname") == "true",
+		})
+	})
+}
+
+// NewHDFSBackend creates a new instance of HDFSBackend, storing objects in the directory of
+// the namenode URI, e.g. hdfs://namenode:8020/charts. Several namenodes of a high availability
+// cluster are separated by commas
+func NewHDFSBackend(namenodeURI string, options HDFSOptions) (*HDFSBackend, error) {
+	addresses, root, err := parseHDFSNamenodeURI(namenodeURI)
+	if err != nil {
+		return nil, err
+	}
+	clientOptions := hdfs.ClientOptions{
+		Addresses:           addresses,
+		UseDatanodeHostname: options.UseDatanodeHostname,
+	}
+	if options.KerberosPrincipal != "" {
+		clientOptions.KerberosClient, err = newHDFSKerberosClient(options)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.KerberosServicePrincipleName = options.KerberosServicePrincipal
+		if clientOptions.KerberosServicePrincipleName == "" {
+			clientOptions.KerberosServicePrincipleName = "nn/_HOST"
+		}
+	} else {
+		clientOptions.User, err = hdfsUser(options.User)
+		if err != nil {
+			return nil, err
+		}
+	}
+	client, err := hdfs.NewClient(clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &HDFSBackend{Root: root, client: hdfsClientAdapter{client}}, nil
+}
+
+// ListObjects lists all objects in the directory at prefix (depth 1), except files being written
+func (b *HDFSBackend) ListObjects(prefix string) ([]Object, error) {
+	var objects []Object
+	files, err := b.client.ReadDir(pathutil.Join(b.Root, prefix))
+	if err != nil {
+		if os.IsNotExist(err) { // OK if the directory doesnt exist yet
+			err = nil
+		}
+		return objects, err
+	}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), localTempPrefix) {
+			continue
+		}
+		objects = append(objects, Object{Path: f.Name(), Content: []byte{}, LastModified: f.ModTime()})
+	}
+	return objects, nil
+}
+
+// ListDirs lists the directories at prefix in the directory
+func (b *HDFSBackend) ListDirs(prefix string) ([]string, error) {
+	files, err := b.client.ReadDir(pathutil.Join(b.Root, prefix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return nil, err
+	}
+	var dirs []string
+	for _, f := range files {
+		if f.IsDir() {
+			dirs = append(dirs, f.Name())
+		}
+	}
+	return dirs, nil
+}
+
+// GetObject retrieves an object from the directory
+func (b *HDFSBackend) GetObject(path string) (Object, error) {
+	object := Object{Path: path}
+	reader, info, err := b.open(path)
+	if err != nil {
+		return object, err
+	}
+	defer reader.Close()
+	object.Content, err = io.ReadAll(reader)
+	if err != nil {
+		return object, err
+	}
+	object.LastModified = info.ModTime()
+	return object, nil
+}
+
+// GetObjectStream reads an object from the directory
+func (b *HDFSBackend) GetObjectStream(path string) (io.ReadCloser, int64, error) {
+	reader, info, err := b.open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reader, info.Size(), nil
+}
+
+func (b *HDFSBackend) open(path string) (io.ReadCloser, os.FileInfo, error) {
+	reader, info, err := b.client.Open(pathutil.Join(b.Root, path))
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		reader.Close()
+		return nil, nil, fmt.Errorf("%s is a directory", path)
+	}
+	return reader, info, nil
+}
+
+// PutObject atomically puts an object in the directory
+func (b *HDFSBackend) PutObject(path string, content []byte) error {
+	fullpath := pathutil.Join(b.Root, path)
+	folderPath := pathutil.Dir(fullpath)
+	if err := b.client.MkdirAll(folderPath, 0755); err != nil {
+		return err
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	tempPath := pathutil.Join(folderPath, localTempPrefix+pathutil.Base(fullpath)+"-"+hex.EncodeToString(suffix))
+	w, err := b.client.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = b.client.Rename(tempPath, fullpath)
+	}
+	if err != nil {
+		b.client.Remove(tempPath)
+	}
+	return err
+}
+
+// DeleteObject removes an object from the directory
+func (b *HDFSBackend) DeleteObject(path string) error {
+	return b.client.Remove(pathutil.Join(b.Root, path))
+}
+
+func (c hdfsClientAdapter) Open(name string) (io.ReadCloser, os.FileInfo, error) {
+	f, err := c.Client.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Stat(), nil
+}
+
+func (c hdfsClientAdapter) Create(name string) (io.WriteCloser, error) {
+	w, err := c.Client.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// parseHDFSNamenodeURI splits a namenode URI in namenode addresses and a directory. The URI is
+// not parsed with net/url, which rejects several host:port pairs
+func parseHDFSNamenodeURI(namenodeURI string) ([]string, string, error) {
+	hosts, ok := strings.CutPrefix(namenodeURI, "hdfs://")
+	if !ok || hosts == "" || hosts[0] == '/' {
+		return nil, "", fmt.Errorf("invalid hdfs namenode URI: %s", namenodeURI)
+	}
+	root := "/"
+	if i := strings.IndexByte(hosts, '/'); i >= 0 {
+		hosts, root = hosts[:i], hosts[i:]
+	}
+	var addresses []string
+	for _, address := range strings.Split(hosts, ",") {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, defaultHDFSNamenodePort)
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, pathutil.Clean(root), nil
+}
+
+func hdfsUser(name string) (string, error) {
+	if name == "" {
+		name = os.Getenv("HADOOP_USER_NAME")
+	}
+	if name == "" {
+		current, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		name = current.Username
+	}
+	return name, nil
+}
+
+func newHDFSKerberosClient(options HDFSOptions) (*krb.Client, error) {
+	configPath := options.KerberosConfig
+	if configPath == "" {
+		configPath = os.Getenv("KRB5_CONFIG")
+	}
+	if configPath == "" {
+		configPath = "/etc/krb5.conf"
+	}
+	config, err := krb_config.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading kerberos config: %w", err)
+	}
+
+	if options.KerberosKeytab == "" {
+		ccachePath := strings.TrimPrefix(os.Getenv("KRB5CCNAME"), "FILE:")
+		if ccachePath == "" {
+			ccachePath = fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+		}
+		ccache, err := krb_credentials.LoadCCache(ccachePath)
+		if err != nil {
+			return nil, fmt.Errorf("loading kerberos credentials cache: %w", err)
+		}
+		return krb.NewFromCCache(ccache, config)
+	}
+
+	keytab, err := krb_keytab.Load(options.KerberosKeytab)
+	if err != nil {
+		return nil, fmt.Errorf("loading kerberos keytab: %w", err)
+	}
+	username, realm, _ := strings.Cut(options.KerberosPrincipal, "@")
+	if realm == "" {
+		realm = config.LibDefaults.DefaultRealm
+	}
+	if realm == "" {
+		return nil, errors.New("missing kerberos realm")
+	}
+	return krb.NewWithKeytab(username, realm, keytab, config), nil
+}
@@ -0,0 +1,189 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type HDFSTestSuite struct {
+	suite.Suite
+	Client  *fakeHDFSClient
+	Backend *HDFSBackend
+}
+
+// fakeHDFSClient maps HDFS paths to a local directory
+type fakeHDFSClient struct {
+	dir        string
+	failRename bool
+}
+
+func (c *fakeHDFSClient) local(name string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(name))
+}
+
+func (c *fakeHDFSClient) ReadDir(dirname string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(c.local(dirname))
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (c *fakeHDFSClient) Open(name string) (io.ReadCloser, os.FileInfo, error) {
+	f, err := os.Open(c.local(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	return f, info, err
+}
+
+func (c *fakeHDFSClient) Create(name string) (io.WriteCloser, error) {
+	return os.OpenFile(c.local(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+}
+
+func (c *fakeHDFSClient) MkdirAll(dirname string, perm os.FileMode) error {
+	return os.MkdirAll(c.local(dirname), perm)
+}
+
+func (c *fakeHDFSClient) Rename(oldpath, newpath string) error {
+	if c.failRename {
+		return errors.New("rename failed")
+	}
+	return os.Rename(c.local(oldpath), c.local(newpath))
+}
+
+func (c *fakeHDFSClient) Remove(name string) error {
+	return os.Remove(c.local(name))
+}
+
+func (suite *HDFSTestSuite) SetupTest() {
+	suite.Client = &fakeHDFSClient{dir: suite.T().TempDir()}
+	suite.Backend = &HDFSBackend{Root: "/charts", client: suite.Client}
+}
+
+func (suite *HDFSTestSuite) TestObjects() {
+	objects, err := suite.Backend.ListObjects("")
+	suite.Nil(err, "no error listing missing directory")
+	suite.Empty(objects)
+
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("mychart")), "no error putting object")
+	suite.Nil(suite.Backend.PutObject("org/otherchart-0.1.0.tgz", []byte("otherchart")), "no error putting nested object")
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("mychart2")), "no error overwriting object")
+
+	objects, err = suite.Backend.ListObjects("")
+	suite.Nil(err)
+	suite.Len(objects, 1, "directories are not listed")
+	suite.Equal("mychart-0.1.0.tgz", objects[0].Path)
+	suite.False(objects[0].LastModified.IsZero(), "modification time listed")
+
+	object, err := suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "no error getting object")
+	suite.Equal("mychart2", string(object.Content))
+	suite.Equal(objects[0].LastModified, object.LastModified)
+
+	reader, size, err := GetObjectStream(suite.Backend, "org/otherchart-0.1.0.tgz")
+	suite.Nil(err, "no error streaming object")
+	content, _ := io.ReadAll(reader)
+	reader.Close()
+	suite.Equal("otherchart", string(content))
+	suite.Equal(int64(10), size)
+
+	_, err = suite.Backend.GetObject("org")
+	suite.EqualError(err, "org is a directory")
+
+	suite.Nil(suite.Backend.DeleteObject("mychart-0.1.0.tgz"), "no error deleting object")
+	_, err = suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.True(IsNotFound(err), "deleted object not found")
+	suite.True(IsNotFound(suite.Backend.DeleteObject("mychart-0.1.0.tgz")), "missing object not found")
+}
+
+func (suite *HDFSTestSuite) TestFailedWrite() {
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("mychart")))
+	suite.Client.failRename = true
+	suite.EqualError(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("mychart2")), "rename failed")
+
+	files, err := os.ReadDir(suite.Client.local("/charts"))
+	suite.Nil(err)
+	suite.Len(files, 1, "temporary file removed")
+	object, err := suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err)
+	suite.Equal("mychart", string(object.Content), "object left intact")
+
+	suite.Nil(os.WriteFile(suite.Client.local("/charts/"+localTempPrefix+"otherchart"), []byte("x"), 0644))
+	objects, err := suite.Backend.ListObjects("")
+	suite.Nil(err)
+	suite.Len(objects, 1, "files being written are not listed")
+}
+
+func (suite *HDFSTestSuite) TestNamenodeURI() {
+	addresses, root, err := parseHDFSNamenodeURI("hdfs://nn1:9000,nn2/data/charts")
+	suite.Nil(err)
+	suite.Equal([]string{"nn1:9000", "nn2:8020"}, addresses, "default port")
+	suite.Equal("/data/charts", root)
+
+	_, root, err = parseHDFSNamenodeURI("hdfs://namenode")
+	suite.Nil(err)
+	suite.Equal("/", root, "root directory by default")
+
+	_, _, err = parseHDFSNamenodeURI("http://namenode:8020/charts")
+	suite.EqualError(err, "invalid hdfs namenode URI: http://namenode:8020/charts")
+}
+
+func (suite *HDFSTestSuite) TestOptions() {
+	suite.T().Setenv("HADOOP_USER_NAME", "hadoop")
+	user, err := hdfsUser("")
+	suite.Nil(err)
+	suite.Equal("hadoop", user, "user from HADOOP_USER_NAME")
+	user, err = hdfsUser("charts")
+	suite.Nil(err)
+	suite.Equal("charts", user)
+
+	_, err = NewHDFSBackend("hdfs://namenode:8020/charts", HDFSOptions{
+		KerberosPrincipal: "charts@EXAMPLE.COM",
+		KerberosConfig:    filepath.Join(suite.T().TempDir(), "krb5.conf"),
+	})
+	suite.ErrorContains(err, "loading kerberos config", "missing kerberos config")
+
+	config := filepath.Join(suite.T().TempDir(), "krb5.conf")
+	suite.Nil(os.WriteFile(config, []byte("[libdefaults]\n  default_realm = EXAMPLE.COM\n"), 0644))
+	_, err = NewHDFSBackend("hdfs://namenode:8020/charts", HDFSOptions{
+		KerberosPrincipal: "charts",
+		KerberosKeytab:    filepath.Join(suite.T().TempDir(), "charts.keytab"),
+		KerberosConfig:    config,
+	})
+	suite.ErrorContains(err, "loading kerberos keytab", "missing keytab")
+}
+
+func TestHDFSTestSuite(t *testing.T) {
+	suite.Run(t, new(HDFSTestSuite))
+}
@@ -0,0 +1,330 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	pathutil "path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ipfsEntryTypeFile is the type of files in a files/ls response
+	ipfsEntryTypeFile = 0
+)
+
+type (
+	// IPFSBackend is an experimental storage backend for an IPFS node with the Kubo RPC API.
+	//
+	// Objects are kept in a directory of the mutable file system (MFS) of the node, which
+	// prevents them from being garbage collected. Every object is content-addressed, its
+	// CID can be pinned on other nodes or pinning services to mirror the repository.
+	//
+	// IPFS does not record modification times, an object is considered modified when its
+	// CID changes and LastModified is the time the backend first saw the current CID.
+	IPFSBackend struct {
+		APIURL  *url.URL
+		Root    string
+		Gateway string
+
+		client   *http.Client
+		seenLock sync.Mutex
+		seen     map[string]ipfsSeenObject
+	}
+
+	// IPFSOptions are optional settings of an IPFSBackend
+	IPFSOptions struct {
+		// Gateway is the base URL of an HTTP gateway used for alternate chart URLs,
+		// ipfs://<cid> URLs are used if empty
+		Gateway string
+		Client  *http.Client
+	}
+
+	ipfsSeenObject struct {
+		cid  string
+		time time.Time
+	}
+
+	// ipfsBody is the multipart content of an RPC API request
+	ipfsBody struct {
+		contentType string
+		content     []byte
+	}
+
+	ipfsEntry struct {
+		Name string
+		Type int
+		Size int64
+		Hash string
+	}
+
+	// ipfsError is returned for failed RPC API calls
+	ipfsError struct {
+		Command string
+		Status  int
+		Message string
+	}
+)
+
+func init() {
+	// ipfs+http://<api host>:<api port>/<mfs directory>?gateway=<gateway URL>, ipfs+https:// for https
+	factory := func(u *url.URL) (Backend, error) {
+		options := IPFSOptions{Gateway: u.Query().Get("gateway")}
+		apiURL := url.URL{Scheme: strings.TrimPrefix(u.Scheme, "ipfs+"), Host: u.Host}
+		return NewIPFSBackend(apiURL.String(), u.Path, options)
+	}
+	Register("ipfs+http", factory)
+	Register("ipfs+https", factory)
+}
+
+func (e *ipfsError) Error() string {
+	return fmt.Sprintf("ipfs: %s: %d %s", e.Command, e.Status, e.Message)
+}
+
+// Is makes missing objects match fs.ErrNotExist
+func (e *ipfsError) Is(target error) bool {
+	return target == fs.ErrNotExist && strings.Contains(e.Message, "does not exist")
+}
+
+// NewIPFSBackend creates a new instance of IPFSBackend, storing objects in the MFS
+// directory root of the node with the RPC API at apiURL
+func NewIPFSBackend(apiURL string, root string, options IPFSOptions) (*IPFSBackend, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid ipfs API URL: %s", apiURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if options.Gateway != "" {
+		if g, err := url.Parse(options.Gateway); err != nil || (g.Scheme != "http" && g.Scheme != "https") || g.Host == "" {
+			return nil, fmt.Errorf("invalid ipfs gateway URL: %s", options.Gateway)
+		}
+	}
+	b := &IPFSBackend{
+		APIURL:  u,
+		Root:    pathutil.Join("/", root),
+		Gateway: strings.TrimSuffix(options.Gateway, "/"),
+		client:  options.Client,
+		seen:    map[string]ipfsSeenObject{},
+	}
+	if b.client == nil {
+		b.client = http.DefaultClient
+	}
+	return b, nil
+}
+
+// ListObjects lists all objects in a directory of the MFS, at prefix
+func (b *IPFSBackend) ListObjects(prefix string) ([]Object, error) {
+	var objects []Object
+	var res struct {
+		Entries []ipfsEntry
+	}
+	err := b.callJSON("files/ls", url.Values{
+		"arg":  {b.mfsPath(prefix)},
+		"long": {"true"},
+	}, nil, &res)
+	if err != nil {
+		if IsNotFound(err) { // OK if the directory doesnt exist yet
+			err = nil
+		}
+		return objects, err
+	}
+	for _, entry := range res.Entries {
+		if entry.Type != ipfsEntryTypeFile {
+			continue
+		}
+		objects = append(objects, Object{
+			Path:         entry.Name,
+			Content:      []byte{},
+			LastModified: b.observe(pathutil.Join(prefix, entry.Name), entry.Hash),
+		})
+	}
+	return objects, nil
+}
+
+// GetObject retrieves an object from the MFS, at path
+func (b *IPFSBackend) GetObject(path string) (Object, error) {
+	object := Object{Path: path}
+	cid, err := b.stat(path)
+	if err != nil {
+		return object, err
+	}
+	res, err := b.call("files/read", url.Values{"arg": {b.mfsPath(path)}}, nil)
+	if err != nil {
+		return object, err
+	}
+	defer res.Body.Close()
+	content, err := io.ReadAll(res.Body)
+	if err != nil {
+		return object, err
+	}
+	object.Content = content
+	object.LastModified = b.observe(path, cid)
+	return object, nil
+}
+
+// PutObject adds an object to IPFS and links it in the MFS, at path
+func (b *IPFSBackend) PutObject(path string, content []byte) error {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", pathutil.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(content); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	res, err := b.call("files/write", url.Values{
+		"arg":         {b.mfsPath(path)},
+		"create":      {"true"},
+		"parents":     {"true"},
+		"truncate":    {"true"},
+		"cid-version": {"1"},
+	}, &ipfsBody{contentType: writer.FormDataContentType(), content: body.Bytes()})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	cid, err := b.stat(path)
+	if err != nil {
+		return err
+	}
+	b.observe(path, cid)
+	return nil
+}
+
+// DeleteObject removes an object from the MFS, at path
+func (b *IPFSBackend) DeleteObject(path string) error {
+	res, err := b.call("files/rm", url.Values{"arg": {b.mfsPath(path)}}, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	b.seenLock.Lock()
+	delete(b.seen, pathutil.Clean(path))
+	b.seenLock.Unlock()
+	return nil
+}
+
+// AlternateURL returns the gateway or ipfs:// URL of the current CID of the object at path
+func (b *IPFSBackend) AlternateURL(path string) (string, bool) {
+	b.seenLock.Lock()
+	seen, ok := b.seen[pathutil.Clean(path)]
+	b.seenLock.Unlock()
+	if !ok {
+		return "", false
+	}
+	if b.Gateway != "" {
+		return fmt.Sprintf("%s/ipfs/%s?filename=%s", b.Gateway, seen.cid, url.QueryEscape(pathutil.Base(path))), true
+	}
+	return "ipfs://" + seen.cid, true
+}
+
+// observe records the CID of the object at path, returning the time it was first seen
+func (b *IPFSBackend) observe(path string, cid string) time.Time {
+	path = pathutil.Clean(path)
+	b.seenLock.Lock()
+	defer b.seenLock.Unlock()
+	if seen, ok := b.seen[path]; ok && seen.cid == cid {
+		return seen.time
+	}
+	seen := ipfsSeenObject{cid: cid, time: time.Now()}
+	b.seen[path] = seen
+	return seen.time
+}
+
+func (b *IPFSBackend) stat(path string) (string, error) {
+	var res struct {
+		Hash string
+		Type string
+	}
+	err := b.callJSON("files/stat", url.Values{"arg": {b.mfsPath(path)}}, nil, &res)
+	if err != nil {
+		return "", err
+	}
+	if res.Type != "file" {
+		return "", &ipfsError{Command: "files/stat", Status: http.StatusNotFound, Message: "file does not exist"}
+	}
+	return res.Hash, nil
+}
+
+func (b *IPFSBackend) mfsPath(path string) string {
+	return pathutil.Join(b.Root, path)
+}
+
+func (b *IPFSBackend) callJSON(command string, params url.Values, body *ipfsBody, v interface{}) error {
+	res, err := b.call(command, params, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("ipfs: invalid %s response: %s", command, err)
+	}
+	return nil
+}
+
+// call sends a request to the RPC API, which only accepts POST
+func (b *IPFSBackend) call(command string, params url.Values, body *ipfsBody) (*http.Response, error) {
+	u := *b.APIURL
+	u.Path += "/api/v0/" + command
+	u.RawQuery = params.Encode()
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body.content)
+	}
+	req, err := http.NewRequest(http.MethodPost, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", body.contentType)
+	}
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		e := &ipfsError{Command: command, Status: res.StatusCode}
+		var msg struct {
+			Message string
+		}
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		if json.Unmarshal(data, &msg) == nil && msg.Message != "" {
+			e.Message = msg.Message
+		} else {
+			e.Message = strings.TrimSpace(string(data))
+		}
+		return nil, e
+	}
+	return res, nil
+}
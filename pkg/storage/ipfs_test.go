@@ -0,0 +1,207 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	pathutil "path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type IPFSTestSuite struct {
+	suite.Suite
+	Server *httptest.Server
+	Files  map[string][]byte
+	lock   sync.Mutex
+}
+
+// fakeCID is a stand-in for the CID of content, which only needs to change with it
+func fakeCID(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "bafy" + hex.EncodeToString(sum[:8])
+}
+
+// SetupTest starts a fake node implementing the MFS commands of the RPC API used by the backend
+func (suite *IPFSTestSuite) SetupTest() {
+	suite.Files = map[string][]byte{}
+	notExist := func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"Message":"file does not exist","Code":0,"Type":"error"}`))
+	}
+	suite.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		suite.lock.Lock()
+		defer suite.lock.Unlock()
+		arg := r.URL.Query().Get("arg")
+		content, exists := suite.Files[arg]
+		switch strings.TrimPrefix(r.URL.Path, "/api/v0/") {
+		case "files/write":
+			file, _, err := r.FormFile("file")
+			if err != nil || r.URL.Query().Get("create") != "true" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			content, _ := io.ReadAll(file)
+			suite.Files[arg] = content
+		case "files/stat":
+			if !exists {
+				notExist(w)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"Hash": fakeCID(content), "Type": "file"})
+		case "files/read":
+			if !exists {
+				notExist(w)
+				return
+			}
+			w.Write(content)
+		case "files/rm":
+			if !exists {
+				notExist(w)
+				return
+			}
+			delete(suite.Files, arg)
+		case "files/ls":
+			var entries []map[string]interface{}
+			for path, content := range suite.Files {
+				if pathutil.Dir(path) == arg {
+					entries = append(entries, map[string]interface{}{
+						"Name": pathutil.Base(path), "Type": 0, "Size": len(content), "Hash": fakeCID(content),
+					})
+				} else if strings.HasPrefix(path, arg+"/") {
+					entries = append(entries, map[string]interface{}{"Name": "nested", "Type": 1})
+				}
+			}
+			if entries == nil && arg != "/charts" {
+				notExist(w)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"Entries": entries})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func (suite *IPFSTestSuite) TearDownTest() {
+	suite.Server.Close()
+}
+
+func (suite *IPFSTestSuite) TestObjects() {
+	backend, err := NewIPFSBackend(suite.Server.URL, "charts", IPFSOptions{})
+	suite.Nil(err, "no error creating ipfs backend")
+
+	objects, err := backend.ListObjects("")
+	suite.Nil(err, "no error listing empty directory")
+	suite.Empty(objects, "no objects in empty directory")
+
+	objects, err = backend.ListObjects("missing")
+	suite.Nil(err, "no error listing missing directory")
+	suite.Empty(objects, "no objects in missing directory")
+
+	err = backend.PutObject("mychart-0.1.0.tgz", []byte("v1"))
+	suite.Nil(err, "no error putting object")
+	suite.Equal([]byte("v1"), suite.Files["/charts/mychart-0.1.0.tgz"], "object written in MFS root")
+	err = backend.PutObject("myrepo/mychart-0.1.0.tgz", []byte("nested"))
+	suite.Nil(err, "no error putting nested object")
+
+	objects, err = backend.ListObjects("")
+	suite.Nil(err, "no error listing objects")
+	suite.Len(objects, 1, "nested directory not listed")
+	suite.Equal("mychart-0.1.0.tgz", objects[0].Path)
+	listed := objects[0].LastModified
+
+	object, err := backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "no error getting object")
+	suite.Equal([]byte("v1"), object.Content)
+	suite.Equal(listed, object.LastModified, "same CID keeps its modification time")
+
+	url, ok := backend.AlternateURL("mychart-0.1.0.tgz")
+	suite.True(ok, "alternate URL known")
+	suite.Equal("ipfs://"+fakeCID([]byte("v1")), url)
+
+	err = backend.PutObject("mychart-0.1.0.tgz", []byte("v2"))
+	suite.Nil(err, "no error overwriting object")
+	objects, err = backend.ListObjects("")
+	suite.Nil(err, "no error listing objects")
+	suite.True(objects[0].LastModified.After(listed), "new CID considered modified")
+	url, _ = backend.AlternateURL("mychart-0.1.0.tgz")
+	suite.Equal("ipfs://"+fakeCID([]byte("v2")), url, "alternate URL follows the new CID")
+
+	err = backend.DeleteObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "no error deleting object")
+	_, ok = backend.AlternateURL("mychart-0.1.0.tgz")
+	suite.False(ok, "alternate URL forgotten")
+
+	_, err = backend.GetObject("mychart-0.1.0.tgz")
+	suite.True(IsNotFound(err), "deleted object not found")
+	err = backend.DeleteObject("mychart-0.1.0.tgz")
+	suite.True(IsNotFound(err), "deleting missing object not found")
+}
+
+func (suite *IPFSTestSuite) TestGateway() {
+	_, err := NewIPFSBackend(suite.Server.URL, "charts", IPFSOptions{Gateway: "dweb.link"})
+	suite.NotNil(err, "error with relative gateway URL")
+
+	backend, err := Open("ipfs+" + suite.Server.URL + "/charts?gateway=https://dweb.link/")
+	suite.Nil(err, "no error opening ipfs storage URL")
+	err = backend.PutObject("mychart-0.1.0.tgz", []byte("v1"))
+	suite.Nil(err, "no error putting object")
+
+	url, ok := backend.(AlternateURLer).AlternateURL("mychart-0.1.0.tgz")
+	suite.True(ok, "alternate URL known")
+	suite.Equal("https://dweb.link/ipfs/"+fakeCID([]byte("v1"))+"?filename=mychart-0.1.0.tgz", url)
+}
+
+func (suite *IPFSTestSuite) TestWrappedAlternateURL() {
+	backend, err := NewIPFSBackend(suite.Server.URL, "charts", IPFSOptions{})
+	suite.Nil(err, "no error creating ipfs backend")
+	layout, err := ParseKeyLayout("charts/{repo}/{file}")
+	suite.Nil(err, "no error parsing key layout")
+	layoutBackend, err := NewLayoutBackend(backend, layout)
+	suite.Nil(err, "no error creating layout backend")
+	wrapped := NewCircuitBreakerBackend(NewRetryBackend(layoutBackend, RetryOptions{}), CircuitBreakerOptions{})
+	err = wrapped.PutObject("myrepo/mychart-0.1.0.tgz", []byte("v1"))
+	suite.Nil(err, "no error putting object")
+
+	url, ok := AlternateURL(wrapped, "myrepo/mychart-0.1.0.tgz")
+	suite.True(ok, "alternate URL known through the wrapping backends")
+	suite.Equal("ipfs://"+fakeCID([]byte("v1")), url)
+	_, ok = AlternateURL(wrapped, "myrepo/otherchart-0.1.0.tgz")
+	suite.False(ok, "alternate URL of a missing object")
+}
+
+func (suite *IPFSTestSuite) TestInvalidURL() {
+	_, err := NewIPFSBackend("localhost:5001", "charts", IPFSOptions{})
+	suite.NotNil(err, "error with invalid API URL")
+}
+
+func TestIPFSTestSuite(t *testing.T) {
+	suite.Run(t, new(IPFSTestSuite))
+}
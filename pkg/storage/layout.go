@@ -0,0 +1,383 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	pathutil "path"
+	"sort"
+	"strings"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	cm_storage "github.com/chartmuseum/storage"
+	"google.golang.org/api/iterator"
+
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+)
+
+// Variables of a key layout template
+const (
+	layoutRepo  = "{repo}"
+	layoutName  = "{name}"
+	layoutFirst = "{first}"
+	layoutFile  = "{file}"
+)
+
+// DefaultKeyLayout stores the files of a repo directly in the directory of the repo
+const DefaultKeyLayout = layoutRepo + "/" + layoutFile
+
+type (
+	// KeyLayout places the files of repos in a storage backend. It is parsed from a template of
+	// slash-separated segments, each either a literal directory or one of:
+	//
+	//	{repo}  the directory of the repo, empty for a single tenant server
+	//	{name}  the name of the chart
+	//	{first} the first character of the name of the chart, lowercased
+	//	{file}  the name of the file, the last segment
+	//
+	// such as "charts/{repo}/{first}/{name}/{file}". Files which are not chart packages or
+	// provenance files are stored as if {name} and {first} were not in the template
+	KeyLayout struct {
+		segments []string
+		sharded  bool
+	}
+
+	// LayoutBackend stores objects at the keys of a KeyLayout in the wrapped backend, so that
+	// large repos are split across several directories. Charts stored at the default layout,
+	// before a sharded layout was configured, can still be read, listed and deleted
+	LayoutBackend struct {
+		Backend
+		Layout *KeyLayout
+	}
+
+	// DirLister is implemented by backends which can list the directories at a prefix, which
+	// sharded layouts require to list a repo
+	DirLister interface {
+		ListDirs(prefix string) ([]string, error)
+	}
+)
+
+// ParseKeyLayout parses a key layout template, DefaultKeyLayout if empty
+func ParseKeyLayout(template string) (*KeyLayout, error) {
+	if template == "" {
+		template = DefaultKeyLayout
+	}
+	layout := &KeyLayout{}
+	repos, files := 0, 0
+	for _, segment := range strings.Split(strings.Trim(template, "/"), "/") {
+		switch segment {
+		case "":
+			return nil, fmt.Errorf("empty directory in key layout %s", template)
+		case layoutRepo:
+			if layout.sharded {
+				return nil, fmt.Errorf("%s must come before %s and %s in key layout %s", layoutRepo, layoutName, layoutFirst, template)
+			}
+			repos++
+		case layoutName, layoutFirst:
+			layout.sharded = true
+		case layoutFile:
+			files++
+		default:
+			if strings.ContainsAny(segment, "{}") {
+				return nil, fmt.Errorf("unknown variable %s in key layout %s", segment, template)
+			}
+		}
+		layout.segments = append(layout.segments, segment)
+	}
+	if repos != 1 {
+		return nil, fmt.Errorf("key layout %s must contain %s once", template, layoutRepo)
+	}
+	if files != 1 || layout.segments[len(layout.segments)-1] != layoutFile {
+		return nil, fmt.Errorf("%s must be the last segment of key layout %s", layoutFile, template)
+	}
+	return layout, nil
+}
+
+// Key is the key of the object at path, "<repo>/<file>"
+func (l *KeyLayout) Key(path string) string {
+	repo, file := splitLayoutPath(path)
+	return l.key(repo, file, layoutChartName(file))
+}
+
+// flatKey is the key of the object at path without sharding
+func (l *KeyLayout) flatKey(path string) string {
+	repo, file := splitLayoutPath(path)
+	return l.key(repo, file, "")
+}
+
+// key joins the segments of the layout, skipping {name} and {first} if name is empty, and
+// {file} if file is empty
+func (l *KeyLayout) key(repo string, file string, name string) string {
+	parts := make([]string, 0, len(l.segments))
+	for _, segment := range l.segments {
+		switch segment {
+		case layoutRepo:
+			parts = append(parts, repo)
+		case layoutName:
+			if name != "" {
+				parts = append(parts, name)
+			}
+		case layoutFirst:
+			if name != "" {
+				parts = append(parts, strings.ToLower(name[:1]))
+			}
+		case layoutFile:
+			parts = append(parts, file)
+		default:
+			parts = append(parts, segment)
+		}
+	}
+	return strings.TrimPrefix(pathutil.Join(parts...), "/")
+}
+
+// NewLayoutBackend wraps a backend, storing objects at the keys of layout. A sharded layout
+// requires the backend to list directories
+func NewLayoutBackend(backend Backend, layout *KeyLayout) (*LayoutBackend, error) {
+	if layout.sharded && dirLister(backend) == nil {
+		return nil, errors.New("storage backend cannot list directories, which sharded key layouts require")
+	}
+	return &LayoutBackend{Backend: backend, Layout: layout}, nil
+}
+
+// ListObjects lists the objects of the repo at prefix, in every directory of the layout
+func (b *LayoutBackend) ListObjects(prefix string) ([]Object, error) {
+	repo := routedPath(prefix)
+	dirs := []string{""}
+	for _, segment := range b.Layout.segments[:len(b.Layout.segments)-1] {
+		var next []string
+		for _, dir := range dirs {
+			switch segment {
+			case layoutRepo:
+				next = append(next, pathutil.Join(dir, repo))
+			case layoutName, layoutFirst:
+				names, err := dirLister(b.Backend)(dir)
+				if err != nil {
+					return nil, err
+				}
+				for _, name := range names {
+					next = append(next, pathutil.Join(dir, name))
+				}
+			default:
+				next = append(next, pathutil.Join(dir, segment))
+			}
+		}
+		dirs = next
+	}
+
+	var objects []Object
+	listed := map[string]bool{}
+	for _, dir := range dirs {
+		all, err := b.Backend.ListObjects(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range all {
+			// a file in the wrong shard, such as a chart copied by hand, is not at its key
+			if b.Layout.Key(pathutil.Join(repo, object.Path)) != pathutil.Join(dir, object.Path) || listed[object.Path] {
+				continue
+			}
+			listed[object.Path] = true
+			objects = append(objects, object)
+		}
+	}
+	if b.Layout.sharded {
+		all, err := b.Backend.ListObjects(b.Layout.key(repo, "", ""))
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range all {
+			if !listed[object.Path] {
+				listed[object.Path] = true
+				objects = append(objects, object)
+			}
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Path < objects[j].Path
+	})
+	return objects, nil
+}
+
+// GetObject retrieves the object at path from its key, or from its key without sharding
+func (b *LayoutBackend) GetObject(path string) (Object, error) {
+	object, err := b.Backend.GetObject(b.Layout.Key(path))
+	if IsNotFound(err) && b.Layout.Key(path) != b.Layout.flatKey(path) {
+		object, err = b.Backend.GetObject(b.Layout.flatKey(path))
+	}
+	object.Path = path
+	return object, err
+}
+
+// GetObjectStream reads the object at path from its key, or from its key without sharding
+func (b *LayoutBackend) GetObjectStream(path string) (io.ReadCloser, int64, error) {
+	reader, size, err := GetObjectStream(b.Backend, b.Layout.Key(path))
+	if IsNotFound(err) && b.Layout.Key(path) != b.Layout.flatKey(path) {
+		return GetObjectStream(b.Backend, b.Layout.flatKey(path))
+	}
+	return reader, size, err
+}
+
+// GetObjectRange reads a range of the object at path from its key, or from its key without sharding
+func (b *LayoutBackend) GetObjectRange(path string, offset int64, length int64) (*ObjectRange, error) {
+	r, err := GetObjectRange(b.Backend, b.Layout.Key(path), offset, length)
+	if IsNotFound(err) && b.Layout.Key(path) != b.Layout.flatKey(path) {
+		return GetObjectRange(b.Backend, b.Layout.flatKey(path), offset, length)
+	}
+	return r, err
+}
+
+// PresignGetObject presigns the key of the object at path
+func (b *LayoutBackend) PresignGetObject(path string, expiry time.Duration) (string, error) {
+	return Presign(b.Backend, b.Layout.Key(path), expiry)
+}
+
+// AlternateURL returns the alternate location of the object at path from its key, or from its key
+// without sharding
+func (b *LayoutBackend) AlternateURL(path string) (string, bool) {
+	alternateURL, ok := AlternateURL(b.Backend, b.Layout.Key(path))
+	if !ok && b.Layout.Key(path) != b.Layout.flatKey(path) {
+		return AlternateURL(b.Backend, b.Layout.flatKey(path))
+	}
+	return alternateURL, ok
+}
+
+// PutObject puts an object at its key
+func (b *LayoutBackend) PutObject(path string, content []byte) error {
+	return b.Backend.PutObject(b.Layout.Key(path), content)
+}
+
+// DeleteObject removes the object at path from its key, and from its key without sharding
+func (b *LayoutBackend) DeleteObject(path string) error {
+	err := b.Backend.DeleteObject(b.Layout.Key(path))
+	if b.Layout.Key(path) == b.Layout.flatKey(path) || (err != nil && !IsNotFound(err)) {
+		return err
+	}
+	flatErr := b.Backend.DeleteObject(b.Layout.flatKey(path))
+	if IsNotFound(flatErr) {
+		return err
+	}
+	return flatErr
+}
+
+// splitLayoutPath splits the path of an object in the directory of its repo and its file name
+func splitLayoutPath(path string) (string, string) {
+	path = routedPath(path)
+	repo, file := pathutil.Split(path)
+	return strings.TrimSuffix(repo, "/"), file
+}
+
+// layoutChartName is the name of the chart of a package or provenance file, "" for other files
+func layoutChartName(file string) string {
+	base := strings.TrimSuffix(file, "."+cm_repo.ProvenanceFileExtension)
+	if base == file {
+		base = strings.TrimSuffix(file, "."+cm_repo.ChartPackageFileExtension)
+	}
+	if base == file || !strings.Contains(base, "-") {
+		return ""
+	}
+	name, _ := cm_repo.GetExactChartNameVersion(base)
+	return name
+}
+
+// dirLister returns the function listing the directories at a prefix of backend, nil if the
+// backend cannot list directories
+func dirLister(backend Backend) func(prefix string) ([]string, error) {
+	switch b := backend.(type) {
+	case DirLister:
+		return b.ListDirs
+	case *cm_storage.LocalFilesystemBackend:
+		return func(prefix string) ([]string, error) {
+			return listLocalDirs(b.RootDirectory, prefix)
+		}
+	case *cm_storage.AmazonS3Backend:
+		return func(prefix string) ([]string, error) {
+			return listAmazonS3Dirs(b, prefix)
+		}
+	case *AmazonS3KMSBackend:
+		return func(prefix string) ([]string, error) {
+			return listAmazonS3Dirs(b.AmazonS3Backend, prefix)
+		}
+	case *cm_storage.GoogleCSBackend:
+		return func(prefix string) ([]string, error) {
+			return listGoogleCSDirs(b, prefix)
+		}
+	default:
+		return nil
+	}
+}
+
+// ListDirs lists the directories at prefix in root directory
+func (b *LocalFilesystemBackend) ListDirs(prefix string) ([]string, error) {
+	return listLocalDirs(b.RootDirectory, prefix)
+}
+
+func listLocalDirs(rootDirectory string, prefix string) ([]string, error) {
+	entries, err := os.ReadDir(pathutil.Join(rootDirectory, prefix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return nil, err
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	return dirs, nil
+}
+
+func listAmazonS3Dirs(b *cm_storage.AmazonS3Backend, prefix string) ([]string, error) {
+	prefix = listPrefix(b.Prefix, prefix)
+	var dirs []string
+	err := b.Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, commonPrefix := range page.CommonPrefixes {
+			dirs = append(dirs, strings.TrimSuffix(aws.StringValue(commonPrefix.Prefix)[len(prefix):], "/"))
+		}
+		return true
+	})
+	return dirs, err
+}
+
+func listGoogleCSDirs(b *cm_storage.GoogleCSBackend, prefix string) ([]string, error) {
+	prefix = listPrefix(b.Prefix, prefix)
+	it := b.Client.Objects(b.Context, &gcs.Query{Prefix: prefix, Delimiter: "/"})
+	var dirs []string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return dirs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs.Prefix != "" {
+			dirs = append(dirs, strings.TrimSuffix(attrs.Prefix[len(prefix):], "/"))
+		}
+	}
+}
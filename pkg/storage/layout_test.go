@@ -0,0 +1,149 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LayoutTestSuite struct {
+	suite.Suite
+	Storage *MemoryBackend
+	Backend *LayoutBackend
+}
+
+func (suite *LayoutTestSuite) SetupTest() {
+	layout, err := ParseKeyLayout("charts/{repo}/{first}/{name}/{file}")
+	suite.Nil(err, "no error parsing key layout")
+	suite.Storage = NewMemoryBackend()
+	suite.Backend, err = NewLayoutBackend(suite.Storage, layout)
+	suite.Nil(err, "no error creating layout backend")
+}
+
+func (suite *LayoutTestSuite) TestParse() {
+	layout, err := ParseKeyLayout("")
+	suite.Nil(err, "no error parsing default layout")
+	suite.Equal("org/repo/my-chart-0.1.0.tgz", layout.Key("/org/repo/my-chart-0.1.0.tgz"))
+	suite.Equal("my-chart-0.1.0.tgz", layout.Key("my-chart-0.1.0.tgz"))
+
+	layout, _ = ParseKeyLayout("/{repo}/{first}/{file}/")
+	suite.Equal("org/m/my-chart-0.1.0.tgz", layout.Key("org/my-chart-0.1.0.tgz"))
+	suite.Equal("org/m/my-chart-0.1.0.tgz.prov", layout.Key("org/my-chart-0.1.0.tgz.prov"), "provenance files along charts")
+	suite.Equal("org/index-cache.yaml", layout.Key("org/index-cache.yaml"), "other files not sharded")
+	suite.Equal("m/my-chart-0.1.0.tgz", layout.Key("my-chart-0.1.0.tgz"), "no repo directory")
+
+	for template, message := range map[string]string{
+		"{repo}":                      "{file} must be the last segment of key layout {repo}",
+		"{file}":                      "key layout {file} must contain {repo} once",
+		"{repo}/{file}/{name}":        "{file} must be the last segment of key layout {repo}/{file}/{name}",
+		"{repo}/{file}/{file}":        "{file} must be the last segment of key layout {repo}/{file}/{file}",
+		"{name}/{repo}/{file}":        "{repo} must come before {name} and {first} in key layout {name}/{repo}/{file}",
+		"{repo}//{file}":              "empty directory in key layout {repo}//{file}",
+		"{repo}/{version}/{file}":     "unknown variable {version} in key layout {repo}/{version}/{file}",
+		"{repo}/{repo}/{name}/{file}": "key layout {repo}/{repo}/{name}/{file} must contain {repo} once",
+	} {
+		_, err := ParseKeyLayout(template)
+		suite.EqualError(err, message, "invalid template %s", template)
+	}
+}
+
+func (suite *LayoutTestSuite) TestObjects() {
+	for _, path := range []string{"org/my-chart-0.1.0.tgz", "org/my-chart-0.1.0.tgz.prov", "org/otherchart-0.2.0.tgz", "org/index-cache.yaml", "my-chart-0.1.0.tgz"} {
+		suite.Nil(suite.Backend.PutObject(path, []byte(path)), "no error putting %s", path)
+	}
+	for _, key := range []string{"charts/org/m/my-chart/my-chart-0.1.0.tgz", "charts/org/m/my-chart/my-chart-0.1.0.tgz.prov", "charts/org/o/otherchart/otherchart-0.2.0.tgz", "charts/org/index-cache.yaml", "charts/m/my-chart/my-chart-0.1.0.tgz"} {
+		_, err := suite.Storage.GetObject(key)
+		suite.Nil(err, "stored at %s", key)
+	}
+
+	objects, err := suite.Backend.ListObjects("org")
+	suite.Nil(err, "no error listing repo")
+	var paths []string
+	for _, object := range objects {
+		paths = append(paths, object.Path)
+	}
+	suite.Equal([]string{"index-cache.yaml", "my-chart-0.1.0.tgz", "my-chart-0.1.0.tgz.prov", "otherchart-0.2.0.tgz"}, paths, "every shard listed")
+
+	objects, err = suite.Backend.ListObjects("")
+	suite.Nil(err, "no error listing root")
+	suite.Len(objects, 1, "repos are not shards of the root")
+
+	object, err := suite.Backend.GetObject("org/otherchart-0.2.0.tgz")
+	suite.Nil(err, "no error getting object")
+	suite.Equal("org/otherchart-0.2.0.tgz", object.Path, "path of the object, not its key")
+	suite.Equal("org/otherchart-0.2.0.tgz", string(object.Content))
+
+	reader, _, err := GetObjectStream(suite.Backend, "org/otherchart-0.2.0.tgz")
+	suite.Nil(err, "no error streaming object")
+	content, _ := io.ReadAll(reader)
+	reader.Close()
+	suite.Equal("org/otherchart-0.2.0.tgz", string(content))
+
+	suite.Nil(suite.Backend.DeleteObject("org/otherchart-0.2.0.tgz"), "no error deleting object")
+	_, err = suite.Storage.GetObject("charts/org/o/otherchart/otherchart-0.2.0.tgz")
+	suite.True(IsNotFound(err), "deleted at its key")
+	suite.True(IsNotFound(suite.Backend.DeleteObject("org/otherchart-0.2.0.tgz")), "missing object not found")
+}
+
+func (suite *LayoutTestSuite) TestFlatCharts() {
+	suite.Nil(suite.Storage.PutObject("charts/org/my-chart-0.1.0.tgz", []byte("flat")))
+	suite.Nil(suite.Storage.PutObject("charts/org/z/my-chart/my-chart-0.2.0.tgz", []byte("wrong shard")))
+
+	objects, err := suite.Backend.ListObjects("org")
+	suite.Nil(err)
+	suite.Len(objects, 1, "chart stored before sharding listed, chart in the wrong shard ignored")
+	object, err := suite.Backend.GetObject("org/my-chart-0.1.0.tgz")
+	suite.Nil(err, "no error getting chart stored before sharding")
+	suite.Equal("flat", string(object.Content))
+
+	suite.Nil(suite.Backend.PutObject("org/my-chart-0.1.0.tgz", []byte("sharded")))
+	objects, _ = suite.Backend.ListObjects("org")
+	suite.Len(objects, 1, "listed once")
+	object, _ = suite.Backend.GetObject("org/my-chart-0.1.0.tgz")
+	suite.Equal("sharded", string(object.Content), "sharded copy read first")
+
+	suite.Nil(suite.Backend.DeleteObject("org/my-chart-0.1.0.tgz"), "no error deleting both copies")
+	_, err = suite.Backend.GetObject("org/my-chart-0.1.0.tgz")
+	suite.True(IsNotFound(err), "no copy left")
+}
+
+func (suite *LayoutTestSuite) TestDirectories() {
+	dir := suite.T().TempDir()
+	layout, _ := ParseKeyLayout("{repo}/{first}/{file}")
+	backend, err := NewLayoutBackend(NewLocalFilesystemBackend(dir, false), layout)
+	suite.Nil(err, "local filesystem lists directories")
+	suite.Nil(backend.PutObject("org/my-chart-0.1.0.tgz", []byte("x")))
+	objects, err := backend.ListObjects("org")
+	suite.Nil(err)
+	suite.Len(objects, 1)
+	objects, err = backend.ListObjects("missing")
+	suite.Nil(err, "no error listing missing repo")
+	suite.Empty(objects)
+
+	_, err = NewLayoutBackend(presignerBackend{suite.Storage}, layout)
+	suite.EqualError(err, "storage backend cannot list directories, which sharded key layouts require")
+	layout, _ = ParseKeyLayout("charts/{repo}/{file}")
+	_, err = NewLayoutBackend(presignerBackend{suite.Storage}, layout)
+	suite.Nil(err, "directories not listed without sharding")
+}
+
+func TestLayoutTestSuite(t *testing.T) {
+	suite.Run(t, new(LayoutTestSuite))
+}
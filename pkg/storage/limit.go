@@ -0,0 +1,49 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrObjectTooLarge is returned when putting an object larger than a backend accepts
+var ErrObjectTooLarge = errors.New("object too large")
+
+// SizeLimitedBackend rejects objects larger than MaxObjectSize before they reach the
+// wrapped backend, for backends storing objects as values of a limited size such as etcd
+type SizeLimitedBackend struct {
+	Backend
+	MaxObjectSize int
+}
+
+// NewSizeLimitedBackend wraps a backend to limit the size of its objects. A limit of
+// zero or less returns the backend as is
+func NewSizeLimitedBackend(backend Backend, maxObjectSize int) Backend {
+	if maxObjectSize <= 0 {
+		return backend
+	}
+	return &SizeLimitedBackend{Backend: backend, MaxObjectSize: maxObjectSize}
+}
+
+// PutObject uploads an object to the wrapped backend, unless it is too large
+func (b *SizeLimitedBackend) PutObject(path string, content []byte) error {
+	if len(content) > b.MaxObjectSize {
+		return fmt.Errorf("%w: %s is %d bytes, the limit is %d bytes", ErrObjectTooLarge, path, len(content), b.MaxObjectSize)
+	}
+	return b.Backend.PutObject(path, content)
+}
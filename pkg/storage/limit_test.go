@@ -0,0 +1,50 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/stretchr/testify/suite"
+)
+
+type LimitTestSuite struct {
+	suite.Suite
+}
+
+func (suite *LimitTestSuite) TestSizeLimitedBackend() {
+	local := cm_storage.NewLocalFilesystemBackend(suite.T().TempDir())
+	suite.Equal(Backend(local), NewSizeLimitedBackend(local, 0), "no limit")
+
+	backend := NewSizeLimitedBackend(local, 4)
+	suite.Nil(backend.PutObject("small.tgz", []byte("1234")), "no error putting object at the limit")
+	err := backend.PutObject("large.tgz", []byte("12345"))
+	suite.True(errors.Is(err, ErrObjectTooLarge), "error putting object over the limit")
+	suite.Contains(err.Error(), "large.tgz is 5 bytes, the limit is 4 bytes", "sizes in error")
+
+	_, err = local.GetObject("large.tgz")
+	suite.True(IsNotFound(err), "object over the limit not stored")
+	object, err := backend.GetObject("small.tgz")
+	suite.Nil(err, "no error getting object")
+	suite.Equal("1234", string(object.Content), "object content")
+}
+
+func TestLimitTestSuite(t *testing.T) {
+	suite.Run(t, new(LimitTestSuite))
+}
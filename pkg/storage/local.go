@@ -0,0 +1,132 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"os"
+	pathutil "path"
+	"strings"
+
+	cm_storage "github.com/chartmuseum/storage"
+)
+
+// localTempPrefix marks files being written, which are not listed
+const localTempPrefix = ".upload-"
+
+// LocalFilesystemBackend is a storage backend for local filesystem storage with atomic writes.
+//
+// Objects are written to a temporary file in the same directory, which is renamed over the
+// object once complete, so a crashed upload never leaves a truncated object behind.
+type LocalFilesystemBackend struct {
+	*cm_storage.LocalFilesystemBackend
+	// Fsync flushes the content of objects and their directory to disk before PutObject
+	// and DeleteObject return, so they survive a power loss
+	Fsync bool
+}
+
+// NewLocalFilesystemBackend creates a new instance of LocalFilesystemBackend
+func NewLocalFilesystemBackend(rootDirectory string, fsync bool) *LocalFilesystemBackend {
+	return &LocalFilesystemBackend{
+		LocalFilesystemBackend: cm_storage.NewLocalFilesystemBackend(rootDirectory),
+		Fsync:                  fsync,
+	}
+}
+
+// ListObjects lists all objects in root directory (depth 1), except files being written
+func (b *LocalFilesystemBackend) ListObjects(prefix string) ([]Object, error) {
+	all, err := b.LocalFilesystemBackend.ListObjects(prefix)
+	if err != nil {
+		return all, err
+	}
+	var objects []Object
+	for _, object := range all {
+		if strings.HasPrefix(object.Path, localTempPrefix) {
+			continue
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// PutObject atomically puts an object in root directory
+func (b *LocalFilesystemBackend) PutObject(path string, content []byte) error {
+	fullpath := pathutil.Join(b.RootDirectory, path)
+	folderPath := pathutil.Dir(fullpath)
+	if _, err := os.Stat(folderPath); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.MkdirAll(folderPath, 0774); err != nil {
+			return err
+		}
+		// same permissions as the directories created by the non-atomic backend, regardless of umask
+		if err := os.Chmod(folderPath, 0774); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.CreateTemp(folderPath, localTempPrefix+pathutil.Base(fullpath)+"-*")
+	if err != nil {
+		return err
+	}
+	tempPath := f.Name()
+	err = b.writeTemp(f, content)
+	if err == nil {
+		err = os.Rename(tempPath, fullpath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return b.syncDir(folderPath)
+}
+
+// DeleteObject removes an object from root directory
+func (b *LocalFilesystemBackend) DeleteObject(path string) error {
+	if err := b.LocalFilesystemBackend.DeleteObject(path); err != nil {
+		return err
+	}
+	return b.syncDir(pathutil.Dir(pathutil.Join(b.RootDirectory, path)))
+}
+
+func (b *LocalFilesystemBackend) writeTemp(f *os.File, content []byte) error {
+	_, err := f.Write(content)
+	if err == nil {
+		// CreateTemp uses 0600, keep objects readable like the non-atomic backend
+		err = f.Chmod(0644)
+	}
+	if err == nil && b.Fsync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir flushes a directory so that renames and removals in it are durable
+func (b *LocalFilesystemBackend) syncDir(dir string) error {
+	if !b.Fsync {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,89 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"os"
+	pathutil "path"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LocalTestSuite struct {
+	suite.Suite
+	RootDirectory string
+}
+
+func (suite *LocalTestSuite) SetupTest() {
+	suite.RootDirectory = suite.T().TempDir()
+}
+
+func (suite *LocalTestSuite) testObjects(backend *LocalFilesystemBackend) {
+	err := backend.PutObject("mychart-0.1.0.tgz", []byte("v1"))
+	suite.Nil(err, "no error putting object")
+	err = backend.PutObject("mychart-0.1.0.tgz", []byte("v2"))
+	suite.Nil(err, "no error overwriting object")
+	err = backend.PutObject("myrepo/mychart-0.1.0.tgz", []byte("nested"))
+	suite.Nil(err, "no error putting object in new directory")
+
+	info, err := os.Stat(pathutil.Join(suite.RootDirectory, "mychart-0.1.0.tgz"))
+	suite.Nil(err, "object stored")
+	suite.Equal(os.FileMode(0644), info.Mode().Perm(), "object readable by others")
+
+	// leftover of a crashed upload
+	suite.Nil(os.WriteFile(pathutil.Join(suite.RootDirectory, localTempPrefix+"mychart-0.2.0.tgz-1"), []byte("v"), 0644))
+
+	objects, err := backend.ListObjects("")
+	suite.Nil(err, "no error listing objects")
+	suite.Len(objects, 1, "temporary files and directories not listed")
+	suite.Equal("mychart-0.1.0.tgz", objects[0].Path)
+
+	object, err := backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "no error getting object")
+	suite.Equal([]byte("v2"), object.Content, "object overwritten")
+
+	err = backend.DeleteObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "no error deleting object")
+	_, err = backend.GetObject("mychart-0.1.0.tgz")
+	suite.True(IsNotFound(err), "deleted object not found")
+	err = backend.DeleteObject("mychart-0.1.0.tgz")
+	suite.True(IsNotFound(err), "deleting missing object not found")
+}
+
+func (suite *LocalTestSuite) TestObjects() {
+	suite.testObjects(NewLocalFilesystemBackend(suite.RootDirectory, false))
+}
+
+func (suite *LocalTestSuite) TestObjectsFsync() {
+	suite.testObjects(NewLocalFilesystemBackend(suite.RootDirectory, true))
+}
+
+func (suite *LocalTestSuite) TestFailedWrite() {
+	backend := NewLocalFilesystemBackend(suite.RootDirectory, false)
+	suite.Nil(os.Mkdir(pathutil.Join(suite.RootDirectory, "mychart-0.1.0.tgz"), 0755))
+	err := backend.PutObject("mychart-0.1.0.tgz", []byte("v1"))
+	suite.NotNil(err, "error renaming over a directory")
+
+	entries, err := os.ReadDir(suite.RootDirectory)
+	suite.Nil(err)
+	suite.Len(entries, 1, "temporary file removed")
+}
+
+func TestLocalTestSuite(t *testing.T) {
+	suite.Run(t, new(LocalTestSuite))
+}
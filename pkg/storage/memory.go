@@ -0,0 +1,164 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"io/fs"
+	"net/url"
+	pathutil "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// MemoryBackend is a storage backend keeping objects in memory, for embedding the server
+	// in tests and demos. Objects are lost when the process exits.
+	//
+	// ListObjects returns objects sorted by path, and every write of an object gives it a
+	// modification time later than its previous one, so that changes are always detected.
+	MemoryBackend struct {
+		lock    sync.RWMutex
+		objects map[string]memoryObject
+	}
+
+	memoryObject struct {
+		content      []byte
+		lastModified time.Time
+	}
+)
+
+func init() {
+	// memory://, every backend opened is empty
+	Register("memory", func(u *url.URL) (Backend, error) {
+		return NewMemoryBackend(), nil
+	})
+}
+
+// NewMemoryBackend creates a new, empty instance of MemoryBackend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{objects: map[string]memoryObject{}}
+}
+
+// ListObjects lists the objects at prefix (depth 1), sorted by path
+func (b *MemoryBackend) ListObjects(prefix string) ([]Object, error) {
+	objects, _, err := b.ListObjectsPage(prefix, "", 0)
+	return objects, err
+}
+
+// ListObjectsPage lists at most limit objects at prefix, all of them if limit is not positive,
+// following the object named by token in path order
+func (b *MemoryBackend) ListObjectsPage(prefix string, token string, limit int) ([]Object, string, error) {
+	prefix = memoryKey(prefix)
+	var objects []Object
+	b.lock.RLock()
+	for key, object := range b.objects {
+		if memoryDir(key) != prefix || pathutil.Base(key) <= token {
+			continue
+		}
+		objects = append(objects, Object{
+			Path:         pathutil.Base(key),
+			Content:      []byte{},
+			LastModified: object.lastModified,
+		})
+	}
+	b.lock.RUnlock()
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Path < objects[j].Path
+	})
+	if limit <= 0 || len(objects) <= limit {
+		return objects, "", nil
+	}
+	return objects[:limit], objects[limit-1].Path, nil
+}
+
+// ListDirs lists the directories at prefix, sorted by name
+func (b *MemoryBackend) ListDirs(prefix string) ([]string, error) {
+	prefix = memoryKey(prefix)
+	seen := map[string]bool{}
+	var dirs []string
+	b.lock.RLock()
+	for key := range b.objects {
+		if prefix != "" {
+			if !strings.HasPrefix(key, prefix+"/") {
+				continue
+			}
+			key = key[len(prefix)+1:]
+		}
+		if i := strings.IndexByte(key, '/'); i >= 0 && !seen[key[:i]] {
+			seen[key[:i]] = true
+			dirs = append(dirs, key[:i])
+		}
+	}
+	b.lock.RUnlock()
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// GetObject retrieves a copy of an object
+func (b *MemoryBackend) GetObject(path string) (Object, error) {
+	b.lock.RLock()
+	object, ok := b.objects[memoryKey(path)]
+	b.lock.RUnlock()
+	if !ok {
+		return Object{Path: path}, &fs.PathError{Op: "get", Path: path, Err: fs.ErrNotExist}
+	}
+	return Object{
+		Path:         path,
+		Content:      append([]byte{}, object.content...),
+		LastModified: object.lastModified,
+	}, nil
+}
+
+// PutObject stores a copy of content at path
+func (b *MemoryBackend) PutObject(path string, content []byte) error {
+	key := memoryKey(path)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	lastModified := time.Now()
+	if previous, ok := b.objects[key]; ok && !lastModified.After(previous.lastModified) {
+		lastModified = previous.lastModified.Add(time.Nanosecond)
+	}
+	b.objects[key] = memoryObject{content: append([]byte{}, content...), lastModified: lastModified}
+	return nil
+}
+
+// DeleteObject removes an object
+func (b *MemoryBackend) DeleteObject(path string) error {
+	key := memoryKey(path)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.objects[key]; !ok {
+		return &fs.PathError{Op: "delete", Path: path, Err: fs.ErrNotExist}
+	}
+	delete(b.objects, key)
+	return nil
+}
+
+// memoryKey normalizes a path, so that "/org/mychart-0.1.0.tgz" and "org/mychart-0.1.0.tgz"
+// are the same object
+func memoryKey(path string) string {
+	return strings.TrimPrefix(pathutil.Clean("/"+path), "/")
+}
+
+func memoryDir(key string) string {
+	if i := strings.LastIndexByte(key, '/'); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MemoryTestSuite struct {
+	suite.Suite
+	Backend *MemoryBackend
+}
+
+func (suite *MemoryTestSuite) SetupTest() {
+	suite.Backend = NewMemoryBackend()
+}
+
+func (suite *MemoryTestSuite) TestObjects() {
+	objects, err := suite.Backend.ListObjects("")
+	suite.Nil(err, "no error listing empty backend")
+	suite.Empty(objects)
+
+	for _, path := range []string{"mychart-0.2.0.tgz", "/mychart-0.1.0.tgz", "org/repo/otherchart-0.1.0.tgz", "org/mychart-0.1.0.tgz"} {
+		suite.Nil(suite.Backend.PutObject(path, []byte(path)), "no error putting %s", path)
+	}
+
+	objects, err = suite.Backend.ListObjects("")
+	suite.Nil(err)
+	suite.Len(objects, 2, "nested objects are not listed")
+	suite.Equal("mychart-0.1.0.tgz", objects[0].Path, "sorted by path")
+	suite.Equal("mychart-0.2.0.tgz", objects[1].Path)
+	suite.Empty(objects[0].Content, "no content listed")
+
+	objects, err = suite.Backend.ListObjects("/org/repo/")
+	suite.Nil(err)
+	suite.Len(objects, 1)
+	suite.Equal("otherchart-0.1.0.tgz", objects[0].Path, "path relative to prefix")
+
+	object, err := suite.Backend.GetObject("org/repo/otherchart-0.1.0.tgz")
+	suite.Nil(err, "no error getting object")
+	suite.Equal("org/repo/otherchart-0.1.0.tgz", string(object.Content))
+	object.Content[0] = 'x'
+	object, _ = suite.Backend.GetObject("org/repo/otherchart-0.1.0.tgz")
+	suite.Equal("org/repo/otherchart-0.1.0.tgz", string(object.Content), "content copied")
+
+	suite.Nil(suite.Backend.DeleteObject("/mychart-0.1.0.tgz"), "no error deleting object")
+	_, err = suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.True(IsNotFound(err), "deleted object not found")
+	suite.True(IsNotFound(suite.Backend.DeleteObject("mychart-0.1.0.tgz")), "missing object not found")
+}
+
+func (suite *MemoryTestSuite) TestLastModified() {
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("1")))
+	first, _ := suite.Backend.GetObject("mychart-0.1.0.tgz")
+	for i := 0; i < 100; i++ {
+		suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("2")))
+	}
+	last, _ := suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.True(last.LastModified.After(first.LastModified), "every write is more recent")
+	suite.True(last.LastModified.Sub(first.LastModified) >= 100, "no two writes at the same time")
+}
+
+func (suite *MemoryTestSuite) TestConcurrency() {
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := fmt.Sprintf("mychart-0.%d.0.tgz", i)
+			suite.Backend.PutObject(path, []byte(path))
+			suite.Backend.ListObjects("")
+			suite.Backend.GetObject(path)
+		}(i)
+	}
+	wg.Wait()
+	objects, err := suite.Backend.ListObjects("")
+	suite.Nil(err)
+	suite.Len(objects, 10)
+}
+
+func (suite *MemoryTestSuite) TestOpen() {
+	backend, err := Open("memory://")
+	suite.Nil(err, "no error opening memory backend")
+	suite.IsType(&MemoryBackend{}, backend)
+}
+
+func TestMemoryTestSuite(t *testing.T) {
+	suite.Run(t, new(MemoryTestSuite))
+}
@@ -0,0 +1,43 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	cm_storage "github.com/chartmuseum/storage"
+)
+
+func init() {
+	// azure://<container>/<prefix>, account from AZURE_STORAGE_ACCOUNT and
+	// AZURE_STORAGE_ACCESS_KEY
+	Register("azure", func(u *url.URL) (backend Backend, err error) {
+		if u.Host == "" {
+			return nil, errors.New("missing container in azure storage URL")
+		}
+		// cm_storage.NewMicrosoftBlobBackend panics on missing or invalid credentials
+		defer func() {
+			if r := recover(); r != nil {
+				backend, err = nil, fmt.Errorf("invalid azure storage credentials: %v", r)
+			}
+		}()
+		return cm_storage.NewMicrosoftBlobBackend(u.Host, strings.Trim(u.Path, "/")), nil
+	})
+}
@@ -0,0 +1,117 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	cm_storage "github.com/chartmuseum/storage"
+)
+
+// MinIOOptions are the settings of a MinIO server, or of another S3 compatible storage
+// deployed on premises
+type MinIOOptions struct {
+	// Endpoint is the URL of the server, e.g. https://minio.example.com:9000
+	Endpoint string
+	Bucket   string
+	Prefix   string
+	// Region signs the requests, us-east-1 if empty
+	Region string
+	// AccessKeyID and SecretAccessKey are the credentials, those of the AWS environment
+	// variables and files if empty
+	AccessKeyID     string
+	SecretAccessKey string
+	// CACertFile is a PEM bundle of the certificate authorities trusted for the server, in
+	// addition to those of the system
+	CACertFile string
+	// InsecureSkipVerify disables the verification of the certificate of the server
+	InsecureSkipVerify bool
+}
+
+// NewMinIOBackend creates an Amazon S3 backend for a MinIO server, addressing buckets by path
+func NewMinIOBackend(options MinIOOptions) (*cm_storage.AmazonS3Backend, error) {
+	endpoint, err := url.Parse(options.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("endpoint must be an http:// or https:// URL, got %q", options.Endpoint)
+	}
+	if (options.AccessKeyID == "") != (options.SecretAccessKey == "") {
+		return nil, errors.New("access key id and secret access key must be set together")
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}
+	if options.CACertFile != "" {
+		tlsConfig.RootCAs, err = minioCertPool(options.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	config := &aws.Config{
+		Region:           aws.String(options.Region),
+		Endpoint:         aws.String(options.Endpoint),
+		DisableSSL:       aws.Bool(endpoint.Scheme == "http"),
+		S3ForcePathStyle: aws.Bool(true),
+	}
+	if options.Region == "" {
+		config.Region = aws.String("us-east-1")
+	}
+	if options.AccessKeyID != "" {
+		config.Credentials = credentials.NewStaticCredentials(options.AccessKeyID, options.SecretAccessKey, "")
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	// the client is given to the service rather than to the session, which would replace its
+	// certificate authorities by those of AWS_CA_BUNDLE
+	service := s3.New(sess, &aws.Config{HTTPClient: &http.Client{Transport: transport}})
+	return &cm_storage.AmazonS3Backend{
+		Bucket:     options.Bucket,
+		Client:     service,
+		Downloader: s3manager.NewDownloaderWithClient(service),
+		Prefix:     strings.Trim(options.Prefix, "/"),
+		Uploader:   s3manager.NewUploaderWithClient(service),
+	}, nil
+}
+
+func minioCertPool(caCertFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caCertFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caCertFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	pathutil "path"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MinIOTestSuite struct {
+	suite.Suite
+	Server        *httptest.Server
+	CACertFile    string
+	Path          string
+	Authorization string
+}
+
+// SetupTest starts a fake MinIO server over TLS, recording the last PUT request
+func (suite *MinIOTestSuite) SetupTest() {
+	suite.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			suite.Path = r.URL.Path
+			suite.Authorization = r.Header.Get("Authorization")
+		}
+		w.Header().Set("ETag", `"x"`)
+	}))
+	suite.CACertFile = pathutil.Join(suite.T().TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: suite.Server.Certificate().Raw})
+	suite.Nil(os.WriteFile(suite.CACertFile, certificate, 0644))
+}
+
+func (suite *MinIOTestSuite) TearDownTest() {
+	suite.Server.Close()
+}
+
+func (suite *MinIOTestSuite) TestCACert() {
+	backend, err := NewMinIOBackend(MinIOOptions{
+		Endpoint:        suite.Server.URL,
+		Bucket:          "charts",
+		Prefix:          "/prefix/",
+		AccessKeyID:     "minioadmin",
+		SecretAccessKey: "minioadmin",
+		CACertFile:      suite.CACertFile,
+	})
+	suite.Nil(err, "no error creating MinIO backend")
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("x")), "no error putting object with CA certificate")
+	suite.Equal("/charts/prefix/mychart-0.1.0.tgz", suite.Path, "path-style addressing")
+	suite.Contains(suite.Authorization, "Credential=minioadmin/", "static credentials")
+	suite.Contains(suite.Authorization, "/us-east-1/s3/", "default region")
+}
+
+func (suite *MinIOTestSuite) TestInsecureSkipVerify() {
+	suite.T().Setenv("AWS_ACCESS_KEY_ID", "x")
+	suite.T().Setenv("AWS_SECRET_ACCESS_KEY", "x")
+	backend, err := NewMinIOBackend(MinIOOptions{Endpoint: suite.Server.URL, Bucket: "charts"})
+	suite.Nil(err)
+	suite.NotNil(backend.PutObject("mychart-0.1.0.tgz", []byte("x")), "unknown certificate authority")
+
+	backend, err = NewMinIOBackend(MinIOOptions{Endpoint: suite.Server.URL, Bucket: "charts", InsecureSkipVerify: true})
+	suite.Nil(err)
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("x")), "no error skipping verification")
+	suite.Contains(suite.Authorization, "Credential=x/", "credentials of the environment")
+}
+
+func (suite *MinIOTestSuite) TestInvalid() {
+	_, err := NewMinIOBackend(MinIOOptions{Endpoint: "minio:9000", Bucket: "charts"})
+	suite.EqualError(err, `endpoint must be an http:// or https:// URL, got "minio:9000"`)
+	_, err = NewMinIOBackend(MinIOOptions{Endpoint: "http://minio:9000", Bucket: "charts", AccessKeyID: "minioadmin"})
+	suite.EqualError(err, "access key id and secret access key must be set together")
+	empty := pathutil.Join(suite.T().TempDir(), "empty.pem")
+	suite.Nil(os.WriteFile(empty, []byte("not a certificate"), 0644))
+	_, err = NewMinIOBackend(MinIOOptions{Endpoint: "https://minio:9000", Bucket: "charts", CACertFile: empty})
+	suite.EqualError(err, "no certificate found in "+empty)
+}
+
+func TestMinIOTestSuite(t *testing.T) {
+	suite.Run(t, new(MinIOTestSuite))
+}
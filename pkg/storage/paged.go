@@ -0,0 +1,152 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	pathutil "path"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	cm_storage "github.com/chartmuseum/storage"
+	"google.golang.org/api/iterator"
+)
+
+// defaultListPageSize is the page size of backends without a default, the default of Amazon S3
+const defaultListPageSize = 1000
+
+// PageLister is implemented by backends which can list the objects at a prefix one page at a
+// time, so that listing a very large repo is not a single long running call
+type PageLister interface {
+	// ListObjectsPage lists at most limit objects (the backend default if limit is not positive),
+	// continuing from token, the token returned with the previous page or "" for the first page.
+	// The token returned is "" once all the objects are listed
+	ListObjectsPage(prefix string, token string, limit int) ([]Object, string, error)
+}
+
+// ListObjectsPage lists a page of the objects at prefix. Amazon S3 and Google Cloud Storage
+// backends list pages, as well as the backends implementing PageLister. Other backends list all
+// the objects at once, in a single page
+func ListObjectsPage(backend Backend, prefix string, token string, limit int) ([]Object, string, error) {
+	for {
+		switch b := backend.(type) {
+		case PageLister:
+			return b.ListObjectsPage(prefix, token, limit)
+		case *cm_storage.AmazonS3Backend:
+			return listAmazonS3Page(b, prefix, token, limit)
+		case *AmazonS3KMSBackend:
+			return listAmazonS3Page(b.AmazonS3Backend, prefix, token, limit)
+		case *cm_storage.GoogleCSBackend:
+			return listGoogleCSPage(b, prefix, token, limit)
+		case *ReplicatedBackend:
+			backend = b.Backend
+		case *EncryptedBackend:
+			backend = b.Backend
+		default:
+			objects, err := backend.ListObjects(prefix)
+			return objects, "", err
+		}
+	}
+}
+
+// WalkObjects lists the objects at prefix page by page, calling fn with each page. Listing stops
+// at the first error, of the backend or of fn
+func WalkObjects(backend Backend, prefix string, limit int, fn func([]Object) error) error {
+	token := ""
+	for {
+		objects, next, err := ListObjectsPage(backend, prefix, token, limit)
+		if err != nil {
+			return err
+		}
+		if err := fn(objects); err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		token = next
+	}
+}
+
+// listPrefix is the prefix of the keys of the objects directly at prefix in a bucket
+func listPrefix(bucketPrefix string, prefix string) string {
+	prefix = pathutil.Join(bucketPrefix, prefix)
+	if prefix == "" || prefix == "." || prefix == "/" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+func listAmazonS3Page(b *cm_storage.AmazonS3Backend, prefix string, token string, limit int) ([]Object, string, error) {
+	prefix = listPrefix(b.Prefix, prefix)
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
+	if token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
+	if limit > 0 {
+		input.MaxKeys = aws.Int64(int64(limit))
+	}
+	result, err := b.Client.ListObjectsV2(input)
+	if err != nil {
+		return nil, "", err
+	}
+	objects := make([]Object, 0, len(result.Contents))
+	for _, obj := range result.Contents {
+		path := aws.StringValue(obj.Key)[len(prefix):]
+		if path == "" {
+			continue
+		}
+		objects = append(objects, Object{
+			Path:         path,
+			Content:      []byte{},
+			LastModified: aws.TimeValue(obj.LastModified),
+		})
+	}
+	if !aws.BoolValue(result.IsTruncated) {
+		return objects, "", nil
+	}
+	return objects, aws.StringValue(result.NextContinuationToken), nil
+}
+
+func listGoogleCSPage(b *cm_storage.GoogleCSBackend, prefix string, token string, limit int) ([]Object, string, error) {
+	prefix = listPrefix(b.Prefix, prefix)
+	if limit <= 0 {
+		limit = defaultListPageSize
+	}
+	it := b.Client.Objects(b.Context, &gcs.Query{Prefix: prefix, Delimiter: "/"})
+	var page []*gcs.ObjectAttrs
+	next, err := iterator.NewPager(it, limit, token).NextPage(&page)
+	if err != nil {
+		return nil, "", err
+	}
+	objects := make([]Object, 0, len(page))
+	for _, attrs := range page {
+		if attrs.Name == "" || attrs.Name == prefix {
+			continue // a "directory", listed with its prefix only
+		}
+		objects = append(objects, Object{
+			Path:         attrs.Name[len(prefix):],
+			Content:      []byte{},
+			LastModified: attrs.Updated,
+		})
+	}
+	return objects, next, nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/stretchr/testify/suite"
+)
+
+type PagedTestSuite struct {
+	suite.Suite
+	Backend *MemoryBackend
+}
+
+func (suite *PagedTestSuite) SetupTest() {
+	suite.Backend = NewMemoryBackend()
+	for i := 0; i < 5; i++ {
+		suite.Nil(suite.Backend.PutObject(fmt.Sprintf("org/mychart-0.%d.0.tgz", i), []byte("mychart")))
+	}
+	suite.Nil(suite.Backend.PutObject("org/nested/otherchart-0.1.0.tgz", []byte("otherchart")))
+}
+
+func (suite *PagedTestSuite) TestMemoryPages() {
+	objects, token, err := suite.Backend.ListObjectsPage("org", "", 2)
+	suite.Nil(err, "no error listing first page")
+	suite.Len(objects, 2)
+	suite.Equal("mychart-0.1.0.tgz", objects[1].Path)
+	suite.Equal("mychart-0.1.0.tgz", token, "token is the last object listed")
+
+	objects, token, err = suite.Backend.ListObjectsPage("org", token, 3)
+	suite.Nil(err, "no error listing last page")
+	suite.Len(objects, 3)
+	suite.Equal("mychart-0.2.0.tgz", objects[0].Path, "listing continues after token")
+	suite.Empty(token, "no token after last page")
+}
+
+func (suite *PagedTestSuite) TestWalkObjects() {
+	keys, _ := NewLocalKeyWrapper(make([]byte, 32))
+	backend := NewRetryBackend(NewEncryptedBackend(suite.Backend, keys), RetryOptions{Attempts: 2})
+
+	var pages [][]Object
+	err := WalkObjects(backend, "org", 2, func(objects []Object) error {
+		pages = append(pages, objects)
+		return nil
+	})
+	suite.Nil(err, "no error walking objects")
+	suite.Len(pages, 3, "listed two objects at a time")
+	suite.Len(pages[2], 1)
+	suite.Equal("mychart-0.4.0.tgz", pages[2][0].Path)
+
+	stop := errors.New("stop")
+	calls := 0
+	err = WalkObjects(backend, "org", 2, func([]Object) error {
+		calls++
+		return stop
+	})
+	suite.Equal(stop, err, "error of fn returned")
+	suite.Equal(1, calls, "walk stops at first error")
+}
+
+func (suite *PagedTestSuite) TestSinglePage() {
+	dir := suite.T().TempDir()
+	backend := cm_storage.NewLocalFilesystemBackend(dir)
+	for i := 0; i < 3; i++ {
+		suite.Nil(backend.PutObject(fmt.Sprintf("mychart-0.%d.0.tgz", i), []byte("mychart")))
+	}
+	objects, token, err := ListObjectsPage(backend, "", "", 1)
+	suite.Nil(err, "no error listing backend without pages")
+	suite.Len(objects, 3, "all objects listed at once")
+	suite.Empty(token)
+}
+
+func (suite *PagedTestSuite) TestAmazonS3() {
+	suite.T().Setenv("AWS_ACCESS_KEY_ID", "x")
+	suite.T().Setenv("AWS_SECRET_ACCESS_KEY", "x")
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		if r.URL.Query().Get("continuation-token") == "" {
+			fmt.Fprint(w, `<ListBucketResult><IsTruncated>true</IsTruncated><NextContinuationToken>next</NextContinuationToken>
+<Contents><Key>prefix/org/mychart-0.1.0.tgz</Key><LastModified>2023-01-02T15:04:05Z</LastModified></Contents>
+<CommonPrefixes><Prefix>prefix/org/nested/</Prefix></CommonPrefixes></ListBucketResult>`)
+			return
+		}
+		fmt.Fprint(w, `<ListBucketResult><IsTruncated>false</IsTruncated>
+<Contents><Key>prefix/org/mychart-0.2.0.tgz</Key><LastModified>2023-01-02T15:04:05Z</LastModified></Contents></ListBucketResult>`)
+	}))
+	defer server.Close()
+	backend := cm_storage.NewAmazonS3Backend("charts", "prefix", "us-east-1", server.URL, "")
+
+	var paths []string
+	err := WalkObjects(backend, "org", 1, func(objects []Object) error {
+		for _, object := range objects {
+			paths = append(paths, object.Path)
+		}
+		return nil
+	})
+	suite.Nil(err, "no error listing S3 pages")
+	suite.Equal([]string{"mychart-0.1.0.tgz", "mychart-0.2.0.tgz"}, paths, "paths relative to prefix")
+	suite.Len(queries, 2)
+	suite.Contains(queries[0], "prefix=prefix%2Forg%2F")
+	suite.Contains(queries[0], "max-keys=1")
+	suite.Contains(queries[1], "continuation-token=next")
+}
+
+func TestPagedTestSuite(t *testing.T) {
+	suite.Run(t, new(PagedTestSuite))
+}
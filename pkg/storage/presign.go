@@ -0,0 +1,78 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"net/http"
+	pathutil "path"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	cm_storage "github.com/chartmuseum/storage"
+)
+
+// ErrPresignUnsupported is returned by Presign for backends which cannot presign URLs
+var ErrPresignUnsupported = errors.New("storage backend does not support presigned URLs")
+
+// Presigner is implemented by backends which can grant a temporary read access to an object,
+// so that clients download it from the storage directly rather than through the chart server
+type Presigner interface {
+	PresignGetObject(path string, expiry time.Duration) (string, error)
+}
+
+// Presign returns a URL to download the object at path, valid for expiry. Amazon S3 and Google
+// Cloud Storage backends are supported, as well as the backends implementing Presigner.
+// Wrapping backends reading from another backend presign with the wrapped backend
+func Presign(backend Backend, path string, expiry time.Duration) (string, error) {
+	for {
+		switch b := backend.(type) {
+		case Presigner:
+			return b.PresignGetObject(path, expiry)
+		case *cm_storage.AmazonS3Backend:
+			return presignAmazonS3(b, path, expiry)
+		case *AmazonS3KMSBackend:
+			return presignAmazonS3(b.AmazonS3Backend, path, expiry)
+		case *cm_storage.GoogleCSBackend:
+			return b.Client.SignedURL(pathutil.Join(b.Prefix, path), &gcs.SignedURLOptions{
+				Method:  http.MethodGet,
+				Expires: time.Now().Add(expiry),
+				Scheme:  gcs.SigningSchemeV4,
+			})
+		case *ReplicatedBackend:
+			backend = b.Backend
+		case *TieredBackend:
+			backend = b.Backend
+		case *RetryBackend:
+			backend = b.Backend
+		case *CircuitBreakerBackend:
+			backend = b.Backend
+		default:
+			return "", ErrPresignUnsupported
+		}
+	}
+}
+
+func presignAmazonS3(b *cm_storage.AmazonS3Backend, path string, expiry time.Duration) (string, error) {
+	req, _ := b.Client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(pathutil.Join(b.Prefix, path)),
+	})
+	return req.Presign(expiry)
+}
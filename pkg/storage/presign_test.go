@@ -0,0 +1,87 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/stretchr/testify/suite"
+)
+
+type PresignTestSuite struct {
+	suite.Suite
+}
+
+type presignerBackend struct {
+	Backend
+}
+
+func (b presignerBackend) PresignGetObject(path string, expiry time.Duration) (string, error) {
+	return "https://cdn.example.com/" + path + "?expiry=" + expiry.String(), nil
+}
+
+func (suite *PresignTestSuite) SetupTest() {
+	suite.T().Setenv("AWS_ACCESS_KEY_ID", "x")
+	suite.T().Setenv("AWS_SECRET_ACCESS_KEY", "x")
+}
+
+func (suite *PresignTestSuite) TestAmazonS3() {
+	backend := cm_storage.NewAmazonS3Backend("charts", "prefix", "us-east-1", "https://s3.example.com", "")
+
+	signedURL, err := Presign(backend, "org/mychart-0.1.0.tgz", 5*time.Minute)
+	suite.Nil(err, "no error presigning S3 object")
+	u, err := url.Parse(signedURL)
+	suite.Nil(err, "presigned URL is valid")
+	suite.Equal("s3.example.com", u.Host)
+	suite.Equal("/charts/prefix/org/mychart-0.1.0.tgz", u.Path)
+	suite.Equal("300", u.Query().Get("X-Amz-Expires"), "expiry in seconds")
+	suite.NotEmpty(u.Query().Get("X-Amz-Signature"), "URL is signed")
+
+	kms, err := NewAmazonS3SSEBackend(backend, "", "alias/charts")
+	suite.Nil(err)
+	signedURL, err = Presign(kms, "mychart-0.1.0.tgz", time.Minute)
+	suite.Nil(err, "no error presigning with KMS key")
+	suite.Contains(signedURL, "/charts/prefix/mychart-0.1.0.tgz?")
+}
+
+func (suite *PresignTestSuite) TestWrappedBackends() {
+	backend := presignerBackend{Backend: cm_storage.NewLocalFilesystemBackend(suite.T().TempDir())}
+
+	replicated := NewReplicatedBackend(backend, NewLocalFilesystemBackend(suite.T().TempDir(), false), ReplicationOptions{})
+	defer replicated.Close()
+	signedURL, err := Presign(replicated, "mychart-0.1.0.tgz", time.Minute)
+	suite.Nil(err, "no error presigning with the primary")
+	suite.Equal("https://cdn.example.com/mychart-0.1.0.tgz?expiry=1m0s", signedURL)
+
+	tiered, err := NewTieredBackend(backend, suite.T().TempDir(), 1024)
+	suite.Nil(err)
+	signedURL, err = Presign(tiered, "mychart-0.1.0.tgz", time.Minute)
+	suite.Nil(err, "no error presigning with the cold tier")
+	suite.Equal("https://cdn.example.com/mychart-0.1.0.tgz?expiry=1m0s", signedURL)
+}
+
+func (suite *PresignTestSuite) TestUnsupported() {
+	_, err := Presign(NewLocalFilesystemBackend(suite.T().TempDir(), false), "mychart-0.1.0.tgz", time.Minute)
+	suite.Equal(ErrPresignUnsupported, err, "local storage cannot presign")
+}
+
+func TestPresignTestSuite(t *testing.T) {
+	suite.Run(t, new(PresignTestSuite))
+}
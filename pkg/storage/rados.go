@@ -0,0 +1,70 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+type (
+	// RADOSOptions are the settings of a RADOSBackend, which stores objects directly in a
+	// pool of a Ceph cluster with librados, bypassing the S3 layer of the RADOS Gateway.
+	//
+	// The backend requires cgo and librados, and is only available in binaries built
+	// with the ceph build tag.
+	RADOSOptions struct {
+		// Pool is the RADOS pool to store charts in
+		Pool string
+		// Namespace isolates chart objects from other objects of the pool
+		Namespace string
+		// Cluster is the name of the cluster, ceph if empty
+		Cluster string
+		// User is the name of the Ceph user, client.admin if empty
+		User string
+		// ConfigFile is the path of ceph.conf, the default locations are searched if empty
+		ConfigFile string
+	}
+)
+
+func init() {
+	// rados://<pool>/<namespace>?user=<user>&cluster=<cluster>&conf=<path to ceph.conf>
+	Register("rados", func(u *url.URL) (Backend, error) {
+		query := u.Query()
+		return NewRADOSBackend(RADOSOptions{
+			Pool:       u.Host,
+			Namespace:  strings.Trim(u.Path, "/"),
+			Cluster:    query.Get("cluster"),
+			User:       query.Get("user"),
+			ConfigFile: query.Get("conf"),
+		})
+	})
+}
+
+func (options *RADOSOptions) validate() error {
+	if options.Pool == "" {
+		return errors.New("missing rados pool")
+	}
+	if options.Cluster == "" {
+		options.Cluster = "ceph"
+	}
+	if options.User == "" {
+		options.User = "client.admin"
+	}
+	return nil
+}
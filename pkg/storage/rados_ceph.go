@@ -0,0 +1,217 @@
+//go:build ceph
+
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+/*
+#cgo LDFLAGS: -lrados
+#include <errno.h>
+#include <stdlib.h>
+#include <rados/librados.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"io/fs"
+	pathutil "path"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+type (
+	// RADOSBackend is a storage backend for a pool of a Ceph cluster, using librados.
+	//
+	// RADOS has no directories, objects are named after their path. Listing iterates over
+	// every object of the namespace, so a dedicated namespace should be used.
+	RADOSBackend struct {
+		Pool      string
+		Namespace string
+
+		cluster C.rados_t
+		ioctx   C.rados_ioctx_t
+	}
+
+	// radosError is a negative errno returned by librados
+	radosError struct {
+		Op   string
+		Path string
+		Code int
+	}
+)
+
+func (e *radosError) Error() string {
+	return fmt.Sprintf("rados: %s %s: %s", e.Op, e.Path, syscall.Errno(-e.Code).Error())
+}
+
+// Is makes missing objects match fs.ErrNotExist
+func (e *radosError) Is(target error) bool {
+	return target == fs.ErrNotExist && e.Code == -int(C.ENOENT)
+}
+
+func radosCheck(op string, path string, ret C.int) error {
+	if ret < 0 {
+		return &radosError{Op: op, Path: path, Code: int(ret)}
+	}
+	return nil
+}
+
+// NewRADOSBackend connects to a Ceph cluster and creates a new instance of RADOSBackend
+func NewRADOSBackend(options RADOSOptions) (Backend, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	b := &RADOSBackend{Pool: options.Pool, Namespace: options.Namespace}
+
+	clusterName := C.CString(options.Cluster)
+	defer C.free(unsafe.Pointer(clusterName))
+	userName := C.CString(options.User)
+	defer C.free(unsafe.Pointer(userName))
+	if err := radosCheck("create", options.User, C.rados_create2(&b.cluster, clusterName, userName, 0)); err != nil {
+		return nil, err
+	}
+
+	var configFile *C.char // NULL searches the default locations
+	if options.ConfigFile != "" {
+		configFile = C.CString(options.ConfigFile)
+		defer C.free(unsafe.Pointer(configFile))
+	}
+	if err := radosCheck("read config", options.ConfigFile, C.rados_conf_read_file(b.cluster, configFile)); err != nil {
+		C.rados_shutdown(b.cluster)
+		return nil, err
+	}
+	if err := radosCheck("connect", options.Cluster, C.rados_connect(b.cluster)); err != nil {
+		C.rados_shutdown(b.cluster)
+		return nil, err
+	}
+
+	pool := C.CString(options.Pool)
+	defer C.free(unsafe.Pointer(pool))
+	if err := radosCheck("open pool", options.Pool, C.rados_ioctx_create(b.cluster, pool, &b.ioctx)); err != nil {
+		C.rados_shutdown(b.cluster)
+		return nil, err
+	}
+	namespace := C.CString(options.Namespace)
+	defer C.free(unsafe.Pointer(namespace))
+	C.rados_ioctx_set_namespace(b.ioctx, namespace)
+	return b, nil
+}
+
+// ListObjects lists all objects in the namespace of the pool, at prefix
+func (b *RADOSBackend) ListObjects(prefix string) ([]Object, error) {
+	var objects []Object
+	var list C.rados_list_ctx_t
+	if err := radosCheck("list", prefix, C.rados_nobjects_list_open(b.ioctx, &list)); err != nil {
+		return objects, err
+	}
+	defer C.rados_nobjects_list_close(list)
+
+	prefix = b.oid(prefix)
+	if prefix != "" {
+		prefix += "/"
+	}
+	for {
+		var entry *C.char
+		ret := C.rados_nobjects_list_next(list, &entry, nil, nil)
+		if ret == -C.ENOENT {
+			break
+		}
+		if err := radosCheck("list", prefix, ret); err != nil {
+			return objects, err
+		}
+		oid := C.GoString(entry)
+		if !strings.HasPrefix(oid, prefix) || strings.Contains(oid[len(prefix):], "/") {
+			continue
+		}
+		_, lastModified, err := b.stat(oid)
+		if err != nil {
+			if IsNotFound(err) { // deleted while listing
+				continue
+			}
+			return objects, err
+		}
+		objects = append(objects, Object{
+			Path:         oid[len(prefix):],
+			Content:      []byte{},
+			LastModified: lastModified,
+		})
+	}
+	return objects, nil
+}
+
+// GetObject retrieves an object from the pool, at path
+func (b *RADOSBackend) GetObject(path string) (Object, error) {
+	object := Object{Path: path}
+	oid := b.oid(path)
+	size, lastModified, err := b.stat(oid)
+	if err != nil {
+		return object, err
+	}
+	content := make([]byte, size)
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	for read := uint64(0); read < size; {
+		ret := C.rados_read(b.ioctx, coid, (*C.char)(unsafe.Pointer(&content[read])), C.size_t(size-read), C.uint64_t(read))
+		if err := radosCheck("read", path, ret); err != nil {
+			return object, err
+		}
+		if ret == 0 { // truncated while reading
+			content = content[:read]
+			break
+		}
+		read += uint64(ret)
+	}
+	object.Content = content
+	object.LastModified = lastModified
+	return object, nil
+}
+
+// PutObject atomically replaces the content of an object in the pool, at path
+func (b *RADOSBackend) PutObject(path string, content []byte) error {
+	coid := C.CString(b.oid(path))
+	defer C.free(unsafe.Pointer(coid))
+	var buf *C.char
+	if len(content) > 0 {
+		buf = (*C.char)(unsafe.Pointer(&content[0]))
+	}
+	return radosCheck("write", path, C.rados_write_full(b.ioctx, coid, buf, C.size_t(len(content))))
+}
+
+// DeleteObject removes an object from the pool, at path
+func (b *RADOSBackend) DeleteObject(path string) error {
+	coid := C.CString(b.oid(path))
+	defer C.free(unsafe.Pointer(coid))
+	return radosCheck("remove", path, C.rados_remove(b.ioctx, coid))
+}
+
+func (b *RADOSBackend) stat(oid string) (uint64, time.Time, error) {
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	var size C.uint64_t
+	var mtime C.time_t
+	if err := radosCheck("stat", oid, C.rados_stat(b.ioctx, coid, &size, &mtime)); err != nil {
+		return 0, time.Time{}, err
+	}
+	return uint64(size), time.Unix(int64(mtime), 0), nil
+}
+
+func (b *RADOSBackend) oid(path string) string {
+	return strings.TrimPrefix(pathutil.Join("/", path), "/")
+}
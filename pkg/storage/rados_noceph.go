@@ -0,0 +1,31 @@
+//go:build !ceph
+
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+)
+
+// NewRADOSBackend fails in binaries built without the ceph build tag
+func NewRADOSBackend(options RADOSOptions) (Backend, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("rados storage is not supported by this binary, it must be built with -tags ceph")
+}
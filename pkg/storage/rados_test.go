@@ -0,0 +1,44 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RADOSTestSuite struct {
+	suite.Suite
+}
+
+func (suite *RADOSTestSuite) TestOptions() {
+	_, err := NewRADOSBackend(RADOSOptions{Namespace: "charts"})
+	suite.EqualError(err, "missing rados pool", "error without pool")
+
+	_, err = Open("rados:///charts")
+	suite.EqualError(err, "missing rados pool", "error without pool in storage URL")
+
+	options := RADOSOptions{Pool: "charts"}
+	suite.Nil(options.validate(), "no error with pool")
+	suite.Equal("ceph", options.Cluster, "default cluster name")
+	suite.Equal("client.admin", options.User, "default user")
+}
+
+func TestRADOSTestSuite(t *testing.T) {
+	suite.Run(t, new(RADOSTestSuite))
+}
@@ -0,0 +1,216 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	pathutil "path"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	cm_storage "github.com/chartmuseum/storage"
+)
+
+type (
+	// RangeGetter is implemented by backends which can read a byte range of an object without
+	// reading the bytes before it
+	RangeGetter interface {
+		GetObjectRange(path string, offset int64, length int64) (*ObjectRange, error)
+	}
+
+	// ObjectRange is a byte range of an object being read, the reader must be closed
+	ObjectRange struct {
+		io.ReadCloser
+		// Offset and Length are those of the bytes read
+		Offset int64
+		Length int64
+		// Size is the size of the whole object
+		Size int64
+	}
+
+	// RangeNotSatisfiableError is returned when a range starts past the end of an object
+	RangeNotSatisfiableError struct {
+		// Size is the size of the object, -1 if unknown
+		Size int64
+	}
+)
+
+func (e *RangeNotSatisfiableError) Error() string {
+	return "range not satisfiable"
+}
+
+// GetObjectRange reads length bytes of the object at path from offset, up to its end if length is
+// negative, or its last -offset bytes if offset is negative. Local filesystem, Amazon S3 and Google
+// Cloud Storage backends read the range only, as well as the backends implementing RangeGetter.
+// Other backends stream the object and skip the bytes before the range
+func GetObjectRange(backend Backend, path string, offset int64, length int64) (*ObjectRange, error) {
+	if length == 0 || (offset < 0 && length > 0) {
+		return nil, fmt.Errorf("invalid range of %d bytes from %d", length, offset)
+	}
+	for {
+		switch b := backend.(type) {
+		case RangeGetter:
+			return b.GetObjectRange(path, offset, length)
+		case *cm_storage.LocalFilesystemBackend:
+			return rangeLocalFile(b.RootDirectory, path, offset, length)
+		case *cm_storage.AmazonS3Backend:
+			return rangeAmazonS3(b, path, offset, length)
+		case *AmazonS3KMSBackend:
+			return rangeAmazonS3(b.AmazonS3Backend, path, offset, length)
+		case *cm_storage.GoogleCSBackend:
+			if offset < 0 {
+				length = -1
+			}
+			reader, err := b.Client.Object(pathutil.Join(b.Prefix, path)).NewRangeReader(context.Background(), offset, length)
+			if err != nil {
+				return nil, err
+			}
+			if reader.Remain() == 0 {
+				// the client reads nothing rather than failing past the end of the object
+				reader.Close()
+				return nil, &RangeNotSatisfiableError{Size: reader.Attrs.Size}
+			}
+			return &ObjectRange{reader, reader.Attrs.StartOffset, reader.Remain(), reader.Attrs.Size}, nil
+		case *ReplicatedBackend:
+			backend = b.Backend
+		default:
+			reader, size, err := GetObjectStream(backend, path)
+			if err != nil {
+				return nil, err
+			}
+			return skipToRange(reader, size, offset, length)
+		}
+	}
+}
+
+// GetObjectRange reads a range of an object from root directory
+func (b *LocalFilesystemBackend) GetObjectRange(path string, offset int64, length int64) (*ObjectRange, error) {
+	return rangeLocalFile(b.RootDirectory, path, offset, length)
+}
+
+func rangeLocalFile(rootDirectory string, path string, offset int64, length int64) (*ObjectRange, error) {
+	reader, size, err := streamLocalFile(rootDirectory, path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := clampRange(size, offset, length)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	if _, err := reader.(*os.File).Seek(r.Offset, io.SeekStart); err != nil {
+		reader.Close()
+		return nil, err
+	}
+	r.ReadCloser = limitReadCloser(reader, r.Length)
+	return r, nil
+}
+
+func rangeAmazonS3(b *cm_storage.AmazonS3Backend, path string, offset int64, length int64) (*ObjectRange, error) {
+	var byteRange string
+	switch {
+	case offset < 0:
+		byteRange = fmt.Sprintf("bytes=%d", offset)
+	case length < 0:
+		byteRange = fmt.Sprintf("bytes=%d-", offset)
+	default:
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	out, err := b.Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(pathutil.Join(b.Prefix, path)),
+		Range:  aws.String(byteRange),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == http.StatusRequestedRangeNotSatisfiable {
+			return nil, &RangeNotSatisfiableError{Size: -1}
+		}
+		return nil, err
+	}
+	r := &ObjectRange{ReadCloser: out.Body, Size: -1}
+	if out.ContentLength != nil {
+		r.Length = *out.ContentLength
+	}
+	if out.ContentRange != nil {
+		// bytes first-last/size
+		var last int64
+		var size string
+		if _, err := fmt.Sscanf(*out.ContentRange, "bytes %d-%d/%s", &r.Offset, &last, &size); err == nil {
+			if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+				r.Size = n
+			}
+		}
+	}
+	return r, nil
+}
+
+// skipToRange reads the range of an object from a reader of the whole object, of size bytes
+func skipToRange(reader io.ReadCloser, size int64, offset int64, length int64) (*ObjectRange, error) {
+	if size < 0 {
+		// the size is needed to locate the range, read the object in memory
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		size = int64(len(content))
+		reader = io.NopCloser(bytes.NewReader(content))
+	}
+	r, err := clampRange(size, offset, length)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, reader, r.Offset); err != nil {
+		reader.Close()
+		return nil, err
+	}
+	r.ReadCloser = limitReadCloser(reader, r.Length)
+	return r, nil
+}
+
+// clampRange locates a range in an object of size bytes, cut at its end, without its reader
+func clampRange(size int64, offset int64, length int64) (*ObjectRange, error) {
+	if offset < 0 {
+		offset += size
+		if offset < 0 {
+			offset = 0
+		}
+		length = size - offset
+	}
+	if offset >= size {
+		return nil, &RangeNotSatisfiableError{Size: size}
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	return &ObjectRange{Offset: offset, Length: length, Size: size}, nil
+}
+
+func limitReadCloser(reader io.ReadCloser, n int64) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(reader, n), reader}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/stretchr/testify/suite"
+)
+
+type RangeTestSuite struct {
+	suite.Suite
+}
+
+func (suite *RangeTestSuite) readRange(backend Backend, offset int64, length int64) (string, *ObjectRange) {
+	r, err := GetObjectRange(backend, "mychart-0.1.0.tgz", offset, length)
+	suite.Nil(err, "no error reading %d bytes from %d", length, offset)
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	suite.Nil(err, "no error reading the range")
+	return string(content), r
+}
+
+// checkRanges reads ranges of "mychart" from backend
+func (suite *RangeTestSuite) checkRanges(backend Backend) {
+	content, r := suite.readRange(backend, 2, 3)
+	suite.Equal("cha", content)
+	suite.Equal(ObjectRange{Offset: 2, Length: 3, Size: 7}, ObjectRange{Offset: r.Offset, Length: r.Length, Size: r.Size})
+
+	content, r = suite.readRange(backend, 4, -1)
+	suite.Equal("art", content, "up to the end")
+	suite.Equal(int64(3), r.Length)
+
+	content, r = suite.readRange(backend, 5, 10)
+	suite.Equal("rt", content, "cut at the end")
+	suite.Equal(int64(2), r.Length)
+
+	content, r = suite.readRange(backend, -4, -1)
+	suite.Equal("hart", content, "suffix")
+	suite.Equal(int64(3), r.Offset)
+
+	content, _ = suite.readRange(backend, -10, -1)
+	suite.Equal("mychart", content, "suffix longer than the object")
+
+	_, err := GetObjectRange(backend, "mychart-0.1.0.tgz", 7, -1)
+	var notSatisfiable *RangeNotSatisfiableError
+	suite.True(errors.As(err, &notSatisfiable), "range past the end")
+	suite.Equal(int64(7), notSatisfiable.Size)
+
+	_, err = GetObjectRange(backend, "otherchart-0.1.0.tgz", 0, -1)
+	suite.NotNil(err, "missing object")
+	suite.False(errors.As(err, &notSatisfiable), "missing object, not a range past the end")
+}
+
+func (suite *RangeTestSuite) TestLocal() {
+	backend := NewLocalFilesystemBackend(suite.T().TempDir(), false)
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("mychart")))
+	suite.checkRanges(backend)
+	suite.checkRanges(backend.LocalFilesystemBackend)
+
+	_, err := GetObjectRange(backend, "mychart-0.1.0.tgz", 0, 0)
+	suite.NotNil(err, "empty range")
+}
+
+func (suite *RangeTestSuite) TestFallback() {
+	backend := bufferedBackend{NewLocalFilesystemBackend(suite.T().TempDir(), false)}
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("mychart")))
+	suite.checkRanges(backend)
+}
+
+func (suite *RangeTestSuite) TestWrappers() {
+	local := NewLocalFilesystemBackend(suite.T().TempDir(), false)
+	suite.Nil(local.PutObject("mychart-0.1.0.tgz", []byte("mychart")))
+
+	breaker := NewCircuitBreakerBackend(local, CircuitBreakerOptions{Threshold: 1, Cooldown: time.Hour})
+	suite.checkRanges(breaker)
+	suite.checkRanges(breaker)
+	_, _, err := GetObjectStream(breaker, "mychart-0.1.0.tgz")
+	suite.Nil(err, "circuit closed by ranges past the end")
+
+	suite.checkRanges(NewRetryBackend(local, RetryOptions{Attempts: 3, Backoff: time.Hour}))
+}
+
+func (suite *RangeTestSuite) TestAmazonS3() {
+	suite.T().Setenv("AWS_ACCESS_KEY_ID", "x")
+	suite.T().Setenv("AWS_SECRET_ACCESS_KEY", "x")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/charts/prefix/mychart-0.1.0.tgz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("mychart"))
+	}))
+	defer server.Close()
+	backend := cm_storage.NewAmazonS3Backend("charts", "prefix", "us-east-1", server.URL, "")
+
+	content, r := suite.readRange(backend, 2, 3)
+	suite.Equal("cha", content)
+	suite.Equal(int64(2), r.Offset)
+	suite.Equal(int64(3), r.Length)
+	suite.Equal(int64(7), r.Size)
+
+	content, r = suite.readRange(backend, -4, -1)
+	suite.Equal("hart", content, "suffix")
+	suite.Equal(int64(3), r.Offset)
+
+	_, err := GetObjectRange(backend, "mychart-0.1.0.tgz", 7, -1)
+	var notSatisfiable *RangeNotSatisfiableError
+	suite.True(errors.As(err, &notSatisfiable), "range past the end")
+}
+
+func TestRangeTestSuite(t *testing.T) {
+	suite.Run(t, new(RangeTestSuite))
+}
@@ -0,0 +1,170 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package storage lets external code plug its own storage backend into ChartMuseum.
+//
+// A backend implementation registers a Factory for a URL scheme, usually from an init
+// function, and is selected with the storage URL option:
+//
+//	func init() {
+//		storage.Register("objstore", func(u *url.URL) (storage.Backend, error) {
+//			return newObjstoreBackend(u.Host, u.Path)
+//		})
+//	}
+//
+//	chartmuseum --storage-url objstore://store.example.com/charts
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	cm_storage "github.com/chartmuseum/storage"
+)
+
+type (
+	// Backend is the interface a storage backend must satisfy.
+	//
+	// Objects are addressed by slash-separated paths relative to the root of the backend,
+	// such as "mychart-0.1.0.tgz" or "myrepo/mychart-0.1.0.tgz" with multitenancy.
+	//
+	// ListObjects returns every object under prefix, with Path relative to prefix and
+	// LastModified set, which the server compares to detect changes. Content may be left
+	// empty. Objects in nested directories should not be listed.
+	//
+	// GetObject returns the object with Path, Content and LastModified set. A missing
+	// object must be reported with an error, preferably wrapping fs.ErrNotExist so it
+	// can be told apart with IsNotFound. The server answers 404 to any GetObject error.
+	//
+	// PutObject creates or replaces an object. DeleteObject removes an object.
+	//
+	// Errors other than not-found are considered transient: the server logs them and
+	// fails the request with a 500, and the operation is retried on the next request or
+	// cache refresh. Implementations must be safe for concurrent use.
+	Backend = cm_storage.Backend
+
+	// Object is a generic representation of a storage object
+	Object = cm_storage.Object
+
+	// AlternateURLer is implemented by backends which can serve an object from a location
+	// other than the chart server, such as a CDN or a content-addressed network.
+	// AlternateURL returns false if the location of the object at path is not known
+	AlternateURLer interface {
+		AlternateURL(path string) (string, bool)
+	}
+
+	// Factory creates a backend from a storage URL
+	Factory func(u *url.URL) (Backend, error)
+)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = map[string]Factory{}
+)
+
+func init() {
+	Register("file", func(u *url.URL) (Backend, error) {
+		if u.Path == "" {
+			return nil, errors.New("missing path in file storage URL")
+		}
+		return NewLocalFilesystemBackend(u.Path, u.Query().Get("fsync") == "true"), nil
+	})
+}
+
+// Register makes a backend available for the given URL scheme. It panics if the scheme
+// is empty, the factory is nil or a backend is already registered for the scheme
+func Register(scheme string, factory Factory) {
+	scheme = strings.ToLower(scheme)
+	if scheme == "" {
+		panic("storage: Register with empty scheme")
+	}
+	if factory == nil {
+		panic("storage: Register factory is nil for scheme " + scheme)
+	}
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	if _, dup := factories[scheme]; dup {
+		panic("storage: Register called twice for scheme " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// Schemes returns the sorted list of registered schemes
+func Schemes() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open creates the backend registered for the scheme of a storage URL
+func Open(rawURL string) (Backend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage URL: %s", err)
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("missing scheme in storage URL: %s", rawURL)
+	}
+	factoriesLock.RLock()
+	factory, ok := factories[u.Scheme]
+	factoriesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no storage backend registered for scheme %s (registered: %s)", u.Scheme, strings.Join(Schemes(), ", "))
+	}
+	return factory(u)
+}
+
+// AlternateURL returns the location the backend serves the object at path from, with the backends
+// implementing AlternateURLer. Wrapping backends reading from another backend return the location
+// of the wrapped backend, except encrypting ones, whose objects are not served decrypted elsewhere
+func AlternateURL(backend Backend, path string) (string, bool) {
+	for {
+		switch b := backend.(type) {
+		case AlternateURLer:
+			return b.AlternateURL(path)
+		case *ReplicatedBackend:
+			backend = b.Backend
+		case *TieredBackend:
+			backend = b.Backend
+		case *RetryBackend:
+			backend = b.Backend
+		case *CircuitBreakerBackend:
+			backend = b.Backend
+		default:
+			return "", false
+		}
+	}
+}
+
+// IsNotFound reports whether an error returned by a backend means the object does not exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// IsExist reports whether an error returned by a backend means the object already exists
+func IsExist(err error) bool {
+	return errors.Is(err, fs.ErrExist)
+}
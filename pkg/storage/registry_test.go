@@ -0,0 +1,84 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/stretchr/testify/suite"
+)
+
+type RegistryTestSuite struct {
+	suite.Suite
+}
+
+func (suite *RegistryTestSuite) TestRegister() {
+	var opened *url.URL
+	Register("Mystore", func(u *url.URL) (Backend, error) {
+		opened = u
+		return cm_storage.NewLocalFilesystemBackend(suite.T().TempDir()), nil
+	})
+	suite.Contains(Schemes(), "mystore", "scheme registered lowercase")
+	suite.Contains(Schemes(), "file", "file scheme registered by default")
+
+	backend, err := Open("mystore://host.example.com/charts?region=x")
+	suite.Nil(err, "no error opening registered scheme")
+	suite.NotNil(backend, "backend returned")
+	suite.Equal("host.example.com", opened.Host, "URL passed to factory")
+	suite.Equal("/charts", opened.Path, "URL passed to factory")
+	suite.Equal("x", opened.Query().Get("region"), "URL passed to factory")
+
+	suite.Panics(func() {
+		Register("mystore", func(u *url.URL) (Backend, error) { return nil, nil })
+	}, "duplicate scheme")
+	suite.Panics(func() { Register("", func(u *url.URL) (Backend, error) { return nil, nil }) }, "empty scheme")
+	suite.Panics(func() { Register("nilstore", nil) }, "nil factory")
+}
+
+func (suite *RegistryTestSuite) TestOpen() {
+	_, err := Open("garage://x")
+	suite.NotNil(err, "error opening unregistered scheme")
+	_, err = Open("/var/lib/charts")
+	suite.NotNil(err, "error opening URL without scheme")
+	_, err = Open("file://")
+	suite.NotNil(err, "error opening file URL without path")
+
+	dir := suite.T().TempDir()
+	backend, err := Open("file://" + dir)
+	suite.Nil(err, "no error opening file URL")
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("content")), "no error putting object")
+	_, err = os.Stat(dir + "/mychart-0.1.0.tgz")
+	suite.Nil(err, "object stored in the URL path")
+}
+
+func (suite *RegistryTestSuite) TestIsNotFound() {
+	backend := cm_storage.NewLocalFilesystemBackend(suite.T().TempDir())
+	_, err := backend.GetObject("missing.tgz")
+	suite.True(IsNotFound(err), "missing local object is not found")
+	suite.True(IsNotFound(fmt.Errorf("get: %w", os.ErrNotExist)), "wrapped not found")
+	suite.False(IsNotFound(errors.New("connection reset")), "transient error")
+	suite.False(IsNotFound(nil), "no error")
+}
+
+func TestRegistryTestSuite(t *testing.T) {
+	suite.Run(t, new(RegistryTestSuite))
+}
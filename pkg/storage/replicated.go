@@ -0,0 +1,226 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	pathutil "path"
+	"sort"
+	"sync"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+
+	cm_storage "github.com/chartmuseum/storage"
+)
+
+// defaultReplicationQueueSize is the number of writes waiting to be replicated before new
+// writes are left to the reconciliation
+const defaultReplicationQueueSize = 1000
+
+type (
+	// ReplicatedBackend copies every write to the wrapped backend, the primary, to a secondary
+	// backend in the background. Reads are served by the primary only.
+	//
+	// Writes are acknowledged once the primary succeeds. Replication failures are logged, and
+	// repaired by the reconciliation, which copies the objects of the primary missing or older
+	// in the secondary and removes the objects missing in the primary.
+	ReplicatedBackend struct {
+		Backend
+		Secondary Backend
+		Logger    *cm_logger.Logger
+
+		queue        chan replicationOp
+		done         chan struct{}
+		closeOnce    sync.Once
+		wg           sync.WaitGroup
+		prefixesLock sync.Mutex
+		prefixes     map[string]struct{}
+	}
+
+	// ReplicationOptions are optional settings of a ReplicatedBackend
+	ReplicationOptions struct {
+		// QueueSize is the number of writes waiting to be replicated, 1000 if zero
+		QueueSize int
+		// ReconcileInterval is the time between reconciliations, 0 disables them
+		ReconcileInterval time.Duration
+		// Logger reports replication failures, they are ignored if nil
+		Logger *cm_logger.Logger
+	}
+
+	replicationOp struct {
+		path    string
+		content []byte
+		delete  bool
+	}
+)
+
+// NewReplicatedBackend creates a new instance of ReplicatedBackend and starts replicating
+// to secondary, until Close is called
+func NewReplicatedBackend(primary Backend, secondary Backend, options ReplicationOptions) *ReplicatedBackend {
+	if options.QueueSize <= 0 {
+		options.QueueSize = defaultReplicationQueueSize
+	}
+	b := &ReplicatedBackend{
+		Backend:   primary,
+		Secondary: secondary,
+		Logger:    options.Logger,
+		queue:     make(chan replicationOp, options.QueueSize),
+		done:      make(chan struct{}),
+		prefixes:  map[string]struct{}{"": {}},
+	}
+	b.wg.Add(1)
+	go b.replicate()
+	if options.ReconcileInterval > 0 {
+		b.wg.Add(1)
+		go b.reconcileEvery(options.ReconcileInterval)
+	}
+	return b
+}
+
+// PutObject puts an object in the primary, and queues its replication
+func (b *ReplicatedBackend) PutObject(path string, content []byte) error {
+	if err := b.Backend.PutObject(path, content); err != nil {
+		return err
+	}
+	b.enqueue(replicationOp{path: path, content: content})
+	return nil
+}
+
+// DeleteObject removes an object from the primary, and queues its removal from the secondary
+func (b *ReplicatedBackend) DeleteObject(path string) error {
+	if err := b.Backend.DeleteObject(path); err != nil {
+		return err
+	}
+	b.enqueue(replicationOp{path: path, delete: true})
+	return nil
+}
+
+// Reconcile makes the objects of the secondary at prefix match the primary
+func (b *ReplicatedBackend) Reconcile(prefix string) error {
+	primaryObjects, err := b.Backend.ListObjects(prefix)
+	if err != nil {
+		return err
+	}
+	secondaryObjects, err := b.Secondary.ListObjects(prefix)
+	if err != nil {
+		return err
+	}
+	diff := cm_storage.GetObjectSliceDiff(secondaryObjects, primaryObjects, 0)
+	for _, object := range append(diff.Added, diff.Updated...) {
+		path := pathutil.Join(prefix, object.Path)
+		primaryObject, err := b.Backend.GetObject(path)
+		if err != nil {
+			if IsNotFound(err) { // deleted since the listing
+				continue
+			}
+			return err
+		}
+		if err := b.Secondary.PutObject(path, primaryObject.Content); err != nil {
+			return err
+		}
+	}
+	for _, object := range diff.Removed {
+		path := pathutil.Join(prefix, object.Path)
+		if err := b.Secondary.DeleteObject(path); err != nil && !IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the replication, writes still queued are left to the next reconciliation
+func (b *ReplicatedBackend) Close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+	b.wg.Wait()
+}
+
+func (b *ReplicatedBackend) enqueue(op replicationOp) {
+	b.prefixesLock.Lock()
+	b.prefixes[pathutil.Dir(pathutil.Join("/", op.path))[1:]] = struct{}{}
+	b.prefixesLock.Unlock()
+	select {
+	case b.queue <- op:
+	default:
+		b.warn("Replication queue full, leaving write to reconciliation", op.path, nil)
+	}
+}
+
+func (b *ReplicatedBackend) replicate() {
+	defer b.wg.Done()
+	for {
+		select {
+		case <-b.done:
+			return
+		case op := <-b.queue:
+			var err error
+			if op.delete {
+				err = b.Secondary.DeleteObject(op.path)
+				if IsNotFound(err) {
+					err = nil
+				}
+			} else {
+				err = b.Secondary.PutObject(op.path, op.content)
+			}
+			if err != nil {
+				b.warn("Error replicating write, leaving it to reconciliation", op.path, err)
+			}
+		}
+	}
+}
+
+func (b *ReplicatedBackend) reconcileEvery(interval time.Duration) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		b.reconcileAll()
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcileAll reconciles the root and every directory written to since startup
+func (b *ReplicatedBackend) reconcileAll() {
+	b.prefixesLock.Lock()
+	prefixes := make([]string, 0, len(b.prefixes))
+	for prefix := range b.prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	b.prefixesLock.Unlock()
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		if err := b.Reconcile(prefix); err != nil {
+			b.warn("Error reconciling replica", prefix, err)
+		}
+	}
+}
+
+func (b *ReplicatedBackend) warn(msg string, path string, err error) {
+	if b.Logger == nil {
+		return
+	}
+	if err != nil {
+		b.Logger.Warnw(msg, "path", path, "error", err.Error())
+		return
+	}
+	b.Logger.Warnw(msg, "path", path)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"os"
+	pathutil "path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// failingBackend fails writes while down is set
+type failingBackend struct {
+	Backend
+	down atomic.Bool
+}
+
+func (b *failingBackend) PutObject(path string, content []byte) error {
+	if b.down.Load() {
+		return errors.New("secondary down")
+	}
+	return b.Backend.PutObject(path, content)
+}
+
+type ReplicatedTestSuite struct {
+	suite.Suite
+	Primary   Backend
+	Secondary *failingBackend
+}
+
+func (suite *ReplicatedTestSuite) SetupTest() {
+	suite.Primary = NewLocalFilesystemBackend(suite.T().TempDir(), false)
+	suite.Secondary = &failingBackend{Backend: NewLocalFilesystemBackend(suite.T().TempDir(), false)}
+}
+
+func (suite *ReplicatedTestSuite) secondaryContent(path string) func() string {
+	return func() string {
+		object, err := suite.Secondary.GetObject(path)
+		if err != nil {
+			return ""
+		}
+		return string(object.Content)
+	}
+}
+
+func (suite *ReplicatedTestSuite) TestReplication() {
+	backend := NewReplicatedBackend(suite.Primary, suite.Secondary, ReplicationOptions{})
+	defer backend.Close()
+
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("v1")), "no error putting object")
+	suite.Nil(backend.PutObject("myrepo/mychart-0.1.0.tgz", []byte("nested")), "no error putting nested object")
+	suite.Eventually(func() bool { return suite.secondaryContent("mychart-0.1.0.tgz")() == "v1" },
+		time.Second, 10*time.Millisecond, "object replicated")
+	suite.Eventually(func() bool { return suite.secondaryContent("myrepo/mychart-0.1.0.tgz")() == "nested" },
+		time.Second, 10*time.Millisecond, "nested object replicated")
+
+	object, err := backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "object read from primary")
+	suite.Equal([]byte("v1"), object.Content)
+
+	suite.Nil(backend.DeleteObject("mychart-0.1.0.tgz"), "no error deleting object")
+	suite.Eventually(func() bool { return suite.secondaryContent("mychart-0.1.0.tgz")() == "" },
+		time.Second, 10*time.Millisecond, "delete replicated")
+
+	suite.True(IsNotFound(backend.DeleteObject("mychart-0.1.0.tgz")), "primary errors returned")
+}
+
+func (suite *ReplicatedTestSuite) TestReconcile() {
+	backend := NewReplicatedBackend(suite.Primary, suite.Secondary, ReplicationOptions{})
+	defer backend.Close()
+
+	suite.Secondary.down.Store(true)
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("v1")), "primary write acknowledged while secondary is down")
+	suite.Nil(suite.Primary.PutObject("mychart-0.2.0.tgz", []byte("v2")))
+	suite.Nil(suite.Secondary.Backend.PutObject("orphan-0.1.0.tgz", []byte("x")))
+	suite.Error(backend.Reconcile(""), "reconciliation fails while secondary is down")
+
+	suite.Secondary.down.Store(false)
+	suite.Nil(backend.Reconcile(""), "no error reconciling")
+	suite.Equal("v1", suite.secondaryContent("mychart-0.1.0.tgz")(), "failed write repaired")
+	suite.Equal("v2", suite.secondaryContent("mychart-0.2.0.tgz")(), "missing object copied")
+	suite.Equal("", suite.secondaryContent("orphan-0.1.0.tgz")(), "object missing in primary removed")
+
+	// primary modified after the copy
+	suite.Nil(suite.Primary.PutObject("mychart-0.2.0.tgz", []byte("v2 fixed")))
+	later := time.Now().Add(time.Minute)
+	suite.Nil(os.Chtimes(pathutil.Join(suite.Primary.(*LocalFilesystemBackend).RootDirectory, "mychart-0.2.0.tgz"), later, later))
+	suite.Nil(backend.Reconcile(""), "no error reconciling")
+	suite.Equal("v2 fixed", suite.secondaryContent("mychart-0.2.0.tgz")(), "older object updated")
+}
+
+func (suite *ReplicatedTestSuite) TestReconcileInterval() {
+	suite.Secondary.down.Store(true)
+	backend := NewReplicatedBackend(suite.Primary, suite.Secondary, ReplicationOptions{ReconcileInterval: 10 * time.Millisecond})
+	defer backend.Close()
+
+	suite.Nil(backend.PutObject("myrepo/mychart-0.1.0.tgz", []byte("v1")))
+	suite.Secondary.down.Store(false)
+	suite.Eventually(func() bool { return suite.secondaryContent("myrepo/mychart-0.1.0.tgz")() == "v1" },
+		time.Second, 10*time.Millisecond, "directory written to reconciled periodically")
+}
+
+func TestReplicatedTestSuite(t *testing.T) {
+	suite.Run(t, new(ReplicatedTestSuite))
+}
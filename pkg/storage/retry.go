@@ -0,0 +1,210 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 5 * time.Second
+)
+
+// ErrStorageTimeout is returned when a storage operation takes longer than the timeout of a
+// RetryBackend
+var ErrStorageTimeout = errors.New("storage operation timed out")
+
+type (
+	// RetryBackend retries the operations of the wrapped backend which fail, waiting longer
+	// after each failure (exponential backoff with jitter), so that transient errors such as
+	// throttling do not fail requests. Missing objects are not retried.
+	RetryBackend struct {
+		Backend
+		RetryOptions
+	}
+
+	// RetryOptions are the settings of a RetryBackend
+	RetryOptions struct {
+		// Attempts is the number of times an operation is tried, at least 1
+		Attempts int
+		// Backoff is the wait after the first failure, doubled after each failure, 100ms if zero
+		Backoff time.Duration
+		// MaxBackoff caps the wait between attempts, 5s if zero
+		MaxBackoff time.Duration
+		// Timeout fails an attempt taking longer, 0 disables it. The backend has no way to cancel
+		// an operation, which completes in the background
+		Timeout time.Duration
+		// Logger reports retried failures, they are not logged if nil
+		Logger *cm_logger.Logger
+	}
+)
+
+// NewRetryBackend creates a new instance of RetryBackend
+func NewRetryBackend(backend Backend, options RetryOptions) *RetryBackend {
+	if options.Attempts < 1 {
+		options.Attempts = 1
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = defaultRetryBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = defaultRetryMaxBackoff
+	}
+	return &RetryBackend{Backend: backend, RetryOptions: options}
+}
+
+// ListObjects lists objects of the wrapped backend, retrying failures
+func (b *RetryBackend) ListObjects(prefix string) ([]Object, error) {
+	return retry(b, "list", prefix, func(int) ([]Object, error) {
+		return b.Backend.ListObjects(prefix)
+	})
+}
+
+// ListObjectsPage lists a page of objects of the wrapped backend, retrying failures
+func (b *RetryBackend) ListObjectsPage(prefix string, token string, limit int) ([]Object, string, error) {
+	type page struct {
+		objects []Object
+		next    string
+	}
+	p, err := retry(b, "list", prefix, func(int) (page, error) {
+		objects, next, err := ListObjectsPage(b.Backend, prefix, token, limit)
+		return page{objects, next}, err
+	})
+	return p.objects, p.next, err
+}
+
+// GetObject retrieves an object of the wrapped backend, retrying failures
+func (b *RetryBackend) GetObject(path string) (Object, error) {
+	return retry(b, "get", path, func(int) (Object, error) {
+		return b.Backend.GetObject(path)
+	})
+}
+
+// GetObjectStream opens an object of the wrapped backend, retrying failures to open it. Reading
+// the content is not retried
+func (b *RetryBackend) GetObjectStream(path string) (io.ReadCloser, int64, error) {
+	type stream struct {
+		reader io.ReadCloser
+		size   int64
+	}
+	s, err := retry(b, "get", path, func(int) (stream, error) {
+		reader, size, err := GetObjectStream(b.Backend, path)
+		return stream{reader, size}, err
+	})
+	return s.reader, s.size, err
+}
+
+// GetObjectRange opens a range of an object of the wrapped backend, retrying failures to open it
+// other than a range not satisfiable. Reading the content is not retried
+func (b *RetryBackend) GetObjectRange(path string, offset int64, length int64) (*ObjectRange, error) {
+	var notSatisfiable *RangeNotSatisfiableError
+	r, err := retry(b, "get", path, func(int) (*ObjectRange, error) {
+		r, err := GetObjectRange(b.Backend, path, offset, length)
+		if errors.As(err, &notSatisfiable) {
+			return nil, nil
+		}
+		return r, err
+	})
+	if err == nil && notSatisfiable != nil {
+		return nil, notSatisfiable
+	}
+	return r, err
+}
+
+// PutObject puts an object in the wrapped backend, retrying failures
+func (b *RetryBackend) PutObject(path string, content []byte) error {
+	_, err := retry(b, "put", path, func(int) (struct{}, error) {
+		return struct{}{}, b.Backend.PutObject(path, content)
+	})
+	return err
+}
+
+// DeleteObject removes an object from the wrapped backend, retrying failures. An object missing
+// after a failed attempt was deleted by that attempt
+func (b *RetryBackend) DeleteObject(path string) error {
+	_, err := retry(b, "delete", path, func(attempt int) (struct{}, error) {
+		err := b.Backend.DeleteObject(path)
+		if attempt > 1 && IsNotFound(err) {
+			err = nil
+		}
+		return struct{}{}, err
+	})
+	return err
+}
+
+func retry[T any](b *RetryBackend, op string, path string, fn func(attempt int) (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := tryWithTimeout(b.Timeout, attempt, fn)
+		if err == nil || IsNotFound(err) || attempt >= b.Attempts {
+			return result, err
+		}
+		backoff := b.backoff(attempt)
+		if b.Logger != nil {
+			b.Logger.Warnw("Storage operation failed, retrying",
+				"operation", op,
+				"path", path,
+				"attempt", attempt,
+				"backoff", backoff.String(),
+				"error", err.Error(),
+			)
+		}
+		time.Sleep(backoff)
+	}
+}
+
+// tryWithTimeout runs fn, giving up after timeout if positive
+func tryWithTimeout[T any](timeout time.Duration, attempt int, fn func(attempt int) (T, error)) (T, error) {
+	if timeout <= 0 {
+		return fn(attempt)
+	}
+	type outcome struct {
+		result T
+		err    error
+	}
+	done := make(chan outcome, 1) // buffered, an abandoned attempt does not block
+	go func() {
+		result, err := fn(attempt)
+		done <- outcome{result, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-timer.C:
+		var zero T
+		return zero, ErrStorageTimeout
+	}
+}
+
+// backoff is the wait after a failed attempt, between half and all of the exponential backoff
+func (b *RetryBackend) backoff(attempt int) time.Duration {
+	backoff := b.Backoff
+	for i := 1; i < attempt && backoff < b.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > b.MaxBackoff {
+		backoff = b.MaxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
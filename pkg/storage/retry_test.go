@@ -0,0 +1,166 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RetryTestSuite struct {
+	suite.Suite
+	Flaky   *flakyBackend
+	Backend *RetryBackend
+}
+
+// flakyBackend fails the next failures operations, and delays all of them
+type flakyBackend struct {
+	Backend
+	lock     sync.Mutex
+	failures int
+	calls    int
+	delay    time.Duration
+}
+
+func (b *flakyBackend) fail() error {
+	b.lock.Lock()
+	b.calls++
+	delay := b.delay
+	fail := b.failures > 0
+	if fail {
+		b.failures--
+	}
+	b.lock.Unlock()
+	time.Sleep(delay)
+	if fail {
+		return errors.New("503 SlowDown")
+	}
+	return nil
+}
+
+func (b *flakyBackend) ListObjects(prefix string) ([]Object, error) {
+	if err := b.fail(); err != nil {
+		return nil, err
+	}
+	return b.Backend.ListObjects(prefix)
+}
+
+func (b *flakyBackend) GetObject(path string) (Object, error) {
+	if err := b.fail(); err != nil {
+		return Object{}, err
+	}
+	return b.Backend.GetObject(path)
+}
+
+func (b *flakyBackend) PutObject(path string, content []byte) error {
+	if err := b.fail(); err != nil {
+		return err
+	}
+	return b.Backend.PutObject(path, content)
+}
+
+func (b *flakyBackend) DeleteObject(path string) error {
+	if err := b.fail(); err != nil {
+		// the object is deleted, but the response is lost
+		b.Backend.DeleteObject(path)
+		return err
+	}
+	return b.Backend.DeleteObject(path)
+}
+
+func (b *flakyBackend) set(failures int, delay time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures, b.delay, b.calls = failures, delay, 0
+}
+
+func (suite *RetryTestSuite) SetupTest() {
+	suite.Flaky = &flakyBackend{Backend: NewMemoryBackend()}
+	suite.Backend = NewRetryBackend(suite.Flaky, RetryOptions{Attempts: 3, Backoff: time.Millisecond})
+}
+
+func (suite *RetryTestSuite) TestRetries() {
+	suite.Flaky.set(2, 0)
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("mychart")), "put succeeds on third attempt")
+	suite.Equal(3, suite.Flaky.calls)
+
+	suite.Flaky.set(2, 0)
+	object, err := suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "get succeeds on third attempt")
+	suite.Equal("mychart", string(object.Content))
+
+	suite.Flaky.set(1, 0)
+	objects, err := suite.Backend.ListObjects("")
+	suite.Nil(err, "list succeeds on second attempt")
+	suite.Len(objects, 1)
+
+	suite.Flaky.set(1, 0)
+	reader, _, err := GetObjectStream(suite.Backend, "mychart-0.1.0.tgz")
+	suite.Nil(err, "stream opened on second attempt")
+	content, _ := io.ReadAll(reader)
+	suite.Equal("mychart", string(content))
+
+	suite.Flaky.set(3, 0)
+	_, err = suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.EqualError(err, "503 SlowDown", "last error after all attempts")
+	suite.Equal(3, suite.Flaky.calls)
+}
+
+func (suite *RetryTestSuite) TestNotFound() {
+	suite.Flaky.set(0, 0)
+	_, err := suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.True(IsNotFound(err), "missing object")
+	suite.Equal(1, suite.Flaky.calls, "missing object not retried")
+
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("mychart")))
+	suite.Flaky.set(1, 0)
+	suite.Nil(suite.Backend.DeleteObject("mychart-0.1.0.tgz"), "object deleted by failed attempt")
+	suite.Equal(2, suite.Flaky.calls)
+	suite.True(IsNotFound(suite.Backend.DeleteObject("mychart-0.1.0.tgz")), "missing object on first attempt")
+}
+
+func (suite *RetryTestSuite) TestTimeout() {
+	backend := NewRetryBackend(suite.Flaky, RetryOptions{Attempts: 2, Backoff: time.Millisecond, Timeout: 20 * time.Millisecond})
+	suite.Flaky.set(0, 100*time.Millisecond)
+	start := time.Now()
+	_, err := backend.ListObjects("")
+	suite.Equal(ErrStorageTimeout, err, "attempts time out")
+	suite.Less(time.Since(start), 100*time.Millisecond, "slow attempts abandoned")
+
+	suite.Flaky.set(0, 0)
+	_, err = backend.ListObjects("")
+	suite.Nil(err, "fast attempt")
+}
+
+func (suite *RetryTestSuite) TestBackoff() {
+	backend := NewRetryBackend(suite.Flaky, RetryOptions{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second})
+	suite.Equal(1, backend.Attempts, "one attempt by default")
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 10: time.Second} {
+		backoff := backend.backoff(attempt)
+		suite.GreaterOrEqual(backoff, max/2, "attempt %d", attempt)
+		suite.LessOrEqual(backoff, max, "attempt %d", attempt)
+	}
+}
+
+func TestRetryTestSuite(t *testing.T) {
+	suite.Run(t, new(RetryTestSuite))
+}
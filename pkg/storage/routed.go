@@ -0,0 +1,110 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"io"
+	pathutil "path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// RoutedBackend stores the objects under the prefix of a route in the backend of the
+	// route, and the other objects in the default backend, so that the repos of a tenant are
+	// isolated in their own storage. The longest matching prefix wins. Paths are not rewritten,
+	// "team-a/mychart-0.1.0.tgz" is stored as "team-a/mychart-0.1.0.tgz" in the backend of team-a
+	RoutedBackend struct {
+		Backend
+		Routes []Route
+	}
+
+	// Route sends the objects under Prefix to Backend
+	Route struct {
+		Prefix  string
+		Backend Backend
+	}
+)
+
+// NewRoutedBackend creates a new instance of RoutedBackend, routes mapping prefixes to backends
+func NewRoutedBackend(defaultBackend Backend, routes map[string]Backend) *RoutedBackend {
+	b := &RoutedBackend{Backend: defaultBackend}
+	for prefix, backend := range routes {
+		b.Routes = append(b.Routes, Route{Prefix: routedPath(prefix), Backend: backend})
+	}
+	sort.Slice(b.Routes, func(i, j int) bool {
+		return len(b.Routes[i].Prefix) > len(b.Routes[j].Prefix)
+	})
+	return b
+}
+
+// Route returns the backend storing the object at path
+func (b *RoutedBackend) Route(path string) Backend {
+	path = routedPath(path)
+	for _, route := range b.Routes {
+		if path == route.Prefix || strings.HasPrefix(path, route.Prefix+"/") {
+			return route.Backend
+		}
+	}
+	return b.Backend
+}
+
+// ListObjects lists the objects at prefix in the backend of prefix
+func (b *RoutedBackend) ListObjects(prefix string) ([]Object, error) {
+	return b.Route(prefix).ListObjects(prefix)
+}
+
+// ListObjectsPage lists a page of the objects at prefix in the backend of prefix
+func (b *RoutedBackend) ListObjectsPage(prefix string, token string, limit int) ([]Object, string, error) {
+	return ListObjectsPage(b.Route(prefix), prefix, token, limit)
+}
+
+// GetObject retrieves an object from the backend of its path
+func (b *RoutedBackend) GetObject(path string) (Object, error) {
+	return b.Route(path).GetObject(path)
+}
+
+// GetObjectStream reads an object from the backend of its path
+func (b *RoutedBackend) GetObjectStream(path string) (io.ReadCloser, int64, error) {
+	return GetObjectStream(b.Route(path), path)
+}
+
+// GetObjectRange reads a range of an object from the backend of its path
+func (b *RoutedBackend) GetObjectRange(path string, offset int64, length int64) (*ObjectRange, error) {
+	return GetObjectRange(b.Route(path), path, offset, length)
+}
+
+// PresignGetObject presigns an object with the backend of its path
+func (b *RoutedBackend) PresignGetObject(path string, expiry time.Duration) (string, error) {
+	return Presign(b.Route(path), path, expiry)
+}
+
+// PutObject puts an object in the backend of its path
+func (b *RoutedBackend) PutObject(path string, content []byte) error {
+	return b.Route(path).PutObject(path, content)
+}
+
+// DeleteObject removes an object from the backend of its path
+func (b *RoutedBackend) DeleteObject(path string) error {
+	return b.Route(path).DeleteObject(path)
+}
+
+// routedPath is path without leading, trailing or duplicate slashes
+func routedPath(path string) string {
+	return strings.Trim(pathutil.Clean("/"+path), "/")
+}
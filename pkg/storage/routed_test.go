@@ -0,0 +1,98 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RoutedTestSuite struct {
+	suite.Suite
+	Default *MemoryBackend
+	TeamA   *MemoryBackend
+	TeamAB  *MemoryBackend
+	Backend *RoutedBackend
+}
+
+func (suite *RoutedTestSuite) SetupTest() {
+	suite.Default = NewMemoryBackend()
+	suite.TeamA = NewMemoryBackend()
+	suite.TeamAB = NewMemoryBackend()
+	suite.Backend = NewRoutedBackend(suite.Default, map[string]Backend{
+		"/team-a/":   suite.TeamA,
+		"team-a/b":   suite.TeamAB,
+		"team-c/org": NewMemoryBackend(),
+	})
+}
+
+func (suite *RoutedTestSuite) TestRoutes() {
+	suite.Same(suite.TeamA, suite.Backend.Route("team-a"))
+	suite.Same(suite.TeamA, suite.Backend.Route("/team-a/mychart-0.1.0.tgz"), "leading slash ignored")
+	suite.Same(suite.TeamA, suite.Backend.Route("team-a/c/mychart-0.1.0.tgz"))
+	suite.Same(suite.TeamAB, suite.Backend.Route("team-a/b/mychart-0.1.0.tgz"), "longest prefix wins")
+	suite.Same(suite.Default, suite.Backend.Route("team-ab/mychart-0.1.0.tgz"), "prefix matches whole path segments")
+	suite.Same(suite.Default, suite.Backend.Route("mychart-0.1.0.tgz"))
+	suite.Same(suite.Default, suite.Backend.Route("team-c"), "parent of a route")
+}
+
+func (suite *RoutedTestSuite) TestObjects() {
+	for _, path := range []string{"mychart-0.1.0.tgz", "team-a/mychart-0.1.0.tgz", "team-a/b/mychart-0.1.0.tgz"} {
+		suite.Nil(suite.Backend.PutObject(path, []byte(path)), "no error putting %s", path)
+	}
+	_, err := suite.TeamA.GetObject("team-a/mychart-0.1.0.tgz")
+	suite.Nil(err, "stored in backend of route, path unchanged")
+	_, err = suite.Default.GetObject("team-a/mychart-0.1.0.tgz")
+	suite.True(IsNotFound(err), "not stored in default backend")
+
+	objects, err := suite.Backend.ListObjects("team-a")
+	suite.Nil(err, "no error listing route")
+	suite.Len(objects, 1)
+	objects, err = suite.Backend.ListObjects("")
+	suite.Nil(err, "no error listing default backend")
+	suite.Len(objects, 1)
+
+	object, err := suite.Backend.GetObject("team-a/b/mychart-0.1.0.tgz")
+	suite.Nil(err, "no error getting object")
+	suite.Equal("team-a/b/mychart-0.1.0.tgz", string(object.Content))
+
+	reader, _, err := GetObjectStream(suite.Backend, "team-a/mychart-0.1.0.tgz")
+	suite.Nil(err, "no error streaming object")
+	content, _ := io.ReadAll(reader)
+	reader.Close()
+	suite.Equal("team-a/mychart-0.1.0.tgz", string(content))
+
+	suite.Nil(suite.Backend.DeleteObject("team-a/b/mychart-0.1.0.tgz"), "no error deleting object")
+	_, err = suite.TeamAB.GetObject("team-a/b/mychart-0.1.0.tgz")
+	suite.True(IsNotFound(err), "deleted from backend of route")
+}
+
+func (suite *RoutedTestSuite) TestPresign() {
+	backend := NewRoutedBackend(suite.Default, map[string]Backend{"team-a": presignerBackend{suite.TeamA}})
+	signedURL, err := Presign(backend, "team-a/mychart-0.1.0.tgz", time.Minute)
+	suite.Nil(err, "no error presigning with backend of route")
+	suite.Equal("https://cdn.example.com/team-a/mychart-0.1.0.tgz?expiry=1m0s", signedURL)
+	_, err = Presign(backend, "mychart-0.1.0.tgz", time.Minute)
+	suite.Equal(ErrPresignUnsupported, err, "default backend cannot presign")
+}
+
+func TestRoutedTestSuite(t *testing.T) {
+	suite.Run(t, new(RoutedTestSuite))
+}
@@ -0,0 +1,271 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	pathutil "path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpTempPrefix marks files being uploaded, which are not listed
+const sftpTempPrefix = ".upload-"
+
+type (
+	// SFTPBackend is a storage backend for a directory of an SFTP server. Objects are
+	// written to a temporary file renamed once complete, so partial uploads are never listed
+	SFTPBackend struct {
+		Host     string
+		BasePath string
+
+		lock    sync.Mutex
+		client  *sftp.Client
+		connect func() (*sftp.Client, error)
+	}
+
+	// SFTPOptions are the SSH settings of an SFTPBackend. Either Password or PrivateKey
+	// must be set, and either KnownHostsFile or InsecureIgnoreHostKey
+	SFTPOptions struct {
+		User                  string
+		Password              string
+		PrivateKey            []byte
+		KnownHostsFile        string
+		InsecureIgnoreHostKey bool
+		Timeout               time.Duration
+	}
+)
+
+func init() {
+	// sftp://<user>:<password>@<host>[:port]/<base path>, with the private key file
+	// in SFTP_PRIVATE_KEY_FILE and the known hosts file in SFTP_KNOWN_HOSTS_FILE
+	Register("sftp", func(u *url.URL) (Backend, error) {
+		options := SFTPOptions{KnownHostsFile: os.Getenv("SFTP_KNOWN_HOSTS_FILE")}
+		if u.User != nil {
+			options.User = u.User.Username()
+			options.Password, _ = u.User.Password()
+		}
+		if keyFile := os.Getenv("SFTP_PRIVATE_KEY_FILE"); keyFile != "" {
+			key, err := os.ReadFile(keyFile)
+			if err != nil {
+				return nil, err
+			}
+			options.PrivateKey = key
+		}
+		return NewSFTPBackend(u.Host, u.Path, options)
+	})
+}
+
+// NewSFTPBackend creates a new instance of SFTPBackend. The server is connected to on first use
+func NewSFTPBackend(host string, basePath string, options SFTPOptions) (*SFTPBackend, error) {
+	if host == "" {
+		return nil, errors.New("missing sftp host")
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+	config := &ssh.ClientConfig{
+		User:    options.User,
+		Timeout: options.Timeout,
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if options.PrivateKey != nil {
+		signer, err := ssh.ParsePrivateKey(options.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sftp private key: %s", err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	if options.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(options.Password))
+	}
+	if len(config.Auth) == 0 {
+		return nil, errors.New("missing sftp password or private key")
+	}
+	switch {
+	case options.KnownHostsFile != "":
+		callback, err := knownhosts.New(options.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sftp known hosts file: %s", err)
+		}
+		config.HostKeyCallback = callback
+	case options.InsecureIgnoreHostKey:
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, errors.New("missing sftp known hosts file")
+	}
+
+	b := &SFTPBackend{
+		Host:     host,
+		BasePath: pathutil.Clean("/" + basePath),
+	}
+	b.connect = func() (*sftp.Client, error) {
+		conn, err := ssh.Dial("tcp", host, config)
+		if err != nil {
+			return nil, err
+		}
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return client, nil
+	}
+	return b, nil
+}
+
+// ListObjects lists all objects in a directory of the SFTP server, at prefix
+func (b *SFTPBackend) ListObjects(prefix string) ([]Object, error) {
+	var objects []Object
+	err := b.withClient(func(client *sftp.Client) error {
+		objects = nil
+		files, err := client.ReadDir(pathutil.Join(b.BasePath, prefix))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) { // OK if the directory doesnt exist yet
+				return nil
+			}
+			return err
+		}
+		for _, f := range files {
+			if f.IsDir() || strings.HasPrefix(f.Name(), sftpTempPrefix) {
+				continue
+			}
+			objects = append(objects, Object{Path: f.Name(), Content: []byte{}, LastModified: f.ModTime()})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+// GetObject retrieves an object from the SFTP server, at path
+func (b *SFTPBackend) GetObject(path string) (Object, error) {
+	object := Object{Path: path}
+	err := b.withClient(func(client *sftp.Client) error {
+		file, err := client.Open(pathutil.Join(b.BasePath, path))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		content, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		object.Content = content
+		object.LastModified = info.ModTime()
+		return nil
+	})
+	return object, err
+}
+
+// PutObject uploads an object to the SFTP server, at path. The content is written to
+// a temporary file in the same directory, then renamed over the object
+func (b *SFTPBackend) PutObject(path string, content []byte) error {
+	fullPath := pathutil.Join(b.BasePath, path)
+	dir := pathutil.Dir(fullPath)
+	tempPath := pathutil.Join(dir, sftpTempPrefix+pathutil.Base(fullPath)+"-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	return b.withClient(func(client *sftp.Client) error {
+		if err := client.MkdirAll(dir); err != nil {
+			return err
+		}
+		file, err := client.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(content)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = sftpReplace(client, tempPath, fullPath)
+		}
+		if err != nil {
+			client.Remove(tempPath)
+		}
+		return err
+	})
+}
+
+// DeleteObject removes an object from the SFTP server, at path
+func (b *SFTPBackend) DeleteObject(path string) error {
+	return b.withClient(func(client *sftp.Client) error {
+		return client.Remove(pathutil.Join(b.BasePath, path))
+	})
+}
+
+// withClient runs f with a connected client, connecting again and retrying once when
+// the connection was lost
+func (b *SFTPBackend) withClient(f func(client *sftp.Client) error) error {
+	for retried := false; ; retried = true {
+		client, err := b.getClient()
+		if err != nil {
+			return err
+		}
+		err = f(client)
+		if !errors.Is(err, sftp.ErrSSHFxConnectionLost) || retried {
+			return err
+		}
+		b.lock.Lock()
+		if b.client == client {
+			b.client = nil
+		}
+		b.lock.Unlock()
+		client.Close()
+	}
+}
+
+func (b *SFTPBackend) getClient() (*sftp.Client, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.client == nil {
+		client, err := b.connect()
+		if err != nil {
+			return nil, err
+		}
+		b.client = client
+	}
+	return b.client, nil
+}
+
+// sftpReplace renames a file over another atomically when the server supports the
+// posix-rename extension. Otherwise, the destination is removed first, so it is
+// briefly missing but never partial
+func sftpReplace(client *sftp.Client, oldname string, newname string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(oldname, newname)
+	}
+	if err := client.Remove(newname); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return client.Rename(oldname, newname)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"io"
+	"os"
+	pathutil "path"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/suite"
+)
+
+type SFTPTestSuite struct {
+	suite.Suite
+	RootDirectory string
+	Backend       *SFTPBackend
+	Connections   int
+}
+
+// pipeSFTPServer serves the local filesystem over pipes, in place of an SSH connection
+func (suite *SFTPTestSuite) pipeSFTPServer() (*sftp.Client, error) {
+	suite.Connections++
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		server.Serve()
+		serverWriter.Close()
+	}()
+	return sftp.NewClientPipe(clientReader, clientWriter)
+}
+
+func (suite *SFTPTestSuite) SetupTest() {
+	suite.RootDirectory = suite.T().TempDir()
+	backend, err := NewSFTPBackend("localhost", suite.RootDirectory, SFTPOptions{User: "user", Password: "x", InsecureIgnoreHostKey: true})
+	suite.Nil(err, "no error creating sftp backend")
+	backend.connect = suite.pipeSFTPServer
+	suite.Backend = backend
+	suite.Connections = 0
+}
+
+func (suite *SFTPTestSuite) TestNewSFTPBackend() {
+	_, err := NewSFTPBackend("", "/charts", SFTPOptions{User: "user", Password: "x", InsecureIgnoreHostKey: true})
+	suite.NotNil(err, "error without host")
+	_, err = NewSFTPBackend("localhost", "/charts", SFTPOptions{User: "user", InsecureIgnoreHostKey: true})
+	suite.NotNil(err, "error without password or key")
+	_, err = NewSFTPBackend("localhost", "/charts", SFTPOptions{User: "user", Password: "x"})
+	suite.NotNil(err, "error without known hosts")
+	_, err = NewSFTPBackend("localhost", "/charts", SFTPOptions{User: "user", PrivateKey: []byte("x"), InsecureIgnoreHostKey: true})
+	suite.NotNil(err, "error with invalid private key")
+
+	backend, err := NewSFTPBackend("localhost", "charts/", SFTPOptions{User: "user", Password: "x", InsecureIgnoreHostKey: true})
+	suite.Nil(err, "no error creating sftp backend")
+	suite.Equal("localhost:22", backend.Host, "default port")
+	suite.Equal("/charts", backend.BasePath, "clean base path")
+}
+
+func (suite *SFTPTestSuite) TestObjects() {
+	objects, err := suite.Backend.ListObjects("myrepo")
+	suite.Nil(err, "no error listing a missing directory")
+	suite.Empty(objects, "no objects in a missing directory")
+
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("content")), "no error putting object")
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("overwritten")), "no error overwriting object")
+	suite.Nil(suite.Backend.PutObject("myrepo/mychart-0.2.0.tgz", []byte("nested")), "no error putting nested object")
+	// a partial upload left behind
+	suite.Nil(os.WriteFile(pathutil.Join(suite.RootDirectory, sftpTempPrefix+"mychart-0.3.0.tgz-x"), []byte("part"), 0644))
+
+	object, err := suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "no error getting object")
+	suite.Equal("overwritten", string(object.Content), "object content")
+	suite.False(object.LastModified.IsZero(), "object last modified")
+
+	_, err = suite.Backend.GetObject("missing.tgz")
+	suite.True(IsNotFound(err), "missing object not found")
+
+	objects, err = suite.Backend.ListObjects("")
+	suite.Nil(err, "no error listing objects")
+	suite.Len(objects, 1, "directories and partial uploads are not listed")
+	suite.Equal("mychart-0.1.0.tgz", objects[0].Path, "object path")
+
+	objects, err = suite.Backend.ListObjects("myrepo")
+	suite.Nil(err, "no error listing repo objects")
+	suite.Len(objects, 1, "repo objects listed")
+
+	suite.Nil(suite.Backend.DeleteObject("mychart-0.1.0.tgz"), "no error deleting object")
+	_, err = os.Stat(pathutil.Join(suite.RootDirectory, "mychart-0.1.0.tgz"))
+	suite.True(os.IsNotExist(err), "object deleted")
+	suite.Equal(1, suite.Connections, "connection reused")
+}
+
+func (suite *SFTPTestSuite) TestReconnect() {
+	suite.Nil(suite.Backend.PutObject("mychart-0.1.0.tgz", []byte("content")), "no error putting object")
+	suite.Backend.client.Close()
+	_, err := suite.Backend.GetObject("mychart-0.1.0.tgz")
+	suite.Nil(err, "no error getting object after the connection was lost")
+	suite.Equal(2, suite.Connections, "connected again")
+}
+
+func TestSFTPTestSuite(t *testing.T) {
+	suite.Run(t, new(SFTPTestSuite))
+}
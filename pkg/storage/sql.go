@@ -0,0 +1,255 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq" // registers the postgres driver
+)
+
+const (
+	// SQLDefaultTable is the table objects are stored in
+	SQLDefaultTable = "chartmuseum_objects"
+
+	// SQLDialectPostgres is the dialect of PostgreSQL databases
+	SQLDialectPostgres = "postgres"
+	// SQLDialectMySQL is the dialect of MySQL and MariaDB databases
+	SQLDialectMySQL = "mysql"
+)
+
+var sqlTablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLBackend is a storage backend keeping objects in a table of a PostgreSQL or MySQL
+// database, with their size and last modification time as metadata columns
+type SQLBackend struct {
+	DB      *sql.DB
+	Dialect string
+	Table   string
+}
+
+func init() {
+	// postgres://<user>:<password>@<host>/<database>?sslmode=disable, as accepted by lib/pq
+	postgres := func(u *url.URL) (Backend, error) {
+		dataSource, table := postgresDataSource(u)
+		db, err := sql.Open("postgres", dataSource)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLBackend(db, SQLDialectPostgres, table)
+	}
+	Register("postgres", postgres)
+	Register("postgresql", postgres)
+
+	// mysql://<user>:<password>@<host>[:port]/<database>
+	Register("mysql", func(u *url.URL) (Backend, error) {
+		dataSource, table, err := mysqlDataSource(u)
+		if err != nil {
+			return nil, err
+		}
+		db, err := sql.Open("mysql", dataSource)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLBackend(db, SQLDialectMySQL, table)
+	})
+}
+
+// postgresDataSource returns the lib/pq connection URL of a storage URL and the table given by
+// its table parameter, which is left out of the URL, lib/pq sending unknown parameters to the
+// server as run-time parameters
+func postgresDataSource(u *url.URL) (string, string) {
+	query := u.Query()
+	table := query.Get("table")
+	query.Del("table")
+	dataSource := *u
+	dataSource.RawQuery = query.Encode()
+	return dataSource.String(), table
+}
+
+// mysqlDataSource returns the driver DSN of a storage URL, with the parameters of the URL other
+// than table, and the table given by its table parameter
+func mysqlDataSource(u *url.URL) (string, string, error) {
+	query := u.Query()
+	table := query.Get("table")
+	query.Del("table")
+	config := mysql.NewConfig()
+	config.Net = "tcp"
+	config.Addr = u.Host
+	config.DBName = strings.TrimPrefix(u.Path, "/")
+	config.ParseTime = true
+	if u.User != nil {
+		config.User = u.User.Username()
+		config.Passwd, _ = u.User.Password()
+	}
+	dataSource := config.FormatDSN()
+	if len(query) > 0 {
+		// FormatDSN always has parameters, parseTime being set
+		config, err := mysql.ParseDSN(dataSource + "&" + query.Encode())
+		if err != nil {
+			return "", "", err
+		}
+		dataSource = config.FormatDSN()
+	}
+	return dataSource, table, nil
+}
+
+// NewSQLBackend creates a new instance of SQLBackend, creating its table if needed.
+// The table defaults to SQLDefaultTable
+func NewSQLBackend(db *sql.DB, dialect string, table string) (*SQLBackend, error) {
+	if table == "" {
+		table = SQLDefaultTable
+	}
+	if !sqlTablePattern.MatchString(table) {
+		return nil, fmt.Errorf("invalid sql table name: %s", table)
+	}
+	var contentType string
+	switch dialect {
+	case SQLDialectPostgres:
+		contentType = "BYTEA"
+	case SQLDialectMySQL:
+		contentType = "LONGBLOB"
+	default:
+		return nil, fmt.Errorf("unsupported sql dialect: %s", dialect)
+	}
+	b := &SQLBackend{DB: db, Dialect: dialect, Table: table}
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	path VARCHAR(512) NOT NULL PRIMARY KEY,
+	content %s NOT NULL,
+	size BIGINT NOT NULL,
+	last_modified TIMESTAMP NOT NULL
+)`, table, contentType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sql table %s: %w", table, err)
+	}
+	return b, nil
+}
+
+// ListObjects lists all objects in the table, at prefix
+func (b *SQLBackend) ListObjects(prefix string) ([]Object, error) {
+	var objects []Object
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	rows, err := b.DB.Query(b.query(`SELECT path, last_modified FROM %s WHERE path LIKE ? ORDER BY path`), sqlEscapeLike(prefix)+"%")
+	if err != nil {
+		return objects, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path string
+		var lastModified time.Time
+		if err := rows.Scan(&path, &lastModified); err != nil {
+			return objects, err
+		}
+		path = strings.TrimPrefix(path, prefix)
+		if path == "" || strings.Contains(path, "/") {
+			continue
+		}
+		objects = append(objects, Object{Path: path, Content: []byte{}, LastModified: lastModified})
+	}
+	return objects, rows.Err()
+}
+
+// GetObject retrieves an object from the table, at path
+func (b *SQLBackend) GetObject(path string) (Object, error) {
+	object := Object{Path: path}
+	row := b.DB.QueryRow(b.query(`SELECT content, last_modified FROM %s WHERE path = ?`), path)
+	err := row.Scan(&object.Content, &object.LastModified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return object, fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	}
+	return object, err
+}
+
+// PutObject creates or replaces an object in the table, at path, with a single upsert
+// statement, so concurrent uploads of the same path, even a new one, cannot conflict
+func (b *SQLBackend) PutObject(path string, content []byte) error {
+	upsert := `INSERT INTO %s (path, content, size, last_modified) VALUES (?, ?, ?, ?)
+ON CONFLICT (path) DO UPDATE SET content = EXCLUDED.content, size = EXCLUDED.size, last_modified = EXCLUDED.last_modified`
+	if b.Dialect == SQLDialectMySQL {
+		upsert = `INSERT INTO %s (path, content, size, last_modified) VALUES (?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE content = VALUES(content), size = VALUES(size), last_modified = VALUES(last_modified)`
+	}
+	_, err := b.DB.Exec(b.query(upsert), path, content, len(content), time.Now().UTC())
+	return err
+}
+
+// CreateObject creates an object in the table, at path, unless there is one already, with a
+// single insert statement, so that of concurrent uploads of a new path only one succeeds
+func (b *SQLBackend) CreateObject(path string, content []byte) error {
+	insert := `INSERT INTO %s (path, content, size, last_modified) VALUES (?, ?, ?, ?)
+ON CONFLICT (path) DO NOTHING`
+	if b.Dialect == SQLDialectMySQL {
+		// the affected rows are 0 when the update leaves the existing row unchanged
+		insert = `INSERT INTO %s (path, content, size, last_modified) VALUES (?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE path = path`
+	}
+	res, err := b.DB.Exec(b.query(insert), path, content, len(content), time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if count, err := res.RowsAffected(); err == nil && count == 0 {
+		return fmt.Errorf("%s: %w", path, fs.ErrExist)
+	}
+	return nil
+}
+
+// DeleteObject removes an object from the table, at path
+func (b *SQLBackend) DeleteObject(path string) error {
+	res, err := b.DB.Exec(b.query(`DELETE FROM %s WHERE path = ?`), path)
+	if err != nil {
+		return err
+	}
+	if count, err := res.RowsAffected(); err == nil && count == 0 {
+		return fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	}
+	return nil
+}
+
+// query formats a query for the table, with the placeholders of the dialect
+func (b *SQLBackend) query(format string) string {
+	q := fmt.Sprintf(format, b.Table)
+	if b.Dialect != SQLDialectPostgres {
+		return q
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&sb, "$%d", n)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// sqlEscapeLike escapes the wildcards of a LIKE pattern, with the default escape character
+func sqlEscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
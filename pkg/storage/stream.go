@@ -0,0 +1,104 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	pathutil "path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	cm_storage "github.com/chartmuseum/storage"
+)
+
+// StreamGetter is implemented by backends which can read an object without loading its
+// content in memory. The size is -1 if unknown, and the reader must be closed
+type StreamGetter interface {
+	GetObjectStream(path string) (io.ReadCloser, int64, error)
+}
+
+// GetObjectStream reads the object at path. Local filesystem, Amazon S3 and Google Cloud
+// Storage backends stream the content, as well as the backends implementing StreamGetter.
+// Other backends load the content in memory with GetObject
+func GetObjectStream(backend Backend, path string) (io.ReadCloser, int64, error) {
+	for {
+		switch b := backend.(type) {
+		case StreamGetter:
+			return b.GetObjectStream(path)
+		case *cm_storage.LocalFilesystemBackend:
+			return streamLocalFile(b.RootDirectory, path)
+		case *cm_storage.AmazonS3Backend:
+			return streamAmazonS3(b, path)
+		case *AmazonS3KMSBackend:
+			return streamAmazonS3(b.AmazonS3Backend, path)
+		case *cm_storage.GoogleCSBackend:
+			reader, err := b.Client.Object(pathutil.Join(b.Prefix, path)).NewReader(context.Background())
+			if err != nil {
+				return nil, 0, err
+			}
+			return reader, reader.Attrs.Size, nil
+		case *ReplicatedBackend:
+			backend = b.Backend
+		default:
+			object, err := backend.GetObject(path)
+			if err != nil {
+				return nil, 0, err
+			}
+			return io.NopCloser(bytes.NewReader(object.Content)), int64(len(object.Content)), nil
+		}
+	}
+}
+
+// GetObjectStream reads an object from root directory
+func (b *LocalFilesystemBackend) GetObjectStream(path string) (io.ReadCloser, int64, error) {
+	return streamLocalFile(b.RootDirectory, path)
+}
+
+func streamLocalFile(rootDirectory string, path string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(pathutil.Join(rootDirectory, path))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err == nil && info.IsDir() {
+		err = fmt.Errorf("%s is a directory", path)
+	}
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func streamAmazonS3(b *cm_storage.AmazonS3Backend, path string) (io.ReadCloser, int64, error) {
+	out, err := b.Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(pathutil.Join(b.Prefix, path)),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	size := int64(-1)
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+	return out.Body, size, nil
+}
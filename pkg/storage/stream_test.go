@@ -0,0 +1,118 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/stretchr/testify/suite"
+)
+
+type StreamTestSuite struct {
+	suite.Suite
+}
+
+// bufferedBackend hides the streaming of the wrapped backend
+type bufferedBackend struct {
+	Backend
+}
+
+func (suite *StreamTestSuite) readStream(backend Backend, path string) (string, int64) {
+	reader, size, err := GetObjectStream(backend, path)
+	suite.Nil(err, "no error streaming %s", path)
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	suite.Nil(err, "no error reading %s", path)
+	return string(content), size
+}
+
+func (suite *StreamTestSuite) TestLocal() {
+	backend := NewLocalFilesystemBackend(suite.T().TempDir(), false)
+	suite.Nil(backend.PutObject("org/mychart-0.1.0.tgz", []byte("mychart")))
+
+	content, size := suite.readStream(backend, "org/mychart-0.1.0.tgz")
+	suite.Equal("mychart", content)
+	suite.Equal(int64(7), size)
+
+	content, size = suite.readStream(backend.LocalFilesystemBackend, "org/mychart-0.1.0.tgz")
+	suite.Equal("mychart", content, "streamed from the non-atomic backend")
+	suite.Equal(int64(7), size)
+
+	_, _, err := GetObjectStream(backend, "org/otherchart-0.1.0.tgz")
+	suite.True(os.IsNotExist(err), "missing object")
+	_, _, err = GetObjectStream(backend, "org")
+	suite.EqualError(err, "org is a directory")
+}
+
+func (suite *StreamTestSuite) TestFallback() {
+	backend := bufferedBackend{NewLocalFilesystemBackend(suite.T().TempDir(), false)}
+	suite.Nil(backend.PutObject("mychart-0.1.0.tgz", []byte("mychart")))
+
+	content, size := suite.readStream(backend, "mychart-0.1.0.tgz")
+	suite.Equal("mychart", content, "buffered by GetObject")
+	suite.Equal(int64(7), size)
+
+	_, _, err := GetObjectStream(backend, "otherchart-0.1.0.tgz")
+	suite.NotNil(err, "missing object")
+}
+
+func (suite *StreamTestSuite) TestTiered() {
+	cold := NewLocalFilesystemBackend(suite.T().TempDir(), false)
+	suite.Nil(cold.PutObject("mychart-0.1.0.tgz", []byte("mychart")))
+	backend, err := NewTieredBackend(bufferedBackend{cold}, suite.T().TempDir(), 1024)
+	suite.Nil(err)
+
+	content, _ := suite.readStream(backend, "mychart-0.1.0.tgz")
+	suite.Equal("mychart", content, "miss read from the cold tier")
+	suite.Equal(int64(7), backend.cacheSize, "object cached")
+
+	suite.Nil(os.Remove(cold.RootDirectory + "/mychart-0.1.0.tgz"))
+	content, size := suite.readStream(backend, "mychart-0.1.0.tgz")
+	suite.Equal("mychart", content, "hit read from the hot tier")
+	suite.Equal(int64(7), size)
+}
+
+func (suite *StreamTestSuite) TestAmazonS3() {
+	suite.T().Setenv("AWS_ACCESS_KEY_ID", "x")
+	suite.T().Setenv("AWS_SECRET_ACCESS_KEY", "x")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/charts/prefix/mychart-0.1.0.tgz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", "7")
+		w.Write([]byte("mychart"))
+	}))
+	defer server.Close()
+	backend := cm_storage.NewAmazonS3Backend("charts", "prefix", "us-east-1", server.URL, "")
+
+	content, size := suite.readStream(backend, "mychart-0.1.0.tgz")
+	suite.Equal("mychart", content)
+	suite.Equal(int64(7), size)
+
+	_, _, err := GetObjectStream(backend, "otherchart-0.1.0.tgz")
+	suite.NotNil(err, "missing object")
+}
+
+func TestStreamTestSuite(t *testing.T) {
+	suite.Run(t, new(StreamTestSuite))
+}
@@ -0,0 +1,255 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	pathutil "path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tieredCacheExtension is the extension of the files of the hot tier, the only files removed
+// from its directory
+const tieredCacheExtension = ".cache"
+
+type (
+	// TieredBackend serves recently read objects from a directory on local disk, the hot tier,
+	// and falls back to the wrapped backend, the cold tier, which remains the source of truth.
+	//
+	// The hot tier holds at most MaxCacheSize bytes, the least recently read objects are evicted
+	// first. Writes and deletes go to the cold tier and evict the object from the hot tier. An object
+	// modified in the cold tier by another server is evicted when a listing reports its new
+	// modification time.
+	TieredBackend struct {
+		Backend
+		CacheDirectory string
+		MaxCacheSize   int64
+
+		hot       *LocalFilesystemBackend
+		lock      sync.Mutex
+		entries   map[string]*list.Element
+		recency   *list.List // front is the most recently read
+		cacheSize int64
+	}
+
+	tieredEntry struct {
+		path         string
+		name         string
+		size         int64
+		lastModified time.Time
+	}
+)
+
+// NewTieredBackend wraps a backend with a hot tier in cacheDirectory, holding at most
+// maxCacheSize bytes. Cache files left in cacheDirectory by a previous run are removed
+func NewTieredBackend(backend Backend, cacheDirectory string, maxCacheSize int64) (*TieredBackend, error) {
+	if cacheDirectory == "" {
+		return nil, errors.New("missing tiered storage cache directory")
+	}
+	if maxCacheSize <= 0 {
+		return nil, errors.New("tiered storage cache size must be positive")
+	}
+	if err := os.MkdirAll(cacheDirectory, 0755); err != nil {
+		return nil, err
+	}
+	stale, err := filepath.Glob(filepath.Join(cacheDirectory, "*"+tieredCacheExtension))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range stale {
+		if err := os.Remove(file); err != nil {
+			return nil, err
+		}
+	}
+	return &TieredBackend{
+		Backend:        backend,
+		CacheDirectory: cacheDirectory,
+		MaxCacheSize:   maxCacheSize,
+		hot:            NewLocalFilesystemBackend(cacheDirectory, false),
+		entries:        map[string]*list.Element{},
+		recency:        list.New(),
+	}, nil
+}
+
+// ListObjects lists objects of the cold tier, evicting objects it reports as modified
+func (b *TieredBackend) ListObjects(prefix string) ([]Object, error) {
+	objects, err := b.Backend.ListObjects(prefix)
+	if err != nil {
+		return objects, err
+	}
+	b.evictModified(prefix, objects)
+	return objects, nil
+}
+
+// ListObjectsPage lists a page of objects of the cold storage, evicting the modified objects of
+// the page from the cache
+func (b *TieredBackend) ListObjectsPage(prefix string, token string, limit int) ([]Object, string, error) {
+	objects, next, err := ListObjectsPage(b.Backend, prefix, token, limit)
+	if err != nil {
+		return objects, next, err
+	}
+	b.evictModified(prefix, objects)
+	return objects, next, nil
+}
+
+func (b *TieredBackend) evictModified(prefix string, objects []Object) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, object := range objects {
+		if element, ok := b.entries[pathutil.Join(prefix, object.Path)]; ok {
+			if !element.Value.(*tieredEntry).lastModified.Equal(object.LastModified) {
+				b.evict(element)
+			}
+		}
+	}
+}
+
+// GetObject retrieves an object from the hot tier, or from the cold tier on a miss
+func (b *TieredBackend) GetObject(path string) (Object, error) {
+	path = pathutil.Clean(path)
+	if object, ok := b.getHot(path); ok {
+		return object, nil
+	}
+	object, err := b.Backend.GetObject(path)
+	if err != nil {
+		return object, err
+	}
+	b.putHot(path, object.Content, object.LastModified)
+	return object, nil
+}
+
+// GetObjectStream reads an object from the file of the hot tier, or retrieves it from the cold
+// tier on a miss
+func (b *TieredBackend) GetObjectStream(path string) (io.ReadCloser, int64, error) {
+	path = pathutil.Clean(path)
+	b.lock.Lock()
+	element, ok := b.entries[path]
+	var name string
+	if ok {
+		b.recency.MoveToFront(element)
+		name = element.Value.(*tieredEntry).name
+	}
+	b.lock.Unlock()
+
+	if ok {
+		// an open file remains readable if the entry is evicted meanwhile
+		reader, size, err := b.hot.GetObjectStream(name)
+		if err == nil {
+			return reader, size, nil
+		}
+		b.remove(path)
+	}
+	object, err := b.GetObject(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return io.NopCloser(bytes.NewReader(object.Content)), int64(len(object.Content)), nil
+}
+
+// PutObject puts an object in the cold tier, evicting it from the hot tier. It is cached again
+// on the next read, as its modification time is only known from the cold tier
+func (b *TieredBackend) PutObject(path string, content []byte) error {
+	path = pathutil.Clean(path)
+	b.remove(path)
+	return b.Backend.PutObject(path, content)
+}
+
+// DeleteObject removes an object from the cold tier and the hot tier
+func (b *TieredBackend) DeleteObject(path string) error {
+	path = pathutil.Clean(path)
+	b.remove(path)
+	return b.Backend.DeleteObject(path)
+}
+
+func (b *TieredBackend) getHot(path string) (Object, bool) {
+	b.lock.Lock()
+	element, ok := b.entries[path]
+	if !ok {
+		b.lock.Unlock()
+		return Object{}, false
+	}
+	b.recency.MoveToFront(element)
+	entry := *element.Value.(*tieredEntry)
+	b.lock.Unlock()
+
+	object, err := b.hot.GetObject(entry.name)
+	if err != nil {
+		b.remove(path)
+		return Object{}, false
+	}
+	return Object{Path: path, Content: object.Content, LastModified: entry.lastModified}, true
+}
+
+func (b *TieredBackend) putHot(path string, content []byte, lastModified time.Time) {
+	size := int64(len(content))
+	if size > b.MaxCacheSize {
+		return
+	}
+	name := tieredCacheName(path)
+	if err := b.hot.PutObject(name, content); err != nil {
+		return // the hot tier is best effort
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if element, ok := b.entries[path]; ok {
+		b.forget(element) // the file was replaced
+	}
+	b.entries[path] = b.recency.PushFront(&tieredEntry{path: path, name: name, size: size, lastModified: lastModified})
+	b.cacheSize += size
+	for b.cacheSize > b.MaxCacheSize {
+		b.evict(b.recency.Back())
+	}
+}
+
+func (b *TieredBackend) remove(path string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if element, ok := b.entries[path]; ok {
+		b.evict(element)
+	}
+}
+
+// evict removes an entry of the hot tier and its file, the lock must be held
+func (b *TieredBackend) evict(element *list.Element) {
+	entry := b.forget(element)
+	b.hot.DeleteObject(entry.name)
+}
+
+// forget removes an entry of the hot tier, the lock must be held
+func (b *TieredBackend) forget(element *list.Element) *tieredEntry {
+	entry := b.recency.Remove(element).(*tieredEntry)
+	delete(b.entries, entry.path)
+	b.cacheSize -= entry.size
+	return entry
+}
+
+// tieredCacheName is the name of the file of an object in the hot tier, the directory is flat
+// so that nested objects of multitenant repos do not need subdirectories
+func tieredCacheName(path string) string {
+	sum := sha256.Sum256([]byte(strings.TrimPrefix(path, "/")))
+	return hex.EncodeToString(sum[:]) + tieredCacheExtension
+}
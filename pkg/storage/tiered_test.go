@@ -0,0 +1,146 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"os"
+	pathutil "path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// countingBackend counts the reads reaching the cold tier
+type countingBackend struct {
+	Backend
+	gets int
+}
+
+func (b *countingBackend) GetObject(path string) (Object, error) {
+	b.gets++
+	return b.Backend.GetObject(path)
+}
+
+type TieredTestSuite struct {
+	suite.Suite
+	ColdDirectory  string
+	CacheDirectory string
+	Cold           *countingBackend
+}
+
+func (suite *TieredTestSuite) SetupTest() {
+	suite.ColdDirectory = suite.T().TempDir()
+	suite.CacheDirectory = suite.T().TempDir()
+	suite.Cold = &countingBackend{Backend: NewLocalFilesystemBackend(suite.ColdDirectory, false)}
+}
+
+func (suite *TieredTestSuite) TestHotTier() {
+	backend, err := NewTieredBackend(suite.Cold, suite.CacheDirectory, 10)
+	suite.Nil(err, "no error creating tiered backend")
+
+	suite.Nil(backend.PutObject("a.tgz", []byte("aaaa")))
+	suite.Nil(backend.PutObject("myrepo/b.tgz", []byte("bbbb")))
+	suite.Nil(backend.PutObject("c.tgz", []byte("cccc")))
+	suite.Nil(backend.PutObject("big.tgz", []byte("0123456789a")))
+
+	for i := 0; i < 2; i++ {
+		object, err := backend.GetObject("a.tgz")
+		suite.Nil(err, "no error getting object")
+		suite.Equal([]byte("aaaa"), object.Content)
+	}
+	suite.Equal(1, suite.Cold.gets, "second read served by the hot tier")
+
+	_, err = backend.GetObject("myrepo/b.tgz")
+	suite.Nil(err)
+	_, err = backend.GetObject("a.tgz")
+	suite.Nil(err)
+	_, err = backend.GetObject("c.tgz") // evicts b.tgz, the least recently read
+	suite.Nil(err)
+	suite.Equal(3, suite.Cold.gets)
+	suite.Equal(int64(8), backend.cacheSize, "cache size bounded")
+	_, err = backend.GetObject("a.tgz")
+	suite.Nil(err)
+	suite.Equal(3, suite.Cold.gets, "recently read object kept")
+	_, err = backend.GetObject("myrepo/b.tgz")
+	suite.Nil(err)
+	suite.Equal(4, suite.Cold.gets, "least recently read object evicted")
+
+	_, err = backend.GetObject("big.tgz")
+	suite.Nil(err)
+	_, err = backend.GetObject("big.tgz")
+	suite.Nil(err)
+	suite.Equal(6, suite.Cold.gets, "object larger than the cache not cached")
+
+	files, err := os.ReadDir(suite.CacheDirectory)
+	suite.Nil(err)
+	suite.Len(files, 2, "evicted files removed")
+
+	suite.Nil(backend.PutObject("a.tgz", []byte("AAAA")))
+	object, err := backend.GetObject("a.tgz")
+	suite.Nil(err)
+	suite.Equal([]byte("AAAA"), object.Content, "overwritten object evicted")
+
+	suite.Nil(backend.DeleteObject("a.tgz"))
+	_, err = backend.GetObject("a.tgz")
+	suite.True(IsNotFound(err), "deleted object evicted")
+}
+
+func (suite *TieredTestSuite) TestListInvalidation() {
+	backend, err := NewTieredBackend(suite.Cold, suite.CacheDirectory, 1024)
+	suite.Nil(err)
+	suite.Nil(backend.PutObject("a.tgz", []byte("v1")))
+	_, err = backend.GetObject("a.tgz")
+	suite.Nil(err)
+
+	// another server overwrites the object in the cold tier
+	suite.Nil(suite.Cold.PutObject("a.tgz", []byte("v2")))
+	later := time.Now().Add(time.Minute)
+	suite.Nil(os.Chtimes(pathutil.Join(suite.ColdDirectory, "a.tgz"), later, later))
+	object, err := backend.GetObject("a.tgz")
+	suite.Nil(err)
+	suite.Equal([]byte("v1"), object.Content, "stale until listed")
+
+	objects, err := backend.ListObjects("")
+	suite.Nil(err)
+	suite.Len(objects, 1)
+	object, err = backend.GetObject("a.tgz")
+	suite.Nil(err)
+	suite.Equal([]byte("v2"), object.Content, "modified object evicted by listing")
+}
+
+func (suite *TieredTestSuite) TestCacheDirectory() {
+	_, err := NewTieredBackend(suite.Cold, "", 1024)
+	suite.NotNil(err, "error without cache directory")
+	_, err = NewTieredBackend(suite.Cold, suite.CacheDirectory, 0)
+	suite.NotNil(err, "error without cache size")
+
+	stale := pathutil.Join(suite.CacheDirectory, "x"+tieredCacheExtension)
+	other := pathutil.Join(suite.CacheDirectory, "mychart-0.1.0.tgz")
+	suite.Nil(os.WriteFile(stale, []byte("x"), 0644))
+	suite.Nil(os.WriteFile(other, []byte("x"), 0644))
+	_, err = NewTieredBackend(suite.Cold, suite.CacheDirectory, 1024)
+	suite.Nil(err)
+	_, err = os.Stat(stale)
+	suite.True(os.IsNotExist(err), "stale cache file removed")
+	_, err = os.Stat(other)
+	suite.Nil(err, "other files kept")
+}
+
+func TestTieredTestSuite(t *testing.T) {
+	suite.Run(t, new(TieredTestSuite))
+}
@@ -0,0 +1,202 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	gcs "cloud.google.com/go/storage"
+	microsoft_storage "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	cm_storage "github.com/chartmuseum/storage"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+
+	"helm.sh/chartmuseum/pkg/vault"
+)
+
+type (
+	// vaultAmazonProvider provides the AWS credentials of a Vault secret, with the fields of the
+	// AWS secrets engine: access_key, secret_key and security_token
+	vaultAmazonProvider struct {
+		credentials.Expiry
+		lease *vault.Lease
+	}
+
+	// vaultGoogleTokenSource provides the OAuth tokens of a Vault secret, with the fields of the
+	// GCP secrets engine: the token of a roleset or static account, or its private_key_data
+	vaultGoogleTokenSource struct {
+		lease *vault.Lease
+	}
+
+	// MicrosoftVaultBackend is a Microsoft Azure Blob Storage backend with the storage account
+	// and access key of a Vault secret, in its fields account and access_key, connecting again
+	// when the secret changes
+	MicrosoftVaultBackend struct {
+		Container string
+		Prefix    string
+		lease     *vault.Lease
+		lock      sync.Mutex
+		secret    *vault.Secret
+		backend   *cm_storage.MicrosoftBlobBackend
+	}
+)
+
+// NewVaultAmazonCredentials returns the AWS credentials of the secret of lease, retrieved again
+// when the lease is refreshed. They are set as the credentials of an S3 backend with
+//
+//	backend.Client.Config.Credentials = NewVaultAmazonCredentials(lease)
+func NewVaultAmazonCredentials(lease *vault.Lease) *credentials.Credentials {
+	return credentials.NewCredentials(&vaultAmazonProvider{lease: lease})
+}
+
+func (p *vaultAmazonProvider) Retrieve() (credentials.Value, error) {
+	secret, err := p.lease.Secret()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	value := credentials.Value{
+		AccessKeyID:     secret.String("access_key"),
+		SecretAccessKey: secret.String("secret_key"),
+		SessionToken:    secret.String("security_token"),
+		ProviderName:    "Vault",
+	}
+	if value.AccessKeyID == "" || value.SecretAccessKey == "" {
+		return credentials.Value{}, errors.New("the Vault secret of the AWS credentials has no access_key or secret_key")
+	}
+	p.SetExpiration(p.lease.Expires(), 0)
+	return value, nil
+}
+
+// NewVaultGoogleCSBackend creates a new instance of GoogleCSBackend, authenticated with the
+// tokens of the secret of lease
+func NewVaultGoogleCSBackend(bucket string, prefix string, lease *vault.Lease) (*cm_storage.GoogleCSBackend, error) {
+	ctx := context.Background()
+	client, err := gcs.NewClient(ctx, option.WithTokenSource(oauth2.ReuseTokenSource(nil, &vaultGoogleTokenSource{lease: lease})))
+	if err != nil {
+		return nil, err
+	}
+	return &cm_storage.GoogleCSBackend{
+		Prefix:  strings.Trim(prefix, "/"),
+		Client:  client.Bucket(bucket),
+		Context: ctx,
+	}, nil
+}
+
+func (s *vaultGoogleTokenSource) Token() (*oauth2.Token, error) {
+	secret, err := s.lease.Secret()
+	if err != nil {
+		return nil, err
+	}
+	if token := secret.String("token"); token != "" {
+		return &oauth2.Token{AccessToken: token, TokenType: "Bearer", Expiry: s.lease.Expires()}, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(secret.String("private_key_data"))
+	if err != nil || len(key) == 0 {
+		return nil, errors.New("the Vault secret of the Google credentials has no token or private_key_data")
+	}
+	credentials, err := google.CredentialsFromJSON(context.Background(), key, gcs.ScopeFullControl)
+	if err != nil {
+		return nil, err
+	}
+	token, err := credentials.TokenSource.Token()
+	if err != nil {
+		return nil, err
+	}
+	// the token is not used past the key it was issued with
+	if expires := s.lease.Expires(); token.Expiry.IsZero() || expires.Before(token.Expiry) {
+		token.Expiry = expires
+	}
+	return token, nil
+}
+
+// NewMicrosoftVaultBackend creates a new instance of MicrosoftVaultBackend, checking the secret
+// of lease
+func NewMicrosoftVaultBackend(container string, prefix string, lease *vault.Lease) (*MicrosoftVaultBackend, error) {
+	b := &MicrosoftVaultBackend{Container: container, Prefix: prefix, lease: lease}
+	if _, err := b.current(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// current returns the backend connected with the current secret
+func (b *MicrosoftVaultBackend) current() (*cm_storage.MicrosoftBlobBackend, error) {
+	secret, err := b.lease.Secret()
+	if err != nil {
+		return nil, err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if secret == b.secret {
+		return b.backend, nil
+	}
+	account, accessKey := secret.String("account"), secret.String("access_key")
+	if account == "" || accessKey == "" {
+		return nil, errors.New("the Vault secret of the Azure storage account has no account or access_key")
+	}
+	client, err := microsoft_storage.NewClient(account, accessKey, microsoft_storage.DefaultBaseURL, microsoft_storage.DefaultAPIVersion, true)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Azure storage account %s: %w", account, err)
+	}
+	blobService := client.GetBlobService()
+	b.secret = secret
+	b.backend = &cm_storage.MicrosoftBlobBackend{Prefix: b.Prefix, Container: blobService.GetContainerReference(b.Container)}
+	return b.backend, nil
+}
+
+// ListObjects lists all objects in the container, at prefix
+func (b *MicrosoftVaultBackend) ListObjects(prefix string) ([]Object, error) {
+	backend, err := b.current()
+	if err != nil {
+		return nil, err
+	}
+	return backend.ListObjects(prefix)
+}
+
+// GetObject retrieves an object from the container, at prefix
+func (b *MicrosoftVaultBackend) GetObject(path string) (Object, error) {
+	backend, err := b.current()
+	if err != nil {
+		return Object{}, err
+	}
+	return backend.GetObject(path)
+}
+
+// PutObject uploads an object to the container, at prefix
+func (b *MicrosoftVaultBackend) PutObject(path string, content []byte) error {
+	backend, err := b.current()
+	if err != nil {
+		return err
+	}
+	return backend.PutObject(path, content)
+}
+
+// DeleteObject removes an object from the container, at prefix
+func (b *MicrosoftVaultBackend) DeleteObject(path string) error {
+	backend, err := b.current()
+	if err != nil {
+		return err
+	}
+	return backend.DeleteObject(path)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/stretchr/testify/suite"
+
+	"helm.sh/chartmuseum/pkg/vault"
+)
+
+type VaultTestSuite struct {
+	suite.Suite
+	Vault  *httptest.Server
+	Client *vault.Client
+	// Secrets are the fields of the secrets of the fake Vault, by path
+	Secrets map[string]map[string]interface{}
+}
+
+func (suite *VaultTestSuite) SetupTest() {
+	suite.Secrets = map[string]map[string]interface{}{}
+	suite.Vault = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth/token/lookup-self" {
+			w.Write([]byte(`{"data":{"ttl":0}}`))
+			return
+		}
+		fields, found := suite.Secrets[strings.TrimPrefix(r.URL.Path, "/v1/")]
+		if !found {
+			w.WriteHeader(404)
+			return
+		}
+		// the secrets of the KV store have no lease
+		leaseDuration := 3600
+		if strings.HasPrefix(r.URL.Path, "/v1/secret/") {
+			leaseDuration = 0
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"lease_duration": leaseDuration, "data": fields})
+	}))
+	var err error
+	suite.Client, err = vault.NewClient(vault.Options{Address: suite.Vault.URL, Token: "token"})
+	suite.Nil(err)
+}
+
+func (suite *VaultTestSuite) TearDownTest() {
+	suite.Vault.Close()
+}
+
+func (suite *VaultTestSuite) TestAmazonCredentials() {
+	suite.Secrets["aws/creds/chartmuseum"] = map[string]interface{}{"access_key": "AKIAVAULT", "secret_key": "secret", "security_token": "session"}
+	backend := cm_storage.NewAmazonS3Backend("charts", "", "us-east-1", "https://s3.example.com", "")
+	backend.Client.Config.Credentials = NewVaultAmazonCredentials(suite.Client.Lease("aws/creds/chartmuseum", nil))
+
+	signedURL, err := Presign(backend, "mychart-0.1.0.tgz", time.Minute)
+	suite.Nil(err)
+	u, err := url.Parse(signedURL)
+	suite.Nil(err)
+	suite.True(strings.HasPrefix(u.Query().Get("X-Amz-Credential"), "AKIAVAULT/"), "signed with the credentials of Vault")
+	suite.Equal("session", u.Query().Get("X-Amz-Security-Token"))
+
+	backend.Client.Config.Credentials = NewVaultAmazonCredentials(suite.Client.Lease("aws/creds/missing", nil))
+	_, err = Presign(backend, "mychart-0.1.0.tgz", time.Minute)
+	suite.NotNil(err, "missing secret")
+}
+
+func (suite *VaultTestSuite) TestGoogleTokenSource() {
+	suite.Secrets["gcp/roleset/chartmuseum/token"] = map[string]interface{}{"token": "ya29.vault"}
+	lease := suite.Client.Lease("gcp/roleset/chartmuseum/token", nil)
+	token, err := (&vaultGoogleTokenSource{lease: lease}).Token()
+	suite.Nil(err)
+	suite.Equal("ya29.vault", token.AccessToken)
+	suite.Equal(lease.Expires(), token.Expiry, "token refreshed with the secret")
+
+	suite.Secrets["secret/data/chartmuseum"] = map[string]interface{}{"private_key_data": "not base64"}
+	_, err = (&vaultGoogleTokenSource{lease: suite.Client.Lease("secret/data/chartmuseum", nil)}).Token()
+	suite.EqualError(err, "the Vault secret of the Google credentials has no token or private_key_data")
+
+	backend, err := NewVaultGoogleCSBackend("charts", "/prefix/", lease)
+	suite.Nil(err)
+	suite.Equal("prefix", backend.Prefix)
+}
+
+func (suite *VaultTestSuite) TestMicrosoftBackend() {
+	suite.Secrets["secret/data/azure"] = map[string]interface{}{"account": "charts1", "access_key": "a2V5"}
+	lease := suite.Client.Lease("secret/data/azure", nil)
+	lease.RefreshInterval = time.Nanosecond
+	backend, err := NewMicrosoftVaultBackend("charts", "prefix", lease)
+	suite.Nil(err)
+	current, err := backend.current()
+	suite.Nil(err)
+	suite.Contains(current.Container.GetURL(), "charts1.blob.core.windows.net")
+
+	suite.Secrets["secret/data/azure"] = map[string]interface{}{"account": "charts2", "access_key": "a2V5"}
+	current, err = backend.current()
+	suite.Nil(err)
+	suite.Contains(current.Container.GetURL(), "charts2.blob.core.windows.net", "connected again with the rotated secret")
+
+	suite.Secrets["secret/data/other"] = map[string]interface{}{"account": "charts"}
+	_, err = NewMicrosoftVaultBackend("charts", "prefix", suite.Client.Lease("secret/data/other", nil))
+	suite.EqualError(err, "the Vault secret of the Azure storage account has no account or access_key")
+}
+
+func TestVaultTestSuite(t *testing.T) {
+	suite.Run(t, new(VaultTestSuite))
+}
@@ -0,0 +1,405 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	pathutil "path"
+	"strings"
+	"sync"
+)
+
+// webdavPropfindBody requests the properties needed to list objects
+const webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getlastmodified/></prop></propfind>`
+
+type (
+	// WebDAVBackend is a storage backend for a collection of a WebDAV server, such as
+	// Nextcloud or ownCloud, with basic or digest authentication
+	WebDAVBackend struct {
+		BaseURL  *url.URL
+		Username string
+		Password string
+
+		client     *http.Client
+		digestLock sync.Mutex
+		digest     *webdavDigest
+	}
+
+	// WebDAVOptions are optional settings of a WebDAVBackend
+	WebDAVOptions struct {
+		Username string
+		Password string
+		Client   *http.Client
+	}
+
+	// webdavDigest is the digest authentication challenge of the server
+	webdavDigest struct {
+		realm     string
+		nonce     string
+		opaque    string
+		qop       string
+		algorithm string
+		count     int
+	}
+
+	webdavMultistatus struct {
+		Responses []struct {
+			Href      string `xml:"href"`
+			Propstats []struct {
+				Status string `xml:"status"`
+				Prop   struct {
+					LastModified string `xml:"getlastmodified"`
+					ResourceType struct {
+						Collection *struct{} `xml:"collection"`
+					} `xml:"resourcetype"`
+				} `xml:"prop"`
+			} `xml:"propstat"`
+		} `xml:"response"`
+	}
+
+	// webdavError is returned for unexpected response statuses
+	webdavError struct {
+		Method string
+		Path   string
+		Status int
+	}
+)
+
+func init() {
+	// webdav://<user>:<password>@<host>/<collection> for http, webdavs:// for https
+	factory := func(u *url.URL) (Backend, error) {
+		options := WebDAVOptions{}
+		if u.User != nil {
+			options.Username = u.User.Username()
+			options.Password, _ = u.User.Password()
+		}
+		baseURL := *u
+		baseURL.User = nil
+		baseURL.Scheme = "http"
+		if u.Scheme == "webdavs" {
+			baseURL.Scheme = "https"
+		}
+		return NewWebDAVBackend(baseURL.String(), options)
+	}
+	Register("webdav", factory)
+	Register("webdavs", factory)
+}
+
+func (e *webdavError) Error() string {
+	return fmt.Sprintf("webdav: %s %s: %d %s", e.Method, e.Path, e.Status, http.StatusText(e.Status))
+}
+
+// Is makes missing objects match fs.ErrNotExist
+func (e *webdavError) Is(target error) bool {
+	return target == fs.ErrNotExist && e.Status == http.StatusNotFound
+}
+
+// NewWebDAVBackend creates a new instance of WebDAVBackend, storing objects in the
+// collection at baseURL
+func NewWebDAVBackend(baseURL string, options WebDAVOptions) (*WebDAVBackend, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webdav URL: %s", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	b := &WebDAVBackend{
+		BaseURL:  u,
+		Username: options.Username,
+		Password: options.Password,
+		client:   options.Client,
+	}
+	if b.client == nil {
+		b.client = http.DefaultClient
+	}
+	return b, nil
+}
+
+// ListObjects lists all objects in a collection of the WebDAV server, at prefix
+func (b *WebDAVBackend) ListObjects(prefix string) ([]Object, error) {
+	var objects []Object
+	collection := pathutil.Join("/", prefix) + "/"
+	res, err := b.do("PROPFIND", collection, []byte(webdavPropfindBody), map[string]string{
+		"Depth":        "1",
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) { // OK if the collection doesnt exist yet
+			err = nil
+		}
+		return objects, err
+	}
+	defer res.Body.Close()
+	var multistatus webdavMultistatus
+	if err := xml.NewDecoder(res.Body).Decode(&multistatus); err != nil {
+		return objects, fmt.Errorf("webdav: invalid PROPFIND response: %s", err)
+	}
+	for _, response := range multistatus.Responses {
+		href, err := url.PathUnescape(response.Href)
+		if err != nil {
+			continue
+		}
+		// hrefs may be absolute URLs
+		if u, err := url.Parse(href); err == nil && u.Host != "" {
+			href = u.Path
+		}
+		// skip the collection itself and nested collections
+		if strings.HasSuffix(href, "/") || pathutil.Dir(href) != pathutil.Join("/", b.BaseURL.Path, collection) {
+			continue
+		}
+		object := Object{Path: pathutil.Base(href), Content: []byte{}}
+		isCollection := false
+		for _, propstat := range response.Propstats {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			if propstat.Prop.ResourceType.Collection != nil {
+				isCollection = true
+			}
+			if lastModified, err := http.ParseTime(propstat.Prop.LastModified); err == nil {
+				object.LastModified = lastModified
+			}
+		}
+		if isCollection {
+			continue
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// GetObject retrieves an object from the WebDAV server, at path
+func (b *WebDAVBackend) GetObject(path string) (Object, error) {
+	object := Object{Path: path}
+	res, err := b.do(http.MethodGet, path, nil, nil)
+	if err != nil {
+		return object, err
+	}
+	defer res.Body.Close()
+	object.Content, err = io.ReadAll(res.Body)
+	if err != nil {
+		return object, err
+	}
+	if lastModified, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		object.LastModified = lastModified
+	}
+	return object, nil
+}
+
+// PutObject uploads an object to the WebDAV server, at path. Missing parent
+// collections are created
+func (b *WebDAVBackend) PutObject(path string, content []byte) error {
+	res, err := b.do(http.MethodPut, path, content, nil)
+	var webdavErr *webdavError
+	if errors.As(err, &webdavErr) && (webdavErr.Status == http.StatusConflict || webdavErr.Status == http.StatusNotFound) {
+		// the parent collection does not exist
+		if err := b.makeCollections(pathutil.Dir(pathutil.Join("/", path))); err != nil {
+			return err
+		}
+		res, err = b.do(http.MethodPut, path, content, nil)
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// DeleteObject removes an object from the WebDAV server, at path
+func (b *WebDAVBackend) DeleteObject(path string) error {
+	res, err := b.do(http.MethodDelete, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// makeCollections creates a collection and its parents, up to the base collection
+func (b *WebDAVBackend) makeCollections(collection string) error {
+	if collection != "/" {
+		if err := b.makeCollections(pathutil.Dir(collection)); err != nil {
+			return err
+		}
+	}
+	res, err := b.do("MKCOL", strings.TrimSuffix(collection, "/")+"/", nil, nil)
+	var webdavErr *webdavError
+	if errors.As(err, &webdavErr) && webdavErr.Status == http.StatusMethodNotAllowed {
+		// the collection already exists
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// do sends a request for a path relative to the base URL, returning an error for
+// unsuccessful statuses. The request is sent again once when the server asks for
+// digest authentication
+func (b *WebDAVBackend) do(method string, path string, body []byte, headers map[string]string) (*http.Response, error) {
+	u := *b.BaseURL
+	u.Path = pathutil.Join(b.BaseURL.Path, path)
+	if strings.HasSuffix(path, "/") {
+		u.Path += "/"
+	}
+	for retried := false; ; retried = true {
+		req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		b.authenticate(req)
+		res, err := b.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode >= 200 && res.StatusCode < 300 {
+			return res, nil
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusUnauthorized && !retried && b.Username != "" {
+			if challenge := res.Header.Get("WWW-Authenticate"); strings.HasPrefix(challenge, "Digest ") {
+				b.digestLock.Lock()
+				b.digest = parseWebDAVDigest(challenge)
+				b.digestLock.Unlock()
+				continue
+			}
+		}
+		return nil, &webdavError{Method: method, Path: path, Status: res.StatusCode}
+	}
+}
+
+// authenticate sets the credentials of a request, with digest authentication once the
+// server asked for it, and basic authentication otherwise
+func (b *WebDAVBackend) authenticate(req *http.Request) {
+	if b.Username == "" {
+		return
+	}
+	b.digestLock.Lock()
+	defer b.digestLock.Unlock()
+	if b.digest == nil {
+		req.SetBasicAuth(b.Username, b.Password)
+		return
+	}
+	d := b.digest
+	d.count++
+	uri := req.URL.RequestURI()
+	ha1 := md5Hex(b.Username + ":" + d.realm + ":" + b.Password)
+	ha2 := md5Hex(req.Method + ":" + uri)
+	params := []string{
+		fmt.Sprintf(`username="%s"`, b.Username),
+		fmt.Sprintf(`realm="%s"`, d.realm),
+		fmt.Sprintf(`nonce="%s"`, d.nonce),
+		fmt.Sprintf(`uri="%s"`, uri),
+	}
+	if d.qop == "" {
+		params = append(params, fmt.Sprintf(`response="%s"`, md5Hex(ha1+":"+d.nonce+":"+ha2)))
+	} else {
+		nc := fmt.Sprintf("%08x", d.count)
+		cnonceBytes := make([]byte, 8)
+		rand.Read(cnonceBytes)
+		cnonce := hex.EncodeToString(cnonceBytes)
+		params = append(params,
+			"qop=auth",
+			"nc="+nc,
+			fmt.Sprintf(`cnonce="%s"`, cnonce),
+			fmt.Sprintf(`response="%s"`, md5Hex(ha1+":"+d.nonce+":"+nc+":"+cnonce+":auth:"+ha2)),
+		)
+	}
+	if d.opaque != "" {
+		params = append(params, fmt.Sprintf(`opaque="%s"`, d.opaque))
+	}
+	if d.algorithm != "" {
+		params = append(params, "algorithm="+d.algorithm)
+	}
+	req.Header.Set("Authorization", "Digest "+strings.Join(params, ", "))
+}
+
+// parseWebDAVDigest parses a WWW-Authenticate digest challenge. Only MD5 and qop=auth
+// are supported, as by most servers
+func parseWebDAVDigest(challenge string) *webdavDigest {
+	d := &webdavDigest{}
+	for _, param := range splitDigestParams(strings.TrimPrefix(challenge, "Digest ")) {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "realm":
+			d.realm = value
+		case "nonce":
+			d.nonce = value
+		case "opaque":
+			d.opaque = value
+		case "algorithm":
+			d.algorithm = value
+		case "qop":
+			for _, qop := range strings.Split(value, ",") {
+				if strings.TrimSpace(qop) == "auth" {
+					d.qop = "auth"
+				}
+			}
+		}
+	}
+	return d
+}
+
+// splitDigestParams splits comma-separated parameters, ignoring commas in quoted values
+func splitDigestParams(s string) []string {
+	var params []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case r == ',' && !quoted:
+			params = append(params, strings.TrimSpace(current.String()))
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		params = append(params, strings.TrimSpace(current.String()))
+	}
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
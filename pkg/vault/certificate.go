@@ -0,0 +1,76 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// CertificateSource serves the certificate of a lease to TLS handshakes, with the fields of the
+// PKI secrets engine: certificate, private_key, and ca_chain or issuing_ca
+type CertificateSource struct {
+	lease       *Lease
+	lock        sync.Mutex
+	secret      *Secret
+	certificate *tls.Certificate
+}
+
+// NewCertificateSource returns the source of the certificate of lease, checking that it can be
+// fetched
+func NewCertificateSource(lease *Lease) (*CertificateSource, error) {
+	source := &CertificateSource{lease: lease}
+	if _, err := source.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// GetCertificate is the tls.Config GetCertificate of the server
+func (s *CertificateSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	secret, err := s.lease.Secret()
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if secret == s.secret {
+		return s.certificate, nil
+	}
+	chain := []string{secret.String("certificate")}
+	if caChain, ok := secret.Data["ca_chain"].([]interface{}); ok {
+		for _, ca := range caChain {
+			if pem, ok := ca.(string); ok && pem != chain[0] {
+				chain = append(chain, pem)
+			}
+		}
+	} else if issuingCA := secret.String("issuing_ca"); issuingCA != "" {
+		chain = append(chain, issuingCA)
+	}
+	key := secret.String("private_key")
+	if chain[0] == "" || key == "" {
+		return nil, errors.New("the Vault secret of the TLS certificate has no certificate or private_key")
+	}
+	certificate, err := tls.X509KeyPair([]byte(strings.Join(chain, "\n")), []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	s.secret, s.certificate = secret, &certificate
+	return s.certificate, nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRefreshInterval is how often the secrets that do not expire are read again, so that
+	// the rotated secrets of a KV store are picked up
+	DefaultRefreshInterval = 5 * time.Minute
+
+	// retryInterval is how long a secret still valid is used after failing to refresh it,
+	// before trying again
+	retryInterval = 10 * time.Second
+)
+
+// Lease keeps a secret fresh: its lease is renewed, or the secret fetched again, after two
+// thirds of its lifetime
+type Lease struct {
+	fetch   func() (*Secret, error)
+	renew   func(*Secret) (*Secret, error)
+	lock    sync.Mutex
+	secret  *Secret
+	refresh time.Time
+	// RefreshInterval is how often a secret that does not expire is fetched again,
+	// DefaultRefreshInterval by default
+	RefreshInterval time.Duration
+}
+
+// Lease returns a lease of the secret read at path, or of the secret written at path with data
+// when data is set, such as to issue certificates
+func (c *Client) Lease(path string, data map[string]interface{}) *Lease {
+	fetch := func() (*Secret, error) { return c.Read(path) }
+	if data != nil {
+		fetch = func() (*Secret, error) { return c.Write(path, data) }
+	}
+	return &Lease{fetch: fetch, renew: c.Renew, RefreshInterval: DefaultRefreshInterval}
+}
+
+// Secret returns the current secret, refreshing it when due. A secret that failed to refresh is
+// returned until it expires
+func (l *Lease) Secret() (*Secret, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	if l.secret != nil && now.Before(l.refresh) {
+		return l.secret, nil
+	}
+	secret, err := l.next()
+	if err != nil {
+		if l.secret != nil && (l.secret.Expires().IsZero() || now.Before(l.secret.Expires())) {
+			l.refresh = now.Add(retryInterval)
+			return l.secret, nil
+		}
+		return nil, err
+	}
+	l.secret = secret
+	if expires := secret.Expires(); !expires.IsZero() {
+		l.refresh = now.Add(expires.Sub(now) * 2 / 3)
+	} else {
+		l.refresh = now.Add(l.RefreshInterval)
+	}
+	return secret, nil
+}
+
+// Expires returns when the current secret is refreshed, which credentials caching the secret
+// take as their expiry
+func (l *Lease) Expires() time.Time {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.refresh
+}
+
+// next renews the lease of the current secret, or fetches a new secret if it cannot be renewed
+// or its lease was cut short by its max TTL
+func (l *Lease) next() (*Secret, error) {
+	if current := l.secret; current != nil && current.Renewable && current.LeaseID != "" {
+		renewed, err := l.renew(current)
+		if err == nil && renewed.LeaseDuration >= current.LeaseDuration/2 {
+			return renewed, nil
+		}
+	}
+	return l.fetch()
+}
@@ -0,0 +1,345 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vault fetches the credentials of storage backends and the TLS certificate of the
+// server from HashiCorp Vault, renewing them before they expire
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AuthToken, AuthAppRole and AuthKubernetes are the auth methods of the client
+	AuthToken      = "token"
+	AuthAppRole    = "approle"
+	AuthKubernetes = "kubernetes"
+
+	// DefaultKubernetesTokenFile is the service account token of the pod, sent with the
+	// kubernetes auth method
+	DefaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	defaultTimeout = 10 * time.Second
+)
+
+type (
+	// Options are the settings of a Client
+	Options struct {
+		// Address of Vault, i.e. https://vault.example.com:8200
+		Address string
+		// Namespace of Vault Enterprise, sent with every request
+		Namespace string
+		// CACertFile verifies the certificate of Vault, instead of the system roots
+		CACertFile string
+		// Auth is the auth method, AuthToken by default
+		Auth string
+		// AuthMount is the path the auth method is mounted at, its name by default
+		AuthMount string
+		// Token is the token of AuthToken
+		Token string
+		// RoleID and SecretID are the credentials of AuthAppRole
+		RoleID   string
+		SecretID string
+		// Role is the role of AuthKubernetes, logging in with the token of KubernetesTokenFile,
+		// DefaultKubernetesTokenFile by default
+		Role                string
+		KubernetesTokenFile string
+		Timeout             time.Duration
+	}
+
+	// Client reads secrets from Vault, logging in with its auth method and renewing its token
+	// before it expires
+	Client struct {
+		options Options
+		client  *http.Client
+		lock    sync.Mutex
+		token   string
+		// renew is when the token is renewed, or obtained again if it cannot be renewed
+		renew     time.Time
+		renewable bool
+	}
+
+	// Secret is a secret read from Vault
+	Secret struct {
+		// Data are the fields of the secret, the ones of the current version for KV version 2
+		Data          map[string]interface{}
+		LeaseID       string
+		LeaseDuration time.Duration
+		Renewable     bool
+		// Fetched is when the secret was read or its lease renewed
+		Fetched time.Time
+	}
+
+	// response is the response of the Vault HTTP API
+	response struct {
+		LeaseID       string                 `json:"lease_id"`
+		LeaseDuration int                    `json:"lease_duration"`
+		Renewable     bool                   `json:"renewable"`
+		Data          map[string]interface{} `json:"data"`
+		Auth          *struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+			Renewable     bool   `json:"renewable"`
+		} `json:"auth"`
+		Errors []string `json:"errors"`
+	}
+)
+
+// NewClient returns a client of the Vault of options, checking its credentials
+func NewClient(options Options) (*Client, error) {
+	if options.Address == "" {
+		return nil, errors.New("missing Vault address")
+	}
+	if options.Auth == "" {
+		options.Auth = AuthToken
+	}
+	if options.AuthMount == "" {
+		options.AuthMount = options.Auth
+	}
+	if options.KubernetesTokenFile == "" {
+		options.KubernetesTokenFile = DefaultKubernetesTokenFile
+	}
+	if options.Timeout == 0 {
+		options.Timeout = defaultTimeout
+	}
+	switch {
+	case options.Auth == AuthToken && options.Token == "":
+		return nil, errors.New("the token auth of Vault requires a token")
+	case options.Auth == AuthAppRole && options.RoleID == "":
+		return nil, errors.New("the approle auth of Vault requires a role id")
+	case options.Auth == AuthKubernetes && options.Role == "":
+		return nil, errors.New("the kubernetes auth of Vault requires a role")
+	case options.Auth != AuthToken && options.Auth != AuthAppRole && options.Auth != AuthKubernetes:
+		return nil, fmt.Errorf("unsupported Vault auth method: %s", options.Auth)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.CACertFile != "" {
+		pem, err := os.ReadFile(options.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: x509.NewCertPool()}
+		if !transport.TLSClientConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", options.CACertFile)
+		}
+	}
+	c := &Client{options: options, client: &http.Client{Transport: transport, Timeout: options.Timeout}}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.login(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Read reads the secret at path, i.e. aws/creds/chartmuseum or secret/data/chartmuseum
+func (c *Client) Read(path string) (*Secret, error) {
+	return c.secret(http.MethodGet, path, nil)
+}
+
+// Write writes data to path and returns the secret of the response, i.e. the certificate of
+// pki/issue/chartmuseum
+func (c *Client) Write(path string, data map[string]interface{}) (*Secret, error) {
+	return c.secret(http.MethodPut, path, data)
+}
+
+// Renew renews the lease of secret, returning the secret with its new lease
+func (c *Client) Renew(secret *Secret) (*Secret, error) {
+	renewed, err := c.secret(http.MethodPut, "sys/leases/renew", map[string]interface{}{
+		"lease_id":  secret.LeaseID,
+		"increment": int(secret.LeaseDuration.Seconds()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	renewed.Data = secret.Data
+	return renewed, nil
+}
+
+func (c *Client) secret(method string, path string, data map[string]interface{}) (*Secret, error) {
+	token, err := c.currentToken()
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.do(method, path, token, data)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, fmt.Errorf("no Vault secret at %s", path)
+	}
+	secret := &Secret{
+		Data:          r.Data,
+		LeaseID:       r.LeaseID,
+		LeaseDuration: time.Duration(r.LeaseDuration) * time.Second,
+		Renewable:     r.Renewable,
+		Fetched:       time.Now(),
+	}
+	// the fields of a KV version 2 secret are in the data of its current version
+	if fields, ok := secret.Data["data"].(map[string]interface{}); ok {
+		if _, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+			secret.Data = fields
+		}
+	}
+	return secret, nil
+}
+
+// currentToken returns the token of the client, renewing it or logging in again when due
+func (c *Client) currentToken() (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.renew.IsZero() || time.Now().Before(c.renew) {
+		return c.token, nil
+	}
+	if c.renewable {
+		r, err := c.do(http.MethodPut, "auth/token/renew-self", c.token, nil)
+		if err == nil && r != nil && r.Auth != nil {
+			c.setToken(r.Auth.ClientToken, r.Auth.LeaseDuration, r.Auth.Renewable)
+			return c.token, nil
+		}
+		if c.options.Auth == AuthToken {
+			return "", fmt.Errorf("renewing the Vault token: %w", err)
+		}
+	}
+	if err := c.login(); err != nil {
+		return "", err
+	}
+	return c.token, nil
+}
+
+// login obtains a token with the auth method of the client
+func (c *Client) login() error {
+	var data map[string]interface{}
+	switch c.options.Auth {
+	case AuthToken:
+		r, err := c.do(http.MethodGet, "auth/token/lookup-self", c.options.Token, nil)
+		if err != nil {
+			return fmt.Errorf("looking up the Vault token: %w", err)
+		}
+		ttl, _ := r.Data["ttl"].(float64)
+		renewable, _ := r.Data["renewable"].(bool)
+		c.setToken(c.options.Token, int(ttl), renewable)
+		return nil
+	case AuthAppRole:
+		data = map[string]interface{}{"role_id": c.options.RoleID, "secret_id": c.options.SecretID}
+	case AuthKubernetes:
+		jwt, err := os.ReadFile(c.options.KubernetesTokenFile)
+		if err != nil {
+			return err
+		}
+		data = map[string]interface{}{"role": c.options.Role, "jwt": strings.TrimSpace(string(jwt))}
+	}
+	r, err := c.do(http.MethodPut, "auth/"+c.options.AuthMount+"/login", "", data)
+	if err != nil {
+		return fmt.Errorf("logging in to Vault with %s: %w", c.options.Auth, err)
+	}
+	if r == nil || r.Auth == nil || r.Auth.ClientToken == "" {
+		return fmt.Errorf("logging in to Vault with %s: no token", c.options.Auth)
+	}
+	c.setToken(r.Auth.ClientToken, r.Auth.LeaseDuration, r.Auth.Renewable)
+	return nil
+}
+
+// setToken sets the token of the client, renewed after two thirds of its ttl
+func (c *Client) setToken(token string, ttl int, renewable bool) {
+	c.token, c.renewable = token, renewable
+	c.renew = time.Time{}
+	if ttl > 0 {
+		c.renew = time.Now().Add(time.Duration(ttl) * time.Second * 2 / 3)
+	}
+}
+
+// do sends a request to the Vault HTTP API, the response being nil for 204 and 404
+func (c *Client) do(method string, path string, token string, data map[string]interface{}) (*response, error) {
+	var body io.Reader
+	if data != nil {
+		content, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(content)
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(c.options.Address, "/")+"/v1/"+strings.TrimPrefix(path, "/"), body)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		request.Header.Set("X-Vault-Token", token)
+	}
+	if c.options.Namespace != "" {
+		request.Header.Set("X-Vault-Namespace", c.options.Namespace)
+	}
+	if data != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	httpResponse, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode == http.StatusNoContent || httpResponse.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	var r response
+	if err := json.NewDecoder(io.LimitReader(httpResponse.Body, 1<<20)).Decode(&r); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s %s: %s", method, path, httpResponse.Status)
+	}
+	if httpResponse.StatusCode >= 300 {
+		if len(r.Errors) > 0 {
+			return nil, fmt.Errorf("%s %s: %s: %s", method, path, httpResponse.Status, strings.Join(r.Errors, ", "))
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, httpResponse.Status)
+	}
+	return &r, nil
+}
+
+// String returns the string field of the secret, empty if missing
+func (s *Secret) String(field string) string {
+	value, _ := s.Data[field].(string)
+	return value
+}
+
+// Expires returns when the secret expires: the end of its lease, or of the validity given by
+// its fields for the secrets without lease such as the certificates of the PKI secrets engine
+// and the OAuth tokens of the GCP secrets engine. It is zero for secrets that do not expire
+func (s *Secret) Expires() time.Time {
+	if s.LeaseDuration > 0 {
+		return s.Fetched.Add(s.LeaseDuration)
+	}
+	for _, field := range []string{"expiration", "expires_at_seconds"} {
+		switch value := s.Data[field].(type) {
+		case float64:
+			return time.Unix(int64(value), 0)
+		case json.Number:
+			if seconds, err := value.Int64(); err == nil {
+				return time.Unix(seconds, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,244 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	pathutil "path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type VaultTestSuite struct {
+	suite.Suite
+	Vault    *httptest.Server
+	Requests map[string]int
+	// Responses are the responses of the fake Vault, by method and path
+	Responses map[string]interface{}
+}
+
+func (suite *VaultTestSuite) SetupTest() {
+	suite.Requests = map[string]int{}
+	suite.Responses = map[string]interface{}{
+		"PUT /v1/auth/approle/login": map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": "approle-token", "lease_duration": 3600, "renewable": true},
+		},
+		"GET /v1/auth/token/lookup-self": map[string]interface{}{
+			"data": map[string]interface{}{"ttl": 0, "renewable": false},
+		},
+		"GET /v1/secret/data/chartmuseum": map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"account": "charts", "access_key": "key"},
+				"metadata": map[string]interface{}{"version": 2},
+			},
+		},
+		"GET /v1/aws/creds/chartmuseum": map[string]interface{}{
+			"lease_id": "aws/creds/chartmuseum/1", "lease_duration": 3, "renewable": true,
+			"data": map[string]interface{}{"access_key": "AKIA1", "secret_key": "secret1"},
+		},
+	}
+	suite.Vault = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+		suite.Requests[key]++
+		if !strings.HasSuffix(r.URL.Path, "/login") && r.Header.Get("X-Vault-Token") == "" {
+			w.WriteHeader(403)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		response, found := suite.Responses[key]
+		if !found {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func (suite *VaultTestSuite) TearDownTest() {
+	suite.Vault.Close()
+}
+
+func (suite *VaultTestSuite) TestNewClient() {
+	_, err := NewClient(Options{})
+	suite.EqualError(err, "missing Vault address")
+	_, err = NewClient(Options{Address: suite.Vault.URL})
+	suite.EqualError(err, "the token auth of Vault requires a token")
+	_, err = NewClient(Options{Address: suite.Vault.URL, Auth: "userpass"})
+	suite.EqualError(err, "unsupported Vault auth method: userpass")
+
+	client, err := NewClient(Options{Address: suite.Vault.URL, Auth: AuthAppRole, RoleID: "role", SecretID: "secret"})
+	suite.Nil(err)
+	suite.Equal("approle-token", client.token)
+	suite.True(client.renewable)
+
+	suite.Responses["PUT /v1/auth/kubernetes/login"] = map[string]interface{}{
+		"auth": map[string]interface{}{"client_token": "kubernetes-token", "lease_duration": 3600},
+	}
+	tokenFile := pathutil.Join(suite.T().TempDir(), "token")
+	suite.Nil(os.WriteFile(tokenFile, []byte("service-account-jwt\n"), 0600))
+	client, err = NewClient(Options{Address: suite.Vault.URL, Auth: AuthKubernetes, Role: "chartmuseum", KubernetesTokenFile: tokenFile})
+	suite.Nil(err)
+	suite.Equal("kubernetes-token", client.token)
+
+	delete(suite.Responses, "PUT /v1/auth/approle/login")
+	_, err = NewClient(Options{Address: suite.Vault.URL, Auth: AuthAppRole, RoleID: "role"})
+	suite.ErrorContains(err, "logging in to Vault with approle")
+}
+
+func (suite *VaultTestSuite) TestRead() {
+	client, err := NewClient(Options{Address: suite.Vault.URL, Token: "token"})
+	suite.Nil(err)
+
+	secret, err := client.Read("secret/data/chartmuseum")
+	suite.Nil(err)
+	suite.Equal("charts", secret.String("account"), "fields of the current version of a KV version 2 secret")
+	suite.True(secret.Expires().IsZero())
+
+	secret, err = client.Read("aws/creds/chartmuseum")
+	suite.Nil(err)
+	suite.Equal("AKIA1", secret.String("access_key"))
+	suite.Equal(3*time.Second, secret.LeaseDuration)
+	suite.WithinDuration(time.Now().Add(3*time.Second), secret.Expires(), time.Second)
+
+	_, err = client.Read("secret/data/missing")
+	suite.EqualError(err, "no Vault secret at secret/data/missing")
+}
+
+func (suite *VaultTestSuite) TestTokenRenewal() {
+	client, err := NewClient(Options{Address: suite.Vault.URL, Auth: AuthAppRole, RoleID: "role"})
+	suite.Nil(err)
+	suite.Responses["PUT /v1/auth/token/renew-self"] = map[string]interface{}{
+		"auth": map[string]interface{}{"client_token": "approle-token", "lease_duration": 3600, "renewable": true},
+	}
+	client.renew = time.Now().Add(-time.Second)
+	_, err = client.Read("secret/data/chartmuseum")
+	suite.Nil(err)
+	suite.Equal(1, suite.Requests["PUT /v1/auth/token/renew-self"], "token renewed when due")
+	suite.Equal(1, suite.Requests["PUT /v1/auth/approle/login"])
+
+	delete(suite.Responses, "PUT /v1/auth/token/renew-self")
+	client.renew = time.Now().Add(-time.Second)
+	_, err = client.Read("secret/data/chartmuseum")
+	suite.Nil(err)
+	suite.Equal(2, suite.Requests["PUT /v1/auth/approle/login"], "logged in again when the token cannot be renewed")
+}
+
+func (suite *VaultTestSuite) TestLease() {
+	client, err := NewClient(Options{Address: suite.Vault.URL, Token: "token"})
+	suite.Nil(err)
+	lease := client.Lease("aws/creds/chartmuseum", nil)
+
+	secret, err := lease.Secret()
+	suite.Nil(err)
+	suite.Equal("AKIA1", secret.String("access_key"))
+	cached, _ := lease.Secret()
+	suite.Same(secret, cached, "secret cached until two thirds of its lease")
+	suite.WithinDuration(time.Now().Add(2*time.Second), lease.Expires(), 500*time.Millisecond)
+
+	suite.Responses["PUT /v1/sys/leases/renew"] = map[string]interface{}{
+		"lease_id": "aws/creds/chartmuseum/1", "lease_duration": 3, "renewable": true,
+	}
+	lease.refresh = time.Now()
+	renewed, err := lease.Secret()
+	suite.Nil(err)
+	suite.Equal(1, suite.Requests["PUT /v1/sys/leases/renew"])
+	suite.Equal("AKIA1", renewed.String("access_key"), "fields kept by the renewal")
+	suite.Equal(1, suite.Requests["GET /v1/aws/creds/chartmuseum"])
+
+	suite.Responses["PUT /v1/sys/leases/renew"] = map[string]interface{}{
+		"lease_id": "aws/creds/chartmuseum/1", "lease_duration": 1, "renewable": true,
+	}
+	suite.Responses["GET /v1/aws/creds/chartmuseum"] = map[string]interface{}{
+		"lease_id": "aws/creds/chartmuseum/2", "lease_duration": 3, "renewable": true,
+		"data": map[string]interface{}{"access_key": "AKIA2", "secret_key": "secret2"},
+	}
+	lease.refresh = time.Now()
+	secret, err = lease.Secret()
+	suite.Nil(err)
+	suite.Equal("AKIA2", secret.String("access_key"), "new secret when the lease reaches its max TTL")
+
+	delete(suite.Responses, "PUT /v1/sys/leases/renew")
+	delete(suite.Responses, "GET /v1/aws/creds/chartmuseum")
+	lease.refresh = time.Now()
+	cached, err = lease.Secret()
+	suite.Nil(err)
+	suite.Same(secret, cached, "secret kept until it expires when it cannot be refreshed")
+	lease.secret.Fetched = time.Now().Add(-time.Minute)
+	lease.refresh = time.Now()
+	_, err = lease.Secret()
+	suite.NotNil(err, "expired secret")
+
+	lease = client.Lease("secret/data/chartmuseum", nil)
+	lease.RefreshInterval = time.Hour
+	_, err = lease.Secret()
+	suite.Nil(err)
+	suite.WithinDuration(time.Now().Add(time.Hour), lease.Expires(), time.Second, "KV secret read again after the refresh interval")
+}
+
+func (suite *VaultTestSuite) TestCertificateSource() {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Nil(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "charts.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	suite.Nil(err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	suite.Nil(err)
+	suite.Responses["PUT /v1/pki/issue/chartmuseum"] = map[string]interface{}{
+		"data": map[string]interface{}{
+			"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+			"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+			"expiration":  template.NotAfter.Unix(),
+		},
+	}
+	client, err := NewClient(Options{Address: suite.Vault.URL, Token: "token"})
+	suite.Nil(err)
+
+	source, err := NewCertificateSource(client.Lease("pki/issue/chartmuseum", map[string]interface{}{"common_name": "charts.example.com"}))
+	suite.Nil(err)
+	certificate, err := source.GetCertificate(nil)
+	suite.Nil(err)
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	suite.Nil(err)
+	suite.Equal("charts.example.com", leaf.Subject.CommonName)
+	suite.Equal(1, suite.Requests["PUT /v1/pki/issue/chartmuseum"], "certificate issued once until two thirds of its validity")
+
+	_, err = NewCertificateSource(client.Lease("secret/data/chartmuseum", nil))
+	suite.EqualError(err, "the Vault secret of the TLS certificate has no certificate or private_key")
+}
+
+func TestVaultTestSuite(t *testing.T) {
+	suite.Run(t, new(VaultTestSuite))
+}